@@ -0,0 +1,134 @@
+package runbox
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const accessLogTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// accessLogWriter writes one line per request. A file is reopened on
+// SIGHUP so logrotate can move it away.
+type accessLogWriter struct {
+	mu       sync.Mutex
+	path     string
+	out      io.Writer
+	file     *os.File
+	combined bool
+	hup      chan os.Signal
+}
+
+func newAccessLogWriter(config AccessLogConfig) (*accessLogWriter, error) {
+	w := &accessLogWriter{path: config.Path}
+	switch config.Format {
+	case "", "combined":
+		w.combined = true
+	case "common":
+	default:
+		return nil, fmt.Errorf("unknown access log format %q (want combined or common)", config.Format)
+	}
+
+	if config.Path == "stdout" || config.Path == "-" {
+		w.out = os.Stdout
+		return w, nil
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+
+	w.hup = make(chan os.Signal, 1)
+	signal.Notify(w.hup, syscall.SIGHUP)
+	go func() {
+		for range w.hup {
+			if err := w.open(); err != nil {
+				log.Println("Failed to reopen access log:", err)
+			}
+		}
+	}()
+	return w, nil
+}
+
+// close stops reopening the file on SIGHUP and closes it.
+func (w *accessLogWriter) close() {
+	if w.hup == nil {
+		return
+	}
+	signal.Stop(w.hup)
+	close(w.hup)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file != nil {
+		w.file.Close()
+		w.file, w.out = nil, io.Discard
+	}
+}
+
+func (w *accessLogWriter) open() error {
+	file, err := os.OpenFile(w.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	old := w.file
+	w.file, w.out = file, file
+	w.mu.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// middleware logs requests in Apache common or combined format:
+// host ident user [time] "request" status bytes "referer" "user-agent".
+func (w *accessLogWriter) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		started := time.Now()
+		c.Next()
+
+		user, _, _ := c.Request.BasicAuth()
+		size := "-"
+		if n := c.Writer.Size(); n > 0 {
+			size = strconv.Itoa(n)
+		}
+		line := fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s",
+			c.ClientIP(), orDash(user), started.Format(accessLogTimeFormat),
+			c.Request.Method, c.Request.URL.RequestURI(), c.Request.Proto, c.Writer.Status(), size)
+		if w.combined {
+			line += fmt.Sprintf(" %q %q", orDash(c.Request.Referer()), orDash(c.Request.UserAgent()))
+		}
+
+		w.mu.Lock()
+		if _, err := io.WriteString(w.out, line+"\n"); err != nil {
+			log.Println("Failed to write access log:", err)
+		}
+		w.mu.Unlock()
+	}
+}
+
+// executeRoutes returns the handlers for the execute routes, behind CORS,
+// the maintenance switch, the rate limit and the access log when one is
+// configured.
+func (app *App) executeRoutes(handler gin.HandlerFunc) []gin.HandlerFunc {
+	handlers := []gin.HandlerFunc{app.corsMiddleware(), app.maintenanceMiddleware(), app.rateLimitMiddleware(), handler}
+	if app.accessLog == nil {
+		return handlers
+	}
+	return append([]gin.HandlerFunc{app.accessLog.middleware()}, handlers...)
+}
@@ -0,0 +1,215 @@
+package runbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/robertkrimen/otto"
+)
+
+const (
+	defaultAIBaseURL      = "https://api.openai.com/v1"
+	defaultAIAPIKeySecret = "OPENAI_API_KEY"
+	defaultAITimeout      = 60 * time.Second
+)
+
+var aiClient = &http.Client{}
+
+// aiMessage is a chat message in the OpenAI format.
+type aiMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// aiCompletion is what runbox.ai.complete answers.
+type aiCompletion struct {
+	Text         string `json:"text"`
+	Model        string `json:"model"`
+	FinishReason string `json:"finishReason"`
+	Usage        struct {
+		PromptTokens     int64 `json:"promptTokens"`
+		CompletionTokens int64 `json:"completionTokens"`
+		TotalTokens      int64 `json:"totalTokens"`
+	} `json:"usage"`
+}
+
+// aiBudget answers how many tokens the function has left this month, or
+// -1 without a budget.
+func (app *App) aiBudget(functionID int) (int64, error) {
+	q := app.quotaFor(functionID)
+	if q == nil || q.MonthlyAITokens <= 0 {
+		return -1, nil
+	}
+	u, err := app.usageSince(functionID, monthStart(time.Now().UTC()))
+	if err != nil {
+		return 0, err
+	}
+	if u.AITokens >= q.MonthlyAITokens {
+		return 0, fmt.Errorf("the monthly AI token budget of %d is used up", q.MonthlyAITokens)
+	}
+	return q.MonthlyAITokens - u.AITokens, nil
+}
+
+// complete calls the chat completions endpoint and records the tokens the
+// answer reports against the function.
+func (app *App) complete(ctx context.Context, function *Function, request map[string]interface{}) (*aiCompletion, error) {
+	config := app.config.AI
+	baseURL := strings.TrimSuffix(config.BaseURL, "/")
+	if baseURL == "" {
+		baseURL = defaultAIBaseURL
+	}
+	secret := config.APIKeySecret
+	if secret == "" {
+		secret = defaultAIAPIKeySecret
+	}
+	key, ok, err := app.secretValue(secret)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("set the API key as the secret %s", secret)
+	}
+	if request["model"] == nil || request["model"] == "" {
+		if config.Model == "" {
+			return nil, errors.New("model is required, as ai.model isn't configured")
+		}
+		request["model"] = config.Model
+	}
+
+	remaining, err := app.aiBudget(function.ID)
+	if err != nil {
+		return nil, err
+	}
+	// The answer can't use more of the budget than is left, though the
+	// prompt still can overshoot it once.
+	if remaining > 0 {
+		if max, ok := request["max_tokens"].(int64); !ok || max > remaining {
+			request["max_tokens"] = remaining
+		}
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, errors.New("the request must be JSON-serializable")
+	}
+	timeout := defaultAITimeout
+	if config.TimeoutSeconds > 0 {
+		timeout = time.Duration(config.TimeoutSeconds) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(key))
+	resp, err := aiClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		Model   string `json:"model"`
+		Choices []struct {
+			Message      aiMessage `json:"message"`
+			FinishReason string    `json:"finish_reason"`
+		} `json:"choices"`
+		Usage struct {
+			PromptTokens     int64 `json:"prompt_tokens"`
+			CompletionTokens int64 `json:"completion_tokens"`
+			TotalTokens      int64 `json:"total_tokens"`
+		} `json:"usage"`
+		Error *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, fmt.Errorf("the API answered %d with something other than JSON", resp.StatusCode)
+	}
+	if out.Usage.TotalTokens > 0 {
+		app.recordAITokens(function.ID, out.Usage.TotalTokens)
+	}
+	if out.Error != nil {
+		return nil, fmt.Errorf("the API answered %d: %s", resp.StatusCode, out.Error.Message)
+	}
+	if resp.StatusCode >= 300 || len(out.Choices) == 0 {
+		return nil, fmt.Errorf("the API answered %d without a completion", resp.StatusCode)
+	}
+
+	c := &aiCompletion{Text: out.Choices[0].Message.Content, Model: out.Model, FinishReason: out.Choices[0].FinishReason}
+	c.Usage.PromptTokens = out.Usage.PromptTokens
+	c.Usage.CompletionTokens = out.Usage.CompletionTokens
+	c.Usage.TotalTokens = out.Usage.TotalTokens
+	return c, nil
+}
+
+// jsAI exposes runbox.ai. complete takes {model, prompt} or {model,
+// messages}, with optional system, maxTokens and temperature, and sends
+// them to the configured API as a chat completion.
+func (app *App) jsAI(vm *otto.Otto, exec *execution) *otto.Object {
+	ai, _ := vm.Object(`({})`)
+
+	ai.Set("complete", func(call otto.FunctionCall) otto.Value {
+		if exec.function.ID == 0 {
+			throwError(call, "runbox.ai.complete: save the function before using runbox.ai, so its tokens are counted")
+		}
+		if !call.Argument(0).IsObject() {
+			throwError(call, "runbox.ai.complete: expected an options object")
+		}
+		exported, _ := call.Argument(0).Export()
+		opts, _ := exported.(map[string]interface{})
+
+		var messages []aiMessage
+		if system, ok := opts["system"].(string); ok && system != "" {
+			messages = append(messages, aiMessage{Role: "system", Content: system})
+		}
+		if list, ok := opts["messages"]; ok {
+			encoded, _ := json.Marshal(list)
+			var given []aiMessage
+			if err := json.Unmarshal(encoded, &given); err != nil || len(given) == 0 {
+				throwError(call, "runbox.ai.complete: messages must be a list of {role, content}")
+			}
+			messages = append(messages, given...)
+		} else if prompt, ok := opts["prompt"].(string); ok && prompt != "" {
+			messages = append(messages, aiMessage{Role: "user", Content: prompt})
+		} else {
+			throwError(call, "runbox.ai.complete: prompt or messages is required")
+		}
+
+		request := map[string]interface{}{"model": opts["model"], "messages": messages}
+		if arg, _ := call.Argument(0).Object().Get("maxTokens"); arg.IsDefined() {
+			n, err := arg.ToInteger()
+			if err != nil || n <= 0 {
+				throwError(call, "runbox.ai.complete: maxTokens must be a positive integer")
+			}
+			request["max_tokens"] = n
+		}
+		if t, ok := opts["temperature"]; ok {
+			request["temperature"] = t
+		}
+
+		completion, err := app.complete(exec.ctx, exec.function, request)
+		if err != nil {
+			throwError(call, "runbox.ai.complete: "+err.Error())
+		}
+		encoded, _ := json.Marshal(completion)
+		var result map[string]interface{}
+		json.Unmarshal(encoded, &result)
+		return toValue(call, result)
+	})
+
+	return ai
+}
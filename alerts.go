@@ -0,0 +1,375 @@
+package runbox
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const alertEvaluationInterval = 15 * time.Second
+
+// AlertRule fires when a function fails more than Threshold times within
+// Window, optionally counting only one error group. It notifies a webhook
+// URL, email recipients and every webhook subscribed to alert.triggered,
+// then stays quiet for one window.
+type AlertRule struct {
+	ID          int        `json:"id"`
+	FunctionID  int        `json:"functionId"`
+	Name        string     `json:"name"`
+	Threshold   int        `json:"threshold"`
+	Window      string     `json:"window"`
+	Fingerprint string     `json:"fingerprint,omitempty"`
+	WebhookURL  string     `json:"webhookUrl,omitempty"`
+	Secret      string     `json:"-"`
+	HasSecret   bool       `json:"hasSecret"`
+	Email       []string   `json:"email"`
+	Enabled     bool       `json:"enabled"`
+	LastFiredAt *time.Time `json:"lastFiredAt,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+}
+
+func (app *App) initAlertRulesTable() error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS alert_rules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		function_id INTEGER NOT NULL REFERENCES functions(id) ON DELETE CASCADE,
+		name TEXT NOT NULL DEFAULT '',
+		threshold INTEGER NOT NULL,
+		time_window TEXT NOT NULL,
+		fingerprint TEXT NOT NULL DEFAULT '',
+		webhook_url TEXT NOT NULL DEFAULT '',
+		secret TEXT NOT NULL DEFAULT '',
+		email TEXT NOT NULL DEFAULT '',
+		enabled INTEGER NOT NULL DEFAULT 1,
+		last_fired_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	if _, err := app.db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create alert_rules table: %v", err)
+	}
+	return nil
+}
+
+const alertRuleColumns = `id, function_id, name, threshold, time_window, fingerprint, webhook_url, secret, email, enabled, last_fired_at, created_at`
+
+func scanAlertRule(row rowScanner) (*AlertRule, error) {
+	var (
+		r         AlertRule
+		email     string
+		lastFired sql.NullTime
+	)
+	err := row.Scan(&r.ID, &r.FunctionID, &r.Name, &r.Threshold, &r.Window, &r.Fingerprint, &r.WebhookURL,
+		&r.Secret, &email, &r.Enabled, &lastFired, &r.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	r.HasSecret = r.Secret != ""
+	r.Email = []string{}
+	if email != "" {
+		r.Email = strings.Split(email, ",")
+	}
+	if lastFired.Valid {
+		r.LastFiredAt = &lastFired.Time
+	}
+	return &r, nil
+}
+
+func (app *App) getAlertRule(id int) (*AlertRule, error) {
+	return scanAlertRule(app.db.QueryRow(`SELECT `+alertRuleColumns+` FROM alert_rules WHERE id = ?`, id))
+}
+
+// evaluateAlertRules checks every enabled rule against the execution log.
+// It runs on the leader, every alertEvaluationInterval.
+func (app *App) evaluateAlertRules(now time.Time) {
+	if !app.periodic.due("alert-rules", now, alertEvaluationInterval) {
+		return
+	}
+
+	rows, err := app.db.Query(`SELECT ` + alertRuleColumns + ` FROM alert_rules WHERE enabled = 1`)
+	if err != nil {
+		log.Println("Failed to load alert rules:", err)
+		return
+	}
+	var rules []AlertRule
+	for rows.Next() {
+		if r, err := scanAlertRule(rows); err == nil {
+			rules = append(rules, *r)
+		}
+	}
+	rows.Close()
+
+	for i := range rules {
+		r := &rules[i]
+		window, err := parseWindow(r.Window)
+		if err != nil {
+			continue
+		}
+		if r.LastFiredAt != nil && now.Sub(*r.LastFiredAt) < window {
+			continue
+		}
+
+		query := `SELECT COUNT(*) FROM execution_logs WHERE function_id = ? AND status = ? AND created_at >= ?`
+		args := []interface{}{r.FunctionID, JobFailed, now.Add(-window)}
+		if r.Fingerprint != "" {
+			query += ` AND fingerprint = ?`
+			args = append(args, r.Fingerprint)
+		}
+		var count int
+		if err := app.db.QueryRow(query, args...).Scan(&count); err != nil || count <= r.Threshold {
+			continue
+		}
+
+		app.db.Exec(`UPDATE alert_rules SET last_fired_at = ? WHERE id = ?`, now, r.ID)
+		app.goBackground(func() { app.fireAlert(r, count, now.Add(-window)) })
+	}
+}
+
+// fireAlert notifies the rule's targets with the failure count and the
+// error groups seen during the window.
+func (app *App) fireAlert(r *AlertRule, count int, since time.Time) {
+	function, err := app.getFunctionByID(r.FunctionID)
+	if err != nil {
+		return
+	}
+
+	groups := []ErrorGroup{}
+	query := `SELECT ` + errorGroupColumns + ` FROM error_groups WHERE function_id = ? AND last_seen >= ?`
+	args := []interface{}{r.FunctionID, since}
+	if r.Fingerprint != "" {
+		query += ` AND fingerprint = ?`
+		args = append(args, r.Fingerprint)
+	}
+	if rows, err := app.db.Query(query+` ORDER BY last_seen DESC LIMIT 10`, args...); err == nil {
+		for rows.Next() {
+			if g, err := scanErrorGroup(rows); err == nil {
+				groups = append(groups, *g)
+			}
+		}
+		rows.Close()
+	}
+
+	data := map[string]interface{}{
+		"rule":     gin.H{"id": r.ID, "name": r.Name, "threshold": r.Threshold, "window": r.Window},
+		"function": functionSummary(function),
+		"errors":   count,
+		"since":    since.Format(time.RFC3339),
+		"groups":   groups,
+	}
+
+	name := r.Name
+	if name == "" {
+		name = fmt.Sprintf("more than %d errors in %s", r.Threshold, r.Window)
+	}
+	var text strings.Builder
+	fmt.Fprintf(&text, "%s failed %d times in the last %s (alert: %s).\n", function.Path, count, r.Window, name)
+	for _, g := range groups {
+		fmt.Fprintf(&text, "\n%dx %s", g.Count, g.Message)
+		if g.Location != "" {
+			fmt.Fprintf(&text, "\n    at %s", g.Location)
+		}
+		text.WriteString("\n")
+	}
+
+	app.notify(notifyTargets{WebhookURL: r.WebhookURL, Secret: r.Secret, Email: r.Email},
+		WebhookAlertTriggered, data, fmt.Sprintf("[RunBox] %s: %s", function.Path, name), text.String())
+}
+
+// notifyTargets are where alert-style notifications go besides the
+// webhooks subscribed to their event.
+type notifyTargets struct {
+	WebhookURL string
+	Secret     string
+	Email      []string
+}
+
+func (t notifyTargets) validate() string {
+	if t.WebhookURL != "" {
+		if u, err := url.Parse(t.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return "webhookUrl must be an http(s) URL"
+		}
+	}
+	for _, address := range t.Email {
+		if _, err := mail.ParseAddress(address); err != nil || strings.Contains(address, ",") {
+			return fmt.Sprintf("invalid email address %q", address)
+		}
+	}
+	return ""
+}
+
+// notify posts event to the target URL and to subscribed webhooks, and
+// emails subject and text to the target recipients.
+func (app *App) notify(t notifyTargets, event string, data interface{}, subject, text string) {
+	if t.WebhookURL != "" {
+		app.deliverWebhook(&Webhook{URL: t.WebhookURL, Secret: t.Secret}, event, data)
+	}
+	if len(t.Email) > 0 {
+		if err := app.emailNotification(t.Email, subject, text); err != nil {
+			log.Printf("Failed to email %s notification: %v", event, err)
+		}
+	}
+	app.fireWebhook(event, data)
+}
+
+func (app *App) emailNotification(to []string, subject, text string) error {
+	if app.config.Email.Provider == "" || app.config.Email.From == "" {
+		return errors.New("email is not configured")
+	}
+	m := &emailMessage{From: app.config.Email.From, To: to, Subject: subject, Text: text}
+	return app.deliverEmail(m, newID(), "RunBox")
+}
+
+type alertRuleInput struct {
+	Name        string   `json:"name"`
+	Threshold   int      `json:"threshold"`
+	Window      string   `json:"window"`
+	Fingerprint string   `json:"fingerprint"`
+	WebhookURL  string   `json:"webhookUrl"`
+	Secret      *string  `json:"secret"`
+	Email       []string `json:"email"`
+	Enabled     *bool    `json:"enabled"`
+}
+
+func (in *alertRuleInput) validate() string {
+	if in.Threshold < 0 {
+		return "threshold must not be negative"
+	}
+	if in.Window == "" {
+		in.Window = "5m"
+	}
+	if _, err := parseWindow(in.Window); err != nil {
+		return err.Error()
+	}
+	return notifyTargets{WebhookURL: in.WebhookURL, Email: in.Email}.validate()
+}
+
+func (app *App) listAlertRules(c *gin.Context) {
+	functionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+
+	rows, err := app.db.Query(`SELECT `+alertRuleColumns+` FROM alert_rules WHERE function_id = ? ORDER BY id`, functionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list alert rules"})
+		return
+	}
+	defer rows.Close()
+
+	rules := []AlertRule{}
+	for rows.Next() {
+		r, err := scanAlertRule(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list alert rules"})
+			return
+		}
+		rules = append(rules, *r)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"rules": rules})
+}
+
+func (app *App) createAlertRule(c *gin.Context) {
+	functionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+	if _, err := app.getFunctionByID(functionID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Function not found"})
+		return
+	}
+
+	var in alertRuleInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid alert rule body"})
+		return
+	}
+	if msg := in.validate(); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	secret := ""
+	if in.Secret != nil {
+		secret = *in.Secret
+	}
+	enabled := in.Enabled == nil || *in.Enabled
+
+	res, err := app.db.Exec(`INSERT INTO alert_rules (function_id, name, threshold, time_window, fingerprint, webhook_url, secret, email, enabled)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		functionID, in.Name, in.Threshold, in.Window, in.Fingerprint, in.WebhookURL, secret, strings.Join(in.Email, ","), enabled)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create alert rule: " + err.Error()})
+		return
+	}
+
+	id, _ := res.LastInsertId()
+	r, _ := app.getAlertRule(int(id))
+	c.JSON(http.StatusCreated, r)
+}
+
+func (app *App) updateAlertRule(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid alert rule ID"})
+		return
+	}
+	existing, err := app.getAlertRule(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alert rule not found"})
+		return
+	}
+
+	var in alertRuleInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid alert rule body"})
+		return
+	}
+	if msg := in.validate(); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	secret := keptSecret(existing.Secret, in.Secret)
+	enabled := in.Enabled == nil || *in.Enabled
+
+	_, err = app.db.Exec(`UPDATE alert_rules SET name = ?, threshold = ?, time_window = ?, fingerprint = ?, webhook_url = ?, secret = ?, email = ?, enabled = ?
+		WHERE id = ?`, in.Name, in.Threshold, in.Window, in.Fingerprint, in.WebhookURL, secret, strings.Join(in.Email, ","), enabled, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update alert rule: " + err.Error()})
+		return
+	}
+
+	r, _ := app.getAlertRule(id)
+	c.JSON(http.StatusOK, r)
+}
+
+func (app *App) deleteAlertRule(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid alert rule ID"})
+		return
+	}
+
+	res, err := app.db.Exec(`DELETE FROM alert_rules WHERE id = ?`, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete alert rule"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alert rule not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Alert rule deleted"})
+}
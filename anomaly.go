@@ -0,0 +1,305 @@
+package runbox
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	TrafficNormal = "normal"
+	TrafficSpike  = "spike"
+	TrafficDrop   = "drop"
+
+	defaultTrafficWindow          = "5m"
+	defaultTrafficBaselineWindows = 12
+	defaultTrafficFactor          = 3.0
+	defaultTrafficMinRequests     = 20
+	trafficEvaluationEvery        = time.Minute
+)
+
+// TrafficAlert compares a function's requests in the last Window with the
+// average of the BaselineWindows before it. More than Factor times the
+// baseline is a spike, less than the baseline divided by Factor a drop;
+// either is notified once until traffic is back to normal. MinRequests
+// keeps quiet functions from alerting on noise.
+type TrafficAlert struct {
+	FunctionID      int        `json:"functionId"`
+	Window          string     `json:"window"`
+	BaselineWindows int        `json:"baselineWindows"`
+	Factor          float64    `json:"factor"`
+	MinRequests     int64      `json:"minRequests"`
+	WebhookURL      string     `json:"webhookUrl,omitempty"`
+	Secret          string     `json:"-"`
+	HasSecret       bool       `json:"hasSecret"`
+	Email           []string   `json:"email"`
+	State           string     `json:"state"`
+	LastAlertedAt   *time.Time `json:"lastAlertedAt,omitempty"`
+	CreatedAt       time.Time  `json:"createdAt"`
+}
+
+func (app *App) initTrafficAlertsTable() error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS traffic_alerts (
+		function_id INTEGER PRIMARY KEY REFERENCES functions(id) ON DELETE CASCADE,
+		time_window TEXT NOT NULL,
+		baseline_windows INTEGER NOT NULL,
+		factor REAL NOT NULL,
+		min_requests INTEGER NOT NULL,
+		webhook_url TEXT NOT NULL DEFAULT '',
+		secret TEXT NOT NULL DEFAULT '',
+		email TEXT NOT NULL DEFAULT '',
+		state TEXT NOT NULL DEFAULT 'normal',
+		last_alerted_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	if _, err := app.db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create traffic_alerts table: %v", err)
+	}
+	return nil
+}
+
+const trafficAlertColumns = `function_id, time_window, baseline_windows, factor, min_requests, webhook_url, secret, email, state, last_alerted_at, created_at`
+
+func scanTrafficAlert(row rowScanner) (*TrafficAlert, error) {
+	var (
+		a           TrafficAlert
+		email       string
+		lastAlerted sql.NullTime
+	)
+	err := row.Scan(&a.FunctionID, &a.Window, &a.BaselineWindows, &a.Factor, &a.MinRequests, &a.WebhookURL, &a.Secret,
+		&email, &a.State, &lastAlerted, &a.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	a.HasSecret = a.Secret != ""
+	a.Email = []string{}
+	if email != "" {
+		a.Email = strings.Split(email, ",")
+	}
+	if lastAlerted.Valid {
+		a.LastAlertedAt = &lastAlerted.Time
+	}
+	return &a, nil
+}
+
+func (app *App) getTrafficAlert(functionID int) (*TrafficAlert, error) {
+	return scanTrafficAlert(app.db.QueryRow(`SELECT `+trafficAlertColumns+` FROM traffic_alerts WHERE function_id = ?`, functionID))
+}
+
+// trafficCounts returns a function's requests since current, and the
+// average per window of the baseline windows before it.
+func (app *App) trafficCounts(functionID int, now time.Time, window time.Duration, baselineWindows int) (int64, float64, error) {
+	current := now.Add(-window)
+	var recent, before int64
+	err := app.db.QueryRow(`SELECT COALESCE(SUM(CASE WHEN bucket >= ? THEN invocations END), 0),
+			COALESCE(SUM(CASE WHEN bucket < ? THEN invocations END), 0)
+		FROM function_metrics WHERE function_id = ? AND bucket >= ?`,
+		current, current, functionID, current.Add(-window*time.Duration(baselineWindows))).Scan(&recent, &before)
+	if err != nil {
+		return 0, 0, err
+	}
+	recent += app.metrics.snapshot(functionID).Invocations
+	return recent, float64(before) / float64(baselineWindows), nil
+}
+
+// trafficState classifies the requests of a window against the baseline.
+func (a *TrafficAlert) trafficState(requests int64, baseline float64) string {
+	switch {
+	case requests >= a.MinRequests && float64(requests) > a.Factor*baseline:
+		return TrafficSpike
+	case baseline >= float64(a.MinRequests) && float64(requests) < baseline/a.Factor:
+		return TrafficDrop
+	}
+	return TrafficNormal
+}
+
+// evaluateTrafficAlerts runs on the leader, once a minute since that is how
+// often instances flush their metrics.
+func (app *App) evaluateTrafficAlerts(now time.Time) {
+	if !app.periodic.due("traffic-alerts", now, trafficEvaluationEvery) {
+		return
+	}
+
+	rows, err := app.db.Query(`SELECT ` + trafficAlertColumns + ` FROM traffic_alerts`)
+	if err != nil {
+		log.Println("Failed to load traffic alerts:", err)
+		return
+	}
+	var alerts []TrafficAlert
+	for rows.Next() {
+		if a, err := scanTrafficAlert(rows); err == nil {
+			alerts = append(alerts, *a)
+		}
+	}
+	rows.Close()
+
+	for i := range alerts {
+		a := &alerts[i]
+		window, err := parseWindow(a.Window)
+		if err != nil {
+			continue
+		}
+		requests, baseline, err := app.trafficCounts(a.FunctionID, now, window, a.BaselineWindows)
+		if err != nil {
+			continue
+		}
+
+		state := a.trafficState(requests, baseline)
+		if state == a.State {
+			continue
+		}
+		if state == TrafficNormal {
+			app.db.Exec(`UPDATE traffic_alerts SET state = ? WHERE function_id = ?`, state, a.FunctionID)
+			continue
+		}
+		app.db.Exec(`UPDATE traffic_alerts SET state = ?, last_alerted_at = ? WHERE function_id = ?`, state, now, a.FunctionID)
+		app.goBackground(func() { app.notifyTrafficAnomaly(a, state, requests, baseline) })
+	}
+}
+
+func (app *App) notifyTrafficAnomaly(a *TrafficAlert, state string, requests int64, baseline float64) {
+	function, err := app.getFunctionByID(a.FunctionID)
+	if err != nil {
+		return
+	}
+
+	data := map[string]interface{}{
+		"function": functionSummary(function),
+		"state":    state,
+		"window":   a.Window,
+		"requests": requests,
+		"baseline": baseline,
+		"factor":   a.Factor,
+	}
+	what := "spiked"
+	if state == TrafficDrop {
+		what = "dropped"
+	}
+	text := fmt.Sprintf("%s: traffic %s to %d requests in the last %s, against %.1f per %s over the %d windows before.\n",
+		function.Path, what, requests, a.Window, baseline, a.Window, a.BaselineWindows)
+
+	app.notify(notifyTargets{WebhookURL: a.WebhookURL, Secret: a.Secret, Email: a.Email},
+		WebhookTrafficAnomaly, data, fmt.Sprintf("[RunBox] %s: traffic %s", function.Path, what), text)
+}
+
+func (app *App) getTrafficAlertHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+
+	a, err := app.getTrafficAlert(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No traffic alert is set for this function"})
+		return
+	}
+
+	c.JSON(http.StatusOK, a)
+}
+
+func (app *App) setTrafficAlert(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+	if _, err := app.getFunctionByID(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Function not found"})
+		return
+	}
+
+	var in struct {
+		Window          string   `json:"window"`
+		BaselineWindows int      `json:"baselineWindows"`
+		Factor          float64  `json:"factor"`
+		MinRequests     int64    `json:"minRequests"`
+		WebhookURL      string   `json:"webhookUrl"`
+		Secret          *string  `json:"secret"`
+		Email           []string `json:"email"`
+	}
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid traffic alert body"})
+		return
+	}
+	if in.Window == "" {
+		in.Window = defaultTrafficWindow
+	}
+	if in.BaselineWindows == 0 {
+		in.BaselineWindows = defaultTrafficBaselineWindows
+	}
+	if in.Factor == 0 {
+		in.Factor = defaultTrafficFactor
+	}
+	if in.MinRequests == 0 {
+		in.MinRequests = defaultTrafficMinRequests
+	}
+	window, err := parseWindow(in.Window)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if window < metricsFlushInterval {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "window must be at least 1m"})
+		return
+	}
+	if in.BaselineWindows < 1 || window*time.Duration(in.BaselineWindows+1) > metricsRetention {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "baselineWindows must be at least 1 and the baseline must fit in the 30 days of kept metrics"})
+		return
+	}
+	if in.Factor <= 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "factor must be greater than 1"})
+		return
+	}
+	if in.MinRequests < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "minRequests must be at least 1"})
+		return
+	}
+	if msg := (notifyTargets{WebhookURL: in.WebhookURL, Email: in.Email}).validate(); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	current := ""
+	if existing, err := app.getTrafficAlert(id); err == nil {
+		current = existing.Secret
+	}
+	secret := keptSecret(current, in.Secret)
+
+	_, err = app.db.Exec(`INSERT INTO traffic_alerts (function_id, time_window, baseline_windows, factor, min_requests, webhook_url, secret, email)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (function_id) DO UPDATE SET time_window = excluded.time_window, baseline_windows = excluded.baseline_windows,
+			factor = excluded.factor, min_requests = excluded.min_requests, webhook_url = excluded.webhook_url,
+			secret = excluded.secret, email = excluded.email`,
+		id, in.Window, in.BaselineWindows, in.Factor, in.MinRequests, in.WebhookURL, secret, strings.Join(in.Email, ","))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save traffic alert: " + err.Error()})
+		return
+	}
+
+	a, _ := app.getTrafficAlert(id)
+	c.JSON(http.StatusOK, a)
+}
+
+func (app *App) deleteTrafficAlert(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+
+	if _, err := app.db.Exec(`DELETE FROM traffic_alerts WHERE function_id = ?`, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove traffic alert"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Traffic alert removed"})
+}
@@ -0,0 +1,230 @@
+package runbox
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	apiKeyPrefix         = "rbk_"
+	bootstrapTokenPrefix = "rbb_"
+	// keyUseInterval is how often last_used_at is written for a key in
+	// use, so authenticated requests don't each take a write lock.
+	keyUseInterval = time.Minute
+)
+
+// APIKey is a token kept in the database. Like auth.tokens it opens
+// private functions, and it is what manages API keys. Only a hash of the
+// token is stored; the token itself is answered once, on creation.
+type APIKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Token      string     `json:"token,omitempty"`
+	Hint       string     `json:"hint"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+func (app *App) initAPIKeysTable() error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		hint TEXT NOT NULL,
+		last_used_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS auth_bootstrap (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		token_hash TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	if _, err := app.db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create api keys table: %v", err)
+	}
+	return nil
+}
+
+func newToken(prefix string) string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return prefix + hex.EncodeToString(b)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (app *App) apiKeyCount() int {
+	n := 0
+	app.db.QueryRow(`SELECT COUNT(*) FROM api_keys`).Scan(&n)
+	return n
+}
+
+// bootstrapAuth makes the token that creates the first API key, the first
+// time the instance starts with neither API keys nor auth.tokens. It goes
+// to auth.bootstrapTokenFile when set and to the log otherwise. Until the
+// first key exists, nobody without it can create one. runbox dev on a
+// loopback address needs no token, so it has no use for one.
+func (app *App) bootstrapAuth() error {
+	if !app.authRequired() || len(app.apiTokens) > 0 || app.apiKeyCount() > 0 {
+		return nil
+	}
+
+	token := newToken(bootstrapTokenPrefix)
+	result, err := app.db.Exec(`INSERT OR IGNORE INTO auth_bootstrap (id, token_hash) VALUES (1, ?)`, hashToken(token))
+	if err != nil {
+		return fmt.Errorf("failed to store bootstrap token: %v", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		// Made on an earlier start, or by another instance of the cluster.
+		log.Println("No API keys yet; create the first one with the bootstrap token from the first start")
+		return nil
+	}
+
+	if file := app.config.Auth.BootstrapTokenFile; file != "" {
+		if err := os.WriteFile(file, []byte(token+"\n"), 0o600); err != nil {
+			return fmt.Errorf("failed to write bootstrap token: %v", err)
+		}
+		log.Printf("No API keys yet; the bootstrap token to create the first one is in %s", file)
+		return nil
+	}
+	log.Printf("No API keys yet; create the first one with POST /api/keys and Authorization: Bearer %s", token)
+	return nil
+}
+
+func bearerToken(c *gin.Context) (string, bool) {
+	return strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+}
+
+// validToken reports whether token is one of auth.tokens or an API key.
+func (app *App) validToken(token string) bool {
+	_, ok := app.tokenName(token)
+	return ok
+}
+
+// tokenName answers whose token it is: the name of its API key, or
+// "auth.tokens" for one of those. It notes when the key was last used, to
+// the minute.
+func (app *App) tokenName(token string) (string, bool) {
+	for _, t := range app.apiTokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			return "auth.tokens", true
+		}
+	}
+	if !strings.HasPrefix(token, apiKeyPrefix) {
+		return "", false
+	}
+	hash := hashToken(token)
+	var name string
+	if err := app.db.QueryRow(`SELECT name FROM api_keys WHERE token_hash = ?`, hash).Scan(&name); err != nil {
+		return "", false
+	}
+	app.noteKeyUse(hash, time.Now().UTC())
+	return name, true
+}
+
+// noteKeyUse writes last_used_at of the key with hash in the background,
+// unless this instance has written it less than keyUseInterval ago.
+func (app *App) noteKeyUse(hash string, now time.Time) {
+	if last, ok := app.keyUses.Load(hash); ok && now.Sub(last.(time.Time)) < keyUseInterval {
+		return
+	}
+	app.keyUses.Store(hash, now)
+	app.goBackground(func() {
+		if _, err := app.db.Exec(`UPDATE api_keys SET last_used_at = ? WHERE token_hash = ?`, now, hash); err != nil {
+			log.Println("Failed to note API key use:", err)
+		}
+	})
+}
+
+// validBootstrapToken reports whether token is the bootstrap token, which
+// only counts until the first API key exists.
+func (app *App) validBootstrapToken(token string) bool {
+	if app.apiKeyCount() > 0 {
+		return false
+	}
+	var hash string
+	err := app.db.QueryRow(`SELECT token_hash FROM auth_bootstrap WHERE id = 1`).Scan(&hash)
+	return err == nil && subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(hash)) == 1
+}
+
+func (app *App) listAPIKeysHandler(c *gin.Context) {
+	rows, err := app.db.Query(`SELECT id, name, hint, last_used_at, created_at FROM api_keys ORDER BY id`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		var k APIKey
+		var lastUsed sql.NullTime
+		if err := rows.Scan(&k.ID, &k.Name, &k.Hint, &lastUsed, &k.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if lastUsed.Valid {
+			k.LastUsedAt = &lastUsed.Time
+		}
+		keys = append(keys, k)
+	}
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
+
+// createAPIKey answers the new key with its token, which is not shown
+// again. The first key retires the bootstrap token.
+func (app *App) createAPIKey(c *gin.Context) {
+	var in struct {
+		Name string `json:"name"`
+	}
+	if err := c.ShouldBindJSON(&in); err != nil || strings.TrimSpace(in.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+
+	k := APIKey{Name: strings.TrimSpace(in.Name), Token: newToken(apiKeyPrefix), CreatedAt: time.Now().UTC()}
+	k.Hint = k.Token[:len(apiKeyPrefix)+4] + "…"
+	result, err := app.db.Exec(`INSERT INTO api_keys (name, token_hash, hint, created_at) VALUES (?, ?, ?, ?)`,
+		k.Name, hashToken(k.Token), k.Hint, k.CreatedAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key: " + err.Error()})
+		return
+	}
+	id, _ := result.LastInsertId()
+	k.ID = int(id)
+	app.db.Exec(`DELETE FROM auth_bootstrap`)
+
+	c.JSON(http.StatusCreated, k)
+}
+
+func (app *App) deleteAPIKey(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+
+	if _, err := app.db.Exec(`DELETE FROM api_keys WHERE id = ?`, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
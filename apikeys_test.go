@@ -0,0 +1,35 @@
+package runbox
+
+import "testing"
+
+func TestValidToken(t *testing.T) {
+	app := newTestServer(t, func(c *Config) { c.Auth.Tokens = []string{"configured-token"} }).app
+
+	key := newToken(apiKeyPrefix)
+	if _, err := app.db.Exec(`INSERT INTO api_keys (name, token_hash, hint) VALUES (?, ?, ?)`, "ci", hashToken(key), key[:8]); err != nil {
+		t.Fatal(err)
+	}
+	revoked := newToken(apiKeyPrefix)
+
+	tests := []struct {
+		name  string
+		token string
+		want  bool
+	}{
+		{"configured token", "configured-token", true},
+		{"api key", key, true},
+		{"empty", "", false},
+		{"prefix of a configured token", "configured", false},
+		{"configured token with more", "configured-token2", false},
+		{"unknown api key", revoked, false},
+		{"api key hash", hashToken(key), false},
+		{"bootstrap token", newToken(bootstrapTokenPrefix), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := app.validToken(tt.token); got != tt.want {
+				t.Errorf("validToken(%q) = %v, want %v", tt.token, got, tt.want)
+			}
+		})
+	}
+}
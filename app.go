@@ -0,0 +1,306 @@
+package runbox
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/gin-gonic/gin"
+	"github.com/graph-gophers/graphql-go"
+	"github.com/prodemmi/runbox/store"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type App struct {
+	// ctx is cancelled by Close, which waits for the background work
+	// started with goBackground.
+	ctx        context.Context
+	stop       context.CancelFunc
+	background sync.WaitGroup
+
+	config         Config
+	db             *sql.DB
+	store          *store.Store
+	graphqlSchema  *graphql.Schema
+	jobs           *jobQueue
+	triggers       *triggerManager
+	webhooks       *webhookDispatcher
+	leader         *leaderElector
+	scripts        *scriptCache
+	execLogs       chan *ExecutionLog
+	logExporters   []*logExporter
+	logStream      *logBroker
+	slos           *sloTargets
+	maintenance    *maintenanceCache
+	settings       atomic.Pointer[liveSettings]
+	rateLimits     *rateLimiter
+	captures       *captureCache
+	quotas         *quotaCache
+	metrics        *metricsRegistry
+	vmStats        *runtimeStats
+	accessLog      *accessLogWriter
+	health         *healthTracker
+	statsd         *statsdExporter
+	secrets        *secretBox
+	vault          *vaultClient
+	storage        *objectStorage
+	renders        *renderCache
+	protos         *protoCache
+	gqlSchemas     *gqlSchemaCache
+	externalDBs    map[string]*externalDB
+	grpcTargets    map[string]*grpcTarget
+	geoip          *geoIP
+	shareKey       []byte
+	trustedProxies []*net.IPNet
+	apiTokens      []string
+	tracer         trace.Tracer
+	sentry         *sentry.Hub
+	periodic       periodicTasks
+	refreshing     sync.Map
+	// runningWorkflows holds the cancel func of each run in flight here.
+	runningWorkflows sync.Map
+	// keyUses holds when last_used_at was last written for each API key.
+	keyUses sync.Map
+}
+
+func MethodOverride() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == "POST" {
+			if override := c.PostForm("_method"); override != "" {
+				c.Request.Method = override
+			}
+		}
+		c.Next()
+	}
+}
+
+// router registers the UI, the API and the execute routes.
+func (app *App) router() (*gin.Engine, error) {
+	r := gin.New()
+	r.Use(gin.LoggerWithConfig(gin.LoggerConfig{Skip: app.skipRequestLog}), gin.Recovery())
+	if err := configureClientIP(r, app.config); err != nil {
+		return nil, fmt.Errorf("invalid trustedProxies: %v", err)
+	}
+	trustedProxies, err := parseTrustedProxies(app.config.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trustedProxies: %v", err)
+	}
+	app.trustedProxies = trustedProxies
+
+	r.Use(app.tracingMiddleware())
+	r.Use(requestIDMiddleware())
+	r.Use(app.sentryMiddleware())
+	r.Use(MethodOverride())
+	r.Use(app.authMiddleware())
+	r.Use(app.readOnlyMiddleware())
+
+	app.loadTemplates(r)
+
+	r.StaticFS("/static", app.staticFiles())
+
+	r.GET("/login", app.loginPage)
+	r.POST("/login", app.login)
+	r.POST("/logout", app.logout)
+	r.GET("/api/auth", app.authStatus)
+
+	r.GET("/", app.homePage)
+	r.GET("/functions/create", app.newFunctionPage)
+	r.GET("/playground", app.playgroundPage)
+	r.GET("/functions/:id/edit", app.editFunctionPage)
+	r.GET("/functions/:id/logs", app.functionLogsPage)
+	r.POST("/api/functions", app.createFunction)
+	r.POST("/api/functions/validate", app.validateCodeHandler)
+	r.GET("/api/typings", app.typingsHandler)
+	r.GET("/api/reference", app.referenceHandler)
+	r.POST("/api/functions/dry-run", app.dryRunHandler)
+	r.POST("/api/functions/import", app.importURLHandler)
+	r.GET("/api/bundle", app.exportBundle)
+	r.POST("/api/bundle/preview", app.previewBundle)
+	r.POST("/api/bundle/import", app.importBundle)
+	r.POST("/api/apply", app.applyManifestHandler)
+	r.PUT("/api/functions/:id", app.updateFunction)
+	r.DELETE("/api/functions/:id", app.deleteFunction)
+
+	r.GET("/api/graphql", app.graphqlHandler)
+	r.POST("/api/graphql", app.graphqlHandler)
+
+	r.GET("/api/execute/*path", app.executeRoutes(app.executeFunction)...)
+	r.POST("/api/execute/*path", app.executeRoutes(app.executeFunction)...)
+	r.PUT("/api/execute/*path", app.executeRoutes(app.executeFunction)...)
+	r.PATCH("/api/execute/*path", app.executeRoutes(app.executeFunction)...)
+	r.DELETE("/api/execute/*path", app.executeRoutes(app.executeFunction)...)
+	r.HEAD("/api/execute/*path", app.executeRoutes(app.executeFunction)...)
+	r.OPTIONS("/api/execute/*path", app.executeRoutes(app.executeFunction)...)
+
+	r.POST("/api/execute-async/*path", app.executeRoutes(app.executeFunctionAsync)...)
+
+	r.POST("/api/delayed", app.createDelayedInvocation)
+
+	r.GET("/api/maintenance", app.getMaintenanceHandler)
+	r.PUT("/api/maintenance", app.setMaintenance)
+	r.POST("/api/config/reload", app.reloadConfigHandler)
+
+	r.GET("/api/jobs", app.listJobsHandler)
+	r.GET("/api/jobs/:id", app.getJobHandler)
+	r.POST("/api/jobs/:id/cancel", app.cancelJobHandler)
+
+	r.GET("/api/cluster", app.clusterStatus)
+	r.GET("/api/keys", app.listAPIKeysHandler)
+	r.POST("/api/keys", app.createAPIKey)
+	r.DELETE("/api/keys/:id", app.deleteAPIKey)
+	r.GET("/healthz", app.livenessHandler)
+	r.GET("/readyz", app.readinessHandler)
+
+	r.GET("/api/dead-letters", app.listDeadLettersHandler)
+	r.GET("/api/dead-letters/:id", app.getDeadLetterHandler)
+	r.POST("/api/dead-letters/:id/requeue", app.requeueDeadLetterHandler)
+	r.DELETE("/api/dead-letters/:id", app.deleteDeadLetter)
+	r.POST("/api/dead-letters/requeue", app.bulkRequeueDeadLetters)
+	r.POST("/api/dead-letters/discard", app.bulkDiscardDeadLetters)
+
+	r.GET("/api/functions/:id/schedules", app.listFunctionSchedules)
+	r.POST("/api/functions/:id/schedules", app.createSchedule)
+	r.PUT("/api/schedules/:id", app.updateSchedule)
+	r.DELETE("/api/schedules/:id", app.deleteSchedule)
+	r.GET("/api/schedules/:id/runs", app.listScheduleRuns)
+
+	r.GET("/api/functions/:id/saved-requests", app.listSavedRequests)
+	r.POST("/api/functions/:id/saved-requests", app.createSavedRequest)
+	r.POST("/api/functions/:id/saved-requests/import", app.importCurlHandler)
+	r.PUT("/api/saved-requests/:id", app.updateSavedRequest)
+	r.DELETE("/api/saved-requests/:id", app.deleteSavedRequest)
+
+	r.GET("/api/functions/:id/share-links", app.listShareLinks)
+	r.POST("/api/functions/:id/share-links", app.createShareLink)
+	r.DELETE("/api/share-links/:id", app.deleteShareLink)
+	r.GET("/share/:token", app.sharedFunctionPage)
+
+	r.GET("/api/functions/:id/subscriptions", app.listFunctionSubscriptions)
+	r.POST("/api/functions/:id/subscriptions", app.createSubscription)
+	r.DELETE("/api/subscriptions/:id", app.deleteSubscription)
+	r.GET("/api/topics", app.listTopics)
+	r.GET("/api/event-log", app.listEventLogHandler)
+	r.GET("/api/event-log/:id", app.getLoggedEventHandler)
+	r.POST("/api/event-log/replay", app.replayEventsHandler)
+	r.POST("/api/topics/:topic/publish", app.publishEventHandler)
+
+	r.GET("/api/triggers", app.listTriggersHandler)
+	r.GET("/api/functions/:id/keep-warm", app.getKeepWarmHandler)
+	r.PUT("/api/functions/:id/keep-warm", app.setKeepWarm)
+	r.DELETE("/api/functions/:id/keep-warm", app.deleteKeepWarm)
+	r.GET("/api/functions/:id/deployment", app.getDeploymentHandler)
+	r.PUT("/api/functions/:id/deployment", app.setDeployment)
+	r.POST("/api/functions/:id/deployment/switch", app.switchDeployment)
+	r.GET("/api/functions/:id/deployment/metrics", app.deploymentMetricsHandler)
+	r.DELETE("/api/functions/:id/deployment", app.deleteDeployment)
+	r.GET("/api/functions/:id/logs", app.listFunctionLogs)
+	r.GET("/api/functions/:id/versions", app.listVersionsHandler)
+	r.GET("/api/functions/:id/versions/:version/diff", app.versionDiffHandler)
+	r.POST("/api/functions/:id/versions/:version/restore", app.restoreVersion)
+	r.POST("/api/functions/:id/resync", app.resyncFunction)
+	r.GET("/api/logs/stream", app.streamLogs)
+	r.GET("/api/functions/:id/metrics", app.functionMetricsHandler)
+	r.GET("/api/functions/:id/snippets", app.functionSnippetsHandler)
+	r.GET("/api/functions/:id/errors", app.listErrorGroups)
+	r.GET("/api/errors/:id", app.getErrorGroupHandler)
+	r.POST("/api/errors/:id/resolve", app.setErrorGroupStatus(ErrorGroupResolved))
+	r.POST("/api/errors/:id/reopen", app.setErrorGroupStatus(ErrorGroupOpen))
+	r.GET("/api/functions/:id/usage", app.functionUsageHandler)
+	r.GET("/api/usage", app.usageReportHandler)
+	r.GET("/api/functions/:id/quota", app.getUsageQuotaHandler)
+	r.PUT("/api/functions/:id/quota", app.setUsageQuota)
+	r.DELETE("/api/functions/:id/quota", app.deleteUsageQuota)
+	r.GET("/api/functions/:id/proto", app.getFunctionProtoHandler)
+	r.PUT("/api/functions/:id/proto", app.setFunctionProto)
+	r.DELETE("/api/functions/:id/proto", app.deleteFunctionProto)
+	r.GET("/api/functions/:id/capture", app.getCaptureSettingsHandler)
+	r.PUT("/api/functions/:id/capture", app.setCaptureSettings)
+	r.DELETE("/api/functions/:id/capture", app.deleteCaptureSettings)
+	r.GET("/api/functions/:id/captures", app.listCaptures)
+	r.GET("/api/captures/:id", app.getCaptureHandler)
+	r.GET("/api/functions/:id/slo", app.getLatencySLOHandler)
+	r.PUT("/api/functions/:id/slo", app.setLatencySLO)
+	r.DELETE("/api/functions/:id/slo", app.deleteLatencySLO)
+	r.GET("/api/functions/:id/traffic-alert", app.getTrafficAlertHandler)
+	r.PUT("/api/functions/:id/traffic-alert", app.setTrafficAlert)
+	r.DELETE("/api/functions/:id/traffic-alert", app.deleteTrafficAlert)
+	r.GET("/api/functions/:id/alert-rules", app.listAlertRules)
+	r.POST("/api/functions/:id/alert-rules", app.createAlertRule)
+	r.PUT("/api/alert-rules/:id", app.updateAlertRule)
+	r.DELETE("/api/alert-rules/:id", app.deleteAlertRule)
+	r.GET("/api/metrics", app.listMetricsHandler)
+	r.GET("/api/metrics/series", app.metricsSeriesHandler)
+	r.GET("/api/runtime/stats", app.runtimeStatsHandler)
+	r.GET("/api/functions/:id/on-failure", app.getFailureHandlerHandler)
+	r.PUT("/api/functions/:id/on-failure", app.setFailureHandler)
+	r.DELETE("/api/functions/:id/on-failure", app.deleteFailureHandler)
+	r.GET("/api/functions/:id/materializations", app.listMaterializationsHandler)
+	r.POST("/api/functions/:id/materializations", app.createMaterialization)
+	r.POST("/api/materializations/:id/refresh", app.refreshMaterializationHandler)
+	r.DELETE("/api/materializations/:id", app.deleteMaterialization)
+
+	r.GET("/api/functions/:id/triggers", app.listTriggersHandler)
+	r.POST("/api/functions/:id/triggers", app.createTrigger)
+	r.DELETE("/api/triggers/:id", app.deleteTrigger)
+
+	r.GET("/api/webhooks", app.listWebhooksHandler)
+	r.POST("/api/webhooks", app.createWebhook)
+	r.PUT("/api/webhooks/:id", app.updateWebhook)
+	r.DELETE("/api/webhooks/:id", app.deleteWebhook)
+	r.POST("/api/webhooks/:id/ping", app.pingWebhook)
+	r.GET("/api/templates", app.listTemplatesHandler)
+	r.GET("/api/templates/:id", app.getTemplateHandler)
+	r.POST("/api/templates", app.createTemplate)
+	r.DELETE("/api/templates/:id", app.deleteTemplate)
+
+	r.GET("/dashboard", app.dashboardPage)
+	r.GET("/audit", app.auditPage)
+	r.GET("/secrets", app.secretsPage)
+	r.GET("/api/secrets", app.listSecretsHandler)
+	r.POST("/api/secrets", app.createSecret)
+	r.PUT("/api/secrets/:name", app.rotateSecret)
+	r.DELETE("/api/secrets/:name", app.deleteSecret)
+	r.GET("/flags", app.flagsPage)
+	r.GET("/api/flags", app.listFlagsHandler)
+	r.POST("/api/flags", app.createFlag)
+	r.GET("/api/flags/:name", app.getFlagHandler)
+	r.PUT("/api/flags/:name", app.updateFlag)
+	r.DELETE("/api/flags/:name", app.deleteFlag)
+	r.POST("/api/flags/:name/evaluate", app.evaluateFlag)
+
+	r.GET("/api/render-templates", app.listRenderTemplatesHandler)
+	r.GET("/api/render-templates/:name", app.getRenderTemplateHandler)
+	r.PUT("/api/render-templates/:name", app.putRenderTemplate)
+	r.DELETE("/api/render-templates/:name", app.deleteRenderTemplate)
+	r.POST("/api/render/:name", app.previewRenderTemplate)
+	r.GET("/env", app.envPage)
+	r.GET("/api/env", app.listEnvHandler)
+	r.PUT("/api/env/:name", app.setEnvHandler)
+	r.DELETE("/api/env/:name", app.deleteEnvHandler)
+	r.GET("/api/functions/:id/env", app.listEnvHandler)
+	r.PUT("/api/functions/:id/env/:name", app.setEnvHandler)
+	r.DELETE("/api/functions/:id/env/:name", app.deleteEnvHandler)
+	r.GET("/api/audit", app.listAuditHandler)
+	r.GET("/workflows", app.workflowsPage)
+	r.GET("/dead-letters", app.deadLettersPage)
+	r.GET("/api/workflows", app.listWorkflowsHandler)
+	r.POST("/api/workflows", app.createWorkflow)
+	r.GET("/api/workflows/:id", app.getWorkflowHandler)
+	r.PUT("/api/workflows/:id", app.updateWorkflow)
+	r.DELETE("/api/workflows/:id", app.deleteWorkflow)
+	r.GET("/api/workflows/:id/runs", app.listWorkflowRunsHandler)
+	r.POST("/api/workflows/:id/runs", app.runWorkflowHandler)
+	r.GET("/api/workflow-runs/:id", app.getWorkflowRunHandler)
+	r.POST("/api/workflow-runs/:id/cancel", app.cancelWorkflowRunHandler)
+
+	r.POST("/api/cloudevents", app.executeRoutes(app.ingestCloudEvent)...)
+	r.GET("/api/cloudevents/routes", app.listCloudEventRoutes)
+	r.POST("/api/cloudevents/routes", app.createCloudEventRoute)
+	r.DELETE("/api/cloudevents/routes/:id", app.deleteCloudEventRoute)
+
+	return r, nil
+}
@@ -0,0 +1,408 @@
+package runbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// applyManifest is the declarative description POST /api/apply converges
+// the instance to. A nil env, keepWarm or onFailure leaves that setting as
+// it is; an empty one removes it.
+type applyManifest struct {
+	Env       map[string]string `json:"env" yaml:"env"`
+	Functions []applyFunction   `json:"functions" yaml:"functions"`
+	// Prune deletes functions the manifest doesn't list. It defaults to
+	// true, so the manifest is the whole instance.
+	Prune *bool `json:"prune" yaml:"prune"`
+}
+
+type applyFunction struct {
+	Name        string            `json:"name" yaml:"name"`
+	Path        string            `json:"path" yaml:"path"`
+	Description string            `json:"description" yaml:"description"`
+	Mode        string            `json:"mode" yaml:"mode"`
+	Tags        []string          `json:"tags" yaml:"tags"`
+	Visibility  string            `json:"visibility" yaml:"visibility"`
+	Code        string            `json:"code" yaml:"code"`
+	Files       []FunctionFile    `json:"files" yaml:"files"`
+	Env         map[string]string `json:"env" yaml:"env"`
+	// KeepWarm with an interval of 0 turns keep-warm off.
+	KeepWarm *struct {
+		IntervalSeconds int    `json:"intervalSeconds" yaml:"intervalSeconds"`
+		Path            string `json:"path" yaml:"path"`
+	} `json:"keepWarm" yaml:"keepWarm"`
+	// OnFailure is the path of the failure handler; "" removes it.
+	OnFailure *string `json:"onFailure" yaml:"onFailure"`
+}
+
+// applyStep is one change in the plan. Path is empty for global env
+// variables.
+type applyStep struct {
+	Action string   `json:"action"`
+	Kind   string   `json:"kind"`
+	Path   string   `json:"path,omitempty"`
+	Name   string   `json:"name,omitempty"`
+	Fields []string `json:"fields,omitempty"`
+	Error  string   `json:"error,omitempty"`
+	run    func() error
+}
+
+// applyManifestHandler serves POST /api/apply with a JSON or, with a yaml
+// content type, YAML manifest. It answers the plan; with ?dryRun=true
+// nothing is changed. An invalid manifest applies nothing.
+func (app *App) applyManifestHandler(c *gin.Context) {
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, maxBundleBytes+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read manifest"})
+		return
+	}
+	if len(data) > maxBundleBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Manifest is larger than 16 MiB"})
+		return
+	}
+	var m applyManifest
+	if strings.Contains(c.ContentType(), "yaml") {
+		err = yaml.Unmarshal(data, &m)
+	} else {
+		err = json.Unmarshal(data, &m)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid manifest: " + err.Error()})
+		return
+	}
+
+	steps, unchanged, errs := app.planApply(app.auditOrigin(c), &m)
+	if len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Manifest is invalid", "errors": errs})
+		return
+	}
+
+	dryRun := c.Query("dryRun") == "true"
+	counts := map[string]int{}
+	for _, step := range steps {
+		if !dryRun {
+			if err := step.run(); err != nil {
+				step.Error = err.Error()
+				counts["failed"]++
+				continue
+			}
+		}
+		counts[step.Action]++
+	}
+	c.JSON(http.StatusOK, gin.H{"plan": steps, "unchanged": unchanged, "applied": !dryRun, "counts": counts})
+}
+
+// planApply diffs m against the instance. Steps run in order: functions
+// are created and updated first so settings can refer to them, and
+// pruned last.
+func (app *App) planApply(origin auditOrigin, m *applyManifest) ([]*applyStep, int, []string) {
+	existing, err := app.getAllFunctions()
+	if err != nil {
+		return nil, 0, []string{"failed to list functions: " + err.Error()}
+	}
+	current := map[string]*Function{}
+	for i := range existing {
+		current[existing[i].Path] = &existing[i]
+	}
+	prune := m.Prune == nil || *m.Prune
+
+	var errs []string
+	listed := map[string]bool{}
+	for i := range m.Functions {
+		f := &m.Functions[i]
+		if f.Path != "" && !strings.HasPrefix(f.Path, "/") {
+			f.Path = "/" + f.Path
+		}
+		if f.Name == "" {
+			f.Name = strings.Trim(f.Path, "/")
+			if f.Name == "" {
+				f.Name = "index"
+			}
+		}
+		if f.Mode == "" {
+			f.Mode = ModeStandard
+		}
+		if err := validateApplyFunction(f); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", f.Path, err))
+		}
+		if listed[f.Path] {
+			errs = append(errs, fmt.Sprintf("%s: listed twice", f.Path))
+		}
+		listed[f.Path] = true
+	}
+	for _, f := range m.Functions {
+		if f.OnFailure == nil || *f.OnFailure == "" {
+			continue
+		}
+		target := *f.OnFailure
+		if !strings.HasPrefix(target, "/") {
+			target = "/" + target
+		}
+		switch {
+		case target == f.Path:
+			errs = append(errs, fmt.Sprintf("%s: a function cannot be its own failure handler", f.Path))
+		case !listed[target] && (current[target] == nil || prune):
+			errs = append(errs, fmt.Sprintf("%s: failure handler %s is not in the manifest", f.Path, target))
+		}
+	}
+	if m.Env != nil {
+		for name, value := range m.Env {
+			if err := validateEnvVar(name, value); err != nil {
+				errs = append(errs, fmt.Sprintf("env %s: %v", name, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, 0, errs
+	}
+
+	var functions, settings, deletes []*applyStep
+	unchanged := 0
+	if m.Env != nil {
+		settings = append(settings, app.planEnv(0, "", m.Env)...)
+	}
+	for _, f := range m.Functions {
+		f := f
+		bf := bundleFunction{Name: f.Name, Path: f.Path, Description: f.Description, Mode: f.Mode, Tags: f.Tags, Visibility: f.Visibility, Code: f.Code, Files: f.Files}
+		before := current[f.Path]
+		if before == nil {
+			functions = append(functions, &applyStep{Action: "create", Kind: "function", Path: f.Path, Name: f.Name, run: func() error {
+				_, err := app.importBundleFunction(origin, bf, importCreate)
+				return err
+			}})
+		} else if fields := changedFields(before, &bf); len(fields) > 0 {
+			functions = append(functions, &applyStep{Action: "update", Kind: "function", Path: f.Path, Name: f.Name, Fields: fields, run: func() error {
+				_, err := app.importBundleFunction(origin, bf, importOverwrite)
+				return err
+			}})
+		} else {
+			unchanged++
+		}
+
+		id := 0
+		if before != nil {
+			id = before.ID
+		}
+		if f.Env != nil {
+			settings = append(settings, app.planEnv(id, f.Path, f.Env)...)
+		}
+		if f.KeepWarm != nil {
+			if step := app.planKeepWarm(id, f.Path, f.KeepWarm.IntervalSeconds, f.KeepWarm.Path); step != nil {
+				settings = append(settings, step)
+			}
+		}
+		if f.OnFailure != nil {
+			if step := app.planOnFailure(id, f.Path, *f.OnFailure); step != nil {
+				settings = append(settings, step)
+			}
+		}
+	}
+
+	if prune {
+		for _, f := range existing {
+			if listed[f.Path] {
+				continue
+			}
+			f := f
+			deletes = append(deletes, &applyStep{Action: "delete", Kind: "function", Path: f.Path, Name: f.Name, run: func() error {
+				if err := app.removeFunction(f.ID); err != nil {
+					return err
+				}
+				app.recordAudit(origin, AuditFunctionDeleted, &f, nil)
+				return nil
+			}})
+		}
+	}
+
+	return append(append(functions, settings...), deletes...), unchanged, nil
+}
+
+func validateApplyFunction(f *applyFunction) error {
+	if f.Path == "" || f.Code == "" {
+		return fmt.Errorf("path and code are required")
+	}
+	if !validMode(f.Mode) {
+		return fmt.Errorf("invalid mode %s", f.Mode)
+	}
+	if diagnostics := validateFunctionCode(f.Code, f.Mode); diagnostics != nil {
+		return fmt.Errorf("syntax error at %s", diagnostics[0].String())
+	}
+	if err := validateFunctionFiles(f.Files); err != nil {
+		return err
+	}
+	if !validVisibility(f.Visibility) {
+		return fmt.Errorf("invalid visibility %s", f.Visibility)
+	}
+	for name, value := range f.Env {
+		if err := validateEnvVar(name, value); err != nil {
+			return fmt.Errorf("env %s: %v", name, err)
+		}
+	}
+	if f.KeepWarm != nil && f.KeepWarm.IntervalSeconds != 0 && f.KeepWarm.IntervalSeconds < 10 {
+		return fmt.Errorf("keepWarm.intervalSeconds must be at least 10, or 0 to turn it off")
+	}
+	return nil
+}
+
+// changedFields names what applying f would change on current. Tags are
+// only compared when f sets them.
+func changedFields(current *Function, f *bundleFunction) []string {
+	var fields []string
+	check := func(name string, same bool) {
+		if !same {
+			fields = append(fields, name)
+		}
+	}
+	check("name", current.Name == f.Name)
+	check("code", current.Code == f.Code)
+	check("description", current.Description == f.Description)
+	check("mode", current.Mode == f.Mode)
+	check("files", encodeFiles(current.Files) == encodeFiles(f.Files))
+	check("visibility", current.Visibility == f.Visibility)
+	if f.Tags != nil {
+		check("tags", reflect.DeepEqual(normalizeTags(current.Tags), normalizeTags(f.Tags)))
+	}
+	return fields
+}
+
+// applyFunctionID looks the function up when the step runs, since it may
+// have been created earlier in the same apply.
+func (app *App) applyFunctionID(fnPath string) (int, error) {
+	if fnPath == "" {
+		return 0, nil
+	}
+	f, err := app.getFunctionByPath(fnPath)
+	if err != nil {
+		return 0, fmt.Errorf("no function at %s", fnPath)
+	}
+	return f.ID, nil
+}
+
+// planEnv converges the variables of function id, or the global ones for
+// an empty fnPath, to want. Sensitive variables stay sensitive.
+func (app *App) planEnv(id int, fnPath string, want map[string]string) []*applyStep {
+	have := map[string]EnvVar{}
+	if id != 0 || fnPath == "" {
+		vars, _ := app.listEnvVars(id)
+		for _, v := range vars {
+			have[v.Name] = v
+		}
+	}
+
+	names := make([]string, 0, len(want))
+	for name := range want {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var steps []*applyStep
+	for _, name := range names {
+		name, value := name, want[name]
+		v, ok := have[name]
+		if ok && v.Value == value {
+			continue
+		}
+		action := "create"
+		if ok {
+			action = "update"
+		}
+		steps = append(steps, &applyStep{Action: action, Kind: "env", Path: fnPath, Name: name, run: func() error {
+			functionID, err := app.applyFunctionID(fnPath)
+			if err != nil {
+				return err
+			}
+			_, err = app.setEnvVar(functionID, name, &value, v.Sensitive)
+			return err
+		}})
+	}
+	for name := range have {
+		if _, ok := want[name]; ok {
+			continue
+		}
+		name := name
+		steps = append(steps, &applyStep{Action: "delete", Kind: "env", Path: fnPath, Name: name, run: func() error {
+			_, err := app.deleteEnvVar(id, name)
+			return err
+		}})
+	}
+	sort.SliceStable(steps, func(i, j int) bool { return steps[i].Action != "delete" && steps[j].Action == "delete" })
+	return steps
+}
+
+func (app *App) planKeepWarm(id int, fnPath string, interval int, warmPath string) *applyStep {
+	if warmPath != "" && !strings.HasPrefix(warmPath, "/") {
+		warmPath = "/" + warmPath
+	}
+	var current *KeepWarm
+	if id != 0 {
+		current, _ = app.getKeepWarm(id)
+	}
+	if interval == 0 {
+		if current == nil {
+			return nil
+		}
+		return &applyStep{Action: "delete", Kind: "keepWarm", Path: fnPath, run: func() error {
+			return app.removeKeepWarm(id)
+		}}
+	}
+	if current != nil && current.IntervalSeconds == interval && current.Path == warmPath {
+		return nil
+	}
+	action := "create"
+	if current != nil {
+		action = "update"
+	}
+	return &applyStep{Action: action, Kind: "keepWarm", Path: fnPath, run: func() error {
+		functionID, err := app.applyFunctionID(fnPath)
+		if err != nil {
+			return err
+		}
+		return app.saveKeepWarm(functionID, interval, warmPath)
+	}}
+}
+
+func (app *App) planOnFailure(id int, fnPath, handlerPath string) *applyStep {
+	if handlerPath != "" && !strings.HasPrefix(handlerPath, "/") {
+		handlerPath = "/" + handlerPath
+	}
+	var current *FailureHandler
+	if id != 0 {
+		current, _ = app.getFailureHandler(id)
+	}
+	if handlerPath == "" {
+		if current == nil {
+			return nil
+		}
+		return &applyStep{Action: "delete", Kind: "onFailure", Path: fnPath, run: func() error {
+			return app.removeFailureHandler(id)
+		}}
+	}
+	if current != nil {
+		if handler, err := app.getFunctionByID(current.HandlerID); err == nil && handler.Path == handlerPath {
+			return nil
+		}
+	}
+	action := "create"
+	if current != nil {
+		action = "update"
+	}
+	return &applyStep{Action: action, Kind: "onFailure", Path: fnPath, Name: handlerPath, run: func() error {
+		functionID, err := app.applyFunctionID(fnPath)
+		if err != nil {
+			return err
+		}
+		handlerID, err := app.applyFunctionID(handlerPath)
+		if err != nil {
+			return err
+		}
+		return app.saveFailureHandler(functionID, handlerID)
+	}}
+}
@@ -0,0 +1,64 @@
+package runbox
+
+import (
+	"embed"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:generate sh scripts/vendor-monaco.sh
+
+// The pages and static files are built into the binary. static/ holds
+// whatever was vendored into it at build time, such as Monaco, which go
+// generate fetches.
+var (
+	//go:embed templates
+	embeddedTemplates embed.FS
+	//go:embed all:static
+	embeddedStatic embed.FS
+)
+
+// loadTemplates parses the page templates from the templates setting when
+// it names a directory, or else from the binary.
+func (app *App) loadTemplates(r *gin.Engine) {
+	if dir := app.config.Templates; dir != "" {
+		r.LoadHTMLGlob(filepath.Join(dir, "*"))
+		return
+	}
+	r.SetHTMLTemplate(template.Must(template.New("").Funcs(r.FuncMap).ParseFS(embeddedTemplates, "templates/*")))
+}
+
+// staticFiles serves /static from the static setting when it names a
+// directory, or else from the binary. Directories are never listed.
+func (app *App) staticFiles() http.FileSystem {
+	if dir := app.config.Static; dir != "" {
+		return gin.Dir(dir, false)
+	}
+	sub, err := fs.Sub(embeddedStatic, "static")
+	if err != nil {
+		panic(err)
+	}
+	return filesOnly{http.FS(sub)}
+}
+
+// filesOnly answers requests for a directory with "not found".
+type filesOnly struct {
+	fs http.FileSystem
+}
+
+func (f filesOnly) Open(name string) (http.File, error) {
+	file, err := f.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := file.Stat(); err == nil && info.IsDir() {
+		file.Close()
+		return nil, os.ErrNotExist
+	}
+	return file, nil
+}
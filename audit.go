@@ -0,0 +1,387 @@
+package runbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	AuditFunctionCreated = "function.created"
+	AuditFunctionUpdated = "function.updated"
+	AuditFunctionDeleted = "function.deleted"
+
+	defaultAuditUserHeader = "X-Forwarded-User"
+)
+
+// AuditEntry records one change to a function: who made it, the fields
+// that changed as [old, new] pairs, and a unified diff of the code.
+type AuditEntry struct {
+	ID           int                  `json:"id"`
+	Actor        string               `json:"actor"`
+	Action       string               `json:"action"`
+	FunctionID   int                  `json:"functionId"`
+	FunctionName string               `json:"functionName"`
+	Path         string               `json:"path"`
+	Version      int                  `json:"version,omitempty"`
+	Changes      map[string][2]string `json:"changes"`
+	Diff         string               `json:"diff,omitempty"`
+	RemoteAddr   string               `json:"remoteAddr"`
+	CreatedAt    time.Time            `json:"createdAt"`
+}
+
+func (app *App) initAuditLogTable() error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		actor TEXT NOT NULL,
+		action TEXT NOT NULL,
+		function_id INTEGER NOT NULL,
+		function_name TEXT NOT NULL,
+		path TEXT NOT NULL,
+		version INTEGER NOT NULL DEFAULT 0,
+		changes TEXT NOT NULL DEFAULT '{}',
+		diff TEXT NOT NULL DEFAULT '',
+		remote_addr TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_audit_log_created ON audit_log (created_at);
+	CREATE INDEX IF NOT EXISTS idx_audit_log_function ON audit_log (function_id, created_at);`
+
+	if _, err := app.db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create audit_log table: %v", err)
+	}
+	return nil
+}
+
+// auditOrigin is who made a change: the user an authenticating proxy in
+// trustedProxies put in audit.userHeader, else the name of the API key the
+// request was authorized with, or "anonymous"; and the client address.
+type auditOrigin struct {
+	actor      string
+	remoteAddr string
+}
+
+type auditOriginKey struct{}
+
+func (app *App) auditOrigin(c *gin.Context) auditOrigin {
+	header := app.config.Audit.UserHeader
+	if header == "" {
+		header = defaultAuditUserHeader
+	}
+	var actor string
+	if app.fromTrustedProxy(c) {
+		actor = strings.TrimSpace(c.GetHeader(header))
+	}
+	if actor == "" {
+		actor = c.GetString(actorKey)
+	}
+	if actor == "" {
+		actor = "anonymous"
+	}
+	return auditOrigin{actor: actor, remoteAddr: c.ClientIP()}
+}
+
+// auditOriginFrom returns the origin graphqlHandler put on ctx.
+func auditOriginFrom(ctx context.Context) auditOrigin {
+	if origin, ok := ctx.Value(auditOriginKey{}).(auditOrigin); ok {
+		return origin
+	}
+	return auditOrigin{actor: "anonymous"}
+}
+
+// recordAudit logs a change from before to after; before is nil for a
+// created function and after is nil for a deleted one.
+func (app *App) recordAudit(origin auditOrigin, action string, before, after *Function) {
+	e := AuditEntry{
+		Actor:      origin.actor,
+		Action:     action,
+		Changes:    map[string][2]string{},
+		RemoteAddr: origin.remoteAddr,
+		CreatedAt:  time.Now().UTC(),
+	}
+	var old, cur Function
+	if before != nil {
+		old = *before
+	}
+	if after != nil {
+		cur = *after
+	}
+
+	subject := cur
+	if after == nil {
+		subject = old
+	}
+	e.FunctionID, e.FunctionName, e.Path, e.Version = subject.ID, subject.Name, subject.Path, subject.Version
+
+	for _, field := range []struct{ name, old, cur string }{
+		{"name", old.Name, cur.Name},
+		{"path", old.Path, cur.Path},
+		{"description", old.Description, cur.Description},
+		{"mode", old.Mode, cur.Mode},
+		{"visibility", old.Visibility, cur.Visibility},
+	} {
+		if field.old != field.cur {
+			e.Changes[field.name] = [2]string{field.old, field.cur}
+		}
+	}
+	from, to := "/dev/null", "/dev/null"
+	if before != nil {
+		from = old.Path + "@v" + strconv.Itoa(old.Version)
+	}
+	if after != nil {
+		to = cur.Path + "@v" + strconv.Itoa(cur.Version)
+	}
+	e.Diff = unifiedDiff(from, to, old.Code, cur.Code)
+
+	if action == AuditFunctionUpdated && len(e.Changes) == 0 && e.Diff == "" {
+		return
+	}
+
+	changes, _ := json.Marshal(e.Changes)
+	_, err := app.db.Exec(`INSERT INTO audit_log (actor, action, function_id, function_name, path, version, changes, diff, remote_addr, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.Actor, e.Action, e.FunctionID, e.FunctionName, e.Path, e.Version, string(changes), e.Diff, e.RemoteAddr, e.CreatedAt)
+	if err != nil {
+		log.Printf("Failed to record audit entry for function %d: %v", e.FunctionID, err)
+	}
+}
+
+const auditColumns = `id, actor, action, function_id, function_name, path, version, changes, diff, remote_addr, created_at`
+
+func scanAuditEntry(row rowScanner) (*AuditEntry, error) {
+	var e AuditEntry
+	var changes string
+	err := row.Scan(&e.ID, &e.Actor, &e.Action, &e.FunctionID, &e.FunctionName, &e.Path, &e.Version, &changes,
+		&e.Diff, &e.RemoteAddr, &e.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	e.Changes = map[string][2]string{}
+	json.Unmarshal([]byte(changes), &e.Changes)
+	return &e, nil
+}
+
+// auditFilter holds the filters shared by the audit API and page: actor,
+// functionId, and since and until as RFC 3339 times or dates, where an
+// until date includes that whole day.
+type auditFilter struct {
+	Actor      string
+	FunctionID int
+	Since      string
+	Until      string
+}
+
+func parseAuditTime(v string, endOfDay bool) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t.UTC(), true
+	}
+	if t, err := time.Parse("2006-01-02", v); err == nil {
+		if endOfDay {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+func (f auditFilter) query() (string, []interface{}, string) {
+	query := `SELECT ` + auditColumns + ` FROM audit_log WHERE 1 = 1`
+	var args []interface{}
+	if f.Actor != "" {
+		query += ` AND actor = ?`
+		args = append(args, f.Actor)
+	}
+	if f.FunctionID != 0 {
+		query += ` AND function_id = ?`
+		args = append(args, f.FunctionID)
+	}
+	if f.Since != "" {
+		t, ok := parseAuditTime(f.Since, false)
+		if !ok {
+			return "", nil, "since must be an RFC 3339 time or a date"
+		}
+		query += ` AND created_at >= ?`
+		args = append(args, t)
+	}
+	if f.Until != "" {
+		t, ok := parseAuditTime(f.Until, true)
+		if !ok {
+			return "", nil, "until must be an RFC 3339 time or a date"
+		}
+		query += ` AND created_at < ?`
+		args = append(args, t)
+	}
+	return query, args, ""
+}
+
+func (app *App) listAuditEntries(f auditFilter, limit, offset int) ([]AuditEntry, string, error) {
+	query, args, msg := f.query()
+	if msg != "" {
+		return nil, msg, nil
+	}
+	query += ` ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?`
+	args = append(args, limit, offset)
+
+	rows, err := app.db.Query(query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		e, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		entries = append(entries, *e)
+	}
+	return entries, "", nil
+}
+
+func auditFilterFrom(c *gin.Context) (auditFilter, bool) {
+	f := auditFilter{Actor: c.Query("actor"), Since: c.Query("since"), Until: c.Query("until")}
+	if v := c.Query("functionId"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return f, false
+		}
+		f.FunctionID = id
+	}
+	return f, true
+}
+
+// listAuditHandler serves GET /api/audit, newest first.
+func (app *App) listAuditHandler(c *gin.Context) {
+	f, ok := auditFilterFrom(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+
+	var err error
+	limit, offset := 50, 0
+	if v := c.Query("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > 500 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 500"})
+			return
+		}
+	}
+	if v := c.Query("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+			return
+		}
+	}
+
+	entries, msg, err := app.listAuditEntries(f, limit, offset)
+	if msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list audit log"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"entries": entries})
+}
+
+type auditDiffLine struct {
+	Class string
+	Text  string
+}
+
+// diffLines splits a diff for the page, classed by what each line is.
+func diffLines(diff string) []auditDiffLine {
+	var lines []auditDiffLine
+	for _, line := range splitLines(diff) {
+		class := ""
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+			class = "text-muted"
+		case strings.HasPrefix(line, "@@"):
+			class = "text-info"
+		case strings.HasPrefix(line, "+"):
+			class = "text-success"
+		case strings.HasPrefix(line, "-"):
+			class = "text-danger"
+		}
+		lines = append(lines, auditDiffLine{Class: class, Text: line})
+	}
+	return lines
+}
+
+func (app *App) auditPage(c *gin.Context) {
+	f, _ := auditFilterFrom(c)
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	page = max(page, 1)
+	const perPage = 50
+
+	entries, msg, err := app.listAuditEntries(f, perPage+1, (page-1)*perPage)
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": err.Error()})
+		return
+	}
+	more := len(entries) > perPage
+	if more {
+		entries = entries[:perPage]
+	}
+
+	type row struct {
+		AuditEntry
+		Lines []auditDiffLine
+	}
+	rows := make([]row, len(entries))
+	for i, e := range entries {
+		rows[i] = row{AuditEntry: e, Lines: diffLines(e.Diff)}
+	}
+
+	functions, err := app.getAllFunctions()
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": err.Error()})
+		return
+	}
+	var actors []string
+	if r, err := app.db.Query(`SELECT DISTINCT actor FROM audit_log ORDER BY actor`); err == nil {
+		for r.Next() {
+			var actor string
+			if r.Scan(&actor) == nil {
+				actors = append(actors, actor)
+			}
+		}
+		r.Close()
+	}
+
+	c.HTML(http.StatusOK, "audit.html", gin.H{
+		"title":     "Audit Log",
+		"entries":   rows,
+		"functions": functions,
+		"actors":    actors,
+		"filter":    f,
+		"error":     msg,
+		"prevURL":   pageURL(c, page-1),
+		"nextURL":   pageURL(c, page+1),
+		"page":      page,
+		"more":      more,
+	})
+}
+
+// pageURL links to another page of the audit log with the same filters.
+func pageURL(c *gin.Context, page int) string {
+	q := url.Values{}
+	for k, v := range c.Request.URL.Query() {
+		q[k] = v
+	}
+	q.Set("page", strconv.Itoa(page))
+	return "/audit?" + q.Encode()
+}
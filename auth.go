@@ -0,0 +1,168 @@
+package runbox
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sessionCookie holds the token a browser signed in with on /login.
+const sessionCookie = "runbox_token"
+
+// openRoutes are the routes that take no token: the execute routes, which
+// check each function's visibility, CloudEvents ingestion, which checks
+// the visibility of the functions it routes to, share links, health
+// checks, static files and the sign-in page itself.
+var openRoutes = map[string]bool{
+	"/api/execute/*path":       true,
+	"/api/execute-async/*path": true,
+	"/api/cloudevents":         true,
+	"/share/:token":            true,
+	"/healthz":                 true,
+	"/readyz":                  true,
+	"/static/*filepath":        true,
+	"/login":                   true,
+	"/logout":                  true,
+}
+
+// requestToken is the bearer token a request carries, or the one its
+// session cookie holds.
+func requestToken(c *gin.Context) (string, bool) {
+	if token, ok := bearerToken(c); ok {
+		return token, true
+	}
+	if token, err := c.Cookie(sessionCookie); err == nil && token != "" {
+		return token, true
+	}
+	return "", false
+}
+
+// authRequired reports whether the management API and pages need a
+// token. Every instance requires one but runbox dev on a loopback address,
+// whose database is gone on exit and which nobody else can reach; before
+// the first API key exists that is the bootstrap token.
+func (app *App) authRequired() bool {
+	return app.config.Database != devDatabase || !loopbackAddr(app.config.Addr)
+}
+
+// loopbackAddr reports whether addr only accepts connections from this
+// host: a Unix socket, or TCP on localhost or a loopback IP.
+func loopbackAddr(addr string) bool {
+	if strings.HasPrefix(addr, unixAddrPrefix) {
+		return true
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// loopbackListener reports whether l only accepts connections from this
+// host.
+func loopbackListener(l net.Listener) bool {
+	switch addr := l.Addr().(type) {
+	case *net.UnixAddr:
+		return true
+	case *net.TCPAddr:
+		return addr.IP.IsLoopback()
+	}
+	return false
+}
+
+// actorKey holds who authorized a request: the name of its API key,
+// "auth.tokens" for a configured token, or "bootstrap".
+const actorKey = "runbox.actor"
+
+// bootstrapRoute is the one request the bootstrap token authorizes:
+// creating the first API key.
+func bootstrapRoute(c *gin.Context) bool {
+	return c.Request.Method == http.MethodPost && c.FullPath() == "/api/keys"
+}
+
+func (app *App) authorized(c *gin.Context) bool {
+	token, ok := requestToken(c)
+	if !ok {
+		return false
+	}
+	if name, ok := app.tokenName(token); ok {
+		c.Set(actorKey, name)
+		return true
+	}
+	if bootstrapRoute(c) && app.validBootstrapToken(token) {
+		c.Set(actorKey, "bootstrap")
+		return true
+	}
+	return false
+}
+
+// authMiddleware guards every route outside openRoutes: the function API,
+// GraphQL, dry runs, the playground, secrets and the other settings, and
+// the editor pages. API calls without a valid token get 401 and pages
+// redirect to /login.
+func (app *App) authMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if !app.authRequired() || route == "" || openRoutes[route] || app.authorized(c) {
+			return
+		}
+		if strings.HasPrefix(route, "/api/") || c.Request.Method != http.MethodGet {
+			c.Header("WWW-Authenticate", `Bearer realm="runbox"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Send Authorization: Bearer with an API key or a token from auth.tokens"})
+			return
+		}
+		c.Redirect(http.StatusSeeOther, "/login?next="+url.QueryEscape(c.Request.URL.RequestURI()))
+		c.Abort()
+	}
+}
+
+// authStatus serves GET /api/auth. Like the rest of the management API it
+// only answers an authorized request, so clients such as runbox login
+// check a token with it.
+func (app *App) authStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"required": app.authRequired()})
+}
+
+// localRedirect is next when it is a path on this instance, and / otherwise.
+func localRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+func (app *App) loginPage(c *gin.Context) {
+	c.HTML(http.StatusOK, "login.html", gin.H{"title": "Sign in", "next": localRedirect(c.Query("next"))})
+}
+
+// login serves POST /login with a token form field: a valid token is kept
+// in an HTTP-only cookie the pages and their API calls are authorized by.
+func (app *App) login(c *gin.Context) {
+	token := strings.TrimSpace(c.PostForm("token"))
+	next := localRedirect(c.PostForm("next"))
+	if token == "" || !app.validToken(token) {
+		c.HTML(http.StatusUnauthorized, "login.html", gin.H{"title": "Sign in", "next": next, "error": "That token is not valid"})
+		return
+	}
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteStrictMode,
+	})
+	c.Redirect(http.StatusSeeOther, next)
+}
+
+func (app *App) logout(c *gin.Context) {
+	http.SetCookie(c.Writer, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1, HttpOnly: true, SameSite: http.SameSiteStrictMode})
+	c.Redirect(http.StatusSeeOther, "/login")
+}
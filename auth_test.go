@@ -0,0 +1,96 @@
+package runbox
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestOpenRoutesAreRegistered(t *testing.T) {
+	s := newTestServer(t, nil)
+	registered := map[string]bool{}
+	for _, route := range s.handler.(*gin.Engine).Routes() {
+		registered[route.Path] = true
+	}
+	for route := range openRoutes {
+		if !registered[route] {
+			t.Errorf("open route %s is not registered", route)
+		}
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	s := newTestServer(t, func(c *Config) { c.Auth.Tokens = []string{"configured-token"} })
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		want   int
+	}{
+		{"execute is open", http.MethodGet, "/api/execute/missing", "", http.StatusNotFound},
+		{"async execute is open", http.MethodPost, "/api/execute-async/missing", "", http.StatusNotFound},
+		{"cloudevents are open", http.MethodPost, "/api/cloudevents", "", http.StatusBadRequest},
+		{"share links are open", http.MethodGet, "/share/nonsense", "", http.StatusNotFound},
+		{"health check is open", http.MethodGet, "/healthz", "", http.StatusOK},
+		{"sign-in page is open", http.MethodGet, "/login", "", http.StatusOK},
+		{"api needs a token", http.MethodGet, "/api/functions/1/schedules", "", http.StatusUnauthorized},
+		{"api rejects a wrong token", http.MethodGet, "/api/functions/1/schedules", "wrong", http.StatusUnauthorized},
+		{"api takes a token", http.MethodGet, "/api/functions/1/schedules", "configured-token", http.StatusOK},
+		{"api writes need a token", http.MethodPost, "/api/functions", "", http.StatusUnauthorized},
+		{"pages redirect to sign-in", http.MethodGet, "/", "", http.StatusSeeOther},
+		{"pages take a token", http.MethodGet, "/", "configured-token", http.StatusOK},
+		{"unknown routes are not found", http.MethodGet, "/nowhere", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("%s %s = %d, want %d: %s", tt.method, tt.path, w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestBootstrapTokenOnlyCreatesTheFirstKey(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "bootstrap")
+	s := newTestServer(t, func(c *Config) { c.Auth.BootstrapTokenFile = file })
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := strings.TrimSpace(string(data))
+
+	send := func(method, path, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := send(http.MethodGet, "/api/keys", ""); code != http.StatusUnauthorized {
+		t.Errorf("GET /api/keys = %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code := send(http.MethodPost, "/api/functions", `{"name":"f","path":"/f","code":""}`); code != http.StatusUnauthorized {
+		t.Errorf("POST /api/functions = %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code := send(http.MethodPost, "/api/keys", `{"name":"first"}`); code != http.StatusCreated {
+		t.Fatalf("POST /api/keys = %d, want %d", code, http.StatusCreated)
+	}
+	if code := send(http.MethodPost, "/api/keys", `{"name":"second"}`); code != http.StatusUnauthorized {
+		t.Errorf("POST /api/keys after the first key = %d, want %d", code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,64 @@
+package runbox
+
+import (
+	"context"
+	"time"
+)
+
+// goBackground runs fn as background work: it should return once
+// app.ctx is done, and Close waits for it before closing the database.
+func (app *App) goBackground(fn func()) {
+	app.background.Add(1)
+	go func() {
+		defer app.background.Done()
+		fn()
+	}()
+}
+
+// every runs fn every interval as background work until the server is
+// closed.
+func (app *App) every(interval time.Duration, fn func(now time.Time)) {
+	app.goBackground(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-app.ctx.Done():
+				return
+			case now := <-ticker.C:
+				fn(now)
+			}
+		}
+	})
+}
+
+// sleep waits for d, and reports false when the server was closed first.
+func (app *App) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-app.ctx.Done():
+		return false
+	}
+}
+
+// stopping reports whether ctx ended because the server is closing rather
+// than for a reason of its own.
+func (app *App) stopping(ctx context.Context) bool {
+	return ctx.Err() != nil && app.ctx.Err() != nil
+}
+
+// stopBackground cancels app.ctx, stops the triggers and waits for the
+// background work to return.
+func (app *App) stopBackground() {
+	if app.stop == nil {
+		return
+	}
+	app.stop()
+	if app.triggers != nil {
+		app.stopTriggers()
+	}
+	app.background.Wait()
+}
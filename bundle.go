@@ -0,0 +1,466 @@
+package runbox
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	bundleFormat    = "runbox-bundle"
+	bundleVersion   = 1
+	maxBundleBytes  = 16 << 20
+	bundleManifest  = "runbox.json"
+	bundleCodeDir   = "functions/"
+	importCreate    = "create"
+	importOverwrite = "overwrite"
+	importSkip      = "skip"
+)
+
+// functionBundle is the export format: a JSON file, or a tar.gz archive
+// holding runbox.json with each function's code in its own file.
+type functionBundle struct {
+	Format     string           `json:"format"`
+	Version    int              `json:"version"`
+	ExportedAt time.Time        `json:"exportedAt"`
+	Functions  []bundleFunction `json:"functions"`
+}
+
+type bundleFunction struct {
+	Name        string   `json:"name"`
+	Path        string   `json:"path"`
+	Description string   `json:"description,omitempty"`
+	Mode        string   `json:"mode"`
+	Tags        []string `json:"tags,omitempty"`
+	SourceURL   string   `json:"sourceUrl,omitempty"`
+	Visibility  string   `json:"visibility,omitempty"`
+	Code        string   `json:"code,omitempty"`
+	// File names the archive entry holding the code, in tar.gz bundles.
+	// Modules sit next to it in a directory of the same name.
+	File  string         `json:"file,omitempty"`
+	Files []FunctionFile `json:"files,omitempty"`
+}
+
+// matches reports whether importing f over current would change nothing.
+func (f *bundleFunction) matches(current *Function) bool {
+	return current.Code == f.Code && current.Name == f.Name &&
+		current.Mode == f.Mode && current.Description == f.Description &&
+		encodeFiles(current.Files) == encodeFiles(f.Files) && current.Visibility == f.Visibility
+}
+
+func bundleFunctionOf(f *Function) bundleFunction {
+	return bundleFunction{
+		Name:        f.Name,
+		Path:        f.Path,
+		Description: f.Description,
+		Mode:        f.Mode,
+		Tags:        f.Tags,
+		SourceURL:   f.SourceURL,
+		Visibility:  f.Visibility,
+		Code:        f.Code,
+		Files:       f.Files,
+	}
+}
+
+// writeBundleArchive writes b as a gzipped tarball, moving code out of the
+// manifest into functions/<path>.js so it diffs and reviews as plain files.
+func writeBundleArchive(w io.Writer, b *functionBundle) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	manifest := *b
+	manifest.Functions = make([]bundleFunction, len(b.Functions))
+	used := map[string]bool{}
+	for i, f := range b.Functions {
+		slug := strings.Trim(importNameCleaner.ReplaceAllString(strings.ToLower(f.Path), "-"), "-")
+		if slug == "" {
+			slug = "root"
+		}
+		file := bundleCodeDir + slug + ".js"
+		for n := 2; used[file]; n++ {
+			file = bundleCodeDir + slug + "-" + strconv.Itoa(n) + ".js"
+		}
+		used[file] = true
+
+		if err := writeTarFile(tw, file, []byte(f.Code), b.ExportedAt); err != nil {
+			return err
+		}
+		f.Code, f.File = "", file
+		modules := make([]FunctionFile, len(f.Files))
+		for j, m := range f.Files {
+			if err := writeTarFile(tw, moduleEntry(file, m.Name), []byte(m.Code), b.ExportedAt); err != nil {
+				return err
+			}
+			modules[j] = FunctionFile{Name: m.Name}
+		}
+		f.Files = modules
+		manifest.Functions[i] = f
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, bundleManifest, data, b.ExportedAt); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// moduleEntry is where an archive keeps a module of the function whose
+// code is in file.
+func moduleEntry(file, name string) string {
+	return strings.TrimSuffix(file, ".js") + "/" + name
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), ModTime: modTime}); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// parseBundle reads a JSON or tar.gz bundle. An archive without a
+// runbox.json is read as a directory of .js files, one function each.
+func parseBundle(data []byte) (*functionBundle, error) {
+	if len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b {
+		return parseBundleArchive(data)
+	}
+	var b functionBundle
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("not a JSON or tar.gz bundle: %v", err)
+	}
+	if b.Format != "" && b.Format != bundleFormat {
+		return nil, fmt.Errorf("unknown bundle format %q", b.Format)
+	}
+	return &b, nil
+}
+
+func parseBundleArchive(data []byte) (*functionBundle, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	files := map[string][]byte{}
+	var names []string
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("bad archive: %v", err)
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		content, err := io.ReadAll(io.LimitReader(tr, maxBundleBytes))
+		if err != nil {
+			return nil, fmt.Errorf("bad archive: %v", err)
+		}
+		name := strings.TrimPrefix(path.Clean(h.Name), "./")
+		files[name] = content
+		names = append(names, name)
+	}
+
+	manifest, ok := files[bundleManifest]
+	if !ok {
+		b := &functionBundle{Format: bundleFormat, Version: bundleVersion}
+		for _, name := range names {
+			if strings.HasSuffix(name, ".js") {
+				fnName, fnPath := importName(path.Base(name))
+				b.Functions = append(b.Functions, bundleFunction{Name: fnName, Path: fnPath, Mode: ModeStandard, Code: string(files[name])})
+			}
+		}
+		if len(b.Functions) == 0 {
+			return nil, fmt.Errorf("archive has no %s and no .js files", bundleManifest)
+		}
+		return b, nil
+	}
+
+	b, err := parseBundle(manifest)
+	if err != nil {
+		return nil, err
+	}
+	err = b.loadFiles("the archive", func(name string) ([]byte, bool) {
+		content, ok := files[name]
+		return content, ok
+	})
+	return b, err
+}
+
+// loadFiles moves the code of each function that names a file, and of its
+// modules, into the manifest. read looks a cleaned entry name up in source.
+func (b *functionBundle) loadFiles(source string, read func(name string) ([]byte, bool)) error {
+	for i, f := range b.Functions {
+		if f.File == "" {
+			continue
+		}
+		code, ok := read(path.Clean(f.File))
+		if !ok {
+			return fmt.Errorf("%s lists %s, which is not in %s", bundleManifest, f.File, source)
+		}
+		for j, m := range f.Files {
+			module, ok := read(path.Clean(moduleEntry(f.File, m.Name)))
+			if !ok {
+				return fmt.Errorf("%s lists module %s of %s, which is not in %s", bundleManifest, m.Name, f.File, source)
+			}
+			b.Functions[i].Files[j].Code = string(module)
+		}
+		b.Functions[i].Code, b.Functions[i].File = string(code), ""
+	}
+	return nil
+}
+
+// exportBundle serves GET /api/bundle, every function or the ones in
+// ?ids=1,2,3, as JSON or, with ?format=tar.gz, as an archive.
+func (app *App) exportBundle(c *gin.Context) {
+	functions, err := app.getAllFunctions()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list functions"})
+		return
+	}
+	var only map[int]bool
+	if ids := c.Query("ids"); ids != "" {
+		only = map[int]bool{}
+		for _, s := range strings.Split(ids, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID " + s})
+				return
+			}
+			only[id] = true
+		}
+	}
+
+	b := &functionBundle{Format: bundleFormat, Version: bundleVersion, ExportedAt: time.Now().UTC(), Functions: []bundleFunction{}}
+	for i := range functions {
+		if only == nil || only[functions[i].ID] {
+			b.Functions = append(b.Functions, bundleFunctionOf(&functions[i]))
+		}
+	}
+
+	name := "runbox-" + b.ExportedAt.Format("20060102-150405")
+	if c.Query("format") == "tar.gz" {
+		var buf bytes.Buffer
+		if err := writeBundleArchive(&buf, b); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write archive: " + err.Error()})
+			return
+		}
+		c.Header("Content-Disposition", `attachment; filename="`+name+`.tar.gz"`)
+		c.Data(http.StatusOK, "application/gzip", buf.Bytes())
+		return
+	}
+	c.Header("Content-Disposition", `attachment; filename="`+name+`.json"`)
+	c.IndentedJSON(http.StatusOK, b)
+}
+
+// bundlePreviewItem is one function in an uploaded bundle, with what
+// importing it would run into.
+type bundlePreviewItem struct {
+	bundleFunction
+	Errors []CodeDiagnostic `json:"errors"`
+	// Conflict is the existing function at the same path, if any.
+	Conflict *bundleConflict `json:"conflict,omitempty"`
+	// SuggestedPath is a free path to import it under instead.
+	SuggestedPath string `json:"suggestedPath,omitempty"`
+	// Action is the default resolution: create, overwrite or skip.
+	Action string `json:"action"`
+}
+
+type bundleConflict struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	Version   int    `json:"version"`
+	Identical bool   `json:"identical"`
+}
+
+// previewBundle serves POST /api/bundle/preview with a bundle file as the
+// body. Nothing is imported; the page picks what to import from the
+// preview and sends it to POST /api/bundle/import.
+func (app *App) previewBundle(c *gin.Context) {
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, maxBundleBytes+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read bundle"})
+		return
+	}
+	if len(data) > maxBundleBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Bundle is larger than 16 MiB"})
+		return
+	}
+	b, err := parseBundle(data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid bundle: " + err.Error()})
+		return
+	}
+
+	taken := map[string]bool{}
+	if existing, err := app.getAllFunctions(); err == nil {
+		for _, f := range existing {
+			taken[f.Path] = true
+		}
+	}
+
+	items := make([]bundlePreviewItem, 0, len(b.Functions))
+	for _, f := range b.Functions {
+		if f.Mode == "" {
+			f.Mode = ModeStandard
+		}
+		if f.Path != "" && !strings.HasPrefix(f.Path, "/") {
+			f.Path = "/" + f.Path
+		}
+		item := bundlePreviewItem{bundleFunction: f, Errors: []CodeDiagnostic{}, Action: importCreate}
+		if validMode(f.Mode) {
+			if diagnostics := validateFunctionCode(f.Code, f.Mode); diagnostics != nil {
+				item.Errors = diagnostics
+			}
+		}
+		if err := validateFunctionFiles(f.Files); err != nil {
+			item.Errors = append(item.Errors, CodeDiagnostic{Line: 1, Column: 1, Message: err.Error()})
+		}
+		if !validVisibility(f.Visibility) {
+			item.Errors = append(item.Errors, CodeDiagnostic{Line: 1, Column: 1, Message: "invalid visibility " + f.Visibility})
+		}
+
+		if current, err := app.getFunctionByPath(f.Path); err == nil {
+			item.Conflict = &bundleConflict{
+				ID:        current.ID,
+				Name:      current.Name,
+				Version:   current.Version,
+				Identical: f.matches(current),
+			}
+			item.Action = importOverwrite
+			if item.Conflict.Identical {
+				item.Action = importSkip
+			}
+			for n := 2; ; n++ {
+				candidate := f.Path + "-" + strconv.Itoa(n)
+				if !taken[candidate] {
+					item.SuggestedPath = candidate
+					taken[candidate] = true
+					break
+				}
+			}
+		}
+		if len(item.Errors) > 0 || f.Name == "" || f.Path == "" || f.Code == "" || !validMode(f.Mode) {
+			item.Action = importSkip
+		}
+		items = append(items, item)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"exportedAt": b.ExportedAt, "functions": items})
+}
+
+// importBundle serves POST /api/bundle/import with {functions: [...]},
+// each a bundle function with the action chosen for it. Items are imported
+// one by one; a failure is reported for that item and the rest go on.
+func (app *App) importBundle(c *gin.Context) {
+	var in struct {
+		Functions []struct {
+			bundleFunction
+			Action string `json:"action"`
+		} `json:"functions"`
+	}
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid import body"})
+		return
+	}
+
+	origin := app.auditOrigin(c)
+	results := make([]gin.H, 0, len(in.Functions))
+	counts := map[string]int{}
+	for _, item := range in.Functions {
+		f := item.bundleFunction
+		if f.Mode == "" {
+			f.Mode = ModeStandard
+		}
+		if f.Path != "" && !strings.HasPrefix(f.Path, "/") {
+			f.Path = "/" + f.Path
+		}
+		result := gin.H{"name": f.Name, "path": f.Path, "action": item.Action}
+
+		id, err := app.importBundleFunction(origin, f, item.Action)
+		if err != nil {
+			result["error"] = err.Error()
+			counts["failed"]++
+		} else {
+			if id != 0 {
+				result["id"] = id
+			}
+			counts[item.Action]++
+		}
+		results = append(results, result)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"results": results, "counts": counts})
+}
+
+func (app *App) importBundleFunction(origin auditOrigin, f bundleFunction, action string) (int, error) {
+	if action == importSkip {
+		return 0, nil
+	}
+	if f.Name == "" || f.Path == "" || f.Code == "" || !validMode(f.Mode) {
+		return 0, fmt.Errorf("name, path and code are required and mode must be valid")
+	}
+	if diagnostics := validateFunctionCode(f.Code, f.Mode); diagnostics != nil {
+		return 0, fmt.Errorf("syntax error at %s", diagnostics[0].String())
+	}
+	if err := validateFunctionFiles(f.Files); err != nil {
+		return 0, err
+	}
+	if !validVisibility(f.Visibility) {
+		return 0, fmt.Errorf("invalid visibility %s", f.Visibility)
+	}
+
+	switch action {
+	case importCreate:
+		if _, err := app.getFunctionByPath(f.Path); err == nil {
+			return 0, fmt.Errorf("a function already exists at %s", f.Path)
+		}
+		function := &Function{Name: f.Name, Path: f.Path, Code: f.Code, Description: f.Description, Mode: f.Mode, SourceURL: f.SourceURL, Tags: f.Tags, Files: f.Files, Visibility: f.Visibility}
+		if err := app.insertFunction(function); err != nil {
+			return 0, err
+		}
+		app.recordAudit(origin, AuditFunctionCreated, nil, function)
+		return function.ID, nil
+
+	case importOverwrite:
+		before, err := app.getFunctionByPath(f.Path)
+		if err != nil {
+			return 0, fmt.Errorf("no function at %s to overwrite", f.Path)
+		}
+		function := *before
+		function.Name, function.Code, function.Description, function.Mode = f.Name, f.Code, f.Description, f.Mode
+		function.Files, function.Visibility = f.Files, f.Visibility
+		if err := app.saveFunction(&function); err != nil {
+			return 0, err
+		}
+		if err := app.setFunctionVisibility(function.ID, function.Visibility); err != nil {
+			return 0, err
+		}
+		if f.Tags != nil {
+			if err := app.setFunctionTags(function.ID, f.Tags); err != nil {
+				return 0, err
+			}
+			function.Tags = normalizeTags(f.Tags)
+		}
+		app.recordAudit(origin, AuditFunctionUpdated, before, &function)
+		return function.ID, nil
+	}
+	return 0, fmt.Errorf("unknown action %q", action)
+}
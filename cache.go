@@ -0,0 +1,128 @@
+package runbox
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/robertkrimen/otto"
+)
+
+// cacheKeyPrefix namespaces runbox.cache keys in Redis, followed by the
+// function ID, so functions don't see each other's entries and other
+// users of the database aren't disturbed.
+const cacheKeyPrefix = "runbox:cache:"
+
+// jsCache exposes runbox.cache, a cache private to each function and
+// shared by every instance through the Redis server in redis.addr, for
+// state that must be fast or expire, such as counters and rate windows.
+// Values are stored as JSON, like runbox.kv, so counters kept with incr
+// read back as numbers.
+func (app *App) jsCache(vm *otto.Otto, exec *execution) *otto.Object {
+	cache, _ := vm.Object(`({})`)
+
+	client := func(call otto.FunctionCall, name string) (*redis.Client, string) {
+		if exec.function.ID == 0 {
+			throwError(call, "runbox.cache."+name+": save the function before using its cache")
+		}
+		k := call.Argument(0).String()
+		if !call.Argument(0).IsString() || k == "" {
+			throwError(call, "runbox.cache."+name+": key must be a non-empty string")
+		}
+		c, err := app.triggers.drivers["redis"].(*redisDriver).connection()
+		if err != nil {
+			throwError(call, "runbox.cache."+name+": "+err.Error())
+		}
+		return c, cacheKeyPrefix + strconv.Itoa(exec.function.ID) + ":" + k
+	}
+
+	// seconds reads an optional TTL argument; 0 means none.
+	seconds := func(call otto.FunctionCall, name string, arg otto.Value) time.Duration {
+		if !arg.IsDefined() || arg.IsNull() {
+			return 0
+		}
+		n, err := arg.ToFloat()
+		if err != nil || n < 0 {
+			throwError(call, "runbox.cache."+name+": seconds must be a non-negative number")
+		}
+		return time.Duration(n * float64(time.Second))
+	}
+
+	cache.Set("get", func(call otto.FunctionCall) otto.Value {
+		c, k := client(call, "get")
+		value, err := c.Get(exec.ctx, k).Result()
+		if err == redis.Nil {
+			return otto.NullValue()
+		}
+		if err != nil {
+			throwError(call, "runbox.cache.get: "+err.Error())
+		}
+		var decoded interface{}
+		json.Unmarshal([]byte(value), &decoded)
+		return toValue(call, decoded)
+	})
+
+	// set stores a value, expiring after the optional ttlSeconds.
+	cache.Set("set", func(call otto.FunctionCall) otto.Value {
+		c, k := client(call, "set")
+		exported, _ := call.Argument(1).Export()
+		value, err := json.Marshal(exported)
+		if err != nil || !call.Argument(1).IsDefined() {
+			throwError(call, "runbox.cache.set: value must be JSON-serializable")
+		}
+		if len(value) > maxKVValueBytes {
+			throwError(call, "runbox.cache.set: value is larger than 256 KiB")
+		}
+		ttl := seconds(call, "set", call.Argument(2))
+		if err := c.Set(exec.ctx, k, value, ttl).Err(); err != nil {
+			throwError(call, "runbox.cache.set: "+err.Error())
+		}
+		return otto.UndefinedValue()
+	})
+
+	// incr adds the optional integer by, 1 by default, to a counter that
+	// starts at 0, and returns the new value.
+	cache.Set("incr", func(call otto.FunctionCall) otto.Value {
+		c, k := client(call, "incr")
+		by := int64(1)
+		if arg := call.Argument(1); arg.IsDefined() && !arg.IsNull() {
+			n, err := arg.ToInteger()
+			if err != nil {
+				throwError(call, "runbox.cache.incr: by must be an integer")
+			}
+			by = n
+		}
+		n, err := c.IncrBy(exec.ctx, k, by).Result()
+		if err != nil {
+			throwError(call, "runbox.cache.incr: "+err.Error())
+		}
+		return toValue(call, n)
+	})
+
+	// expire sets a key to expire after seconds, returning whether it
+	// exists.
+	cache.Set("expire", func(call otto.FunctionCall) otto.Value {
+		c, k := client(call, "expire")
+		ttl := seconds(call, "expire", call.Argument(1))
+		if ttl == 0 {
+			throwError(call, "runbox.cache.expire: seconds must be positive")
+		}
+		ok, err := c.Expire(exec.ctx, k, ttl).Result()
+		if err != nil {
+			throwError(call, "runbox.cache.expire: "+err.Error())
+		}
+		return toValue(call, ok)
+	})
+
+	cache.Set("delete", func(call otto.FunctionCall) otto.Value {
+		c, k := client(call, "delete")
+		n, err := c.Del(exec.ctx, k).Result()
+		if err != nil {
+			throwError(call, "runbox.cache.delete: "+err.Error())
+		}
+		return toValue(call, n > 0)
+	})
+
+	return cache
+}
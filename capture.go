@@ -0,0 +1,575 @@
+package runbox
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultCaptureMaxBytes      = 1 << 20
+	maxCaptureMaxBytes          = 10 << 20
+	defaultCaptureRetentionDays = 7
+	captureSettingsTTL          = 30 * time.Second
+	redacted                    = "[REDACTED]"
+)
+
+// alwaysRedactedHeaders are redacted from every capture on top of the
+// function's own rules.
+var alwaysRedactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+
+// CaptureSettings opts a function into full request and response capture.
+// RedactHeaders lists header names and RedactFields body and query fields,
+// either a bare name matched at any depth or a dotted path where * matches
+// any key or array index.
+type CaptureSettings struct {
+	FunctionID    int       `json:"functionId"`
+	MaxBytes      int       `json:"maxBytes"`
+	RetentionDays int       `json:"retentionDays"`
+	RedactHeaders []string  `json:"redactHeaders"`
+	RedactFields  []string  `json:"redactFields"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+// Capture is one recorded exchange. Its ID is that of the execution log
+// entry of the same execution.
+type Capture struct {
+	ID              string            `json:"id"`
+	FunctionID      int               `json:"functionId"`
+	Version         int               `json:"version"`
+	Method          string            `json:"method"`
+	URL             string            `json:"url"`
+	RequestHeaders  map[string]string `json:"requestHeaders"`
+	RequestBody     string            `json:"requestBody,omitempty"`
+	Status          int               `json:"status,omitempty"`
+	ResponseHeaders map[string]string `json:"responseHeaders,omitempty"`
+	ResponseBody    string            `json:"responseBody,omitempty"`
+	Error           string            `json:"error,omitempty"`
+	Truncated       bool              `json:"truncated"`
+	DurationMs      int64             `json:"durationMs"`
+	CreatedAt       time.Time         `json:"createdAt"`
+}
+
+func (app *App) initCaptureTables() error {
+	createTables := `
+	CREATE TABLE IF NOT EXISTS capture_settings (
+		function_id INTEGER PRIMARY KEY REFERENCES functions(id) ON DELETE CASCADE,
+		max_bytes INTEGER NOT NULL,
+		retention_days INTEGER NOT NULL,
+		redact_headers TEXT NOT NULL DEFAULT '[]',
+		redact_fields TEXT NOT NULL DEFAULT '[]',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS captures (
+		id TEXT PRIMARY KEY,
+		function_id INTEGER NOT NULL REFERENCES functions(id) ON DELETE CASCADE,
+		version INTEGER NOT NULL,
+		method TEXT NOT NULL,
+		url TEXT NOT NULL,
+		request_headers TEXT NOT NULL,
+		request_body TEXT NOT NULL,
+		status INTEGER,
+		response_headers TEXT NOT NULL DEFAULT '{}',
+		response_body TEXT NOT NULL DEFAULT '',
+		error TEXT NOT NULL DEFAULT '',
+		truncated INTEGER NOT NULL DEFAULT 0,
+		duration_ms INTEGER NOT NULL,
+		created_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_captures_function ON captures (function_id, created_at);`
+
+	if _, err := app.db.Exec(createTables); err != nil {
+		return fmt.Errorf("failed to create capture tables: %v", err)
+	}
+	return nil
+}
+
+const captureSettingsColumns = `function_id, max_bytes, retention_days, redact_headers, redact_fields, created_at`
+
+func scanCaptureSettings(row rowScanner) (*CaptureSettings, error) {
+	var s CaptureSettings
+	var headers, fields string
+	if err := row.Scan(&s.FunctionID, &s.MaxBytes, &s.RetentionDays, &headers, &fields, &s.CreatedAt); err != nil {
+		return nil, err
+	}
+	s.RedactHeaders, s.RedactFields = []string{}, []string{}
+	json.Unmarshal([]byte(headers), &s.RedactHeaders)
+	json.Unmarshal([]byte(fields), &s.RedactFields)
+	return &s, nil
+}
+
+func (app *App) getCaptureSettings(functionID int) (*CaptureSettings, error) {
+	return scanCaptureSettings(app.db.QueryRow(`SELECT `+captureSettingsColumns+` FROM capture_settings WHERE function_id = ?`, functionID))
+}
+
+// captureCache holds every function's capture settings so executions of
+// functions without capture cost no query. Like latency targets, it is
+// reloaded every captureSettingsTTL.
+type captureCache struct {
+	mu       sync.Mutex
+	settings map[int]*CaptureSettings
+	loadedAt time.Time
+}
+
+func newCaptureCache() *captureCache {
+	return &captureCache{settings: map[int]*CaptureSettings{}}
+}
+
+func (cc *captureCache) invalidate() {
+	cc.mu.Lock()
+	cc.loadedAt = time.Time{}
+	cc.mu.Unlock()
+}
+
+func (app *App) captureSettingsFor(functionID int) *CaptureSettings {
+	cc := app.captures
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if time.Since(cc.loadedAt) > captureSettingsTTL {
+		if rows, err := app.db.Query(`SELECT ` + captureSettingsColumns + ` FROM capture_settings`); err == nil {
+			settings := map[int]*CaptureSettings{}
+			for rows.Next() {
+				if s, err := scanCaptureSettings(rows); err == nil {
+					settings[s.FunctionID] = s
+				}
+			}
+			rows.Close()
+			cc.settings = settings
+		}
+		cc.loadedAt = time.Now()
+	}
+	return cc.settings[functionID]
+}
+
+// captureExecution records the full, redacted exchange of an execution if
+// its function has capture enabled. The insert runs in the background.
+func (app *App) captureExecution(exec *execution, started time.Time, result interface{}, execErr error) {
+	settings := app.captureSettingsFor(exec.function.ID)
+	if settings == nil {
+		return
+	}
+	r := newRedactor(settings)
+
+	truncated := false
+	cut := func(s string) string {
+		if len(s) > settings.MaxBytes {
+			truncated = true
+			return strings.ToValidUTF8(s[:settings.MaxBytes], "")
+		}
+		return s
+	}
+
+	c := &Capture{
+		ID:             exec.id,
+		FunctionID:     exec.function.ID,
+		Version:        exec.function.Version,
+		RequestHeaders: map[string]string{},
+		DurationMs:     time.Since(started).Milliseconds(),
+		CreatedAt:      started.UTC(),
+	}
+	c.Method, _ = exec.request["method"].(string)
+	c.URL, _ = exec.request["url"].(string)
+	if c.URL == "" {
+		c.URL, _ = exec.request["path"].(string)
+	}
+	c.URL = r.url(c.URL)
+
+	contentType := ""
+	switch headers := exec.request["headers"].(type) {
+	case map[string]string:
+		for k, v := range headers {
+			c.RequestHeaders[k] = r.header(k, v)
+			if strings.EqualFold(k, "Content-Type") {
+				contentType = v
+			}
+		}
+	case map[string]interface{}:
+		for k, v := range headers {
+			if s, ok := v.(string); ok {
+				c.RequestHeaders[k] = r.header(k, s)
+				if strings.EqualFold(k, "Content-Type") {
+					contentType = s
+				}
+			}
+		}
+	}
+	rawBody, _ := exec.request["rawBody"].(string)
+	c.RequestBody = cut(r.body(rawBody, contentType))
+
+	if execErr != nil {
+		c.Error = execErr.Error()
+	} else if resp, err := responseFromResult(result); err == nil {
+		c.Status = resp.Status
+		c.ResponseHeaders = map[string]string{}
+		for k, v := range resp.Headers {
+			c.ResponseHeaders[k] = r.header(k, v)
+		}
+		c.ResponseBody = cut(r.body(resp.Body, resp.ContentType()))
+	}
+	c.Truncated = truncated
+
+	app.goBackground(func() { app.insertCapture(c) })
+}
+
+func (app *App) insertCapture(c *Capture) {
+	requestHeaders, _ := json.Marshal(c.RequestHeaders)
+	responseHeaders, _ := json.Marshal(c.ResponseHeaders)
+	var status sql.NullInt64
+	if c.Status != 0 {
+		status = sql.NullInt64{Int64: int64(c.Status), Valid: true}
+	}
+
+	_, err := app.db.Exec(`INSERT INTO captures (`+captureColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		c.ID, c.FunctionID, c.Version, c.Method, c.URL, string(requestHeaders), c.RequestBody, status,
+		string(responseHeaders), c.ResponseBody, c.Error, c.Truncated, c.DurationMs, c.CreatedAt)
+	if err != nil {
+		log.Printf("Failed to capture execution of function %d: %v", c.FunctionID, err)
+	}
+}
+
+// redactor applies a function's redaction rules.
+type redactor struct {
+	headers map[string]bool
+	names   map[string]bool
+	paths   [][]string
+}
+
+func newRedactor(s *CaptureSettings) *redactor {
+	r := &redactor{headers: map[string]bool{}, names: map[string]bool{}}
+	for _, h := range append(append([]string{}, alwaysRedactedHeaders...), s.RedactHeaders...) {
+		r.headers[strings.ToLower(h)] = true
+	}
+	for _, f := range s.RedactFields {
+		if strings.Contains(f, ".") {
+			r.paths = append(r.paths, strings.Split(f, "."))
+		} else {
+			r.names[strings.ToLower(f)] = true
+		}
+	}
+	return r
+}
+
+func (r *redactor) header(name, value string) string {
+	if r.headers[strings.ToLower(name)] {
+		return redacted
+	}
+	return value
+}
+
+func (r *redactor) matches(path []string) bool {
+	if r.names[strings.ToLower(path[len(path)-1])] {
+		return true
+	}
+	for _, p := range r.paths {
+		if len(p) != len(path) {
+			continue
+		}
+		match := true
+		for i := range p {
+			if p[i] != "*" && p[i] != path[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// value redacts a decoded JSON value in place and returns it.
+func (r *redactor) value(v interface{}, path []string) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			p := append(path[:len(path):len(path)], k)
+			if r.matches(p) {
+				v[k] = redacted
+			} else {
+				v[k] = r.value(child, p)
+			}
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = r.value(child, append(path[:len(path):len(path)], strconv.Itoa(i)))
+		}
+	}
+	return v
+}
+
+// body redacts JSON and form-encoded bodies; anything else is kept as is.
+func (r *redactor) body(body, contentType string) string {
+	if body == "" || (len(r.names) == 0 && len(r.paths) == 0) {
+		return body
+	}
+
+	var decoded interface{}
+	if json.Unmarshal([]byte(body), &decoded) == nil {
+		out, err := json.Marshal(r.value(decoded, nil))
+		if err == nil {
+			return string(out)
+		}
+	}
+	if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		if form, err := url.ParseQuery(body); err == nil {
+			return r.form(form).Encode()
+		}
+	}
+	return body
+}
+
+// savedRequest redacts the headers, query and body of a saved request,
+// which is a copy: its headers are replaced rather than changed.
+func (r *redactor) savedRequest(req *SavedRequest) {
+	headers := make(map[string]string, len(req.Headers))
+	contentType := ""
+	for name, value := range req.Headers {
+		if strings.EqualFold(name, "Content-Type") {
+			contentType = value
+		}
+		headers[name] = r.header(name, value)
+	}
+	req.Headers = headers
+	if query, err := url.ParseQuery(req.Query); err == nil && req.Query != "" && (len(r.names) > 0 || len(r.paths) > 0) {
+		req.Query = r.form(query).Encode()
+	}
+	req.Body = r.body(req.Body, contentType)
+}
+
+func (r *redactor) form(values url.Values) url.Values {
+	for k, vs := range values {
+		if r.matches([]string{k}) {
+			for i := range vs {
+				vs[i] = redacted
+			}
+		}
+	}
+	return values
+}
+
+func (r *redactor) url(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.RawQuery == "" {
+		return raw
+	}
+	u.RawQuery = r.form(u.Query()).Encode()
+	return u.String()
+}
+
+// pruneCaptures drops captures past their function's retention, or the
+// default retention once capture was turned off, at most once an hour.
+func (app *App) pruneCaptures(now time.Time) {
+	if !app.periodic.due("capture-prune", now, time.Hour) {
+		return
+	}
+
+	retention := map[int]int{}
+	if rows, err := app.db.Query(`SELECT function_id, retention_days FROM capture_settings`); err == nil {
+		for rows.Next() {
+			var id, days int
+			if rows.Scan(&id, &days) == nil {
+				retention[id] = days
+			}
+		}
+		rows.Close()
+	}
+
+	for id, days := range retention {
+		app.db.Exec(`DELETE FROM captures WHERE function_id = ? AND created_at < ?`, id, now.AddDate(0, 0, -days))
+	}
+	_, err := app.db.Exec(`DELETE FROM captures WHERE function_id NOT IN (SELECT function_id FROM capture_settings) AND created_at < ?`,
+		now.AddDate(0, 0, -defaultCaptureRetentionDays))
+	if err != nil {
+		log.Println("Failed to prune captures:", err)
+	}
+}
+
+const captureColumns = `id, function_id, version, method, url, request_headers, request_body, status, response_headers, response_body, error, truncated, duration_ms, created_at`
+
+func scanCapture(row rowScanner) (*Capture, error) {
+	var (
+		c               Capture
+		requestHeaders  string
+		responseHeaders string
+		status          sql.NullInt64
+	)
+	err := row.Scan(&c.ID, &c.FunctionID, &c.Version, &c.Method, &c.URL, &requestHeaders, &c.RequestBody, &status,
+		&responseHeaders, &c.ResponseBody, &c.Error, &c.Truncated, &c.DurationMs, &c.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	c.Status = int(status.Int64)
+	json.Unmarshal([]byte(requestHeaders), &c.RequestHeaders)
+	json.Unmarshal([]byte(responseHeaders), &c.ResponseHeaders)
+	return &c, nil
+}
+
+func (app *App) getCaptureSettingsHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+
+	s, err := app.getCaptureSettings(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Capture is not enabled for this function"})
+		return
+	}
+
+	c.JSON(http.StatusOK, s)
+}
+
+func (app *App) setCaptureSettings(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+	if _, err := app.getFunctionByID(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Function not found"})
+		return
+	}
+
+	var in struct {
+		MaxBytes      int      `json:"maxBytes"`
+		RetentionDays int      `json:"retentionDays"`
+		RedactHeaders []string `json:"redactHeaders"`
+		RedactFields  []string `json:"redactFields"`
+	}
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid capture body"})
+		return
+	}
+	if in.MaxBytes == 0 {
+		in.MaxBytes = defaultCaptureMaxBytes
+	}
+	if in.MaxBytes < 1 || in.MaxBytes > maxCaptureMaxBytes {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "maxBytes must be between 1 and " + strconv.Itoa(maxCaptureMaxBytes)})
+		return
+	}
+	if in.RetentionDays == 0 {
+		in.RetentionDays = defaultCaptureRetentionDays
+	}
+	if in.RetentionDays < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "retentionDays must be at least 1"})
+		return
+	}
+	if in.RedactHeaders == nil {
+		in.RedactHeaders = []string{}
+	}
+	if in.RedactFields == nil {
+		in.RedactFields = []string{}
+	}
+	headers, _ := json.Marshal(in.RedactHeaders)
+	fields, _ := json.Marshal(in.RedactFields)
+
+	_, err = app.db.Exec(`INSERT INTO capture_settings (function_id, max_bytes, retention_days, redact_headers, redact_fields) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (function_id) DO UPDATE SET max_bytes = excluded.max_bytes, retention_days = excluded.retention_days,
+			redact_headers = excluded.redact_headers, redact_fields = excluded.redact_fields`,
+		id, in.MaxBytes, in.RetentionDays, string(headers), string(fields))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save capture settings: " + err.Error()})
+		return
+	}
+	app.captures.invalidate()
+	app.broadcastChange(changeSettings, id)
+
+	s, _ := app.getCaptureSettings(id)
+	c.JSON(http.StatusOK, s)
+}
+
+// deleteCaptureSettings turns capture off. Existing captures are kept for
+// the default retention.
+func (app *App) deleteCaptureSettings(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+
+	if _, err := app.db.Exec(`DELETE FROM capture_settings WHERE function_id = ?`, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable capture"})
+		return
+	}
+	app.captures.invalidate()
+	app.broadcastChange(changeSettings, id)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Capture disabled"})
+}
+
+// listCaptures serves GET /api/functions/:id/captures, newest first,
+// without the bodies.
+func (app *App) listCaptures(c *gin.Context) {
+	functionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+
+	limit, offset := 50, 0
+	if v := c.Query("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > 500 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 500"})
+			return
+		}
+	}
+	if v := c.Query("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+			return
+		}
+	}
+
+	rows, err := app.db.Query(`SELECT id, method, url, status, error, truncated, length(request_body), length(response_body), duration_ms, created_at
+		FROM captures WHERE function_id = ? ORDER BY created_at DESC LIMIT ? OFFSET ?`, functionID, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list captures"})
+		return
+	}
+	defer rows.Close()
+
+	captures := []gin.H{}
+	for rows.Next() {
+		var (
+			id, method, url, errMsg string
+			status                  sql.NullInt64
+			truncated               bool
+			requestBytes            int
+			responseBytes           int
+			durationMs              int64
+			createdAt               time.Time
+		)
+		if err := rows.Scan(&id, &method, &url, &status, &errMsg, &truncated, &requestBytes, &responseBytes, &durationMs, &createdAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list captures"})
+			return
+		}
+		captures = append(captures, gin.H{
+			"id": id, "method": method, "url": url, "status": status.Int64, "error": errMsg, "truncated": truncated,
+			"requestBytes": requestBytes, "responseBytes": responseBytes, "durationMs": durationMs, "createdAt": createdAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"captures": captures})
+}
+
+func (app *App) getCaptureHandler(c *gin.Context) {
+	capture, err := scanCapture(app.db.QueryRow(`SELECT `+captureColumns+` FROM captures WHERE id = ?`, c.Param("id")))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Capture not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, capture)
+}
@@ -0,0 +1,174 @@
+package runbox
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const maxTagLength = 32
+
+var (
+	tagCleaner = regexp.MustCompile(`[^a-z0-9._-]+`)
+	// Handlers a standard function defines, as GET(...) functions or
+	// app.get(...) routes.
+	methodFunctionPattern = regexp.MustCompile(`\bfunction\s+(GET|POST|PUT|PATCH|DELETE|HEAD|OPTIONS)\s*\(`)
+	methodRoutePattern    = regexp.MustCompile(`\bapp\s*\.\s*(get|post|put|patch|delete|head|options|all)\s*\(`)
+	defaultHandlerPattern = regexp.MustCompile(`\bfunction\s+default\s*\(|\[\s*['"]default['"]\s*\]\s*=`)
+)
+
+// httpMethods orders the method filter on the home page; ANY stands for a
+// handler that takes every method.
+var httpMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS", "ANY"}
+
+// parseTags reads a comma- or space-separated tag list from a form.
+func parseTags(s string) []string {
+	return normalizeTags(strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' }))
+}
+
+// normalizeTags lowercases tags, keeps them to letters, digits, dots,
+// dashes and underscores, and drops empty and repeated ones.
+func normalizeTags(tags []string) []string {
+	out := []string{}
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = strings.Trim(tagCleaner.ReplaceAllString(strings.ToLower(strings.TrimSpace(tag)), "-"), "-")
+		if len(tag) > maxTagLength {
+			tag = tag[:maxTagLength]
+		}
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		out = append(out, tag)
+	}
+	return out
+}
+
+func (app *App) setFunctionTags(id int, tags []string) error {
+	_, err := app.db.Exec(`UPDATE functions SET tags = ? WHERE id = ?`, strings.Join(normalizeTags(tags), ","), id)
+	return err
+}
+
+// functionMethods guesses from the code which HTTP methods a function
+// answers. Lambda and Workers handlers, routers with app.all, and default
+// handlers take any method; GraphQL takes GET and POST.
+func functionMethods(f *Function) []string {
+	if f.Mode == ModeLambda || f.Mode == ModeWorkers {
+		return []string{"ANY"}
+	}
+	if f.Mode == ModeGraphQL {
+		return []string{"GET", "POST"}
+	}
+	found := map[string]bool{}
+	for _, m := range methodFunctionPattern.FindAllStringSubmatch(f.Code, -1) {
+		found[m[1]] = true
+	}
+	for _, m := range methodRoutePattern.FindAllStringSubmatch(f.Code, -1) {
+		if m[1] == "all" {
+			found["ANY"] = true
+		} else {
+			found[strings.ToUpper(m[1])] = true
+		}
+	}
+	if defaultHandlerPattern.MatchString(f.Code) {
+		found["ANY"] = true
+	}
+	methods := []string{}
+	for _, m := range httpMethods {
+		if found[m] {
+			methods = append(methods, m)
+		}
+	}
+	return methods
+}
+
+// functionsUpdatedAt returns when each function's current version was saved.
+func (app *App) functionsUpdatedAt() (map[int]time.Time, error) {
+	rows, err := app.db.Query(`SELECT v.function_id, v.created_at FROM function_versions v
+		JOIN functions f ON f.id = v.function_id AND f.version = v.version`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	updated := map[int]time.Time{}
+	for rows.Next() {
+		var id int
+		var at time.Time
+		if err := rows.Scan(&id, &at); err != nil {
+			return nil, err
+		}
+		updated[id] = at
+	}
+	return updated, nil
+}
+
+// functionListing is one card on the home page.
+type functionListing struct {
+	Function
+	Methods   []string
+	UpdatedAt time.Time
+	Metrics   map[string]interface{}
+	ErrorRate float64
+	Private   bool
+	// Hidden is set on listings the filter leaves out. They are still
+	// rendered, so the page can filter again without a round trip.
+	Hidden bool
+}
+
+// functionFilter is the home page's search, filters and sort order, read
+// from its query string so a filtered view can be bookmarked.
+type functionFilter struct {
+	Query  string
+	Tag    string
+	Method string
+	Sort   string // name, path, updated or errors
+	Desc   bool
+}
+
+func (f functionFilter) matches(l *functionListing) bool {
+	if q := strings.ToLower(f.Query); q != "" &&
+		!strings.Contains(strings.ToLower(l.Name), q) &&
+		!strings.Contains(strings.ToLower(l.Path), q) &&
+		!strings.Contains(strings.ToLower(l.Description), q) {
+		return false
+	}
+	if f.Tag != "" && !contains(l.Tags, f.Tag) {
+		return false
+	}
+	if f.Method != "" && !contains(l.Methods, f.Method) && !contains(l.Methods, "ANY") {
+		return false
+	}
+	return true
+}
+
+// apply sorts listings in place, hiding the ones that don't match, and
+// returns how many do.
+func (f functionFilter) apply(listings []functionListing) int {
+	shown := 0
+	for i := range listings {
+		listings[i].Hidden = !f.matches(&listings[i])
+		if !listings[i].Hidden {
+			shown++
+		}
+	}
+	out := listings
+
+	less := func(a, b *functionListing) bool { return strings.ToLower(a.Name) < strings.ToLower(b.Name) }
+	switch f.Sort {
+	case "path":
+		less = func(a, b *functionListing) bool { return a.Path < b.Path }
+	case "updated":
+		less = func(a, b *functionListing) bool { return a.UpdatedAt.Before(b.UpdatedAt) }
+	case "errors":
+		less = func(a, b *functionListing) bool { return a.ErrorRate < b.ErrorRate }
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if f.Desc {
+			return less(&out[j], &out[i])
+		}
+		return less(&out[i], &out[j])
+	})
+	return shown
+}
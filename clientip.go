@@ -0,0 +1,64 @@
+package runbox
+
+import (
+	"net"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// configureClientIP sets whose forwarding headers are believed when
+// resolving a request's client IP, for request.ip, the rate limit, the
+// access log and the audit log. Only peers in trustedProxies, IPs or
+// CIDRs, may set it with remoteIPHeaders (default X-Forwarded-For, then
+// X-Real-IP); without any, the client IP is the connection's peer address.
+func configureClientIP(r *gin.Engine, config Config) error {
+	if err := r.SetTrustedProxies(config.TrustedProxies); err != nil {
+		return err
+	}
+	if len(config.RemoteIPHeaders) > 0 {
+		r.RemoteIPHeaders = config.RemoteIPHeaders
+	}
+	return nil
+}
+
+// parseTrustedProxies turns trustedProxies into networks, a single IP
+// being a network of one address.
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, &net.ParseError{Type: "IP address", Text: proxy}
+			}
+			bits := 128
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, network)
+	}
+	return nets, nil
+}
+
+// fromTrustedProxy reports whether the request's peer is one of
+// trustedProxies, whose headers may speak for the client.
+func (app *App) fromTrustedProxy(c *gin.Context) bool {
+	ip := net.ParseIP(c.RemoteIP())
+	if ip == nil {
+		return false
+	}
+	for _, network := range app.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,363 @@
+package runbox
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const cloudEventsContentType = "application/cloudevents+json"
+
+type CloudEventRoute struct {
+	ID         int       `json:"id"`
+	EventType  string    `json:"type"`
+	FunctionID int       `json:"functionId"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+type CloudEvent struct {
+	SpecVersion     string                 `json:"specversion"`
+	ID              string                 `json:"id"`
+	Source          string                 `json:"source"`
+	Type            string                 `json:"type"`
+	Subject         string                 `json:"subject,omitempty"`
+	Time            string                 `json:"time,omitempty"`
+	DataContentType string                 `json:"datacontenttype,omitempty"`
+	DataSchema      string                 `json:"dataschema,omitempty"`
+	Data            interface{}            `json:"data,omitempty"`
+	Extensions      map[string]interface{} `json:"extensions"`
+}
+
+var cloudEventAttributes = map[string]bool{
+	"specversion":     true,
+	"id":              true,
+	"source":          true,
+	"type":            true,
+	"subject":         true,
+	"time":            true,
+	"datacontenttype": true,
+	"dataschema":      true,
+	"data":            true,
+	"data_base64":     true,
+}
+
+func (app *App) initCloudEventsTable() error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS cloudevent_routes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		event_type TEXT NOT NULL,
+		function_id INTEGER NOT NULL REFERENCES functions(id) ON DELETE CASCADE,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (event_type, function_id)
+	);`
+
+	if _, err := app.db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create cloudevent_routes table: %v", err)
+	}
+	return nil
+}
+
+// matchesEventType reports whether a route pattern accepts an event type.
+// A trailing "*" matches any suffix, so "com.github.*" accepts
+// "com.github.push".
+func matchesEventType(pattern, eventType string) bool {
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(eventType, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == eventType
+}
+
+func (app *App) getCloudEventRoutes() ([]CloudEventRoute, error) {
+	rows, err := app.db.Query(`SELECT id, event_type, function_id, created_at FROM cloudevent_routes ORDER BY event_type, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	routes := []CloudEventRoute{}
+	for rows.Next() {
+		var r CloudEventRoute
+		if err := rows.Scan(&r.ID, &r.EventType, &r.FunctionID, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		routes = append(routes, r)
+	}
+
+	return routes, nil
+}
+
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+func decodeEventData(data []byte, contentType string) interface{} {
+	if len(data) == 0 {
+		return nil
+	}
+	if contentType == "" || isJSONContentType(contentType) {
+		var v interface{}
+		if err := json.Unmarshal(data, &v); err == nil {
+			return v
+		}
+	}
+	return string(data)
+}
+
+// parseCloudEvent decodes an HTTP request in either structured or binary
+// content mode as defined by the CloudEvents HTTP protocol binding.
+func parseCloudEvent(header http.Header, body []byte) (*CloudEvent, error) {
+	event := &CloudEvent{Extensions: map[string]interface{}{}}
+
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	if mediaType == cloudEventsContentType {
+		var raw map[string]interface{}
+		if err := json.Unmarshal(body, &raw); err != nil {
+			return nil, err
+		}
+
+		attr := func(name string) string {
+			s, _ := raw[name].(string)
+			return s
+		}
+		event.SpecVersion = attr("specversion")
+		event.ID = attr("id")
+		event.Source = attr("source")
+		event.Type = attr("type")
+		event.Subject = attr("subject")
+		event.Time = attr("time")
+		event.DataContentType = attr("datacontenttype")
+		event.DataSchema = attr("dataschema")
+
+		if encoded := attr("data_base64"); encoded != "" {
+			decoded, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				return nil, err
+			}
+			event.Data = decodeEventData(decoded, event.DataContentType)
+		} else {
+			event.Data = raw["data"]
+		}
+
+		for name, value := range raw {
+			if !cloudEventAttributes[name] {
+				event.Extensions[name] = value
+			}
+		}
+	} else {
+		for name, values := range header {
+			lower := strings.ToLower(name)
+			if !strings.HasPrefix(lower, "ce-") || len(values) == 0 {
+				continue
+			}
+			attribute := strings.TrimPrefix(lower, "ce-")
+			switch attribute {
+			case "specversion":
+				event.SpecVersion = values[0]
+			case "id":
+				event.ID = values[0]
+			case "source":
+				event.Source = values[0]
+			case "type":
+				event.Type = values[0]
+			case "subject":
+				event.Subject = values[0]
+			case "time":
+				event.Time = values[0]
+			case "dataschema":
+				event.DataSchema = values[0]
+			default:
+				event.Extensions[attribute] = values[0]
+			}
+		}
+		event.DataContentType = header.Get("Content-Type")
+		event.Data = decodeEventData(body, event.DataContentType)
+	}
+
+	return event, nil
+}
+
+func (event *CloudEvent) toMap() map[string]interface{} {
+	return map[string]interface{}{
+		"specversion":     event.SpecVersion,
+		"id":              event.ID,
+		"source":          event.Source,
+		"type":            event.Type,
+		"subject":         event.Subject,
+		"time":            event.Time,
+		"datacontenttype": event.DataContentType,
+		"dataschema":      event.DataSchema,
+		"data":            event.Data,
+		"extensions":      event.Extensions,
+	}
+}
+
+func (app *App) ingestCloudEvent(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	event, err := parseCloudEvent(c.Request.Header, body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid CloudEvent", "details": err.Error()})
+		return
+	}
+	if event.SpecVersion == "" || event.ID == "" || event.Source == "" || event.Type == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "CloudEvent requires specversion, id, source, and type"})
+		return
+	}
+
+	routes, err := app.getCloudEventRoutes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load event routes"})
+		return
+	}
+
+	trusted := app.takeBearerToken(c)
+	// Handlers can change the request they get, so each delivery gets one
+	// built afresh from the body.
+	deliveryRequest := func() map[string]interface{} {
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		requestData := buildRequestData(c)
+		event, _ := parseCloudEvent(c.Request.Header, body)
+		requestData["event"] = event.toMap()
+		if data, ok := event.Data.(map[string]interface{}); ok {
+			requestData["body"] = data
+		}
+		return requestData
+	}
+
+	deliveries := []gin.H{}
+	succeeded, failed := 0, false
+	denied, throttled := 0, []*quotaBreach{}
+	for _, route := range routes {
+		if !matchesEventType(route.EventType, event.Type) {
+			continue
+		}
+
+		function, err := app.getFunctionByID(route.FunctionID)
+		if err != nil {
+			continue
+		}
+		if !trusted && app.visibilityOf(function) == VisibilityPrivate {
+			denied++
+			deliveries = append(deliveries, gin.H{"function": function.Name, "status": "unauthorized", "error": "This function is private; send Authorization: Bearer <token>"})
+			continue
+		}
+
+		if breach := app.overQuota(function); breach != nil {
+			throttled = append(throttled, breach)
+			deliveries = append(deliveries, gin.H{"function": function.Name, "status": "throttled", "error": breach.Error()})
+			continue
+		}
+
+		requestData := deliveryRequest()
+		app.logEvent(JobSourceCloudEvent, event.Type, function, requestData, "")
+		ctx, cancel := app.live().executionContext(c.Request.Context())
+		exec := newExecution(ctx, function, requestData)
+		exec.source = JobSourceCloudEvent
+		result, err := app.executeJavaScript(exec)
+		cancel()
+		if err != nil {
+			failed = true
+			deliveries = append(deliveries, gin.H{"function": function.Name, "status": JobFailed, "error": err.Error()})
+			continue
+		}
+		succeeded++
+		deliveries = append(deliveries, gin.H{"function": function.Name, "status": JobSucceeded, "result": result})
+	}
+
+	if len(deliveries) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No function is routed for event type " + event.Type})
+		return
+	}
+
+	// A non-2xx status tells brokers such as Knative to redeliver the event,
+	// which would run it again on the functions it succeeded on, so once
+	// any delivery succeeded the rest are only reported in deliveries.
+	status := http.StatusOK
+	switch {
+	case succeeded > 0:
+	case failed:
+		status = http.StatusInternalServerError
+	case len(throttled) > 0:
+		// Redelivered once the first of the quotas resets.
+		retry := throttled[0]
+		for _, b := range throttled[1:] {
+			if b.reset.Before(retry.reset) {
+				retry = b
+			}
+		}
+		c.Header("Retry-After", retry.retryAfter())
+		status = http.StatusTooManyRequests
+	case denied == len(deliveries):
+		c.Header("WWW-Authenticate", `Bearer realm="runbox"`)
+		status = http.StatusUnauthorized
+	}
+	c.JSON(status, gin.H{"id": event.ID, "type": event.Type, "deliveries": deliveries})
+}
+
+func (app *App) listCloudEventRoutes(c *gin.Context) {
+	routes, err := app.getCloudEventRoutes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list event routes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"routes": routes})
+}
+
+func (app *App) createCloudEventRoute(c *gin.Context) {
+	var route CloudEventRoute
+	if err := c.ShouldBindJSON(&route); err != nil || route.EventType == "" || route.FunctionID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type and functionId are required"})
+		return
+	}
+
+	if _, err := app.getFunctionByID(route.FunctionID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Function not found"})
+		return
+	}
+
+	result, err := app.db.Exec(`INSERT INTO cloudevent_routes (event_type, function_id) VALUES (?, ?)`, route.EventType, route.FunctionID)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Failed to create event route: " + err.Error()})
+		return
+	}
+
+	id, _ := result.LastInsertId()
+	route.ID = int(id)
+	route.CreatedAt = time.Now().UTC()
+
+	c.JSON(http.StatusCreated, route)
+}
+
+func (app *App) deleteCloudEventRoute(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid route ID"})
+		return
+	}
+
+	if _, err := app.db.Exec(`DELETE FROM cloudevent_routes WHERE id = ?`, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete event route"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Event route deleted successfully"})
+}
@@ -0,0 +1,73 @@
+package runbox
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIngestCloudEvent(t *testing.T) {
+	const event = `{"specversion":"1.0","id":"1","source":"test","type":"order.created","data":{"total":10}}`
+
+	tests := []struct {
+		name       string
+		handlers   []string
+		wantStatus int
+		wantTotals []interface{} // what each delivery's handler saw
+	}{
+		{
+			"handlers don't see each other's changes",
+			[]string{
+				`function POST(request) { request.body.total = 99; request.event.data.total = 99; return request.body.total; }`,
+				`function POST(request) { return request.body.total + request.event.data.total; }`,
+			},
+			http.StatusOK, []interface{}{float64(99), float64(20)},
+		},
+		{
+			"a failure after a success isn't redelivered",
+			[]string{
+				`function POST(request) { return request.body.total; }`,
+				`function POST(request) { throw new Error("down"); }`,
+			},
+			http.StatusOK, []interface{}{float64(10), nil},
+		},
+		{
+			"only failures are redelivered",
+			[]string{`function POST(request) { throw new Error("down"); }`},
+			http.StatusInternalServerError, []interface{}{nil},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, nil)
+			for i, code := range tt.handlers {
+				function := newTestFunction(t, s.app, "/handler"+string(rune('a'+i)), code)
+				if _, err := s.app.db.Exec(`INSERT INTO cloudevent_routes (event_type, function_id) VALUES (?, ?)`, "order.*", function.ID); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/cloudevents", strings.NewReader(event))
+			req.Header.Set("Content-Type", "application/cloudevents+json")
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+
+			var out struct {
+				Deliveries []map[string]interface{} `json:"deliveries"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil || len(out.Deliveries) != len(tt.handlers) {
+				t.Fatalf("deliveries = %s", w.Body.String())
+			}
+			for i, want := range tt.wantTotals {
+				if got := out.Deliveries[i]["result"]; got != want {
+					t.Errorf("delivery %d result = %v, want %v", i, got, want)
+				}
+			}
+		})
+	}
+}
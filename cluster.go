@@ -0,0 +1,130 @@
+package runbox
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Kinds of change an instance tells the rest of the cluster about, so
+// they drop whatever they keep in memory about it.
+const (
+	changeFunction = "function"
+	changeSettings = "settings"
+	changeTriggers = "triggers"
+)
+
+const (
+	defaultChangeSyncInterval = time.Second
+	clusterChangesRetention   = time.Hour
+)
+
+func (app *App) initClusterChangesTable() error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS cluster_changes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		kind TEXT NOT NULL,
+		function_id INTEGER NOT NULL DEFAULT 0,
+		instance TEXT NOT NULL,
+		created_at DATETIME NOT NULL
+	);`
+
+	if _, err := app.db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create cluster changes table: %v", err)
+	}
+	return nil
+}
+
+// broadcastChange records a change for the other instances to pick up.
+// Without clustering there is no one to tell.
+func (app *App) broadcastChange(kind string, functionID int) {
+	if !app.leader.clustered {
+		return
+	}
+	_, err := app.db.Exec(`INSERT INTO cluster_changes (kind, function_id, instance, created_at) VALUES (?, ?, ?, ?)`,
+		kind, functionID, app.leader.instanceID, time.Now().UTC())
+	if err != nil {
+		log.Printf("Failed to broadcast %s change: %v", kind, err)
+	}
+}
+
+// startChangeSync polls for changes made by other instances. It starts
+// after the newest change, since a fresh instance loads everything anyway.
+func (app *App) startChangeSync() {
+	if !app.leader.clustered {
+		return
+	}
+
+	interval := defaultChangeSyncInterval
+	if ms := app.config.Cluster.SyncMilliseconds; ms > 0 {
+		interval = time.Duration(ms) * time.Millisecond
+	}
+
+	var lastID int64
+	app.db.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM cluster_changes`).Scan(&lastID)
+
+	app.every(interval, func(time.Time) {
+		lastID = app.applyClusterChanges(lastID)
+	})
+}
+
+// applyClusterChanges applies the changes after lastID and returns the
+// newest ID seen. Each kind is applied once per poll however often it
+// changed.
+func (app *App) applyClusterChanges(lastID int64) int64 {
+	rows, err := app.db.Query(`SELECT id, kind, function_id FROM cluster_changes WHERE id > ? AND instance != ? ORDER BY id`,
+		lastID, app.leader.instanceID)
+	if err != nil {
+		log.Println("Failed to load cluster changes:", err)
+		return lastID
+	}
+	functions := map[int]bool{}
+	settings, triggers := false, false
+	for rows.Next() {
+		var id int64
+		var kind string
+		var functionID int
+		if err := rows.Scan(&id, &kind, &functionID); err != nil {
+			continue
+		}
+		lastID = id
+		switch kind {
+		case changeFunction:
+			functions[functionID] = true
+		case changeSettings:
+			settings = true
+		case changeTriggers:
+			triggers = true
+		}
+	}
+	rows.Close()
+
+	for id := range functions {
+		app.scripts.forget(id)
+	}
+	if settings {
+		app.captures.invalidate()
+		app.quotas.invalidate()
+		app.slos.invalidate()
+		app.maintenance.invalidate()
+		app.renders.invalidate()
+		app.protos.invalidate()
+	}
+	if triggers {
+		app.syncTriggers()
+	}
+	return lastID
+}
+
+// pruneClusterChanges runs on the leader. Instances poll far more often
+// than changes are kept, so only a stopped instance misses any, and it
+// reloads everything when it starts.
+func (app *App) pruneClusterChanges(now time.Time) {
+	if !app.periodic.due("cluster-changes-prune", now, time.Minute) {
+		return
+	}
+
+	if _, err := app.db.Exec(`DELETE FROM cluster_changes WHERE created_at < ?`, now.Add(-clusterChangesRetention)); err != nil {
+		log.Println("Failed to prune cluster changes:", err)
+	}
+}
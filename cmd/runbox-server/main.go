@@ -0,0 +1,65 @@
+// Command runbox-server serves RunBox: the editor, the management API and
+// the functions. The runbox command's dev serves a directory of functions
+// instead.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/prodemmi/runbox"
+)
+
+func main() {
+	config, err := parseFlags()
+	if err != nil {
+		log.Fatal("Failed to load config: ", err)
+	}
+
+	srv, err := runbox.New(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// log.Fatal would skip a deferred Close, so the server is closed
+	// before exiting either way.
+	err = srv.ListenAndServe()
+	srv.Close()
+	if err != nil {
+		log.Print("Server stopped: ", err)
+		os.Exit(1)
+	}
+}
+
+func parseFlags() (runbox.Config, error) {
+	configPath := flag.String("config", "", "path to the JSON config file (default runbox.json if present)")
+	addr := flag.String("addr", "", "listen address, overrides the config file")
+	templates := flag.String("templates", "", "serve page templates from this directory instead of the built-in ones")
+	static := flag.String("static", "", "serve /static from this directory instead of the built-in files")
+	readOnly := flag.Bool("read-only", false, "refuse changes to functions and settings; executions keep running")
+	seedDir := flag.String("seed-dir", "", "create the functions in this directory that the database doesn't have")
+	flag.Parse()
+
+	config, err := runbox.LoadConfig(*configPath)
+	if err != nil {
+		return config, err
+	}
+
+	if *addr != "" {
+		config.Addr = *addr
+	}
+	if *templates != "" {
+		config.Templates = *templates
+	}
+	if *static != "" {
+		config.Static = *static
+	}
+	if *readOnly {
+		config.ReadOnly = true
+	}
+	if *seedDir != "" {
+		config.SeedDir = *seedDir
+	}
+
+	return config, nil
+}
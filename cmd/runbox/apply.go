@@ -0,0 +1,141 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// applyStep is one change in the plan POST /api/apply answers.
+type applyStep struct {
+	Action string   `json:"action"`
+	Kind   string   `json:"kind"`
+	Path   string   `json:"path"`
+	Name   string   `json:"name"`
+	Fields []string `json:"fields"`
+	Error  string   `json:"error"`
+}
+
+func (s applyStep) String() string {
+	var parts []string
+	switch s.Kind {
+	case "function":
+		parts = append(parts, s.Path)
+	case "env":
+		parts = append(parts, "env", strings.TrimSpace(s.Path+" "+s.Name))
+	default:
+		parts = append(parts, s.Kind, s.Path)
+		if s.Name != "" {
+			parts = append(parts, "-> "+s.Name)
+		}
+	}
+	if len(s.Fields) > 0 {
+		parts = append(parts, "("+strings.Join(s.Fields, ", ")+")")
+	}
+	return strings.Join(parts, " ")
+}
+
+func applyCommand(args []string) error {
+	fs := flag.NewFlagSet("apply", flag.ContinueOnError)
+	server := serverFlags(fs)
+	file := fs.String("f", "runbox.yaml", "manifest to apply, in YAML or JSON")
+	dryRun := fs.Bool("dry-run", false, "show the plan without changing anything")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: runbox apply [flags]\n\nConverges the server to the manifest: listed functions are created or updated,\nunlisted ones deleted unless the manifest sets prune: false. A function or\nmodule may give file: instead of code, relative to the manifest.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	manifest, err := readManifest(*file)
+	if err != nil {
+		return err
+	}
+
+	path := "/api/apply"
+	if *dryRun {
+		path += "?dryRun=true"
+	}
+	var result struct {
+		Plan      []applyStep    `json:"plan"`
+		Unchanged int            `json:"unchanged"`
+		Counts    map[string]int `json:"counts"`
+	}
+	if err := server().json(http.MethodPost, path, manifest, &result); err != nil {
+		return err
+	}
+	failed := 0
+	for _, step := range result.Plan {
+		if step.Error != "" {
+			failed++
+			fmt.Printf("failed    %s %s: %s\n", step.Action, step, step.Error)
+			continue
+		}
+		fmt.Printf("%-9s %s\n", step.Action, step)
+	}
+	if *dryRun {
+		fmt.Printf("%d changes to apply, %d functions unchanged\n", len(result.Plan), result.Unchanged)
+		return nil
+	}
+	fmt.Printf("%d created, %d updated, %d deleted, %d functions unchanged\n",
+		result.Counts["create"], result.Counts["update"], result.Counts["delete"], result.Unchanged)
+	if failed > 0 {
+		return fmt.Errorf("%d changes failed", failed)
+	}
+	return nil
+}
+
+// readManifest parses a YAML or JSON manifest, replacing each file: key on
+// a function or module with the code read from that file.
+func readManifest(name string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var manifest map[string]interface{}
+	if err := yaml.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	dir := filepath.Dir(name)
+	functions, _ := manifest["functions"].([]interface{})
+	for _, f := range functions {
+		function, ok := f.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s: each function must be a mapping", name)
+		}
+		if err := inlineFile(dir, function); err != nil {
+			return nil, err
+		}
+		files, _ := function["files"].([]interface{})
+		for _, m := range files {
+			if module, ok := m.(map[string]interface{}); ok {
+				if err := inlineFile(dir, module); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return manifest, nil
+}
+
+func inlineFile(dir string, entry map[string]interface{}) error {
+	file, ok := entry["file"].(string)
+	if !ok {
+		return nil
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(dir, file)
+	}
+	code, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	delete(entry, "file")
+	entry["code"] = string(code)
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const requestTimeout = 60 * time.Second
+
+type client struct {
+	baseURL string
+	token   string
+	http    http.Client
+}
+
+// remoteFunction is a function as the GraphQL API lists it.
+type remoteFunction struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Path       string   `json:"path"`
+	Mode       string   `json:"mode"`
+	Version    int      `json:"version"`
+	Visibility *string  `json:"visibility"`
+	Tags       []string `json:"tags"`
+}
+
+// apiError is a non-2xx answer, with the message of the {"error": "..."}
+// body the server answers failures with.
+type apiError struct {
+	Method, Path string
+	Status       int
+	Message      string
+	// Details lists problems some endpoints report with the message, such
+	// as each invalid entry of a manifest.
+	Details []string
+}
+
+func (e *apiError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%s %s: %d %s", e.Method, e.Path, e.Status, http.StatusText(e.Status))
+	}
+	msg := fmt.Sprintf("%s %s: %s (%d)", e.Method, e.Path, e.Message, e.Status)
+	for _, d := range e.Details {
+		msg += "\n  " + d
+	}
+	return msg
+}
+
+func isNotFound(err error) bool {
+	var e *apiError
+	return errors.As(err, &e) && e.Status == http.StatusNotFound
+}
+
+// raw sends a request and returns the response body, turning a non-2xx
+// status into an error carrying the server's message.
+func (c *client) raw(method, path, contentType string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	c.http.Timeout = requestTimeout
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var body struct {
+			Error string `json:"error"`
+		}
+		json.Unmarshal(data, &body)
+		var details struct {
+			Errors []string `json:"errors"`
+		}
+		json.Unmarshal(data, &details)
+		return nil, &apiError{Method: method, Path: path, Status: resp.StatusCode, Message: body.Error, Details: details.Errors}
+	}
+	return data, nil
+}
+
+// json sends in as a JSON body, or no body when in is nil, and decodes the
+// response into out.
+func (c *client) json(method, path string, in, out interface{}) error {
+	var body []byte
+	contentType := ""
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+		contentType = "application/json"
+	}
+	data, err := c.raw(method, path, contentType, body)
+	if err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+func (c *client) graphql(query string, variables map[string]interface{}, out interface{}) error {
+	var resp struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	in := map[string]interface{}{"query": query, "variables": variables}
+	if err := c.json(http.MethodPost, "/api/graphql", in, &resp); err != nil {
+		return err
+	}
+	if len(resp.Errors) > 0 {
+		messages := make([]string, len(resp.Errors))
+		for i, e := range resp.Errors {
+			messages[i] = e.Message
+		}
+		return fmt.Errorf("graphql: %s", strings.Join(messages, "; "))
+	}
+	return json.Unmarshal(resp.Data, out)
+}
+
+func (c *client) functions() ([]remoteFunction, error) {
+	var data struct {
+		Functions []remoteFunction `json:"functions"`
+	}
+	err := c.graphql(`{ functions { id name path mode version visibility tags } }`, nil, &data)
+	return data.Functions, err
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+func loginCommand(args []string) error {
+	fs := flag.NewFlagSet("login", flag.ContinueOnError)
+	server := serverFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	c := server()
+	var status struct {
+		Required bool `json:"required"`
+	}
+	if err := c.json(http.MethodGet, "/api/auth", nil, &status); err != nil {
+		var e *apiError
+		if errors.As(err, &e) && (e.Status == http.StatusUnauthorized || e.Status == http.StatusForbidden) {
+			if c.token == "" {
+				return fmt.Errorf("%s needs a token; pass --token or set RUNBOX_TOKEN", c.baseURL)
+			}
+			if strings.HasPrefix(c.token, "rbb_") {
+				return fmt.Errorf("%s rejected the token: a bootstrap token only creates the first API key with POST /api/keys; log in with that key", c.baseURL)
+			}
+			return fmt.Errorf("%s rejected the token (%d)", c.baseURL, e.Status)
+		}
+		return err
+	}
+	functions, err := c.functions()
+	if err != nil {
+		return err
+	}
+	path, err := saveConfig(cliConfig{URL: c.baseURL, Token: c.token})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Logged in to %s (%d functions); saved to %s\n", c.baseURL, len(functions), path)
+	return nil
+}
+
+func listCommand(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	server := serverFlags(fs)
+	asJSON := fs.Bool("json", false, "print the functions as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	functions, err := server().functions()
+	if err != nil {
+		return err
+	}
+	sort.Slice(functions, func(i, j int) bool { return functions[i].Path < functions[j].Path })
+	if *asJSON {
+		out := json.NewEncoder(os.Stdout)
+		out.SetIndent("", "  ")
+		return out.Encode(functions)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PATH\tNAME\tMODE\tVERSION\tVISIBILITY\tTAGS")
+	for _, f := range functions {
+		visibility := "-"
+		if f.Visibility != nil {
+			visibility = *f.Visibility
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", f.Path, f.Name, f.Mode, f.Version, visibility, strings.Join(f.Tags, ","))
+	}
+	return w.Flush()
+}
+
+func pullCommand(args []string) error {
+	fs := flag.NewFlagSet("pull", flag.ContinueOnError)
+	server := serverFlags(fs)
+	dir := fs.String("dir", ".", "directory to write runbox.json and functions/ into")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: runbox pull [flags] [path...]\n\nWith no paths every function is pulled.")
+		fs.PrintDefaults()
+	}
+	paths, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	c := server()
+
+	query := url.Values{"format": {"tar.gz"}}
+	if len(paths) > 0 {
+		all, err := c.functions()
+		if err != nil {
+			return err
+		}
+		functions, err := selectFunctions(all, paths)
+		if err != nil {
+			return err
+		}
+		ids := make([]string, len(functions))
+		for i, f := range functions {
+			ids[i] = f.ID
+		}
+		query.Set("ids", strings.Join(ids, ","))
+	}
+
+	archive, err := c.raw(http.MethodGet, "/api/bundle?"+query.Encode(), "", nil)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		return err
+	}
+	files, err := readArchive(archive)
+	if err != nil {
+		return err
+	}
+	if err := writeFiles(*dir, files); err != nil {
+		return err
+	}
+	b, err := readDir(*dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range b.Functions {
+		fmt.Printf("pulled %s\n", f.Path)
+	}
+	fmt.Printf("%d functions written to %s\n", len(b.Functions), *dir)
+	return nil
+}
+
+// previewItem is one function of a push as POST /api/bundle/preview sees it.
+type previewItem struct {
+	bundleFunction
+	Errors []struct {
+		Line    int    `json:"line"`
+		Column  int    `json:"column"`
+		Message string `json:"message"`
+	} `json:"errors"`
+	Conflict *struct {
+		Version   int  `json:"version"`
+		Identical bool `json:"identical"`
+	} `json:"conflict"`
+	Action string `json:"action"`
+}
+
+type importItem struct {
+	bundleFunction
+	Action string `json:"action"`
+}
+
+func pushCommand(args []string) error {
+	fs := flag.NewFlagSet("push", flag.ContinueOnError)
+	server := serverFlags(fs)
+	dir := fs.String("dir", ".", "directory holding runbox.json and functions/")
+	dryRun := fs.Bool("dry-run", false, "show what would change without changing anything")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: runbox push [flags] [path...]\n\nWith no paths every function in runbox.json is pushed.")
+		fs.PrintDefaults()
+	}
+	paths, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	b, err := readDir(*dir)
+	if err != nil {
+		return err
+	}
+	if len(paths) > 0 {
+		var only []bundleFunction
+		for _, p := range paths {
+			f, err := findFunction(b, p)
+			if err != nil {
+				return err
+			}
+			only = append(only, *f)
+		}
+		b.Functions = only
+	}
+	b.Format, b.Version = "runbox-bundle", 1
+	c := server()
+
+	var preview struct {
+		Functions []previewItem `json:"functions"`
+	}
+	if err := c.json(http.MethodPost, "/api/bundle/preview", b, &preview); err != nil {
+		return err
+	}
+	var changes []importItem
+	invalid := 0
+	for _, item := range preview.Functions {
+		switch {
+		case len(item.Errors) > 0:
+			invalid++
+			for _, e := range item.Errors {
+				fmt.Printf("invalid   %s: %d:%d: %s\n", item.Path, e.Line, e.Column, e.Message)
+			}
+		case item.Action == "skip" && item.Conflict != nil && item.Conflict.Identical:
+			fmt.Printf("unchanged %s\n", item.Path)
+		case item.Action == "skip":
+			invalid++
+			fmt.Printf("invalid   %s: name, path and code are required and mode must be valid\n", item.Path)
+		case item.Action == "overwrite":
+			fmt.Printf("update    %s (version %d)\n", item.Path, item.Conflict.Version)
+			changes = append(changes, importItem{item.bundleFunction, item.Action})
+		default:
+			fmt.Printf("create    %s\n", item.Path)
+			changes = append(changes, importItem{item.bundleFunction, item.Action})
+		}
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d functions are invalid; nothing was pushed", invalid)
+	}
+	if *dryRun || len(changes) == 0 {
+		fmt.Printf("%d functions to push\n", len(changes))
+		return nil
+	}
+
+	var result struct {
+		Results []struct {
+			Path   string `json:"path"`
+			Action string `json:"action"`
+			Error  string `json:"error"`
+		} `json:"results"`
+		Counts map[string]int `json:"counts"`
+	}
+	if err := c.json(http.MethodPost, "/api/bundle/import", map[string]interface{}{"functions": changes}, &result); err != nil {
+		return err
+	}
+	for _, r := range result.Results {
+		if r.Error != "" {
+			fmt.Printf("failed    %s: %s\n", r.Path, r.Error)
+		}
+	}
+	fmt.Printf("%d created, %d updated\n", result.Counts["create"], result.Counts["overwrite"])
+	if failed := result.Counts["failed"]; failed > 0 {
+		return fmt.Errorf("%d functions failed to push", failed)
+	}
+	return nil
+}
+
+func dryRunCommand(args []string) error {
+	fs := flag.NewFlagSet("dry-run", flag.ContinueOnError)
+	server := serverFlags(fs)
+	dir := fs.String("dir", ".", "directory holding runbox.json and functions/")
+	method := fs.String("X", http.MethodGet, "request method")
+	subpath := fs.String("subpath", "", "subpath under a wildcard function, e.g. /items/1")
+	data := fs.String("d", "", "request body, or @file to read it from a file")
+	asJSON := fs.Bool("json", false, "print the whole dry-run result as JSON")
+	headers, query := headerFlag{}, queryFlag{}
+	fs.Var(headers, "H", "request header as \"Name: value\" (repeatable)")
+	fs.Var(query, "q", "query parameter as name=value (repeatable)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: runbox dry-run [flags] <path>\n\nRuns the local code and modules of the function at path; it exits 1 when\nthe response status is 400 or above.")
+		fs.PrintDefaults()
+	}
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	b, err := readDir(*dir)
+	if err != nil {
+		return err
+	}
+	f, err := findFunction(b, positional[0])
+	if err != nil {
+		return err
+	}
+	body := *data
+	if strings.HasPrefix(body, "@") {
+		content, err := os.ReadFile(body[1:])
+		if err != nil {
+			return err
+		}
+		body = string(content)
+	}
+	c := server()
+
+	// Running as the saved function, when there is one, gives host APIs
+	// its ID, as the editor's test panel does.
+	in := map[string]interface{}{
+		"code":    f.Code,
+		"files":   f.Files,
+		"mode":    f.Mode,
+		"path":    f.Path,
+		"subpath": *subpath,
+		"method":  strings.ToUpper(*method),
+		"headers": map[string]string(headers),
+		"query":   map[string]string(query),
+		"body":    body,
+	}
+	if f.Files == nil {
+		in["files"] = []functionFile{}
+	}
+	var saved struct {
+		Function *remoteFunction `json:"function"`
+	}
+	if err := c.graphql(`query($path: String) { function(path: $path) { id } }`, map[string]interface{}{"path": f.Path}, &saved); err != nil {
+		return err
+	}
+	if saved.Function != nil {
+		id, _ := strconv.Atoi(saved.Function.ID)
+		in["functionId"] = id
+	}
+
+	var result struct {
+		Status     int               `json:"status"`
+		Headers    map[string]string `json:"headers"`
+		Body       string            `json:"body"`
+		Logs       []string          `json:"logs"`
+		Error      string            `json:"error"`
+		DurationMs float64           `json:"durationMs"`
+	}
+	request, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	raw, err := c.raw(http.MethodPost, "/api/functions/dry-run", "application/json", request)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return err
+	}
+	if *asJSON {
+		os.Stdout.Write(raw)
+		fmt.Println()
+	} else {
+		for _, line := range result.Logs {
+			fmt.Fprintln(os.Stderr, "log:", line)
+		}
+		if result.Error != "" {
+			fmt.Fprintln(os.Stderr, "error:", result.Error)
+		}
+		fmt.Fprintf(os.Stderr, "%d in %.2f ms\n", result.Status, result.DurationMs)
+		fmt.Println(result.Body)
+	}
+	if result.Status >= 400 {
+		return fmt.Errorf("%s %s answered %d", strings.ToUpper(*method), f.Path, result.Status)
+	}
+	return nil
+}
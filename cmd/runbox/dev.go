@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/prodemmi/runbox"
+)
+
+// devAddr is where runbox dev listens by default: only this host can reach
+// it, so it serves without a token.
+const devAddr = "127.0.0.1:8080"
+
+// devCommand serves the functions in a directory from an in-memory
+// database, reloading them as files change. Unlike the other commands it
+// runs a server of its own rather than talking to one. The config file is
+// only read when given, so a bare runbox dev needs no setup.
+func devCommand(args []string) error {
+	fs := flag.NewFlagSet("dev", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to a JSON config file (none by default)")
+	addr := fs.String("addr", "", "listen address (default "+devAddr+"); any but a loopback address requires a token")
+	templates := fs.String("templates", "", "serve page templates from this directory instead of the built-in ones")
+	static := fs.String("static", "", "serve /static from this directory instead of the built-in files")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: runbox dev [flags] <dir>\n\nServes the functions in dir from an in-memory database, reloading them as files change.")
+		fs.PrintDefaults()
+	}
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+
+	config := runbox.DefaultConfig()
+	if *configPath != "" {
+		if config, err = runbox.LoadConfig(*configPath); err != nil {
+			return fmt.Errorf("failed to load config: %v", err)
+		}
+	}
+	config.DevDir = positional[0]
+	if *addr != "" {
+		config.Addr = *addr
+	} else if config.Addr == runbox.DefaultConfig().Addr {
+		config.Addr = devAddr
+	}
+	if *templates != "" {
+		config.Templates = *templates
+	}
+	if *static != "" {
+		config.Static = *static
+	}
+
+	srv, err := runbox.New(config)
+	if err != nil {
+		return err
+	}
+	defer srv.Close()
+	return srv.ListenAndServe()
+}
@@ -0,0 +1,182 @@
+// Command runbox talks to a runbox server: it lists functions, pulls them
+// into a directory laid out like a tar.gz bundle, pushes local edits back,
+// dry-runs local code without saving it and moves functions with their
+// settings between instances. runbox dev serves a directory of functions
+// locally.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	defaultURL = "http://localhost:8080"
+	configName = "cli.json"
+)
+
+const usage = `Usage: runbox <command> [flags]
+
+Commands:
+  login     check a server URL and token and save them for later commands
+  list      list the server's functions
+  pull      write functions into a local directory
+  push      create or update functions from a local directory
+  dry-run   run a local function's code on the server without saving it
+  export    write functions with their env variables and settings to a directory or archive
+  import    load an export into a server, reporting conflicts
+  apply     converge the server to a declarative manifest of functions and settings
+  dev       serve the functions in a directory locally, reloading them as files change
+
+Every command but dev takes --url and --token, defaulting to RUNBOX_URL and
+RUNBOX_TOKEN and then to what login saved. Run runbox <command> -h for the
+rest of its flags.
+`
+
+// cliConfig is what login saves.
+type cliConfig struct {
+	URL   string `json:"url"`
+	Token string `json:"token,omitempty"`
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	commands := map[string]func([]string) error{
+		"login":   loginCommand,
+		"list":    listCommand,
+		"pull":    pullCommand,
+		"push":    pushCommand,
+		"dry-run": dryRunCommand,
+		"export":  exportCommand,
+		"import":  importCommand,
+		"apply":   applyCommand,
+		"dev":     devCommand,
+	}
+	name, args := os.Args[1], os.Args[2:]
+	if name == "help" || name == "-h" || name == "--help" {
+		fmt.Print(usage)
+		return
+	}
+	command, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "runbox: unknown command %q\n\n%s", name, usage)
+		os.Exit(2)
+	}
+	if err := command(args); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "runbox:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+func configPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "runbox", configName), nil
+}
+
+func loadConfig() cliConfig {
+	var config cliConfig
+	if path, err := configPath(); err == nil {
+		if data, err := os.ReadFile(path); err == nil {
+			json.Unmarshal(data, &config)
+		}
+	}
+	return config
+}
+
+func saveConfig(config cliConfig) (string, error) {
+	path, err := configPath()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return path, os.WriteFile(path, append(data, '\n'), 0o600)
+}
+
+// serverFlags adds --url and --token to fs. They fall back to the
+// environment and then to the saved config once fs is parsed.
+func serverFlags(fs *flag.FlagSet) func() *client {
+	url := fs.String("url", "", "server URL (default $RUNBOX_URL, the saved URL or "+defaultURL+")")
+	token := fs.String("token", "", "API token sent as Authorization: Bearer (default $RUNBOX_TOKEN or the saved token)")
+	return func() *client {
+		saved := loadConfig()
+		c := &client{baseURL: firstNonEmpty(*url, os.Getenv("RUNBOX_URL"), saved.URL, defaultURL)}
+		c.token = firstNonEmpty(*token, os.Getenv("RUNBOX_TOKEN"))
+		if c.token == "" && strings.TrimSuffix(c.baseURL, "/") == strings.TrimSuffix(saved.URL, "/") {
+			c.token = saved.Token
+		}
+		c.baseURL = strings.TrimSuffix(c.baseURL, "/")
+		return c
+	}
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// parseInterspersed parses fs allowing flags after positional arguments,
+// as in runbox dry-run /hello -X POST.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// headerFlag collects repeated -H "Name: value" flags.
+type headerFlag map[string]string
+
+func (h headerFlag) String() string { return "" }
+
+func (h headerFlag) Set(value string) error {
+	name, v, ok := strings.Cut(value, ":")
+	if !ok || strings.TrimSpace(name) == "" {
+		return fmt.Errorf("header %q is not Name: value", value)
+	}
+	h[strings.TrimSpace(name)] = strings.TrimSpace(v)
+	return nil
+}
+
+// queryFlag collects repeated -q name=value flags.
+type queryFlag map[string]string
+
+func (q queryFlag) String() string { return "" }
+
+func (q queryFlag) Set(value string) error {
+	name, v, _ := strings.Cut(value, "=")
+	if name == "" {
+		return fmt.Errorf("query parameter %q is not name=value", value)
+	}
+	q[name] = v
+	return nil
+}
@@ -0,0 +1,538 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// settingsName is the file export writes beside the bundle's runbox.json.
+const settingsName = "settings.json"
+
+// settingEndpoints are the per-function settings export copies, each read
+// from and written back to /api/functions/:id/<endpoint> as is.
+var settingEndpoints = []string{"keep-warm", "quota", "capture", "slo", "traffic-alert"}
+
+// instanceSettings is what the bundle format leaves out: environment
+// variables and the settings of each function, keyed by path.
+type instanceSettings struct {
+	Env       []envVar                     `json:"env"`
+	Functions map[string]*functionSettings `json:"functions"`
+}
+
+type functionSettings struct {
+	Env      []envVar                   `json:"env,omitempty"`
+	Settings map[string]json.RawMessage `json:"settings,omitempty"`
+	// OnFailure is the path of the function's on-failure handler.
+	OnFailure string `json:"onFailure,omitempty"`
+}
+
+// envVar is a variable as the env API lists it; sensitive ones come
+// without their value.
+type envVar struct {
+	Name      string `json:"name"`
+	Value     string `json:"value"`
+	Sensitive bool   `json:"sensitive"`
+}
+
+// selectFunctions is the functions at paths, or all of them without any.
+func selectFunctions(functions []remoteFunction, paths []string) ([]remoteFunction, error) {
+	if len(paths) == 0 {
+		return functions, nil
+	}
+	selected := make([]remoteFunction, 0, len(paths))
+	for _, p := range paths {
+		p = "/" + strings.TrimPrefix(p, "/")
+		found := false
+		for _, f := range functions {
+			if f.Path == p {
+				selected = append(selected, f)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no function at %s", p)
+		}
+	}
+	return selected, nil
+}
+
+func (c *client) envVars(prefix string) ([]envVar, error) {
+	var list struct {
+		Vars []envVar `json:"vars"`
+	}
+	err := c.json(http.MethodGet, prefix+"/env", nil, &list)
+	return list.Vars, err
+}
+
+func functionPrefix(id string) string {
+	return "/api/functions/" + url.PathEscape(id)
+}
+
+// exportSettings reads the env variables and settings of functions. It
+// returns what could not be exported, such as sensitive values.
+func (c *client) exportSettings(functions, all []remoteFunction) (*instanceSettings, []string, error) {
+	var warnings []string
+	paths := map[int]string{}
+	for _, f := range all {
+		id, _ := strconv.Atoi(f.ID)
+		paths[id] = f.Path
+	}
+	sensitive := func(where string, vars []envVar) {
+		for _, v := range vars {
+			if v.Sensitive {
+				warnings = append(warnings, fmt.Sprintf("%s%s is sensitive; its value is not exported", where, v.Name))
+			}
+		}
+	}
+
+	global, err := c.envVars("/api")
+	if err != nil {
+		return nil, nil, err
+	}
+	sensitive("", global)
+	settings := &instanceSettings{Env: global, Functions: map[string]*functionSettings{}}
+
+	for _, f := range functions {
+		prefix := functionPrefix(f.ID)
+		s := &functionSettings{Settings: map[string]json.RawMessage{}}
+		if s.Env, err = c.envVars(prefix); err != nil {
+			return nil, nil, err
+		}
+		sensitive(f.Path+" ", s.Env)
+
+		for _, endpoint := range settingEndpoints {
+			data, err := c.raw(http.MethodGet, prefix+"/"+endpoint, "", nil)
+			if isNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			var secret struct {
+				HasSecret bool `json:"hasSecret"`
+			}
+			if json.Unmarshal(data, &secret) == nil && secret.HasSecret {
+				warnings = append(warnings, fmt.Sprintf("%s %s has a webhook secret, which is not exported", f.Path, endpoint))
+			}
+			s.Settings[endpoint] = data
+		}
+
+		var handler struct {
+			HandlerID int `json:"handlerId"`
+		}
+		err := c.json(http.MethodGet, prefix+"/on-failure", nil, &handler)
+		switch {
+		case isNotFound(err):
+		case err != nil:
+			return nil, nil, err
+		default:
+			s.OnFailure = paths[handler.HandlerID]
+		}
+
+		if len(s.Env) > 0 || len(s.Settings) > 0 || s.OnFailure != "" {
+			settings.Functions[f.Path] = s
+		}
+	}
+	return settings, warnings, nil
+}
+
+func exportCommand(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	server := serverFlags(fs)
+	dir := fs.String("dir", "", "directory to export into")
+	archive := fs.String("archive", "", "tar.gz file to export into")
+	noSettings := fs.Bool("no-settings", false, "export only the functions, without env variables and settings")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: runbox export (--dir <dir> | --archive <file.tar.gz>) [flags] [path...]\n\nWith no paths every function is exported.")
+		fs.PrintDefaults()
+	}
+	paths, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if (*dir == "") == (*archive == "") {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	c := server()
+
+	all, err := c.functions()
+	if err != nil {
+		return err
+	}
+	functions, err := selectFunctions(all, paths)
+	if err != nil {
+		return err
+	}
+	query := url.Values{"format": {"tar.gz"}}
+	if len(paths) > 0 {
+		ids := make([]string, len(functions))
+		for i, f := range functions {
+			ids[i] = f.ID
+		}
+		query.Set("ids", strings.Join(ids, ","))
+	}
+	data, err := c.raw(http.MethodGet, "/api/bundle?"+query.Encode(), "", nil)
+	if err != nil {
+		return err
+	}
+	files, err := readArchive(data)
+	if err != nil {
+		return err
+	}
+
+	if !*noSettings {
+		settings, warnings, err := c.exportSettings(functions, all)
+		if err != nil {
+			return err
+		}
+		if files[settingsName], err = json.MarshalIndent(settings, "", "  "); err != nil {
+			return err
+		}
+		for _, w := range warnings {
+			fmt.Fprintln(os.Stderr, "warning:", w)
+		}
+	}
+
+	target := *dir
+	if *archive != "" {
+		target = *archive
+		var buf bytes.Buffer
+		if err := writeArchive(&buf, files); err != nil {
+			return err
+		}
+		if err := os.WriteFile(*archive, buf.Bytes(), 0o644); err != nil {
+			return err
+		}
+	} else if err := writeFiles(*dir, files); err != nil {
+		return err
+	}
+	fmt.Printf("%d functions exported from %s to %s\n", len(functions), c.baseURL, target)
+	return nil
+}
+
+// Conflict policies of import.
+const (
+	conflictFail      = "fail"
+	conflictSkip      = "skip"
+	conflictOverwrite = "overwrite"
+)
+
+// envChange is a variable import sets, at global scope when path is "".
+type envChange struct {
+	path string
+	v    envVar
+}
+
+func (e envChange) name() string {
+	if e.path == "" {
+		return e.v.Name
+	}
+	return e.path + " " + e.v.Name
+}
+
+// planEnv sorts wanted into the variables to set and those that differ
+// from what the target already has. Sensitive values were never exported,
+// so those are left out and reported.
+func planEnv(path string, wanted, existing []envVar, warn func(string)) (set, conflicts []envChange) {
+	have := map[string]envVar{}
+	for _, v := range existing {
+		have[v.Name] = v
+	}
+	where := ""
+	if path != "" {
+		where = path + " "
+	}
+	for _, v := range wanted {
+		current, exists := have[v.Name]
+		switch {
+		case v.Sensitive:
+			if !exists {
+				warn(fmt.Sprintf("%s%s is sensitive and was exported without its value; set it by hand", where, v.Name))
+			}
+		case !exists:
+			set = append(set, envChange{path, v})
+		case current.Sensitive || current.Value != v.Value:
+			conflicts = append(conflicts, envChange{path, v})
+		}
+	}
+	return set, conflicts
+}
+
+func importCommand(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	server := serverFlags(fs)
+	dryRun := fs.Bool("dry-run", false, "report what would change without changing anything")
+	onConflict := fs.String("on-conflict", conflictFail, "functions and variables that differ from the target's: fail, skip or overwrite")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: runbox import [flags] <dir | file.tar.gz>\n\nBy default nothing is imported if any function or variable differs from\nthe one already on the server.")
+		fs.PrintDefaults()
+	}
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	if *onConflict != conflictFail && *onConflict != conflictSkip && *onConflict != conflictOverwrite {
+		return fmt.Errorf("--on-conflict must be fail, skip or overwrite")
+	}
+
+	source := positional[0]
+	read := dirReader(source)
+	if info, err := os.Stat(source); err != nil {
+		return err
+	} else if !info.IsDir() {
+		data, err := os.ReadFile(source)
+		if err != nil {
+			return err
+		}
+		files, err := readArchive(data)
+		if err != nil {
+			return err
+		}
+		read = mapReader(files)
+	}
+	b, err := loadBundle(read)
+	if err != nil {
+		return err
+	}
+	settings := &instanceSettings{}
+	if data, err := read(settingsName); err == nil {
+		if err := json.Unmarshal(data, settings); err != nil {
+			return fmt.Errorf("%s: %v", settingsName, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	b.Format, b.Version = "runbox-bundle", 1
+	c := server()
+
+	var preview struct {
+		Functions []previewItem `json:"functions"`
+	}
+	if err := c.json(http.MethodPost, "/api/bundle/preview", b, &preview); err != nil {
+		return err
+	}
+
+	var warnings []string
+	warn := func(w string) { warnings = append(warnings, w) }
+	var changes []importItem
+	var envSet, envConflicts []envChange
+	// configure lists the functions whose settings are applied; those
+	// already on the target get only the settings it lacks.
+	configure := map[string]bool{}
+	invalid, conflicts := 0, 0
+
+	existing, err := c.functions()
+	if err != nil {
+		return err
+	}
+	ids := map[string]string{}
+	for _, f := range existing {
+		ids[f.Path] = f.ID
+	}
+
+	for _, item := range preview.Functions {
+		switch {
+		case len(item.Errors) > 0:
+			invalid++
+			for _, e := range item.Errors {
+				fmt.Printf("invalid   %s: %d:%d: %s\n", item.Path, e.Line, e.Column, e.Message)
+			}
+			continue
+		case item.Action == "skip" && item.Conflict != nil && item.Conflict.Identical:
+			fmt.Printf("unchanged %s\n", item.Path)
+			configure[item.Path] = false
+		case item.Action == "skip":
+			invalid++
+			fmt.Printf("invalid   %s: name, path and code are required and mode must be valid\n", item.Path)
+			continue
+		case item.Action == "overwrite":
+			conflicts++
+			if *onConflict != conflictOverwrite {
+				fmt.Printf("conflict  %s differs from version %d on the server\n", item.Path, item.Conflict.Version)
+				continue
+			}
+			fmt.Printf("update    %s (version %d)\n", item.Path, item.Conflict.Version)
+			changes = append(changes, importItem{item.bundleFunction, item.Action})
+			configure[item.Path] = true
+		default:
+			fmt.Printf("create    %s\n", item.Path)
+			changes = append(changes, importItem{item.bundleFunction, item.Action})
+			configure[item.Path] = true
+		}
+
+		if s := settings.Functions[item.Path]; s != nil {
+			var have []envVar
+			if id, ok := ids[item.Path]; ok {
+				if have, err = c.envVars(functionPrefix(id)); err != nil {
+					return err
+				}
+			}
+			set, differ := planEnv(item.Path, s.Env, have, warn)
+			envSet, envConflicts = append(envSet, set...), append(envConflicts, differ...)
+		}
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d functions are invalid; nothing was imported", invalid)
+	}
+
+	globals, err := c.envVars("/api")
+	if err != nil {
+		return err
+	}
+	set, differ := planEnv("", settings.Env, globals, warn)
+	envSet, envConflicts = append(set, envSet...), append(differ, envConflicts...)
+	for _, e := range envSet {
+		fmt.Printf("env       %s\n", e.name())
+	}
+	for _, e := range envConflicts {
+		if *onConflict == conflictOverwrite {
+			fmt.Printf("update    env %s\n", e.name())
+		} else {
+			fmt.Printf("conflict  env %s differs on the server\n", e.name())
+		}
+	}
+	conflicts += len(envConflicts)
+	if *onConflict == conflictOverwrite {
+		envSet = append(envSet, envConflicts...)
+	}
+	for _, w := range warnings {
+		fmt.Fprintln(os.Stderr, "warning:", w)
+	}
+
+	if conflicts > 0 && *onConflict == conflictFail {
+		return fmt.Errorf("%d conflicts; nothing was imported (use --on-conflict skip or overwrite)", conflicts)
+	}
+	if *dryRun {
+		fmt.Printf("%d functions and %d variables to import\n", len(changes), len(envSet))
+		return nil
+	}
+
+	if len(changes) > 0 {
+		var result struct {
+			Results []struct {
+				Path  string `json:"path"`
+				Error string `json:"error"`
+			} `json:"results"`
+		}
+		if err := c.json(http.MethodPost, "/api/bundle/import", map[string]interface{}{"functions": changes}, &result); err != nil {
+			return err
+		}
+		failed := 0
+		for _, r := range result.Results {
+			if r.Error != "" {
+				failed++
+				delete(configure, r.Path)
+				fmt.Printf("failed    %s: %s\n", r.Path, r.Error)
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d functions failed to import; env variables and settings were not applied", failed)
+		}
+		if existing, err = c.functions(); err != nil {
+			return err
+		}
+		for _, f := range existing {
+			ids[f.Path] = f.ID
+		}
+	}
+
+	for _, e := range envSet {
+		prefix := "/api"
+		if e.path != "" {
+			id, ok := ids[e.path]
+			if !ok {
+				continue
+			}
+			prefix = functionPrefix(id)
+		}
+		body := map[string]interface{}{"value": e.v.Value, "sensitive": false}
+		if err := c.json(http.MethodPut, prefix+"/env/"+url.PathEscape(e.v.Name), body, nil); err != nil {
+			return err
+		}
+	}
+	applied, err := c.importSettings(settings, configure, ids)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d functions, %d variables and %d settings imported into %s\n", len(changes), len(envSet), applied, c.baseURL)
+	return nil
+}
+
+// importSettings writes the settings of each function in configure. Ones
+// that were already on the target (false in configure) only get settings
+// they don't have yet.
+func (c *client) importSettings(settings *instanceSettings, configure map[string]bool, ids map[string]string) (int, error) {
+	paths := make([]string, 0, len(configure))
+	for p := range configure {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	applied := 0
+	for _, p := range paths {
+		s, id := settings.Functions[p], ids[p]
+		if s == nil || id == "" {
+			continue
+		}
+		prefix := functionPrefix(id)
+		missing := func(endpoint string) (bool, error) {
+			if configure[p] {
+				return true, nil
+			}
+			_, err := c.raw(http.MethodGet, prefix+"/"+endpoint, "", nil)
+			if isNotFound(err) {
+				return true, nil
+			}
+			return false, err
+		}
+
+		for _, endpoint := range settingEndpoints {
+			body, ok := s.Settings[endpoint]
+			if !ok {
+				continue
+			}
+			if write, err := missing(endpoint); err != nil || !write {
+				if err != nil {
+					return applied, err
+				}
+				continue
+			}
+			if _, err := c.raw(http.MethodPut, prefix+"/"+endpoint, "application/json", body); err != nil {
+				return applied, err
+			}
+			applied++
+		}
+		if s.OnFailure != "" {
+			handler, ok := ids[s.OnFailure]
+			if !ok {
+				fmt.Fprintf(os.Stderr, "warning: %s's on-failure handler %s is not on the server\n", p, s.OnFailure)
+				continue
+			}
+			write, err := missing("on-failure")
+			if err != nil {
+				return applied, err
+			}
+			if write {
+				handlerID, _ := strconv.Atoi(handler)
+				if err := c.json(http.MethodPut, prefix+"/on-failure", map[string]int{"handlerId": handlerID}, nil); err != nil {
+					return applied, err
+				}
+				applied++
+			}
+		}
+	}
+	return applied, nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// manifestName is the file a pulled directory describes its functions in,
+// the same runbox.json a tar.gz bundle carries.
+const manifestName = "runbox.json"
+
+// bundle and bundleFunction mirror the server's bundle format.
+type bundle struct {
+	Format     string           `json:"format"`
+	Version    int              `json:"version"`
+	ExportedAt time.Time        `json:"exportedAt"`
+	Functions  []bundleFunction `json:"functions"`
+}
+
+type bundleFunction struct {
+	Name        string         `json:"name"`
+	Path        string         `json:"path"`
+	Description string         `json:"description,omitempty"`
+	Mode        string         `json:"mode"`
+	Tags        []string       `json:"tags,omitempty"`
+	SourceURL   string         `json:"sourceUrl,omitempty"`
+	Visibility  string         `json:"visibility,omitempty"`
+	Code        string         `json:"code,omitempty"`
+	File        string         `json:"file,omitempty"`
+	Files       []functionFile `json:"files,omitempty"`
+}
+
+type functionFile struct {
+	Name string `json:"name"`
+	Code string `json:"code,omitempty"`
+}
+
+// moduleFile is where a module of the function whose code is in file
+// lives, as in the server's archives.
+func moduleFile(file, name string) string {
+	return strings.TrimSuffix(file, ".js") + "/" + name
+}
+
+// readArchive returns the regular files of a tar.gz bundle by name,
+// refusing entries that would land outside the directory it unpacks to.
+func readArchive(data []byte) (map[string][]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	files := map[string][]byte{}
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("bad archive: %v", err)
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Clean(h.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return nil, fmt.Errorf("archive entry %s is outside the directory", h.Name)
+		}
+		if files[name], err = io.ReadAll(tr); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// writeArchive writes files as a tar.gz bundle, manifest first.
+func writeArchive(w io.Writer, files map[string][]byte) error {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return names[i] == manifestName || names[j] != manifestName && names[i] < names[j]
+	})
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	for _, name := range names {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(files[name])), ModTime: now}); err != nil {
+			return err
+		}
+		if _, err := tw.Write(files[name]); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// writeFiles writes files, named as in an archive, under dir.
+func writeFiles(dir string, files map[string][]byte) error {
+	for name, content := range files {
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(target, content, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dirReader reads the files of a pulled directory by archive name.
+func dirReader(dir string) func(name string) ([]byte, error) {
+	return func(name string) ([]byte, error) {
+		return os.ReadFile(filepath.Join(dir, filepath.FromSlash(path.Clean(name))))
+	}
+}
+
+// mapReader reads the files of an unpacked archive.
+func mapReader(files map[string][]byte) func(name string) ([]byte, error) {
+	return func(name string) ([]byte, error) {
+		content, ok := files[path.Clean(name)]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return content, nil
+	}
+}
+
+// readDir loads the functions in a pulled directory.
+func readDir(dir string) (*bundle, error) {
+	b, err := loadBundle(dirReader(dir))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s has no %s; run runbox pull first", dir, manifestName)
+	}
+	return b, err
+}
+
+// loadBundle reads runbox.json and moves each function's code and modules
+// back into it.
+func loadBundle(read func(name string) ([]byte, error)) (*bundle, error) {
+	data, err := read(manifestName)
+	if err != nil {
+		return nil, err
+	}
+	var b bundle
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("%s: %v", manifestName, err)
+	}
+	for i, f := range b.Functions {
+		if f.File == "" {
+			continue
+		}
+		code, err := read(f.File)
+		if err != nil {
+			return nil, fmt.Errorf("%s lists %s: %v", manifestName, f.File, err)
+		}
+		b.Functions[i].Code = string(code)
+		for j, m := range f.Files {
+			module, err := read(moduleFile(f.File, m.Name))
+			if err != nil {
+				return nil, fmt.Errorf("%s lists module %s of %s: %v", manifestName, m.Name, f.File, err)
+			}
+			b.Functions[i].Files[j].Code = string(module)
+		}
+		b.Functions[i].File = ""
+	}
+	return &b, nil
+}
+
+// findFunction is the function in b at path, matched with or without the
+// leading slash.
+func findFunction(b *bundle, fnPath string) (*bundleFunction, error) {
+	fnPath = "/" + strings.TrimPrefix(fnPath, "/")
+	for i := range b.Functions {
+		if b.Functions[i].Path == fnPath {
+			return &b.Functions[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no function at %s in %s", fnPath, manifestName)
+}
@@ -0,0 +1,434 @@
+package runbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const defaultConfigPath = "runbox.json"
+
+type Config struct {
+	Addr            string             `json:"addr"`
+	SocketMode      string             `json:"socketMode"`
+	TLS             TLSConfig          `json:"tls"`
+	H2C             bool               `json:"h2c"`
+	Server          ServerConfig       `json:"server"`
+	Database        string             `json:"database"`
+	Templates       string             `json:"templates"`
+	Static          string             `json:"static"`
+	ReadOnly        bool               `json:"readOnly"`
+	SeedDir         string             `json:"seedDir"`
+	NATS            NATSConfig         `json:"nats"`
+	Redis           RedisConfig        `json:"redis"`
+	MQTT            MQTTConfig         `json:"mqtt"`
+	Queue           QueueConfig        `json:"queue"`
+	Cluster         ClusterConfig      `json:"cluster"`
+	Email           EmailConfig        `json:"email"`
+	Storage         StorageConfig      `json:"storage"`
+	AI              AIConfig           `json:"ai"`
+	ExternalDBs     ExternalDatabases  `json:"externalDatabases"`
+	GeoIP           GeoIPConfig        `json:"geoip"`
+	GRPC            GRPCTargets        `json:"grpc"`
+	EventLog        EventLogConfig     `json:"eventLog"`
+	ExecutionLogs   ExecutionLogConfig `json:"executionLogs"`
+	Tracing         TracingConfig      `json:"tracing"`
+	Sentry          SentryConfig       `json:"sentry"`
+	Audit           AuditConfig        `json:"audit"`
+	AccessLog       AccessLogConfig    `json:"accessLog"`
+	StatsD          StatsDConfig       `json:"statsd"`
+	Secrets         SecretsConfig      `json:"secrets"`
+	Public          PublicConfig       `json:"public"`
+	Share           ShareConfig        `json:"share"`
+	Auth            AuthConfig         `json:"auth"`
+	Timeouts        TimeoutsConfig     `json:"timeouts"`
+	RateLimit       RateLimitConfig    `json:"rateLimit"`
+	CORS            CORSConfig         `json:"cors"`
+	LogLevel        string             `json:"logLevel"`
+	TrustedProxies  []string           `json:"trustedProxies"`
+	RemoteIPHeaders []string           `json:"remoteIPHeaders"`
+	// DevDir serves the functions in a directory from an in-memory
+	// database, reloading them as files change, as runbox-server dev does.
+	DevDir string `json:"-"`
+
+	// path is the file the config was read from, for reloads.
+	path     string
+	explicit bool
+}
+
+type NATSConfig struct {
+	URL  string `json:"url"`
+	Name string `json:"name"`
+}
+
+type RedisConfig struct {
+	Addr     string `json:"addr"`
+	Password string `json:"password"`
+	DB       int    `json:"db"`
+}
+
+type MQTTConfig struct {
+	Broker   string `json:"broker"`
+	ClientID string `json:"clientId"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// QueueConfig sets the retry policy of queued executions. Retry n waits
+// BackoffSeconds * 2^(n-1), capped at MaxBackoffSeconds.
+type QueueConfig struct {
+	MaxAttempts       int `json:"maxAttempts"`
+	BackoffSeconds    int `json:"backoffSeconds"`
+	MaxBackoffSeconds int `json:"maxBackoffSeconds"`
+}
+
+func (q QueueConfig) backoff(attempt int) time.Duration {
+	delay := time.Duration(q.BackoffSeconds) * time.Second
+	limit := time.Duration(q.MaxBackoffSeconds) * time.Second
+	for i := 1; i < attempt && delay < limit; i++ {
+		delay *= 2
+	}
+	if delay > limit {
+		delay = limit
+	}
+	return delay
+}
+
+// ClusterConfig enables leader election for instances sharing one
+// database. InstanceID defaults to the hostname plus a random suffix.
+// SyncMilliseconds is how often an instance checks for changes made by
+// the others (default 1000).
+type ClusterConfig struct {
+	Enabled          bool   `json:"enabled"`
+	InstanceID       string `json:"instanceId"`
+	LeaseSeconds     int    `json:"leaseSeconds"`
+	SyncMilliseconds int    `json:"syncMilliseconds"`
+}
+
+// EmailConfig backs runbox.email. Provider is "smtp", or "log" to only
+// write messages to the server log during development. QuotaPerHour limits
+// sends per function (default 100, negative for unlimited).
+type EmailConfig struct {
+	Provider     string     `json:"provider"`
+	From         string     `json:"from"`
+	QuotaPerHour int        `json:"quotaPerHour"`
+	SMTP         SMTPConfig `json:"smtp"`
+}
+
+// StorageConfig is the S3-compatible bucket behind runbox.storage.
+// Endpoint is a URL such as https://s3.amazonaws.com (the default) or
+// http://minio:9000; PathStyle addresses the bucket in the path rather
+// than the host name, as MinIO and most self-hosted stores need. Without
+// AccessKeyID the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY variables
+// are used. Objects live under Prefix (default "runbox/").
+type StorageConfig struct {
+	Endpoint        string `json:"endpoint"`
+	Region          string `json:"region"`
+	Bucket          string `json:"bucket"`
+	AccessKeyID     string `json:"accessKeyId"`
+	SecretAccessKey string `json:"secretAccessKey"`
+	PathStyle       bool   `json:"pathStyle"`
+	Prefix          string `json:"prefix"`
+}
+
+// ExternalDatabases are the databases besides RunBox's own, by name.
+type ExternalDatabases map[string]ExternalDatabaseConfig
+
+// ExternalDatabaseConfig is a Postgres or MySQL database that functions
+// reach with runbox.db(name). Only the function paths in Functions may
+// use it; a path ending in * grants everything below it. Each call is
+// limited to TimeoutSeconds (default 10).
+type ExternalDatabaseConfig struct {
+	Driver                 string   `json:"driver"`
+	DSN                    string   `json:"dsn"`
+	Functions              []string `json:"functions"`
+	MaxOpenConns           int      `json:"maxOpenConns"`
+	MaxIdleConns           int      `json:"maxIdleConns"`
+	ConnMaxLifetimeSeconds int      `json:"connMaxLifetimeSeconds"`
+	TimeoutSeconds         int      `json:"timeoutSeconds"`
+}
+
+// GRPCTargets are the gRPC servers functions call with runbox.grpc, by
+// fully-qualified service name.
+type GRPCTargets map[string]GRPCTargetConfig
+
+// GRPCTargetConfig is the server a service's methods are sent to. TLS
+// verifies it against the system roots; without it the connection is
+// plaintext, as on an internal network. Metadata is sent with every call.
+// Only the function paths in Functions may call it, as with external
+// databases, and each call is limited to TimeoutSeconds (default 10).
+type GRPCTargetConfig struct {
+	Address        string            `json:"address"`
+	TLS            bool              `json:"tls"`
+	Metadata       map[string]string `json:"metadata"`
+	Functions      []string          `json:"functions"`
+	TimeoutSeconds int               `json:"timeoutSeconds"`
+}
+
+// AIConfig is the OpenAI-compatible API behind runbox.ai. BaseURL
+// defaults to https://api.openai.com/v1 and the key is read from the
+// secret named APIKeySecret (default OPENAI_API_KEY), so it can be rotated
+// like any other. Model is used when a call names none.
+type AIConfig struct {
+	BaseURL        string `json:"baseUrl"`
+	APIKeySecret   string `json:"apiKeySecret"`
+	Model          string `json:"model"`
+	TimeoutSeconds int    `json:"timeoutSeconds"`
+}
+
+// GeoIPConfig points at MaxMind DB files: Database a GeoIP2 or GeoLite2
+// City or Country database, ASNDatabase an ASN one. With either, HTTP runs
+// get request.geo and functions can call runbox.geoip.
+type GeoIPConfig struct {
+	Database    string `json:"database"`
+	ASNDatabase string `json:"asnDatabase"`
+}
+
+type SMTPConfig struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// EventLogConfig sets how long event and trigger invocations are kept for
+// replay (default 30 days, negative to keep them forever).
+type EventLogConfig struct {
+	RetentionDays int `json:"retentionDays"`
+}
+
+// ExecutionLogConfig sets how long execution logs are kept (default 7 days,
+// negative to keep them forever) and how much of each request and response
+// is stored (default 4096 bytes). Sinks also ship every entry elsewhere.
+type ExecutionLogConfig struct {
+	RetentionDays int             `json:"retentionDays"`
+	MaxBodyBytes  int             `json:"maxBodyBytes"`
+	Sinks         []LogSinkConfig `json:"sinks"`
+}
+
+// LogSinkConfig is an execution log exporter. Type is "loki",
+// "elasticsearch", "http" or "syslog"; URL is the Loki or Elasticsearch base
+// URL, the endpoint batches are posted to, or udp:// or tcp://host:port for
+// syslog. Batches of BatchSize (default 100) are sent at least every
+// FlushSeconds (default 5); up to BufferSize (default 10000) entries wait.
+type LogSinkConfig struct {
+	Type         string            `json:"type"`
+	URL          string            `json:"url"`
+	Index        string            `json:"index"`
+	Labels       map[string]string `json:"labels"`
+	Headers      map[string]string `json:"headers"`
+	Username     string            `json:"username"`
+	Password     string            `json:"password"`
+	BatchSize    int               `json:"batchSize"`
+	FlushSeconds int               `json:"flushSeconds"`
+	BufferSize   int               `json:"bufferSize"`
+}
+
+// TracingConfig exports OpenTelemetry traces over OTLP. Protocol is
+// "http/protobuf" (the default) or "grpc"; an empty Endpoint uses the
+// OTEL_EXPORTER_OTLP_* environment variables. SampleRatio below 1 samples
+// that share of new traces.
+type TracingConfig struct {
+	Enabled     bool              `json:"enabled"`
+	Endpoint    string            `json:"endpoint"`
+	Protocol    string            `json:"protocol"`
+	Headers     map[string]string `json:"headers"`
+	Insecure    bool              `json:"insecure"`
+	ServiceName string            `json:"serviceName"`
+	SampleRatio float64           `json:"sampleRatio"`
+}
+
+// SentryConfig reports execution errors and panics to Sentry or a
+// compatible service. SampleRate below 1 sends that share of errors.
+type SentryConfig struct {
+	DSN         string  `json:"dsn"`
+	Environment string  `json:"environment"`
+	Release     string  `json:"release"`
+	SampleRate  float64 `json:"sampleRate"`
+}
+
+// AuditConfig names the request header an authenticating proxy puts the
+// user in (default X-Forwarded-User). It is only read from trustedProxies;
+// other changes are logged under the name of their API key.
+type AuditConfig struct {
+	UserHeader string `json:"userHeader"`
+}
+
+// AccessLogConfig writes the execute routes' requests to Path, a file or
+// "stdout", in Apache "combined" (the default) or "common" log format.
+type AccessLogConfig struct {
+	Path   string `json:"path"`
+	Format string `json:"format"`
+}
+
+// StatsDConfig pushes metrics over UDP to the StatsD server at Addr, named
+// under Prefix (default "runbox"), every FlushSeconds (default 10).
+type StatsDConfig struct {
+	Addr         string `json:"addr"`
+	Prefix       string `json:"prefix"`
+	FlushSeconds int    `json:"flushSeconds"`
+}
+
+// SecretsConfig holds the key secrets are encrypted with at rest: 32
+// bytes, base64-encoded, taken from RUNBOX_SECRETS_KEY when Key is empty.
+// Without either, secrets are stored unencrypted. With Vault.Address set,
+// secrets are kept in Vault instead and Key is unused.
+type SecretsConfig struct {
+	Key   string      `json:"key"`
+	Vault VaultConfig `json:"vault"`
+}
+
+// VaultConfig keeps secrets in a HashiCorp Vault KV version 2 engine
+// mounted at Mount (default "secret"), one entry per secret under Path
+// (default "runbox"), with the value in its "value" field. Runbox logs in
+// with AppRole when AppRole.RoleID is set and with Token, or VAULT_TOKEN,
+// otherwise, and renews its token before it expires. Values read are
+// cached for CacheSeconds (default 60).
+type VaultConfig struct {
+	Address      string             `json:"address"`
+	Namespace    string             `json:"namespace"`
+	Mount        string             `json:"mount"`
+	Path         string             `json:"path"`
+	Token        string             `json:"token"`
+	AppRole      VaultAppRoleConfig `json:"appRole"`
+	CacheSeconds int                `json:"cacheSeconds"`
+}
+
+// VaultAppRoleConfig is an AppRole login at the auth method mounted at
+// Mount (default "approle"). SecretID is taken from VAULT_SECRET_ID when
+// empty.
+type VaultAppRoleConfig struct {
+	Mount    string `json:"mount"`
+	RoleID   string `json:"roleId"`
+	SecretID string `json:"secretId"`
+}
+
+// PublicConfig is how clients reach this instance, for the request
+// snippets on function pages. URL defaults to the address the page was
+// loaded from. AuthHeader, with AuthScheme before the credential, adds the
+// header a gateway in front of runbox expects, such as Authorization:
+// Bearer $RUNBOX_TOKEN.
+type PublicConfig struct {
+	URL        string `json:"url"`
+	AuthHeader string `json:"authHeader"`
+	AuthScheme string `json:"authScheme"`
+}
+
+// ShareConfig holds the key share links are signed with, taken from
+// RUNBOX_SHARE_KEY when Key is empty. Without either, a key is made once
+// and kept in the database.
+type ShareConfig struct {
+	Key string `json:"key"`
+}
+
+// AuthConfig holds the API tokens that private functions accept, joined
+// by any in RUNBOX_API_TOKENS, and the visibility of functions that don't
+// set one: public unless DefaultVisibility is private. BootstrapTokenFile
+// receives the first-start bootstrap token instead of the log.
+type AuthConfig struct {
+	Tokens             []string `json:"tokens"`
+	DefaultVisibility  string   `json:"defaultVisibility"`
+	BootstrapTokenFile string   `json:"bootstrapTokenFile"`
+}
+
+// TLSConfig serves HTTPS with the PEM certificate chain in CertFile and
+// its key in KeyFile; clients that support it get HTTP/2.
+type TLSConfig struct {
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+}
+
+func (t TLSConfig) enabled() bool { return t.CertFile != "" || t.KeyFile != "" }
+
+// ServerConfig hardens the listener: how long a client may take to send
+// the headers (default 10 seconds), the whole request, or to read the
+// response, how long idle keep-alive connections stay open (default the
+// read timeout), and the largest request headers (default 1 MiB). Zero
+// timeouts don't time out. WriteTimeoutSeconds also bounds executions and
+// live log streams, so leave it above the longest of those.
+type ServerConfig struct {
+	ReadHeaderTimeoutSeconds int  `json:"readHeaderTimeoutSeconds"`
+	ReadTimeoutSeconds       int  `json:"readTimeoutSeconds"`
+	WriteTimeoutSeconds      int  `json:"writeTimeoutSeconds"`
+	IdleTimeoutSeconds       int  `json:"idleTimeoutSeconds"`
+	MaxHeaderBytes           int  `json:"maxHeaderBytes"`
+	DisableKeepAlives        bool `json:"disableKeepAlives"`
+}
+
+// TimeoutsConfig bounds each HTTP execution to ExecutionSeconds; zero
+// lets executions run until the client goes away. JobSeconds bounds each
+// attempt of an async job, delayed invocation or retry, and defaults to
+// ExecutionSeconds, or 5 minutes when that is zero too.
+type TimeoutsConfig struct {
+	ExecutionSeconds int `json:"executionSeconds"`
+	JobSeconds       int `json:"jobSeconds"`
+}
+
+// RateLimitConfig limits each client IP to RequestsPerSecond on the
+// execute routes, with bursts of up to Burst (default RequestsPerSecond).
+// Zero is unlimited.
+type RateLimitConfig struct {
+	RequestsPerSecond float64 `json:"requestsPerSecond"`
+	Burst             int     `json:"burst"`
+}
+
+// CORSConfig answers cross-origin calls to the execute routes from
+// AllowedOrigins ("*" for any). AllowedMethods and AllowedHeaders default
+// to the request's own; MaxAgeSeconds lets browsers cache preflights.
+type CORSConfig struct {
+	AllowedOrigins   []string `json:"allowedOrigins"`
+	AllowedMethods   []string `json:"allowedMethods"`
+	AllowedHeaders   []string `json:"allowedHeaders"`
+	AllowCredentials bool     `json:"allowCredentials"`
+	MaxAgeSeconds    int      `json:"maxAgeSeconds"`
+}
+
+// DefaultConfig is the config without a file: port 8080 and runbox.db
+// in the working directory.
+func DefaultConfig() Config {
+	return Config{
+		Addr:     ":8080",
+		Database: "./runbox.db",
+		Server: ServerConfig{
+			ReadHeaderTimeoutSeconds: 10,
+		},
+		Queue: QueueConfig{
+			MaxAttempts:       3,
+			BackoffSeconds:    2,
+			MaxBackoffSeconds: 300,
+		},
+	}
+}
+
+// LoadConfig reads the JSON config file at path on top of DefaultConfig.
+// An empty path reads runbox.json from the working directory, if there is
+// one.
+func LoadConfig(path string) (Config, error) {
+	if path == "" {
+		return loadConfig(defaultConfigPath, false)
+	}
+	return loadConfig(path, true)
+}
+
+// loadConfig reads the JSON config file at path on top of the defaults. A
+// missing file is only an error when the path was given explicitly.
+func loadConfig(path string, explicit bool) (Config, error) {
+	config := DefaultConfig()
+	config.path, config.explicit = path, explicit
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return config, nil
+	}
+	if err != nil {
+		return config, err
+	}
+
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+
+	return config, nil
+}
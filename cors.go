@@ -0,0 +1,56 @@
+package runbox
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// corsMiddleware answers cross-origin calls to the execute routes from the
+// origins in cors.allowedOrigins. Preflights are answered here; functions
+// still see every other request, and can set CORS headers themselves
+// when none is configured.
+func (app *App) corsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		config := app.live().CORS
+		origin := c.GetHeader("Origin")
+		if len(config.AllowedOrigins) == 0 || origin == "" {
+			return
+		}
+		c.Writer.Header().Add("Vary", "Origin")
+		if !slices.Contains(config.AllowedOrigins, "*") && !slices.Contains(config.AllowedOrigins, origin) {
+			return
+		}
+		if slices.Contains(config.AllowedOrigins, "*") && !config.AllowCredentials {
+			c.Header("Access-Control-Allow-Origin", "*")
+		} else {
+			c.Header("Access-Control-Allow-Origin", origin)
+		}
+		if config.AllowCredentials {
+			c.Header("Access-Control-Allow-Credentials", "true")
+		}
+
+		method := c.GetHeader("Access-Control-Request-Method")
+		if c.Request.Method != http.MethodOptions || method == "" {
+			return
+		}
+		methods, headers := method, c.GetHeader("Access-Control-Request-Headers")
+		if len(config.AllowedMethods) > 0 {
+			methods = strings.Join(config.AllowedMethods, ", ")
+		}
+		if len(config.AllowedHeaders) > 0 {
+			headers = strings.Join(config.AllowedHeaders, ", ")
+		}
+		c.Header("Access-Control-Allow-Methods", methods)
+		if headers != "" {
+			c.Header("Access-Control-Allow-Headers", headers)
+		}
+		if config.MaxAgeSeconds > 0 {
+			c.Header("Access-Control-Max-Age", strconv.Itoa(config.MaxAgeSeconds))
+		}
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,328 @@
+package runbox
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// curlBareFlags are curl options that take no value; they are skipped, as
+// are the options in curlValueFlags along with their value.
+var curlBareFlags = map[string]bool{
+	"-s": true, "--silent": true, "-S": true, "--show-error": true, "-v": true, "--verbose": true,
+	"-L": true, "--location": true, "-k": true, "--insecure": true, "-i": true, "--include": true,
+	"-f": true, "--fail": true, "--compressed": true, "-#": true, "--progress-bar": true,
+	"-N": true, "--no-buffer": true, "--http1.1": true, "--http2": true, "-g": true, "--globoff": true,
+}
+
+var curlValueFlags = map[string]bool{
+	"-o": true, "--output": true, "-m": true, "--max-time": true, "--connect-timeout": true,
+	"-w": true, "--write-out": true, "--retry": true, "-x": true, "--proxy": true,
+	"--cacert": true, "--cert": true, "--key": true, "--resolve": true, "-c": true, "--cookie-jar": true,
+}
+
+// curlRequest is what parseCurl reads out of a curl command line.
+type curlRequest struct {
+	Method  string
+	Path    string
+	Query   string
+	Headers map[string]string
+	Body    string
+}
+
+// splitShellWords splits a command line the way a POSIX shell would for the
+// quoting curl commands use: single and double quotes, backslash escapes
+// and backslash-newline continuations.
+func splitShellWords(command string) ([]string, error) {
+	var (
+		words   []string
+		word    strings.Builder
+		inWord  bool
+		quote   rune
+		escaped bool
+	)
+	for _, r := range command {
+		switch {
+		case escaped:
+			escaped = false
+			if r == '\n' {
+				continue
+			}
+			if quote == '"' && !strings.ContainsRune("\"\\$`", r) {
+				word.WriteRune('\\')
+			}
+			word.WriteRune(r)
+			inWord = true
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\\':
+			escaped = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// parseCurl reads the method, URL, headers and body of a curl command. A
+// URL under /api/execute is taken to be a runbox route and has the prefix
+// removed from Path.
+func parseCurl(command string) (*curlRequest, error) {
+	words, err := splitShellWords(strings.TrimSpace(command))
+	if err != nil {
+		return nil, err
+	}
+	if len(words) == 0 || words[0] != "curl" {
+		return nil, fmt.Errorf("expected a command starting with curl")
+	}
+
+	var (
+		method, rawURL string
+		data           []string
+		get, head      bool
+	)
+	headers := map[string]string{}
+	setHeader := func(line string) {
+		if i := strings.Index(line, ":"); i > 0 {
+			headers[http.CanonicalHeaderKey(strings.TrimSpace(line[:i]))] = strings.TrimSpace(line[i+1:])
+		}
+	}
+
+	for i := 1; i < len(words); i++ {
+		flag, value, attached := words[i], "", false
+		switch {
+		case strings.HasPrefix(flag, "--") && strings.Contains(flag, "="):
+			flag, value, _ = strings.Cut(flag, "=")
+			attached = true
+		case len(flag) > 2 && flag[0] == '-' && flag[1] != '-':
+			// Short options either cluster (-sSL) or carry their value (-XPOST).
+			if curlBareFlags["-"+flag[1:2]] && allBareFlags(flag[1:]) {
+				continue
+			}
+			flag, value, attached = flag[:2], flag[2:], true
+		}
+		next := func() (string, error) {
+			if attached {
+				return value, nil
+			}
+			if i+1 >= len(words) {
+				return "", fmt.Errorf("%s needs a value", flag)
+			}
+			i++
+			return words[i], nil
+		}
+
+		switch flag {
+		case "-X", "--request", "-H", "--header", "-d", "--data", "--data-raw", "--data-binary", "--data-ascii",
+			"--data-urlencode", "--json", "-u", "--user", "-A", "--user-agent", "-e", "--referer", "-b", "--cookie", "--url":
+			v, err := next()
+			if err != nil {
+				return nil, err
+			}
+			switch flag {
+			case "-X", "--request":
+				method = strings.ToUpper(v)
+			case "-H", "--header":
+				setHeader(v)
+			case "--data-urlencode":
+				if name, content, ok := strings.Cut(v, "="); ok {
+					data = append(data, name+"="+url.QueryEscape(content))
+				} else {
+					data = append(data, url.QueryEscape(v))
+				}
+			case "--json":
+				data = append(data, v)
+				if _, ok := headers["Content-Type"]; !ok {
+					headers["Content-Type"] = "application/json"
+				}
+				if _, ok := headers["Accept"]; !ok {
+					headers["Accept"] = "application/json"
+				}
+			case "-u", "--user":
+				headers["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(v))
+			case "-A", "--user-agent":
+				headers["User-Agent"] = v
+			case "-e", "--referer":
+				headers["Referer"] = v
+			case "-b", "--cookie":
+				headers["Cookie"] = v
+			case "--url":
+				rawURL = v
+			default:
+				data = append(data, v)
+			}
+		case "-G", "--get":
+			get = true
+		case "-I", "--head":
+			head = true
+		default:
+			switch {
+			case curlValueFlags[flag]:
+				if _, err := next(); err != nil {
+					return nil, err
+				}
+			case strings.HasPrefix(flag, "-"):
+			case rawURL == "":
+				rawURL = flag
+			}
+		}
+	}
+
+	if rawURL == "" {
+		return nil, fmt.Errorf("no URL in the curl command")
+	}
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "http://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %v", err)
+	}
+
+	req := &curlRequest{Headers: headers, Query: u.RawQuery}
+	body := strings.Join(data, "&")
+	switch {
+	case get && body != "":
+		if req.Query != "" {
+			req.Query += "&"
+		}
+		req.Query += body
+		body = ""
+	case body != "":
+		if _, ok := headers["Content-Type"]; !ok {
+			headers["Content-Type"] = "application/x-www-form-urlencoded"
+		}
+	}
+	req.Body = body
+
+	switch {
+	case method != "":
+		req.Method = method
+	case head:
+		req.Method = http.MethodHead
+	case body != "":
+		req.Method = http.MethodPost
+	default:
+		req.Method = http.MethodGet
+	}
+
+	req.Path = u.Path
+	for _, prefix := range []string{"/api/execute-async", "/api/execute"} {
+		if rest, ok := strings.CutPrefix(req.Path, prefix); ok && (rest == "" || rest[0] == '/') {
+			req.Path = rest
+			break
+		}
+	}
+	if req.Path == "" {
+		req.Path = "/"
+	}
+	return req, nil
+}
+
+func allBareFlags(cluster string) bool {
+	for _, c := range cluster {
+		if !curlBareFlags["-"+string(c)] {
+			return false
+		}
+	}
+	return true
+}
+
+type curlImportInput struct {
+	Command string `json:"command"`
+	Name    string `json:"name"`
+}
+
+// importCurlHandler serves POST /api/functions/:id/saved-requests/import:
+// it saves a curl command as one of the function's saved requests. The
+// response says which function serves the command's URL, and suggests
+// the path for a new function when none does.
+func (app *App) importCurlHandler(c *gin.Context) {
+	functionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+	function, err := app.getFunctionByID(functionID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Function not found"})
+		return
+	}
+
+	var in curlImportInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid import body"})
+		return
+	}
+	parsed, err := parseCurl(in.Command)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not read the curl command: " + err.Error()})
+		return
+	}
+
+	saved := savedRequestInput{
+		Name:    in.Name,
+		Method:  parsed.Method,
+		Query:   parsed.Query,
+		Headers: parsed.Headers,
+		Body:    parsed.Body,
+	}
+	if strings.TrimSpace(saved.Name) == "" {
+		saved.Name = parsed.Method + " " + parsed.Path
+		if len(saved.Name) > maxSavedRequestName {
+			saved.Name = saved.Name[:maxSavedRequestName]
+		}
+	}
+	result := gin.H{"path": parsed.Path}
+	if served, subpath, err := app.resolveFunction(c.Request.Context(), parsed.Path); err == nil {
+		result["servedBy"] = gin.H{"id": served.ID, "name": served.Name, "path": served.Path}
+		if served.ID == function.ID && subpath != "/" {
+			saved.Subpath = subpath
+		}
+	} else {
+		result["suggestedPath"] = parsed.Path
+	}
+	if msg := saved.validate(); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	request, err := app.saveRequest(functionID, &saved)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save request: " + err.Error()})
+		return
+	}
+	result["request"] = request
+	c.JSON(http.StatusCreated, result)
+}
@@ -0,0 +1,147 @@
+package runbox
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// seriesSteps are the bucket widths a time series is drawn with; the
+// smallest one that keeps the series under maxSeriesPoints is used.
+var seriesSteps = []time.Duration{
+	time.Minute, 5 * time.Minute, 15 * time.Minute, time.Hour, 6 * time.Hour, 24 * time.Hour,
+}
+
+const maxSeriesPoints = 120
+
+func seriesStep(window time.Duration) time.Duration {
+	for _, step := range seriesSteps {
+		if window/step <= maxSeriesPoints {
+			return step
+		}
+	}
+	return seriesSteps[len(seriesSteps)-1]
+}
+
+// metricsSeries adds up the stored rows of the given functions into buckets
+// of step since since. What this instance has not flushed yet goes into the
+// last bucket.
+func (app *App) metricsSeries(ids []int, since, now time.Time, step time.Duration) ([]time.Time, []*functionStats, error) {
+	start := since.Truncate(step)
+	n := int(now.Sub(start)/step) + 1
+	times := make([]time.Time, n)
+	points := make([]*functionStats, n)
+	for i := range points {
+		times[i] = start.Add(time.Duration(i) * step)
+		points[i] = newFunctionStats()
+	}
+	if len(ids) == 0 {
+		return times, points, nil
+	}
+	for _, id := range ids {
+		points[n-1].merge(app.metrics.snapshot(id))
+	}
+
+	args := []interface{}{since}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	rows, err := app.db.Query(`SELECT bucket, invocations, errors, slow, total_ms, max_ms, histogram FROM function_metrics
+		WHERE bucket >= ? AND function_id IN (?`+strings.Repeat(`, ?`, len(ids)-1)+`)`, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bucket time.Time
+		var histogram string
+		row := newFunctionStats()
+		if err := rows.Scan(&bucket, &row.Invocations, &row.Errors, &row.Slow, &row.TotalMs, &row.MaxMs, &histogram); err != nil {
+			return nil, nil, err
+		}
+		json.Unmarshal([]byte(histogram), &row.Histogram)
+
+		i := int(bucket.Sub(start) / step)
+		if i < 0 || i >= n {
+			continue
+		}
+		points[i].merge(row)
+	}
+
+	return times, points, nil
+}
+
+// metricsSeriesHandler serves GET /api/metrics/series: request volume,
+// errors and latency per bucket over window, for every function or the
+// functionId ones.
+func (app *App) metricsSeriesHandler(c *gin.Context) {
+	window, err := parseWindow(c.Query("window"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var ids []int
+	for _, v := range c.QueryArray("functionId") {
+		for _, part := range strings.Split(v, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+				return
+			}
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		functions, err := app.getAllFunctions()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list functions"})
+			return
+		}
+		for _, f := range functions {
+			ids = append(ids, f.ID)
+		}
+	}
+
+	now := time.Now().UTC()
+	step := seriesStep(window)
+	times, points, err := app.metricsSeries(ids, now.Add(-window), now, step)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load metrics"})
+		return
+	}
+
+	total := newFunctionStats()
+	series := make([]gin.H, len(points))
+	for i, p := range points {
+		total.merge(p)
+		point := p.summary()
+		point["at"] = times[i]
+		series[i] = point
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"window": window.String(),
+		"step":   step.String(),
+		"total":  total.summary(),
+		"series": series,
+	})
+}
+
+func (app *App) dashboardPage(c *gin.Context) {
+	functions, err := app.getAllFunctions()
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": err.Error()})
+		return
+	}
+
+	c.HTML(http.StatusOK, "dashboard.html", gin.H{
+		"title":     "Dashboard",
+		"functions": functions,
+	})
+}
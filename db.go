@@ -0,0 +1,70 @@
+package runbox
+
+import "github.com/prodemmi/runbox/store"
+
+// initDB opens the database and creates the tables, starting with the
+// functions the store keeps.
+func (app *App) initDB() error {
+	var err error
+	if app.db, err = store.Open(app.config.Database); err != nil {
+		return err
+	}
+	app.store = store.New(app.db)
+	if err := app.store.Init(); err != nil {
+		return err
+	}
+
+	for _, init := range []func() error{
+		app.initJobsTable,
+		app.initCloudEventsTable,
+		app.initSchedulesTable,
+		app.initSavedRequestsTable,
+		app.initShareLinksTable,
+		app.initSubscriptionsTable,
+		app.initTriggersTable,
+		app.initWebhooksTable,
+		app.initDeadLettersTable,
+		app.initWorkflowsTables,
+		app.initLeasesTable,
+		app.initClusterChangesTable,
+		app.initAPIKeysTable,
+		app.initKeepWarmTable,
+		app.initDeploymentsTable,
+		app.initEmailTable,
+		app.initMaterializationsTable,
+		app.initEventLogTable,
+		app.initFailureHandlersTable,
+		app.initExecutionLogsTable,
+		app.initMetricsTable,
+		app.initErrorGroupsTable,
+		app.initAlertRulesTable,
+		app.initLatencySLOTable,
+		app.initTrafficAlertsTable,
+		app.initCaptureTables,
+		app.initUsageTables,
+		app.initAuditLogTable,
+		app.initKVTable,
+		app.initTemplatesTable,
+		app.initEnvTable,
+		app.initSecretsTable,
+		app.initFlagsTable,
+		app.initRenderTemplatesTable,
+		app.initFunctionProtosTable,
+		app.initMaintenanceTable,
+	} {
+		if err := init(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addColumns adds columns, each a name and a definition, to an existing
+// table unless they are already present, so databases created by older
+
+// addColumns adds columns, each a name and a definition, to an existing
+// table unless they are already present, so databases created by older
+// releases pick up new fields on startup.
+func (app *App) addColumns(table string, columns [][2]string) error {
+	return store.AddColumns(app.db, table, columns)
+}
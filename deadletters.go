@@ -0,0 +1,350 @@
+package runbox
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DeadLetter is a queued execution that failed on every attempt. It keeps
+// the original request so it can be requeued once the cause is fixed, and
+// the error of each attempt.
+type DeadLetter struct {
+	ID         int             `json:"id"`
+	JobID      string          `json:"jobId"`
+	FunctionID int             `json:"functionId"`
+	Path       string          `json:"path"`
+	Method     string          `json:"method"`
+	Source     string          `json:"source"`
+	Request    json.RawMessage `json:"request,omitempty"`
+	Error      string          `json:"error"`
+	Attempts   int             `json:"attempts"`
+	History    []AttemptError  `json:"history"`
+	CreatedAt  time.Time       `json:"createdAt"`
+}
+
+type AttemptError struct {
+	Attempt int       `json:"attempt"`
+	Error   string    `json:"error"`
+	At      time.Time `json:"at"`
+}
+
+func (app *App) initDeadLettersTable() error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS dead_letters (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		job_id TEXT NOT NULL,
+		function_id INTEGER NOT NULL,
+		path TEXT NOT NULL,
+		method TEXT NOT NULL,
+		source TEXT NOT NULL,
+		request TEXT,
+		error TEXT NOT NULL,
+		attempts INTEGER NOT NULL,
+		created_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_dead_letters_function ON dead_letters (function_id, created_at);`
+
+	if _, err := app.db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create dead_letters table: %v", err)
+	}
+
+	return app.addColumns("dead_letters", [][2]string{
+		{"history", "TEXT"},
+	})
+}
+
+// recordAttemptError appends the error of the job's current attempt to its
+// history, which is carried over when the job is dead-lettered.
+func (app *App) recordAttemptError(job *Job, errMsg string) {
+	_, err := app.db.Exec(`UPDATE jobs SET attempt_errors = json_insert(COALESCE(attempt_errors, '[]'), '$[#]',
+		json_object('attempt', attempt, 'error', ?, 'at', ?)) WHERE id = ?`,
+		errMsg, time.Now().UTC().Format(time.RFC3339Nano), job.ID)
+	if err != nil {
+		log.Printf("Failed to record error of job %s: %v", job.ID, err)
+	}
+}
+
+func (app *App) deadLetter(job *Job, errMsg string) error {
+	query := `INSERT INTO dead_letters (job_id, function_id, path, method, source, request, error, attempts, history, created_at)
+		SELECT ?, ?, ?, ?, ?, ?, ?, ?, (SELECT attempt_errors FROM jobs WHERE id = ?), ?`
+	_, err := app.db.Exec(query, job.ID, job.FunctionID, job.Path, job.Method, job.Source,
+		string(job.Request), errMsg, job.Attempt, job.ID, time.Now().UTC())
+	return err
+}
+
+const deadLetterColumns = `id, job_id, function_id, path, method, source, request, error, attempts, history, created_at`
+
+func scanDeadLetter(row rowScanner) (*DeadLetter, error) {
+	var d DeadLetter
+	var request string
+	var history sql.NullString
+	err := row.Scan(&d.ID, &d.JobID, &d.FunctionID, &d.Path, &d.Method, &d.Source, &request, &d.Error, &d.Attempts, &history, &d.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if request != "" {
+		d.Request = json.RawMessage(request)
+	}
+	d.History = []AttemptError{}
+	if history.Valid {
+		json.Unmarshal([]byte(history.String), &d.History)
+	}
+	return &d, nil
+}
+
+func (app *App) getDeadLetter(id int) (*DeadLetter, error) {
+	return scanDeadLetter(app.db.QueryRow(`SELECT `+deadLetterColumns+` FROM dead_letters WHERE id = ?`, id))
+}
+
+func (app *App) listDeadLetters(functionID, limit, offset int) ([]DeadLetter, error) {
+	query := `SELECT ` + deadLetterColumns + ` FROM dead_letters`
+	var args []interface{}
+	if functionID != 0 {
+		query += ` WHERE function_id = ?`
+		args = append(args, functionID)
+	}
+	query += ` ORDER BY created_at DESC LIMIT ? OFFSET ?`
+	args = append(args, limit, offset)
+
+	rows, err := app.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	letters := []DeadLetter{}
+	for rows.Next() {
+		d, err := scanDeadLetter(rows)
+		if err != nil {
+			return nil, err
+		}
+		letters = append(letters, *d)
+	}
+
+	return letters, nil
+}
+
+// requeueDeadLetter enqueues the stored request again as a fresh job with a
+// full set of attempts against the function's current version, and removes
+// the dead letter.
+func (app *App) requeueDeadLetter(d *DeadLetter) (*Job, error) {
+	function, err := app.getFunctionByID(d.FunctionID)
+	if err != nil {
+		return nil, err
+	}
+
+	var requestData map[string]interface{}
+	if err := json.Unmarshal(d.Request, &requestData); err != nil {
+		return nil, err
+	}
+
+	job, err := app.enqueueJob(function, requestData, jobOptions{Source: d.Source})
+	if err != nil {
+		return nil, err
+	}
+
+	app.db.Exec(`DELETE FROM dead_letters WHERE id = ?`, d.ID)
+	return job, nil
+}
+
+func (app *App) listDeadLettersHandler(c *gin.Context) {
+	functionID, limit, offset := 0, 50, 0
+	var err error
+	if v := c.Query("function_id"); v != "" {
+		if functionID, err = strconv.Atoi(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function_id"})
+			return
+		}
+	}
+	if v := c.Query("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > 500 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 500"})
+			return
+		}
+	}
+	if v := c.Query("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+			return
+		}
+	}
+
+	letters, err := app.listDeadLetters(functionID, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list dead letters"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deadLetters": letters})
+}
+
+func (app *App) getDeadLetterHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid dead letter ID"})
+		return
+	}
+
+	d, err := app.getDeadLetter(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Dead letter not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, d)
+}
+
+func (app *App) requeueDeadLetterHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid dead letter ID"})
+		return
+	}
+
+	d, err := app.getDeadLetter(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Dead letter not found"})
+		return
+	}
+
+	job, err := app.requeueDeadLetter(d)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Failed to requeue", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"jobId": job.ID, "status": job.Status})
+}
+
+func (app *App) deleteDeadLetter(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid dead letter ID"})
+		return
+	}
+
+	if _, err := app.db.Exec(`DELETE FROM dead_letters WHERE id = ?`, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete dead letter"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Dead letter deleted successfully"})
+}
+
+// deadLetterSelection picks dead letters for a bulk action: the given ids,
+// or with all set every dead letter, optionally of one function.
+type deadLetterSelection struct {
+	IDs        []int `json:"ids"`
+	FunctionID int   `json:"functionId"`
+	All        bool  `json:"all"`
+}
+
+func (app *App) selectDeadLetters(c *gin.Context) ([]DeadLetter, bool) {
+	var sel deadLetterSelection
+	if err := c.ShouldBindJSON(&sel); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid selection body"})
+		return nil, false
+	}
+	if len(sel.IDs) == 0 && !sel.All {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ids or all is required"})
+		return nil, false
+	}
+
+	query := `SELECT ` + deadLetterColumns + ` FROM dead_letters`
+	var conditions []string
+	var args []interface{}
+	if len(sel.IDs) > 0 {
+		conditions = append(conditions, `id IN (?`+strings.Repeat(`, ?`, len(sel.IDs)-1)+`)`)
+		for _, id := range sel.IDs {
+			args = append(args, id)
+		}
+	}
+	if sel.FunctionID != 0 {
+		conditions = append(conditions, `function_id = ?`)
+		args = append(args, sel.FunctionID)
+	}
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, ` AND `)
+	}
+
+	rows, err := app.db.Query(query+` ORDER BY created_at`, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load dead letters"})
+		return nil, false
+	}
+	defer rows.Close()
+
+	letters := []DeadLetter{}
+	for rows.Next() {
+		if d, err := scanDeadLetter(rows); err == nil {
+			letters = append(letters, *d)
+		}
+	}
+	return letters, true
+}
+
+func (app *App) bulkRequeueDeadLetters(c *gin.Context) {
+	letters, ok := app.selectDeadLetters(c)
+	if !ok {
+		return
+	}
+
+	jobIDs := []string{}
+	failures := []gin.H{}
+	for i := range letters {
+		job, err := app.requeueDeadLetter(&letters[i])
+		if err != nil {
+			failures = append(failures, gin.H{"id": letters[i].ID, "error": err.Error()})
+			continue
+		}
+		jobIDs = append(jobIDs, job.ID)
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"requeued": len(jobIDs), "jobIds": jobIDs, "failures": failures})
+}
+
+func (app *App) bulkDiscardDeadLetters(c *gin.Context) {
+	letters, ok := app.selectDeadLetters(c)
+	if !ok {
+		return
+	}
+
+	discarded := 0
+	for _, d := range letters {
+		if _, err := app.db.Exec(`DELETE FROM dead_letters WHERE id = ?`, d.ID); err == nil {
+			discarded++
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"discarded": discarded})
+}
+
+func (app *App) deadLettersPage(c *gin.Context) {
+	functionID, _ := strconv.Atoi(c.Query("function_id"))
+
+	letters, err := app.listDeadLetters(functionID, 200, 0)
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": err.Error()})
+		return
+	}
+	functions, err := app.getAllFunctions()
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": err.Error()})
+		return
+	}
+
+	c.HTML(http.StatusOK, "dead_letters.html", gin.H{
+		"title":       "Dead Letters",
+		"deadLetters": letters,
+		"functions":   functions,
+		"functionId":  functionID,
+	})
+}
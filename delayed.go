@@ -0,0 +1,136 @@
+package runbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/robertkrimen/otto"
+)
+
+type delayedInput struct {
+	Path    string          `json:"path"`
+	Method  string          `json:"method"`
+	Payload json.RawMessage `json:"payload"`
+	RunAt   *time.Time      `json:"runAt"`
+	Delay   string          `json:"delay"`
+}
+
+// scheduleOnce persists a single future execution of the function serving
+// path. The job survives restarts and is picked up by the scheduler loop.
+func (app *App) scheduleOnce(path, method string, payload json.RawMessage, runAt time.Time) (*Job, error) {
+	function, subpath, err := app.resolveFunction(context.Background(), path)
+	if err != nil {
+		return nil, err
+	}
+
+	if method == "" {
+		method = http.MethodPost
+	}
+	requestData := syntheticRequest(function, strings.ToUpper(method), payload)
+	requestData["path"] = path
+	requestData["subpath"] = subpath
+
+	return app.enqueueJob(function, requestData, jobOptions{Source: JobSourceDelayed, RunAt: runAt})
+}
+
+func (app *App) createDelayedInvocation(c *gin.Context) {
+	var in delayedInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+	if in.Path == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "path is required"})
+		return
+	}
+	if len(in.Payload) > 0 && !json.Valid(in.Payload) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "payload must be valid JSON"})
+		return
+	}
+
+	var runAt time.Time
+	switch {
+	case in.RunAt != nil:
+		runAt = *in.RunAt
+	case in.Delay != "":
+		delay, err := time.ParseDuration(in.Delay)
+		if err != nil || delay < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "delay must be a positive duration such as \"90s\" or \"2h\""})
+			return
+		}
+		runAt = time.Now().Add(delay)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "runAt or delay is required"})
+		return
+	}
+
+	if !strings.HasPrefix(in.Path, "/") {
+		in.Path = "/" + in.Path
+	}
+
+	job, err := app.scheduleOnce(in.Path, in.Method, in.Payload, runAt)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Function not found"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+// jsSchedule implements runbox.schedule(path, payload, runAt). runAt may be
+// a Date, an ISO-8601 string, or milliseconds since the epoch.
+func (app *App) jsSchedule(call otto.FunctionCall, exec *execution) otto.Value {
+	path := call.Argument(0).String()
+	if !call.Argument(0).IsString() || path == "" {
+		throwError(call, "runbox.schedule: path must be a non-empty string")
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	var payload json.RawMessage
+	if arg := call.Argument(1); arg.IsDefined() && !arg.IsNull() {
+		exported, _ := arg.Export()
+		b, err := json.Marshal(exported)
+		if err != nil {
+			throwError(call, "runbox.schedule: payload must be JSON-serializable")
+		}
+		payload = b
+	}
+
+	runAt, err := jsTime(call.Argument(2))
+	if err != nil {
+		throwError(call, "runbox.schedule: "+err.Error())
+	}
+
+	job, err := app.scheduleOnce(path, http.MethodPost, payload, runAt)
+	if err != nil {
+		throwError(call, "runbox.schedule: "+err.Error())
+	}
+
+	exec.logs = append(exec.logs, "scheduled "+path+" for "+runAt.UTC().Format(time.RFC3339)+" as job "+job.ID)
+	return toValue(call, job.ID)
+}
+
+func jsTime(v otto.Value) (time.Time, error) {
+	switch {
+	case v.IsNumber():
+		ms, _ := v.ToInteger()
+		return time.UnixMilli(ms), nil
+	case v.IsString():
+		return time.Parse(time.RFC3339, v.String())
+	case v.Class() == "Date":
+		ms, err := v.Object().Call("getTime")
+		if err != nil {
+			return time.Time{}, err
+		}
+		n, _ := ms.ToInteger()
+		return time.UnixMilli(n), nil
+	}
+	return time.Time{}, errors.New("runAt must be a Date, an ISO-8601 string, or epoch milliseconds")
+}
@@ -0,0 +1,420 @@
+package runbox
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Deployment slots. Each holds a saved version of the function.
+const (
+	SlotBlue  = "blue"
+	SlotGreen = "green"
+)
+
+const (
+	canaryEvaluationEvery      = 30 * time.Second
+	defaultCanaryMinExecutions = 20
+)
+
+// Deployment keeps two versions of a function live at once: every
+// execution runs the Live slot, and HTTP calls with X-Runbox-Slot may run
+// the other one to check it before switching. Saving the function changes
+// neither slot; without a deployment the latest version is served.
+//
+// With CanaryPercent set, that share of executions runs the other slot,
+// the canary. When its error rate since CanaryStartedAt goes over
+// RollbackErrorRate after at least RollbackMinExecutions, the leader sets
+// CanaryPercent back to 0 and says why in RollbackReason.
+type Deployment struct {
+	FunctionID            int        `json:"functionId"`
+	Blue                  int        `json:"blue"`
+	Green                 int        `json:"green"`
+	Live                  string     `json:"live"`
+	CanaryPercent         int        `json:"canaryPercent"`
+	RollbackErrorRate     float64    `json:"rollbackErrorRate,omitempty"`
+	RollbackMinExecutions int        `json:"rollbackMinExecutions,omitempty"`
+	CanaryStartedAt       *time.Time `json:"canaryStartedAt,omitempty"`
+	RolledBackAt          *time.Time `json:"rolledBackAt,omitempty"`
+	RollbackReason        string     `json:"rollbackReason,omitempty"`
+	SwitchedAt            *time.Time `json:"switchedAt,omitempty"`
+	UpdatedAt             time.Time  `json:"updatedAt"`
+}
+
+func (d *Deployment) version(slot string) int {
+	if slot == SlotGreen {
+		return d.Green
+	}
+	return d.Blue
+}
+
+func otherSlot(slot string) string {
+	if slot == SlotGreen {
+		return SlotBlue
+	}
+	return SlotGreen
+}
+
+func (app *App) initDeploymentsTable() error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS deployments (
+		function_id INTEGER PRIMARY KEY REFERENCES functions(id) ON DELETE CASCADE,
+		blue_version INTEGER NOT NULL,
+		green_version INTEGER NOT NULL,
+		live TEXT NOT NULL,
+		switched_at DATETIME,
+		updated_at DATETIME NOT NULL
+	);`
+
+	if _, err := app.db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create deployments table: %v", err)
+	}
+	return app.addColumns("deployments", [][2]string{
+		{"canary_percent", "INTEGER NOT NULL DEFAULT 0"},
+		{"rollback_error_rate", "REAL NOT NULL DEFAULT 0"},
+		{"rollback_min_executions", "INTEGER NOT NULL DEFAULT 0"},
+		{"canary_started_at", "DATETIME"},
+		{"rolled_back_at", "DATETIME"},
+		{"rollback_reason", "TEXT NOT NULL DEFAULT ''"},
+	})
+}
+
+const deploymentColumns = `function_id, blue_version, green_version, live, canary_percent, rollback_error_rate,
+	rollback_min_executions, canary_started_at, rolled_back_at, rollback_reason, switched_at, updated_at`
+
+func scanDeployment(row rowScanner) (*Deployment, error) {
+	var d Deployment
+	err := row.Scan(&d.FunctionID, &d.Blue, &d.Green, &d.Live, &d.CanaryPercent, &d.RollbackErrorRate,
+		&d.RollbackMinExecutions, &d.CanaryStartedAt, &d.RolledBackAt, &d.RollbackReason, &d.SwitchedAt, &d.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (app *App) getDeployment(functionID int) (*Deployment, error) {
+	return scanDeployment(app.db.QueryRow(`SELECT `+deploymentColumns+` FROM deployments WHERE function_id = ?`, functionID))
+}
+
+// deployedFunction is function as its deployment serves it: the code of
+// the live slot, the canary for its share of executions, or of slot when
+// one is asked for. Name, path and settings stay those of the function.
+// It answers the slot it ran, or "" without a deployment.
+func (app *App) deployedFunction(function *Function, slot string) (*Function, string) {
+	d, err := app.getDeployment(function.ID)
+	if err != nil {
+		return function, ""
+	}
+	if slot != SlotBlue && slot != SlotGreen {
+		slot = d.Live
+		if d.CanaryPercent > 0 && rand.IntN(100) < d.CanaryPercent {
+			slot = otherSlot(d.Live)
+		}
+	}
+	v, err := app.getFunctionVersion(function.ID, d.version(slot))
+	if err != nil {
+		return function, ""
+	}
+
+	deployed := *function
+	deployed.Version, deployed.Code, deployed.Files = v.Version, v.Code, v.Files
+	if v.Mode != "" {
+		deployed.Mode = v.Mode
+	}
+	return &deployed, slot
+}
+
+func (app *App) getDeploymentHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+
+	d, err := app.getDeployment(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "This function has no blue/green deployment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, d)
+}
+
+// setDeployment serves PUT /api/functions/:id/deployment. Slots left out
+// keep their version, or start at the latest one; live defaults to blue.
+// Staging a new version is putting it in the idle slot. The canary
+// settings are replaced as a whole, and its error rate counts from when
+// the canary or its version changed.
+func (app *App) setDeployment(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+	function, err := app.getFunctionByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Function not found"})
+		return
+	}
+
+	var in struct {
+		Blue                  int     `json:"blue"`
+		Green                 int     `json:"green"`
+		Live                  string  `json:"live"`
+		CanaryPercent         int     `json:"canaryPercent"`
+		RollbackErrorRate     float64 `json:"rollbackErrorRate"`
+		RollbackMinExecutions int     `json:"rollbackMinExecutions"`
+	}
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid deployment body"})
+		return
+	}
+
+	d, err := app.getDeployment(id)
+	if err != nil {
+		d = &Deployment{FunctionID: id, Blue: function.Version, Green: function.Version, Live: SlotBlue}
+	}
+	before := *d
+	if in.Blue != 0 {
+		d.Blue = in.Blue
+	}
+	if in.Green != 0 {
+		d.Green = in.Green
+	}
+	if in.Live != "" {
+		d.Live = in.Live
+	}
+	if d.Live != SlotBlue && d.Live != SlotGreen {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "live must be blue or green"})
+		return
+	}
+	if in.CanaryPercent < 0 || in.CanaryPercent > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "canaryPercent must be between 0 and 100"})
+		return
+	}
+	if in.RollbackErrorRate < 0 || in.RollbackErrorRate > 1 || in.RollbackMinExecutions < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "rollbackErrorRate must be between 0 and 1 and rollbackMinExecutions positive"})
+		return
+	}
+	for _, version := range []int{d.Blue, d.Green} {
+		if _, err := app.getFunctionVersion(id, version); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Version " + strconv.Itoa(version) + " not found"})
+			return
+		}
+	}
+
+	now := time.Now().UTC()
+	d.CanaryPercent, d.RollbackErrorRate, d.RollbackMinExecutions = in.CanaryPercent, in.RollbackErrorRate, in.RollbackMinExecutions
+	canary := d.version(otherSlot(d.Live))
+	switch {
+	case d.CanaryPercent == 0:
+		d.CanaryStartedAt = nil
+	case before.CanaryPercent == 0 || canary != before.version(otherSlot(before.Live)):
+		d.CanaryStartedAt, d.RolledBackAt, d.RollbackReason = &now, nil, ""
+	}
+	_, err = app.db.Exec(`INSERT INTO deployments (function_id, blue_version, green_version, live, canary_percent, rollback_error_rate,
+			rollback_min_executions, canary_started_at, rolled_back_at, rollback_reason, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (function_id) DO UPDATE SET blue_version = excluded.blue_version, green_version = excluded.green_version,
+			live = excluded.live, canary_percent = excluded.canary_percent, rollback_error_rate = excluded.rollback_error_rate,
+			rollback_min_executions = excluded.rollback_min_executions, canary_started_at = excluded.canary_started_at,
+			rolled_back_at = excluded.rolled_back_at, rollback_reason = excluded.rollback_reason, updated_at = excluded.updated_at`,
+		id, d.Blue, d.Green, d.Live, d.CanaryPercent, d.RollbackErrorRate, d.RollbackMinExecutions,
+		d.CanaryStartedAt, d.RolledBackAt, d.RollbackReason, now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save deployment: " + err.Error()})
+		return
+	}
+
+	d, _ = app.getDeployment(id)
+	c.JSON(http.StatusOK, d)
+}
+
+// switchDeployment serves POST /api/functions/:id/deployment/switch. It
+// flips traffic to the other slot, or to "to" when given, in one update,
+// so every execution after it runs the new slot; switching again rolls
+// back. Switching to the canary promotes it, ending the canary.
+func (app *App) switchDeployment(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+
+	var in struct {
+		To string `json:"to"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid switch body"})
+			return
+		}
+	}
+	if in.To != "" && in.To != SlotBlue && in.To != SlotGreen {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to must be blue or green"})
+		return
+	}
+
+	now := time.Now().UTC()
+	result, err := app.db.Exec(`UPDATE deployments SET live = CASE WHEN ? != '' THEN ? WHEN live = ? THEN ? ELSE ? END,
+		canary_percent = 0, canary_started_at = NULL, switched_at = ?, updated_at = ? WHERE function_id = ?`,
+		in.To, in.To, SlotBlue, SlotGreen, SlotBlue, now, now, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to switch deployment: " + err.Error()})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "This function has no blue/green deployment"})
+		return
+	}
+
+	d, _ := app.getDeployment(id)
+	log.Printf("Function %d switched to %s (version %d)", id, d.Live, d.version(d.Live))
+	c.JSON(http.StatusOK, d)
+}
+
+// deleteDeployment goes back to serving the latest version.
+func (app *App) deleteDeployment(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+
+	if _, err := app.db.Exec(`DELETE FROM deployments WHERE function_id = ?`, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove deployment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Deployment removed; the latest version is served"})
+}
+
+// versionMetrics counts the executions of one version of a function since
+// since from its execution logs, the only place versions are recorded.
+// Failed executions and 5xx answers count as errors.
+func (app *App) versionMetrics(functionID, version int, since time.Time) (*functionStats, error) {
+	rows, err := app.db.Query(`SELECT duration_ms, status, http_status, slow FROM execution_logs
+		WHERE function_id = ? AND version = ? AND created_at >= ?`, functionID, version, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := newFunctionStats()
+	for rows.Next() {
+		var ms int64
+		var status string
+		var httpStatus sql.NullInt64
+		var slow bool
+		if err := rows.Scan(&ms, &status, &httpStatus, &slow); err != nil {
+			return nil, err
+		}
+		stats.observe(ms, status == JobFailed || httpStatus.Int64 >= 500, slow)
+	}
+	return stats, rows.Err()
+}
+
+// deploymentMetricsHandler serves GET /api/functions/:id/deployment/metrics,
+// the metrics of the live and the other slot over ?window=.
+func (app *App) deploymentMetricsHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+	window, err := parseWindow(c.Query("window"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	d, err := app.getDeployment(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "This function has no blue/green deployment"})
+		return
+	}
+
+	since := time.Now().UTC().Add(-window)
+	slots := gin.H{}
+	for _, slot := range []string{SlotBlue, SlotGreen} {
+		stats, err := app.versionMetrics(id, d.version(slot), since)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load metrics"})
+			return
+		}
+		summary := stats.summary()
+		summary["version"] = d.version(slot)
+		summary["live"] = slot == d.Live
+		slots[slot] = summary
+	}
+	c.JSON(http.StatusOK, gin.H{"functionId": id, "window": window.String(), "slots": slots})
+}
+
+// evaluateCanaries runs on the leader and rolls back every canary whose
+// error rate went over its threshold.
+func (app *App) evaluateCanaries(now time.Time) {
+	if !app.periodic.due("canaries", now, canaryEvaluationEvery) {
+		return
+	}
+
+	rows, err := app.db.Query(`SELECT ` + deploymentColumns + ` FROM deployments WHERE canary_percent > 0 AND rollback_error_rate > 0`)
+	if err != nil {
+		log.Println("Failed to load canaries:", err)
+		return
+	}
+	var canaries []Deployment
+	for rows.Next() {
+		if d, err := scanDeployment(rows); err == nil {
+			canaries = append(canaries, *d)
+		}
+	}
+	rows.Close()
+
+	for i := range canaries {
+		d := &canaries[i]
+		if d.CanaryStartedAt == nil {
+			continue
+		}
+		version := d.version(otherSlot(d.Live))
+		stats, err := app.versionMetrics(d.FunctionID, version, *d.CanaryStartedAt)
+		if err != nil {
+			continue
+		}
+		minExecutions := int64(d.RollbackMinExecutions)
+		if minExecutions == 0 {
+			minExecutions = defaultCanaryMinExecutions
+		}
+		if stats.Invocations < minExecutions {
+			continue
+		}
+		rate := float64(stats.Errors) / float64(stats.Invocations)
+		if rate <= d.RollbackErrorRate {
+			continue
+		}
+
+		reason := fmt.Sprintf("version %d failed %d of %d executions (%.1f%%, over %.1f%%)",
+			version, stats.Errors, stats.Invocations, rate*100, d.RollbackErrorRate*100)
+		result, err := app.db.Exec(`UPDATE deployments SET canary_percent = 0, rolled_back_at = ?, rollback_reason = ?, updated_at = ?
+			WHERE function_id = ? AND canary_percent > 0`, now, reason, now, d.FunctionID)
+		if err != nil {
+			continue
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			continue
+		}
+		log.Printf("Canary of function %d rolled back: %s", d.FunctionID, reason)
+		if function, err := app.getFunctionByID(d.FunctionID); err == nil {
+			app.fireWebhook(WebhookCanaryRolledBack, map[string]interface{}{
+				"function": functionSummary(function),
+				"version":  version,
+				"reason":   reason,
+				"errors":   stats.Errors,
+				"total":    stats.Invocations,
+			})
+		}
+	}
+}
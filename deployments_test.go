@@ -0,0 +1,73 @@
+package runbox
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeployedFunction(t *testing.T) {
+	app := newTestServer(t, nil).app
+	function := newTestFunction(t, app, "/deployed", "// blue")
+	function.Code = "// green"
+	if err := app.saveFunction(function); err != nil {
+		t.Fatal(err)
+	}
+	undeployed := newTestFunction(t, app, "/undeployed", "// latest")
+
+	deploy := func(live string, canaryPercent int) {
+		t.Helper()
+		_, err := app.db.Exec(`INSERT OR REPLACE INTO deployments (function_id, blue_version, green_version, live, canary_percent, updated_at)
+			VALUES (?, 1, 2, ?, ?, ?)`, function.ID, live, canaryPercent, time.Now().UTC())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name          string
+		live          string
+		canaryPercent int
+		slot          string
+		wantGreen     int // executions out of 1000 that run green
+	}{
+		{"live blue", SlotBlue, 0, "", 0},
+		{"live green", SlotGreen, 0, "", 1000},
+		{"whole canary", SlotBlue, 100, "", 1000},
+		{"half canary", SlotBlue, 50, "", 500},
+		{"small canary", SlotGreen, 10, "", 900},
+		{"slot asked for", SlotBlue, 50, SlotBlue, 0},
+		{"other slot asked for", SlotBlue, 0, SlotGreen, 1000},
+		{"unknown slot", SlotGreen, 0, "purple", 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deploy(tt.live, tt.canaryPercent)
+			green := 0
+			for range 1000 {
+				deployed, slot := app.deployedFunction(function, tt.slot)
+				want := map[string]string{SlotBlue: "// blue", SlotGreen: "// green"}[slot]
+				if deployed.Code != want || deployed.Path != function.Path {
+					t.Fatalf("slot %s served %q at %s", slot, deployed.Code, deployed.Path)
+				}
+				if slot == SlotGreen {
+					green++
+				}
+			}
+			// A canary split is random; 100 is over six standard deviations.
+			tolerance := 0
+			if tt.slot == "" && tt.canaryPercent > 0 && tt.canaryPercent < 100 {
+				tolerance = 100
+			}
+			if green < tt.wantGreen-tolerance || green > tt.wantGreen+tolerance {
+				t.Errorf("%d of 1000 executions ran green, want about %d", green, tt.wantGreen)
+			}
+		})
+	}
+
+	t.Run("no deployment", func(t *testing.T) {
+		deployed, slot := app.deployedFunction(undeployed, SlotGreen)
+		if deployed != undeployed || slot != "" {
+			t.Errorf("deployedFunction = %q in slot %q, want the function itself and no slot", deployed.Code, slot)
+		}
+	})
+}
@@ -0,0 +1,286 @@
+package runbox
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// devDatabase is an in-memory SQLite database shared by every
+	// connection of the process; it is gone when the dev server exits.
+	devDatabase = "file:/runbox-dev?vfs=memdb"
+	devDebounce = 150 * time.Millisecond
+	// devWildcardName is the file name that serves everything under its
+	// directory, as a /* function.
+	devWildcardName = "[...]"
+)
+
+var (
+	lambdaExportPattern  = regexp.MustCompile(`\bexports\.handler\s*=`)
+	workersExportPattern = regexp.MustCompile(`(?m)^\s*export\s+default\b`)
+	typeDefsPattern      = regexp.MustCompile(`(?m)^\s*(?:var|let|const)\s+typeDefs\s*=`)
+)
+
+// devLoader keeps the functions of a directory loaded into the dev
+// server's database.
+type devLoader struct {
+	app *App
+	dir string
+
+	mu     sync.Mutex
+	loaded map[string]bool   // paths that came from dir
+	failed map[string]string // the last load error of each path
+}
+
+// startDev loads dir and reloads it whenever a file under it changes.
+func (app *App) startDev(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return errors.New(dir + " is not a directory")
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watchDir(watcher, dir, true); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	d := &devLoader{app: app, dir: dir, loaded: map[string]bool{}, failed: map[string]string{}}
+	d.sync()
+	app.goBackground(func() { d.watch(watcher) })
+	app.goBackground(app.logDevExecutions)
+	return nil
+}
+
+// watch reloads dir as it changes, until the server is closed.
+func (d *devLoader) watch(watcher *fsnotify.Watcher) {
+	defer watcher.Close()
+	debounce := time.NewTimer(devDebounce)
+	debounce.Stop()
+	for {
+		select {
+		case <-d.app.ctx.Done():
+			debounce.Stop()
+			return
+
+		case <-debounce.C:
+			d.sync()
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					watchDir(watcher, event.Name, true)
+				}
+			}
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			// Editors save in bursts of events, so the directory is read
+			// again once it has been quiet for a moment.
+			debounce.Reset(devDebounce)
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("dev: watching %s failed: %v", d.dir, err)
+		}
+	}
+}
+
+// sync creates, updates and removes functions to match dir. A function
+// that fails to load keeps serving its last good version.
+func (d *devLoader) sync() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	functions, err := readDevDir(d.dir)
+	if err != nil {
+		log.Printf("dev: reading %s failed: %v", d.dir, err)
+		return
+	}
+	origin := auditOrigin{actor: "runbox dev"}
+	seen := map[string]bool{}
+	for _, f := range functions {
+		if f.Mode == "" {
+			f.Mode = ModeStandard
+		}
+		if !strings.HasPrefix(f.Path, "/") {
+			f.Path = "/" + f.Path
+		}
+		seen[f.Path] = true
+
+		current, err := d.app.getFunctionByPath(f.Path)
+		action := importCreate
+		if err == nil {
+			if f.matches(current) {
+				delete(d.failed, f.Path)
+				d.loaded[f.Path] = true
+				continue
+			}
+			action = importOverwrite
+		}
+		if _, err := d.app.importBundleFunction(origin, f, action); err != nil {
+			if d.failed[f.Path] != err.Error() {
+				d.failed[f.Path] = err.Error()
+				log.Printf("dev: %s not loaded: %v", f.Path, err)
+			}
+			continue
+		}
+		delete(d.failed, f.Path)
+		d.loaded[f.Path] = true
+		if action == importCreate {
+			log.Printf("dev: loaded %s", f.Path)
+		} else {
+			log.Printf("dev: reloaded %s", f.Path)
+		}
+	}
+
+	for p := range d.failed {
+		if !seen[p] {
+			delete(d.failed, p)
+		}
+	}
+	for p := range d.loaded {
+		if seen[p] {
+			continue
+		}
+		delete(d.loaded, p)
+		if function, err := d.app.getFunctionByPath(p); err == nil {
+			if err := d.app.removeFunction(function.ID); err != nil {
+				log.Printf("dev: removing %s failed: %v", p, err)
+				continue
+			}
+			log.Printf("dev: removed %s", p)
+		}
+	}
+}
+
+// readDevDir reads dir as a pulled bundle when it has a runbox.json, and
+// otherwise as one function per .js file.
+func readDevDir(dir string) ([]bundleFunction, error) {
+	manifest, err := os.ReadFile(filepath.Join(dir, bundleManifest))
+	if err == nil {
+		b, err := parseBundle(manifest)
+		if err != nil {
+			return nil, err
+		}
+		err = b.loadFiles(dir, func(name string) ([]byte, bool) {
+			if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+				return nil, false
+			}
+			content, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+			return content, err == nil
+		})
+		return b.Functions, err
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	var functions []bundleFunction
+	err = filepath.WalkDir(dir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if file != dir && (strings.HasPrefix(entry.Name(), ".") || entry.Name() == "node_modules") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(file) != ".js" {
+			return nil
+		}
+		code, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		functions = append(functions, devFunction(filepath.ToSlash(rel), string(code)))
+		return nil
+	})
+	return functions, err
+}
+
+// devFunction is the function a .js file at rel serves: users.js serves
+// /users, users/index.js /users too and users/[...].js /users/*. The mode
+// follows from how the code exports its handler.
+func devFunction(rel, code string) bundleFunction {
+	name := strings.TrimSuffix(rel, ".js")
+	prefix := ""
+	if dir := path.Dir(name); dir != "." {
+		prefix = "/" + dir
+	}
+	fnPath := prefix + "/" + path.Base(name)
+	switch path.Base(name) {
+	case "index":
+		fnPath = prefix
+		if fnPath == "" {
+			fnPath = "/"
+		}
+	case devWildcardName:
+		fnPath = prefix + "/*"
+	}
+
+	mode := ModeStandard
+	switch {
+	case lambdaExportPattern.MatchString(code):
+		mode = ModeLambda
+	case workersExportPattern.MatchString(code):
+		mode = ModeWorkers
+	case typeDefsPattern.MatchString(code):
+		mode = ModeGraphQL
+	}
+	return bundleFunction{Name: name, Path: fnPath, Mode: mode, Code: code}
+}
+
+// logDevExecutions prints every finished execution, with its error, so
+// the terminal running runbox dev shows what each call did.
+func (app *App) logDevExecutions() {
+	s := app.logStream.subscribe(nil)
+	defer app.logStream.unsubscribe(s)
+	for {
+		var e LogStreamEvent
+		select {
+		case e = <-s.events:
+		case <-app.ctx.Done():
+			return
+		}
+		if e.Type != "execution" || e.Execution == nil {
+			continue
+		}
+		x := e.Execution
+		line := fmt.Sprintf("dev: %s %s %s", x.Method, e.Path, x.Status)
+		if x.HTTPStatus != 0 {
+			line = fmt.Sprintf("dev: %s %s %d", x.Method, e.Path, x.HTTPStatus)
+		}
+		line += fmt.Sprintf(" in %dms", x.DurationMs)
+		if x.Error != "" {
+			line += ": " + x.Error
+		}
+		log.Println(line)
+	}
+}
@@ -0,0 +1,209 @@
+package runbox
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	diffContext = 3
+	// maxDiffCells bounds the line-matching table; past it the changed
+	// middle of the two texts is shown as removed and re-added whole.
+	maxDiffCells = 4_000_000
+)
+
+type diffEdit struct {
+	kind byte // ' ', '-' or '+'
+	text string
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// lineEdits turns a into b line by line, keeping the longest common
+// subsequence of lines.
+func lineEdits(a, b []string) []diffEdit {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	edits := make([]diffEdit, 0, len(a)+len(b))
+	for _, line := range a[:prefix] {
+		edits = append(edits, diffEdit{' ', line})
+	}
+
+	am, bm := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(am)*len(bm) > maxDiffCells {
+		for _, line := range am {
+			edits = append(edits, diffEdit{'-', line})
+		}
+		for _, line := range bm {
+			edits = append(edits, diffEdit{'+', line})
+		}
+	} else {
+		// lcs[i][j] is the common subsequence length of am[i:] and bm[j:].
+		lcs := make([][]int, len(am)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(bm)+1)
+		}
+		for i := len(am) - 1; i >= 0; i-- {
+			for j := len(bm) - 1; j >= 0; j-- {
+				if am[i] == bm[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else {
+					lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < len(am) || j < len(bm) {
+			switch {
+			case i < len(am) && j < len(bm) && am[i] == bm[j]:
+				edits = append(edits, diffEdit{' ', am[i]})
+				i++
+				j++
+			case i < len(am) && (j == len(bm) || lcs[i+1][j] >= lcs[i][j+1]):
+				edits = append(edits, diffEdit{'-', am[i]})
+				i++
+			default:
+				edits = append(edits, diffEdit{'+', bm[j]})
+				j++
+			}
+		}
+	}
+
+	for _, line := range a[len(a)-suffix:] {
+		edits = append(edits, diffEdit{' ', line})
+	}
+	return edits
+}
+
+// unifiedDiff renders the changes from a to b in unified diff format with
+// three lines of context, or returns "" when they are equal.
+func unifiedDiff(fromName, toName, a, b string) string {
+	if a == b {
+		return ""
+	}
+	edits := lineEdits(splitLines(a), splitLines(b))
+
+	// Line numbers in a and b before each edit.
+	aLine := make([]int, len(edits)+1)
+	bLine := make([]int, len(edits)+1)
+	for i, e := range edits {
+		aLine[i+1], bLine[i+1] = aLine[i], bLine[i]
+		if e.kind != '+' {
+			aLine[i+1]++
+		}
+		if e.kind != '-' {
+			bLine[i+1]++
+		}
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+
+	for i := 0; i < len(edits); {
+		if edits[i].kind == ' ' {
+			i++
+			continue
+		}
+		start := max(0, i-diffContext)
+		end := i
+		// Extend the hunk while the next change is close enough to share
+		// context with this one.
+		for end < len(edits) {
+			if edits[end].kind != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(edits) && edits[next].kind == ' ' {
+				next++
+			}
+			if next == len(edits) || next-end > 2*diffContext {
+				break
+			}
+			end = next
+		}
+		end = min(len(edits), end+diffContext)
+
+		aStart, aCount := aLine[start]+1, aLine[end]-aLine[start]
+		bStart, bCount := bLine[start]+1, bLine[end]-bLine[start]
+		if aCount == 0 {
+			aStart--
+		}
+		if bCount == 0 {
+			bStart--
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", aStart, aCount, bStart, bCount)
+		for _, e := range edits[start:end] {
+			out.WriteByte(e.kind)
+			out.WriteString(e.text)
+			out.WriteByte('\n')
+		}
+		i = end
+	}
+
+	return out.String()
+}
+
+// diffRow is one line of a side-by-side diff. Line numbers are 1-based and
+// zero on the side a line is missing from.
+type diffRow struct {
+	Kind      string `json:"kind"` // same, changed, removed or added
+	LeftLine  int    `json:"leftLine,omitempty"`
+	Left      string `json:"left"`
+	RightLine int    `json:"rightLine,omitempty"`
+	Right     string `json:"right"`
+}
+
+// sideBySide lines a and b up for showing in two columns, pairing each run
+// of removed lines with the added lines that replace it.
+func sideBySide(a, b string) []diffRow {
+	edits := lineEdits(splitLines(a), splitLines(b))
+	rows := make([]diffRow, 0, len(edits))
+	left, right := 0, 0
+	for i := 0; i < len(edits); {
+		if edits[i].kind == ' ' {
+			left++
+			right++
+			rows = append(rows, diffRow{Kind: "same", LeftLine: left, Left: edits[i].text, RightLine: right, Right: edits[i].text})
+			i++
+			continue
+		}
+		var removed, added []string
+		for ; i < len(edits) && edits[i].kind != ' '; i++ {
+			if edits[i].kind == '-' {
+				removed = append(removed, edits[i].text)
+			} else {
+				added = append(added, edits[i].text)
+			}
+		}
+		for j := 0; j < max(len(removed), len(added)); j++ {
+			row := diffRow{Kind: "changed"}
+			if j < len(removed) {
+				left++
+				row.LeftLine, row.Left = left, removed[j]
+			} else {
+				row.Kind = "added"
+			}
+			if j < len(added) {
+				right++
+				row.RightLine, row.Right = right, added[j]
+			} else {
+				row.Kind = "removed"
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
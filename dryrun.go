@@ -0,0 +1,178 @@
+package runbox
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// dryRunTimeout stops test runs of code that never returns; real
+// executions are only bounded by their client.
+const dryRunTimeout = 30 * time.Second
+
+type dryRunInput struct {
+	FunctionID int               `json:"functionId"`
+	Code       string            `json:"code"`
+	Files      []FunctionFile    `json:"files"`
+	Mode       string            `json:"mode"`
+	Path       string            `json:"path"`
+	Subpath    string            `json:"subpath"`
+	Method     string            `json:"method"`
+	Headers    map[string]string `json:"headers"`
+	Query      map[string]string `json:"query"`
+	Body       string            `json:"body"`
+}
+
+// request builds the request object the way buildRequestData does for a
+// real call to function.
+func (in *dryRunInput) request(function *Function) map[string]interface{} {
+	subpath := "/" + strings.TrimPrefix(in.Subpath, "/")
+	path := function.Path
+	if strings.HasSuffix(path, "/*") {
+		path = strings.TrimSuffix(path, "/*") + subpath
+	}
+
+	query := map[string]interface{}{}
+	values := url.Values{}
+	for key, value := range in.Query {
+		query[key] = value
+		values.Set(key, value)
+	}
+	requestURL := "/api/execute" + path
+	if len(values) > 0 {
+		requestURL += "?" + values.Encode()
+	}
+
+	var body interface{} = map[string]interface{}{}
+	if in.Body != "" {
+		var parsed map[string]interface{}
+		if err := json.Unmarshal([]byte(in.Body), &parsed); err == nil {
+			body = parsed
+		} else {
+			body = map[string]interface{}{"raw": in.Body}
+		}
+	}
+
+	headers := map[string]string{}
+	for key, value := range in.Headers {
+		headers[http.CanonicalHeaderKey(key)] = value
+	}
+
+	return map[string]interface{}{
+		"id":      newID(),
+		"method":  in.Method,
+		"path":    path,
+		"url":     requestURL,
+		"query":   query,
+		"body":    body,
+		"headers": headers,
+		"rawBody": in.Body,
+		"subpath": subpath,
+	}
+}
+
+// dryRunHandler serves POST /api/functions/dry-run: it runs code from the
+// editor against a made-up request and returns the response it would have
+// sent, with its console output and timings. Nothing is saved, and the run
+// is left out of logs, metrics, and usage; host APIs such as email and
+// events still act for real.
+func (app *App) dryRunHandler(c *gin.Context) {
+	var in dryRunInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid dry run body"})
+		return
+	}
+	if in.Mode == "" {
+		in.Mode = ModeStandard
+	}
+	if !validMode(in.Mode) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid mode " + in.Mode})
+		return
+	}
+	in.Method = strings.ToUpper(in.Method)
+	if in.Method == "" {
+		in.Method = http.MethodGet
+	}
+	if diagnostics := validateFunctionCode(in.Code, in.Mode); len(diagnostics) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "syntax error at " + diagnostics[0].String(), "errors": diagnostics})
+		return
+	}
+	if err := validateFunctionFiles(in.Files); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Testing a saved function keeps its ID and path, so host APIs see the
+	// same function the code will run as once saved.
+	function := &Function{Name: "dry-run", Path: in.Path}
+	if in.FunctionID != 0 {
+		saved, err := app.getFunctionByID(in.FunctionID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Function not found"})
+			return
+		}
+		function = saved
+		if in.Path != "" {
+			function.Path = in.Path
+		}
+	}
+	function.Code, function.Mode = in.Code, in.Mode
+	// Leaving files out tests the code against the saved modules.
+	if in.Files != nil {
+		function.Files = in.Files
+	}
+	if function.Path == "" {
+		function.Path = "/"
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), dryRunTimeout)
+	defer cancel()
+	exec := newExecution(ctx, function, in.request(function))
+	exec.source = "dry-run"
+	exec.dryRun = true
+	result, err := app.executeJavaScript(exec)
+
+	serializeStarted := time.Now()
+	var resp *HTTPResponse
+	errMessage := ""
+	switch {
+	case err == errExecutionCancelled && ctx.Err() == context.DeadlineExceeded:
+		errMessage = "execution timed out after " + dryRunTimeout.String()
+	case err != nil:
+		errMessage = err.Error()
+	default:
+		if resp, err = responseFromResult(result); err != nil {
+			errMessage = "Failed to serialize result: " + err.Error()
+		}
+	}
+	if resp == nil {
+		body, _ := json.Marshal(gin.H{"error": "Function execution failed", "details": errMessage, "function": function.Name})
+		resp = &HTTPResponse{Status: http.StatusInternalServerError, Headers: map[string]string{}, Body: string(body)}
+	}
+	exec.timings.SerializeMs = millis(time.Since(serializeStarted))
+	exec.timings.TotalMs += exec.timings.SerializeMs
+
+	headers := map[string]string{"Content-Type": resp.ContentType()}
+	for name, value := range resp.Headers {
+		headers[http.CanonicalHeaderKey(name)] = value
+	}
+	logs := exec.logs
+	if logs == nil {
+		logs = []string{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":     resp.Status,
+		"headers":    headers,
+		"body":       resp.Body,
+		"logs":       logs,
+		"error":      errMessage,
+		"durationMs": exec.timings.TotalMs,
+		"timings":    exec.timings,
+		"phases":     exec.timings.bars(),
+	})
+}
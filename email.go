@@ -0,0 +1,338 @@
+package runbox
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robertkrimen/otto"
+)
+
+const defaultEmailQuota = 100
+
+type emailMessage struct {
+	From    string
+	To      []string
+	Cc      []string
+	Bcc     []string
+	ReplyTo string
+	Subject string
+	HTML    string
+	Text    string
+}
+
+func (m *emailMessage) recipients() []string {
+	all := append(append(append([]string{}, m.To...), m.Cc...), m.Bcc...)
+	addresses := make([]string, 0, len(all))
+	for _, r := range all {
+		if a, err := mail.ParseAddress(r); err == nil {
+			addresses = append(addresses, a.Address)
+		}
+	}
+	return addresses
+}
+
+// build renders the message as MIME, with a multipart/alternative body when
+// both a text and an HTML version are given. Bcc is never written out.
+func (m *emailMessage) build(id string) []byte {
+	var buf bytes.Buffer
+	header := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(&buf, "%s: %s\r\n", name, value)
+		}
+	}
+
+	header("From", m.From)
+	header("To", strings.Join(m.To, ", "))
+	header("Cc", strings.Join(m.Cc, ", "))
+	header("Reply-To", m.ReplyTo)
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", "<"+id+"@runbox>")
+	header("MIME-Version", "1.0")
+
+	part := func(contentType, body string) {
+		fmt.Fprintf(&buf, "Content-Type: %s; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n", contentType)
+		w := quotedprintable.NewWriter(&buf)
+		w.Write([]byte(body))
+		w.Close()
+		buf.WriteString("\r\n")
+	}
+
+	switch {
+	case m.HTML != "" && m.Text != "":
+		boundary := "runbox-" + id
+		fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", boundary)
+		fmt.Fprintf(&buf, "--%s\r\n", boundary)
+		part("text/plain", m.Text)
+		fmt.Fprintf(&buf, "--%s\r\n", boundary)
+		part("text/html", m.HTML)
+		fmt.Fprintf(&buf, "--%s--\r\n", boundary)
+	case m.HTML != "":
+		part("text/html", m.HTML)
+	default:
+		part("text/plain", m.Text)
+	}
+
+	return buf.Bytes()
+}
+
+func (app *App) initEmailTable() error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS email_sends (
+		id TEXT PRIMARY KEY,
+		function_id INTEGER NOT NULL,
+		recipients INTEGER NOT NULL,
+		sent_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_email_sends_function ON email_sends (function_id, sent_at);`
+
+	if _, err := app.db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create email_sends table: %v", err)
+	}
+	return nil
+}
+
+// checkEmailQuota reports an error once the function has sent its hourly
+// quota of messages.
+func (app *App) checkEmailQuota(functionID int) error {
+	quota := app.config.Email.QuotaPerHour
+	if quota == 0 {
+		quota = defaultEmailQuota
+	}
+	if quota < 0 {
+		return nil
+	}
+
+	var sent int
+	err := app.db.QueryRow(`SELECT COUNT(*) FROM email_sends WHERE function_id = ? AND sent_at > ?`,
+		functionID, time.Now().UTC().Add(-time.Hour)).Scan(&sent)
+	if err != nil {
+		return err
+	}
+	if sent >= quota {
+		return fmt.Errorf("hourly quota of %d emails reached", quota)
+	}
+	return nil
+}
+
+func (app *App) sendEmail(function *Function, m *emailMessage) (string, error) {
+	config := app.config.Email
+	if config.Provider == "" {
+		return "", errors.New("email is not configured")
+	}
+	if m.From == "" {
+		m.From = config.From
+	}
+	if m.From == "" {
+		return "", errors.New("no sender: set email.from or pass from")
+	}
+	if len(m.To) == 0 {
+		return "", errors.New("to is required")
+	}
+	for _, r := range append(append(append([]string{m.From}, m.To...), m.Cc...), m.Bcc...) {
+		if _, err := mail.ParseAddress(r); err != nil {
+			return "", fmt.Errorf("invalid address %q", r)
+		}
+	}
+	if m.HTML == "" && m.Text == "" {
+		return "", errors.New("html or text is required")
+	}
+
+	if err := app.checkEmailQuota(function.ID); err != nil {
+		return "", err
+	}
+
+	id := newID()
+	if err := app.deliverEmail(m, id, function.Path); err != nil {
+		return "", err
+	}
+
+	app.db.Exec(`INSERT INTO email_sends (id, function_id, recipients, sent_at) VALUES (?, ?, ?, ?)`,
+		id, function.ID, len(m.recipients()), time.Now().UTC())
+	return id, nil
+}
+
+// deliverEmail hands a validated message to the configured provider; sender
+// only labels it in the log provider's output.
+func (app *App) deliverEmail(m *emailMessage, id, sender string) error {
+	switch app.config.Email.Provider {
+	case "smtp":
+		return app.sendSMTP(m, id)
+	case "log":
+		log.Printf("Email %s from %s to %s: %s", id, sender, strings.Join(m.recipients(), ", "), m.Subject)
+		return nil
+	default:
+		return fmt.Errorf("unknown email provider %q", app.config.Email.Provider)
+	}
+}
+
+// sendSMTP delivers through the configured server. Port 465 uses implicit
+// TLS; other ports upgrade with STARTTLS when the server offers it.
+func (app *App) sendSMTP(m *emailMessage, id string) error {
+	config := app.config.Email.SMTP
+	if config.Host == "" {
+		return errors.New("email.smtp.host is not configured")
+	}
+	port := config.Port
+	if port == 0 {
+		port = 587
+	}
+	addr := net.JoinHostPort(config.Host, strconv.Itoa(port))
+	tlsConfig := &tls.Config{ServerName: config.Host}
+
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if port == 465 {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	client, err := smtp.NewClient(conn, config.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && port != 465 {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if config.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", config.Username, config.Password, config.Host)); err != nil {
+			return err
+		}
+	}
+
+	from, _ := mail.ParseAddress(m.From)
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, r := range m.recipients() {
+		if err := client.Rcpt(r); err != nil {
+			return err
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(m.build(id)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// pingSMTP connects to the configured server and waits for its greeting.
+func (app *App) pingSMTP(ctx context.Context) error {
+	config := app.config.Email.SMTP
+	if config.Host == "" {
+		return errors.New("email.smtp.host is not configured")
+	}
+	port := config.Port
+	if port == 0 {
+		port = 587
+	}
+	addr := net.JoinHostPort(config.Host, strconv.Itoa(port))
+
+	var conn net.Conn
+	var err error
+	if port == 465 {
+		conn, err = (&tls.Dialer{Config: &tls.Config{ServerName: config.Host}}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, config.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	return client.Quit()
+}
+
+// addressList accepts a single address or an array of them.
+func addressList(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		if v == "" {
+			return nil
+		}
+		return []string{v}
+	case []interface{}:
+		var list []string
+		for _, item := range v {
+			if s, ok := item.(string); ok && s != "" {
+				list = append(list, s)
+			}
+		}
+		return list
+	case []string:
+		return v
+	}
+	return nil
+}
+
+func (app *App) jsEmail(vm *otto.Otto, exec *execution) *otto.Object {
+	email, _ := vm.Object(`({})`)
+
+	email.Set("send", func(call otto.FunctionCall) otto.Value {
+		if !call.Argument(0).IsObject() {
+			throwError(call, "runbox.email.send: expected an options object")
+		}
+		exported, _ := call.Argument(0).Export()
+		opts, _ := exported.(map[string]interface{})
+		str := func(key string) string {
+			s, _ := opts[key].(string)
+			return s
+		}
+
+		m := &emailMessage{
+			From:    str("from"),
+			To:      addressList(opts["to"]),
+			Cc:      addressList(opts["cc"]),
+			Bcc:     addressList(opts["bcc"]),
+			ReplyTo: str("replyTo"),
+			Subject: str("subject"),
+			HTML:    str("html"),
+			Text:    str("text"),
+		}
+
+		id, err := app.sendEmail(exec.function, m)
+		if err != nil {
+			throwError(call, "runbox.email.send: "+err.Error())
+		}
+		return toValue(call, map[string]interface{}{"id": id})
+	})
+
+	return email
+}
@@ -0,0 +1,368 @@
+package runbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prodemmi/runbox/engine"
+	"github.com/robertkrimen/otto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func requestURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
+
+func buildRequestData(c *gin.Context) map[string]interface{} {
+	requestData := map[string]interface{}{
+		"id":      requestID(c),
+		"ip":      c.ClientIP(),
+		"method":  c.Request.Method,
+		"path":    c.Request.URL.Path,
+		"url":     requestURL(c.Request),
+		"query":   map[string]interface{}{},
+		"body":    map[string]interface{}{},
+		"headers": map[string]string{},
+		"rawBody": "",
+	}
+
+	for key, values := range c.Request.URL.Query() {
+		if len(values) > 0 {
+			requestData["query"].(map[string]interface{})[key] = values[0]
+		}
+	}
+
+	if c.Request.Body != nil {
+		bodyBytes, err := io.ReadAll(c.Request.Body)
+		requestData["rawBody"] = string(bodyBytes)
+		if err == nil && len(bodyBytes) > 0 {
+			var bodyData map[string]interface{}
+			if err := json.Unmarshal(bodyBytes, &bodyData); err == nil {
+				requestData["body"] = bodyData
+			} else {
+
+				requestData["body"] = map[string]interface{}{"raw": string(bodyBytes)}
+			}
+		}
+	}
+
+	if c.Request.Method == "POST" || c.Request.Method == "PUT" {
+		if err := c.Request.ParseForm(); err == nil {
+			for key, values := range c.Request.PostForm {
+				if len(values) > 0 {
+					if requestData["body"] == nil {
+						requestData["body"] = map[string]interface{}{}
+					}
+					if bodyMap, ok := requestData["body"].(map[string]interface{}); ok {
+						bodyMap[key] = values[0]
+					}
+				}
+			}
+		}
+	}
+
+	for key, values := range c.Request.Header {
+		if len(values) > 0 {
+			requestData["headers"].(map[string]string)[key] = values[0]
+		}
+	}
+
+	return requestData
+}
+
+var functionModes = engine.Modes
+
+func validMode(mode string) bool {
+	return engine.ValidMode(mode)
+}
+
+// HTTPResponse is a handler result that controls the status, headers, and
+// raw body of the HTTP response instead of being serialized as JSON.
+type HTTPResponse = engine.HTTPResponse
+
+const (
+	ModeStandard = engine.ModeStandard
+	ModeLambda   = engine.ModeLambda
+	ModeWorkers  = engine.ModeWorkers
+	ModeGraphQL  = engine.ModeGraphQL
+	ModeXML      = engine.ModeXML
+)
+
+// execution carries the state of a single handler invocation through the VM.
+type execution struct {
+	ctx      context.Context
+	function *Function
+	request  map[string]interface{}
+	logs     []string
+	source   string
+	job      *Job // set when running a queued job
+	id       string
+	stream   *logBroker
+
+	// requestID is request.id: the X-Request-ID of the request that led to
+	// this execution, passed on to fetch calls and events it publishes.
+	requestID string
+
+	timings ExecutionTimings
+	// holdLog keeps the log entry in heldLog until the caller has
+	// serialized the response and released it.
+	holdLog bool
+	heldLog *ExecutionLog
+	// dryRun runs unsaved code for the editor's test console: nothing is
+	// logged, streamed, counted, or reported.
+	dryRun bool
+	// env is the function's environment, as installed into process.env.
+	env map[string]string
+	// slot asks for a blue/green slot other than the live one; once
+	// running it is the slot that ran, or "" without a deployment.
+	slot string
+}
+
+var errExecutionCancelled = engine.ErrCancelled
+
+func newExecution(ctx context.Context, function *Function, requestData map[string]interface{}) *execution {
+	id, _ := requestData["id"].(string)
+	if id == "" {
+		id = newID()
+		requestData["id"] = id
+	}
+	return &execution{
+		ctx:       ctx,
+		function:  function,
+		request:   requestData,
+		source:    "http",
+		requestID: id,
+	}
+}
+
+func (exec *execution) consoleLog(call otto.FunctionCall) otto.Value {
+	parts := make([]string, 0, len(call.ArgumentList))
+	for _, arg := range call.ArgumentList {
+		parts = append(parts, arg.String())
+	}
+	line := strings.Join(parts, " ")
+
+	exec.logs = append(exec.logs, line)
+	log.Printf("JS Console [%s %s]: %s", exec.function.Path, exec.requestID, line)
+	exec.streamConsole(line)
+	return otto.UndefinedValue()
+}
+
+func (app *App) executeJavaScript(exec *execution) (result interface{}, err error) {
+	started := time.Now()
+	exec.id = newID()
+	if !exec.dryRun {
+		exec.function, exec.slot = app.deployedFunction(exec.function, exec.slot)
+		exec.stream = app.logStream
+		app.vmStats.start(exec.function.ID)
+	}
+	var compiled, cold bool
+	var handlerStarted time.Time
+
+	ctx, span := app.tracer.Start(executionContext(exec), "runbox.execute", trace.WithAttributes(functionAttributes(exec.function)...))
+	span.SetAttributes(attribute.String("runbox.source", exec.source))
+	exec.ctx = ctx
+	defer func() { endSpan(span, err) }()
+
+	defer func() {
+		elapsed := time.Since(started)
+		switch {
+		case compiled:
+			exec.timings.HandlerMs = millis(time.Since(handlerStarted)) - exec.timings.ExportMs
+		case handlerStarted.IsZero():
+			exec.timings.SetupMs = millis(elapsed)
+		}
+		exec.timings.TotalMs = exec.timings.RouteMs + exec.timings.DBMs + millis(elapsed)
+		if exec.dryRun {
+			return
+		}
+		slow := app.slowExecution(exec.function.ID, elapsed)
+		app.recordExecution(exec, started, slow, result, err)
+		app.captureExecution(exec, started, result, err)
+		app.metrics.observe(exec.function.ID, elapsed, err != nil && err != errExecutionCancelled, slow)
+		app.statsd.observe(exec.function.ID, elapsed, err != nil && err != errExecutionCancelled, slow)
+		app.recordExecutionOutcome(exec.function, err)
+		if err != nil && err != errExecutionCancelled {
+			app.invokeFailureHandler(exec, err)
+		}
+		app.reportExecutionError(exec, err)
+		app.vmStats.finish(exec.function.ID, elapsed, compiled, cold)
+	}()
+
+	vm, release := engine.NewVM(exec.ctx)
+	defer release()
+	defer func() {
+		if r := recover(); r != nil {
+			if r == errExecutionCancelled {
+				result, err = nil, errExecutionCancelled
+				return
+			}
+			app.reportExecutionPanic(exec, r)
+			panic(r)
+		}
+	}()
+
+	app.attachGeo(exec.request)
+	if exec.function.Mode == ModeXML {
+		if err := attachXML(exec.request); err != nil {
+			return xmlErrorResponse(http.StatusBadRequest, xmlResponseType(exec.request), "Malformed XML body: "+err.Error()), nil
+		}
+	}
+	vm.Set("request", exec.request)
+
+	consoleLog := exec.consoleLog
+	vm.Set("console", map[string]interface{}{
+		"log":   consoleLog,
+		"info":  consoleLog,
+		"warn":  consoleLog,
+		"error": consoleLog,
+	})
+
+	app.installRunbox(vm, exec)
+	if err := app.installEnv(vm, exec); err != nil {
+		return nil, fmt.Errorf("failed to load environment: %v", err)
+	}
+	app.installRequire(vm, exec)
+
+	vm.Set("fetch", func(call otto.FunctionCall) otto.Value {
+		url := call.Argument(0).String()
+
+		req, err := http.NewRequestWithContext(exec.ctx, http.MethodGet, url, nil)
+		if err == nil {
+			tracePropagator.Inject(exec.ctx, propagation.HeaderCarrier(req.Header))
+			req.Header.Set(requestIDHeader, exec.requestID)
+		}
+		var resp *http.Response
+		if err == nil {
+			resp, err = http.DefaultClient.Do(req)
+		}
+		if err != nil {
+			val, _ := call.Otto.ToValue(map[string]interface{}{
+				"error": err.Error(),
+			})
+			return val
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+
+		val, _ := call.Otto.ToValue(map[string]interface{}{
+			"status": resp.StatusCode,
+			"body":   string(body),
+		})
+		return val
+	})
+
+	if exec.function.Mode == ModeLambda {
+		exports, _ := vm.Object(`({})`)
+		module, _ := vm.Object(`({})`)
+		module.Set("exports", exports)
+		vm.Set("exports", exports)
+		vm.Set("module", module)
+	}
+
+	if exec.function.Mode == ModeStandard || exec.function.Mode == ModeXML {
+		if _, err := app.runCached(vm, engine.RouterPrelude); err != nil {
+			return nil, fmt.Errorf("failed to load router: %v", err)
+		}
+	}
+
+	if exec.function.Mode == ModeWorkers {
+		if _, err := app.runCached(vm, workersPrelude); err != nil {
+			return nil, fmt.Errorf("failed to load fetch API: %v", err)
+		}
+	}
+
+	compileStarted := time.Now()
+	exec.timings.SetupMs = millis(compileStarted.Sub(started))
+	_, compileSpan := app.tracer.Start(exec.ctx, "runbox.vm.compile")
+	script, cached, err := app.scripts.compileFunction(exec.function.ID, functionSource(exec.function))
+	endSpan(compileSpan, err)
+	handlerStarted = time.Now()
+	exec.timings.CompileMs = millis(handlerStarted.Sub(compileStarted))
+	if err != nil {
+		return nil, fmt.Errorf("JavaScript execution error: %w", err)
+	}
+	compiled, cold = true, !cached
+
+	_, handlerSpan := app.tracer.Start(exec.ctx, "runbox.handler")
+	defer func() { endSpan(handlerSpan, err) }()
+
+	if _, err = vm.Run(script); err != nil {
+		return nil, fmt.Errorf("JavaScript execution error: %w", err)
+	}
+
+	switch exec.function.Mode {
+	case ModeLambda:
+		return invokeLambdaHandler(vm, exec)
+	case ModeWorkers:
+		return invokeFetchHandler(vm, exec)
+	case ModeGraphQL:
+		return app.invokeGraphQL(vm, exec)
+	case ModeXML:
+		return invokeXMLHandler(vm, exec)
+	default:
+		if result, handled, err := invokeRouter(vm, exec); handled {
+			return result, err
+		}
+		return invokeMethodHandler(vm, exec)
+	}
+}
+
+func invokeMethodHandler(vm *otto.Otto, exec *execution) (interface{}, error) {
+	requestData := exec.request
+	methodName := strings.ToUpper(requestData["method"].(string))
+
+	if fn, err := vm.Get(methodName); err == nil && fn.IsFunction() {
+
+		result, err := fn.Call(otto.UndefinedValue(), requestData)
+		if err != nil {
+			return nil, fmt.Errorf("error calling %s handler: %w", methodName, err)
+		}
+
+		if result.IsDefined() {
+			goValue, err := exec.export(result)
+			if err != nil {
+				return nil, fmt.Errorf("failed to export result: %v", err)
+			}
+			return goValue, nil
+		}
+	}
+
+	if defaultFn, err := vm.Get("default"); err == nil && defaultFn.IsFunction() {
+		result, err := defaultFn.Call(otto.UndefinedValue(), requestData)
+		if err != nil {
+			return nil, fmt.Errorf("error calling default handler: %w", err)
+		}
+
+		if result.IsDefined() {
+			goValue, err := exec.export(result)
+			if err != nil {
+				return nil, fmt.Errorf("failed to export result: %v", err)
+			}
+			return goValue, nil
+		}
+	}
+
+	return map[string]interface{}{
+		"error":             fmt.Sprintf("No handler found for method %s. Please define a %s function or a default function.", methodName, methodName),
+		"availableHandlers": []string{"GET", "POST", "PUT", "PATCH", "DELETE", "default"},
+		"method":            methodName,
+	}, nil
+}
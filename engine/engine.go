@@ -0,0 +1,73 @@
+// Package engine holds what the JavaScript engine and its callers agree on:
+// the Executor interface functions are run through, the cancellable VM
+// they run in, the function modes, the response a handler can answer, how
+// modules are wrapped and resolved, and the router functions get in their
+// global scope.
+package engine
+
+import (
+	"path"
+	"strings"
+)
+
+const (
+	ModeStandard = "standard"
+	ModeLambda   = "lambda"
+	ModeWorkers  = "workers"
+	ModeGraphQL  = "graphql"
+	ModeXML      = "xml"
+)
+
+// Modes are the ways a function's code can be called.
+var Modes = []string{ModeStandard, ModeLambda, ModeWorkers, ModeGraphQL, ModeXML}
+
+func ValidMode(mode string) bool {
+	for _, m := range Modes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// HTTPResponse is a handler result that controls the status, headers, and
+// raw body of the HTTP response instead of being serialized as JSON.
+type HTTPResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+}
+
+// ContentType is the Content-Type header the handler set, or JSON.
+func (resp *HTTPResponse) ContentType() string {
+	for name, value := range resp.Headers {
+		if strings.EqualFold(name, "Content-Type") {
+			return value
+		}
+	}
+	return "application/json; charset=utf-8"
+}
+
+// ModuleSource wraps a module CommonJS-style; the header stays on the first
+// line so error positions match the file.
+func ModuleSource(code string) string {
+	return "(function (module, exports, require) {" + code + "\n})"
+}
+
+// ResolveModule finds the module spec names when required from dir: a
+// relative path, with or without .js, or a directory with an index.js.
+func ResolveModule(files map[string]string, dir, spec string) (string, bool) {
+	if !strings.HasPrefix(spec, "./") && !strings.HasPrefix(spec, "../") {
+		return "", false
+	}
+	name := path.Join(dir, spec)
+	if strings.HasPrefix(name, "../") {
+		return "", false
+	}
+	for _, candidate := range []string{name, name + ".js", name + "/index.js"} {
+		if _, ok := files[candidate]; ok {
+			return candidate, true
+		}
+	}
+	return "", false
+}
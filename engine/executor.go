@@ -0,0 +1,40 @@
+package engine
+
+import (
+	"context"
+	"errors"
+
+	"github.com/robertkrimen/otto"
+)
+
+// ErrCancelled is what an execution answers when its context ended before
+// the handler returned.
+var ErrCancelled = errors.New("execution cancelled")
+
+// Executor runs the function serving path with request as its request
+// object: method, path, query, body and headers, as /api/execute builds it.
+// *runbox.Server is one, with the whole runtime: the runbox global, fetch,
+// modules, deployments, logs and metrics.
+type Executor interface {
+	Execute(ctx context.Context, path string, request map[string]interface{}) (interface{}, error)
+}
+
+// NewVM answers a VM that stops once ctx is done. The VM is interrupted
+// between statements by panicking with ErrCancelled, so the code running it
+// recovers that value; release must be called once the VM is done with.
+func NewVM(ctx context.Context) (vm *otto.Otto, release func()) {
+	vm = otto.New()
+
+	// otto.Interrupt is serviced between statements; panicking from the
+	// interrupt func unwinds the VM back to the caller's recover.
+	vm.Interrupt = make(chan func(), 1)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			vm.Interrupt <- func() { panic(ErrCancelled) }
+		case <-done:
+		}
+	}()
+	return vm, func() { close(done) }
+}
@@ -0,0 +1,40 @@
+package engine
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNewVM(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		timeout time.Duration
+		want    error
+	}{
+		{"returns", `1 + 1`, time.Second, nil},
+		{"interrupted", `while (true) {}`, 20 * time.Millisecond, ErrCancelled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+			vm, release := NewVM(ctx)
+			defer release()
+
+			err := func() (err error) {
+				defer func() {
+					if r := recover(); r != nil {
+						err = r.(error)
+					}
+				}()
+				_, err = vm.Run(tt.script)
+				return err
+			}()
+			if err != tt.want {
+				t.Errorf("Run(%q) = %v, want %v", tt.script, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package engine
+
+// RouterPrelude provides a small Express-like router so one function mounted
+// on a wildcard path can serve several sub-routes. Routes are matched against
+// request.subpath, the part of the URL below the function's mount point.
+const RouterPrelude = `
+function Router() {
+	if (!(this instanceof Router)) return new Router();
+	this.routes = [];
+}
+
+function __runboxCompileRoute(pattern) {
+	var keys = [];
+	var wildcards = 0;
+	var source = String(pattern).replace(/\/+$/, '').replace(/[.+?^${}()|[\]\\]/g, '\\$&')
+		.replace(/\*|:(\w+)/g, function (match, key) {
+			if (match === '*') {
+				keys.push(String(wildcards++));
+				return '(.*)';
+			}
+			keys.push(key);
+			return '([^/]+)';
+		});
+	return { keys: keys, regex: new RegExp('^' + source + '/?$') };
+}
+
+function __runboxDecodeParam(value) {
+	try {
+		return decodeURIComponent(value);
+	} catch (e) {
+		return value;
+	}
+}
+
+['get', 'post', 'put', 'patch', 'delete', 'head', 'options', 'all'].forEach(function (method) {
+	Router.prototype[method] = function (pattern, handler) {
+		var compiled = __runboxCompileRoute(pattern);
+		this.routes.push({
+			method: method === 'all' ? '*' : method.toUpperCase(),
+			pattern: pattern,
+			keys: compiled.keys,
+			regex: compiled.regex,
+			handler: handler
+		});
+		return this;
+	};
+});
+
+function __RouterResponse() {
+	this._status = 200;
+	this._headers = {};
+	this._sent = false;
+}
+__RouterResponse.prototype.status = function (code) { this._status = code; return this; };
+__RouterResponse.prototype.set = function (name, value) { this._headers[name] = String(value); return this; };
+__RouterResponse.prototype.send = function (body) {
+	this._body = body == null ? '' : (typeof body === 'object' ? JSON.stringify(body) : String(body));
+	if (typeof body === 'object' && !this._headers['Content-Type']) this._headers['Content-Type'] = 'application/json';
+	this._sent = true;
+	return this;
+};
+__RouterResponse.prototype.json = function (body) {
+	this._headers['Content-Type'] = 'application/json';
+	return this.send(JSON.stringify(body));
+};
+__RouterResponse.prototype.xml = function (body) {
+	if (!this._headers['Content-Type']) this._headers['Content-Type'] = 'application/xml; charset=utf-8';
+	return this.send(body != null && typeof body === 'object' ? runbox.stringify.xml(body) : body);
+};
+__RouterResponse.prototype.pdf = function (html, options) {
+	options = options || {};
+	this._body = runbox.pdf(html, options);
+	this._base64 = true;
+	this._headers['Content-Type'] = 'application/pdf';
+	if (options.filename) this._headers['Content-Disposition'] = 'inline; filename="' + String(options.filename).replace(/["\\\r\n]/g, '') + '"';
+	this._sent = true;
+	return this;
+};
+
+Router.prototype.handle = function (req) {
+	var subpath = req.subpath || '/';
+	for (var i = 0; i < this.routes.length; i++) {
+		var route = this.routes[i];
+		if (route.method !== '*' && route.method !== req.method) continue;
+		var match = route.regex.exec(subpath);
+		if (!match) continue;
+
+		var params = {};
+		for (var k = 0; k < route.keys.length; k++) params[route.keys[k]] = __runboxDecodeParam(match[k + 1]);
+		req.params = params;
+
+		var res = new __RouterResponse();
+		var out = route.handler(req, res);
+		if (out !== undefined) return out;
+		if (res._sent) return { __runboxResponse: true, status: res._status, headers: res._headers, body: res._body, base64: !!res._base64 };
+		return null;
+	}
+	return { __runboxResponse: true, status: 404, headers: { 'Content-Type': 'application/json' },
+		body: JSON.stringify({ error: 'No route for ' + req.method + ' ' + subpath }) };
+};
+
+var app = new Router();
+`
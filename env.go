@@ -0,0 +1,278 @@
+package runbox
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/robertkrimen/otto"
+)
+
+const (
+	maxEnvNameLength = 128
+	maxEnvValueBytes = 32 << 10
+)
+
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// EnvVar is an environment variable, set for every function or, with a
+// FunctionID, for one. A function's own variables override global ones of
+// the same name.
+type EnvVar struct {
+	ID         int    `json:"id"`
+	FunctionID int    `json:"functionId,omitempty"`
+	Name       string `json:"name"`
+	// Value is left empty in API responses for sensitive variables.
+	Value     string    `json:"value"`
+	Sensitive bool      `json:"sensitive"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func (app *App) initEnvTable() error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS env_vars (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		function_id INTEGER REFERENCES functions(id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		value TEXT NOT NULL,
+		sensitive INTEGER NOT NULL DEFAULT 0,
+		updated_at DATETIME NOT NULL
+	);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_env_vars_name ON env_vars (IFNULL(function_id, 0), name);`
+
+	if _, err := app.db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create env_vars table: %v", err)
+	}
+	return nil
+}
+
+func validateEnvVar(name, value string) error {
+	if !envNamePattern.MatchString(name) || len(name) > maxEnvNameLength {
+		return fmt.Errorf("name must start with a letter or underscore and contain only letters, digits and underscores, up to %d characters", maxEnvNameLength)
+	}
+	if len(value) > maxEnvValueBytes {
+		return fmt.Errorf("value is larger than 32 KiB")
+	}
+	return nil
+}
+
+// envScope is the function_id a variable is stored under: NULL for global
+// variables, which are passed as function ID 0.
+func envScope(functionID int) interface{} {
+	if functionID == 0 {
+		return nil
+	}
+	return functionID
+}
+
+const envColumns = `id, IFNULL(function_id, 0), name, value, sensitive, updated_at`
+
+func scanEnvVar(row rowScanner) (*EnvVar, error) {
+	var v EnvVar
+	if err := row.Scan(&v.ID, &v.FunctionID, &v.Name, &v.Value, &v.Sensitive, &v.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// listEnvVars returns the variables set on a function, or the global ones
+// for function ID 0, by name.
+func (app *App) listEnvVars(functionID int) ([]EnvVar, error) {
+	rows, err := app.db.Query(`SELECT `+envColumns+` FROM env_vars WHERE IFNULL(function_id, 0) = ? ORDER BY name`, functionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	vars := []EnvVar{}
+	for rows.Next() {
+		v, err := scanEnvVar(rows)
+		if err != nil {
+			return nil, err
+		}
+		vars = append(vars, *v)
+	}
+	return vars, rows.Err()
+}
+
+func (app *App) getEnvVar(functionID int, name string) (*EnvVar, error) {
+	return scanEnvVar(app.db.QueryRow(`SELECT `+envColumns+` FROM env_vars WHERE IFNULL(function_id, 0) = ? AND name = ?`, functionID, name))
+}
+
+// setEnvVar creates or updates a variable. A nil value keeps the current
+// one, so a sensitive variable can be changed without sending it again.
+func (app *App) setEnvVar(functionID int, name string, value *string, sensitive bool) (*EnvVar, error) {
+	now := time.Now().UTC()
+	if value == nil {
+		result, err := app.db.Exec(`UPDATE env_vars SET sensitive = ?, updated_at = ? WHERE IFNULL(function_id, 0) = ? AND name = ?`,
+			sensitive, now, functionID, name)
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return nil, fmt.Errorf("value is required for a new variable")
+		}
+		return app.getEnvVar(functionID, name)
+	}
+	if err := validateEnvVar(name, *value); err != nil {
+		return nil, err
+	}
+
+	result, err := app.db.Exec(`UPDATE env_vars SET value = ?, sensitive = ?, updated_at = ? WHERE IFNULL(function_id, 0) = ? AND name = ?`,
+		*value, sensitive, now, functionID, name)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		_, err = app.db.Exec(`INSERT INTO env_vars (function_id, name, value, sensitive, updated_at) VALUES (?, ?, ?, ?, ?)`,
+			envScope(functionID), name, *value, sensitive, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return app.getEnvVar(functionID, name)
+}
+
+func (app *App) deleteEnvVar(functionID int, name string) (bool, error) {
+	result, err := app.db.Exec(`DELETE FROM env_vars WHERE IFNULL(function_id, 0) = ? AND name = ?`, functionID, name)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// functionEnv is the environment a function runs with: the global
+// variables overlaid with its own.
+func (app *App) functionEnv(functionID int) (map[string]string, error) {
+	rows, err := app.db.Query(`SELECT name, value FROM env_vars WHERE function_id IS NULL OR function_id = ?
+		ORDER BY function_id IS NOT NULL`, functionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	env := map[string]string{}
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		env[name] = value
+	}
+	return env, rows.Err()
+}
+
+// installEnv exposes the function's environment as process.env, which is
+// also the env argument of a Workers default export's fetch.
+func (app *App) installEnv(vm *otto.Otto, exec *execution) error {
+	env, err := app.functionEnv(exec.function.ID)
+	if err != nil {
+		return err
+	}
+	exec.env = env
+	process, _ := vm.Object(`({})`)
+	process.Set("env", env)
+	return vm.Set("process", process)
+}
+
+// masked blanks the values of sensitive variables for API responses.
+func masked(vars []EnvVar) []EnvVar {
+	out := make([]EnvVar, len(vars))
+	for i, v := range vars {
+		if v.Sensitive {
+			v.Value = ""
+		}
+		out[i] = v
+	}
+	return out
+}
+
+// envFunctionID reads the scope of an env route: the :id function, which
+// must exist, or 0 for the global routes.
+func (app *App) envFunctionID(c *gin.Context) (int, bool) {
+	if c.Param("id") == "" {
+		return 0, true
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return 0, false
+	}
+	if _, err := app.getFunctionByID(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Function not found"})
+		return 0, false
+	}
+	return id, true
+}
+
+// listEnvHandler serves GET /api/env and GET /api/functions/:id/env. A
+// function's listing includes the global variables it inherits.
+func (app *App) listEnvHandler(c *gin.Context) {
+	functionID, ok := app.envFunctionID(c)
+	if !ok {
+		return
+	}
+	vars, err := app.listEnvVars(functionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list variables"})
+		return
+	}
+	response := gin.H{"vars": masked(vars)}
+	if functionID != 0 {
+		global, err := app.listEnvVars(0)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list variables"})
+			return
+		}
+		response["global"] = masked(global)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// setEnvHandler serves PUT /api/env/:name and PUT
+// /api/functions/:id/env/:name with {value, sensitive}; leaving value out
+// keeps the current one.
+func (app *App) setEnvHandler(c *gin.Context) {
+	functionID, ok := app.envFunctionID(c)
+	if !ok {
+		return
+	}
+	var in struct {
+		Value     *string `json:"value"`
+		Sensitive bool    `json:"sensitive"`
+	}
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid variable body"})
+		return
+	}
+
+	v, err := app.setEnvVar(functionID, c.Param("name"), in.Value, in.Sensitive)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, masked([]EnvVar{*v})[0])
+}
+
+func (app *App) deleteEnvHandler(c *gin.Context) {
+	functionID, ok := app.envFunctionID(c)
+	if !ok {
+		return
+	}
+	deleted, err := app.deleteEnvVar(functionID, c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete variable"})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Variable not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deleted": true})
+}
+
+func (app *App) envPage(c *gin.Context) {
+	c.HTML(http.StatusOK, "env.html", gin.H{"title": "Environment Variables"})
+}
@@ -0,0 +1,215 @@
+package runbox
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/robertkrimen/otto"
+)
+
+const (
+	ErrorGroupOpen     = "open"
+	ErrorGroupResolved = "resolved"
+)
+
+// ErrorGroup aggregates the failed executions of a function that share a
+// fingerprint: the error message with its variable parts masked, plus the
+// innermost script frame it was thrown from.
+type ErrorGroup struct {
+	ID              int       `json:"id"`
+	FunctionID      int       `json:"functionId"`
+	Fingerprint     string    `json:"fingerprint"`
+	Message         string    `json:"message"`
+	Location        string    `json:"location,omitempty"`
+	Count           int       `json:"count"`
+	Status          string    `json:"status"`
+	FirstSeen       time.Time `json:"firstSeen"`
+	LastSeen        time.Time `json:"lastSeen"`
+	LastExecutionID string    `json:"lastExecutionId"`
+}
+
+func (app *App) initErrorGroupsTable() error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS error_groups (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		function_id INTEGER NOT NULL REFERENCES functions(id) ON DELETE CASCADE,
+		fingerprint TEXT NOT NULL,
+		message TEXT NOT NULL,
+		location TEXT NOT NULL DEFAULT '',
+		count INTEGER NOT NULL DEFAULT 0,
+		status TEXT NOT NULL DEFAULT 'open',
+		first_seen DATETIME NOT NULL,
+		last_seen DATETIME NOT NULL,
+		last_execution_id TEXT NOT NULL DEFAULT '',
+		UNIQUE (function_id, fingerprint)
+	);
+	CREATE INDEX IF NOT EXISTS idx_error_groups_last_seen ON error_groups (function_id, last_seen);`
+
+	if _, err := app.db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create error_groups table: %v", err)
+	}
+	return nil
+}
+
+var (
+	errorFramePattern = regexp.MustCompile(`^\s*at (.+)$`)
+	errorMaskPattern  = regexp.MustCompile(`"[^"]*"|'[^']*'|\b[0-9a-fA-F]{8,}\b|\d+`)
+)
+
+// errorFingerprint groups errors that differ only in ids, numbers or quoted
+// values. The location is the innermost frame of a script error, if any.
+func errorFingerprint(err error) (fingerprint, location string) {
+	var scriptErr *otto.Error
+	if errors.As(err, &scriptErr) {
+		for _, line := range strings.Split(scriptErr.String(), "\n") {
+			if m := errorFramePattern.FindStringSubmatch(line); m != nil {
+				location = m[1]
+				break
+			}
+		}
+	}
+
+	masked := errorMaskPattern.ReplaceAllStringFunc(err.Error(), func(s string) string {
+		if s[0] == '"' || s[0] == '\'' {
+			return `"…"`
+		}
+		return "N"
+	})
+	sum := sha256.Sum256([]byte(masked + "\n" + location))
+	return hex.EncodeToString(sum[:8]), location
+}
+
+// recordErrorGroup counts a failed execution against its group. A resolved
+// group that fails again is reopened.
+func (app *App) recordErrorGroup(e *ExecutionLog) {
+	_, err := app.db.Exec(`INSERT INTO error_groups (function_id, fingerprint, message, location, count, status, first_seen, last_seen, last_execution_id)
+		VALUES (?, ?, ?, ?, 1, 'open', ?, ?, ?)
+		ON CONFLICT (function_id, fingerprint) DO UPDATE SET count = count + 1, message = excluded.message,
+			status = 'open', last_seen = excluded.last_seen, last_execution_id = excluded.last_execution_id`,
+		e.FunctionID, e.Fingerprint, e.Error, e.errorLocation, e.CreatedAt, e.CreatedAt, e.ID)
+	if err != nil {
+		log.Printf("Failed to group error of function %d: %v", e.FunctionID, err)
+	}
+}
+
+const errorGroupColumns = `id, function_id, fingerprint, message, location, count, status, first_seen, last_seen, last_execution_id`
+
+func scanErrorGroup(row rowScanner) (*ErrorGroup, error) {
+	var g ErrorGroup
+	err := row.Scan(&g.ID, &g.FunctionID, &g.Fingerprint, &g.Message, &g.Location, &g.Count, &g.Status,
+		&g.FirstSeen, &g.LastSeen, &g.LastExecutionID)
+	if err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+func (app *App) getErrorGroup(id int) (*ErrorGroup, error) {
+	return scanErrorGroup(app.db.QueryRow(`SELECT `+errorGroupColumns+` FROM error_groups WHERE id = ?`, id))
+}
+
+// listErrorGroups serves GET /api/functions/:id/errors, most recently seen
+// first, optionally filtered by status.
+func (app *App) listErrorGroups(c *gin.Context) {
+	functionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+
+	query := `SELECT ` + errorGroupColumns + ` FROM error_groups WHERE function_id = ?`
+	args := []interface{}{functionID}
+	if status := c.Query("status"); status != "" {
+		if status != ErrorGroupOpen && status != ErrorGroupResolved {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "status must be open or resolved"})
+			return
+		}
+		query += ` AND status = ?`
+		args = append(args, status)
+	}
+
+	limit, offset := 50, 0
+	if v := c.Query("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > 500 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 500"})
+			return
+		}
+	}
+	if v := c.Query("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+			return
+		}
+	}
+	query += ` ORDER BY last_seen DESC LIMIT ? OFFSET ?`
+	args = append(args, limit, offset)
+
+	rows, err := app.db.Query(query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list errors"})
+		return
+	}
+	defer rows.Close()
+
+	groups := []ErrorGroup{}
+	for rows.Next() {
+		g, err := scanErrorGroup(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list errors"})
+			return
+		}
+		groups = append(groups, *g)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"errors": groups})
+}
+
+func (app *App) getErrorGroupHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid error group ID"})
+		return
+	}
+
+	g, err := app.getErrorGroup(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Error group not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, g)
+}
+
+// setErrorGroupStatus serves POST /api/errors/:id/resolve and
+// /api/errors/:id/reopen.
+func (app *App) setErrorGroupStatus(status string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid error group ID"})
+			return
+		}
+
+		res, err := app.db.Exec(`UPDATE error_groups SET status = ? WHERE id = ?`, status, id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update error group"})
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Error group not found"})
+			return
+		}
+
+		g, _ := app.getErrorGroup(id)
+		c.JSON(http.StatusOK, g)
+	}
+}
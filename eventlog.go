@@ -0,0 +1,294 @@
+package runbox
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	JobSourceCloudEvent = "cloudevent"
+	JobSourceReplay     = "replay"
+
+	defaultEventLogRetentionDays = 30
+	maxReplayEvents              = 1000
+)
+
+// LoggedEvent is one invocation of a function by an event, CloudEvent or
+// trigger message. The stored request is exactly what the function received,
+// so it can be replayed later against the function's current code. Topic is
+// the event topic, the CloudEvent type, or "<type>:<trigger id>".
+type LoggedEvent struct {
+	ID         string          `json:"id"`
+	Source     string          `json:"source"`
+	Topic      string          `json:"topic"`
+	FunctionID int             `json:"functionId"`
+	Path       string          `json:"path"`
+	JobID      string          `json:"jobId,omitempty"`
+	Request    json.RawMessage `json:"request"`
+	ReceivedAt time.Time       `json:"receivedAt"`
+}
+
+func (app *App) initEventLogTable() error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS event_log (
+		id TEXT PRIMARY KEY,
+		source TEXT NOT NULL,
+		topic TEXT NOT NULL,
+		function_id INTEGER NOT NULL,
+		path TEXT NOT NULL,
+		job_id TEXT,
+		request TEXT NOT NULL,
+		received_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_event_log_received ON event_log (received_at);
+	CREATE INDEX IF NOT EXISTS idx_event_log_function ON event_log (function_id, received_at);`
+
+	if _, err := app.db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create event_log table: %v", err)
+	}
+	return nil
+}
+
+const loggedEventColumns = `id, source, topic, function_id, path, job_id, request, received_at`
+
+func scanLoggedEvent(row rowScanner) (*LoggedEvent, error) {
+	var e LoggedEvent
+	var jobID sql.NullString
+	var request string
+	if err := row.Scan(&e.ID, &e.Source, &e.Topic, &e.FunctionID, &e.Path, &jobID, &request, &e.ReceivedAt); err != nil {
+		return nil, err
+	}
+	e.JobID = jobID.String
+	e.Request = json.RawMessage(request)
+	return &e, nil
+}
+
+// logEvent records an invocation. Failing to log never stops the delivery
+// itself.
+func (app *App) logEvent(source, topic string, function *Function, requestData map[string]interface{}, jobID string) {
+	request, err := json.Marshal(requestData)
+	if err != nil {
+		return
+	}
+
+	var job sql.NullString
+	if jobID != "" {
+		job = sql.NullString{String: jobID, Valid: true}
+	}
+
+	_, err = app.db.Exec(`INSERT INTO event_log (`+loggedEventColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		newID(), source, topic, function.ID, function.Path, job, string(request), time.Now().UTC())
+	if err != nil {
+		log.Printf("Failed to log %s event %s: %v", source, topic, err)
+	}
+}
+
+// pruneEventLog drops entries past the retention period, at most once an
+// hour. A negative retention keeps the log forever.
+func (app *App) pruneEventLog(now time.Time) {
+	days := app.config.EventLog.RetentionDays
+	if days == 0 {
+		days = defaultEventLogRetentionDays
+	}
+	if days < 0 || !app.periodic.due("event-log-prune", now, time.Hour) {
+		return
+	}
+
+	if _, err := app.db.Exec(`DELETE FROM event_log WHERE received_at < ?`, now.AddDate(0, 0, -days)); err != nil {
+		log.Println("Failed to prune event log:", err)
+	}
+}
+
+// eventLogFilter selects entries by id, or by source, topic, function and a
+// time range; topic accepts the same trailing "*" wildcard as subscriptions.
+type eventLogFilter struct {
+	IDs        []string  `json:"ids"`
+	Source     string    `json:"source"`
+	Topic      string    `json:"topic"`
+	FunctionID int       `json:"functionId"`
+	From       time.Time `json:"from"`
+	To         time.Time `json:"to"`
+}
+
+func (f *eventLogFilter) where() (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	if len(f.IDs) > 0 {
+		conditions = append(conditions, `id IN (?`+strings.Repeat(`, ?`, len(f.IDs)-1)+`)`)
+		for _, id := range f.IDs {
+			args = append(args, id)
+		}
+	}
+	if f.Source != "" {
+		conditions = append(conditions, `source = ?`)
+		args = append(args, f.Source)
+	}
+	if f.Topic != "" {
+		if prefix, ok := strings.CutSuffix(f.Topic, "*"); ok {
+			conditions = append(conditions, `substr(topic, 1, ?) = ?`)
+			args = append(args, len(prefix), prefix)
+		} else {
+			conditions = append(conditions, `topic = ?`)
+			args = append(args, f.Topic)
+		}
+	}
+	if f.FunctionID != 0 {
+		conditions = append(conditions, `function_id = ?`)
+		args = append(args, f.FunctionID)
+	}
+	if !f.From.IsZero() {
+		conditions = append(conditions, `received_at >= ?`)
+		args = append(args, f.From.UTC())
+	}
+	if !f.To.IsZero() {
+		conditions = append(conditions, `received_at < ?`)
+		args = append(args, f.To.UTC())
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return ` WHERE ` + strings.Join(conditions, ` AND `), args
+}
+
+func (app *App) findLoggedEvents(f *eventLogFilter, limit, offset int) ([]LoggedEvent, error) {
+	where, args := f.where()
+	rows, err := app.db.Query(`SELECT `+loggedEventColumns+` FROM event_log`+where+` ORDER BY received_at, id LIMIT ? OFFSET ?`,
+		append(args, limit, offset)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []LoggedEvent{}
+	for rows.Next() {
+		e, err := scanLoggedEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, *e)
+	}
+
+	return events, nil
+}
+
+// replayEvent queues the logged request again against the function's current
+// code. Replays are not logged themselves; the request carries
+// request.replay so handlers can tell them apart.
+func (app *App) replayEvent(e *LoggedEvent) (*Job, error) {
+	function, err := app.getFunctionByID(e.FunctionID)
+	if err != nil {
+		return nil, fmt.Errorf("function %d no longer exists", e.FunctionID)
+	}
+
+	var requestData map[string]interface{}
+	if err := json.Unmarshal(e.Request, &requestData); err != nil {
+		return nil, err
+	}
+	requestData["replay"] = map[string]interface{}{
+		"eventId":    e.ID,
+		"source":     e.Source,
+		"topic":      e.Topic,
+		"receivedAt": e.ReceivedAt.Format(time.RFC3339Nano),
+	}
+
+	return app.enqueueJob(function, requestData, jobOptions{Source: JobSourceReplay})
+}
+
+func (app *App) listEventLogHandler(c *gin.Context) {
+	f := eventLogFilter{Source: c.Query("source"), Topic: c.Query("topic")}
+	limit, offset := 50, 0
+	var err error
+	if v := c.Query("function_id"); v != "" {
+		if f.FunctionID, err = strconv.Atoi(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function_id"})
+			return
+		}
+	}
+	for name, t := range map[string]*time.Time{"from": &f.From, "to": &f.To} {
+		if v := c.Query(name); v != "" {
+			if *t, err = time.Parse(time.RFC3339, v); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": name + " must be an RFC 3339 time"})
+				return
+			}
+		}
+	}
+	if v := c.Query("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > 500 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 500"})
+			return
+		}
+	}
+	if v := c.Query("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+			return
+		}
+	}
+
+	events, err := app.findLoggedEvents(&f, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list events"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"events": events})
+}
+
+func (app *App) getLoggedEventHandler(c *gin.Context) {
+	e, err := scanLoggedEvent(app.db.QueryRow(`SELECT `+loggedEventColumns+` FROM event_log WHERE id = ?`, c.Param("id")))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, e)
+}
+
+func (app *App) replayEventsHandler(c *gin.Context) {
+	var f eventLogFilter
+	if err := c.ShouldBindJSON(&f); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid replay body"})
+		return
+	}
+	if len(f.IDs) == 0 && f.From.IsZero() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ids or from is required"})
+		return
+	}
+	if len(f.IDs) > maxReplayEvents {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("at most %d events can be replayed at once", maxReplayEvents)})
+		return
+	}
+
+	events, err := app.findLoggedEvents(&f, maxReplayEvents+1, 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load events"})
+		return
+	}
+	if len(events) > maxReplayEvents {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("more than %d events match; narrow the range", maxReplayEvents)})
+		return
+	}
+
+	replays, replayed := []gin.H{}, 0
+	for i := range events {
+		job, err := app.replayEvent(&events[i])
+		if err != nil {
+			replays = append(replays, gin.H{"eventId": events[i].ID, "error": err.Error()})
+			continue
+		}
+		replays = append(replays, gin.H{"eventId": events[i].ID, "jobId": job.ID})
+		replayed++
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"replayed": replayed, "replays": replays})
+}
@@ -0,0 +1,266 @@
+package runbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/robertkrimen/otto"
+)
+
+const JobSourceEvent = "event"
+
+// maxEventDepth bounds chains of functions publishing events that trigger
+// each other, so a subscriber publishing to its own topic cannot loop forever.
+const maxEventDepth = 16
+
+var errEventDepthExceeded = fmt.Errorf("event chain exceeds %d hops", maxEventDepth)
+
+type Subscription struct {
+	ID         int       `json:"id"`
+	Topic      string    `json:"topic"`
+	FunctionID int       `json:"functionId"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+func (app *App) initSubscriptionsTable() error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS subscriptions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		topic TEXT NOT NULL,
+		function_id INTEGER NOT NULL REFERENCES functions(id) ON DELETE CASCADE,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (topic, function_id)
+	);`
+
+	if _, err := app.db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create subscriptions table: %v", err)
+	}
+	return nil
+}
+
+func (app *App) getSubscriptions(functionID int) ([]Subscription, error) {
+	query := `SELECT id, topic, function_id, created_at FROM subscriptions`
+	var args []interface{}
+	if functionID != 0 {
+		query += ` WHERE function_id = ?`
+		args = append(args, functionID)
+	}
+	query += ` ORDER BY topic, id`
+
+	rows, err := app.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subscriptions := []Subscription{}
+	for rows.Next() {
+		var s Subscription
+		if err := rows.Scan(&s.ID, &s.Topic, &s.FunctionID, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, s)
+	}
+
+	return subscriptions, nil
+}
+
+// publishEvent fans an event out to every function subscribed to the topic,
+// enqueueing one async job per subscriber. Topic subscriptions accept the
+// same trailing "*" wildcard as CloudEvent routes.
+func (app *App) publishEvent(topic string, payload json.RawMessage, publisher, requestID string, depth int) ([]string, error) {
+	if depth > maxEventDepth {
+		return nil, errEventDepthExceeded
+	}
+
+	subscriptions, err := app.getSubscriptions(0)
+	if err != nil {
+		return nil, err
+	}
+
+	event := map[string]interface{}{
+		"id":          newID(),
+		"topic":       topic,
+		"publishedBy": publisher,
+		"publishedAt": time.Now().UTC().Format(time.RFC3339Nano),
+		"depth":       depth,
+	}
+
+	jobIDs := []string{}
+	for _, s := range subscriptions {
+		if !matchesEventType(s.Topic, topic) {
+			continue
+		}
+
+		function, err := app.getFunctionByID(s.FunctionID)
+		if err != nil {
+			continue
+		}
+
+		requestData := syntheticRequest(function, "EVENT", payload)
+		requestData["event"] = event
+		requestData["id"] = requestID
+		job, err := app.enqueueJob(function, requestData, jobOptions{Source: JobSourceEvent})
+		if err != nil {
+			log.Printf("Failed to deliver event %s to %s: %v", topic, function.Path, err)
+			continue
+		}
+		app.logEvent(JobSourceEvent, topic, function, requestData, job.ID)
+		jobIDs = append(jobIDs, job.ID)
+	}
+
+	return jobIDs, nil
+}
+
+// eventDepth returns how many publish hops led to this execution.
+func (exec *execution) eventDepth() int {
+	event, ok := exec.request["event"].(map[string]interface{})
+	if !ok {
+		return 0
+	}
+	switch depth := event["depth"].(type) {
+	case int:
+		return depth
+	case float64:
+		return int(depth)
+	}
+	return 0
+}
+
+func (app *App) jsEvents(vm *otto.Otto, exec *execution) *otto.Object {
+	events, _ := vm.Object(`({})`)
+
+	events.Set("publish", func(call otto.FunctionCall) otto.Value {
+		topic := call.Argument(0).String()
+		if !call.Argument(0).IsString() || topic == "" {
+			throwError(call, "runbox.events.publish: topic must be a non-empty string")
+		}
+
+		var payload json.RawMessage
+		if arg := call.Argument(1); arg.IsDefined() && !arg.IsNull() {
+			exported, _ := arg.Export()
+			b, err := json.Marshal(exported)
+			if err != nil {
+				throwError(call, "runbox.events.publish: payload must be JSON-serializable")
+			}
+			payload = b
+		}
+
+		jobIDs, err := app.publishEvent(topic, payload, exec.function.Path, exec.requestID, exec.eventDepth()+1)
+		if err != nil {
+			throwError(call, "runbox.events.publish: "+err.Error())
+		}
+		return toValue(call, len(jobIDs))
+	})
+
+	return events
+}
+
+func (app *App) publishEventHandler(c *gin.Context) {
+	topic := c.Param("topic")
+
+	payload, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return
+	}
+	if len(payload) > 0 && !json.Valid(payload) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Payload must be valid JSON"})
+		return
+	}
+
+	jobIDs, err := app.publishEvent(topic, payload, "api", requestID(c), 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to publish event", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"topic": topic, "deliveries": len(jobIDs), "jobIds": jobIDs})
+}
+
+func (app *App) listTopics(c *gin.Context) {
+	rows, err := app.db.Query(`SELECT topic, COUNT(*) FROM subscriptions GROUP BY topic ORDER BY topic`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list topics"})
+		return
+	}
+	defer rows.Close()
+
+	topics := []gin.H{}
+	for rows.Next() {
+		var topic string
+		var subscribers int
+		if err := rows.Scan(&topic, &subscribers); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list topics"})
+			return
+		}
+		topics = append(topics, gin.H{"topic": topic, "subscribers": subscribers})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"topics": topics})
+}
+
+func (app *App) listFunctionSubscriptions(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+
+	subscriptions, err := app.getSubscriptions(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list subscriptions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"subscriptions": subscriptions})
+}
+
+func (app *App) createSubscription(c *gin.Context) {
+	functionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+	if _, err := app.getFunctionByID(functionID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Function not found"})
+		return
+	}
+
+	var in struct {
+		Topic string `json:"topic"`
+	}
+	if err := c.ShouldBindJSON(&in); err != nil || in.Topic == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "topic is required"})
+		return
+	}
+
+	result, err := app.db.Exec(`INSERT INTO subscriptions (topic, function_id) VALUES (?, ?)`, in.Topic, functionID)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Failed to create subscription: " + err.Error()})
+		return
+	}
+
+	id, _ := result.LastInsertId()
+	c.JSON(http.StatusCreated, Subscription{ID: int(id), Topic: in.Topic, FunctionID: functionID, CreatedAt: time.Now().UTC()})
+}
+
+func (app *App) deleteSubscription(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid subscription ID"})
+		return
+	}
+
+	if _, err := app.db.Exec(`DELETE FROM subscriptions WHERE id = ?`, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete subscription"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Subscription deleted successfully"})
+}
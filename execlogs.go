@@ -0,0 +1,388 @@
+package runbox
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultExecutionLogRetentionDays = 7
+	defaultExecutionLogBodyBytes     = 4096
+	executionLogBuffer               = 1024
+)
+
+// ExecutionLog is the record of one execution. Request and Response are cut
+// to executionLogs.maxBodyBytes, with Truncated set when either was.
+type ExecutionLog struct {
+	ID          string    `json:"id"`
+	FunctionID  int       `json:"functionId"`
+	Version     int       `json:"version"`
+	Method      string    `json:"method"`
+	Source      string    `json:"source"`
+	Status      string    `json:"status"`
+	HTTPStatus  int       `json:"httpStatus,omitempty"`
+	DurationMs  int64     `json:"durationMs"`
+	Slow        bool      `json:"slow"`
+	Error       string    `json:"error,omitempty"`
+	Request     string    `json:"request"`
+	Response    string    `json:"response,omitempty"`
+	Truncated   bool      `json:"truncated"`
+	Logs        []string  `json:"logs"`
+	JobID       string    `json:"jobId,omitempty"`
+	RequestID   string    `json:"requestId"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+
+	Timings *ExecutionTimings `json:"timings,omitempty"`
+
+	errorLocation string
+}
+
+func (app *App) initExecutionLogsTable() error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS execution_logs (
+		id TEXT PRIMARY KEY,
+		function_id INTEGER NOT NULL,
+		version INTEGER NOT NULL,
+		method TEXT NOT NULL,
+		source TEXT NOT NULL,
+		status TEXT NOT NULL,
+		http_status INTEGER,
+		duration_ms INTEGER NOT NULL,
+		error TEXT NOT NULL DEFAULT '',
+		request TEXT NOT NULL,
+		response TEXT NOT NULL DEFAULT '',
+		truncated INTEGER NOT NULL DEFAULT 0,
+		logs TEXT NOT NULL,
+		job_id TEXT,
+		created_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_execution_logs_function ON execution_logs (function_id, created_at);
+	CREATE INDEX IF NOT EXISTS idx_execution_logs_created ON execution_logs (created_at);`
+
+	if _, err := app.db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create execution_logs table: %v", err)
+	}
+	return app.addColumns("execution_logs", [][2]string{
+		{"fingerprint", "TEXT NOT NULL DEFAULT ''"},
+		{"slow", "INTEGER NOT NULL DEFAULT 0"},
+		{"request_id", "TEXT NOT NULL DEFAULT ''"},
+		{"timings", "TEXT NOT NULL DEFAULT ''"},
+	})
+}
+
+// startExecutionLogWriter writes execution logs in the background so
+// recording them adds no database round trip to the request. Entries are
+// dropped, with a warning, when the writer falls behind. On Close the
+// entries still buffered are written before it returns.
+func (app *App) startExecutionLogWriter() {
+	app.execLogs = make(chan *ExecutionLog, executionLogBuffer)
+	app.goBackground(func() {
+		for {
+			select {
+			case entry := <-app.execLogs:
+				app.insertExecutionLog(entry)
+			case <-app.ctx.Done():
+				for {
+					select {
+					case entry := <-app.execLogs:
+						app.insertExecutionLog(entry)
+					default:
+						return
+					}
+				}
+			}
+		}
+	})
+}
+
+func (app *App) insertExecutionLog(e *ExecutionLog) {
+	logsJSON, _ := json.Marshal(e.Logs)
+	var httpStatus sql.NullInt64
+	if e.HTTPStatus != 0 {
+		httpStatus = sql.NullInt64{Int64: int64(e.HTTPStatus), Valid: true}
+	}
+	var jobID sql.NullString
+	if e.JobID != "" {
+		jobID = sql.NullString{String: e.JobID, Valid: true}
+	}
+	var timings []byte
+	if e.Timings != nil {
+		timings, _ = json.Marshal(e.Timings)
+	}
+
+	_, err := app.db.Exec(`INSERT INTO execution_logs (`+executionLogColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.ID, e.FunctionID, e.Version, e.Method, e.Source, e.Status, httpStatus, e.DurationMs, e.Error,
+		e.Request, e.Response, e.Truncated, string(logsJSON), jobID, e.Fingerprint, e.Slow, e.RequestID, string(timings), e.CreatedAt)
+	if err != nil {
+		log.Printf("Failed to record execution of function %d: %v", e.FunctionID, err)
+	}
+	if e.Fingerprint != "" {
+		app.recordErrorGroup(e)
+	}
+}
+
+// recordExecution queues the log entry of a finished execution.
+func (app *App) recordExecution(exec *execution, started time.Time, slow bool, result interface{}, execErr error) {
+	if app.execLogs == nil {
+		return
+	}
+
+	limit := app.config.ExecutionLogs.MaxBodyBytes
+	if limit <= 0 {
+		limit = defaultExecutionLogBodyBytes
+	}
+	truncated := false
+	cut := func(s string) string {
+		if len(s) > limit {
+			truncated = true
+			return strings.ToValidUTF8(s[:limit], "")
+		}
+		return s
+	}
+
+	timings := exec.timings
+	e := &ExecutionLog{
+		ID:         exec.id,
+		FunctionID: exec.function.ID,
+		Version:    exec.function.Version,
+		Source:     exec.source,
+		Status:     JobSucceeded,
+		DurationMs: time.Since(started).Milliseconds(),
+		Slow:       slow,
+		RequestID:  exec.requestID,
+		Timings:    &timings,
+		Logs:       append([]string{}, exec.logs...),
+		CreatedAt:  started.UTC(),
+	}
+	if method, ok := exec.request["method"].(string); ok {
+		e.Method = method
+	}
+	if exec.job != nil {
+		e.JobID = exec.job.ID
+	}
+
+	request, _ := json.Marshal(exec.request)
+	e.Request = cut(string(request))
+
+	switch {
+	case execErr == errExecutionCancelled:
+		e.Status, e.Error = JobCancelled, execErr.Error()
+	case execErr != nil:
+		e.Status, e.Error = JobFailed, execErr.Error()
+		e.Fingerprint, e.errorLocation = errorFingerprint(execErr)
+	default:
+		if resp, err := responseFromResult(result); err == nil {
+			e.HTTPStatus = resp.Status
+			e.Response = cut(resp.Body)
+		}
+	}
+	e.Truncated = truncated
+	if exec.holdLog {
+		exec.heldLog = e
+		return
+	}
+	app.queueExecutionLog(exec.function, e)
+}
+
+// releaseExecutionLog queues an entry held back by exec.holdLog, with the
+// timings measured since it was recorded.
+func (app *App) releaseExecutionLog(exec *execution) {
+	if e := exec.heldLog; e != nil {
+		exec.heldLog = nil
+		timings := exec.timings
+		e.Timings = &timings
+		app.queueExecutionLog(exec.function, e)
+	}
+}
+
+func (app *App) queueExecutionLog(function *Function, e *ExecutionLog) {
+	app.logStream.publish(executionEvent(function.Path, e))
+	app.exportExecutionLog(function, e)
+
+	select {
+	case app.execLogs <- e:
+	default:
+		log.Printf("Execution log buffer is full; dropping the entry for %s", function.Path)
+	}
+}
+
+// pruneExecutionLogs drops entries past the retention period, at most once
+// an hour. A negative retention keeps them forever.
+func (app *App) pruneExecutionLogs(now time.Time) {
+	days := app.config.ExecutionLogs.RetentionDays
+	if days == 0 {
+		days = defaultExecutionLogRetentionDays
+	}
+	if days < 0 || !app.periodic.due("execution-log-prune", now, time.Hour) {
+		return
+	}
+
+	if _, err := app.db.Exec(`DELETE FROM execution_logs WHERE created_at < ?`, now.AddDate(0, 0, -days)); err != nil {
+		log.Println("Failed to prune execution logs:", err)
+	}
+}
+
+const executionLogColumns = `id, function_id, version, method, source, status, http_status, duration_ms, error, request, response, truncated, logs, job_id, fingerprint, slow, request_id, timings, created_at`
+
+func scanExecutionLog(row rowScanner) (*ExecutionLog, error) {
+	var (
+		e          ExecutionLog
+		httpStatus sql.NullInt64
+		jobID      sql.NullString
+		logs       string
+		timings    string
+	)
+	err := row.Scan(&e.ID, &e.FunctionID, &e.Version, &e.Method, &e.Source, &e.Status, &httpStatus, &e.DurationMs,
+		&e.Error, &e.Request, &e.Response, &e.Truncated, &logs, &jobID, &e.Fingerprint, &e.Slow, &e.RequestID, &timings, &e.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	e.HTTPStatus = int(httpStatus.Int64)
+	e.JobID = jobID.String
+	e.Logs = []string{}
+	json.Unmarshal([]byte(logs), &e.Logs)
+	if timings != "" {
+		e.Timings = &ExecutionTimings{}
+		json.Unmarshal([]byte(timings), e.Timings)
+	}
+	return &e, nil
+}
+
+// listFunctionLogs serves GET /api/functions/:id/logs, newest first. Filters:
+// status, method, source, fingerprint, requestId, slow=true, since and until (RFC 3339), q (a
+// substring of the error or console output), limit and offset.
+func (app *App) listFunctionLogs(c *gin.Context) {
+	functionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+
+	query := `SELECT ` + executionLogColumns + ` FROM execution_logs WHERE function_id = ?`
+	args := []interface{}{functionID}
+	for _, filter := range []struct{ param, column string }{
+		{"status", "status"}, {"method", "method"}, {"source", "source"}, {"fingerprint", "fingerprint"}, {"requestId", "request_id"},
+	} {
+		if v := c.Query(filter.param); v != "" {
+			query += ` AND ` + filter.column + ` = ?`
+			args = append(args, v)
+		}
+	}
+	for _, bound := range []struct{ param, cond string }{{"since", ` AND created_at >= ?`}, {"until", ` AND created_at < ?`}} {
+		if v := c.Query(bound.param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": bound.param + " must be an RFC 3339 time"})
+				return
+			}
+			query += bound.cond
+			args = append(args, t.UTC())
+		}
+	}
+	if c.Query("slow") == "true" {
+		query += ` AND slow = 1`
+	}
+	if q := c.Query("q"); q != "" {
+		query += ` AND (instr(error, ?) > 0 OR instr(logs, ?) > 0)`
+		args = append(args, q, q)
+	}
+
+	limit, offset := 50, 0
+	if v := c.Query("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > 500 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 500"})
+			return
+		}
+	}
+	if v := c.Query("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+			return
+		}
+	}
+	query += ` ORDER BY created_at DESC LIMIT ? OFFSET ?`
+	args = append(args, limit, offset)
+
+	entries, err := app.queryExecutionLogs(query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list logs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"logs": entries})
+}
+
+// queryExecutionLogs runs a SELECT of executionLogColumns.
+func (app *App) queryExecutionLogs(query string, args ...interface{}) ([]ExecutionLog, error) {
+	rows, err := app.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []ExecutionLog{}
+	for rows.Next() {
+		e, err := scanExecutionLog(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *e)
+	}
+	return entries, rows.Err()
+}
+
+// functionLogsPage serves /functions/:id/logs: the latest executions, each
+// with a bar showing where its time went.
+func (app *App) functionLogsPage(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "Invalid function ID"})
+		return
+	}
+	function, err := app.getFunctionByID(id)
+	if err != nil {
+		c.HTML(http.StatusNotFound, "error.html", gin.H{"error": "Function not found"})
+		return
+	}
+
+	rows, err := app.db.Query(`SELECT `+executionLogColumns+` FROM execution_logs WHERE function_id = ? ORDER BY created_at DESC LIMIT 50`, id)
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	type entry struct {
+		ExecutionLog
+		Bars []timingBar
+	}
+	var entries []entry
+	for rows.Next() {
+		e, err := scanExecutionLog(rows)
+		if err != nil {
+			c.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": err.Error()})
+			return
+		}
+		row := entry{ExecutionLog: *e}
+		if e.Timings != nil {
+			row.Bars = e.Timings.bars()
+		}
+		entries = append(entries, row)
+	}
+
+	c.HTML(http.StatusOK, "logs.html", gin.H{
+		"title":    function.Name + " Logs",
+		"function": function,
+		"entries":  entries,
+		"legend":   ExecutionTimings{}.phases(),
+	})
+}
@@ -0,0 +1,110 @@
+package runbox
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (app *App) executeFunction(c *gin.Context) {
+	path := c.Param("path")
+
+	routeStarted := time.Now()
+	function, subpath, db, err := app.resolveFunctionTimed(c.Request.Context(), path)
+	route := time.Since(routeStarted) - db
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Function not found"})
+		return
+	}
+	if !app.checkAccess(c, function) {
+		return
+	}
+	if !app.checkQuota(c, function) {
+		return
+	}
+	defer func() {
+		if n := c.Writer.Size(); n > 0 {
+			app.metrics.addEgress(function.ID, int64(n))
+			app.statsd.addEgress(function.ID, int64(n))
+		}
+	}()
+
+	if m := app.servedMaterialization(c, function, subpath); m != nil {
+		writeMaterialization(c, m)
+		return
+	}
+
+	requestData := buildRequestData(c)
+	requestData["subpath"] = subpath
+	schema := app.protoSchemaFor(function.ID)
+	if schema != nil && isProtobuf(c.ContentType()) {
+		if err := schema.decodeRequest(requestData); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	live := app.live()
+	timeout := live.executionTimeout()
+	ctx, cancel := live.executionContext(c.Request.Context())
+	defer cancel()
+	exec := newExecution(ctx, function, requestData)
+	exec.timings.RouteMs, exec.timings.DBMs = millis(route), millis(db)
+	exec.holdLog = true
+	// Picking a slot bypasses the canary split, so it takes a token; other
+	// callers get the live slot.
+	if slot := c.GetHeader("X-Runbox-Slot"); slot != "" && app.takeBearerToken(c) {
+		exec.slot = slot
+	}
+	defer app.releaseExecutionLog(exec)
+	result, err := app.executeJavaScript(exec)
+	if exec.slot != "" {
+		c.Header("X-Runbox-Slot", exec.slot)
+	}
+
+	_, span := app.tracer.Start(c.Request.Context(), "runbox.export")
+	defer span.End()
+
+	serializeStarted := time.Now()
+	status, contentType := http.StatusOK, "application/json; charset=utf-8"
+	var body []byte
+	switch resp, ok := result.(*HTTPResponse); {
+	case err == errExecutionCancelled && ctx.Err() == context.DeadlineExceeded:
+		status = http.StatusGatewayTimeout
+		body, _ = json.Marshal(gin.H{
+			"error":    "Function execution timed out after " + timeout.String(),
+			"function": function.Name,
+		})
+	case err != nil:
+		status = http.StatusInternalServerError
+		body, _ = json.Marshal(gin.H{
+			"error":    "Function execution failed",
+			"details":  err.Error(),
+			"function": function.Name,
+		})
+	case ok:
+		for name, value := range resp.Headers {
+			c.Header(name, value)
+		}
+		status, contentType, body = resp.Status, resp.ContentType(), []byte(resp.Body)
+	case schema != nil && schema.response != nil && wantsProtobuf(c):
+		contentType = protobufContentType
+		if body, err = schema.encodeResult(result); err != nil {
+			status, contentType = http.StatusInternalServerError, "application/json; charset=utf-8"
+			body, _ = json.Marshal(gin.H{"error": "Failed to encode result", "details": err.Error()})
+		}
+	default:
+		if body, err = json.Marshal(result); err != nil {
+			status = http.StatusInternalServerError
+			body, _ = json.Marshal(gin.H{"error": "Failed to serialize result", "details": err.Error()})
+		}
+	}
+	exec.timings.SerializeMs = millis(time.Since(serializeStarted))
+	exec.timings.TotalMs = millis(time.Since(routeStarted))
+
+	c.Writer.Header().Add("Server-Timing", exec.timings.serverTiming())
+	c.Data(status, contentType, body)
+}
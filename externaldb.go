@@ -0,0 +1,192 @@
+package runbox
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/robertkrimen/otto"
+)
+
+const (
+	defaultExternalDBTimeout = 10 * time.Second
+	maxExternalDBRows        = 10000
+)
+
+// externalDBDrivers maps the configured driver to the database/sql one.
+var externalDBDrivers = map[string]string{
+	"postgres": "pgx",
+	"mysql":    "mysql",
+}
+
+// externalDB is a pool for one named entry of externalDatabases.
+type externalDB struct {
+	config ExternalDatabaseConfig
+	db     *sql.DB
+}
+
+// openExternalDBs opens a pool for every configured database; connections
+// are made on first use.
+func openExternalDBs(configs ExternalDatabases) (map[string]*externalDB, error) {
+	dbs := map[string]*externalDB{}
+	for name, config := range configs {
+		driver, ok := externalDBDrivers[config.Driver]
+		if !ok {
+			closeExternalDBs(dbs)
+			return nil, fmt.Errorf("externalDatabases.%s: driver must be postgres or mysql", name)
+		}
+		if config.DSN == "" {
+			closeExternalDBs(dbs)
+			return nil, fmt.Errorf("externalDatabases.%s: dsn is required", name)
+		}
+		db, err := sql.Open(driver, config.DSN)
+		if err != nil {
+			closeExternalDBs(dbs)
+			return nil, fmt.Errorf("externalDatabases.%s: %v", name, err)
+		}
+		if config.MaxOpenConns > 0 {
+			db.SetMaxOpenConns(config.MaxOpenConns)
+		}
+		if config.MaxIdleConns > 0 {
+			db.SetMaxIdleConns(config.MaxIdleConns)
+		}
+		if config.ConnMaxLifetimeSeconds > 0 {
+			db.SetConnMaxLifetime(time.Duration(config.ConnMaxLifetimeSeconds) * time.Second)
+		}
+		dbs[name] = &externalDB{config: config, db: db}
+	}
+	return dbs, nil
+}
+
+func closeExternalDBs(dbs map[string]*externalDB) {
+	for _, d := range dbs {
+		d.db.Close()
+	}
+}
+
+func (d *externalDB) timeout() time.Duration {
+	if d.config.TimeoutSeconds > 0 {
+		return time.Duration(d.config.TimeoutSeconds) * time.Second
+	}
+	return defaultExternalDBTimeout
+}
+
+// query runs a statement that answers rows, as one object per row keyed
+// by column name.
+func (d *externalDB) query(ctx context.Context, query string, params []interface{}) ([]map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout())
+	defer cancel()
+	rows, err := d.db.QueryContext(ctx, query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	result := []map[string]interface{}{}
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for rows.Next() {
+		if len(result) == maxExternalDBRows {
+			return nil, fmt.Errorf("more than %d rows; add a LIMIT", maxExternalDBRows)
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			switch v := values[i].(type) {
+			case []byte:
+				row[column] = string(v)
+			case time.Time:
+				row[column] = v.UTC().Format(time.RFC3339Nano)
+			default:
+				row[column] = v
+			}
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// exec runs a statement that doesn't answer rows.
+func (d *externalDB) exec(ctx context.Context, query string, params []interface{}) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout())
+	defer cancel()
+	result, err := d.db.ExecContext(ctx, query, params...)
+	if err != nil {
+		return nil, err
+	}
+	affected, _ := result.RowsAffected()
+	out := map[string]interface{}{"rowsAffected": affected}
+	// Postgres has no last insert ID; use RETURNING with query instead.
+	if id, err := result.LastInsertId(); err == nil {
+		out["lastInsertId"] = id
+	}
+	return out, nil
+}
+
+// jsExternalDB backs runbox.db(name), the external databases granted to
+// the running function. Statements take their parameters as an array,
+// with the driver's placeholders: $1, $2 for Postgres and ? for MySQL.
+func (app *App) jsExternalDB(exec *execution) func(otto.FunctionCall) otto.Value {
+	return func(call otto.FunctionCall) otto.Value {
+		name := call.Argument(0).String()
+		d, ok := app.externalDBs[name]
+		if !ok {
+			throwError(call, "runbox.db: no external database named "+name)
+		}
+		if !pathAllowed(d.config.Functions, exec.function.Path) {
+			throwError(call, "runbox.db: "+name+" is not granted to "+exec.function.Path)
+		}
+
+		statement := func(call otto.FunctionCall, method string) (string, []interface{}) {
+			if !call.Argument(0).IsString() {
+				throwError(call, "runbox.db: "+name+"."+method+": sql must be a string")
+			}
+			var params []interface{}
+			if arg := call.Argument(1); arg.IsDefined() && !arg.IsNull() {
+				// Export answers typed slices, such as []string, when every
+				// element has the same type.
+				exported, _ := arg.Export()
+				list := reflect.ValueOf(exported)
+				if list.Kind() != reflect.Slice {
+					throwError(call, "runbox.db: "+name+"."+method+": params must be an array")
+				}
+				for i := 0; i < list.Len(); i++ {
+					params = append(params, list.Index(i).Interface())
+				}
+			}
+			return call.Argument(0).String(), params
+		}
+
+		db, _ := call.Otto.Object(`({})`)
+		db.Set("query", func(call otto.FunctionCall) otto.Value {
+			query, params := statement(call, "query")
+			rows, err := d.query(exec.ctx, query, params)
+			if err != nil {
+				throwError(call, "runbox.db: "+name+".query: "+err.Error())
+			}
+			return toValue(call, rows)
+		})
+		db.Set("exec", func(call otto.FunctionCall) otto.Value {
+			query, params := statement(call, "exec")
+			result, err := d.exec(exec.ctx, query, params)
+			if err != nil {
+				throwError(call, "runbox.db: "+name+".exec: "+err.Error())
+			}
+			return toValue(call, result)
+		})
+		return db.Value()
+	}
+}
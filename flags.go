@@ -0,0 +1,328 @@
+package runbox
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"net/http"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/robertkrimen/otto"
+)
+
+// Feature flag types.
+const (
+	FlagBoolean    = "boolean"
+	FlagPercentage = "percentage"
+	FlagTargeted   = "targeted"
+)
+
+var flagNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,127}$`)
+
+// flagKeyAttributes are the context attributes, in order, whose value
+// places a caller in a percentage rollout, so the same user keeps getting
+// the same answer.
+var flagKeyAttributes = []string{"key", "userId", "id"}
+
+// Flag is a feature flag that function code reads with
+// runbox.flags.isEnabled. A disabled flag is off for everyone; an enabled
+// boolean flag is on for everyone, a percentage flag for Percentage of
+// callers, and a targeted flag for callers whose context matches one of
+// Targets, plus Percentage of the rest.
+type Flag struct {
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	Type        string       `json:"type"`
+	Enabled     bool         `json:"enabled"`
+	Percentage  int          `json:"percentage"`
+	Targets     []FlagTarget `json:"targets"`
+	CreatedAt   time.Time    `json:"createdAt"`
+	UpdatedAt   time.Time    `json:"updatedAt"`
+}
+
+// FlagTarget matches callers whose context Attribute is one of Values.
+type FlagTarget struct {
+	Attribute string   `json:"attribute"`
+	Values    []string `json:"values"`
+}
+
+func (app *App) initFlagsTable() error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS feature_flags (
+		name TEXT PRIMARY KEY,
+		description TEXT NOT NULL DEFAULT '',
+		type TEXT NOT NULL,
+		enabled INTEGER NOT NULL DEFAULT 0,
+		percentage INTEGER NOT NULL DEFAULT 0,
+		targets TEXT NOT NULL DEFAULT '[]',
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	);`
+
+	if _, err := app.db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create feature_flags table: %v", err)
+	}
+	return nil
+}
+
+const flagColumns = `name, description, type, enabled, percentage, targets, created_at, updated_at`
+
+func scanFlag(row rowScanner) (*Flag, error) {
+	var f Flag
+	var targets string
+	if err := row.Scan(&f.Name, &f.Description, &f.Type, &f.Enabled, &f.Percentage, &targets, &f.CreatedAt, &f.UpdatedAt); err != nil {
+		return nil, err
+	}
+	json.Unmarshal([]byte(targets), &f.Targets)
+	if f.Targets == nil {
+		f.Targets = []FlagTarget{}
+	}
+	return &f, nil
+}
+
+func (app *App) getFlag(name string) (*Flag, error) {
+	return scanFlag(app.db.QueryRow(`SELECT `+flagColumns+` FROM feature_flags WHERE name = ?`, name))
+}
+
+func (app *App) listFlags() ([]Flag, error) {
+	rows, err := app.db.Query(`SELECT ` + flagColumns + ` FROM feature_flags ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	flags := []Flag{}
+	for rows.Next() {
+		f, err := scanFlag(rows)
+		if err != nil {
+			return nil, err
+		}
+		flags = append(flags, *f)
+	}
+	return flags, rows.Err()
+}
+
+func (f *Flag) validate() error {
+	if !flagNamePattern.MatchString(f.Name) {
+		return fmt.Errorf("name must start with a letter or digit and contain only letters, digits, '_', '.' and '-', up to 128 characters")
+	}
+	switch f.Type {
+	case "":
+		f.Type = FlagBoolean
+	case FlagBoolean, FlagPercentage, FlagTargeted:
+	default:
+		return fmt.Errorf("type must be %s, %s or %s", FlagBoolean, FlagPercentage, FlagTargeted)
+	}
+	if f.Percentage < 0 || f.Percentage > 100 {
+		return fmt.Errorf("percentage must be between 0 and 100")
+	}
+	if f.Targets == nil {
+		f.Targets = []FlagTarget{}
+	}
+	for _, t := range f.Targets {
+		if t.Attribute == "" || len(t.Values) == 0 {
+			return fmt.Errorf("every target needs an attribute and at least one value")
+		}
+	}
+	if f.Type == FlagTargeted && len(f.Targets) == 0 {
+		return fmt.Errorf("a targeted flag needs at least one target")
+	}
+	return nil
+}
+
+// rollout places the context in the flag's percentage rollout. Callers
+// with a key land in the same bucket every time; without one each call
+// is a fresh draw.
+func (f *Flag) rollout(context map[string]interface{}) bool {
+	if f.Percentage <= 0 {
+		return false
+	}
+	for _, attribute := range flagKeyAttributes {
+		if key, ok := context[attribute]; ok && key != nil {
+			h := fnv.New32a()
+			h.Write([]byte(f.Name + ":" + fmt.Sprint(key)))
+			return int(h.Sum32()%100) < f.Percentage
+		}
+	}
+	return rand.IntN(100) < f.Percentage
+}
+
+// isEnabled evaluates the flag for a caller's context.
+func (f *Flag) isEnabled(context map[string]interface{}) bool {
+	if !f.Enabled {
+		return false
+	}
+	switch f.Type {
+	case FlagPercentage:
+		return f.rollout(context)
+	case FlagTargeted:
+		for _, t := range f.Targets {
+			if value, ok := context[t.Attribute]; ok && value != nil && slices.Contains(t.Values, fmt.Sprint(value)) {
+				return true
+			}
+		}
+		return f.rollout(context)
+	}
+	return true
+}
+
+// flagEnabled evaluates the named flag; a flag that doesn't exist is off.
+func (app *App) flagEnabled(name string, context map[string]interface{}) (bool, error) {
+	f, err := app.getFlag(name)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return f.isEnabled(context), nil
+}
+
+// jsFlags backs runbox.flags.
+func (app *App) jsFlags(vm *otto.Otto) *otto.Object {
+	flags, _ := vm.Object(`({})`)
+	flags.Set("isEnabled", func(call otto.FunctionCall) otto.Value {
+		name := call.Argument(0).String()
+		context := map[string]interface{}{}
+		if arg := call.Argument(1); arg.IsObject() {
+			exported, _ := arg.Export()
+			if m, ok := exported.(map[string]interface{}); ok {
+				context = m
+			}
+		}
+		enabled, err := app.flagEnabled(name, context)
+		if err != nil {
+			throwError(call, "runbox.flags.isEnabled: "+name+": "+err.Error())
+		}
+		return toValue(call, enabled)
+	})
+	return flags
+}
+
+// saveFlag writes f, creating it or replacing the one with its name.
+func (app *App) saveFlag(f *Flag, create bool) error {
+	targets, _ := json.Marshal(f.Targets)
+	now := time.Now().UTC()
+	f.UpdatedAt = now
+	if create {
+		f.CreatedAt = now
+		_, err := app.db.Exec(`INSERT INTO feature_flags (`+flagColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			f.Name, f.Description, f.Type, f.Enabled, f.Percentage, string(targets), now, now)
+		return err
+	}
+	result, err := app.db.Exec(`UPDATE feature_flags SET description = ?, type = ?, enabled = ?, percentage = ?, targets = ?, updated_at = ?
+		WHERE name = ?`, f.Description, f.Type, f.Enabled, f.Percentage, string(targets), now, f.Name)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (app *App) listFlagsHandler(c *gin.Context) {
+	flags, err := app.listFlags()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list flags"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"flags": flags})
+}
+
+func (app *App) getFlagHandler(c *gin.Context) {
+	f, err := app.getFlag(c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Flag not found"})
+		return
+	}
+	c.JSON(http.StatusOK, f)
+}
+
+// createFlag serves POST /api/flags.
+func (app *App) createFlag(c *gin.Context) {
+	var f Flag
+	if err := c.ShouldBindJSON(&f); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid flag body"})
+		return
+	}
+	if err := f.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := app.getFlag(f.Name); err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Flag " + f.Name + " already exists"})
+		return
+	}
+	if err := app.saveFlag(&f, true); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save flag: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, f)
+}
+
+// updateFlag serves PUT /api/flags/:name, replacing the flag's settings;
+// functions see them from their next call.
+func (app *App) updateFlag(c *gin.Context) {
+	existing, err := app.getFlag(c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Flag not found"})
+		return
+	}
+	var f Flag
+	if err := c.ShouldBindJSON(&f); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid flag body"})
+		return
+	}
+	f.Name, f.CreatedAt = existing.Name, existing.CreatedAt
+	if err := f.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := app.saveFlag(&f, false); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save flag: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, f)
+}
+
+func (app *App) deleteFlag(c *gin.Context) {
+	result, err := app.db.Exec(`DELETE FROM feature_flags WHERE name = ?`, c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete flag"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Flag not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deleted": true})
+}
+
+// evaluateFlag serves POST /api/flags/:name/evaluate with {context}, the
+// answer runbox.flags.isEnabled would give for it.
+func (app *App) evaluateFlag(c *gin.Context) {
+	f, err := app.getFlag(c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Flag not found"})
+		return
+	}
+	var in struct {
+		Context map[string]interface{} `json:"context"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid context"})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"name": f.Name, "enabled": f.isEnabled(in.Context)})
+}
+
+func (app *App) flagsPage(c *gin.Context) {
+	c.HTML(http.StatusOK, "flags.html", gin.H{"title": "Feature Flags"})
+}
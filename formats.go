@@ -0,0 +1,502 @@
+package runbox
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/robertkrimen/otto"
+	"gopkg.in/yaml.v3"
+)
+
+const maxParseInputBytes = 10 << 20
+
+var xmlNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.:-]*$`)
+
+// parseCSV reads CSV text. With header, the first record names the
+// columns and each record after it becomes an object; every record must
+// then have as many fields as the header.
+func parseCSV(text string, delimiter rune, header bool) (interface{}, error) {
+	r := csv.NewReader(strings.NewReader(strings.TrimPrefix(text, "\ufeff")))
+	r.Comma = delimiter
+	if !header {
+		r.FieldsPerRecord = -1
+	}
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if !header {
+		rows := make([]interface{}, len(records))
+		for i, record := range records {
+			rows[i] = record
+		}
+		return rows, nil
+	}
+	rows := []interface{}{}
+	for i, record := range records {
+		if i == 0 {
+			continue
+		}
+		row := make(map[string]interface{}, len(record))
+		for j, column := range records[0] {
+			row[column] = record[j]
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// parseYAML reads the first document of YAML text into JSON-like values.
+func parseYAML(text string) (interface{}, error) {
+	var value interface{}
+	if err := yaml.Unmarshal([]byte(text), &value); err != nil {
+		return nil, err
+	}
+	return jsonLike(value), nil
+}
+
+// jsonLike converts what yaml.v3 decodes into values JavaScript can take:
+// maps keyed by strings and timestamps as RFC 3339 strings.
+func jsonLike(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = jsonLike(item)
+		}
+		return v
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[fmt.Sprint(key)] = jsonLike(item)
+		}
+		return m
+	case []interface{}:
+		for i, item := range v {
+			v[i] = jsonLike(item)
+		}
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	}
+	return value
+}
+
+// xmlElement is an element being read by parseXML.
+type xmlElement struct {
+	name     string
+	attrs    []xml.Attr
+	children []*xmlElement
+	text     strings.Builder
+}
+
+// value converts the element: an element with only text becomes the
+// text; any other becomes an object of its attributes as "@name", its
+// child elements by name, as an array when a name repeats, and its text as
+// "#text".
+func (e *xmlElement) value() interface{} {
+	text := strings.TrimSpace(e.text.String())
+	if len(e.attrs) == 0 && len(e.children) == 0 {
+		return text
+	}
+	obj := map[string]interface{}{}
+	for _, attr := range e.attrs {
+		obj["@"+attr.Name.Local] = attr.Value
+	}
+	for _, child := range e.children {
+		value := child.value()
+		switch existing := obj[child.name].(type) {
+		case nil:
+			obj[child.name] = value
+		case []interface{}:
+			obj[child.name] = append(existing, value)
+		default:
+			obj[child.name] = []interface{}{existing, value}
+		}
+	}
+	if text != "" {
+		obj["#text"] = text
+	}
+	return obj
+}
+
+// parseXML reads an XML document into {rootName: value}, with elements
+// converted as xmlElement.value describes. Namespace prefixes and
+// declarations are dropped.
+func parseXML(text string) (interface{}, error) {
+	d := xml.NewDecoder(strings.NewReader(text))
+	// The text is a JavaScript string, decoded already whatever encoding
+	// the declaration names.
+	d.CharsetReader = func(_ string, r io.Reader) (io.Reader, error) { return r, nil }
+	var root *xmlElement
+	var stack []*xmlElement
+	for {
+		token, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			e := &xmlElement{name: t.Name.Local}
+			for _, attr := range t.Attr {
+				if attr.Name.Space != "xmlns" && attr.Name.Local != "xmlns" {
+					e.attrs = append(e.attrs, attr)
+				}
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, e)
+			} else if root == nil {
+				root = e
+			}
+			stack = append(stack, e)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text.Write(t)
+			}
+		}
+	}
+	if root == nil {
+		return nil, errors.New("no root element")
+	}
+	return map[string]interface{}{root.name: root.value()}, nil
+}
+
+func isArray(v otto.Value) bool {
+	switch v.Class() {
+	case "Array", "GoArray", "GoSlice":
+		return true
+	}
+	return false
+}
+
+// elements answers the items of a JavaScript array.
+func elements(v otto.Value) []otto.Value {
+	obj := v.Object()
+	length, _ := obj.Get("length")
+	n, _ := length.ToInteger()
+	items := make([]otto.Value, n)
+	for i := range items {
+		items[i], _ = obj.Get(strconv.Itoa(i))
+	}
+	return items
+}
+
+// scalarString formats the value of a CSV cell, an XML text or attribute:
+// objects as JSON, null and undefined as nothing.
+func scalarString(v otto.Value) (string, error) {
+	switch {
+	case v.IsUndefined() || v.IsNull():
+		return "", nil
+	case v.IsObject() && v.Class() != "Date":
+		exported, _ := v.Export()
+		encoded, err := json.Marshal(exported)
+		if err != nil {
+			return "", errors.New("values must be JSON-serializable")
+		}
+		return string(encoded), nil
+	}
+	return v.String(), nil
+}
+
+// stringifyCSV writes an array of arrays as it is, or an array of objects
+// with a header row of columns, by default their keys in order of first
+// appearance.
+func stringifyCSV(rows otto.Value, delimiter rune, columns []string, header bool) (string, error) {
+	if !isArray(rows) {
+		return "", errors.New("rows must be an array")
+	}
+	items := elements(rows)
+	var out bytes.Buffer
+	w := csv.NewWriter(&out)
+	w.Comma = delimiter
+
+	objects := len(items) > 0 && items[0].IsObject() && !isArray(items[0])
+	if objects && columns == nil {
+		seen := map[string]bool{}
+		for _, item := range items {
+			if !item.IsObject() {
+				continue
+			}
+			for _, key := range item.Object().Keys() {
+				if !seen[key] {
+					seen[key] = true
+					columns = append(columns, key)
+				}
+			}
+		}
+	}
+	if objects && header {
+		w.Write(columns)
+	}
+	for i, item := range items {
+		var cells []otto.Value
+		switch {
+		case objects && item.IsObject() && !isArray(item):
+			for _, column := range columns {
+				cell, _ := item.Object().Get(column)
+				cells = append(cells, cell)
+			}
+		case !objects && isArray(item):
+			cells = elements(item)
+		case objects:
+			return "", fmt.Errorf("row %d must be an object like the first", i)
+		default:
+			return "", fmt.Errorf("row %d must be an array like the first", i)
+		}
+		record := make([]string, len(cells))
+		for j, cell := range cells {
+			s, err := scalarString(cell)
+			if err != nil {
+				return "", err
+			}
+			record[j] = s
+		}
+		w.Write(record)
+	}
+	w.Flush()
+	return out.String(), w.Error()
+}
+
+// yamlNode builds the YAML for a JavaScript value, keeping the order of
+// object keys. Functions and undefined values are left out, as in JSON.
+func yamlNode(v otto.Value) (*yaml.Node, error) {
+	switch {
+	case isArray(v):
+		n := &yaml.Node{Kind: yaml.SequenceNode}
+		for _, item := range elements(v) {
+			child, err := yamlNode(item)
+			if err != nil {
+				return nil, err
+			}
+			if child == nil {
+				child = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}
+			}
+			n.Content = append(n.Content, child)
+		}
+		return n, nil
+	case v.IsFunction() || v.IsUndefined():
+		return nil, nil
+	case v.IsObject() && v.Class() != "Date":
+		n := &yaml.Node{Kind: yaml.MappingNode}
+		obj := v.Object()
+		for _, key := range obj.Keys() {
+			item, _ := obj.Get(key)
+			child, err := yamlNode(item)
+			if err != nil {
+				return nil, err
+			}
+			if child != nil {
+				n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, child)
+			}
+		}
+		return n, nil
+	}
+	exported, _ := v.Export()
+	n := &yaml.Node{}
+	if err := n.Encode(exported); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func stringifyYAML(v otto.Value) (string, error) {
+	n, err := yamlNode(v)
+	if err != nil {
+		return "", err
+	}
+	if n == nil {
+		return "", errors.New("value must not be undefined")
+	}
+	var out bytes.Buffer
+	e := yaml.NewEncoder(&out)
+	e.SetIndent(2)
+	if err := e.Encode(n); err != nil {
+		return "", err
+	}
+	e.Close()
+	return out.String(), nil
+}
+
+// stringifyXML writes {rootName: value}, the form parseXML answers, as an
+// XML document. Arrays repeat their element.
+func stringifyXML(v otto.Value) (string, error) {
+	if !v.IsObject() || isArray(v) || len(v.Object().Keys()) != 1 {
+		return "", errors.New("expected an object with one key, the root element")
+	}
+	var out bytes.Buffer
+	out.WriteString(xml.Header)
+	e := xml.NewEncoder(&out)
+	name := v.Object().Keys()[0]
+	root, _ := v.Object().Get(name)
+	if isArray(root) {
+		return "", errors.New("the root element can't be an array")
+	}
+	if err := encodeXMLElement(e, name, root); err != nil {
+		return "", err
+	}
+	if err := e.Flush(); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+func encodeXMLElement(e *xml.Encoder, name string, v otto.Value) error {
+	if !xmlNamePattern.MatchString(name) {
+		return fmt.Errorf("%q is not a valid element name", name)
+	}
+	if isArray(v) {
+		for _, item := range elements(v) {
+			if err := encodeXMLElement(e, name, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if v.IsFunction() || v.IsUndefined() {
+		return nil
+	}
+
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	var text string
+	var children []string
+	if v.IsObject() && v.Class() != "Date" {
+		obj := v.Object()
+		for _, key := range obj.Keys() {
+			item, _ := obj.Get(key)
+			switch {
+			case key == "#text":
+				text, _ = scalarString(item)
+			case strings.HasPrefix(key, "@"):
+				if !xmlNamePattern.MatchString(key[1:]) {
+					return fmt.Errorf("%q is not a valid attribute name", key[1:])
+				}
+				value, err := scalarString(item)
+				if err != nil {
+					return err
+				}
+				start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: key[1:]}, Value: value})
+			default:
+				children = append(children, key)
+			}
+		}
+	} else {
+		var err error
+		if text, err = scalarString(v); err != nil {
+			return err
+		}
+	}
+
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	if text != "" {
+		if !utf8.ValidString(text) {
+			return errors.New("text must be valid UTF-8")
+		}
+		if err := e.EncodeToken(xml.CharData(text)); err != nil {
+			return err
+		}
+	}
+	for _, key := range children {
+		item, _ := v.Object().Get(key)
+		if err := encodeXMLElement(e, key, item); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// formatOptions reads the delimiter, header and columns options of the
+// CSV helpers.
+func formatOptions(call otto.FunctionCall, prefix string, arg otto.Value) (delimiter rune, header bool, columns []string) {
+	delimiter, header = ',', true
+	if !arg.IsObject() {
+		return
+	}
+	opts := arg.Object()
+	if v, _ := opts.Get("delimiter"); v.IsDefined() {
+		if utf8.RuneCountInString(v.String()) != 1 || v.String() == "\"" || v.String() == "\n" {
+			throwError(call, prefix+"delimiter must be one character other than a quote or newline")
+		}
+		delimiter, _ = utf8.DecodeRuneInString(v.String())
+	}
+	if v, _ := opts.Get("header"); v.IsBoolean() {
+		header, _ = v.ToBoolean()
+	}
+	if v, _ := opts.Get("columns"); v.IsDefined() {
+		if !isArray(v) {
+			throwError(call, prefix+"columns must be an array of names")
+		}
+		columns = []string{}
+		for _, column := range elements(v) {
+			columns = append(columns, column.String())
+		}
+	}
+	return
+}
+
+// jsParse exposes runbox.parse, which reads the formats webhooks and
+// older systems send instead of JSON.
+func jsParse(vm *otto.Otto) *otto.Object {
+	parse, _ := vm.Object(`({})`)
+	reader := func(format string, read func(call otto.FunctionCall, text string) (interface{}, error)) {
+		prefix := "runbox.parse." + format + ": "
+		parse.Set(format, func(call otto.FunctionCall) otto.Value {
+			if !call.Argument(0).IsString() {
+				throwError(call, prefix+"expected a string")
+			}
+			text := call.Argument(0).String()
+			if len(text) > maxParseInputBytes {
+				throwError(call, prefix+"input is larger than 10 MiB")
+			}
+			value, err := read(call, text)
+			if err != nil {
+				throwError(call, prefix+err.Error())
+			}
+			return toValue(call, value)
+		})
+	}
+	reader("csv", func(call otto.FunctionCall, text string) (interface{}, error) {
+		delimiter, header, _ := formatOptions(call, "runbox.parse.csv: ", call.Argument(1))
+		return parseCSV(text, delimiter, header)
+	})
+	reader("xml", func(_ otto.FunctionCall, text string) (interface{}, error) { return parseXML(text) })
+	reader("yaml", func(_ otto.FunctionCall, text string) (interface{}, error) { return parseYAML(text) })
+	return parse
+}
+
+// jsStringify exposes runbox.stringify, the reverse of runbox.parse.
+func jsStringify(vm *otto.Otto) *otto.Object {
+	stringify, _ := vm.Object(`({})`)
+	writer := func(format string, write func(call otto.FunctionCall) (string, error)) {
+		stringify.Set(format, func(call otto.FunctionCall) otto.Value {
+			out, err := write(call)
+			if err != nil {
+				throwError(call, "runbox.stringify."+format+": "+err.Error())
+			}
+			return toValue(call, out)
+		})
+	}
+	writer("csv", func(call otto.FunctionCall) (string, error) {
+		delimiter, header, columns := formatOptions(call, "runbox.stringify.csv: ", call.Argument(1))
+		return stringifyCSV(call.Argument(0), delimiter, columns, header)
+	})
+	writer("xml", func(call otto.FunctionCall) (string, error) { return stringifyXML(call.Argument(0)) })
+	writer("yaml", func(call otto.FunctionCall) (string, error) { return stringifyYAML(call.Argument(0)) })
+	return stringify
+}
@@ -0,0 +1,387 @@
+package runbox
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prodemmi/runbox/store"
+)
+
+// Function is a function as the store keeps it.
+type Function = store.Function
+
+// FunctionVersion is a saved version of a function.
+type FunctionVersion = store.FunctionVersion
+
+// FunctionFile is a module stored with a function next to its entry point
+// and loaded with require("./name").
+type FunctionFile = store.FunctionFile
+
+type rowScanner = store.RowScanner
+
+func encodeFiles(files []FunctionFile) string {
+	return store.EncodeFiles(files)
+}
+
+func decodeFiles(data string) []FunctionFile {
+	return store.DecodeFiles(data)
+}
+
+func (app *App) homePage(c *gin.Context) {
+	functions, err := app.getAllFunctions()
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": err.Error()})
+		return
+	}
+
+	ids := make([]int, len(functions))
+	for i, f := range functions {
+		ids[i] = f.ID
+	}
+	metrics := map[int]gin.H{}
+	if stats, err := app.functionMetrics(ids, time.Now().UTC().Add(-24*time.Hour)); err == nil {
+		for id, s := range stats {
+			summary := s.summary()
+			summary["errorPercent"] = summary["errorRate"].(float64) * 100
+			metrics[id] = summary
+		}
+	}
+	updated, _ := app.functionsUpdatedAt()
+
+	listings := make([]functionListing, len(functions))
+	tags := map[string]bool{}
+	for i := range functions {
+		f := &functions[i]
+		listings[i] = functionListing{Function: *f, Methods: functionMethods(f), UpdatedAt: updated[f.ID], Metrics: metrics[f.ID],
+			Private: app.visibilityOf(f) == VisibilityPrivate}
+		if m := metrics[f.ID]; m != nil {
+			listings[i].ErrorRate = m["errorRate"].(float64)
+		}
+		for _, tag := range f.Tags {
+			tags[tag] = true
+		}
+	}
+	allTags := make([]string, 0, len(tags))
+	for tag := range tags {
+		allTags = append(allTags, tag)
+	}
+	sort.Strings(allTags)
+
+	filter := functionFilter{
+		Query:  strings.TrimSpace(c.Query("q")),
+		Tag:    c.Query("tag"),
+		Method: strings.ToUpper(c.Query("method")),
+		Sort:   c.DefaultQuery("sort", "name"),
+		Desc:   c.Query("order") == "desc",
+	}
+
+	shown := filter.apply(listings)
+
+	c.HTML(http.StatusOK, "index.html", gin.H{
+		"title":       "RunBox - Function Executor",
+		"total":       len(functions),
+		"shown":       shown,
+		"functions":   listings,
+		"filter":      filter,
+		"tags":        allTags,
+		"methods":     httpMethods,
+		"readOnly":    app.config.ReadOnly,
+		"maintenance": app.currentMaintenance().Enabled,
+	})
+}
+
+func (app *App) newFunctionPage(c *gin.Context) {
+	function := Function{}
+	// ?template=id starts the form from a template.
+	if t, err := app.getFunctionTemplate(c.Query("template")); err == nil {
+		function = Function{Name: t.Name, Path: t.Path, Code: t.Code, Description: t.Description, Mode: t.Mode}
+	}
+	// ?path= prefills the path, as suggested by a curl import.
+	if path := c.Query("path"); path != "" {
+		function.Path = path
+	}
+	templates, _ := app.functionTemplates()
+
+	c.HTML(http.StatusOK, "function_form.html", gin.H{
+		"title":      "Create New Function",
+		"function":   function,
+		"action":     "/api/functions",
+		"method":     "POST",
+		"modes":      functionModes,
+		"templates":  templates,
+		"templateID": c.Query("template"),
+
+		"defaultVisibility": app.defaultVisibility(),
+	})
+}
+
+func (app *App) createFunction(c *gin.Context) {
+	var function Function
+
+	function.Name = c.PostForm("name")
+	function.Path = c.PostForm("path")
+	function.Code = c.PostForm("code")
+	function.Description = c.PostForm("description")
+	function.Mode = c.DefaultPostForm("mode", ModeStandard)
+	function.SourceURL = c.PostForm("source_url")
+	function.Tags = parseTags(c.PostForm("tags"))
+	function.Visibility = c.PostForm("visibility")
+	files, filesErr := parseFiles(c.PostForm("files"))
+	function.Files = files
+
+	if function.Name == "" || function.Path == "" || function.Code == "" || !validMode(function.Mode) || !validVisibility(function.Visibility) {
+		c.HTML(http.StatusBadRequest, "function_form.html", gin.H{
+			"title":    "Create New Function",
+			"function": function,
+			"action":   "/api/functions",
+			"method":   "POST",
+			"modes":    functionModes,
+			"error":    "Name, Path, and Code are required fields and Mode and Visibility must be valid",
+		})
+		return
+	}
+	if diagnostics := validateFunctionCode(function.Code, function.Mode); diagnostics != nil {
+		c.HTML(http.StatusBadRequest, "function_form.html", gin.H{
+			"title":       "Create New Function",
+			"function":    function,
+			"action":      "/api/functions",
+			"method":      "POST",
+			"modes":       functionModes,
+			"error":       "Syntax error at " + diagnostics[0].String(),
+			"diagnostics": diagnostics,
+		})
+		return
+	}
+	if filesErr == nil {
+		filesErr = validateFunctionFiles(function.Files)
+	}
+	if filesErr != nil {
+		c.HTML(http.StatusBadRequest, "function_form.html", gin.H{
+			"title":    "Create New Function",
+			"function": function,
+			"action":   "/api/functions",
+			"method":   "POST",
+			"modes":    functionModes,
+			"error":    filesErr.Error(),
+		})
+		return
+	}
+
+	if !strings.HasPrefix(function.Path, "/") {
+		function.Path = "/" + function.Path
+	}
+
+	err := app.insertFunction(&function)
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "function_form.html", gin.H{
+			"title":    "Create New Function",
+			"function": function,
+			"action":   "/api/functions",
+			"method":   "POST",
+			"modes":    functionModes,
+			"error":    "Failed to create function: " + err.Error(),
+		})
+		return
+	}
+
+	app.recordAudit(app.auditOrigin(c), AuditFunctionCreated, nil, &function)
+	c.Redirect(http.StatusFound, "/")
+}
+
+func (app *App) editFunctionPage(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "Invalid function ID"})
+		return
+	}
+
+	function, err := app.getFunctionByID(id)
+	if err != nil {
+		c.HTML(http.StatusNotFound, "error.html", gin.H{"error": "Function not found"})
+		return
+	}
+
+	c.HTML(http.StatusOK, "function_form.html", gin.H{
+		"title":    "Edit Function",
+		"function": function,
+		"action":   "/api/functions/" + strconv.Itoa(id),
+		"method":   "PUT",
+		"modes":    functionModes,
+		"legend":   ExecutionTimings{}.phases(),
+
+		"defaultVisibility": app.defaultVisibility(),
+	})
+}
+
+func (app *App) updateFunction(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+
+	var function Function
+	function.ID = id
+	function.Name = c.PostForm("name")
+	function.Path = c.PostForm("path")
+	function.Code = c.PostForm("code")
+	function.Description = c.PostForm("description")
+	function.Mode = c.DefaultPostForm("mode", ModeStandard)
+	function.Tags = parseTags(c.PostForm("tags"))
+	function.Visibility = c.PostForm("visibility")
+	files, filesErr := parseFiles(c.PostForm("files"))
+	function.Files = files
+
+	if function.Name == "" || function.Path == "" || function.Code == "" || !validMode(function.Mode) || !validVisibility(function.Visibility) {
+		c.HTML(http.StatusBadRequest, "function_form.html", gin.H{
+			"title":    "Edit Function",
+			"function": function,
+			"action":   "/api/functions/" + strconv.Itoa(id) + "/",
+			"method":   "PUT",
+			"modes":    functionModes,
+			"error":    "Name, Path, and Code are required fields and Mode and Visibility must be valid",
+		})
+		return
+	}
+	if diagnostics := validateFunctionCode(function.Code, function.Mode); diagnostics != nil {
+		c.HTML(http.StatusBadRequest, "function_form.html", gin.H{
+			"title":       "Edit Function",
+			"function":    function,
+			"action":      "/api/functions/" + strconv.Itoa(id),
+			"method":      "PUT",
+			"modes":       functionModes,
+			"error":       "Syntax error at " + diagnostics[0].String(),
+			"diagnostics": diagnostics,
+		})
+		return
+	}
+	if filesErr == nil {
+		filesErr = validateFunctionFiles(function.Files)
+	}
+	if filesErr != nil {
+		c.HTML(http.StatusBadRequest, "function_form.html", gin.H{
+			"title":    "Edit Function",
+			"function": function,
+			"action":   "/api/functions/" + strconv.Itoa(id),
+			"method":   "PUT",
+			"modes":    functionModes,
+			"error":    filesErr.Error(),
+		})
+		return
+	}
+
+	if !strings.HasPrefix(function.Path, "/") {
+		function.Path = "/" + function.Path
+	}
+
+	before, _ := app.getFunctionByID(id)
+	err = app.saveFunction(&function)
+	if err == nil {
+		err = app.setFunctionTags(id, function.Tags)
+	}
+	if err == nil {
+		err = app.setFunctionVisibility(id, function.Visibility)
+	}
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "function_form.html", gin.H{
+			"title":    "Edit Function",
+			"function": function,
+			"action":   "/api/functions/" + strconv.Itoa(id),
+			"method":   "PUT",
+			"modes":    functionModes,
+			"error":    "Failed to update function: " + err.Error(),
+		})
+		return
+	}
+
+	app.recordAudit(app.auditOrigin(c), AuditFunctionUpdated, before, &function)
+	c.Redirect(http.StatusFound, "/")
+}
+
+func (app *App) deleteFunction(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+
+	before, _ := app.getFunctionByID(id)
+	err = app.removeFunction(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete function"})
+		return
+	}
+	if before != nil {
+		app.recordAudit(app.auditOrigin(c), AuditFunctionDeleted, before, nil)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Function deleted successfully"})
+}
+
+func (app *App) getAllFunctions() ([]Function, error) {
+	return app.store.Functions()
+}
+
+func (app *App) getFunctionByID(id int) (*Function, error) {
+	return app.store.FunctionByID(id)
+}
+
+func (app *App) getFunctionByPath(path string) (*Function, error) {
+	return app.store.FunctionByPath(path)
+}
+
+func (app *App) insertFunction(function *Function) error {
+	if function.Mode == "" {
+		function.Mode = ModeStandard
+	}
+	function.Tags = normalizeTags(function.Tags)
+	if err := app.store.InsertFunction(function); err != nil {
+		return err
+	}
+
+	app.fireWebhook(WebhookFunctionCreated, functionSummary(function))
+	return nil
+}
+
+func (app *App) saveFunction(function *Function) error {
+	if function.Mode == "" {
+		function.Mode = ModeStandard
+	}
+	if err := app.store.SaveFunction(function); err != nil {
+		return err
+	}
+	app.scripts.forget(function.ID)
+	app.broadcastChange(changeFunction, function.ID)
+
+	app.fireWebhook(WebhookFunctionUpdated, functionSummary(function))
+	return nil
+}
+
+func (app *App) removeFunction(id int) error {
+	function, _ := app.getFunctionByID(id)
+	app.deactivateFunctionTriggers(id)
+
+	if err := app.store.DeleteFunction(id); err != nil {
+		return err
+	}
+	app.scripts.forget(id)
+	app.broadcastChange(changeFunction, id)
+	app.broadcastChange(changeTriggers, id)
+
+	if function != nil {
+		app.fireWebhook(WebhookFunctionDeleted, functionSummary(function))
+	}
+	return nil
+}
+
+func (app *App) getFunctionVersions(functionID int) ([]FunctionVersion, error) {
+	return app.store.Versions(functionID)
+}
+
+func (app *App) getFunctionVersion(functionID, version int) (*FunctionVersion, error) {
+	return app.store.Version(functionID, version)
+}
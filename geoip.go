@@ -0,0 +1,155 @@
+package runbox
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/oschwald/maxminddb-golang/v2"
+	"github.com/robertkrimen/otto"
+)
+
+// geoIPRecord holds the fields of the GeoIP2 and GeoLite2 City, Country
+// and ASN databases that request.geo reports.
+type geoIPRecord struct {
+	Continent struct {
+		Code string `maxminddb:"code"`
+	} `maxminddb:"continent"`
+	Country struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	Subdivisions []struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Postal struct {
+		Code string `maxminddb:"code"`
+	} `maxminddb:"postal"`
+	Location struct {
+		Latitude  *float64 `maxminddb:"latitude"`
+		Longitude *float64 `maxminddb:"longitude"`
+		TimeZone  string   `maxminddb:"time_zone"`
+	} `maxminddb:"location"`
+	ASN            uint   `maxminddb:"autonomous_system_number"`
+	ASOrganization string `maxminddb:"autonomous_system_organization"`
+}
+
+// geoIP looks addresses up in the databases of the geoip config.
+type geoIP struct {
+	readers []*maxminddb.Reader
+}
+
+// openGeoIP opens the configured databases, or answers nil without any.
+func openGeoIP(config GeoIPConfig) (*geoIP, error) {
+	g := &geoIP{}
+	for _, path := range []string{config.Database, config.ASNDatabase} {
+		if path == "" {
+			continue
+		}
+		reader, err := maxminddb.Open(path)
+		if err != nil {
+			g.close()
+			return nil, fmt.Errorf("failed to open GeoIP database %s: %v", path, err)
+		}
+		g.readers = append(g.readers, reader)
+	}
+	if len(g.readers) == 0 {
+		return nil, nil
+	}
+	return g, nil
+}
+
+func (g *geoIP) close() {
+	for _, reader := range g.readers {
+		reader.Close()
+	}
+}
+
+// lookup answers what the databases know about ip, with the fields named
+// as in Cloudflare's request.cf, or nil when none of them has the address.
+func (g *geoIP) lookup(ip string) (map[string]interface{}, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IP address %q", ip)
+	}
+	addr = addr.Unmap()
+
+	var record geoIPRecord
+	found := false
+	for _, reader := range g.readers {
+		result := reader.Lookup(addr)
+		if !result.Found() {
+			if err := result.Err(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := result.Decode(&record); err != nil {
+			return nil, err
+		}
+		found = true
+	}
+	if !found {
+		return nil, nil
+	}
+
+	geo := map[string]interface{}{}
+	set := func(key, value string) {
+		if value != "" {
+			geo[key] = value
+		}
+	}
+	set("continent", record.Continent.Code)
+	set("country", record.Country.ISOCode)
+	set("countryName", record.Country.Names["en"])
+	if len(record.Subdivisions) > 0 {
+		set("regionCode", record.Subdivisions[0].ISOCode)
+		set("region", record.Subdivisions[0].Names["en"])
+	}
+	set("city", record.City.Names["en"])
+	set("postalCode", record.Postal.Code)
+	if record.Location.Latitude != nil && record.Location.Longitude != nil {
+		geo["latitude"] = *record.Location.Latitude
+		geo["longitude"] = *record.Location.Longitude
+	}
+	set("timezone", record.Location.TimeZone)
+	if record.ASN != 0 {
+		geo["asn"] = record.ASN
+	}
+	set("asOrganization", record.ASOrganization)
+	return geo, nil
+}
+
+// attachGeo sets request.geo from the client IP of HTTP runs, unless the
+// request already has one, as replays do.
+func (app *App) attachGeo(request map[string]interface{}) {
+	if app.geoip == nil {
+		return
+	}
+	ip, ok := request["ip"].(string)
+	if _, set := request["geo"]; !ok || ip == "" || set {
+		return
+	}
+	if geo, err := app.geoip.lookup(ip); err == nil && geo != nil {
+		request["geo"] = geo
+	}
+}
+
+// jsGeoIP backs runbox.geoip(ip), which answers null for addresses the
+// databases don't have.
+func (app *App) jsGeoIP(call otto.FunctionCall) otto.Value {
+	if app.geoip == nil {
+		throwError(call, "runbox.geoip: no GeoIP database is configured")
+	}
+	geo, err := app.geoip.lookup(call.Argument(0).String())
+	if err != nil {
+		throwError(call, "runbox.geoip: "+err.Error())
+	}
+	if geo == nil {
+		return otto.NullValue()
+	}
+	return toValue(call, geo)
+}
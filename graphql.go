@@ -0,0 +1,574 @@
+package runbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graph-gophers/graphql-go"
+)
+
+const adminSchema = `
+schema {
+	query: Query
+	mutation: Mutation
+}
+
+type Query {
+	functions: [Function!]!
+	function(id: ID, path: String): Function
+	executionLog(id: ID!): ExecutionLog
+	metrics(window: String): [Metrics!]!
+}
+
+type Mutation {
+	createFunction(input: FunctionInput!): Function!
+	updateFunction(id: ID!, input: FunctionInput!): Function!
+	deleteFunction(id: ID!): Boolean!
+}
+
+input FunctionInput {
+	name: String!
+	path: String!
+	code: String!
+	description: String
+	mode: String
+	tags: [String!]
+	files: [FileInput!]
+	visibility: String
+}
+
+input FileInput {
+	name: String!
+	code: String!
+}
+
+type File {
+	name: String!
+	code: String!
+}
+
+type Function {
+	id: ID!
+	name: String!
+	path: String!
+	code: String!
+	description: String!
+	version: Int!
+	mode: String!
+	sourceUrl: String
+	tags: [String!]!
+	files: [File!]!
+	visibility: String
+	versions: [FunctionVersion!]!
+	logs(status: String, source: String, limit: Int = 50, offset: Int = 0): [ExecutionLog!]!
+	metrics(window: String): Metrics!
+}
+
+type FunctionVersion {
+	version: Int!
+	name: String!
+	path: String!
+	code: String!
+	description: String!
+	mode: String!
+	createdAt: String!
+}
+
+type ExecutionLog {
+	id: ID!
+	function: Function
+	version: Int!
+	method: String!
+	source: String!
+	status: String!
+	httpStatus: Int
+	durationMs: Int!
+	slow: Boolean!
+	error: String
+	request: String!
+	response: String
+	truncated: Boolean!
+	logs: [String!]!
+	jobId: String
+	requestId: String!
+	fingerprint: String
+	createdAt: String!
+}
+
+type Metrics {
+	function: Function!
+	window: String!
+	invocations: Int!
+	errors: Int!
+	errorRate: Float!
+	slow: Int!
+	avgMs: Float!
+	p50Ms: Int!
+	p95Ms: Int!
+	p99Ms: Int!
+	maxMs: Int!
+}
+`
+
+type graphqlResolver struct {
+	app *App
+}
+
+type functionResolver struct {
+	app *App
+	f   *Function
+}
+
+type functionVersionResolver struct {
+	v FunctionVersion
+}
+
+type functionInput struct {
+	Name        string
+	Path        string
+	Code        string
+	Description *string
+	Mode        *string
+	Tags        *[]string
+	Files       *[]fileInput
+	Visibility  *string
+}
+
+type fileInput struct {
+	Name string
+	Code string
+}
+
+type fileResolver struct {
+	f FunctionFile
+}
+
+func (r *fileResolver) Name() string { return r.f.Name }
+func (r *fileResolver) Code() string { return r.f.Code }
+
+func (r *graphqlResolver) Functions() ([]*functionResolver, error) {
+	functions, err := r.app.getAllFunctions()
+	if err != nil {
+		return nil, err
+	}
+
+	resolvers := make([]*functionResolver, 0, len(functions))
+	for i := range functions {
+		resolvers = append(resolvers, &functionResolver{app: r.app, f: &functions[i]})
+	}
+	return resolvers, nil
+}
+
+func (r *graphqlResolver) Function(args struct {
+	ID   *graphql.ID
+	Path *string
+}) (*functionResolver, error) {
+	var (
+		function *Function
+		err      error
+	)
+
+	switch {
+	case args.ID != nil:
+		id, convErr := strconv.Atoi(string(*args.ID))
+		if convErr != nil {
+			return nil, errors.New("invalid function ID")
+		}
+		function, err = r.app.getFunctionByID(id)
+	case args.Path != nil:
+		function, err = r.app.getFunctionByPath(*args.Path)
+	default:
+		return nil, errors.New("either id or path is required")
+	}
+
+	if err != nil {
+		return nil, nil
+	}
+	return &functionResolver{app: r.app, f: function}, nil
+}
+
+func (r *graphqlResolver) CreateFunction(ctx context.Context, args struct{ Input functionInput }) (*functionResolver, error) {
+	if r.app.config.ReadOnly {
+		return nil, errReadOnly
+	}
+	function, err := args.Input.toFunction()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.app.insertFunction(function); err != nil {
+		return nil, err
+	}
+	r.app.recordAudit(auditOriginFrom(ctx), AuditFunctionCreated, nil, function)
+	return &functionResolver{app: r.app, f: function}, nil
+}
+
+func (r *graphqlResolver) UpdateFunction(ctx context.Context, args struct {
+	ID    graphql.ID
+	Input functionInput
+}) (*functionResolver, error) {
+	if r.app.config.ReadOnly {
+		return nil, errReadOnly
+	}
+	id, err := strconv.Atoi(string(args.ID))
+	if err != nil {
+		return nil, errors.New("invalid function ID")
+	}
+
+	function, err := args.Input.toFunction()
+	if err != nil {
+		return nil, err
+	}
+	function.ID = id
+
+	before, _ := r.app.getFunctionByID(id)
+	if args.Input.Files == nil && before != nil {
+		function.Files = before.Files
+	}
+	if err := r.app.saveFunction(function); err != nil {
+		return nil, err
+	}
+	// Tags, files and visibility left out of the input stay as they are.
+	if args.Input.Tags != nil {
+		if err := r.app.setFunctionTags(id, function.Tags); err != nil {
+			return nil, err
+		}
+	} else if before != nil {
+		function.Tags = before.Tags
+	}
+	if args.Input.Visibility != nil {
+		if err := r.app.setFunctionVisibility(id, function.Visibility); err != nil {
+			return nil, err
+		}
+	} else if before != nil {
+		function.Visibility = before.Visibility
+	}
+	r.app.recordAudit(auditOriginFrom(ctx), AuditFunctionUpdated, before, function)
+	return &functionResolver{app: r.app, f: function}, nil
+}
+
+func (r *graphqlResolver) DeleteFunction(ctx context.Context, args struct{ ID graphql.ID }) (bool, error) {
+	if r.app.config.ReadOnly {
+		return false, errReadOnly
+	}
+	id, err := strconv.Atoi(string(args.ID))
+	if err != nil {
+		return false, errors.New("invalid function ID")
+	}
+
+	before, _ := r.app.getFunctionByID(id)
+	if err := r.app.removeFunction(id); err != nil {
+		return false, err
+	}
+	if before != nil {
+		r.app.recordAudit(auditOriginFrom(ctx), AuditFunctionDeleted, before, nil)
+	}
+	return true, nil
+}
+
+func (in functionInput) toFunction() (*Function, error) {
+	function := &Function{
+		Name: in.Name,
+		Path: in.Path,
+		Code: in.Code,
+	}
+	if in.Description != nil {
+		function.Description = *in.Description
+	}
+	function.Mode = ModeStandard
+	if in.Mode != nil {
+		function.Mode = *in.Mode
+	}
+	function.Tags = []string{}
+	if in.Tags != nil {
+		function.Tags = normalizeTags(*in.Tags)
+	}
+	if in.Visibility != nil {
+		function.Visibility = *in.Visibility
+	}
+	if in.Files != nil {
+		for _, f := range *in.Files {
+			function.Files = append(function.Files, FunctionFile{Name: f.Name, Code: f.Code})
+		}
+	}
+
+	if function.Name == "" || function.Path == "" || function.Code == "" {
+		return nil, errors.New("name, path, and code are required fields")
+	}
+	if !validMode(function.Mode) {
+		return nil, errors.New("invalid mode " + function.Mode)
+	}
+	if !validVisibility(function.Visibility) {
+		return nil, errors.New("invalid visibility " + function.Visibility)
+	}
+	if diagnostics := validateFunctionCode(function.Code, function.Mode); diagnostics != nil {
+		return nil, fmt.Errorf("syntax error at %s", diagnostics[0])
+	}
+	if err := validateFunctionFiles(function.Files); err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(function.Path, "/") {
+		function.Path = "/" + function.Path
+	}
+	return function, nil
+}
+
+func (r *functionResolver) ID() graphql.ID {
+	return graphql.ID(strconv.Itoa(r.f.ID))
+}
+
+func (r *functionResolver) Name() string        { return r.f.Name }
+func (r *functionResolver) Path() string        { return r.f.Path }
+func (r *functionResolver) Code() string        { return r.f.Code }
+func (r *functionResolver) Description() string { return r.f.Description }
+func (r *functionResolver) Version() int32      { return int32(r.f.Version) }
+func (r *functionResolver) Mode() string        { return r.f.Mode }
+
+func (r *functionResolver) Tags() []string { return r.f.Tags }
+
+func (r *functionResolver) Files() []*fileResolver {
+	resolvers := make([]*fileResolver, 0, len(r.f.Files))
+	for _, f := range r.f.Files {
+		resolvers = append(resolvers, &fileResolver{f: f})
+	}
+	return resolvers
+}
+
+func (r *functionResolver) Visibility() *string {
+	if r.f.Visibility == "" {
+		return nil
+	}
+	return &r.f.Visibility
+}
+
+func (r *functionResolver) SourceURL() *string {
+	if r.f.SourceURL == "" {
+		return nil
+	}
+	return &r.f.SourceURL
+}
+
+func (r *functionResolver) Versions() ([]*functionVersionResolver, error) {
+	versions, err := r.app.getFunctionVersions(r.f.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	resolvers := make([]*functionVersionResolver, 0, len(versions))
+	for _, v := range versions {
+		resolvers = append(resolvers, &functionVersionResolver{v: v})
+	}
+	return resolvers, nil
+}
+
+func (r *functionVersionResolver) Version() int32      { return int32(r.v.Version) }
+func (r *functionVersionResolver) Name() string        { return r.v.Name }
+func (r *functionVersionResolver) Path() string        { return r.v.Path }
+func (r *functionVersionResolver) Code() string        { return r.v.Code }
+func (r *functionVersionResolver) Description() string { return r.v.Description }
+func (r *functionVersionResolver) Mode() string        { return r.v.Mode }
+func (r *functionVersionResolver) CreatedAt() string   { return r.v.CreatedAt.UTC().Format(time.RFC3339) }
+
+// Logs answers the function's executions newest first, as GET
+// /api/functions/:id/logs does with the same filters.
+func (r *functionResolver) Logs(args struct {
+	Status *string
+	Source *string
+	Limit  int32
+	Offset int32
+}) ([]*executionLogResolver, error) {
+	if args.Limit < 1 || args.Limit > 500 {
+		return nil, errors.New("limit must be between 1 and 500")
+	}
+	if args.Offset < 0 {
+		return nil, errors.New("invalid offset")
+	}
+
+	query := `SELECT ` + executionLogColumns + ` FROM execution_logs WHERE function_id = ?`
+	queryArgs := []interface{}{r.f.ID}
+	if args.Status != nil {
+		query += ` AND status = ?`
+		queryArgs = append(queryArgs, *args.Status)
+	}
+	if args.Source != nil {
+		query += ` AND source = ?`
+		queryArgs = append(queryArgs, *args.Source)
+	}
+	query += ` ORDER BY created_at DESC LIMIT ? OFFSET ?`
+	entries, err := r.app.queryExecutionLogs(query, append(queryArgs, args.Limit, args.Offset)...)
+	if err != nil {
+		return nil, err
+	}
+
+	resolvers := make([]*executionLogResolver, 0, len(entries))
+	for i := range entries {
+		resolvers = append(resolvers, &executionLogResolver{app: r.app, e: &entries[i], function: r.f})
+	}
+	return resolvers, nil
+}
+
+func (r *functionResolver) Metrics(args struct{ Window *string }) (*metricsResolver, error) {
+	metrics, err := r.app.metricsResolvers([]Function{*r.f}, args.Window)
+	if err != nil {
+		return nil, err
+	}
+	return metrics[0], nil
+}
+
+func (r *graphqlResolver) ExecutionLog(args struct{ ID graphql.ID }) (*executionLogResolver, error) {
+	e, err := scanExecutionLog(r.app.db.QueryRow(`SELECT `+executionLogColumns+` FROM execution_logs WHERE id = ?`, string(args.ID)))
+	if err != nil {
+		return nil, nil
+	}
+	return &executionLogResolver{app: r.app, e: e}, nil
+}
+
+// Metrics answers every function's metrics over window, 24h by default,
+// as GET /api/metrics does.
+func (r *graphqlResolver) Metrics(args struct{ Window *string }) ([]*metricsResolver, error) {
+	functions, err := r.app.getAllFunctions()
+	if err != nil {
+		return nil, err
+	}
+	return r.app.metricsResolvers(functions, args.Window)
+}
+
+func (app *App) metricsResolvers(functions []Function, window *string) ([]*metricsResolver, error) {
+	w := ""
+	if window != nil {
+		w = *window
+	}
+	d, err := parseWindow(w)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, len(functions))
+	for i, f := range functions {
+		ids[i] = f.ID
+	}
+	stats, err := app.functionMetrics(ids, time.Now().UTC().Add(-d))
+	if err != nil {
+		return nil, err
+	}
+
+	resolvers := make([]*metricsResolver, 0, len(functions))
+	for i := range functions {
+		resolvers = append(resolvers, &metricsResolver{app: app, f: &functions[i], window: d, s: stats[functions[i].ID]})
+	}
+	return resolvers, nil
+}
+
+type executionLogResolver struct {
+	app      *App
+	e        *ExecutionLog
+	function *Function
+}
+
+func (r *executionLogResolver) ID() graphql.ID { return graphql.ID(r.e.ID) }
+
+// Function is the function the execution ran, or null once it is deleted.
+func (r *executionLogResolver) Function() *functionResolver {
+	if r.function == nil {
+		function, err := r.app.getFunctionByID(r.e.FunctionID)
+		if err != nil {
+			return nil
+		}
+		r.function = function
+	}
+	return &functionResolver{app: r.app, f: r.function}
+}
+
+func (r *executionLogResolver) Version() int32       { return int32(r.e.Version) }
+func (r *executionLogResolver) Method() string       { return r.e.Method }
+func (r *executionLogResolver) Source() string       { return r.e.Source }
+func (r *executionLogResolver) Status() string       { return r.e.Status }
+func (r *executionLogResolver) DurationMs() int32    { return int32(r.e.DurationMs) }
+func (r *executionLogResolver) Slow() bool           { return r.e.Slow }
+func (r *executionLogResolver) Request() string      { return r.e.Request }
+func (r *executionLogResolver) Truncated() bool      { return r.e.Truncated }
+func (r *executionLogResolver) Logs() []string       { return r.e.Logs }
+func (r *executionLogResolver) RequestID() string    { return r.e.RequestID }
+func (r *executionLogResolver) Error() *string       { return optionalString(r.e.Error) }
+func (r *executionLogResolver) Response() *string    { return optionalString(r.e.Response) }
+func (r *executionLogResolver) JobID() *string       { return optionalString(r.e.JobID) }
+func (r *executionLogResolver) Fingerprint() *string { return optionalString(r.e.Fingerprint) }
+func (r *executionLogResolver) CreatedAt() string    { return r.e.CreatedAt.UTC().Format(time.RFC3339) }
+
+func (r *executionLogResolver) HTTPStatus() *int32 {
+	if r.e.HTTPStatus == 0 {
+		return nil
+	}
+	status := int32(r.e.HTTPStatus)
+	return &status
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+type metricsResolver struct {
+	app    *App
+	f      *Function
+	window time.Duration
+	s      *functionStats
+}
+
+func (r *metricsResolver) Function() *functionResolver { return &functionResolver{app: r.app, f: r.f} }
+func (r *metricsResolver) Window() string              { return r.window.String() }
+func (r *metricsResolver) Invocations() int32          { return int32(r.s.Invocations) }
+func (r *metricsResolver) Errors() int32               { return int32(r.s.Errors) }
+func (r *metricsResolver) Slow() int32                 { return int32(r.s.Slow) }
+func (r *metricsResolver) P50Ms() int32                { return int32(r.s.percentile(0.50)) }
+func (r *metricsResolver) P95Ms() int32                { return int32(r.s.percentile(0.95)) }
+func (r *metricsResolver) P99Ms() int32                { return int32(r.s.percentile(0.99)) }
+func (r *metricsResolver) MaxMs() int32                { return int32(r.s.MaxMs) }
+
+func (r *metricsResolver) ErrorRate() float64 {
+	if r.s.Invocations == 0 {
+		return 0
+	}
+	return float64(r.s.Errors) / float64(r.s.Invocations)
+}
+
+func (r *metricsResolver) AvgMs() float64 {
+	if r.s.Invocations == 0 {
+		return 0
+	}
+	return float64(r.s.TotalMs) / float64(r.s.Invocations)
+}
+
+func (app *App) graphqlHandler(c *gin.Context) {
+	var params struct {
+		Query         string                 `json:"query"`
+		OperationName string                 `json:"operationName"`
+		Variables     map[string]interface{} `json:"variables"`
+	}
+
+	if c.Request.Method == http.MethodGet {
+		params.Query = c.Query("query")
+		params.OperationName = c.Query("operationName")
+	} else if err := c.ShouldBindJSON(&params); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid GraphQL request body"})
+		return
+	}
+
+	if params.Query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing GraphQL query"})
+		return
+	}
+
+	ctx := context.WithValue(c.Request.Context(), auditOriginKey{}, app.auditOrigin(c))
+	response := app.graphqlSchema.Exec(ctx, params.Query, params.OperationName, params.Variables)
+	c.JSON(http.StatusOK, response)
+}
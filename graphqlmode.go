@@ -0,0 +1,934 @@
+package runbox
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/robertkrimen/otto"
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"github.com/vektah/gqlparser/v2/validator"
+)
+
+const maxCachedGraphQLSchemas = 256
+
+// graphiQLPage is served for a browser GET without a query; it sends its
+// requests back to the path it was loaded from.
+const graphiQLPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>GraphiQL</title>
+  <style>body { margin: 0; height: 100vh; } #graphiql { height: 100vh; }</style>
+  <link rel="stylesheet" href="https://unpkg.com/graphiql@3.8.3/graphiql.min.css">
+</head>
+<body>
+  <div id="graphiql">Loading…</div>
+  <script crossorigin src="https://unpkg.com/react@18.3.1/umd/react.production.min.js"></script>
+  <script crossorigin src="https://unpkg.com/react-dom@18.3.1/umd/react-dom.production.min.js"></script>
+  <script crossorigin src="https://unpkg.com/graphiql@3.8.3/graphiql.min.js"></script>
+  <script>
+    var fetcher = GraphiQL.createFetcher({ url: window.location.pathname });
+    ReactDOM.createRoot(document.getElementById('graphiql'))
+      .render(React.createElement(GraphiQL, { fetcher: fetcher }));
+  </script>
+</body>
+</html>
+`
+
+// gqlSchemaCache keeps the parsed typeDefs of graphql functions, keyed by
+// their text, so a schema is only loaded once while the code is unchanged.
+type gqlSchemaCache struct {
+	mu      sync.Mutex
+	schemas map[[32]byte]*ast.Schema
+}
+
+func (gc *gqlSchemaCache) load(typeDefs string) (*ast.Schema, error) {
+	key := sha256.Sum256([]byte(typeDefs))
+	gc.mu.Lock()
+	schema, ok := gc.schemas[key]
+	gc.mu.Unlock()
+	if ok {
+		return schema, nil
+	}
+
+	schema, err := gqlparser.LoadSchema(&ast.Source{Name: "typeDefs", Input: typeDefs})
+	if err != nil {
+		return nil, err
+	}
+	gc.mu.Lock()
+	if gc.schemas == nil || len(gc.schemas) >= maxCachedGraphQLSchemas {
+		gc.schemas = map[[32]byte]*ast.Schema{}
+	}
+	gc.schemas[key] = schema
+	gc.mu.Unlock()
+	return schema, nil
+}
+
+type graphqlParams struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// graphqlObject is a JSON object that keeps its keys in selection order,
+// as GraphQL responses are expected to.
+type graphqlObject []graphqlEntry
+
+type graphqlEntry struct {
+	key   string
+	value interface{}
+}
+
+func (o graphqlObject) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, e := range o {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(e.key)
+		b.Write(key)
+		b.WriteByte(':')
+		value, err := json.Marshal(e.value)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+func graphqlResponse(status int, response graphqlObject) *HTTPResponse {
+	body, _ := json.Marshal(response)
+	return &HTTPResponse{
+		Status:  status,
+		Headers: map[string]string{"Content-Type": "application/json; charset=utf-8"},
+		Body:    string(body),
+	}
+}
+
+func graphqlRequestError(status int, message string) *HTTPResponse {
+	return graphqlResponse(status, graphqlObject{{"errors", gqlerror.List{gqlerror.Errorf("%s", message)}}})
+}
+
+// invokeGraphQL serves a graphql function: the typeDefs string is its
+// schema and resolvers maps type names to field resolvers called as
+// resolve(parent, args, context, info). Fields without a resolver read
+// the property of the same name from the parent. Queries come as POST
+// JSON, application/graphql or GET parameters; a browser GET without a
+// query gets GraphiQL.
+func (app *App) invokeGraphQL(vm *otto.Otto, exec *execution) (interface{}, error) {
+	requestData := exec.request
+	method := fmt.Sprint(requestData["method"])
+	headers := stringMap(requestData["headers"])
+
+	var params graphqlParams
+	switch method {
+	case http.MethodGet:
+		query, _ := requestData["query"].(map[string]interface{})
+		params.Query, _ = query["query"].(string)
+		params.OperationName, _ = query["operationName"].(string)
+		if params.Query == "" {
+			if strings.Contains(headers["Accept"], "text/html") {
+				return &HTTPResponse{
+					Status:  http.StatusOK,
+					Headers: map[string]string{"Content-Type": "text/html; charset=utf-8"},
+					Body:    graphiQLPage,
+				}, nil
+			}
+			return graphqlRequestError(http.StatusBadRequest, "Must provide a query string"), nil
+		}
+		if variables, _ := query["variables"].(string); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &params.Variables); err != nil {
+				return graphqlRequestError(http.StatusBadRequest, "variables must be a JSON object"), nil
+			}
+		}
+	case http.MethodPost:
+		rawBody, _ := requestData["rawBody"].(string)
+		if strings.HasPrefix(headers["Content-Type"], "application/graphql") {
+			params.Query = rawBody
+		} else if err := json.Unmarshal([]byte(rawBody), &params); err != nil {
+			return graphqlRequestError(http.StatusBadRequest, "The body must be a JSON object with a query"), nil
+		}
+		if params.Query == "" {
+			return graphqlRequestError(http.StatusBadRequest, "Must provide a query string"), nil
+		}
+	default:
+		resp := graphqlRequestError(http.StatusMethodNotAllowed, "GraphQL only supports GET and POST requests")
+		resp.Headers["Allow"] = "GET, POST"
+		return resp, nil
+	}
+
+	typeDefs, _ := vm.Get("typeDefs")
+	if !typeDefs.IsString() {
+		return nil, fmt.Errorf("graphql mode requires a typeDefs string with the schema")
+	}
+	schema, err := app.gqlSchemas.load(typeDefs.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid typeDefs: %v", err)
+	}
+	resolvers, _ := vm.Get("resolvers")
+	if resolvers.IsDefined() && !resolvers.IsObject() {
+		return nil, fmt.Errorf("resolvers must be an object of type names to field resolvers")
+	}
+	if err := checkResolvers(schema, resolvers); err != nil {
+		return nil, err
+	}
+
+	doc, errs := gqlparser.LoadQueryWithRules(schema, params.Query, nil)
+	if len(errs) > 0 {
+		return graphqlResponse(http.StatusOK, graphqlObject{{"errors", errs}}), nil
+	}
+	var op *ast.OperationDefinition
+	if params.OperationName != "" {
+		op = doc.Operations.ForName(params.OperationName)
+	} else if len(doc.Operations) == 1 {
+		op = doc.Operations[0]
+	}
+	if op == nil {
+		if params.OperationName != "" {
+			return graphqlRequestError(http.StatusBadRequest, "Unknown operation named \""+params.OperationName+"\""), nil
+		}
+		return graphqlRequestError(http.StatusBadRequest, "Must provide an operation name if the query contains multiple operations"), nil
+	}
+	var root *ast.Definition
+	switch op.Operation {
+	case ast.Mutation:
+		if method == http.MethodGet {
+			resp := graphqlRequestError(http.StatusMethodNotAllowed, "Mutations must be sent with POST")
+			resp.Headers["Allow"] = "POST"
+			return resp, nil
+		}
+		root = schema.Mutation
+	case ast.Subscription:
+		return graphqlRequestError(http.StatusBadRequest, "Subscriptions are not supported"), nil
+	default:
+		root = schema.Query
+	}
+	variables, err := validator.VariableValues(schema, op, params.Variables)
+	if err != nil {
+		return graphqlResponse(http.StatusOK, graphqlObject{{"errors", gqlerror.List{gqlerror.WrapIfUnwrapped(err)}}}), nil
+	}
+
+	request, _ := vm.Get("request")
+	context, _ := vm.Object(`({})`)
+	context.Set("request", request)
+	ge := &graphqlExecution{
+		vm:            vm,
+		exec:          exec,
+		schema:        schema,
+		doc:           doc,
+		variables:     variables,
+		context:       context.Value(),
+		introspection: &graphqlIntrospection{schema: schema, types: map[string]map[string]interface{}{}},
+	}
+	if resolvers.IsObject() {
+		ge.resolvers = resolvers.Object()
+	}
+	data, _ := ge.selectionSet(root, op.SelectionSet, otto.UndefinedValue(), nil)
+
+	response := graphqlObject{}
+	if len(ge.errors) > 0 {
+		response = append(response, graphqlEntry{"errors", ge.errors})
+	}
+	if data == nil {
+		response = append(response, graphqlEntry{"data", nil})
+	} else {
+		response = append(response, graphqlEntry{"data", data})
+	}
+	return graphqlResponse(http.StatusOK, response), nil
+}
+
+// checkResolvers rejects resolvers for types and fields the schema does
+// not have, which are otherwise silently never called.
+func checkResolvers(schema *ast.Schema, resolvers otto.Value) error {
+	if !resolvers.IsObject() {
+		return nil
+	}
+	object := resolvers.Object()
+	for _, typeName := range object.Keys() {
+		def := schema.Types[typeName]
+		if def == nil || strings.HasPrefix(typeName, "__") {
+			return fmt.Errorf("resolvers.%s: there is no type %s in typeDefs", typeName, typeName)
+		}
+		if def.Kind != ast.Object && def.Kind != ast.Interface {
+			continue
+		}
+		fields, _ := object.Get(typeName)
+		if !fields.IsObject() {
+			return fmt.Errorf("resolvers.%s must be an object of field resolvers", typeName)
+		}
+		for _, fieldName := range fields.Object().Keys() {
+			if strings.HasPrefix(fieldName, "__") {
+				continue
+			}
+			if def.Fields.ForName(fieldName) == nil {
+				return fmt.Errorf("resolvers.%s.%s: %s has no field %s", typeName, fieldName, typeName, fieldName)
+			}
+		}
+	}
+	return nil
+}
+
+// graphqlExecution executes one operation against the function's
+// resolvers, collecting field errors rather than failing the request.
+type graphqlExecution struct {
+	vm            *otto.Otto
+	exec          *execution
+	schema        *ast.Schema
+	doc           *ast.QueryDocument
+	variables     map[string]interface{}
+	resolvers     *otto.Object
+	context       otto.Value
+	introspection *graphqlIntrospection
+	errors        gqlerror.List
+}
+
+// graphqlFields is the fields of a selection set grouped by response key,
+// in the order those keys first appear.
+type graphqlFields struct {
+	keys   []string
+	fields map[string][]*ast.Field
+}
+
+func (ge *graphqlExecution) fail(field *ast.Field, path ast.Path, message string) {
+	err := &gqlerror.Error{Message: message, Path: append(ast.Path{}, path...)}
+	if field.Position != nil {
+		err.Locations = []gqlerror.Location{{Line: field.Position.Line, Column: field.Position.Column}}
+	}
+	ge.errors = append(ge.errors, err)
+}
+
+// selectionSet executes set on parent as an objectType. failed reports
+// that a non-null field had no value, so the object itself is null.
+func (ge *graphqlExecution) selectionSet(objectType *ast.Definition, set ast.SelectionSet, parent interface{}, path ast.Path) (result graphqlObject, failed bool) {
+	grouped := &graphqlFields{fields: map[string][]*ast.Field{}}
+	ge.collectFields(objectType, set, map[string]bool{}, grouped)
+
+	result = graphqlObject{}
+	for _, key := range grouped.keys {
+		value, fieldFailed := ge.field(objectType, parent, grouped.fields[key], append(path, ast.PathName(key)))
+		if fieldFailed {
+			return nil, true
+		}
+		result = append(result, graphqlEntry{key, value})
+	}
+	return result, false
+}
+
+func (ge *graphqlExecution) collectFields(objectType *ast.Definition, set ast.SelectionSet, visited map[string]bool, grouped *graphqlFields) {
+	for _, selection := range set {
+		switch s := selection.(type) {
+		case *ast.Field:
+			if !ge.included(s.Directives) {
+				continue
+			}
+			key := s.Alias
+			if key == "" {
+				key = s.Name
+			}
+			if _, ok := grouped.fields[key]; !ok {
+				grouped.keys = append(grouped.keys, key)
+			}
+			grouped.fields[key] = append(grouped.fields[key], s)
+		case *ast.InlineFragment:
+			if ge.included(s.Directives) && ge.applies(objectType, s.TypeCondition) {
+				ge.collectFields(objectType, s.SelectionSet, visited, grouped)
+			}
+		case *ast.FragmentSpread:
+			if visited[s.Name] || !ge.included(s.Directives) {
+				continue
+			}
+			visited[s.Name] = true
+			fragment := ge.doc.Fragments.ForName(s.Name)
+			if fragment != nil && ge.applies(objectType, fragment.TypeCondition) {
+				ge.collectFields(objectType, fragment.SelectionSet, visited, grouped)
+			}
+		}
+	}
+}
+
+// included applies @skip and @include.
+func (ge *graphqlExecution) included(directives ast.DirectiveList) bool {
+	if d := directives.ForName("skip"); d != nil && d.ArgumentMap(ge.variables)["if"] == true {
+		return false
+	}
+	if d := directives.ForName("include"); d != nil && d.ArgumentMap(ge.variables)["if"] != true {
+		return false
+	}
+	return true
+}
+
+// applies reports whether a fragment on typeCondition applies to
+// objectType.
+func (ge *graphqlExecution) applies(objectType *ast.Definition, typeCondition string) bool {
+	if typeCondition == "" || typeCondition == objectType.Name {
+		return true
+	}
+	condition := ge.schema.Types[typeCondition]
+	if condition == nil || !condition.IsAbstractType() {
+		return false
+	}
+	for _, possible := range ge.schema.GetPossibleTypes(condition) {
+		if possible.Name == objectType.Name {
+			return true
+		}
+	}
+	return false
+}
+
+func (ge *graphqlExecution) field(objectType *ast.Definition, parent interface{}, fields []*ast.Field, path ast.Path) (interface{}, bool) {
+	field := fields[0]
+	if field.Name == "__typename" {
+		return objectType.Name, false
+	}
+	definition := objectType.Fields.ForName(field.Name)
+	if definition == nil {
+		return nil, false
+	}
+
+	args := field.ArgumentMap(ge.variables)
+	var value interface{}
+	var err error
+	switch {
+	case objectType == ge.schema.Query && field.Name == "__schema":
+		value = ge.introspection.schemaValue()
+	case objectType == ge.schema.Query && field.Name == "__type":
+		name, _ := args["name"].(string)
+		if ge.schema.Types[name] != nil {
+			value = ge.introspection.named(name)
+		}
+	default:
+		value, err = ge.resolve(objectType, parent, field, definition, args, path)
+	}
+	if err != nil {
+		ge.fail(field, path, resolverErrorMessage(err))
+		return nil, definition.Type.NonNull
+	}
+	return ge.complete(definition.Type, fields, value, path)
+}
+
+func resolverErrorMessage(err error) string {
+	if jsErr, ok := err.(*otto.Error); ok {
+		message := jsErr.Error()
+		if i := strings.Index(message, ": "); i >= 0 {
+			return message[i+2:]
+		}
+		return message
+	}
+	return err.Error()
+}
+
+// resolve calls resolvers[Type][field] when it is a function and
+// otherwise reads the field from parent, calling it if it is a method.
+func (ge *graphqlExecution) resolve(objectType *ast.Definition, parent interface{}, field *ast.Field, definition *ast.FieldDefinition, args map[string]interface{}, path ast.Path) (interface{}, error) {
+	if m, ok := parent.(map[string]interface{}); ok {
+		value := m[field.Name]
+		if lazy, ok := value.(graphqlLazy); ok {
+			return lazy(args), nil
+		}
+		return value, nil
+	}
+
+	jsParent, _ := parent.(otto.Value)
+	jsArgs, err := ge.vm.ToValue(args)
+	if err != nil {
+		return nil, err
+	}
+	info, err := ge.vm.ToValue(map[string]interface{}{
+		"fieldName":      field.Name,
+		"parentType":     objectType.Name,
+		"returnType":     definition.Type.String(),
+		"path":           pathValues(path),
+		"variableValues": ge.variables,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if resolver := ge.resolver(objectType.Name, field.Name); resolver.IsFunction() {
+		return resolver.Call(otto.UndefinedValue(), jsParent, jsArgs, ge.context, info)
+	}
+	if !jsParent.IsObject() {
+		return nil, nil
+	}
+	value, _ := jsParent.Object().Get(field.Name)
+	if value.IsFunction() {
+		return value.Call(jsParent, jsArgs, ge.context, info)
+	}
+	return value, nil
+}
+
+func (ge *graphqlExecution) resolver(typeName, fieldName string) otto.Value {
+	if ge.resolvers == nil {
+		return otto.UndefinedValue()
+	}
+	fields, _ := ge.resolvers.Get(typeName)
+	if !fields.IsObject() {
+		return otto.UndefinedValue()
+	}
+	resolver, _ := fields.Object().Get(fieldName)
+	return resolver
+}
+
+func pathValues(path ast.Path) []interface{} {
+	values := make([]interface{}, len(path))
+	for i, element := range path {
+		switch e := element.(type) {
+		case ast.PathIndex:
+			values[i] = int(e)
+		case ast.PathName:
+			values[i] = string(e)
+		}
+	}
+	return values
+}
+
+func isNullish(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	if v, ok := value.(otto.Value); ok {
+		return v.IsUndefined() || v.IsNull()
+	}
+	return false
+}
+
+// complete turns a resolved value into the response value for typ. As
+// with selectionSet, failed means the value is null because of an error,
+// which a nullable position absorbs and a non-null one passes up.
+func (ge *graphqlExecution) complete(typ *ast.Type, fields []*ast.Field, value interface{}, path ast.Path) (interface{}, bool) {
+	nullable := *typ
+	nullable.NonNull = false
+	result, failed := ge.completeNullable(&nullable, fields, value, path)
+	if !typ.NonNull {
+		return result, false
+	}
+	if result == nil && !failed {
+		field := fields[0]
+		ge.fail(field, path, "Cannot return null for non-nullable field "+field.ObjectDefinition.Name+"."+field.Name)
+	}
+	return result, result == nil
+}
+
+func (ge *graphqlExecution) completeNullable(typ *ast.Type, fields []*ast.Field, value interface{}, path ast.Path) (interface{}, bool) {
+	if isNullish(value) {
+		return nil, false
+	}
+	field := fields[0]
+
+	if typ.Elem != nil {
+		items, ok := ge.listItems(value)
+		if !ok {
+			ge.fail(field, path, "Expected a list for field "+field.ObjectDefinition.Name+"."+field.Name)
+			return nil, true
+		}
+		list := make([]interface{}, 0, len(items))
+		for i, item := range items {
+			completed, failed := ge.complete(typ.Elem, fields, item, append(path, ast.PathIndex(i)))
+			if failed {
+				return nil, true
+			}
+			list = append(list, completed)
+		}
+		return list, false
+	}
+
+	def := ge.schema.Types[typ.NamedType]
+	switch def.Kind {
+	case ast.Scalar, ast.Enum:
+		result, err := ge.serialize(def, value)
+		if err != nil {
+			ge.fail(field, path, err.Error())
+			return nil, true
+		}
+		return result, false
+	case ast.Interface, ast.Union:
+		runtime, err := ge.resolveType(def, value, field, path)
+		if err != nil {
+			ge.fail(field, path, err.Error())
+			return nil, true
+		}
+		def = runtime
+	}
+
+	var set ast.SelectionSet
+	for _, f := range fields {
+		set = append(set, f.SelectionSet...)
+	}
+	result, failed := ge.selectionSet(def, set, value, path)
+	if failed {
+		return nil, true
+	}
+	return result, false
+}
+
+func (ge *graphqlExecution) listItems(value interface{}) ([]interface{}, bool) {
+	switch v := value.(type) {
+	case []interface{}:
+		return v, true
+	case otto.Value:
+		if !v.IsObject() {
+			return nil, false
+		}
+		object := v.Object()
+		switch object.Class() {
+		case "Array", "GoArray", "GoSlice":
+		default:
+			return nil, false
+		}
+		length, _ := object.Get("length")
+		n, _ := length.ToInteger()
+		items := make([]interface{}, n)
+		for i := range items {
+			items[i], _ = object.Get(strconv.Itoa(i))
+		}
+		return items, true
+	}
+	return nil, false
+}
+
+// resolveType picks the object type of a value in an interface or union
+// position: resolvers.Type.__resolveType(value, context, info), then the
+// value's __typename, then the only possible type.
+func (ge *graphqlExecution) resolveType(def *ast.Definition, value interface{}, field *ast.Field, path ast.Path) (*ast.Definition, error) {
+	var name string
+	if v, ok := value.(otto.Value); ok && v.IsObject() {
+		if resolveType := ge.resolver(def.Name, "__resolveType"); resolveType.IsFunction() {
+			info, _ := ge.vm.ToValue(map[string]interface{}{
+				"fieldName":  field.Name,
+				"parentType": field.ObjectDefinition.Name,
+				"path":       pathValues(path),
+			})
+			result, err := resolveType.Call(otto.UndefinedValue(), v, ge.context, info)
+			if err != nil {
+				return nil, fmt.Errorf("%s", resolverErrorMessage(err))
+			}
+			if result.IsString() {
+				name = result.String()
+			}
+		}
+		if name == "" {
+			if typename, _ := v.Object().Get("__typename"); typename.IsString() {
+				name = typename.String()
+			}
+		}
+	}
+
+	possible := ge.schema.GetPossibleTypes(def)
+	if name == "" && len(possible) == 1 {
+		return possible[0], nil
+	}
+	for _, p := range possible {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	if name == "" {
+		return nil, fmt.Errorf("Could not tell which type of %s the value of %s.%s is; give it a __typename or define resolvers.%s.__resolveType", def.Name, field.ObjectDefinition.Name, field.Name, def.Name)
+	}
+	return nil, fmt.Errorf("%s is not a possible type of %s", name, def.Name)
+}
+
+// serialize converts a leaf value to its scalar or enum result. The
+// built-in scalars are coerced as graphql-js does; custom scalars are
+// passed through as JSON.
+func (ge *graphqlExecution) serialize(def *ast.Definition, value interface{}) (interface{}, error) {
+	if v, ok := value.(otto.Value); ok {
+		exported, err := ge.exec.export(v)
+		if err != nil {
+			return nil, err
+		}
+		value = exported
+	}
+
+	number, isNumber := 0.0, false
+	switch n := value.(type) {
+	case int:
+		number, isNumber = float64(n), true
+	case int32:
+		number, isNumber = float64(n), true
+	case int64:
+		number, isNumber = float64(n), true
+	case float64:
+		number, isNumber = n, true
+	}
+
+	if def.Kind == ast.Enum {
+		if s, ok := value.(string); ok && def.EnumValues.ForName(s) != nil {
+			return s, nil
+		}
+		return nil, fmt.Errorf("Enum %s cannot represent value: %v", def.Name, value)
+	}
+	switch def.Name {
+	case "Int":
+		if isNumber && number == math.Trunc(number) && number >= math.MinInt32 && number <= math.MaxInt32 {
+			return int64(number), nil
+		}
+		if b, ok := value.(bool); ok {
+			if b {
+				return 1, nil
+			}
+			return 0, nil
+		}
+		return nil, fmt.Errorf("Int cannot represent non 32-bit integer value: %v", value)
+	case "Float":
+		if isNumber && !math.IsInf(number, 0) && !math.IsNaN(number) {
+			return number, nil
+		}
+		return nil, fmt.Errorf("Float cannot represent non numeric value: %v", value)
+	case "String":
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case bool:
+			return strconv.FormatBool(v), nil
+		}
+		if isNumber {
+			return strconv.FormatFloat(number, 'f', -1, 64), nil
+		}
+		return nil, fmt.Errorf("String cannot represent value: %v", value)
+	case "Boolean":
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+		if isNumber {
+			return number != 0, nil
+		}
+		return nil, fmt.Errorf("Boolean cannot represent a non boolean value: %v", value)
+	case "ID":
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+		if isNumber && number == math.Trunc(number) {
+			return strconv.FormatFloat(number, 'f', -1, 64), nil
+		}
+		return nil, fmt.Errorf("ID cannot represent value: %v", value)
+	}
+	return value, nil
+}
+
+// graphqlLazy is an introspection field that takes arguments or would be
+// expensive or recursive to build up front.
+type graphqlLazy func(args map[string]interface{}) interface{}
+
+// graphqlIntrospection answers __schema and __type from the parsed
+// schema, as plain maps read by the default resolver.
+type graphqlIntrospection struct {
+	schema *ast.Schema
+	types  map[string]map[string]interface{}
+}
+
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func deprecationOf(directives ast.DirectiveList) (bool, interface{}) {
+	d := directives.ForName("deprecated")
+	if d == nil {
+		return false, nil
+	}
+	if reason := d.Arguments.ForName("reason"); reason != nil {
+		return true, reason.Value.Raw
+	}
+	return true, "No longer supported"
+}
+
+func includeDeprecated(args map[string]interface{}) bool {
+	return args["includeDeprecated"] == true
+}
+
+func (gi *graphqlIntrospection) schemaValue() map[string]interface{} {
+	typeValue := func(def *ast.Definition) interface{} {
+		if def == nil {
+			return nil
+		}
+		return gi.named(def.Name)
+	}
+	return map[string]interface{}{
+		"description":      nullString(gi.schema.Description),
+		"queryType":        typeValue(gi.schema.Query),
+		"mutationType":     typeValue(gi.schema.Mutation),
+		"subscriptionType": typeValue(gi.schema.Subscription),
+		"types": graphqlLazy(func(map[string]interface{}) interface{} {
+			names := make([]string, 0, len(gi.schema.Types))
+			for name := range gi.schema.Types {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			types := make([]interface{}, len(names))
+			for i, name := range names {
+				types[i] = gi.named(name)
+			}
+			return types
+		}),
+		"directives": graphqlLazy(func(map[string]interface{}) interface{} {
+			names := make([]string, 0, len(gi.schema.Directives))
+			for name := range gi.schema.Directives {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			directives := make([]interface{}, len(names))
+			for i, name := range names {
+				d := gi.schema.Directives[name]
+				locations := make([]interface{}, len(d.Locations))
+				for j, location := range d.Locations {
+					locations[j] = string(location)
+				}
+				directives[i] = map[string]interface{}{
+					"name":         d.Name,
+					"description":  nullString(d.Description),
+					"isRepeatable": d.IsRepeatable,
+					"locations":    locations,
+					"args":         gi.arguments(d.Arguments),
+				}
+			}
+			return directives
+		}),
+	}
+}
+
+// typeRef is the __Type of a field or argument type, wrapping the named
+// type in NON_NULL and LIST as declared.
+func (gi *graphqlIntrospection) typeRef(t *ast.Type) map[string]interface{} {
+	if t.NonNull {
+		nullable := *t
+		nullable.NonNull = false
+		return map[string]interface{}{"kind": "NON_NULL", "ofType": gi.typeRef(&nullable)}
+	}
+	if t.Elem != nil {
+		return map[string]interface{}{"kind": "LIST", "ofType": gi.typeRef(t.Elem)}
+	}
+	return gi.named(t.NamedType)
+}
+
+func (gi *graphqlIntrospection) named(name string) map[string]interface{} {
+	if t, ok := gi.types[name]; ok {
+		return t
+	}
+	def := gi.schema.Types[name]
+	t := map[string]interface{}{
+		"kind":        string(def.Kind),
+		"name":        def.Name,
+		"description": nullString(def.Description),
+	}
+	gi.types[name] = t
+
+	switch def.Kind {
+	case ast.Scalar:
+		if d := def.Directives.ForName("specifiedBy"); d != nil {
+			if url := d.Arguments.ForName("url"); url != nil {
+				t["specifiedByURL"] = url.Value.Raw
+			}
+		}
+	case ast.Object, ast.Interface:
+		t["fields"] = graphqlLazy(func(args map[string]interface{}) interface{} {
+			fields := []interface{}{}
+			for _, f := range def.Fields {
+				deprecated, reason := deprecationOf(f.Directives)
+				if strings.HasPrefix(f.Name, "__") || deprecated && !includeDeprecated(args) {
+					continue
+				}
+				fields = append(fields, map[string]interface{}{
+					"name":              f.Name,
+					"description":       nullString(f.Description),
+					"args":              gi.arguments(f.Arguments),
+					"type":              gi.typeRef(f.Type),
+					"isDeprecated":      deprecated,
+					"deprecationReason": reason,
+				})
+			}
+			return fields
+		})
+		t["interfaces"] = graphqlLazy(func(map[string]interface{}) interface{} {
+			interfaces := make([]interface{}, len(def.Interfaces))
+			for i, name := range def.Interfaces {
+				interfaces[i] = gi.named(name)
+			}
+			return interfaces
+		})
+	case ast.Enum:
+		t["enumValues"] = graphqlLazy(func(args map[string]interface{}) interface{} {
+			values := []interface{}{}
+			for _, v := range def.EnumValues {
+				deprecated, reason := deprecationOf(v.Directives)
+				if deprecated && !includeDeprecated(args) {
+					continue
+				}
+				values = append(values, map[string]interface{}{
+					"name":              v.Name,
+					"description":       nullString(v.Description),
+					"isDeprecated":      deprecated,
+					"deprecationReason": reason,
+				})
+			}
+			return values
+		})
+	case ast.InputObject:
+		t["isOneOf"] = def.Directives.ForName("oneOf") != nil
+		t["inputFields"] = graphqlLazy(func(args map[string]interface{}) interface{} {
+			fields := []interface{}{}
+			for _, f := range def.Fields {
+				deprecated, reason := deprecationOf(f.Directives)
+				if deprecated && !includeDeprecated(args) {
+					continue
+				}
+				fields = append(fields, gi.inputValue(f.Name, f.Description, f.Type, f.DefaultValue, deprecated, reason))
+			}
+			return fields
+		})
+	}
+	if def.Kind == ast.Interface || def.Kind == ast.Union {
+		t["possibleTypes"] = graphqlLazy(func(map[string]interface{}) interface{} {
+			possible := gi.schema.GetPossibleTypes(def)
+			types := make([]interface{}, len(possible))
+			for i, p := range possible {
+				types[i] = gi.named(p.Name)
+			}
+			return types
+		})
+	}
+	return t
+}
+
+func (gi *graphqlIntrospection) arguments(defs ast.ArgumentDefinitionList) graphqlLazy {
+	return func(args map[string]interface{}) interface{} {
+		values := []interface{}{}
+		for _, a := range defs {
+			deprecated, reason := deprecationOf(a.Directives)
+			if deprecated && !includeDeprecated(args) {
+				continue
+			}
+			values = append(values, gi.inputValue(a.Name, a.Description, a.Type, a.DefaultValue, deprecated, reason))
+		}
+		return values
+	}
+}
+
+func (gi *graphqlIntrospection) inputValue(name, description string, t *ast.Type, defaultValue *ast.Value, deprecated bool, reason interface{}) map[string]interface{} {
+	value := map[string]interface{}{
+		"name":              name,
+		"description":       nullString(description),
+		"type":              gi.typeRef(t),
+		"isDeprecated":      deprecated,
+		"deprecationReason": reason,
+	}
+	if defaultValue != nil {
+		value["defaultValue"] = defaultValue.String()
+	}
+	return value
+}
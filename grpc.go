@@ -0,0 +1,179 @@
+package runbox
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robertkrimen/otto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const defaultGRPCTimeout = 10 * time.Second
+
+// grpcTarget is a client connection for one entry of grpc.
+type grpcTarget struct {
+	config GRPCTargetConfig
+	conn   *grpc.ClientConn
+}
+
+// openGRPCTargets makes a client for every configured service; servers
+// are dialed on first use and redialed as needed.
+func openGRPCTargets(configs GRPCTargets) (map[string]*grpcTarget, error) {
+	targets := map[string]*grpcTarget{}
+	for service, config := range configs {
+		if config.Address == "" {
+			closeGRPCTargets(targets)
+			return nil, fmt.Errorf("grpc.%s: address is required", service)
+		}
+		creds := insecure.NewCredentials()
+		if config.TLS {
+			creds = credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+		}
+		conn, err := grpc.NewClient(config.Address, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			closeGRPCTargets(targets)
+			return nil, fmt.Errorf("grpc.%s: %v", service, err)
+		}
+		targets[service] = &grpcTarget{config: config, conn: conn}
+	}
+	return targets, nil
+}
+
+func closeGRPCTargets(targets map[string]*grpcTarget) {
+	for _, t := range targets {
+		t.conn.Close()
+	}
+}
+
+func (t *grpcTarget) timeout() time.Duration {
+	if t.config.TimeoutSeconds > 0 {
+		return time.Duration(t.config.TimeoutSeconds) * time.Second
+	}
+	return defaultGRPCTimeout
+}
+
+// grpcMethod finds "package.Service/Method" in the function's protobuf
+// schema.
+func (app *App) grpcMethod(functionID int, name string) (protoreflect.MethodDescriptor, error) {
+	schema := app.protoSchemaFor(functionID)
+	if schema == nil {
+		return nil, fmt.Errorf("the function has no protobuf schema to find %s in", name)
+	}
+	service, method, ok := strings.Cut(strings.TrimPrefix(name, "/"), "/")
+	if !ok || service == "" || method == "" {
+		return nil, fmt.Errorf("%s is not a method; name it as package.Service/Method", name)
+	}
+	desc, err := schema.files.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil, fmt.Errorf("no service named %s in the function's protobuf schema", service)
+	}
+	sd, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a service", service)
+	}
+	md := sd.Methods().ByName(protoreflect.Name(method))
+	if md == nil {
+		return nil, fmt.Errorf("%s has no method %s", service, method)
+	}
+	if md.IsStreamingClient() || md.IsStreamingServer() {
+		return nil, fmt.Errorf("%s is a streaming method; only unary methods can be called", name)
+	}
+	return md, nil
+}
+
+// jsGRPC exposes runbox.grpc.call(method, payload, options), which calls
+// a unary method of a service in the function's protobuf schema on the
+// server configured for that service. The payload and the response are
+// in the proto3 JSON mapping, as protobuf request bodies are. A failed
+// call throws an Error with the gRPC status code and its name.
+func (app *App) jsGRPC(vm *otto.Otto, exec *execution) *otto.Object {
+	grpcObject, _ := vm.Object(`({})`)
+
+	grpcObject.Set("call", func(call otto.FunctionCall) otto.Value {
+		const prefix = "runbox.grpc.call: "
+		name := call.Argument(0).String()
+		method, err := app.grpcMethod(exec.function.ID, name)
+		if err != nil {
+			throwError(call, prefix+err.Error())
+		}
+		service := string(method.Parent().FullName())
+		target, ok := app.grpcTargets[service]
+		if !ok {
+			throwError(call, prefix+"no gRPC target is configured for "+service)
+		}
+		if !pathAllowed(target.config.Functions, exec.function.Path) {
+			throwError(call, prefix+service+" is not granted to "+exec.function.Path)
+		}
+
+		request := dynamicpb.NewMessage(method.Input())
+		if payload := call.Argument(1); payload.IsDefined() && !payload.IsNull() {
+			if !payload.IsObject() {
+				throwError(call, prefix+"payload must be an object")
+			}
+			exported, _ := payload.Export()
+			encoded, err := json.Marshal(exported)
+			if err != nil {
+				throwError(call, prefix+"payload must be JSON-serializable")
+			}
+			if err := protojson.Unmarshal(encoded, request); err != nil {
+				throwError(call, prefix+"payload is not a valid "+string(method.Input().FullName())+": "+err.Error())
+			}
+		}
+
+		timeout := target.timeout()
+		md := metadata.New(target.config.Metadata)
+		if options := call.Argument(2); options.IsObject() {
+			if v, _ := options.Object().Get("timeout"); v.IsDefined() {
+				seconds, err := v.ToFloat()
+				if err != nil || seconds <= 0 {
+					throwError(call, prefix+"timeout must be a number of seconds")
+				}
+				timeout = time.Duration(seconds * float64(time.Second))
+			}
+			if v, _ := options.Object().Get("metadata"); v.IsObject() {
+				exported, _ := v.Export()
+				for name, value := range stringMap(exported) {
+					md.Set(name, value)
+				}
+			}
+		}
+		if exec.requestID != "" && len(md.Get("x-request-id")) == 0 {
+			md.Set("x-request-id", exec.requestID)
+		}
+
+		ctx, cancel := context.WithTimeout(exec.ctx, timeout)
+		defer cancel()
+		ctx = metadata.NewOutgoingContext(ctx, md)
+		response := dynamicpb.NewMessage(method.Output())
+		if err := target.conn.Invoke(ctx, "/"+service+"/"+string(method.Name()), request, response); err != nil {
+			st := status.Convert(err)
+			jsErr := call.Otto.MakeCustomError("Error", prefix+name+": "+st.Code().String()+": "+st.Message())
+			jsErr.Object().Set("code", int(st.Code()))
+			jsErr.Object().Set("status", st.Code().String())
+			panic(jsErr)
+		}
+
+		encoded, err := protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(response)
+		if err != nil {
+			throwError(call, prefix+err.Error())
+		}
+		var result interface{}
+		if err := json.Unmarshal(encoded, &result); err != nil {
+			throwError(call, prefix+err.Error())
+		}
+		return toValue(call, result)
+	})
+
+	return grpcObject
+}
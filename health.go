@@ -0,0 +1,143 @@
+package runbox
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	dependencyCheckTimeout = 2 * time.Second
+	// readinessCacheTTL spares dependencies a check per probe when several
+	// orchestrators or load balancers poll the same instance.
+	readinessCacheTTL = 5 * time.Second
+)
+
+// DependencyStatus is the last check of one external dependency.
+// LastSuccessAt survives failures, so it tells how long one has been down.
+type DependencyStatus struct {
+	OK            bool       `json:"ok"`
+	Required      bool       `json:"required"`
+	LatencyMs     float64    `json:"latencyMs"`
+	Error         string     `json:"error,omitempty"`
+	CheckedAt     time.Time  `json:"checkedAt"`
+	LastSuccessAt *time.Time `json:"lastSuccessAt,omitempty"`
+}
+
+type dependencyCheck struct {
+	name     string
+	required bool
+	check    func(ctx context.Context) error
+}
+
+// dependencies lists what this instance is configured to use. Only the
+// database is required; the rest degrade the features that need them.
+func (app *App) dependencies() []dependencyCheck {
+	checks := []dependencyCheck{{name: "database", required: true, check: app.pingDatabase}}
+	if app.config.Redis.Addr != "" {
+		checks = append(checks, dependencyCheck{name: "redis", check: app.triggers.drivers["redis"].(*redisDriver).ping})
+	}
+	if app.config.NATS.URL != "" {
+		checks = append(checks, dependencyCheck{name: "nats", check: app.triggers.drivers["nats"].(*natsDriver).ping})
+	}
+	if app.config.MQTT.Broker != "" {
+		checks = append(checks, dependencyCheck{name: "mqtt", check: app.triggers.drivers["mqtt"].(*mqttDriver).ping})
+	}
+	if app.config.Email.Provider == "smtp" {
+		checks = append(checks, dependencyCheck{name: "smtp", check: app.pingSMTP})
+	}
+	return checks
+}
+
+func (app *App) pingDatabase(ctx context.Context) error {
+	var one int
+	return app.db.QueryRowContext(ctx, `SELECT 1`).Scan(&one)
+}
+
+// healthTracker keeps the last status of every dependency.
+type healthTracker struct {
+	mu        sync.Mutex
+	statuses  map[string]*DependencyStatus
+	checkedAt time.Time
+}
+
+func newHealthTracker() *healthTracker {
+	return &healthTracker{statuses: map[string]*DependencyStatus{}}
+}
+
+// checkDependencies runs every check at once, each bounded by
+// dependencyCheckTimeout, unless the last round is recent enough.
+func (app *App) checkDependencies(ctx context.Context) map[string]DependencyStatus {
+	h := app.health
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if time.Since(h.checkedAt) > readinessCacheTTL {
+		checks := app.dependencies()
+		results := make([]DependencyStatus, len(checks))
+		var wg sync.WaitGroup
+		for i, dep := range checks {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ctx, cancel := context.WithTimeout(ctx, dependencyCheckTimeout)
+				defer cancel()
+				started := time.Now()
+				err := dep.check(ctx)
+				results[i] = DependencyStatus{OK: err == nil, Required: dep.required, LatencyMs: millis(time.Since(started)), CheckedAt: started.UTC()}
+				if err != nil {
+					results[i].Error = err.Error()
+				}
+			}()
+		}
+		wg.Wait()
+
+		statuses := map[string]*DependencyStatus{}
+		for i, dep := range checks {
+			s := results[i]
+			if s.OK {
+				s.LastSuccessAt = &s.CheckedAt
+			} else if prev, ok := h.statuses[dep.name]; ok {
+				s.LastSuccessAt = prev.LastSuccessAt
+			}
+			statuses[dep.name] = &s
+		}
+		h.statuses = statuses
+		h.checkedAt = time.Now()
+	}
+
+	out := make(map[string]DependencyStatus, len(h.statuses))
+	for name, s := range h.statuses {
+		out[name] = *s
+	}
+	return out
+}
+
+// livenessHandler serves GET /healthz: the process is up and serving.
+func (app *App) livenessHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// readinessHandler serves GET /readyz with the status of every configured
+// dependency. It answers 503 when a required one is down and 200 with
+// status "degraded" when only optional ones are; strict=true makes any
+// failure a 503.
+func (app *App) readinessHandler(c *gin.Context) {
+	deps := app.checkDependencies(c.Request.Context())
+
+	status, code := "ready", http.StatusOK
+	for _, d := range deps {
+		switch {
+		case d.OK:
+		case d.Required || c.Query("strict") == "true":
+			status, code = "unavailable", http.StatusServiceUnavailable
+		case code == http.StatusOK:
+			status = "degraded"
+		}
+	}
+
+	c.JSON(code, gin.H{"status": status, "instanceId": app.leader.instanceID, "dependencies": deps})
+}
@@ -0,0 +1,49 @@
+package runbox
+
+import (
+	"github.com/robertkrimen/otto"
+)
+
+// throwError raises a JavaScript Error from inside a native host function.
+func throwError(call otto.FunctionCall, message string) {
+	panic(call.Otto.MakeCustomError("Error", message))
+}
+
+// toValue converts a Go value for return to JavaScript, throwing on failure.
+func toValue(call otto.FunctionCall, v interface{}) otto.Value {
+	value, err := call.Otto.ToValue(v)
+	if err != nil {
+		throwError(call, err.Error())
+	}
+	return value
+}
+
+// installRunbox exposes the "runbox" global, the namespace for host APIs
+// that functions use to talk back to the platform.
+func (app *App) installRunbox(vm *otto.Otto, exec *execution) {
+	runbox, _ := vm.Object(`({})`)
+
+	runbox.Set("schedule", func(call otto.FunctionCall) otto.Value {
+		return app.jsSchedule(call, exec)
+	})
+	runbox.Set("events", app.jsEvents(vm, exec))
+	runbox.Set("email", app.jsEmail(vm, exec))
+	runbox.Set("notify", app.jsNotify(vm, exec))
+	runbox.Set("ai", app.jsAI(vm, exec))
+	runbox.Set("render", app.jsRender)
+	runbox.Set("pdf", jsPDF)
+	runbox.Set("parse", jsParse(vm))
+	runbox.Set("stringify", jsStringify(vm))
+	runbox.Set("kv", app.jsKV(vm, exec))
+	runbox.Set("cache", app.jsCache(vm, exec))
+	runbox.Set("storage", app.jsStorage(vm, exec))
+	runbox.Set("db", app.jsExternalDB(exec))
+	runbox.Set("grpc", app.jsGRPC(vm, exec))
+	runbox.Set("geoip", app.jsGeoIP)
+	runbox.Set("secrets", app.jsSecrets(vm))
+	runbox.Set("jwt", app.jsJWT(vm))
+	runbox.Set("flags", app.jsFlags(vm))
+	installPlugins(vm, runbox, exec)
+
+	vm.Set("runbox", runbox)
+}
@@ -0,0 +1,215 @@
+package runbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxImportBytes = 1 << 20
+	importTimeout  = 10 * time.Second
+)
+
+var (
+	gistPattern       = regexp.MustCompile(`^https://gist\.github\.com/(?:[\w.-]+/)?([0-9a-fA-F]+)/?$`)
+	githubBlobPattern = regexp.MustCompile(`^https://github\.com/([\w.-]+)/([\w.-]+)/blob/(.+)$`)
+	importNameCleaner = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+var importClient = &http.Client{Timeout: importTimeout}
+
+// importedSource is code fetched from a URL, with the file name it had
+// there.
+type importedSource struct {
+	Filename string
+	Code     string
+}
+
+// fetchImport downloads code from a raw URL, a GitHub file page, or a gist,
+// taking the gist's first .js file (or its first file when it has none).
+func fetchImport(ctx context.Context, rawURL string) (*importedSource, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("url must be an http or https URL")
+	}
+
+	if m := gistPattern.FindStringSubmatch(rawURL); m != nil {
+		return fetchGist(ctx, m[1])
+	}
+	if m := githubBlobPattern.FindStringSubmatch(rawURL); m != nil {
+		rawURL = "https://raw.githubusercontent.com/" + m[1] + "/" + m[2] + "/" + m[3]
+	}
+	body, err := fetchImportBody(ctx, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return &importedSource{Filename: path.Base(u.Path), Code: string(body)}, nil
+}
+
+func fetchImportBody(ctx context.Context, rawURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := importClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s answered %s", rawURL, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxImportBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxImportBytes {
+		return nil, fmt.Errorf("%s is larger than 1 MiB", rawURL)
+	}
+	return body, nil
+}
+
+func fetchGist(ctx context.Context, id string) (*importedSource, error) {
+	body, err := fetchImportBody(ctx, "https://api.github.com/gists/"+id)
+	if err != nil {
+		return nil, err
+	}
+	var gist struct {
+		Files map[string]struct {
+			Filename  string `json:"filename"`
+			Content   string `json:"content"`
+			Truncated bool   `json:"truncated"`
+			RawURL    string `json:"raw_url"`
+		} `json:"files"`
+	}
+	if err := json.Unmarshal(body, &gist); err != nil {
+		return nil, fmt.Errorf("unexpected gist response: %v", err)
+	}
+	if len(gist.Files) == 0 {
+		return nil, fmt.Errorf("gist %s has no files", id)
+	}
+
+	names := make([]string, 0, len(gist.Files))
+	for name := range gist.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pick := names[0]
+	for _, name := range names {
+		if strings.HasSuffix(name, ".js") {
+			pick = name
+			break
+		}
+	}
+
+	file := gist.Files[pick]
+	if file.Truncated {
+		content, err := fetchImportBody(ctx, file.RawURL)
+		if err != nil {
+			return nil, err
+		}
+		file.Content = string(content)
+	}
+	return &importedSource{Filename: file.Filename, Code: file.Content}, nil
+}
+
+// importName suggests a function name and path from a file name:
+// send-invoice.js becomes "send-invoice" at /send-invoice.
+func importName(filename string) (string, string) {
+	name := strings.TrimSuffix(filename, path.Ext(filename))
+	slug := strings.Trim(importNameCleaner.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if slug == "" {
+		slug = "imported"
+	}
+	if name == "" {
+		name = slug
+	}
+	return name, "/" + slug
+}
+
+// importURLHandler serves POST /api/functions/import with {url}. It only
+// fetches the code, for the create form to fill in; nothing is saved.
+func (app *App) importURLHandler(c *gin.Context) {
+	var in struct {
+		URL string `json:"url"`
+	}
+	if err := c.ShouldBindJSON(&in); err != nil || in.URL == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "url is required"})
+		return
+	}
+
+	src, err := fetchImport(c.Request.Context(), in.URL)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to import: " + err.Error()})
+		return
+	}
+	name, functionPath := importName(src.Filename)
+	diagnostics := validateFunctionCode(src.Code, ModeStandard)
+	if diagnostics == nil {
+		diagnostics = []CodeDiagnostic{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"name":      name,
+		"path":      functionPath,
+		"code":      src.Code,
+		"sourceUrl": in.URL,
+		"errors":    diagnostics,
+	})
+}
+
+// resyncFunction serves POST /api/functions/:id/resync: it fetches the code
+// again from the function's source URL and saves it as a new version when
+// it changed.
+func (app *App) resyncFunction(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+	before, err := app.getFunctionByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Function not found"})
+		return
+	}
+	if before.SourceURL == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Function was not imported from a URL"})
+		return
+	}
+
+	src, err := fetchImport(c.Request.Context(), before.SourceURL)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to re-sync: " + err.Error()})
+		return
+	}
+	if src.Code == before.Code {
+		c.JSON(http.StatusOK, gin.H{"changed": false, "version": before.Version})
+		return
+	}
+	if diagnostics := validateFunctionCode(src.Code, before.Mode); diagnostics != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "The source has a syntax error at " + diagnostics[0].String(), "errors": diagnostics})
+		return
+	}
+
+	function := *before
+	function.Code = src.Code
+	if err := app.saveFunction(&function); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save function: " + err.Error()})
+		return
+	}
+
+	app.recordAudit(app.auditOrigin(c), AuditFunctionUpdated, before, &function)
+	c.JSON(http.StatusOK, gin.H{"changed": true, "version": function.Version})
+}
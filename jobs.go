@@ -0,0 +1,674 @@
+package runbox
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	JobScheduled = "scheduled"
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+const (
+	JobSourceAsync    = "async"
+	JobSourceSchedule = "schedule"
+	JobSourceDelayed  = "delayed"
+	JobSourceTrigger  = "trigger"
+)
+
+const (
+	jobWorkers   = 4
+	jobQueueSize = 256
+	// maxJobAttempts caps X-Runbox-Max-Attempts, so one call can't keep a
+	// worker busy with retries indefinitely.
+	maxJobAttempts = 10
+	// jobCancelPoll is how often a clustered worker checks the jobs table
+	// for a cancellation made on another instance.
+	jobCancelPoll = time.Second
+)
+
+type Job struct {
+	ID          string          `json:"id"`
+	FunctionID  int             `json:"functionId"`
+	Version     int             `json:"version,omitempty"`
+	Path        string          `json:"path"`
+	Method      string          `json:"method"`
+	Source      string          `json:"source"`
+	ScheduleID  int             `json:"scheduleId,omitempty"`
+	RunAt       *time.Time      `json:"runAt,omitempty"`
+	Status      string          `json:"status"`
+	Attempt     int             `json:"attempt"`
+	MaxAttempts int             `json:"maxAttempts"`
+	Request     json.RawMessage `json:"request,omitempty"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	Logs        []string        `json:"logs"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	StartedAt   *time.Time      `json:"startedAt,omitempty"`
+	FinishedAt  *time.Time      `json:"finishedAt,omitempty"`
+	DurationMs  *int64          `json:"durationMs,omitempty"`
+	// CancelRequested is set while a running job is being interrupted.
+	CancelRequested bool `json:"cancelRequested,omitempty"`
+}
+
+type jobQueue struct {
+	mu      sync.Mutex
+	running map[string]context.CancelFunc
+	pending chan string
+}
+
+func newJobQueue() *jobQueue {
+	return &jobQueue{
+		running: map[string]context.CancelFunc{},
+		pending: make(chan string, jobQueueSize),
+	}
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (app *App) initJobsTable() error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS jobs (
+		id TEXT PRIMARY KEY,
+		function_id INTEGER NOT NULL,
+		version INTEGER,
+		path TEXT NOT NULL,
+		method TEXT NOT NULL,
+		source TEXT NOT NULL,
+		status TEXT NOT NULL,
+		request TEXT,
+		result TEXT,
+		error TEXT,
+		logs TEXT,
+		created_at DATETIME NOT NULL,
+		started_at DATETIME,
+		finished_at DATETIME
+	);
+	CREATE INDEX IF NOT EXISTS idx_jobs_status ON jobs (status);
+	CREATE INDEX IF NOT EXISTS idx_jobs_function ON jobs (function_id, created_at);`
+
+	if _, err := app.db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create jobs table: %v", err)
+	}
+
+	return app.addColumns("jobs", [][2]string{
+		{"schedule_id", "INTEGER"},
+		{"run_at", "DATETIME"},
+		{"attempt", "INTEGER NOT NULL DEFAULT 1"},
+		{"max_attempts", "INTEGER NOT NULL DEFAULT 1"},
+		{"worker", "TEXT"},
+		{"attempt_errors", "TEXT"},
+		{"cancel_requested", "INTEGER NOT NULL DEFAULT 0"},
+	})
+}
+
+// startJobWorkers launches the worker pool and re-dispatches jobs left behind
+// by a previous process. Jobs that were mid-flight cannot be resumed; they
+// count as a failed attempt and are retried if they have attempts left. In a
+// cluster the leader does this once the owning instance's lease runs out.
+func (app *App) startJobWorkers() {
+	if !app.leader.clustered {
+		app.recoverOrphanedWork(time.Now().UTC())
+	}
+
+	for i := 0; i < jobWorkers; i++ {
+		app.goBackground(func() {
+			for {
+				select {
+				case id := <-app.jobs.pending:
+					app.vmStats.busyWorkers.Add(1)
+					app.runJob(id)
+					app.vmStats.busyWorkers.Add(-1)
+				case <-app.ctx.Done():
+					return
+				}
+			}
+		})
+	}
+
+	rows, err := app.db.Query(`SELECT id FROM jobs WHERE status = ? ORDER BY created_at`, JobQueued)
+	if err != nil {
+		log.Println("Failed to load queued jobs:", err)
+		return
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	app.goBackground(func() {
+		for _, id := range ids {
+			select {
+			case app.jobs.pending <- id:
+			case <-app.ctx.Done():
+				return
+			}
+		}
+	})
+}
+
+// jobOptions describes where a job came from and, for delayed jobs, when it
+// should run. A zero RunAt runs the job as soon as a worker is free.
+type jobOptions struct {
+	Source      string
+	ScheduleID  int
+	RunAt       time.Time
+	MaxAttempts int
+}
+
+// enqueueJob stores a job and hands it to the workers, or to the scheduler
+// when the queue is full.
+func (app *App) enqueueJob(function *Function, requestData map[string]interface{}, opts jobOptions) (*Job, error) {
+	requestJSON, err := json.Marshal(requestData)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &Job{
+		ID:          newID(),
+		FunctionID:  function.ID,
+		Path:        function.Path,
+		Method:      strings.ToUpper(requestData["method"].(string)),
+		Source:      opts.Source,
+		ScheduleID:  opts.ScheduleID,
+		Status:      JobQueued,
+		Attempt:     1,
+		MaxAttempts: opts.MaxAttempts,
+		Request:     requestJSON,
+		Logs:        []string{},
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	if job.MaxAttempts < 1 {
+		job.MaxAttempts = max(app.config.Queue.MaxAttempts, 1)
+	}
+
+	var runAt sql.NullTime
+	if !opts.RunAt.IsZero() {
+		at := opts.RunAt.UTC()
+		job.RunAt = &at
+		runAt = sql.NullTime{Time: at, Valid: true}
+		if at.After(job.CreatedAt) {
+			job.Status = JobScheduled
+		}
+	}
+
+	var scheduleID sql.NullInt64
+	if job.ScheduleID != 0 {
+		scheduleID = sql.NullInt64{Int64: int64(job.ScheduleID), Valid: true}
+	}
+
+	query := `INSERT INTO jobs (id, function_id, path, method, source, schedule_id, run_at, status, attempt, max_attempts, request, worker, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err = app.db.Exec(query, job.ID, job.FunctionID, job.Path, job.Method, job.Source, scheduleID, runAt, job.Status,
+		job.Attempt, job.MaxAttempts, string(requestJSON), app.leader.instanceID, job.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if job.Status == JobScheduled {
+		return job, nil
+	}
+
+	select {
+	case app.jobs.pending <- job.ID:
+	default:
+		// The workers are behind; the row stays and dispatchDueJobs hands
+		// the job over once there is room.
+		at := job.CreatedAt
+		job.Status, job.RunAt = JobScheduled, &at
+		if _, err := app.db.Exec(`UPDATE jobs SET status = ?, run_at = ? WHERE id = ? AND status = ?`, JobScheduled, at, job.ID, JobQueued); err != nil {
+			return nil, err
+		}
+	}
+	return job, nil
+}
+
+func (app *App) runJob(id string) {
+	started := time.Now().UTC()
+
+	// Claiming the job with a conditional update makes cancellation of a
+	// queued job race-free: whoever flips the status first wins.
+	result, err := app.db.Exec(`UPDATE jobs SET status = ?, started_at = ?, worker = ? WHERE id = ? AND status = ?`,
+		JobRunning, started, app.leader.instanceID, id, JobQueued)
+	if err != nil {
+		log.Printf("Failed to start job %s: %v", id, err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return
+	}
+
+	job, err := app.getJob(id)
+	if err != nil {
+		log.Printf("Failed to load job %s: %v", id, err)
+		return
+	}
+
+	function, err := app.getFunctionByID(job.FunctionID)
+	if err != nil {
+		app.finishJob(job, JobFailed, nil, "function no longer exists", nil)
+		return
+	}
+	app.db.Exec(`UPDATE jobs SET version = ? WHERE id = ?`, function.Version, id)
+
+	var requestData map[string]interface{}
+	if err := json.Unmarshal(job.Request, &requestData); err != nil {
+		app.finishJob(job, JobFailed, nil, "invalid stored request: "+err.Error(), nil)
+		return
+	}
+
+	timeout := app.live().jobTimeout()
+	ctx, cancel := context.WithTimeout(app.ctx, timeout)
+	app.jobs.mu.Lock()
+	app.jobs.running[id] = cancel
+	app.jobs.mu.Unlock()
+	defer func() {
+		app.jobs.mu.Lock()
+		delete(app.jobs.running, id)
+		app.jobs.mu.Unlock()
+		cancel()
+	}()
+	if app.leader.clustered {
+		app.goBackground(func() { app.watchJobCancel(ctx, id, cancel) })
+	}
+
+	exec := newExecution(ctx, function, requestData)
+	exec.job = job
+	exec.source = job.Source
+	value, err := app.executeJavaScript(exec)
+
+	switch {
+	case errors.Is(err, errExecutionCancelled) && app.stopping(ctx):
+		// Closing the server interrupts the attempt; it counts as failed
+		// like one cut short by a restart.
+		app.failJob(job, "interrupted: its instance stopped", exec.logs)
+	case errors.Is(err, errExecutionCancelled) && ctx.Err() == context.DeadlineExceeded:
+		log.Printf("Async job %s for %s timed out after %s (attempt %d/%d)", id, job.Path, timeout, job.Attempt, job.MaxAttempts)
+		app.failJob(job, "job timed out after "+timeout.String(), exec.logs)
+	case errors.Is(err, errExecutionCancelled):
+		app.finishJob(job, JobCancelled, nil, err.Error(), exec.logs)
+	case err != nil:
+		log.Printf("Async job %s for %s failed (attempt %d/%d): %v", id, job.Path, job.Attempt, job.MaxAttempts, err)
+		app.failJob(job, err.Error(), exec.logs)
+	default:
+		app.finishJob(job, JobSucceeded, value, "", exec.logs)
+	}
+}
+
+// watchJobCancel interrupts a running job once another instance has asked
+// for it to be cancelled through the jobs table.
+func (app *App) watchJobCancel(ctx context.Context, id string, cancel context.CancelFunc) {
+	ticker := time.NewTicker(jobCancelPoll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		var requested bool
+		err := app.db.QueryRow(`SELECT cancel_requested FROM jobs WHERE id = ?`, id).Scan(&requested)
+		if err == nil && requested {
+			cancel()
+			return
+		}
+	}
+}
+
+func (app *App) finishJob(job *Job, status string, value interface{}, errMsg string, logs []string) {
+	var resultJSON sql.NullString
+	if value != nil {
+		if b, err := json.Marshal(value); err == nil {
+			resultJSON = sql.NullString{String: string(b), Valid: true}
+		} else {
+			status, errMsg = JobFailed, "failed to serialize result: "+err.Error()
+		}
+	}
+
+	if logs == nil {
+		logs = []string{}
+	}
+	logsJSON, _ := json.Marshal(logs)
+
+	query := `UPDATE jobs SET status = ?, result = ?, error = ?, logs = ?, finished_at = ? WHERE id = ?`
+	_, err := app.db.Exec(query, status, resultJSON, errMsg, string(logsJSON), time.Now().UTC(), job.ID)
+	if err != nil {
+		log.Printf("Failed to record result of job %s: %v", job.ID, err)
+	}
+}
+
+// failJob handles a failed attempt. A job with attempts left goes back to
+// scheduled with an exponential backoff; otherwise it fails for good and is
+// moved to the dead-letter queue.
+func (app *App) failJob(job *Job, errMsg string, logs []string) {
+	app.recordAttemptError(job, errMsg)
+	if job.Attempt < job.MaxAttempts {
+		logsJSON, _ := json.Marshal(logs)
+		retryAt := time.Now().UTC().Add(app.config.Queue.backoff(job.Attempt))
+		query := `UPDATE jobs SET status = ?, attempt = attempt + 1, run_at = ?, error = ?, logs = ?, started_at = NULL WHERE id = ?`
+		if _, err := app.db.Exec(query, JobScheduled, retryAt, errMsg, string(logsJSON), job.ID); err != nil {
+			log.Printf("Failed to schedule retry of job %s: %v", job.ID, err)
+		}
+		return
+	}
+
+	app.finishJob(job, JobFailed, nil, errMsg, logs)
+	if err := app.deadLetter(job, errMsg); err != nil {
+		log.Printf("Failed to dead-letter job %s: %v", job.ID, err)
+	}
+}
+
+const jobColumns = `id, function_id, version, path, method, source, schedule_id, run_at, status, attempt, max_attempts, request, result, error, logs, created_at, started_at, finished_at, cancel_requested`
+
+func scanJob(row rowScanner) (*Job, error) {
+	var (
+		j                       Job
+		version, scheduleID     sql.NullInt64
+		request, result, errMsg sql.NullString
+		logs                    sql.NullString
+		runAt                   sql.NullTime
+		startedAt, finishedAt   sql.NullTime
+	)
+	err := row.Scan(&j.ID, &j.FunctionID, &version, &j.Path, &j.Method, &j.Source, &scheduleID, &runAt, &j.Status,
+		&j.Attempt, &j.MaxAttempts, &request, &result, &errMsg, &logs, &j.CreatedAt, &startedAt, &finishedAt, &j.CancelRequested)
+	if err != nil {
+		return nil, err
+	}
+
+	j.Version = int(version.Int64)
+	j.ScheduleID = int(scheduleID.Int64)
+	if request.Valid {
+		j.Request = json.RawMessage(request.String)
+	}
+	if result.Valid {
+		j.Result = json.RawMessage(result.String)
+	}
+	j.Error = errMsg.String
+	j.Logs = []string{}
+	if logs.Valid {
+		json.Unmarshal([]byte(logs.String), &j.Logs)
+	}
+	if runAt.Valid {
+		j.RunAt = &runAt.Time
+	}
+	if startedAt.Valid {
+		j.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		j.FinishedAt = &finishedAt.Time
+	}
+	if j.StartedAt != nil && j.FinishedAt != nil {
+		ms := j.FinishedAt.Sub(*j.StartedAt).Milliseconds()
+		j.DurationMs = &ms
+	}
+
+	return &j, nil
+}
+
+func (app *App) getJob(id string) (*Job, error) {
+	query := `SELECT ` + jobColumns + ` FROM jobs WHERE id = ?`
+	return scanJob(app.db.QueryRow(query, id))
+}
+
+type jobFilter struct {
+	Status     string
+	FunctionID int
+	Source     string
+	ScheduleID int
+	Limit      int
+	Offset     int
+}
+
+func (app *App) listJobs(filter jobFilter) ([]Job, error) {
+	query := `SELECT ` + jobColumns + ` FROM jobs`
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	if filter.Status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, filter.Status)
+	}
+	if filter.FunctionID != 0 {
+		conditions = append(conditions, "function_id = ?")
+		args = append(args, filter.FunctionID)
+	}
+	if filter.Source != "" {
+		conditions = append(conditions, "source = ?")
+		args = append(args, filter.Source)
+	}
+	if filter.ScheduleID != 0 {
+		conditions = append(conditions, "schedule_id = ?")
+		args = append(args, filter.ScheduleID)
+	}
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY created_at DESC LIMIT ? OFFSET ?"
+	args = append(args, filter.Limit, filter.Offset)
+
+	rows, err := app.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []Job{}
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *j)
+	}
+
+	return jobs, nil
+}
+
+// dispatchDueJobs hands delayed jobs whose run time has arrived to the
+// worker pool. It never waits on a full queue, which would stall the
+// scheduler tick: jobs that don't fit stay scheduled for the next tick.
+func (app *App) dispatchDueJobs(now time.Time) {
+	rows, err := app.db.Query(`SELECT id FROM jobs WHERE status = ? AND run_at <= ? ORDER BY run_at`, JobScheduled, now)
+	if err != nil {
+		log.Println("Failed to load due jobs:", err)
+		return
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		if len(app.jobs.pending) == cap(app.jobs.pending) {
+			return
+		}
+		result, err := app.db.Exec(`UPDATE jobs SET status = ?, worker = ? WHERE id = ? AND status = ?`,
+			JobQueued, app.leader.instanceID, id, JobScheduled)
+		if err != nil {
+			continue
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			continue
+		}
+		select {
+		case app.jobs.pending <- id:
+		default:
+			app.db.Exec(`UPDATE jobs SET status = ? WHERE id = ? AND status = ?`, JobScheduled, id, JobQueued)
+			return
+		}
+	}
+}
+
+// cancelJob stops a queued or delayed job before it starts or interrupts a
+// running one. A job running on another instance is flagged in the jobs
+// table, and its worker interrupts it on the next poll. It reports false
+// when the job has already finished.
+func (app *App) cancelJob(id string) (bool, error) {
+	result, err := app.db.Exec(`UPDATE jobs SET status = ?, error = ?, finished_at = ? WHERE id = ? AND status IN (?, ?)`,
+		JobCancelled, errExecutionCancelled.Error(), time.Now().UTC(), id, JobQueued, JobScheduled)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		return true, nil
+	}
+
+	result, err = app.db.Exec(`UPDATE jobs SET cancel_requested = 1 WHERE id = ? AND status = ?`, id, JobRunning)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return false, nil
+	}
+
+	app.jobs.mu.Lock()
+	cancel, ok := app.jobs.running[id]
+	app.jobs.mu.Unlock()
+	if ok {
+		cancel()
+	}
+	return true, nil
+}
+
+func (app *App) executeFunctionAsync(c *gin.Context) {
+	path := c.Param("path")
+
+	function, subpath, err := app.resolveFunction(c.Request.Context(), path)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Function not found"})
+		return
+	}
+	if !app.checkAccess(c, function) {
+		return
+	}
+	if !app.checkQuota(c, function) {
+		return
+	}
+
+	requestData := buildRequestData(c)
+	requestData["subpath"] = subpath
+
+	opts := jobOptions{Source: JobSourceAsync}
+	if v := c.GetHeader("X-Runbox-Max-Attempts"); v != "" {
+		attempts, err := strconv.Atoi(v)
+		if err != nil || attempts < 1 || attempts > maxJobAttempts {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("X-Runbox-Max-Attempts must be between 1 and %d", maxJobAttempts)})
+			return
+		}
+		opts.MaxAttempts = attempts
+	}
+
+	job, err := app.enqueueJob(function, requestData, opts)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to enqueue execution", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"jobId":    job.ID,
+		"status":   job.Status,
+		"function": function.Name,
+	})
+}
+
+func (app *App) getJobHandler(c *gin.Context) {
+	job, err := app.getJob(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+func (app *App) listJobsHandler(c *gin.Context) {
+	filter := jobFilter{
+		Status: c.Query("status"),
+		Source: c.Query("source"),
+		Limit:  50,
+	}
+
+	if v := c.Query("function_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function_id"})
+			return
+		}
+		filter.FunctionID = id
+	}
+	if v := c.Query("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > 500 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 500"})
+			return
+		}
+		filter.Limit = limit
+	}
+	if v := c.Query("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+			return
+		}
+		filter.Offset = offset
+	}
+
+	jobs, err := app.listJobs(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list jobs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"jobs": jobs})
+}
+
+func (app *App) cancelJobHandler(c *gin.Context) {
+	id := c.Param("id")
+	if _, err := app.getJob(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	cancelled, err := app.cancelJob(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel job"})
+		return
+	}
+	if !cancelled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Job has already finished"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Job cancelled"})
+}
@@ -0,0 +1,230 @@
+package runbox
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/robertkrimen/otto"
+)
+
+const minJWTHMACKeyBytes = 32
+
+// jwtKey is a secret parsed for runbox.jwt: an HMAC key, or a PEM private
+// key, public key or certificate. Only private and HMAC keys can sign.
+type jwtKey struct {
+	signing    interface{}
+	verifying  interface{}
+	algorithms []string // the first is the default for signing
+}
+
+func parseJWTKey(value string) (*jwtKey, error) {
+	value = strings.TrimSpace(value)
+	if !strings.HasPrefix(value, "-----BEGIN") {
+		if len(value) < minJWTHMACKeyBytes {
+			return nil, fmt.Errorf("HMAC keys must be at least %d bytes", minJWTHMACKeyBytes)
+		}
+		return &jwtKey{signing: []byte(value), verifying: []byte(value), algorithms: []string{"HS256", "HS384", "HS512"}}, nil
+	}
+
+	block, _ := pem.Decode([]byte(value))
+	if block == nil {
+		return nil, errors.New("the key is not valid PEM")
+	}
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			key = cert.PublicKey
+		}
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	k := &jwtKey{}
+	if signer, ok := key.(crypto.Signer); ok {
+		k.signing, key = signer, signer.Public()
+	}
+	k.verifying = key
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		k.algorithms = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512"}
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P256():
+			k.algorithms = []string{"ES256"}
+		case elliptic.P384():
+			k.algorithms = []string{"ES384"}
+		case elliptic.P521():
+			k.algorithms = []string{"ES512"}
+		default:
+			return nil, errors.New("unsupported elliptic curve")
+		}
+	case ed25519.PublicKey:
+		k.algorithms = []string{"EdDSA"}
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	return k, nil
+}
+
+func (app *App) jwtKey(name string) (*jwtKey, error) {
+	value, ok, err := app.secretValue(name)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("no secret named %s", name)
+	}
+	key, err := parseJWTKey(value)
+	if err != nil {
+		return nil, fmt.Errorf("secret %s: %v", name, err)
+	}
+	return key, nil
+}
+
+// jsJWT exposes runbox.jwt, which signs and verifies JSON Web Tokens with
+// keys from the secrets store. The key decides the algorithms: HS256 by
+// default for an HMAC key, RS256 for RSA, ES256 to ES512 for the curve of
+// an EC key and EdDSA for Ed25519. verify only accepts those, so a token
+// can't pick a weaker one.
+func (app *App) jsJWT(vm *otto.Otto) *otto.Object {
+	jwtObject, _ := vm.Object(`({})`)
+
+	options := func(call otto.FunctionCall, prefix string) *otto.Object {
+		arg := call.Argument(2)
+		if !arg.IsDefined() || arg.IsNull() {
+			return nil
+		}
+		if !arg.IsObject() {
+			throwError(call, prefix+"options must be an object")
+		}
+		return arg.Object()
+	}
+	seconds := func(call otto.FunctionCall, prefix string, opts *otto.Object, name string) (time.Duration, bool) {
+		if opts == nil {
+			return 0, false
+		}
+		v, _ := opts.Get(name)
+		if !v.IsDefined() {
+			return 0, false
+		}
+		n, err := v.ToInteger()
+		if err != nil || n < 0 {
+			throwError(call, prefix+name+" must be a number of seconds")
+		}
+		return time.Duration(n) * time.Second, true
+	}
+	str := func(opts *otto.Object, name string) string {
+		if opts == nil {
+			return ""
+		}
+		if v, _ := opts.Get(name); v.IsString() {
+			return v.String()
+		}
+		return ""
+	}
+
+	jwtObject.Set("sign", func(call otto.FunctionCall) otto.Value {
+		const prefix = "runbox.jwt.sign: "
+		if !call.Argument(0).IsObject() {
+			throwError(call, prefix+"claims must be an object")
+		}
+		key, err := app.jwtKey(call.Argument(1).String())
+		if err != nil {
+			throwError(call, prefix+err.Error())
+		}
+		if key.signing == nil {
+			throwError(call, prefix+"a public key can only verify; sign with the private key")
+		}
+		opts := options(call, prefix)
+
+		exported, _ := call.Argument(0).Export()
+		encoded, err := json.Marshal(exported)
+		if err != nil {
+			throwError(call, prefix+"claims must be JSON-serializable")
+		}
+		claims := jwt.MapClaims{}
+		json.Unmarshal(encoded, &claims)
+		now := time.Now()
+		if _, ok := claims["iat"]; !ok {
+			claims["iat"] = now.Unix()
+		}
+		if expiresIn, ok := seconds(call, prefix, opts, "expiresIn"); ok {
+			claims["exp"] = now.Add(expiresIn).Unix()
+		}
+
+		algorithm := key.algorithms[0]
+		if a := str(opts, "algorithm"); a != "" {
+			if !slices.Contains(key.algorithms, a) {
+				throwError(call, prefix+"this key signs with "+strings.Join(key.algorithms, ", "))
+			}
+			algorithm = a
+		}
+		token := jwt.NewWithClaims(jwt.GetSigningMethod(algorithm), claims)
+		if kid := str(opts, "keyId"); kid != "" {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString(key.signing)
+		if err != nil {
+			throwError(call, prefix+err.Error())
+		}
+		return toValue(call, signed)
+	})
+
+	jwtObject.Set("verify", func(call otto.FunctionCall) otto.Value {
+		const prefix = "runbox.jwt.verify: "
+		key, err := app.jwtKey(call.Argument(1).String())
+		if err != nil {
+			throwError(call, prefix+err.Error())
+		}
+		opts := options(call, prefix)
+
+		parserOptions := []jwt.ParserOption{jwt.WithValidMethods(key.algorithms), jwt.WithIssuedAt()}
+		if leeway, ok := seconds(call, prefix, opts, "leeway"); ok {
+			parserOptions = append(parserOptions, jwt.WithLeeway(leeway))
+		}
+		if issuer := str(opts, "issuer"); issuer != "" {
+			parserOptions = append(parserOptions, jwt.WithIssuer(issuer))
+		}
+		if audience := str(opts, "audience"); audience != "" {
+			parserOptions = append(parserOptions, jwt.WithAudience(audience))
+		}
+		claims := jwt.MapClaims{}
+		_, err = jwt.ParseWithClaims(call.Argument(0).String(), claims, func(*jwt.Token) (interface{}, error) {
+			return key.verifying, nil
+		}, parserOptions...)
+		if err != nil {
+			throwError(call, prefix+err.Error())
+		}
+		return toValue(call, map[string]interface{}(claims))
+	})
+
+	return jwtObject
+}
@@ -0,0 +1,117 @@
+package runbox
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/robertkrimen/otto"
+)
+
+// maxKVValueBytes bounds a single stored value; the store is for small
+// state such as records and counters, not files.
+const maxKVValueBytes = 256 << 10
+
+func (app *App) initKVTable() error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS kv_entries (
+		function_id INTEGER NOT NULL REFERENCES functions(id) ON DELETE CASCADE,
+		key TEXT NOT NULL,
+		value TEXT NOT NULL,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (function_id, key)
+	);`
+
+	if _, err := app.db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create kv_entries table: %v", err)
+	}
+	return nil
+}
+
+// jsKV exposes runbox.kv, a key-value store private to each function.
+// Values are stored as JSON, so anything JSON.stringify accepts round-trips.
+func (app *App) jsKV(vm *otto.Otto, exec *execution) *otto.Object {
+	kv, _ := vm.Object(`({})`)
+
+	key := func(call otto.FunctionCall, name string) string {
+		if exec.function.ID == 0 {
+			throwError(call, "runbox.kv."+name+": save the function before using its store")
+		}
+		k := call.Argument(0).String()
+		if !call.Argument(0).IsString() || k == "" {
+			throwError(call, "runbox.kv."+name+": key must be a non-empty string")
+		}
+		return k
+	}
+
+	kv.Set("get", func(call otto.FunctionCall) otto.Value {
+		k := key(call, "get")
+		var value string
+		err := app.db.QueryRowContext(exec.ctx, `SELECT value FROM kv_entries WHERE function_id = ? AND key = ?`, exec.function.ID, k).Scan(&value)
+		if err == sql.ErrNoRows {
+			return otto.NullValue()
+		}
+		if err != nil {
+			throwError(call, "runbox.kv.get: "+err.Error())
+		}
+		var decoded interface{}
+		json.Unmarshal([]byte(value), &decoded)
+		return toValue(call, decoded)
+	})
+
+	kv.Set("set", func(call otto.FunctionCall) otto.Value {
+		k := key(call, "set")
+		exported, _ := call.Argument(1).Export()
+		value, err := json.Marshal(exported)
+		if err != nil || !call.Argument(1).IsDefined() {
+			throwError(call, "runbox.kv.set: value must be JSON-serializable")
+		}
+		if len(value) > maxKVValueBytes {
+			throwError(call, "runbox.kv.set: value is larger than 256 KiB")
+		}
+		_, err = app.db.ExecContext(exec.ctx, `INSERT INTO kv_entries (function_id, key, value) VALUES (?, ?, ?)
+			ON CONFLICT (function_id, key) DO UPDATE SET value = excluded.value, updated_at = CURRENT_TIMESTAMP`,
+			exec.function.ID, k, string(value))
+		if err != nil {
+			throwError(call, "runbox.kv.set: "+err.Error())
+		}
+		return otto.UndefinedValue()
+	})
+
+	kv.Set("delete", func(call otto.FunctionCall) otto.Value {
+		k := key(call, "delete")
+		res, err := app.db.ExecContext(exec.ctx, `DELETE FROM kv_entries WHERE function_id = ? AND key = ?`, exec.function.ID, k)
+		if err != nil {
+			throwError(call, "runbox.kv.delete: "+err.Error())
+		}
+		n, _ := res.RowsAffected()
+		return toValue(call, n > 0)
+	})
+
+	// list returns the keys starting with an optional prefix, in order.
+	kv.Set("list", func(call otto.FunctionCall) otto.Value {
+		if exec.function.ID == 0 {
+			throwError(call, "runbox.kv.list: save the function before using its store")
+		}
+		prefix := ""
+		if arg := call.Argument(0); arg.IsDefined() && !arg.IsNull() {
+			prefix = arg.String()
+		}
+		rows, err := app.db.QueryContext(exec.ctx, `SELECT key FROM kv_entries WHERE function_id = ? AND substr(key, 1, length(?)) = ? ORDER BY key`,
+			exec.function.ID, prefix, prefix)
+		if err != nil {
+			throwError(call, "runbox.kv.list: "+err.Error())
+		}
+		defer rows.Close()
+		keys := []string{}
+		for rows.Next() {
+			var k string
+			if rows.Scan(&k) == nil {
+				keys = append(keys, k)
+			}
+		}
+		return toValue(call, keys)
+	})
+
+	return kv
+}
@@ -0,0 +1,190 @@
+package runbox
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robertkrimen/otto"
+)
+
+// stringMap normalizes request maps that may have round-tripped through JSON
+// (map[string]interface{}) back to plain string maps.
+func stringMap(v interface{}) map[string]string {
+	out := map[string]string{}
+	switch m := v.(type) {
+	case map[string]string:
+		for k, val := range m {
+			out[k] = val
+		}
+	case map[string]interface{}:
+		for k, val := range m {
+			out[k] = fmt.Sprint(val)
+		}
+	}
+	return out
+}
+
+func multiValue(m map[string]string) map[string][]string {
+	out := make(map[string][]string, len(m))
+	for k, v := range m {
+		out[k] = []string{v}
+	}
+	return out
+}
+
+// lambdaEvent shapes a request like an API Gateway REST (payload v1.0) proxy
+// event, which is what most Lambda HTTP handlers are written against. path
+// is the path the request came in on, which on a wildcard mount is not the
+// function's own.
+func lambdaEvent(exec *execution) map[string]interface{} {
+	requestData := exec.request
+	method := strings.ToUpper(fmt.Sprint(requestData["method"]))
+	headers := stringMap(requestData["headers"])
+	query := stringMap(requestData["query"])
+	body, _ := requestData["rawBody"].(string)
+	path, _ := requestData["path"].(string)
+	if path == "" {
+		path = exec.function.Path
+	}
+
+	return map[string]interface{}{
+		"resource":                        exec.function.Path,
+		"path":                            path,
+		"httpMethod":                      method,
+		"headers":                         headers,
+		"multiValueHeaders":               multiValue(headers),
+		"queryStringParameters":           query,
+		"multiValueQueryStringParameters": multiValue(query),
+		"pathParameters":                  map[string]string{},
+		"stageVariables":                  map[string]string{},
+		"requestContext": map[string]interface{}{
+			"requestId":        exec.requestID,
+			"resourcePath":     exec.function.Path,
+			"path":             path,
+			"httpMethod":       method,
+			"stage":            "runbox",
+			"requestTimeEpoch": time.Now().UnixMilli(),
+			"identity":         map[string]interface{}{"sourceIp": requestData["ip"]},
+		},
+		"body":            body,
+		"isBase64Encoded": false,
+	}
+}
+
+func invokeLambdaHandler(vm *otto.Otto, exec *execution) (interface{}, error) {
+	handler, _ := vm.Get("handler")
+	if !handler.IsFunction() {
+		handler, _ = vm.Run(`module.exports && module.exports.handler`)
+	}
+	if !handler.IsFunction() {
+		handler, _ = vm.Run(`exports.handler`)
+	}
+	if !handler.IsFunction() {
+		return nil, fmt.Errorf("lambda mode requires a handler(event, context) function or exports.handler")
+	}
+
+	started := time.Now()
+
+	var (
+		callbackCalled bool
+		callbackErr    otto.Value
+		callbackResult otto.Value
+	)
+	complete := func(call otto.FunctionCall) otto.Value {
+		callbackCalled = true
+		callbackErr = call.Argument(0)
+		callbackResult = call.Argument(1)
+		return otto.UndefinedValue()
+	}
+
+	context := map[string]interface{}{
+		"functionName":       exec.function.Name,
+		"functionVersion":    strconv.Itoa(exec.function.Version),
+		"invokedFunctionArn": "arn:runbox:function:" + exec.function.Name,
+		"memoryLimitInMB":    "128",
+		"awsRequestId":       exec.requestID,
+		"logGroupName":       "/runbox" + exec.function.Path,
+		"logStreamName":      exec.requestID,
+		"getRemainingTimeInMillis": func() int64 {
+			if deadline, ok := exec.ctx.Deadline(); ok {
+				return time.Until(deadline).Milliseconds()
+			}
+			return 0
+		},
+		"done": complete,
+		"succeed": func(call otto.FunctionCall) otto.Value {
+			callbackCalled = true
+			callbackErr = otto.NullValue()
+			callbackResult = call.Argument(0)
+			return otto.UndefinedValue()
+		},
+		"fail": func(call otto.FunctionCall) otto.Value {
+			callbackCalled = true
+			callbackErr = call.Argument(0)
+			return otto.UndefinedValue()
+		},
+	}
+
+	result, err := handler.Call(otto.UndefinedValue(), lambdaEvent(exec), context, complete)
+	if err != nil {
+		return nil, fmt.Errorf("error calling lambda handler: %w", err)
+	}
+	exec.logs = append(exec.logs, fmt.Sprintf("REPORT RequestId: %s Duration: %d ms", exec.requestID, time.Since(started).Milliseconds()))
+
+	if callbackCalled {
+		if callbackErr.IsDefined() && !callbackErr.IsNull() {
+			return nil, fmt.Errorf("lambda handler failed: %s", callbackErr.String())
+		}
+		result = callbackResult
+	}
+
+	if !result.IsDefined() {
+		return nil, nil
+	}
+
+	goValue, err := exec.export(result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export result: %v", err)
+	}
+
+	return lambdaResponse(goValue)
+}
+
+// lambdaResponse converts an API Gateway proxy result ({statusCode, headers,
+// body}) into an HTTPResponse. Any other value is returned unchanged and
+// serialized as JSON.
+func lambdaResponse(value interface{}) (interface{}, error) {
+	obj, ok := value.(map[string]interface{})
+	if !ok {
+		return value, nil
+	}
+	statusCode, ok := obj["statusCode"]
+	if !ok {
+		return value, nil
+	}
+
+	status, err := strconv.Atoi(fmt.Sprint(statusCode))
+	if err != nil {
+		return nil, fmt.Errorf("invalid statusCode %v", statusCode)
+	}
+
+	resp := &HTTPResponse{
+		Status:  status,
+		Headers: stringMap(obj["headers"]),
+	}
+	if body, ok := obj["body"]; ok && body != nil {
+		resp.Body = fmt.Sprint(body)
+	}
+	if encoded, _ := obj["isBase64Encoded"].(bool); encoded {
+		decoded, err := base64.StdEncoding.DecodeString(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 body: %v", err)
+		}
+		resp.Body = string(decoded)
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,243 @@
+package runbox
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	schedulerLease       = "scheduler"
+	instanceLeasePrefix  = "instance:"
+	defaultLeaseDuration = 15 * time.Second
+)
+
+// leaderElector holds the scheduler lease in the shared database so that
+// only one of several instances runs cron schedules, dispatches delayed
+// jobs and recovers work orphaned by instances that went away. Without
+// clustering every instance is its own leader.
+type leaderElector struct {
+	instanceID string
+	lease      time.Duration
+	clustered  bool
+	leader     atomic.Bool
+}
+
+func newLeaderElector(config ClusterConfig) *leaderElector {
+	id := config.InstanceID
+	if id == "" {
+		host, _ := os.Hostname()
+		id = host + "-" + newID()[:8]
+	}
+
+	lease := defaultLeaseDuration
+	if config.LeaseSeconds > 0 {
+		lease = time.Duration(config.LeaseSeconds) * time.Second
+	}
+
+	e := &leaderElector{instanceID: id, lease: lease, clustered: config.Enabled}
+	e.leader.Store(!config.Enabled)
+	return e
+}
+
+func (app *App) isLeader() bool {
+	return app.leader.leader.Load()
+}
+
+func (app *App) initLeasesTable() error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS leases (
+		name TEXT PRIMARY KEY,
+		holder TEXT NOT NULL,
+		expires_at DATETIME NOT NULL
+	);`
+
+	if _, err := app.db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create leases table: %v", err)
+	}
+	return nil
+}
+
+// acquireLease takes or renews the named lease for this instance. It
+// succeeds when the lease is free, expired, or already ours.
+func (app *App) acquireLease(name string, now time.Time) (bool, error) {
+	holder := app.leader.instanceID
+	expires := now.Add(app.leader.lease)
+
+	_, err := app.db.Exec(`INSERT INTO leases (name, holder, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET holder = excluded.holder, expires_at = excluded.expires_at
+		WHERE leases.holder = excluded.holder OR leases.expires_at < ?`,
+		name, holder, expires, now)
+	if err != nil {
+		return false, err
+	}
+
+	var current string
+	if err := app.db.QueryRow(`SELECT holder FROM leases WHERE name = ?`, name).Scan(&current); err != nil {
+		return false, err
+	}
+	return current == holder, nil
+}
+
+// startLeaderElection keeps this instance's liveness lease fresh and
+// competes for the scheduler lease, renewing well before it expires.
+func (app *App) startLeaderElection() {
+	if !app.leader.clustered {
+		return
+	}
+
+	elect := func() {
+		now := time.Now().UTC()
+		if _, err := app.acquireLease(instanceLeasePrefix+app.leader.instanceID, now); err != nil {
+			log.Println("Failed to renew instance lease:", err)
+		}
+
+		leader, err := app.acquireLease(schedulerLease, now)
+		if err != nil {
+			log.Println("Failed to acquire scheduler lease:", err)
+			leader = false
+		}
+		if app.leader.leader.Swap(leader) != leader {
+			if leader {
+				log.Printf("Instance %s is now the scheduler leader", app.leader.instanceID)
+			} else {
+				log.Printf("Instance %s lost the scheduler lease", app.leader.instanceID)
+			}
+			// Triggers that aren't shared follow the lease. Connecting to a
+			// broker can take a while, so keep it off the renewal loop.
+			app.goBackground(app.syncTriggers)
+		}
+	}
+
+	elect()
+	app.every(app.leader.lease/3, func(time.Time) { elect() })
+}
+
+// recoverOrphanedWork fails (or retries) jobs and workflow runs whose
+// instance no longer holds its liveness lease. Without clustering that is
+// everything left running by the previous process.
+func (app *App) recoverOrphanedWork(now time.Time) {
+	orphaned := `worker IS NULL OR worker = ''`
+	args := []interface{}{}
+	if app.leader.clustered {
+		orphaned += ` OR NOT EXISTS (SELECT 1 FROM leases WHERE name = ? || worker AND expires_at >= ?)`
+		args = append(args, instanceLeasePrefix, now)
+	} else {
+		orphaned += ` OR worker != ?`
+		args = append(args, app.leader.instanceID)
+	}
+
+	rows, err := app.db.Query(`SELECT `+jobColumns+` FROM jobs WHERE status = ? AND (`+orphaned+`)`,
+		append([]interface{}{JobRunning}, args...)...)
+	if err != nil {
+		log.Println("Failed to load orphaned jobs:", err)
+		return
+	}
+	var jobs []Job
+	for rows.Next() {
+		if j, err := scanJob(rows); err == nil {
+			jobs = append(jobs, *j)
+		}
+	}
+	rows.Close()
+
+	for i := range jobs {
+		// Claim the orphan first so two sweeps cannot both retry it.
+		result, err := app.db.Exec(`UPDATE jobs SET worker = ? WHERE id = ? AND status = ?`,
+			app.leader.instanceID, jobs[i].ID, JobRunning)
+		if err != nil {
+			continue
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			continue
+		}
+		if jobs[i].CancelRequested {
+			app.finishJob(&jobs[i], JobCancelled, nil, errExecutionCancelled.Error(), jobs[i].Logs)
+			continue
+		}
+		app.failJob(&jobs[i], "interrupted: its instance stopped", jobs[i].Logs)
+	}
+
+	// Queued jobs only live in the in-memory queue of the instance that
+	// queued them, so a lost instance's backlog is taken over here.
+	if app.leader.clustered {
+		rows, err := app.db.Query(`SELECT id, worker FROM jobs WHERE status = ? AND (`+orphaned+`)`,
+			append([]interface{}{JobQueued}, args...)...)
+		if err != nil {
+			log.Println("Failed to load orphaned jobs:", err)
+			return
+		}
+		taken := map[string]sql.NullString{}
+		for rows.Next() {
+			var id string
+			var worker sql.NullString
+			if err := rows.Scan(&id, &worker); err == nil {
+				taken[id] = worker
+			}
+		}
+		rows.Close()
+
+		for id, worker := range taken {
+			result, err := app.db.Exec(`UPDATE jobs SET worker = ? WHERE id = ? AND status = ? AND worker IS ?`,
+				app.leader.instanceID, id, JobQueued, worker)
+			if err != nil {
+				continue
+			}
+			if n, _ := result.RowsAffected(); n == 0 {
+				continue
+			}
+			select {
+			case app.jobs.pending <- id:
+			default:
+				// Hand it back so the next sweep tries again.
+				app.db.Exec(`UPDATE jobs SET worker = ? WHERE id = ? AND status = ?`, worker, id, JobQueued)
+			}
+		}
+	}
+
+	_, err = app.db.Exec(`UPDATE workflow_runs SET status = ?, error = ?, finished_at = ? WHERE status IN (?, ?) AND (`+orphaned+`)`,
+		append([]interface{}{RunFailed, "interrupted: its instance stopped", now, RunPending, RunRunning}, args...)...)
+	if err != nil {
+		log.Println("Failed to recover workflow runs:", err)
+	}
+}
+
+func (app *App) clusterStatus(c *gin.Context) {
+	status := gin.H{
+		"instanceId": app.leader.instanceID,
+		"clustered":  app.leader.clustered,
+		"leader":     app.isLeader(),
+	}
+
+	var holder string
+	var expires time.Time
+	err := app.db.QueryRow(`SELECT holder, expires_at FROM leases WHERE name = ?`, schedulerLease).Scan(&holder, &expires)
+	if err == nil {
+		status["leaderId"] = holder
+		status["leaseExpiresAt"] = expires
+	}
+
+	if app.leader.clustered {
+		instances := []string{}
+		rows, err := app.db.Query(`SELECT holder FROM leases WHERE name LIKE ? AND expires_at >= ? ORDER BY holder`,
+			instanceLeasePrefix+"%", time.Now().UTC())
+		if err == nil {
+			for rows.Next() {
+				var id string
+				if rows.Scan(&id) == nil {
+					instances = append(instances, id)
+				}
+			}
+			rows.Close()
+		}
+		status["instances"] = instances
+	}
+
+	c.JSON(http.StatusOK, status)
+}
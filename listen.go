@@ -0,0 +1,136 @@
+package runbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	unixAddrPrefix        = "unix:"
+	defaultSocketMode     = 0o660
+	systemdListenFDsStart = 3
+	shutdownTimeout       = 30 * time.Second
+)
+
+// listen opens the socket to serve on: the one systemd passed with socket
+// activation (LISTEN_FDS), a Unix domain socket for an addr of
+// "unix:/path", or TCP.
+func listen(config Config) (net.Listener, string, error) {
+	if l, err := systemdListener(); l != nil || err != nil {
+		return l, "the systemd socket", err
+	}
+
+	path, ok := strings.CutPrefix(config.Addr, unixAddrPrefix)
+	if !ok {
+		l, err := net.Listen("tcp", config.Addr)
+		return l, config.Addr, err
+	}
+
+	// A socket left behind by a process that didn't exit cleanly would
+	// fail the listen; anything else at the path is left alone.
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	mode := os.FileMode(defaultSocketMode)
+	if config.SocketMode != "" {
+		m, err := strconv.ParseUint(config.SocketMode, 8, 32)
+		if err != nil {
+			return nil, "", fmt.Errorf("socketMode %q is not an octal mode", config.SocketMode)
+		}
+		mode = os.FileMode(m)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, "", err
+	}
+	return l, config.Addr, nil
+}
+
+// systemdListener answers the first socket systemd passed this process,
+// or nil without socket activation. The variables are cleared so that
+// processes started from functions don't take the socket for theirs.
+func systemdListener() (net.Listener, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	if pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID")); pid != os.Getpid() {
+		return nil, nil
+	}
+	fds, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if fds < 1 {
+		return nil, nil
+	}
+	if fds > 1 {
+		log.Printf("systemd passed %d sockets; serving on the first", fds)
+	}
+	file := os.NewFile(systemdListenFDsStart, "LISTEN_FD_3")
+	defer file.Close()
+	l, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("systemd socket: %v", err)
+	}
+	return l, nil
+}
+
+// serve serves handler on l until SIGTERM or SIGINT, then stops taking
+// connections and lets requests in flight finish, for up to
+// shutdownTimeout. With socket activation systemd keeps the socket open
+// across the restart, so no connection is refused.
+//
+// HTTP/2 is negotiated over TLS. With h2c, cleartext connections may
+// speak it too, with prior knowledge as proxies such as Envoy do, so only
+// enable it behind a proxy that is trusted to.
+func serve(l net.Listener, handler http.Handler, config Config) error {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(config.H2C)
+	srv := &http.Server{
+		Handler:           handler,
+		Protocols:         protocols,
+		ReadHeaderTimeout: time.Duration(config.Server.ReadHeaderTimeoutSeconds) * time.Second,
+		ReadTimeout:       time.Duration(config.Server.ReadTimeoutSeconds) * time.Second,
+		WriteTimeout:      time.Duration(config.Server.WriteTimeoutSeconds) * time.Second,
+		IdleTimeout:       time.Duration(config.Server.IdleTimeoutSeconds) * time.Second,
+		MaxHeaderBytes:    config.Server.MaxHeaderBytes,
+	}
+	srv.SetKeepAlivesEnabled(!config.Server.DisableKeepAlives)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+	shutdown := make(chan error, 1)
+	go func() {
+		sig := <-stop
+		log.Printf("Received %v, finishing requests in flight", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		shutdown <- srv.Shutdown(ctx)
+	}()
+
+	var err error
+	if config.TLS.enabled() {
+		err = srv.ServeTLS(l, config.TLS.CertFile, config.TLS.KeyFile)
+	} else {
+		err = srv.Serve(l)
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return <-shutdown
+}
@@ -0,0 +1,345 @@
+package runbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultLogSinkBatchSize    = 100
+	defaultLogSinkFlushSeconds = 5
+	defaultLogSinkBuffer       = 10000
+	defaultElasticsearchIndex  = "runbox-logs"
+	logSinkAttempts            = 3
+)
+
+// exportedLog is what sinks receive: the execution log plus the function
+// it belongs to.
+type exportedLog struct {
+	*ExecutionLog
+	Function string `json:"function"`
+	Path     string `json:"path"`
+}
+
+// logSinkEncoder ships one batch to a sink.
+type logSinkEncoder interface {
+	send(batch []exportedLog) error
+}
+
+// logExporter buffers entries for one sink and sends them in batches from
+// the background, when BatchSize is reached or every FlushSeconds. Entries
+// are dropped, with a warning, when the buffer is full or a batch still
+// fails after logSinkAttempts tries.
+type logExporter struct {
+	name      string
+	encoder   logSinkEncoder
+	entries   chan exportedLog
+	batchSize int
+	interval  time.Duration
+}
+
+func newLogExporter(config LogSinkConfig) (*logExporter, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("%s sink needs a url", config.Type)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	var encoder logSinkEncoder
+	switch config.Type {
+	case "loki":
+		encoder = &lokiSink{config: config, client: client}
+	case "elasticsearch":
+		encoder = &elasticsearchSink{config: config, client: client}
+	case "http":
+		encoder = &httpSink{config: config, client: client}
+	case "syslog":
+		sink, err := newSyslogSink(config)
+		if err != nil {
+			return nil, err
+		}
+		encoder = sink
+	default:
+		return nil, fmt.Errorf("unknown sink type %q (want loki, elasticsearch, http or syslog)", config.Type)
+	}
+
+	e := &logExporter{
+		name:      config.Type + " " + config.URL,
+		encoder:   encoder,
+		batchSize: config.BatchSize,
+		interval:  time.Duration(config.FlushSeconds) * time.Second,
+	}
+	if e.batchSize <= 0 {
+		e.batchSize = defaultLogSinkBatchSize
+	}
+	if e.interval <= 0 {
+		e.interval = defaultLogSinkFlushSeconds * time.Second
+	}
+	buffer := config.BufferSize
+	if buffer <= 0 {
+		buffer = defaultLogSinkBuffer
+	}
+	e.entries = make(chan exportedLog, buffer)
+	return e, nil
+}
+
+// run sends batches until ctx is done, then sends what is left.
+func (e *logExporter) run(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	batch := make([]exportedLog, 0, e.batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		var err error
+		for attempt := 1; attempt <= logSinkAttempts; attempt++ {
+			if err = e.encoder.send(batch); err == nil {
+				break
+			}
+			if attempt < logSinkAttempts {
+				time.Sleep(time.Duration(attempt) * time.Second)
+			}
+		}
+		if err != nil {
+			log.Printf("Failed to export %d execution logs to %s: %v", len(batch), e.name, err)
+		}
+		batch = make([]exportedLog, 0, e.batchSize)
+	}
+
+	for {
+		select {
+		case entry := <-e.entries:
+			batch = append(batch, entry)
+			if len(batch) >= e.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-ctx.Done():
+			for {
+				select {
+				case entry := <-e.entries:
+					batch = append(batch, entry)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (app *App) startLogExporters() error {
+	for i, config := range app.config.ExecutionLogs.Sinks {
+		exporter, err := newLogExporter(config)
+		if err != nil {
+			return fmt.Errorf("invalid execution log sink %d: %v", i, err)
+		}
+		app.logExporters = append(app.logExporters, exporter)
+	}
+	for _, exporter := range app.logExporters {
+		app.goBackground(func() { exporter.run(app.ctx) })
+	}
+	return nil
+}
+
+// exportExecutionLog hands an entry to every configured sink.
+func (app *App) exportExecutionLog(function *Function, e *ExecutionLog) {
+	entry := exportedLog{ExecutionLog: e, Function: function.Name, Path: function.Path}
+	for _, exporter := range app.logExporters {
+		select {
+		case exporter.entries <- entry:
+		default:
+			log.Printf("Execution log export buffer for %s is full; dropping the entry for %s", exporter.name, function.Path)
+		}
+	}
+}
+
+// postBatch sends body to the sink and treats any non-2xx status as an
+// error.
+func postBatch(client *http.Client, config LogSinkConfig, target, contentType string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range config.Headers {
+		req.Header.Set(k, v)
+	}
+	if config.Username != "" {
+		req.SetBasicAuth(config.Username, config.Password)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	return respBody, nil
+}
+
+// lokiSink pushes to Loki's push API, one stream per function and status
+// plus the configured labels.
+type lokiSink struct {
+	config LogSinkConfig
+	client *http.Client
+}
+
+func (s *lokiSink) send(batch []exportedLog) error {
+	type stream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+	streams := map[string]*stream{}
+	var order []string
+	for _, entry := range batch {
+		key := entry.Path + "\x00" + entry.Status
+		st, ok := streams[key]
+		if !ok {
+			labels := map[string]string{"service": "runbox", "function": entry.Path, "status": entry.Status}
+			for k, v := range s.config.Labels {
+				labels[k] = v
+			}
+			st = &stream{Stream: labels}
+			streams[key] = st
+			order = append(order, key)
+		}
+		line, _ := json.Marshal(entry)
+		st.Values = append(st.Values, [2]string{strconv.FormatInt(entry.CreatedAt.UnixNano(), 10), string(line)})
+	}
+
+	push := struct {
+		Streams []*stream `json:"streams"`
+	}{}
+	for _, key := range order {
+		push.Streams = append(push.Streams, streams[key])
+	}
+	body, _ := json.Marshal(push)
+
+	target := s.config.URL
+	if !strings.HasSuffix(target, "/loki/api/v1/push") {
+		target = strings.TrimSuffix(target, "/") + "/loki/api/v1/push"
+	}
+	_, err := postBatch(s.client, s.config, target, "application/json", body)
+	return err
+}
+
+// elasticsearchSink indexes entries through the bulk API, with an
+// "@timestamp" field for Kibana.
+type elasticsearchSink struct {
+	config LogSinkConfig
+	client *http.Client
+}
+
+func (s *elasticsearchSink) send(batch []exportedLog) error {
+	index := s.config.Index
+	if index == "" {
+		index = defaultElasticsearchIndex
+	}
+	action, _ := json.Marshal(map[string]interface{}{"index": map[string]string{"_index": index}})
+
+	var body bytes.Buffer
+	for _, entry := range batch {
+		doc, _ := json.Marshal(struct {
+			exportedLog
+			Timestamp time.Time `json:"@timestamp"`
+		}{entry, entry.CreatedAt})
+		body.Write(action)
+		body.WriteByte('\n')
+		body.Write(doc)
+		body.WriteByte('\n')
+	}
+
+	resp, err := postBatch(s.client, s.config, strings.TrimSuffix(s.config.URL, "/")+"/_bulk", "application/x-ndjson", body.Bytes())
+	if err != nil {
+		return err
+	}
+	// A bulk request succeeds even when single documents are rejected.
+	var result struct {
+		Errors bool `json:"errors"`
+	}
+	if json.Unmarshal(resp, &result) == nil && result.Errors {
+		log.Printf("Elasticsearch rejected some of %d execution logs sent to %s", len(batch), s.config.URL)
+	}
+	return nil
+}
+
+// httpSink posts each batch as a JSON array.
+type httpSink struct {
+	config LogSinkConfig
+	client *http.Client
+}
+
+func (s *httpSink) send(batch []exportedLog) error {
+	body, _ := json.Marshal(batch)
+	_, err := postBatch(s.client, s.config, s.config.URL, "application/json", body)
+	return err
+}
+
+// syslogSink writes RFC 5424 messages over UDP, or TCP with octet counting
+// framing, reconnecting after a failed write.
+type syslogSink struct {
+	network string
+	addr    string
+	host    string
+	conn    net.Conn
+}
+
+func newSyslogSink(config LogSinkConfig) (*syslogSink, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") || u.Host == "" {
+		return nil, fmt.Errorf("syslog url must look like udp://host:514 or tcp://host:514")
+	}
+	host, _ := os.Hostname()
+	if host == "" {
+		host = "-"
+	}
+	return &syslogSink{network: u.Scheme, addr: u.Host, host: host}, nil
+}
+
+func (s *syslogSink) send(batch []exportedLog) error {
+	if s.conn == nil {
+		conn, err := net.DialTimeout(s.network, s.addr, 10*time.Second)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+
+	for _, entry := range batch {
+		// Facility local0; informational, or error for failed executions.
+		priority := 16*8 + 6
+		if entry.Status == JobFailed {
+			priority = 16*8 + 3
+		}
+		line, _ := json.Marshal(entry)
+		msg := fmt.Sprintf("<%d>1 %s %s runbox - - - %s", priority, entry.CreatedAt.Format(time.RFC3339Nano), s.host, line)
+		if s.network == "tcp" {
+			msg = strconv.Itoa(len(msg)) + " " + msg
+		}
+
+		s.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		if _, err := io.WriteString(s.conn, msg); err != nil {
+			s.conn.Close()
+			s.conn = nil
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,288 @@
+package runbox
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	logStreamBuffer    = 256
+	logStreamHeartbeat = 15 * time.Second
+	maxLogStreamTail   = 500
+)
+
+// LogStreamEvent is one message of the live log tail: a "console" line
+// while the execution runs, or the finished "execution" entry.
+type LogStreamEvent struct {
+	Type        string        `json:"type"`
+	FunctionID  int           `json:"functionId"`
+	Path        string        `json:"path"`
+	ExecutionID string        `json:"executionId"`
+	RequestID   string        `json:"requestId"`
+	Line        string        `json:"line,omitempty"`
+	Execution   *ExecutionLog `json:"execution,omitempty"`
+	At          time.Time     `json:"at"`
+}
+
+type logSubscriber struct {
+	functions map[int]bool // empty follows every function
+	events    chan LogStreamEvent
+	dropped   int
+}
+
+func (s *logSubscriber) wants(functionID int) bool {
+	return len(s.functions) == 0 || s.functions[functionID]
+}
+
+// logBroker fans console output and finished executions out to the open
+// log streams of this instance.
+type logBroker struct {
+	mu          sync.Mutex
+	subscribers map[*logSubscriber]struct{}
+}
+
+func newLogBroker() *logBroker {
+	return &logBroker{subscribers: map[*logSubscriber]struct{}{}}
+}
+
+func (b *logBroker) subscribe(functions map[int]bool) *logSubscriber {
+	s := &logSubscriber{functions: functions, events: make(chan LogStreamEvent, logStreamBuffer)}
+	b.mu.Lock()
+	b.subscribers[s] = struct{}{}
+	b.mu.Unlock()
+	return s
+}
+
+func (b *logBroker) unsubscribe(s *logSubscriber) {
+	b.mu.Lock()
+	delete(b.subscribers, s)
+	b.mu.Unlock()
+}
+
+// publish never blocks an execution: a subscriber that cannot keep up
+// misses events and is told how many in its next one.
+func (b *logBroker) publish(e LogStreamEvent) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for s := range b.subscribers {
+		if !s.wants(e.FunctionID) {
+			continue
+		}
+		select {
+		case s.events <- e:
+		default:
+			s.dropped++
+		}
+	}
+}
+
+func (b *logBroker) takeDropped(s *logSubscriber) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := s.dropped
+	s.dropped = 0
+	return n
+}
+
+func (exec *execution) streamConsole(line string) {
+	exec.stream.publish(LogStreamEvent{
+		Type:        "console",
+		FunctionID:  exec.function.ID,
+		Path:        exec.function.Path,
+		ExecutionID: exec.id,
+		RequestID:   exec.requestID,
+		Line:        line,
+		At:          time.Now().UTC(),
+	})
+}
+
+var logStreamUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// streamLogs serves GET /api/logs/stream as server-sent events, or over a
+// WebSocket when the request asks for an upgrade. functionId (repeatable or
+// comma-separated) and path narrow it to some functions; tail first replays
+// that many stored executions.
+func (app *App) streamLogs(c *gin.Context) {
+	functions := map[int]bool{}
+	for _, v := range c.QueryArray("functionId") {
+		for _, part := range strings.Split(v, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+				return
+			}
+			functions[id] = true
+		}
+	}
+	if path := c.Query("path"); path != "" {
+		function, err := app.getFunctionByPath(path)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Function not found"})
+			return
+		}
+		functions[function.ID] = true
+	}
+
+	tail := 0
+	if v := c.Query("tail"); v != "" {
+		var err error
+		if tail, err = strconv.Atoi(v); err != nil || tail < 0 || tail > maxLogStreamTail {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "tail must be between 0 and 500"})
+			return
+		}
+	}
+	backlog, err := app.recentExecutionLogs(functions, tail)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load logs"})
+		return
+	}
+
+	sub := app.logStream.subscribe(functions)
+	defer app.logStream.unsubscribe(sub)
+
+	if websocket.IsWebSocketUpgrade(c.Request) {
+		app.streamLogsWebSocket(c, sub, backlog)
+		return
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	for _, e := range backlog {
+		c.SSEvent(e.Type, e)
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(logStreamHeartbeat)
+	defer heartbeat.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-heartbeat.C:
+			io.WriteString(w, ": ping\n\n")
+		case e := <-sub.events:
+			if n := app.logStream.takeDropped(sub); n > 0 {
+				c.SSEvent("dropped", gin.H{"count": n})
+			}
+			c.SSEvent(e.Type, e)
+		}
+		return true
+	})
+}
+
+func (app *App) streamLogsWebSocket(c *gin.Context, sub *logSubscriber, backlog []LogStreamEvent) {
+	conn, err := logStreamUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// The client sends nothing; reading only notices when it goes away.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for _, e := range backlog {
+		if conn.WriteJSON(e) != nil {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(logStreamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-heartbeat.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(5*time.Second))
+		case e := <-sub.events:
+			if n := app.logStream.takeDropped(sub); n > 0 {
+				err = conn.WriteJSON(gin.H{"type": "dropped", "count": n})
+			}
+			if err == nil {
+				err = conn.WriteJSON(e)
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// recentExecutionLogs returns the last n stored executions, oldest first,
+// as stream events.
+func (app *App) recentExecutionLogs(functions map[int]bool, n int) ([]LogStreamEvent, error) {
+	if n == 0 {
+		return nil, nil
+	}
+
+	query := `SELECT ` + executionLogColumns + ` FROM execution_logs`
+	args := []interface{}{}
+	if len(functions) > 0 {
+		query += ` WHERE function_id IN (?` + strings.Repeat(`, ?`, len(functions)-1) + `)`
+		for id := range functions {
+			args = append(args, id)
+		}
+	}
+	query += ` ORDER BY created_at DESC LIMIT ?`
+	args = append(args, n)
+
+	rows, err := app.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []LogStreamEvent
+	paths := map[int]string{}
+	for rows.Next() {
+		e, err := scanExecutionLog(rows)
+		if err != nil {
+			return nil, err
+		}
+		path, ok := paths[e.FunctionID]
+		if !ok {
+			if function, err := app.getFunctionByID(e.FunctionID); err == nil {
+				path = function.Path
+			}
+			paths[e.FunctionID] = path
+		}
+		events = append(events, executionEvent(path, e))
+	}
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	return events, rows.Err()
+}
+
+func executionEvent(path string, e *ExecutionLog) LogStreamEvent {
+	return LogStreamEvent{
+		Type:        "execution",
+		FunctionID:  e.FunctionID,
+		Path:        path,
+		ExecutionID: e.ID,
+		RequestID:   e.RequestID,
+		Execution:   e,
+		At:          e.CreatedAt.Add(time.Duration(e.DurationMs) * time.Millisecond),
+	}
+}
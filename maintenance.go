@@ -0,0 +1,209 @@
+package runbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maintenanceTTL = 5 * time.Second
+
+var defaultMaintenanceBody = gin.H{"error": "RunBox is down for maintenance"}
+
+// Maintenance is the instance-wide maintenance switch. While it is on,
+// the execute routes answer Status with Body, except for function paths
+// in AllowPaths; a path ending in * allows everything below it. The UI
+// and the management API keep working.
+type Maintenance struct {
+	Enabled           bool        `json:"enabled"`
+	Status            int         `json:"status"`
+	Body              interface{} `json:"body"`
+	RetryAfterSeconds int         `json:"retryAfterSeconds,omitempty"`
+	AllowPaths        []string    `json:"allowPaths"`
+	StartedAt         *time.Time  `json:"startedAt,omitempty"`
+	UpdatedAt         *time.Time  `json:"updatedAt,omitempty"`
+}
+
+// maintenanceCache keeps the switch in memory so the execute routes don't
+// query it on every call; it is reloaded every maintenanceTTL, and at once
+// when another instance flips it.
+type maintenanceCache struct {
+	mu       sync.Mutex
+	current  Maintenance
+	loadedAt time.Time
+}
+
+func (m *maintenanceCache) invalidate() {
+	m.mu.Lock()
+	m.loadedAt = time.Time{}
+	m.mu.Unlock()
+}
+
+func (app *App) initMaintenanceTable() error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS maintenance (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		enabled INTEGER NOT NULL DEFAULT 0,
+		status INTEGER NOT NULL DEFAULT 503,
+		body TEXT NOT NULL DEFAULT '',
+		retry_after INTEGER NOT NULL DEFAULT 0,
+		allow_paths TEXT NOT NULL DEFAULT '[]',
+		started_at DATETIME,
+		updated_at DATETIME
+	);`
+
+	if _, err := app.db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create maintenance table: %v", err)
+	}
+	return nil
+}
+
+func (app *App) loadMaintenance() (Maintenance, error) {
+	m := Maintenance{Status: http.StatusServiceUnavailable, Body: defaultMaintenanceBody, AllowPaths: []string{}}
+	var body, allow string
+	err := app.db.QueryRow(`SELECT enabled, status, body, retry_after, allow_paths, started_at, updated_at FROM maintenance WHERE id = 1`).
+		Scan(&m.Enabled, &m.Status, &body, &m.RetryAfterSeconds, &allow, &m.StartedAt, &m.UpdatedAt)
+	if err != nil {
+		return m, err
+	}
+	if body != "" {
+		json.Unmarshal([]byte(body), &m.Body)
+	}
+	json.Unmarshal([]byte(allow), &m.AllowPaths)
+	return m, nil
+}
+
+// currentMaintenance is the switch as last loaded; the zero value, off,
+// until the table has a row.
+func (app *App) currentMaintenance() Maintenance {
+	m := app.maintenance
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if time.Since(m.loadedAt) > maintenanceTTL {
+		m.current, _ = app.loadMaintenance()
+		m.loadedAt = time.Now()
+	}
+	return m.current
+}
+
+func (m *Maintenance) allows(path string) bool {
+	return pathAllowed(m.AllowPaths, path)
+}
+
+// pathAllowed reports whether path is one of patterns, where a pattern
+// ending in * matches everything that starts with the rest.
+func pathAllowed(patterns []string, path string) bool {
+	for _, allowed := range patterns {
+		if prefix, ok := strings.CutSuffix(allowed, "*"); ok {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		} else if path == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// maintenanceMiddleware answers for the execute routes while maintenance
+// is on.
+func (app *App) maintenanceMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		m := app.currentMaintenance()
+		if !m.Enabled || m.allows(c.Param("path")) {
+			return
+		}
+		if m.RetryAfterSeconds > 0 {
+			c.Header("Retry-After", strconv.Itoa(m.RetryAfterSeconds))
+		}
+		c.AbortWithStatusJSON(m.Status, m.Body)
+	}
+}
+
+func (app *App) getMaintenanceHandler(c *gin.Context) {
+	m, _ := app.loadMaintenance()
+	c.JSON(http.StatusOK, m)
+}
+
+// setMaintenance serves PUT /api/maintenance. Fields left out keep their
+// value, so {"enabled": false} ends maintenance and the next
+// {"enabled": true} answers the same way as the last one.
+func (app *App) setMaintenance(c *gin.Context) {
+	m, _ := app.loadMaintenance()
+	var in struct {
+		Enabled           *bool           `json:"enabled"`
+		Status            *int            `json:"status"`
+		Body              json.RawMessage `json:"body"`
+		RetryAfterSeconds *int            `json:"retryAfterSeconds"`
+		AllowPaths        []string        `json:"allowPaths"`
+	}
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid maintenance body"})
+		return
+	}
+	if in.Status != nil {
+		if *in.Status < 400 || *in.Status > 599 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "status must be between 400 and 599"})
+			return
+		}
+		m.Status = *in.Status
+	}
+	if in.RetryAfterSeconds != nil {
+		if *in.RetryAfterSeconds < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "retryAfterSeconds must not be negative"})
+			return
+		}
+		m.RetryAfterSeconds = *in.RetryAfterSeconds
+	}
+	if in.AllowPaths != nil {
+		for _, p := range in.AllowPaths {
+			if !strings.HasPrefix(p, "/") {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "allowPaths must be function paths such as /health or /status/*"})
+				return
+			}
+		}
+		m.AllowPaths = in.AllowPaths
+	}
+	body, _ := json.Marshal(m.Body)
+	if len(in.Body) > 0 && string(in.Body) != "null" {
+		body = in.Body
+		json.Unmarshal(body, &m.Body)
+	}
+
+	now := time.Now().UTC()
+	if in.Enabled != nil {
+		if *in.Enabled && !m.Enabled {
+			m.StartedAt = &now
+		} else if !*in.Enabled {
+			m.StartedAt = nil
+		}
+		m.Enabled = *in.Enabled
+	}
+	m.UpdatedAt = &now
+	allow, _ := json.Marshal(m.AllowPaths)
+	_, err := app.db.Exec(`INSERT INTO maintenance (id, enabled, status, body, retry_after, allow_paths, started_at, updated_at)
+		VALUES (1, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET enabled = excluded.enabled, status = excluded.status, body = excluded.body,
+			retry_after = excluded.retry_after, allow_paths = excluded.allow_paths, started_at = excluded.started_at,
+			updated_at = excluded.updated_at`,
+		m.Enabled, m.Status, string(body), m.RetryAfterSeconds, string(allow), m.StartedAt, now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save maintenance mode"})
+		return
+	}
+	app.maintenance.invalidate()
+	app.broadcastChange(changeSettings, 0)
+	if in.Enabled != nil && m.Enabled {
+		log.Printf("Maintenance mode started by %s", app.auditOrigin(c).actor)
+	} else if in.Enabled != nil {
+		log.Printf("Maintenance mode ended by %s", app.auditOrigin(c).actor)
+	}
+	c.JSON(http.StatusOK, m)
+}
@@ -0,0 +1,353 @@
+package runbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Materialization pre-computes the GET response of a function sub-path on a
+// cron schedule, so live requests are answered from the stored result
+// instead of running an expensive handler. A result older than
+// MaxStaleSeconds is no longer served and requests fall through to a live
+// execution.
+type Materialization struct {
+	ID              int        `json:"id"`
+	FunctionID      int        `json:"functionId"`
+	Path            string     `json:"path"`
+	Cron            string     `json:"cron"`
+	MaxStaleSeconds int        `json:"maxStaleSeconds,omitempty"`
+	Status          int        `json:"status,omitempty"`
+	ComputedAt      *time.Time `json:"computedAt,omitempty"`
+	DurationMs      int64      `json:"durationMs,omitempty"`
+	Error           string     `json:"error,omitempty"`
+	NextRunAt       *time.Time `json:"nextRunAt,omitempty"`
+	Stale           bool       `json:"stale"`
+	CreatedAt       time.Time  `json:"createdAt"`
+
+	response *HTTPResponse
+}
+
+func (m *Materialization) stale(now time.Time) bool {
+	if m.ComputedAt == nil {
+		return true
+	}
+	return m.MaxStaleSeconds > 0 && now.Sub(*m.ComputedAt) > time.Duration(m.MaxStaleSeconds)*time.Second
+}
+
+func (app *App) initMaterializationsTable() error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS materializations (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		function_id INTEGER NOT NULL REFERENCES functions(id) ON DELETE CASCADE,
+		path TEXT NOT NULL,
+		cron TEXT NOT NULL,
+		max_stale_seconds INTEGER NOT NULL DEFAULT 0,
+		response TEXT,
+		computed_at DATETIME,
+		duration_ms INTEGER NOT NULL DEFAULT 0,
+		error TEXT NOT NULL DEFAULT '',
+		next_run_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (function_id, path)
+	);`
+
+	if _, err := app.db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create materializations table: %v", err)
+	}
+	return nil
+}
+
+const materializationColumns = `id, function_id, path, cron, max_stale_seconds, response, computed_at, duration_ms, error, next_run_at, created_at`
+
+func scanMaterialization(row rowScanner) (*Materialization, error) {
+	var (
+		m                     Materialization
+		response              sql.NullString
+		computedAt, nextRunAt sql.NullTime
+	)
+	err := row.Scan(&m.ID, &m.FunctionID, &m.Path, &m.Cron, &m.MaxStaleSeconds, &response,
+		&computedAt, &m.DurationMs, &m.Error, &nextRunAt, &m.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.Valid {
+		var resp HTTPResponse
+		if json.Unmarshal([]byte(response.String), &resp) == nil {
+			m.response = &resp
+			m.Status = resp.Status
+		}
+	}
+	if computedAt.Valid {
+		m.ComputedAt = &computedAt.Time
+	}
+	if nextRunAt.Valid {
+		m.NextRunAt = &nextRunAt.Time
+	}
+	m.Stale = m.stale(time.Now().UTC())
+
+	return &m, nil
+}
+
+func (app *App) getMaterialization(id int) (*Materialization, error) {
+	return scanMaterialization(app.db.QueryRow(`SELECT `+materializationColumns+` FROM materializations WHERE id = ?`, id))
+}
+
+func (app *App) getFunctionMaterializations(functionID int) ([]Materialization, error) {
+	rows, err := app.db.Query(`SELECT `+materializationColumns+` FROM materializations WHERE function_id = ? ORDER BY path`, functionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Materialization{}
+	for rows.Next() {
+		m, err := scanMaterialization(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, *m)
+	}
+
+	return list, nil
+}
+
+// servedMaterialization returns the stored response for a live request, or
+// nil when the request must run the function: it is not a plain GET, the
+// client asked for a fresh response, or there is no fresh result.
+func (app *App) servedMaterialization(c *gin.Context, function *Function, subpath string) *Materialization {
+	if c.Request.Method != http.MethodGet || c.Request.URL.RawQuery != "" {
+		return nil
+	}
+	if strings.Contains(c.GetHeader("Cache-Control"), "no-cache") {
+		return nil
+	}
+
+	m, err := scanMaterialization(app.db.QueryRow(`SELECT `+materializationColumns+` FROM materializations WHERE function_id = ? AND path = ?`,
+		function.ID, subpath))
+	if err != nil || m.response == nil || m.Stale {
+		return nil
+	}
+	return m
+}
+
+func writeMaterialization(c *gin.Context, m *Materialization) {
+	for name, value := range m.response.Headers {
+		c.Header(name, value)
+	}
+	c.Header("X-Runbox-Materialized", "hit")
+	c.Header("X-Runbox-Materialized-At", m.ComputedAt.UTC().Format(time.RFC3339))
+	c.Header("Age", strconv.Itoa(int(time.Since(*m.ComputedAt).Seconds())))
+	c.Data(m.response.Status, m.response.ContentType(), []byte(m.response.Body))
+}
+
+// responseFromResult turns a handler result into the response it would be
+// served as.
+func responseFromResult(result interface{}) (*HTTPResponse, error) {
+	if resp, ok := result.(*HTTPResponse); ok {
+		return resp, nil
+	}
+	body, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	return &HTTPResponse{
+		Status:  http.StatusOK,
+		Headers: map[string]string{"Content-Type": "application/json; charset=utf-8"},
+		Body:    string(body),
+	}, nil
+}
+
+// refreshMaterialization runs the function for the materialized path and
+// stores the response. A failed run keeps the previous response.
+func (app *App) refreshMaterialization(m *Materialization) error {
+	if _, busy := app.refreshing.LoadOrStore(m.ID, true); busy {
+		return fmt.Errorf("materialization %d is already refreshing", m.ID)
+	}
+	defer app.refreshing.Delete(m.ID)
+
+	next := sql.NullTime{}
+	if spec, err := cronParser.Parse(m.Cron); err == nil {
+		next = sql.NullTime{Time: spec.Next(time.Now().UTC()), Valid: true}
+	}
+
+	function, err := app.getFunctionByID(m.FunctionID)
+	if err != nil {
+		return err
+	}
+
+	requestData := syntheticRequest(function, http.MethodGet, nil)
+	requestData["path"] = strings.TrimSuffix(function.Path, "/*") + m.Path
+	requestData["subpath"] = m.Path
+	requestData["materialize"] = true
+
+	started := time.Now()
+	ctx, cancel := context.WithTimeout(app.ctx, 5*time.Minute)
+	defer cancel()
+	exec := newExecution(ctx, function, requestData)
+	exec.source = "materialize"
+	result, err := app.executeJavaScript(exec)
+	duration := time.Since(started).Milliseconds()
+
+	var resp *HTTPResponse
+	if err == nil {
+		resp, err = responseFromResult(result)
+	}
+	if err != nil {
+		app.db.Exec(`UPDATE materializations SET error = ?, duration_ms = ?, next_run_at = ? WHERE id = ?`,
+			err.Error(), duration, next, m.ID)
+		return err
+	}
+
+	stored, _ := json.Marshal(resp)
+	_, err = app.db.Exec(`UPDATE materializations SET response = ?, computed_at = ?, duration_ms = ?, error = '', next_run_at = ? WHERE id = ?`,
+		string(stored), time.Now().UTC(), duration, next, m.ID)
+	return err
+}
+
+// runDueMaterializations refreshes every materialization whose time has
+// come. Refreshes run in the background so a slow handler does not hold up
+// the scheduler.
+func (app *App) runDueMaterializations(now time.Time) {
+	rows, err := app.db.Query(`SELECT `+materializationColumns+` FROM materializations WHERE next_run_at IS NULL OR next_run_at <= ?`, now)
+	if err != nil {
+		log.Println("Failed to load due materializations:", err)
+		return
+	}
+	var due []Materialization
+	for rows.Next() {
+		if m, err := scanMaterialization(rows); err == nil {
+			due = append(due, *m)
+		}
+	}
+	rows.Close()
+
+	for i := range due {
+		m := &due[i]
+		if _, busy := app.refreshing.Load(m.ID); busy {
+			continue
+		}
+		app.goBackground(func() {
+			if err := app.refreshMaterialization(m); err != nil {
+				log.Printf("Failed to materialize %d (%s): %v", m.ID, m.Path, err)
+			}
+		})
+	}
+}
+
+type materializationInput struct {
+	Path            string `json:"path"`
+	Cron            string `json:"cron"`
+	MaxStaleSeconds int    `json:"maxStaleSeconds"`
+}
+
+func (in *materializationInput) validate() string {
+	if in.Path == "" {
+		in.Path = "/"
+	}
+	if !strings.HasPrefix(in.Path, "/") {
+		in.Path = "/" + in.Path
+	}
+	if _, err := cronParser.Parse(in.Cron); err != nil {
+		return "Invalid cron expression: " + err.Error()
+	}
+	if in.MaxStaleSeconds < 0 {
+		return "maxStaleSeconds must not be negative"
+	}
+	return ""
+}
+
+func (app *App) listMaterializationsHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+
+	list, err := app.getFunctionMaterializations(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list materializations"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"materializations": list})
+}
+
+func (app *App) createMaterialization(c *gin.Context) {
+	functionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+	if _, err := app.getFunctionByID(functionID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Function not found"})
+		return
+	}
+
+	var in materializationInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid materialization body"})
+		return
+	}
+	if msg := in.validate(); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	// next_run_at starts out NULL so the first result is computed right away.
+	result, err := app.db.Exec(`INSERT INTO materializations (function_id, path, cron, max_stale_seconds) VALUES (?, ?, ?, ?)`,
+		functionID, in.Path, in.Cron, in.MaxStaleSeconds)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Failed to create materialization: " + err.Error()})
+		return
+	}
+
+	id, _ := result.LastInsertId()
+	m, _ := app.getMaterialization(int(id))
+	c.JSON(http.StatusCreated, m)
+}
+
+func (app *App) refreshMaterializationHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid materialization ID"})
+		return
+	}
+	m, err := app.getMaterialization(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Materialization not found"})
+		return
+	}
+
+	if err := app.refreshMaterialization(m); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Refresh failed", "details": err.Error()})
+		return
+	}
+
+	m, _ = app.getMaterialization(id)
+	c.JSON(http.StatusOK, m)
+}
+
+func (app *App) deleteMaterialization(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid materialization ID"})
+		return
+	}
+
+	if _, err := app.db.Exec(`DELETE FROM materializations WHERE id = ?`, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete materialization"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Materialization deleted successfully"})
+}
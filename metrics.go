@@ -0,0 +1,344 @@
+package runbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	metricsFlushInterval = time.Minute
+	metricsRetention     = 30 * 24 * time.Hour
+)
+
+// latencyBounds are the upper bounds, in milliseconds, of the latency
+// histogram buckets; a last bucket catches everything slower. Histograms
+// add up across minutes and instances, so percentiles over any window are
+// estimated from them rather than from raw samples.
+var latencyBounds = []int64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000, 60000}
+
+type functionStats struct {
+	Invocations int64   `json:"invocations"`
+	Errors      int64   `json:"errors"`
+	Slow        int64   `json:"slow"`
+	TotalMs     int64   `json:"totalMs"`
+	MaxMs       int64   `json:"maxMs"`
+	Histogram   []int64 `json:"histogram"`
+	EgressBytes int64   `json:"egressBytes"`
+}
+
+func newFunctionStats() *functionStats {
+	return &functionStats{Histogram: make([]int64, len(latencyBounds)+1)}
+}
+
+func (s *functionStats) observe(ms int64, failed, slow bool) {
+	s.Invocations++
+	if failed {
+		s.Errors++
+	}
+	if slow {
+		s.Slow++
+	}
+	s.TotalMs += ms
+	s.MaxMs = max(s.MaxMs, ms)
+
+	i := 0
+	for i < len(latencyBounds) && ms > latencyBounds[i] {
+		i++
+	}
+	s.Histogram[i]++
+}
+
+func (s *functionStats) merge(o *functionStats) {
+	s.Invocations += o.Invocations
+	s.Errors += o.Errors
+	s.Slow += o.Slow
+	s.TotalMs += o.TotalMs
+	s.MaxMs = max(s.MaxMs, o.MaxMs)
+	s.EgressBytes += o.EgressBytes
+	for i := range s.Histogram {
+		if i < len(o.Histogram) {
+			s.Histogram[i] += o.Histogram[i]
+		}
+	}
+}
+
+// percentile returns the upper bound of the bucket holding the p-th
+// percentile, capped at the slowest observed latency.
+func (s *functionStats) percentile(p float64) int64 {
+	if s.Invocations == 0 {
+		return 0
+	}
+	rank := int64(float64(s.Invocations)*p + 0.5)
+	var seen int64
+	for i, n := range s.Histogram {
+		seen += n
+		if seen >= rank && n > 0 {
+			if i < len(latencyBounds) {
+				return min(latencyBounds[i], s.MaxMs)
+			}
+			return s.MaxMs
+		}
+	}
+	return s.MaxMs
+}
+
+func (s *functionStats) summary() gin.H {
+	summary := gin.H{
+		"invocations": s.Invocations,
+		"errors":      s.Errors,
+		"errorRate":   0.0,
+		"slow":        s.Slow,
+		"avgMs":       0.0,
+		"p50Ms":       s.percentile(0.50),
+		"p95Ms":       s.percentile(0.95),
+		"p99Ms":       s.percentile(0.99),
+		"maxMs":       s.MaxMs,
+	}
+	if s.Invocations > 0 {
+		summary["errorRate"] = float64(s.Errors) / float64(s.Invocations)
+		summary["avgMs"] = float64(s.TotalMs) / float64(s.Invocations)
+	}
+	return summary
+}
+
+// metricsRegistry counts executions in memory and flushes them once a
+// minute into function_metrics, one row per function and flush, and into
+// the hourly function_usage totals.
+type metricsRegistry struct {
+	mu      sync.Mutex
+	pending map[int]*functionStats
+}
+
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{pending: map[int]*functionStats{}}
+}
+
+func (m *metricsRegistry) observe(functionID int, duration time.Duration, failed, slow bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.pending[functionID]
+	if !ok {
+		s = newFunctionStats()
+		m.pending[functionID] = s
+	}
+	s.observe(duration.Milliseconds(), failed, slow)
+}
+
+// addEgress counts response bytes served for a function.
+func (m *metricsRegistry) addEgress(functionID int, n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.pending[functionID]
+	if !ok {
+		s = newFunctionStats()
+		m.pending[functionID] = s
+	}
+	s.EgressBytes += n
+}
+
+func (m *metricsRegistry) snapshot(functionID int) *functionStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := newFunctionStats()
+	if pending, ok := m.pending[functionID]; ok {
+		s.merge(pending)
+	}
+	return s
+}
+
+func (m *metricsRegistry) take() map[int]*functionStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pending := m.pending
+	m.pending = map[int]*functionStats{}
+	return pending
+}
+
+func (app *App) initMetricsTable() error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS function_metrics (
+		function_id INTEGER NOT NULL REFERENCES functions(id) ON DELETE CASCADE,
+		bucket DATETIME NOT NULL,
+		instance TEXT NOT NULL,
+		invocations INTEGER NOT NULL,
+		errors INTEGER NOT NULL,
+		total_ms INTEGER NOT NULL,
+		max_ms INTEGER NOT NULL,
+		histogram TEXT NOT NULL,
+		PRIMARY KEY (function_id, bucket, instance)
+	);
+	CREATE INDEX IF NOT EXISTS idx_function_metrics_bucket ON function_metrics (bucket);`
+
+	if _, err := app.db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create function_metrics table: %v", err)
+	}
+	return app.addColumns("function_metrics", [][2]string{
+		{"slow", "INTEGER NOT NULL DEFAULT 0"},
+	})
+}
+
+// startMetricsFlush writes the metrics every metricsFlushInterval, and a
+// last time on Close.
+func (app *App) startMetricsFlush() {
+	app.goBackground(func() {
+		ticker := time.NewTicker(metricsFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				app.flushMetrics(now.UTC())
+			case <-app.ctx.Done():
+				app.flushMetrics(time.Now().UTC())
+				return
+			}
+		}
+	})
+}
+
+func (app *App) flushMetrics(now time.Time) {
+	for functionID, s := range app.metrics.take() {
+		app.recordUsage(functionID, now.Truncate(time.Hour), s)
+		if s.Invocations == 0 {
+			continue
+		}
+
+		histogram, _ := json.Marshal(s.Histogram)
+		_, err := app.db.Exec(`INSERT INTO function_metrics (function_id, bucket, instance, invocations, errors, slow, total_ms, max_ms, histogram)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			functionID, now, app.leader.instanceID, s.Invocations, s.Errors, s.Slow, s.TotalMs, s.MaxMs, string(histogram))
+		if err != nil {
+			log.Printf("Failed to flush metrics of function %d: %v", functionID, err)
+		}
+	}
+
+	if app.isLeader() {
+		app.db.Exec(`DELETE FROM function_metrics WHERE bucket < ?`, now.Add(-metricsRetention))
+		app.pruneUsage(now)
+	}
+}
+
+// functionMetrics adds up the stored rows of the window and what this
+// instance has not flushed yet.
+func (app *App) functionMetrics(ids []int, since time.Time) (map[int]*functionStats, error) {
+	stats := map[int]*functionStats{}
+	for _, id := range ids {
+		stats[id] = app.metrics.snapshot(id)
+	}
+	if len(ids) == 0 {
+		return stats, nil
+	}
+
+	args := []interface{}{since}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	rows, err := app.db.Query(`SELECT function_id, invocations, errors, slow, total_ms, max_ms, histogram FROM function_metrics
+		WHERE bucket >= ? AND function_id IN (?`+strings.Repeat(`, ?`, len(ids)-1)+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var histogram string
+		row := newFunctionStats()
+		if err := rows.Scan(&id, &row.Invocations, &row.Errors, &row.Slow, &row.TotalMs, &row.MaxMs, &histogram); err != nil {
+			return nil, err
+		}
+		json.Unmarshal([]byte(histogram), &row.Histogram)
+		stats[id].merge(row)
+	}
+
+	return stats, nil
+}
+
+// parseWindow accepts Go durations plus a "d" suffix for days.
+func parseWindow(v string) (time.Duration, error) {
+	if v == "" {
+		return 24 * time.Hour, nil
+	}
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("invalid window %q", v)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid window %q", v)
+	}
+	return d, nil
+}
+
+func (app *App) functionMetricsHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+	window, err := parseWindow(c.Query("window"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	stats, err := app.functionMetrics([]int{id}, time.Now().UTC().Add(-window))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load metrics"})
+		return
+	}
+
+	summary := stats[id].summary()
+	summary["functionId"] = id
+	summary["window"] = window.String()
+	c.JSON(http.StatusOK, summary)
+}
+
+func (app *App) listMetricsHandler(c *gin.Context) {
+	window, err := parseWindow(c.Query("window"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	functions, err := app.getAllFunctions()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list functions"})
+		return
+	}
+	ids := make([]int, len(functions))
+	for i, f := range functions {
+		ids[i] = f.ID
+	}
+
+	stats, err := app.functionMetrics(ids, time.Now().UTC().Add(-window))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load metrics"})
+		return
+	}
+
+	metrics := []gin.H{}
+	for _, f := range functions {
+		summary := stats[f.ID].summary()
+		summary["functionId"] = f.ID
+		summary["name"] = f.Name
+		summary["path"] = f.Path
+		metrics = append(metrics, summary)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"window": window.String(), "metrics": metrics})
+}
@@ -0,0 +1,106 @@
+package runbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/prodemmi/runbox/engine"
+	"github.com/robertkrimen/otto"
+)
+
+const (
+	// entryFileName is what the editor calls a function's own code, which
+	// runs first and can require the function's modules.
+	entryFileName   = "index.js"
+	maxModuleFiles  = 50
+	maxModuleLength = 128
+)
+
+// moduleNamePattern accepts relative .js paths such as lib/format.js; no
+// segment may start with a dot, so names can't climb out with "..".
+var moduleNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9_.-]*(/[A-Za-z0-9_-][A-Za-z0-9_.-]*)*\.js$`)
+
+// parseFiles reads the files form field, a JSON list of modules.
+func parseFiles(data string) ([]FunctionFile, error) {
+	if strings.TrimSpace(data) == "" {
+		return nil, nil
+	}
+	var files []FunctionFile
+	if err := json.Unmarshal([]byte(data), &files); err != nil {
+		return nil, fmt.Errorf("files must be a JSON list of {name, code}")
+	}
+	return files, nil
+}
+
+// validateFunctionFiles checks module names and parses each module, naming
+// the file in the error.
+func validateFunctionFiles(files []FunctionFile) error {
+	if len(files) > maxModuleFiles {
+		return fmt.Errorf("a function can have at most %d modules", maxModuleFiles)
+	}
+	seen := map[string]bool{}
+	for _, f := range files {
+		if !moduleNamePattern.MatchString(f.Name) || len(f.Name) > maxModuleLength {
+			return fmt.Errorf("invalid module name %q: use a relative .js path such as lib/util.js", f.Name)
+		}
+		if f.Name == entryFileName {
+			return fmt.Errorf("%s is the entry point and can't also be a module", entryFileName)
+		}
+		if seen[f.Name] {
+			return fmt.Errorf("module %s is listed twice", f.Name)
+		}
+		seen[f.Name] = true
+		if diagnostics := validateModuleCode(f.Code); diagnostics != nil {
+			return fmt.Errorf("%s: syntax error at %s", f.Name, diagnostics[0])
+		}
+	}
+	return nil
+}
+
+// installRequire defines require for the function's modules. Each module
+// runs once per execution; a cycle gets the exports filled in so far, as in
+// Node.
+func (app *App) installRequire(vm *otto.Otto, exec *execution) {
+	files := map[string]string{}
+	for _, f := range exec.function.Files {
+		files[f.Name] = f.Code
+	}
+	loaded := map[string]*otto.Object{}
+
+	var requireFrom func(dir string) func(call otto.FunctionCall) otto.Value
+	requireFrom = func(dir string) func(call otto.FunctionCall) otto.Value {
+		return func(call otto.FunctionCall) otto.Value {
+			spec := call.Argument(0).String()
+			name, ok := engine.ResolveModule(files, dir, spec)
+			if !ok {
+				throwError(call, fmt.Sprintf("Cannot find module '%s'", spec))
+			}
+			if module, ok := loaded[name]; ok {
+				exports, _ := module.Get("exports")
+				return exports
+			}
+
+			script, err := app.scripts.compile(engine.ModuleSource(files[name]))
+			if err != nil {
+				throwError(call, name+": "+err.Error())
+			}
+			wrapper, err := call.Otto.Run(script)
+			if err != nil {
+				throwError(call, name+": "+err.Error())
+			}
+			module, _ := call.Otto.Object(`({exports: {}})`)
+			loaded[name] = module
+			exports, _ := module.Get("exports")
+			if _, err := wrapper.Call(otto.UndefinedValue(), module, exports, requireFrom(path.Dir(name))); err != nil {
+				delete(loaded, name)
+				throwError(call, name+": "+err.Error())
+			}
+			exports, _ = module.Get("exports")
+			return exports
+		}
+	}
+	vm.Set("require", requireFrom("."))
+}
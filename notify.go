@@ -0,0 +1,101 @@
+package runbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/robertkrimen/otto"
+)
+
+const notifyTimeout = 10 * time.Second
+
+var notifyClient = &http.Client{Timeout: notifyTimeout}
+
+// notifyWebhookURL resolves the target of runbox.notify: the name of a
+// secret holding an incoming webhook URL, or the URL itself.
+func (app *App) notifyWebhookURL(target string) (string, error) {
+	webhook := target
+	if !strings.Contains(target, "://") {
+		value, ok, err := app.secretValue(target)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return "", fmt.Errorf("no secret named %s", target)
+		}
+		webhook = strings.TrimSpace(value)
+	}
+	u, err := url.Parse(webhook)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return "", errors.New("the webhook must be an https URL")
+	}
+	return webhook, nil
+}
+
+// postNotification sends payload as JSON to an incoming webhook.
+func postNotification(exec *execution, webhook string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return errors.New("message must be a string or JSON-serializable")
+	}
+	req, err := http.NewRequestWithContext(exec.ctx, http.MethodPost, webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook answered %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
+
+// jsNotify exposes runbox.notify, messages to Slack and Discord through
+// incoming webhooks. A string message is sent as the text; an object is
+// sent as it is, for Slack blocks or Discord embeds.
+func (app *App) jsNotify(vm *otto.Otto, exec *execution) *otto.Object {
+	notify, _ := vm.Object(`({})`)
+
+	send := func(service, textField string) func(otto.FunctionCall) otto.Value {
+		prefix := "runbox.notify." + service + ": "
+		return func(call otto.FunctionCall) otto.Value {
+			if !call.Argument(0).IsString() || call.Argument(0).String() == "" {
+				throwError(call, prefix+"expected a secret name or a webhook URL")
+			}
+			webhook, err := app.notifyWebhookURL(call.Argument(0).String())
+			if err != nil {
+				throwError(call, prefix+err.Error())
+			}
+
+			var payload interface{}
+			switch message := call.Argument(1); {
+			case message.IsString():
+				payload = map[string]interface{}{textField: message.String()}
+			case message.IsObject():
+				payload, _ = message.Export()
+			default:
+				throwError(call, prefix+"message must be a string or an object")
+			}
+			if err := postNotification(exec, webhook, payload); err != nil {
+				throwError(call, prefix+err.Error())
+			}
+			return otto.UndefinedValue()
+		}
+	}
+	notify.Set("slack", send("slack", "text"))
+	notify.Set("discord", send("discord", "content"))
+
+	return notify
+}
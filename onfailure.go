@@ -0,0 +1,171 @@
+package runbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const JobSourceFailure = "failure"
+
+// FailureHandler names the function invoked whenever another function's
+// execution fails, for custom alerting or compensation.
+type FailureHandler struct {
+	FunctionID int       `json:"functionId"`
+	HandlerID  int       `json:"handlerId"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+func (app *App) initFailureHandlersTable() error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS failure_handlers (
+		function_id INTEGER PRIMARY KEY REFERENCES functions(id) ON DELETE CASCADE,
+		handler_id INTEGER NOT NULL REFERENCES functions(id) ON DELETE CASCADE,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	if _, err := app.db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create failure_handlers table: %v", err)
+	}
+	return nil
+}
+
+func (app *App) getFailureHandler(functionID int) (*FailureHandler, error) {
+	var h FailureHandler
+	err := app.db.QueryRow(`SELECT function_id, handler_id, created_at FROM failure_handlers WHERE function_id = ?`, functionID).
+		Scan(&h.FunctionID, &h.HandlerID, &h.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+func (app *App) saveFailureHandler(functionID, handlerID int) error {
+	_, err := app.db.Exec(`INSERT INTO failure_handlers (function_id, handler_id) VALUES (?, ?)
+		ON CONFLICT (function_id) DO UPDATE SET handler_id = excluded.handler_id`, functionID, handlerID)
+	return err
+}
+
+func (app *App) removeFailureHandler(functionID int) error {
+	_, err := app.db.Exec(`DELETE FROM failure_handlers WHERE function_id = ?`, functionID)
+	return err
+}
+
+// invokeFailureHandler queues the failed function's handler, if it has one,
+// with the error, the original request and the attempt as request.body.
+// Failures of a failure handler do not invoke further handlers.
+func (app *App) invokeFailureHandler(exec *execution, execErr error) {
+	if _, ok := exec.request["failure"]; ok {
+		return
+	}
+
+	h, err := app.getFailureHandler(exec.function.ID)
+	if err != nil {
+		return
+	}
+	handler, err := app.getFunctionByID(h.HandlerID)
+	if err != nil {
+		return
+	}
+
+	failure := map[string]interface{}{
+		"function":    functionSummary(exec.function),
+		"error":       execErr.Error(),
+		"request":     exec.request,
+		"attempt":     1,
+		"maxAttempts": 1,
+		"willRetry":   false,
+		"failedAt":    time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	if job := exec.job; job != nil {
+		failure["jobId"] = job.ID
+		failure["source"] = job.Source
+		failure["attempt"] = job.Attempt
+		failure["maxAttempts"] = job.MaxAttempts
+		failure["willRetry"] = job.Attempt < job.MaxAttempts
+	}
+
+	payload, err := json.Marshal(failure)
+	if err != nil {
+		log.Printf("Failed to serialize failure of %s: %v", exec.function.Path, err)
+		return
+	}
+	requestData := syntheticRequest(handler, "FAILURE", payload)
+	requestData["failure"] = true
+
+	if _, err := app.enqueueJob(handler, requestData, jobOptions{Source: JobSourceFailure, MaxAttempts: 1}); err != nil {
+		log.Printf("Failed to queue failure handler %s for %s: %v", handler.Path, exec.function.Path, err)
+	}
+}
+
+func (app *App) getFailureHandlerHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+
+	h, err := app.getFailureHandler(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No failure handler is set for this function"})
+		return
+	}
+
+	c.JSON(http.StatusOK, h)
+}
+
+func (app *App) setFailureHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+	if _, err := app.getFunctionByID(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Function not found"})
+		return
+	}
+
+	var in struct {
+		HandlerID int `json:"handlerId"`
+	}
+	if err := c.ShouldBindJSON(&in); err != nil || in.HandlerID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "handlerId is required"})
+		return
+	}
+	if in.HandlerID == id {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A function cannot be its own failure handler"})
+		return
+	}
+	if _, err := app.getFunctionByID(in.HandlerID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Handler function not found"})
+		return
+	}
+
+	if err := app.saveFailureHandler(id, in.HandlerID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save failure handler: " + err.Error()})
+		return
+	}
+
+	h, _ := app.getFailureHandler(id)
+	c.JSON(http.StatusOK, h)
+}
+
+func (app *App) deleteFailureHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+
+	if err := app.removeFailureHandler(id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove failure handler"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Failure handler removed"})
+}
@@ -0,0 +1,466 @@
+package runbox
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/robertkrimen/otto"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	maxPDFInputBytes = 5 << 20
+	pdfMargin        = 15.0 // mm
+	pdfFontSize      = 11.0 // pt
+	pdfListIndent    = 6.0  // mm
+)
+
+var pdfHeadingSizes = map[atom.Atom]float64{
+	atom.H1: 22, atom.H2: 18, atom.H3: 15, atom.H4: 13, atom.H5: 12, atom.H6: 11,
+}
+
+// PDFOptions lays out the page of runbox.pdf and res.pdf.
+type PDFOptions struct {
+	PageSize  string // A4 (the default), Letter, Legal, A3 or A5
+	Landscape bool
+}
+
+// renderPDF lays out HTML as a PDF with the built-in renderer. It knows
+// the elements of simple documents, such as invoices and reports:
+// headings, paragraphs, line breaks, rules, bold, italic, underline,
+// links, lists, preformatted text and tables, with align on table cells.
+// Stylesheets, images and scripts are left out; the text is set in
+// Helvetica, in the Windows-1252 character set.
+func renderPDF(source string, options PDFOptions) ([]byte, error) {
+	if len(source) > maxPDFInputBytes {
+		return nil, errors.New("html is larger than 5 MiB")
+	}
+	size := options.PageSize
+	if size == "" {
+		size = "A4"
+	}
+	switch strings.ToLower(size) {
+	case "a3", "a4", "a5", "letter", "legal":
+	default:
+		return nil, fmt.Errorf("unknown page size %q (want A4, A3, A5, Letter or Legal)", size)
+	}
+	orientation := "P"
+	if options.Landscape {
+		orientation = "L"
+	}
+	doc, err := html.Parse(strings.NewReader(source))
+	if err != nil {
+		return nil, err
+	}
+
+	pdf := fpdf.New(orientation, "mm", size, "")
+	pdf.SetMargins(pdfMargin, pdfMargin, pdfMargin)
+	pdf.SetAutoPageBreak(true, pdfMargin)
+	pdf.AddPage()
+	r := &pdfRenderer{pdf: pdf, tr: pdf.UnicodeTranslatorFromDescriptor(""), size: pdfFontSize, lineStart: true}
+	r.applyFont()
+	r.walk(doc)
+
+	var out bytes.Buffer
+	if err := pdf.Output(&out); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// pdfRenderer walks the HTML tree, keeping the inline style in effect.
+type pdfRenderer struct {
+	pdf                     *fpdf.Fpdf
+	tr                      func(string) string
+	bold, italic, underline int
+	mono                    int
+	size                    float64
+	indent                  float64
+	link                    string
+	lineStart               bool
+	lists                   []int // the next number of each open list; -1 for bullets
+}
+
+func (r *pdfRenderer) lineHeight() float64 {
+	return r.size * 0.5
+}
+
+func (r *pdfRenderer) applyFont() {
+	style := ""
+	if r.bold > 0 {
+		style += "B"
+	}
+	if r.italic > 0 {
+		style += "I"
+	}
+	if r.underline > 0 || r.link != "" {
+		style += "U"
+	}
+	family := "Helvetica"
+	if r.mono > 0 {
+		family = "Courier"
+	}
+	r.pdf.SetFont(family, style, r.size)
+}
+
+// block ends the line in progress and leaves gap mm before what follows.
+func (r *pdfRenderer) block(gap float64) {
+	if !r.lineStart {
+		r.pdf.Ln(r.lineHeight())
+	}
+	if gap > 0 && r.pdf.GetY() > pdfMargin+0.1 {
+		r.pdf.Ln(gap)
+	}
+	r.pdf.SetLeftMargin(pdfMargin + r.indent)
+	r.pdf.SetX(pdfMargin + r.indent)
+	r.lineStart = true
+}
+
+func (r *pdfRenderer) write(text string) {
+	if text == "" {
+		return
+	}
+	if r.link != "" {
+		r.pdf.WriteLinkString(r.lineHeight(), r.tr(text), r.link)
+	} else {
+		r.pdf.Write(r.lineHeight(), r.tr(text))
+	}
+	r.lineStart = false
+}
+
+// text writes a text node with HTML whitespace collapsing.
+func (r *pdfRenderer) text(data string) {
+	collapsed := strings.Join(strings.Fields(data), " ")
+	if collapsed == "" {
+		if data != "" && !r.lineStart {
+			r.write(" ")
+		}
+		return
+	}
+	if !r.lineStart && strings.TrimLeft(data[:1], " \t\r\n") == "" {
+		collapsed = " " + collapsed
+	}
+	if strings.TrimRight(data[len(data)-1:], " \t\r\n") == "" {
+		collapsed += " "
+	}
+	r.write(collapsed)
+}
+
+func (r *pdfRenderer) children(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		r.walk(c)
+	}
+}
+
+func (r *pdfRenderer) walk(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		r.text(n.Data)
+		return
+	case html.DocumentNode:
+		r.children(n)
+		return
+	case html.ElementNode:
+	default:
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.Head:
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.DataAtom == atom.Title {
+				r.pdf.SetTitle(textContent(c), true)
+			}
+		}
+	case atom.Style, atom.Script, atom.Template, atom.Img, atom.Svg:
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		r.block(3)
+		size := r.size
+		r.size = pdfHeadingSizes[n.DataAtom]
+		r.bold++
+		r.applyFont()
+		r.children(n)
+		r.bold--
+		r.block(0)
+		r.size = size
+		r.applyFont()
+		r.pdf.Ln(1.5)
+	case atom.P, atom.Blockquote, atom.Address, atom.Figure:
+		r.block(2)
+		if n.DataAtom == atom.Blockquote {
+			r.indent += pdfListIndent
+			r.block(0)
+			r.children(n)
+			r.indent -= pdfListIndent
+		} else {
+			r.children(n)
+		}
+		r.block(2)
+	case atom.Div, atom.Section, atom.Article, atom.Header, atom.Footer, atom.Main, atom.Nav, atom.Aside, atom.Body, atom.Html:
+		r.block(0)
+		r.children(n)
+		r.block(0)
+	case atom.Br:
+		r.pdf.Ln(r.lineHeight())
+		r.lineStart = true
+	case atom.Hr:
+		r.block(2)
+		y := r.pdf.GetY()
+		width, _ := r.pdf.GetPageSize()
+		r.pdf.Line(pdfMargin+r.indent, y, width-pdfMargin, y)
+		r.pdf.Ln(3)
+	case atom.B, atom.Strong, atom.Th:
+		r.bold++
+		r.applyFont()
+		r.children(n)
+		r.bold--
+		r.applyFont()
+	case atom.I, atom.Em, atom.Cite:
+		r.italic++
+		r.applyFont()
+		r.children(n)
+		r.italic--
+		r.applyFont()
+	case atom.U, atom.Ins:
+		r.underline++
+		r.applyFont()
+		r.children(n)
+		r.underline--
+		r.applyFont()
+	case atom.Code, atom.Kbd, atom.Samp:
+		r.mono++
+		r.applyFont()
+		r.children(n)
+		r.mono--
+		r.applyFont()
+	case atom.A:
+		link := r.link
+		r.link = attr(n, "href")
+		r.applyFont()
+		r.children(n)
+		r.link = link
+		r.applyFont()
+	case atom.Pre:
+		r.block(2)
+		r.mono++
+		r.applyFont()
+		for i, line := range strings.Split(strings.TrimSuffix(textContent(n), "\n"), "\n") {
+			if i > 0 {
+				r.pdf.Ln(r.lineHeight())
+			}
+			r.write(strings.ReplaceAll(line, "\t", "    "))
+		}
+		r.mono--
+		r.applyFont()
+		r.block(2)
+	case atom.Ul, atom.Ol:
+		r.block(1)
+		next := -1
+		if n.DataAtom == atom.Ol {
+			next = 1
+		}
+		r.lists = append(r.lists, next)
+		r.indent += pdfListIndent
+		r.children(n)
+		r.indent -= pdfListIndent
+		r.lists = r.lists[:len(r.lists)-1]
+		r.block(1)
+	case atom.Li:
+		r.block(0)
+		marker := "•"
+		if len(r.lists) > 0 && r.lists[len(r.lists)-1] > 0 {
+			marker = fmt.Sprintf("%d.", r.lists[len(r.lists)-1])
+			r.lists[len(r.lists)-1]++
+		}
+		r.pdf.SetX(pdfMargin + r.indent - pdfListIndent + 1)
+		r.write(marker + " ")
+		r.pdf.SetX(pdfMargin + r.indent)
+		r.children(n)
+	case atom.Table:
+		r.block(2)
+		r.table(n)
+		r.block(2)
+	default:
+		r.children(n)
+	}
+}
+
+type pdfCell struct {
+	text   string
+	header bool
+	align  string
+}
+
+// table lays out a table with one bordered row of cells per tr, sized to
+// their content. Cell text is flattened; th cells are bold.
+func (r *pdfRenderer) table(n *html.Node) {
+	var rows [][]pdfCell
+	var collect func(*html.Node)
+	collect = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			switch c.DataAtom {
+			case atom.Tr:
+				var row []pdfCell
+				for cell := c.FirstChild; cell != nil; cell = cell.NextSibling {
+					if cell.DataAtom == atom.Td || cell.DataAtom == atom.Th {
+						row = append(row, pdfCell{
+							text:   strings.Join(strings.Fields(textContent(cell)), " "),
+							header: cell.DataAtom == atom.Th,
+							align:  cellAlign(cell),
+						})
+					}
+				}
+				rows = append(rows, row)
+			case atom.Thead, atom.Tbody, atom.Tfoot:
+				collect(c)
+			}
+		}
+	}
+	collect(n)
+	columns := 0
+	for _, row := range rows {
+		columns = max(columns, len(row))
+	}
+	if columns == 0 {
+		return
+	}
+
+	pageWidth, pageHeight := r.pdf.GetPageSize()
+	left := pdfMargin + r.indent
+	available := pageWidth - pdfMargin - left
+	r.pdf.SetFont("Helvetica", "B", r.size)
+	natural := make([]float64, columns)
+	total := 0.0
+	for i := range natural {
+		for _, row := range rows {
+			if i < len(row) {
+				natural[i] = max(natural[i], r.pdf.GetStringWidth(r.tr(row[i].text))+4)
+			}
+		}
+		natural[i] = max(natural[i], 12)
+		total += natural[i]
+	}
+	widths := make([]float64, columns)
+	for i := range widths {
+		widths[i] = available * natural[i] / total
+	}
+
+	lh := r.lineHeight()
+	r.pdf.SetFillColor(235, 235, 235)
+	for _, row := range rows {
+		lines := make([][]string, columns)
+		height := 0
+		for i := range lines {
+			if i < len(row) {
+				r.setCellFont(row[i])
+				lines[i] = r.pdf.SplitText(r.tr(row[i].text), widths[i]-2)
+			}
+			height = max(height, len(lines[i]), 1)
+		}
+		rowHeight := float64(height)*lh + 2
+		if r.pdf.GetY()+rowHeight > pageHeight-pdfMargin {
+			r.pdf.AddPage()
+		}
+		x, y := left, r.pdf.GetY()
+		for i := range widths {
+			cell := pdfCell{}
+			if i < len(row) {
+				cell = row[i]
+			}
+			box := "D"
+			if cell.header {
+				box = "FD"
+			}
+			r.pdf.Rect(x, y, widths[i], rowHeight, box)
+			r.setCellFont(cell)
+			for j, line := range lines[i] {
+				r.pdf.SetXY(x+1, y+1+float64(j)*lh)
+				r.pdf.CellFormat(widths[i]-2, lh, line, "", 0, cell.align, false, 0, "")
+			}
+			x += widths[i]
+		}
+		r.pdf.SetXY(left, y+rowHeight)
+	}
+	r.lineStart = true
+	r.applyFont()
+}
+
+func (r *pdfRenderer) setCellFont(cell pdfCell) {
+	style := ""
+	if cell.header {
+		style = "B"
+	}
+	r.pdf.SetFont("Helvetica", style, r.size)
+}
+
+// cellAlign reads align="right" or an inline text-align on a cell.
+func cellAlign(n *html.Node) string {
+	align := strings.ToLower(attr(n, "align"))
+	if style := strings.ReplaceAll(strings.ToLower(attr(n, "style")), " ", ""); strings.Contains(style, "text-align:") {
+		align = strings.SplitN(strings.SplitN(style, "text-align:", 2)[1], ";", 2)[0]
+	}
+	switch align {
+	case "right":
+		return "R"
+	case "center":
+		return "C"
+	}
+	return "L"
+}
+
+func attr(n *html.Node, name string) string {
+	for _, a := range n.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func textContent(n *html.Node) string {
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		if n.DataAtom == atom.Br {
+			b.WriteString("\n")
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return b.String()
+}
+
+// pdfOptions reads {pageSize, landscape} from a JavaScript argument.
+func pdfOptions(arg otto.Value) PDFOptions {
+	var options PDFOptions
+	if !arg.IsObject() {
+		return options
+	}
+	if v, _ := arg.Object().Get("pageSize"); v.IsString() {
+		options.PageSize = v.String()
+	}
+	if v, _ := arg.Object().Get("landscape"); v.IsBoolean() {
+		options.Landscape, _ = v.ToBoolean()
+	}
+	return options
+}
+
+// jsPDF backs runbox.pdf(html, options), which answers the PDF
+// base64-encoded, and the routers' res.pdf.
+func jsPDF(call otto.FunctionCall) otto.Value {
+	out, err := renderPDF(call.Argument(0).String(), pdfOptions(call.Argument(1)))
+	if err != nil {
+		throwError(call, "runbox.pdf: "+err.Error())
+	}
+	return toValue(call, base64.StdEncoding.EncodeToString(out))
+}
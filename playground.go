@@ -0,0 +1,69 @@
+package runbox
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// playgroundPath is the path playground code runs as; it is a wildcard
+// mount so routers see the subpath typed in.
+const playgroundPath = "/playground/*"
+
+// playgroundStarters is the code the playground opens with in each mode.
+var playgroundStarters = map[string]string{
+	ModeStandard: `// request holds the method, path, query, headers and body sent below.
+function GET(request) {
+  console.log('hello from the playground');
+  return { message: 'Hello, ' + (request.query.name || 'world') + '!' };
+}
+
+function POST(request) {
+  return { received: request.body };
+}
+`,
+	ModeLambda: `exports.handler = function (event, context) {
+  return {
+    statusCode: 200,
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ method: event.httpMethod, path: event.path })
+  };
+};
+`,
+	ModeWorkers: `export default {
+  fetch: function (request, env) {
+    return Response.json({ method: request.method, url: request.url });
+  }
+};
+`,
+	ModeGraphQL: `// POST {"query": "{ hello(name: \"runbox\") }"} below.
+var typeDefs = 'type Query { hello(name: String): String! }';
+
+var resolvers = {
+  Query: {
+    hello: function (parent, args) {
+      return 'Hello, ' + (args.name || 'world') + '!';
+    }
+  }
+};
+`,
+	ModeXML: `// POST <order><id>42</id></order> below with Content-Type: application/xml.
+function POST(request) {
+  var id = request.xml.text('/order/id');
+  return { order: { '@status': 'received', id: id } };
+}
+`,
+}
+
+// playgroundPage serves GET /playground, an editor that runs code through
+// the dry-run endpoint without creating a function.
+func (app *App) playgroundPage(c *gin.Context) {
+	templates, _ := app.functionTemplates()
+	c.HTML(http.StatusOK, "playground.html", gin.H{
+		"title":     "Playground",
+		"modes":     functionModes,
+		"starters":  playgroundStarters,
+		"templates": templates,
+		"path":      playgroundPath,
+	})
+}
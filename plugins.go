@@ -0,0 +1,160 @@
+package runbox
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"sync"
+
+	"github.com/robertkrimen/otto"
+)
+
+var hostNamePattern = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// HostFunction is a native function added to the runbox global of every
+// function, such as a client for a company-internal service. Register it
+// with RegisterHostFunction from an init function, in a package that the
+// program embedding RunBox imports:
+//
+//	func init() {
+//		runbox.RegisterHostFunction(runbox.HostFunction{
+//			Namespace: "acme",
+//			Name:      "lookup",
+//			Params:    "id: string",
+//			Returns:   "{ name: string }",
+//			Doc:       "Looks up a customer in the Acme directory.",
+//			Call: func(call *runbox.HostCall) (interface{}, error) {
+//				return directory.Lookup(call.Context, fmt.Sprint(call.Args[0]))
+//			},
+//		})
+//	}
+//
+// Code then calls runbox.acme.lookup('42'). Params, Returns, Doc and
+// Example feed the editor's typings and API reference, as they do for the
+// built-in functions.
+type HostFunction struct {
+	// Namespace groups the function under runbox, for runbox.acme.lookup;
+	// empty puts it on runbox itself. The built-in namespaces are taken.
+	Namespace string
+	Name      string
+	Params    string // TypeScript parameter list
+	Returns   string // TypeScript type; any when empty
+	Doc       string
+	Example   string
+
+	// Call runs the function. What it answers is converted to JavaScript;
+	// an error, or a panic, is thrown as an Error.
+	Call func(call *HostCall) (interface{}, error)
+}
+
+// HostCall is one call of a HostFunction from function code.
+type HostCall struct {
+	// Context is cancelled when the execution times out or its request
+	// goes away.
+	Context      context.Context
+	FunctionID   int
+	FunctionPath string
+	// RequestID is the X-Request-ID of the request that led to the
+	// execution.
+	RequestID string
+	// Args are the arguments exported to Go, as otto.Value.Export does:
+	// strings, numbers, bools, nil, slices and map[string]interface{}.
+	Args []interface{}
+}
+
+var (
+	pluginsMu       sync.RWMutex
+	pluginFunctions []HostFunction
+)
+
+// RegisterHostFunction adds f to the runbox global of functions run from
+// then on. It panics if f has no Call, its names aren't JavaScript
+// identifiers, or the name is taken, like database/sql's Register.
+func RegisterHostFunction(f HostFunction) {
+	if f.Call == nil {
+		panic("runbox: RegisterHostFunction " + f.Name + " has no Call")
+	}
+	if !hostNamePattern.MatchString(f.Name) || (f.Namespace != "" && !hostNamePattern.MatchString(f.Namespace)) {
+		panic(fmt.Sprintf("runbox: RegisterHostFunction: %q.%q is not a JavaScript name", f.Namespace, f.Name))
+	}
+
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	namespace := "runbox"
+	if f.Namespace != "" {
+		namespace += "." + f.Namespace
+	}
+	pluginNamespace := slices.ContainsFunc(pluginFunctions, func(p HostFunction) bool { return p.Namespace == namespace })
+	for _, existing := range hostFunctions {
+		switch {
+		case existing.Namespace == namespace && existing.Name == f.Name,
+			f.Namespace == "" && existing.Namespace == "runbox."+f.Name,
+			existing.Namespace == "runbox" && existing.Name == f.Namespace,
+			f.Namespace != "" && existing.Namespace == namespace && !pluginNamespace:
+			panic("runbox: RegisterHostFunction: " + namespace + "." + f.Name + " is already taken")
+		}
+	}
+
+	// Entries of one namespace stay together, as the typings declare each
+	// namespace once.
+	if f.Returns == "" {
+		f.Returns = "any"
+	}
+	entry := hostFunction{namespace, f.Name, f.Params, f.Returns, f.Doc, f.Example}
+	at := len(hostFunctions)
+	for i, existing := range hostFunctions {
+		if existing.Namespace == namespace {
+			at = i + 1
+		}
+	}
+	hostFunctions = slices.Insert(hostFunctions, at, entry)
+	f.Namespace = namespace
+	pluginFunctions = append(pluginFunctions, f)
+}
+
+// installPlugins adds the registered host functions to the runbox object.
+func installPlugins(vm *otto.Otto, runbox *otto.Object, exec *execution) {
+	pluginsMu.RLock()
+	defer pluginsMu.RUnlock()
+	namespaces := map[string]*otto.Object{"runbox": runbox}
+	for _, f := range pluginFunctions {
+		object, ok := namespaces[f.Namespace]
+		if !ok {
+			object, _ = vm.Object(`({})`)
+			runbox.Set(f.Namespace[len("runbox."):], object)
+			namespaces[f.Namespace] = object
+		}
+		object.Set(f.Name, pluginCall(f, exec))
+	}
+}
+
+func pluginCall(f HostFunction, exec *execution) func(otto.FunctionCall) otto.Value {
+	qualified := f.Namespace + "." + f.Name
+	return func(call otto.FunctionCall) otto.Value {
+		args := make([]interface{}, len(call.ArgumentList))
+		for i, arg := range call.ArgumentList {
+			args[i], _ = arg.Export()
+		}
+		hostCall := &HostCall{
+			Context:      exec.ctx,
+			FunctionID:   exec.function.ID,
+			FunctionPath: exec.function.Path,
+			RequestID:    exec.requestID,
+			Args:         args,
+		}
+
+		result, err := func() (result interface{}, err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("panic: %v", r)
+				}
+			}()
+			return f.Call(hostCall)
+		}()
+		if err != nil {
+			throwError(call, qualified+": "+err.Error())
+		}
+		return toValue(call, result)
+	}
+}
@@ -0,0 +1,393 @@
+package runbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bufbuild/protocompile"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const (
+	protobufContentType = "application/x-protobuf"
+	maxProtoSchemaBytes = 1 << 20
+	protoSchemasTTL     = 30 * time.Second
+)
+
+// FunctionProto is a function's protobuf schema: the descriptors of its
+// .proto files and the messages its requests and results are. Requests
+// sent as protobuf are decoded into request.body as RequestType, and
+// results are encoded as ResponseType for clients that accept protobuf.
+// The unary methods of its services can be called with runbox.grpc.
+type FunctionProto struct {
+	FunctionID int `json:"functionId"`
+	// DescriptorSet is a serialized google.protobuf.FileDescriptorSet, as
+	// protoc --include_imports --descriptor_set_out writes. Files, .proto
+	// sources by file name, can be given instead when saving.
+	DescriptorSet []byte            `json:"descriptorSet"`
+	Files         map[string]string `json:"files,omitempty"`
+	RequestType   string            `json:"requestType"`
+	ResponseType  string            `json:"responseType"`
+	Messages      []string          `json:"messages"`
+	Methods       []string          `json:"methods"`
+	UpdatedAt     time.Time         `json:"updatedAt"`
+}
+
+func (app *App) initFunctionProtosTable() error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS function_protos (
+		function_id INTEGER PRIMARY KEY,
+		descriptor_set BLOB NOT NULL,
+		request_type TEXT NOT NULL DEFAULT '',
+		response_type TEXT NOT NULL DEFAULT '',
+		updated_at DATETIME NOT NULL
+	);`
+
+	if _, err := app.db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create function_protos table: %v", err)
+	}
+	return nil
+}
+
+// protoSchema is a compiled FunctionProto.
+type protoSchema struct {
+	request  protoreflect.MessageDescriptor
+	response protoreflect.MessageDescriptor
+	messages []string
+	methods  []string // as service/Method
+	files    *protoregistry.Files
+}
+
+// compileProtoFiles compiles .proto sources, which may import each other
+// and the well-known types, into a descriptor set that has every file
+// they need.
+func compileProtoFiles(sources map[string]string) ([]byte, error) {
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{
+			Accessor: protocompile.SourceAccessorFromMap(sources),
+		}),
+	}
+	files, err := compiler.Compile(context.Background(), names...)
+	if err != nil {
+		return nil, err
+	}
+
+	set := &descriptorpb.FileDescriptorSet{}
+	added := map[string]bool{}
+	var add func(protoreflect.FileDescriptor)
+	add = func(fd protoreflect.FileDescriptor) {
+		if added[fd.Path()] {
+			return
+		}
+		added[fd.Path()] = true
+		imports := fd.Imports()
+		for i := 0; i < imports.Len(); i++ {
+			add(imports.Get(i).FileDescriptor)
+		}
+		set.File = append(set.File, protodesc.ToFileDescriptorProto(fd))
+	}
+	for _, fd := range files {
+		add(fd)
+	}
+	return proto.Marshal(set)
+}
+
+// compileProtoSchema checks a descriptor set and finds the request and
+// response messages in it.
+func compileProtoSchema(descriptorSet []byte, requestType, responseType string) (*protoSchema, error) {
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(descriptorSet, &set); err != nil {
+		return nil, fmt.Errorf("descriptorSet is not a FileDescriptorSet: %v", err)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("%v (build the set with protoc --include_imports)", err)
+	}
+
+	schema := &protoSchema{messages: []string{}, methods: []string{}, files: files}
+	files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		for i := 0; i < fd.Services().Len(); i++ {
+			service := fd.Services().Get(i)
+			for j := 0; j < service.Methods().Len(); j++ {
+				schema.methods = append(schema.methods, string(service.FullName())+"/"+string(service.Methods().Get(j).Name()))
+			}
+		}
+		var walk func(protoreflect.MessageDescriptors)
+		walk = func(messages protoreflect.MessageDescriptors) {
+			for i := 0; i < messages.Len(); i++ {
+				if !messages.Get(i).IsMapEntry() {
+					schema.messages = append(schema.messages, string(messages.Get(i).FullName()))
+				}
+				walk(messages.Get(i).Messages())
+			}
+		}
+		walk(fd.Messages())
+		return true
+	})
+	sort.Strings(schema.messages)
+	sort.Strings(schema.methods)
+
+	find := func(field, name string) (protoreflect.MessageDescriptor, error) {
+		if name == "" {
+			return nil, nil
+		}
+		desc, err := files.FindDescriptorByName(protoreflect.FullName(strings.TrimPrefix(name, ".")))
+		if err == protoregistry.NotFound {
+			return nil, fmt.Errorf("%s: no message named %s", field, name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		md, ok := desc.(protoreflect.MessageDescriptor)
+		if !ok {
+			return nil, fmt.Errorf("%s: %s is not a message", field, name)
+		}
+		return md, nil
+	}
+	if schema.request, err = find("requestType", requestType); err != nil {
+		return nil, err
+	}
+	if schema.response, err = find("responseType", responseType); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+// isProtobuf reports whether a media type is one protobuf is sent as.
+func isProtobuf(mediaType string) bool {
+	switch mediaType {
+	case protobufContentType, "application/protobuf", "application/vnd.google.protobuf":
+		return true
+	}
+	return false
+}
+
+// wantsProtobuf reports whether the client asked for a protobuf answer:
+// by Accept, or by sending protobuf without naming what it accepts.
+func wantsProtobuf(c *gin.Context) bool {
+	accept := strings.TrimSpace(c.GetHeader("Accept"))
+	if accept == "" || accept == "*/*" {
+		return isProtobuf(c.ContentType())
+	}
+	for _, part := range strings.Split(accept, ",") {
+		if mediaType, _, err := mime.ParseMediaType(part); err == nil && isProtobuf(mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeRequest replaces request.body with the protobuf rawBody decoded
+// as the request message, in the proto3 JSON mapping: lowerCamelCase
+// names, every field present, 64-bit integers and bytes as strings.
+func (s *protoSchema) decodeRequest(requestData map[string]interface{}) error {
+	if s.request == nil {
+		return errors.New("the function's protobuf schema has no requestType")
+	}
+	raw, _ := requestData["rawBody"].(string)
+	message := dynamicpb.NewMessage(s.request)
+	if err := proto.Unmarshal([]byte(raw), message); err != nil {
+		return fmt.Errorf("the body is not a valid %s: %v", s.request.FullName(), err)
+	}
+	encoded, err := protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(message)
+	if err != nil {
+		return err
+	}
+	var body interface{}
+	if err := json.Unmarshal(encoded, &body); err != nil {
+		return err
+	}
+	requestData["body"] = body
+	return nil
+}
+
+// encodeResult encodes a handler's result as the response message.
+func (s *protoSchema) encodeResult(result interface{}) ([]byte, error) {
+	if s.response == nil {
+		return nil, errors.New("the function's protobuf schema has no responseType")
+	}
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	message := dynamicpb.NewMessage(s.response)
+	if err := protojson.Unmarshal(encoded, message); err != nil {
+		return nil, fmt.Errorf("the result is not a valid %s: %v", s.response.FullName(), err)
+	}
+	return proto.Marshal(message)
+}
+
+// protoCache holds the compiled schemas of every function, reloaded every
+// protoSchemasTTL and when one changes.
+type protoCache struct {
+	mu       sync.Mutex
+	schemas  map[int]*protoSchema
+	loadedAt time.Time
+}
+
+func (pc *protoCache) invalidate() {
+	pc.mu.Lock()
+	pc.loadedAt = time.Time{}
+	pc.mu.Unlock()
+}
+
+func (app *App) protoSchemaFor(functionID int) *protoSchema {
+	pc := app.protos
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	if time.Since(pc.loadedAt) > protoSchemasTTL {
+		if rows, err := app.db.Query(`SELECT function_id, descriptor_set, request_type, response_type FROM function_protos`); err == nil {
+			schemas := map[int]*protoSchema{}
+			for rows.Next() {
+				var id int
+				var set []byte
+				var requestType, responseType string
+				if rows.Scan(&id, &set, &requestType, &responseType) != nil {
+					continue
+				}
+				if schema, err := compileProtoSchema(set, requestType, responseType); err == nil {
+					schemas[id] = schema
+				}
+			}
+			rows.Close()
+			pc.schemas = schemas
+		}
+		pc.loadedAt = time.Now()
+	}
+	return pc.schemas[functionID]
+}
+
+func (app *App) getFunctionProto(id int) (*FunctionProto, error) {
+	p := FunctionProto{FunctionID: id}
+	err := app.db.QueryRow(`SELECT descriptor_set, request_type, response_type, updated_at FROM function_protos WHERE function_id = ?`, id).
+		Scan(&p.DescriptorSet, &p.RequestType, &p.ResponseType, &p.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	p.Messages, p.Methods = []string{}, []string{}
+	if schema, err := compileProtoSchema(p.DescriptorSet, "", ""); err == nil {
+		p.Messages, p.Methods = schema.messages, schema.methods
+	}
+	return &p, nil
+}
+
+func (app *App) getFunctionProtoHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+
+	p, err := app.getFunctionProto(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No protobuf schema is set for this function"})
+		return
+	}
+	c.JSON(http.StatusOK, p)
+}
+
+// setFunctionProto serves PUT /api/functions/:id/proto with a descriptor
+// set or .proto files and the request and response message names.
+func (app *App) setFunctionProto(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+	if _, err := app.getFunctionByID(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Function not found"})
+		return
+	}
+
+	var in FunctionProto
+	if err := json.NewDecoder(io.LimitReader(c.Request.Body, 2*maxProtoSchemaBytes)).Decode(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid protobuf schema body"})
+		return
+	}
+	switch {
+	case len(in.Files) > 0 && len(in.DescriptorSet) > 0:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Give descriptorSet or files, not both"})
+		return
+	case len(in.Files) > 0:
+		if in.DescriptorSet, err = compileProtoFiles(in.Files); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	case len(in.DescriptorSet) == 0:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "descriptorSet or files is required"})
+		return
+	}
+	if len(in.DescriptorSet) > maxProtoSchemaBytes {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The descriptor set is larger than 1 MiB"})
+		return
+	}
+	schema, err := compileProtoSchema(in.DescriptorSet, in.RequestType, in.ResponseType)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if in.RequestType == "" && in.ResponseType == "" && len(schema.methods) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "requestType or responseType is required when the schema has no services"})
+		return
+	}
+
+	_, err = app.db.Exec(`INSERT INTO function_protos (function_id, descriptor_set, request_type, response_type, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (function_id) DO UPDATE SET descriptor_set = excluded.descriptor_set, request_type = excluded.request_type,
+			response_type = excluded.response_type, updated_at = excluded.updated_at`,
+		id, in.DescriptorSet, in.RequestType, in.ResponseType, time.Now().UTC())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save protobuf schema: " + err.Error()})
+		return
+	}
+	app.protos.invalidate()
+	app.broadcastChange(changeSettings, id)
+
+	p, _ := app.getFunctionProto(id)
+	c.JSON(http.StatusOK, p)
+}
+
+func (app *App) deleteFunctionProto(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+
+	res, err := app.db.Exec(`DELETE FROM function_protos WHERE function_id = ?`, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove protobuf schema"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No protobuf schema is set for this function"})
+		return
+	}
+	app.protos.invalidate()
+	app.broadcastChange(changeSettings, id)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Protobuf schema removed"})
+}
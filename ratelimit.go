@@ -0,0 +1,85 @@
+package runbox
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxRateLimitClients bounds the buckets kept; past it, the buckets of
+// clients that have refilled are dropped.
+const maxRateLimitClients = 10000
+
+// tokenBucket holds a client's remaining requests as of updated.
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// rateLimiter keeps a token bucket per client IP.
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: map[string]*tokenBucket{}}
+}
+
+func (l *rateLimiter) reset() {
+	l.mu.Lock()
+	l.buckets = map[string]*tokenBucket{}
+	l.mu.Unlock()
+}
+
+// allow takes a token from client's bucket, or answers how long until one
+// is available.
+func (l *rateLimiter) allow(client string, config RateLimitConfig, now time.Time) (bool, time.Duration) {
+	burst := float64(config.Burst)
+	if burst == 0 {
+		burst = math.Max(config.RequestsPerSecond, 1)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[client]
+	if !ok {
+		if len(l.buckets) >= maxRateLimitClients {
+			for id, other := range l.buckets {
+				if other.tokens+now.Sub(other.updated).Seconds()*config.RequestsPerSecond >= burst {
+					delete(l.buckets, id)
+				}
+			}
+		}
+		b = &tokenBucket{tokens: burst, updated: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.updated).Seconds()*config.RequestsPerSecond)
+	b.updated = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / config.RequestsPerSecond * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// rateLimitMiddleware answers 429 to clients over rateLimit on the
+// execute routes.
+func (app *App) rateLimitMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		config := app.live().RateLimit
+		if config.RequestsPerSecond <= 0 {
+			return
+		}
+		ok, wait := app.rateLimits.allow(c.ClientIP(), config, time.Now())
+		if ok {
+			return
+		}
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded"})
+	}
+}
@@ -0,0 +1,62 @@
+package runbox
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errReadOnly = errors.New("RunBox is in read-only mode")
+
+// readOnlyWrites are the routes a read-only instance still takes writes
+// on: they run or check functions without changing them. GraphQL
+// mutations are refused by their resolvers.
+var readOnlyWrites = map[string]bool{
+	"/api/execute/*path":         true,
+	"/api/execute-async/*path":   true,
+	"/api/delayed":               true,
+	"/api/topics/:topic/publish": true,
+	"/api/workflows/:id/runs":    true,
+	"/api/cloudevents":           true,
+	"/api/functions/validate":    true,
+	"/api/bundle/preview":        true,
+	"/api/flags/:name/evaluate":  true,
+	"/api/render/:name":          true,
+	"/api/graphql":               true,
+	"/api/maintenance":           true,
+	"/api/config/reload":         true,
+	"/login":                     true,
+	"/logout":                    true,
+}
+
+// readOnlyPages are the editor pages, which would only lead to refused
+// saves.
+var readOnlyPages = map[string]bool{
+	"/functions/create":   true,
+	"/functions/:id/edit": true,
+	"/playground":         true,
+}
+
+// readOnlyMiddleware answers 403 to every create, update and delete when
+// the instance runs with -read-only, such as a replica whose functions
+// come from another instance or from git.
+func (app *App) readOnlyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !app.config.ReadOnly {
+			return
+		}
+		route := c.FullPath()
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			if readOnlyPages[route] {
+				c.HTML(http.StatusForbidden, "error.html", gin.H{"error": errReadOnly.Error()})
+				c.Abort()
+			}
+		default:
+			if route != "" && !readOnlyWrites[route] {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": errReadOnly.Error()})
+			}
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package runbox
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// hostGlobal documents a value the runtime defines, as opposed to a host
+// function, for the API reference. Modes limits it to the handler modes
+// that define it; empty means every mode.
+type hostGlobal struct {
+	Section string
+	Name    string
+	Type    string
+	Modes   []string
+	Doc     string
+	Example string
+}
+
+var hostGlobals = []hostGlobal{
+	{"Request", "request", "RunboxRequest", nil,
+		"The request that invoked the function: id, method, path, url, subpath, query, headers, body (parsed JSON or form, or { raw }) and rawBody. Scheduled and event runs add schedule or event.",
+		`function POST(request) {
+  return { name: request.body.name, agent: request.headers['User-Agent'] };
+}`},
+	{"Request", "request.xml", "RunboxXMLNode", []string{ModeXML},
+		"The body as an XML document; select, selectOne, text and evaluate take XPath. A GET, POST, ... or default handler's string or object result is sent as XML, and routers work as in standard functions with res.xml(body).",
+		`function POST(request) {
+  var id = request.xml.text('//soap:Body/GetOrder/id');
+  return '<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body><Order id="' + id + '"/></soap:Body></soap:Envelope>';
+}`},
+	{"Response", "return value", "any", []string{ModeStandard},
+		"A GET, POST, ... or default handler's return value is sent as JSON with status 200.",
+		`function GET(request) {
+  return { ok: true };
+}`},
+	{"Response", "app", "Router", []string{ModeStandard, ModeXML},
+		"Routes request.subpath to handlers with :params. res.status(code), res.set(name, value), res.json(body) and res.send(body) build the response.",
+		`app.get('/items/:id', function (req, res) {
+  res.status(200).json({ id: req.params.id });
+});`},
+	{"Response", "exports.handler", "(event: LambdaEvent, context: LambdaContext, callback) => LambdaResult", []string{ModeLambda},
+		"Called with an API Gateway proxy event; the { statusCode, headers, body } it returns, or passes to the callback, is the response.",
+		`exports.handler = function (event, context) {
+  return { statusCode: 200, body: JSON.stringify({ path: event.path }) };
+};`},
+	{"Response", "export default { fetch }", "ExportedHandler", []string{ModeWorkers},
+		"fetch(request, env, ctx) returns a Response; env holds the environment variables. addEventListener('fetch', ...) with event.respondWith works too.",
+		`export default {
+  fetch: function (request, env) {
+    return Response.json({ url: request.url, region: env.REGION });
+  }
+};`},
+	{"Response", "typeDefs and resolvers", "GraphQLFieldResolver", []string{ModeGraphQL},
+		"typeDefs is the schema in SDL; resolvers.Type.field(parent, args, context, info) resolves a field. Queries are served over GET and POST, and a browser GET opens GraphiQL.",
+		`var typeDefs = 'type Query { user(id: ID!): User } type User { id: ID! name: String }';
+var resolvers = {
+  Query: { user: function (parent, args) { return { id: args.id, name: 'Ada' }; } }
+};`},
+	{"Environment", "process.env", "{ [name: string]: string }", nil,
+		"The function's environment variables, global ones overridden by its own, as set on the Environment page and the Settings tab.",
+		`var apiKey = process.env.API_KEY;`},
+	{"Console", "console.log", "(...values: any[]) => void", nil,
+		"console.log, info, warn and error keep their output with the execution log and stream it to live tails.",
+		`console.log('processing', request.id);`},
+}
+
+// referenceEntry is one item of the API reference panel.
+type referenceEntry struct {
+	Name      string `json:"name"`
+	Signature string `json:"signature"`
+	Doc       string `json:"doc"`
+	Example   string `json:"example,omitempty"`
+}
+
+type referenceSection struct {
+	Title   string           `json:"title"`
+	Entries []referenceEntry `json:"entries"`
+}
+
+// apiReference lists what code in mode can use: the globals first, then
+// the host functions by namespace, in registration order.
+func apiReference(mode string) []referenceSection {
+	var sections []referenceSection
+	add := func(title string, e referenceEntry) {
+		if n := len(sections); n > 0 && sections[n-1].Title == title {
+			sections[n-1].Entries = append(sections[n-1].Entries, e)
+			return
+		}
+		sections = append(sections, referenceSection{Title: title, Entries: []referenceEntry{e}})
+	}
+
+	for _, g := range hostGlobals {
+		if len(g.Modes) > 0 && !slices.Contains(g.Modes, mode) {
+			continue
+		}
+		add(g.Section, referenceEntry{Name: g.Name, Signature: g.Name + ": " + g.Type, Doc: g.Doc, Example: g.Example})
+	}
+	for _, f := range hostFunctions {
+		title, name := "Globals", f.Name
+		if f.Namespace != "" {
+			title, name = f.Namespace, f.Namespace+"."+f.Name
+		}
+		add(title, referenceEntry{
+			Name:      name,
+			Signature: fmt.Sprintf("%s(%s): %s", name, f.Params, f.Returns),
+			Doc:       f.Doc,
+			Example:   f.Example,
+		})
+	}
+	return sections
+}
+
+// referenceHandler serves GET /api/reference?mode= for the editor's API
+// reference panel.
+func (app *App) referenceHandler(c *gin.Context) {
+	mode := c.DefaultQuery("mode", ModeStandard)
+	if !validMode(mode) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid mode " + mode})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"mode": mode, "sections": apiReference(mode)})
+}
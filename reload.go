@@ -0,0 +1,171 @@
+package runbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Log levels. Below info, successful requests are left out of the
+// request log.
+const (
+	LogDebug = "debug"
+	LogInfo  = "info"
+	LogWarn  = "warn"
+	LogError = "error"
+)
+
+// liveSettings are the parts of the config that reload without a
+// restart. Requests read them once, so an execution in flight finishes
+// with the settings it started with.
+type liveSettings struct {
+	Timeouts  TimeoutsConfig
+	RateLimit RateLimitConfig
+	CORS      CORSConfig
+	LogLevel  string
+}
+
+func (c Config) liveSettings() (*liveSettings, error) {
+	s := &liveSettings{Timeouts: c.Timeouts, RateLimit: c.RateLimit, CORS: c.CORS, LogLevel: c.LogLevel}
+	switch s.LogLevel {
+	case "":
+		s.LogLevel = LogInfo
+	case LogDebug, LogInfo, LogWarn, LogError:
+	default:
+		return nil, fmt.Errorf("unknown log level %q (want debug, info, warn or error)", s.LogLevel)
+	}
+	if s.Timeouts.ExecutionSeconds < 0 || s.Timeouts.JobSeconds < 0 {
+		return nil, fmt.Errorf("timeouts.executionSeconds and timeouts.jobSeconds must not be negative")
+	}
+	if s.RateLimit.RequestsPerSecond < 0 || s.RateLimit.Burst < 0 {
+		return nil, fmt.Errorf("rateLimit.requestsPerSecond and rateLimit.burst must not be negative")
+	}
+	return s, nil
+}
+
+// live answers the settings in effect.
+func (app *App) live() *liveSettings {
+	return app.settings.Load()
+}
+
+// executionTimeout is how long an HTTP execution may run, or 0.
+func (s *liveSettings) executionTimeout() time.Duration {
+	return time.Duration(s.Timeouts.ExecutionSeconds) * time.Second
+}
+
+// executionContext bounds an HTTP execution by the execution timeout.
+func (s *liveSettings) executionContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if timeout := s.executionTimeout(); timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// defaultJobTimeout bounds job attempts when neither timeout is set, so a
+// function that never returns can't hold a worker for good.
+const defaultJobTimeout = 5 * time.Minute
+
+// jobTimeout is how long an attempt of a job may run.
+func (s *liveSettings) jobTimeout() time.Duration {
+	if s.Timeouts.JobSeconds > 0 {
+		return time.Duration(s.Timeouts.JobSeconds) * time.Second
+	}
+	if timeout := s.executionTimeout(); timeout > 0 {
+		return timeout
+	}
+	return defaultJobTimeout
+}
+
+// skipRequestLog leaves requests out of the request log below the level.
+func (app *App) skipRequestLog(c *gin.Context) bool {
+	switch app.live().LogLevel {
+	case LogWarn:
+		return c.Writer.Status() < http.StatusBadRequest
+	case LogError:
+		return c.Writer.Status() < http.StatusInternalServerError
+	}
+	return false
+}
+
+// reloadConfig reads the config file again and applies its live settings,
+// answering which ones changed. A file that doesn't load leaves the
+// current settings in place. Everything else needs a restart.
+func (app *App) reloadConfig() ([]string, error) {
+	if app.config.path == "" {
+		return nil, errors.New("the config wasn't loaded from a file")
+	}
+	config, err := loadConfig(app.config.path, app.config.explicit)
+	if err != nil {
+		return nil, err
+	}
+	next, err := config.liveSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	current := app.live()
+	changed := []string{}
+	if !reflect.DeepEqual(current.Timeouts, next.Timeouts) {
+		changed = append(changed, "timeouts")
+	}
+	rateLimitChanged := !reflect.DeepEqual(current.RateLimit, next.RateLimit)
+	if rateLimitChanged {
+		changed = append(changed, "rateLimit")
+	}
+	if !reflect.DeepEqual(current.CORS, next.CORS) {
+		changed = append(changed, "cors")
+	}
+	if current.LogLevel != next.LogLevel {
+		changed = append(changed, "logLevel")
+	}
+	app.settings.Store(next)
+	if rateLimitChanged {
+		app.rateLimits.reset()
+	}
+	log.Printf("Reloaded %s: changed %v", app.config.path, changed)
+	return changed, nil
+}
+
+// startConfigReload reloads the config file on SIGHUP until the func it
+// answers is called.
+func (app *App) startConfigReload() (stop func()) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-hup:
+				if _, err := app.reloadConfig(); err != nil {
+					log.Println("Failed to reload config:", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(hup)
+		close(done)
+	}
+}
+
+// reloadConfigHandler serves POST /api/config/reload, the same reload as
+// SIGHUP for instances that can't be signalled.
+func (app *App) reloadConfigHandler(c *gin.Context) {
+	changed, err := app.reloadConfig()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to reload config: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"reloaded": true, "changed": changed})
+}
@@ -0,0 +1,287 @@
+package runbox
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	htmltemplate "html/template"
+	"net/http"
+	"regexp"
+	"sync"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/robertkrimen/otto"
+)
+
+// Render template types: html escapes data for the context it lands in,
+// text leaves it as it is, for emails and other plain formats.
+const (
+	RenderHTML = "html"
+	RenderText = "text"
+)
+
+const (
+	maxRenderTemplateBytes = 256 << 10
+	maxRenderOutputBytes   = 5 << 20
+	renderTemplatesTTL     = 30 * time.Second
+)
+
+var renderTemplateNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,127}$`)
+
+// RenderTemplate is a named Go template that functions fill in with
+// runbox.render. Templates of one type can include each other with
+// {{template "name" .}}.
+type RenderTemplate struct {
+	Name        string    `json:"name"`
+	Type        string    `json:"type"`
+	Description string    `json:"description"`
+	Content     string    `json:"content"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+func (app *App) initRenderTemplatesTable() error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS render_templates (
+		name TEXT PRIMARY KEY,
+		type TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		content TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	);`
+
+	if _, err := app.db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create render_templates table: %v", err)
+	}
+	return nil
+}
+
+const renderTemplateColumns = `name, type, description, content, created_at, updated_at`
+
+func scanRenderTemplate(row rowScanner) (*RenderTemplate, error) {
+	var t RenderTemplate
+	if err := row.Scan(&t.Name, &t.Type, &t.Description, &t.Content, &t.CreatedAt, &t.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (app *App) getRenderTemplate(name string) (*RenderTemplate, error) {
+	return scanRenderTemplate(app.db.QueryRow(`SELECT `+renderTemplateColumns+` FROM render_templates WHERE name = ?`, name))
+}
+
+func (app *App) listRenderTemplates() ([]RenderTemplate, error) {
+	rows, err := app.db.Query(`SELECT ` + renderTemplateColumns + ` FROM render_templates ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	templates := []RenderTemplate{}
+	for rows.Next() {
+		t, err := scanRenderTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, *t)
+	}
+	return templates, rows.Err()
+}
+
+// validate checks the name and type and that the content parses.
+func (t *RenderTemplate) validate() error {
+	if !renderTemplateNamePattern.MatchString(t.Name) {
+		return fmt.Errorf("name must start with a letter or digit and contain only letters, digits, '_', '.' and '-', up to 128 characters")
+	}
+	if t.Type == "" {
+		t.Type = RenderHTML
+	}
+	if len(t.Content) > maxRenderTemplateBytes {
+		return fmt.Errorf("content is larger than 256 KiB")
+	}
+	var err error
+	switch t.Type {
+	case RenderHTML:
+		_, err = htmltemplate.New(t.Name).Parse(t.Content)
+	case RenderText:
+		_, err = texttemplate.New(t.Name).Parse(t.Content)
+	default:
+		return fmt.Errorf("type must be %s or %s", RenderHTML, RenderText)
+	}
+	return err
+}
+
+// renderCache holds every stored template parsed into one set per type,
+// reloaded every renderTemplatesTTL and when a template changes.
+type renderCache struct {
+	mu       sync.Mutex
+	html     *htmltemplate.Template
+	text     *texttemplate.Template
+	types    map[string]string
+	loadedAt time.Time
+}
+
+func (rc *renderCache) invalidate() {
+	rc.mu.Lock()
+	rc.loadedAt = time.Time{}
+	rc.mu.Unlock()
+}
+
+func (app *App) renderSets() (*htmltemplate.Template, *texttemplate.Template, map[string]string, error) {
+	rc := app.renders
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if time.Since(rc.loadedAt) <= renderTemplatesTTL {
+		return rc.html, rc.text, rc.types, nil
+	}
+
+	templates, err := app.listRenderTemplates()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	html, text := htmltemplate.New(""), texttemplate.New("")
+	types := map[string]string{}
+	for _, t := range templates {
+		if t.Type == RenderText {
+			_, err = text.New(t.Name).Parse(t.Content)
+		} else {
+			_, err = html.New(t.Name).Parse(t.Content)
+		}
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("template %s: %v", t.Name, err)
+		}
+		types[t.Name] = t.Type
+	}
+	rc.html, rc.text, rc.types, rc.loadedAt = html, text, types, time.Now()
+	return html, text, types, nil
+}
+
+// render fills in the named template with data.
+func (app *App) render(name string, data interface{}) (string, error) {
+	html, text, types, err := app.renderSets()
+	if err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	switch types[name] {
+	case RenderHTML:
+		err = html.ExecuteTemplate(&out, name, data)
+	case RenderText:
+		err = text.ExecuteTemplate(&out, name, data)
+	default:
+		return "", fmt.Errorf("no template named %s", name)
+	}
+	if err != nil {
+		return "", err
+	}
+	if out.Len() > maxRenderOutputBytes {
+		return "", fmt.Errorf("output is larger than 5 MiB")
+	}
+	return out.String(), nil
+}
+
+// jsRender backs runbox.render(name, data).
+func (app *App) jsRender(call otto.FunctionCall) otto.Value {
+	name := call.Argument(0).String()
+	var data interface{}
+	if arg := call.Argument(1); arg.IsDefined() {
+		data, _ = arg.Export()
+	}
+	out, err := app.render(name, data)
+	if err != nil {
+		throwError(call, "runbox.render: "+err.Error())
+	}
+	return toValue(call, out)
+}
+
+func (app *App) renderTemplatesChanged() {
+	app.renders.invalidate()
+	app.broadcastChange(changeSettings, 0)
+}
+
+func (app *App) listRenderTemplatesHandler(c *gin.Context) {
+	templates, err := app.listRenderTemplates()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list render templates"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"templates": templates})
+}
+
+func (app *App) getRenderTemplateHandler(c *gin.Context) {
+	t, err := app.getRenderTemplate(c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Render template not found"})
+		return
+	}
+	c.JSON(http.StatusOK, t)
+}
+
+// putRenderTemplate serves PUT /api/render-templates/:name, creating the
+// template or replacing its content.
+func (app *App) putRenderTemplate(c *gin.Context) {
+	var t RenderTemplate
+	if err := c.ShouldBindJSON(&t); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid render template body"})
+		return
+	}
+	t.Name = c.Param("name")
+	if err := t.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now().UTC()
+	status := http.StatusOK
+	if _, err := app.getRenderTemplate(t.Name); err == sql.ErrNoRows {
+		status = http.StatusCreated
+	}
+	_, err := app.db.Exec(`INSERT INTO render_templates (`+renderTemplateColumns+`) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET type = excluded.type, description = excluded.description,
+			content = excluded.content, updated_at = excluded.updated_at`,
+		t.Name, t.Type, t.Description, t.Content, now, now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save render template: " + err.Error()})
+		return
+	}
+	app.renderTemplatesChanged()
+
+	saved, _ := app.getRenderTemplate(t.Name)
+	c.JSON(status, saved)
+}
+
+func (app *App) deleteRenderTemplate(c *gin.Context) {
+	res, err := app.db.Exec(`DELETE FROM render_templates WHERE name = ?`, c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete render template"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Render template not found"})
+		return
+	}
+	app.renderTemplatesChanged()
+	c.JSON(http.StatusOK, gin.H{"deleted": true})
+}
+
+// previewRenderTemplate serves POST /api/render/:name
+// with {data}, the output runbox.render would give.
+func (app *App) previewRenderTemplate(c *gin.Context) {
+	var in struct {
+		Data interface{} `json:"data"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data"})
+			return
+		}
+	}
+	out, err := app.render(c.Param("name"), in.Data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"output": out})
+}
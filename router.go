@@ -0,0 +1,110 @@
+package runbox
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robertkrimen/otto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// invokeRouter dispatches to the global "app" router when the function has
+// registered any routes on it. The second return value reports whether the
+// router handled the request.
+func invokeRouter(vm *otto.Otto, exec *execution) (interface{}, bool, error) {
+	handled, err := vm.Run(`typeof app === 'object' && app instanceof Router && app.routes.length > 0`)
+	if err != nil || !handled.IsBoolean() {
+		return nil, false, nil
+	}
+	if ok, _ := handled.ToBoolean(); !ok {
+		return nil, false, nil
+	}
+
+	router, _ := vm.Get("app")
+	handle, _ := router.Object().Get("handle")
+	result, err := handle.Call(router, exec.request)
+	if err != nil {
+		return nil, true, fmt.Errorf("error calling router: %w", err)
+	}
+	if !result.IsDefined() || result.IsNull() {
+		return nil, true, nil
+	}
+
+	goValue, err := exec.export(result)
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to export result: %v", err)
+	}
+
+	if obj, ok := goValue.(map[string]interface{}); ok && obj["__runboxResponse"] == true {
+		status, err := strconv.Atoi(fmt.Sprint(obj["status"]))
+		if err != nil {
+			return nil, true, fmt.Errorf("invalid response status %v", obj["status"])
+		}
+		resp := &HTTPResponse{Status: status, Headers: stringMap(obj["headers"])}
+		if body, ok := obj["body"]; ok && body != nil {
+			resp.Body = fmt.Sprint(body)
+		}
+		if encoded, _ := obj["base64"].(bool); encoded {
+			decoded, err := base64.StdEncoding.DecodeString(resp.Body)
+			if err != nil {
+				return nil, true, fmt.Errorf("invalid base64 body: %v", err)
+			}
+			resp.Body = string(decoded)
+		}
+		return resp, true, nil
+	}
+
+	return goValue, true, nil
+}
+
+// resolveFunction finds the function serving a path: an exact match first,
+// then the deepest wildcard mount ("/api/*") that contains the path. It also
+// returns the sub-path below the mount point.
+func (app *App) resolveFunction(ctx context.Context, path string) (*Function, string, error) {
+	function, subpath, _, err := app.resolveFunctionTimed(ctx, path)
+	return function, subpath, err
+}
+
+// resolveFunctionTimed is resolveFunction that also returns the time spent
+// in database lookups.
+func (app *App) resolveFunctionTimed(ctx context.Context, path string) (*Function, string, time.Duration, error) {
+	var db time.Duration
+	ctx, span := app.tracer.Start(ctx, "runbox.route", trace.WithAttributes(attribute.String("runbox.path", path)))
+	defer span.End()
+
+	lookup := func(candidate string) (*Function, error) {
+		_, span := app.tracer.Start(ctx, "runbox.db.lookup", trace.WithAttributes(attribute.String("runbox.path", candidate)))
+		defer span.End()
+		started := time.Now()
+		defer func() { db += time.Since(started) }()
+		return app.getFunctionByPath(candidate)
+	}
+
+	if function, err := lookup(path); err == nil {
+		span.SetAttributes(functionAttributes(function)...)
+		return function, "/", db, nil
+	}
+
+	prefix := strings.TrimSuffix(path, "/")
+	for {
+		if function, err := lookup(prefix + "/*"); err == nil {
+			span.SetAttributes(functionAttributes(function)...)
+			subpath := strings.TrimPrefix(path, prefix)
+			if subpath == "" {
+				subpath = "/"
+			}
+			return function, subpath, db, nil
+		}
+		if prefix == "" {
+			break
+		}
+		prefix = prefix[:strings.LastIndex(prefix, "/")]
+	}
+
+	return nil, "", db, fmt.Errorf("no function for path %s", path)
+}
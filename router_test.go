@@ -0,0 +1,34 @@
+package runbox
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRouterParams(t *testing.T) {
+	s := newTestServer(t, nil)
+	newTestFunction(t, s.app, "/files/*", `
+app.get("/raw/:name", function (req) { return JSON.stringify(req.params); });
+app.get("/*/meta/*", function (req) { return JSON.stringify(req.params); });
+app.get("/*/:id", function (req) { return JSON.stringify(req.params); });
+`)
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/files/docs/2024/42", `{"0":"docs/2024","id":"42"}`},
+		{"/files/a/meta/b/c", `{"0":"a","1":"b/c"}`},
+		{"/files/raw/caf%C3%A9", `{"name":"café"}`},
+		{"/files/raw/100%", `{"name":"100%"}`},
+		{"/files/raw/%E0%A4%A", `{"name":"%E0%A4%A"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			result, err := s.Execute(context.Background(), tt.path, nil)
+			if err != nil || result != tt.want {
+				t.Errorf("Execute(%s) = %v, %v, want %s", tt.path, result, err, tt.want)
+			}
+		})
+	}
+}
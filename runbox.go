@@ -0,0 +1,272 @@
+// Package runbox runs JavaScript functions behind HTTP routes, schedules,
+// events and triggers, with the editor UI and the management API. The
+// runbox-server command serves it on its own; other Go programs embed it
+// by mounting the handler New returns:
+//
+//	config, err := runbox.LoadConfig("runbox.json")
+//	...
+//	srv, err := runbox.New(config)
+//	...
+//	defer srv.Close()
+//	mux.Handle("/", srv)
+//
+// The store package keeps functions in the database, and the engine
+// package holds the VM core, the function modes and module resolution.
+// Server is an engine.Executor, which runs functions from Go.
+package runbox
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/graph-gophers/graphql-go"
+	"github.com/prodemmi/runbox/engine"
+)
+
+// Server is a running RunBox instance: its database, its background work
+// (job workers, the scheduler, triggers and log shipping) and the handler
+// for the UI, the API and the execute routes.
+type Server struct {
+	app     *App
+	handler http.Handler
+	closers []func()
+}
+
+// New opens the database in config, starts the background work and
+// answers the server. RunBox expects the root of the URL space it is
+// mounted on, since its pages link to absolute paths.
+func New(config Config) (*Server, error) {
+	if config.DevDir != "" {
+		config.Database = devDatabase
+	}
+	s := &Server{}
+
+	tracer, shutdownTracing, err := initTracing(config.Tracing)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up tracing: %v", err)
+	}
+	s.closers = append(s.closers, shutdownTracing)
+
+	hub, err := initSentry(config.Sentry)
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("failed to set up Sentry: %v", err)
+	}
+	if hub != nil {
+		s.closers = append(s.closers, func() { hub.Flush(sentryFlushTimeout) })
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	app := &App{
+		ctx:         ctx,
+		stop:        stop,
+		config:      config,
+		webhooks:    newWebhookDispatcher(),
+		leader:      newLeaderElector(config.Cluster),
+		scripts:     newScriptCache(),
+		metrics:     newMetricsRegistry(),
+		logStream:   newLogBroker(),
+		slos:        newSLOTargets(),
+		maintenance: &maintenanceCache{},
+		rateLimits:  newRateLimiter(),
+		captures:    newCaptureCache(),
+		quotas:      newQuotaCache(),
+		vmStats:     newRuntimeStats(),
+		health:      newHealthTracker(),
+		storage:     &objectStorage{config: config.Storage},
+		renders:     &renderCache{},
+		protos:      &protoCache{},
+		gqlSchemas:  &gqlSchemaCache{},
+		tracer:      tracer,
+		sentry:      hub,
+		periodic:    periodicTasks{},
+	}
+	s.app = app
+	settings, err := config.liveSettings()
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("invalid config: %v", err)
+	}
+	app.settings.Store(settings)
+	if config.AccessLog.Path != "" {
+		if app.accessLog, err = newAccessLogWriter(config.AccessLog); err != nil {
+			s.Close()
+			return nil, fmt.Errorf("failed to open access log: %v", err)
+		}
+		s.closers = append(s.closers, app.accessLog.close)
+	}
+	if app.secrets, err = newSecretBox(config.Secrets); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("invalid secrets key: %v", err)
+	}
+	if app.vault, err = openVault(config.Secrets.Vault); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if app.vault != nil {
+		s.closers = append(s.closers, app.vault.close)
+	}
+	if app.externalDBs, err = openExternalDBs(config.ExternalDBs); err != nil {
+		s.Close()
+		return nil, err
+	}
+	s.closers = append(s.closers, func() { closeExternalDBs(app.externalDBs) })
+	if app.grpcTargets, err = openGRPCTargets(config.GRPC); err != nil {
+		s.Close()
+		return nil, err
+	}
+	s.closers = append(s.closers, func() { closeGRPCTargets(app.grpcTargets) })
+	if app.geoip, err = openGeoIP(config.GeoIP); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if app.geoip != nil {
+		s.closers = append(s.closers, app.geoip.close)
+	}
+	if err := app.initAuth(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if err := app.initDB(); err != nil {
+		if app.db != nil {
+			app.db.Close()
+		}
+		s.Close()
+		return nil, err
+	}
+	s.closers = append(s.closers, func() { app.db.Close() })
+	if err := app.loadShareKey(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if err := app.bootstrapAuth(); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	if app.graphqlSchema, err = graphql.ParseSchema(adminSchema, &graphqlResolver{app: app}); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("invalid GraphQL schema: %v", err)
+	}
+
+	if config.SeedDir != "" {
+		if err := app.seedFunctions(config.SeedDir); err != nil {
+			s.Close()
+			return nil, fmt.Errorf("failed to seed functions from %s: %v", config.SeedDir, err)
+		}
+	}
+
+	router, err := app.router()
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	s.handler = router
+
+	if err := app.startLogExporters(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if err := app.startStatsD(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	app.startExecutionLogWriter()
+	app.startMetricsFlush()
+	app.triggers = newTriggerManager(app)
+	app.startLeaderElection()
+	app.startChangeSync()
+
+	app.jobs = newJobQueue()
+	app.startJobWorkers()
+	app.startScheduler()
+
+	app.startTriggers()
+	app.startKeepWarm()
+
+	if config.DevDir != "" {
+		if err := app.startDev(config.DevDir); err != nil {
+			s.Close()
+			return nil, fmt.Errorf("failed to watch %s: %v", config.DevDir, err)
+		}
+	}
+	return s, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// Reload applies the live settings of the config file again, as SIGHUP
+// does for runbox-server, and answers the sections that changed.
+func (s *Server) Reload() ([]string, error) {
+	return s.app.reloadConfig()
+}
+
+// JobSourceEmbed is the source of executions a Go program started with
+// Server.Execute.
+const JobSourceEmbed = "embed"
+
+var _ engine.Executor = (*Server)(nil)
+
+// Execute runs the function serving path, as /api/execute would, with the
+// fields of request on top of an empty GET request. The caller is trusted:
+// visibility, quotas and rate limits don't apply, and the execution runs
+// until ctx is done.
+func (s *Server) Execute(ctx context.Context, path string, request map[string]interface{}) (interface{}, error) {
+	function, subpath, err := s.app.resolveFunction(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("no function serves %s", path)
+	}
+	requestData := map[string]interface{}{
+		"method":  http.MethodGet,
+		"path":    path,
+		"subpath": subpath,
+		"query":   map[string]interface{}{},
+		"body":    map[string]interface{}{},
+		"headers": map[string]string{},
+		"rawBody": "",
+	}
+	for key, value := range request {
+		requestData[key] = value
+	}
+	exec := newExecution(ctx, function, requestData)
+	exec.source = JobSourceEmbed
+	return s.app.executeJavaScript(exec)
+}
+
+// ListenAndServe serves on the config's addr, a Unix socket or the socket
+// systemd passed, reloads the config on SIGHUP and returns once SIGTERM or
+// SIGINT has let requests in flight finish.
+func (s *Server) ListenAndServe() error {
+	listener, where, err := listen(s.app.config)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %v", err)
+	}
+	// Without auth, runbox dev runs any code it is sent, so it must not be
+	// reachable from elsewhere, e.g. through a socket systemd passed.
+	if !s.app.authRequired() && !loopbackListener(listener) {
+		listener.Close()
+		return fmt.Errorf("runbox dev serves without a token, so it only listens on a loopback address, not %s", where)
+	}
+	defer s.app.startConfigReload()()
+	log.Println("RunBox server starting on " + where)
+	return serve(listener, s, s.app.config)
+}
+
+// Close stops the background work, waiting for job attempts and workflow
+// runs in flight to be interrupted and recorded for a retry, then flushes
+// traces and error reports and closes the database. Stop serving requests
+// first.
+func (s *Server) Close() error {
+	if s.app != nil {
+		s.app.stopBackground()
+	}
+	for i := len(s.closers) - 1; i >= 0; i-- {
+		s.closers[i]()
+	}
+	s.closers = nil
+	return nil
+}
@@ -0,0 +1,59 @@
+package runbox
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prodemmi/runbox/engine"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// newTestServer starts a server on a database of its own, with the
+// changes set makes to the default config, and closes it after the test.
+func newTestServer(t *testing.T, set func(*Config)) *Server {
+	t.Helper()
+	config := DefaultConfig()
+	config.Database = filepath.Join(t.TempDir(), "runbox.db")
+	config.LogLevel = LogError
+	if set != nil {
+		set(&config)
+	}
+	s, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// newTestFunction saves a function with the given code as version 1.
+func newTestFunction(t *testing.T, app *App, path, code string) *Function {
+	t.Helper()
+	function := &Function{Name: path, Path: path, Code: code}
+	if err := app.insertFunction(function); err != nil {
+		t.Fatal(err)
+	}
+	return function
+}
+
+func TestServerExecute(t *testing.T) {
+	s := newTestServer(t, nil)
+	newTestFunction(t, s.app, "/orders/*", `function POST(request) { return request.subpath + ":" + request.body.item; }`)
+
+	var executor engine.Executor = s
+	result, err := executor.Execute(context.Background(), "/orders/42", map[string]interface{}{
+		"method": "POST",
+		"body":   map[string]interface{}{"item": "book"},
+	})
+	if err != nil || result != "/42:book" {
+		t.Errorf("Execute = %v, %v, want /42:book", result, err)
+	}
+	if _, err := executor.Execute(context.Background(), "/missing", nil); err == nil {
+		t.Error("Execute of a path no function serves succeeded")
+	}
+}
@@ -0,0 +1,150 @@
+package runbox
+
+import (
+	"net/http"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// runtimeStats counts what this instance is doing right now and has done
+// since it started: executions in flight, and how long executions took
+// when the function's code had to be compiled (cold) or came from the
+// script cache (warm).
+type runtimeStats struct {
+	startedAt   time.Time
+	busyWorkers atomic.Int64
+
+	mu         sync.Mutex
+	active     int
+	peakActive int
+	functions  map[int]*functionRuntime
+}
+
+type functionRuntime struct {
+	active  int
+	cold    int64
+	coldMs  int64
+	warm    int64
+	warmMs  int64
+	lastRun time.Time
+}
+
+func newRuntimeStats() *runtimeStats {
+	return &runtimeStats{startedAt: time.Now(), functions: map[int]*functionRuntime{}}
+}
+
+func (rs *runtimeStats) function(id int) *functionRuntime {
+	f, ok := rs.functions[id]
+	if !ok {
+		f = &functionRuntime{}
+		rs.functions[id] = f
+	}
+	return f
+}
+
+func (rs *runtimeStats) start(functionID int) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	rs.active++
+	rs.peakActive = max(rs.peakActive, rs.active)
+	rs.function(functionID).active++
+}
+
+// finish ends an execution started with start. Executions that failed
+// before their code was compiled count as neither cold nor warm.
+func (rs *runtimeStats) finish(functionID int, elapsed time.Duration, compiled, cold bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	rs.active--
+	f := rs.function(functionID)
+	f.active--
+	f.lastRun = time.Now().UTC()
+	if !compiled {
+		return
+	}
+	if cold {
+		f.cold++
+		f.coldMs += elapsed.Milliseconds()
+	} else {
+		f.warm++
+		f.warmMs += elapsed.Milliseconds()
+	}
+}
+
+func average(totalMs, n int64) float64 {
+	if n == 0 {
+		return 0
+	}
+	return float64(totalMs) / float64(n)
+}
+
+// runtimeStatsHandler serves GET /api/runtime/stats for this instance.
+func (app *App) runtimeStatsHandler(c *gin.Context) {
+	functions, err := app.getAllFunctions()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list functions"})
+		return
+	}
+	names := map[int]*Function{}
+	for i := range functions {
+		names[functions[i].ID] = &functions[i]
+	}
+
+	rs := app.vmStats
+	rs.mu.Lock()
+	active, peak := rs.active, rs.peakActive
+	perFunction := []gin.H{}
+	for id, f := range rs.functions {
+		entry := gin.H{
+			"functionId": id,
+			"active":     f.active,
+			"cold":       gin.H{"count": f.cold, "avgMs": average(f.coldMs, f.cold)},
+			"warm":       gin.H{"count": f.warm, "avgMs": average(f.warmMs, f.warm)},
+			"lastRun":    f.lastRun,
+		}
+		if function, ok := names[id]; ok {
+			entry["name"] = function.Name
+			entry["path"] = function.Path
+		}
+		perFunction = append(perFunction, entry)
+	}
+	rs.mu.Unlock()
+	sort.Slice(perFunction, func(i, j int) bool {
+		return perFunction[i]["functionId"].(int) < perFunction[j]["functionId"].(int)
+	})
+
+	busy := rs.busyWorkers.Load()
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	c.JSON(http.StatusOK, gin.H{
+		"instance":   app.leader.instanceID,
+		"uptime":     time.Since(rs.startedAt).Round(time.Second).String(),
+		"goroutines": runtime.NumGoroutine(),
+		"memory": gin.H{
+			"heapAllocBytes": mem.HeapAlloc,
+			"heapSysBytes":   mem.HeapSys,
+			"numGC":          mem.NumGC,
+		},
+		"jobWorkers": gin.H{
+			"size":          jobWorkers,
+			"busy":          busy,
+			"utilization":   float64(busy) / float64(jobWorkers),
+			"queued":        len(app.jobs.pending),
+			"queueCapacity": cap(app.jobs.pending),
+		},
+		"scriptCache": app.scripts.stats(),
+		"executions": gin.H{
+			"active":     active,
+			"peakActive": peak,
+		},
+		"functions": perFunction,
+	})
+}
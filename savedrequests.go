@@ -0,0 +1,242 @@
+package runbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxSavedRequestName keeps saved request names short enough for the test
+// panel's list.
+const maxSavedRequestName = 100
+
+// SavedRequest is a named test request kept with a function, to replay
+// against the dry-run endpoint or the live route.
+type SavedRequest struct {
+	ID         int               `json:"id"`
+	FunctionID int               `json:"functionId"`
+	Name       string            `json:"name"`
+	Method     string            `json:"method"`
+	Subpath    string            `json:"subpath"`
+	Query      string            `json:"query"`
+	Headers    map[string]string `json:"headers"`
+	Body       string            `json:"body"`
+	CreatedAt  time.Time         `json:"createdAt"`
+	UpdatedAt  time.Time         `json:"updatedAt"`
+}
+
+func (app *App) initSavedRequestsTable() error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS saved_requests (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		function_id INTEGER NOT NULL REFERENCES functions(id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		method TEXT NOT NULL,
+		subpath TEXT NOT NULL DEFAULT '',
+		query TEXT NOT NULL DEFAULT '',
+		headers TEXT NOT NULL DEFAULT '{}',
+		body TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (function_id, name)
+	);`
+
+	if _, err := app.db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create saved requests table: %v", err)
+	}
+	return nil
+}
+
+const savedRequestColumns = `id, function_id, name, method, subpath, query, headers, body, created_at, updated_at`
+
+func scanSavedRequest(row rowScanner) (*SavedRequest, error) {
+	var (
+		r       SavedRequest
+		headers string
+	)
+	err := row.Scan(&r.ID, &r.FunctionID, &r.Name, &r.Method, &r.Subpath, &r.Query, &headers, &r.Body, &r.CreatedAt, &r.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	json.Unmarshal([]byte(headers), &r.Headers)
+	if r.Headers == nil {
+		r.Headers = map[string]string{}
+	}
+	return &r, nil
+}
+
+func (app *App) getSavedRequest(id int) (*SavedRequest, error) {
+	return scanSavedRequest(app.db.QueryRow(`SELECT `+savedRequestColumns+` FROM saved_requests WHERE id = ?`, id))
+}
+
+func (app *App) getFunctionSavedRequests(functionID int) ([]SavedRequest, error) {
+	rows, err := app.db.Query(`SELECT `+savedRequestColumns+` FROM saved_requests WHERE function_id = ? ORDER BY name`, functionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := []SavedRequest{}
+	for rows.Next() {
+		r, err := scanSavedRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, *r)
+	}
+	return requests, nil
+}
+
+type savedRequestInput struct {
+	Name    string            `json:"name"`
+	Method  string            `json:"method"`
+	Subpath string            `json:"subpath"`
+	Query   string            `json:"query"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+}
+
+func (in *savedRequestInput) validate() string {
+	in.Name = strings.TrimSpace(in.Name)
+	in.Method = strings.ToUpper(strings.TrimSpace(in.Method))
+	if in.Method == "" {
+		in.Method = http.MethodGet
+	}
+	if in.Headers == nil {
+		in.Headers = map[string]string{}
+	}
+	switch {
+	case in.Name == "":
+		return "name is required"
+	case len(in.Name) > maxSavedRequestName:
+		return "name must be at most " + strconv.Itoa(maxSavedRequestName) + " characters"
+	case !validHTTPMethod(in.Method):
+		return "Invalid method " + in.Method
+	}
+	return ""
+}
+
+// validHTTPMethod accepts the methods the test panel can send.
+func validHTTPMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+func (app *App) listSavedRequests(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+
+	requests, err := app.getFunctionSavedRequests(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list saved requests"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"requests": requests})
+}
+
+// createSavedRequest saves a request under a function; saving again under
+// a name that is taken replaces that request.
+func (app *App) createSavedRequest(c *gin.Context) {
+	functionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+	if _, err := app.getFunctionByID(functionID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Function not found"})
+		return
+	}
+
+	var in savedRequestInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid saved request body"})
+		return
+	}
+	if msg := in.validate(); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	saved, err := app.saveRequest(functionID, &in)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save request: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, saved)
+}
+
+// saveRequest stores a validated request under functionID, replacing any
+// saved under the same name.
+func (app *App) saveRequest(functionID int, in *savedRequestInput) (*SavedRequest, error) {
+	headers, _ := json.Marshal(in.Headers)
+	query := `INSERT INTO saved_requests (function_id, name, method, subpath, query, headers, body) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (function_id, name) DO UPDATE SET method = excluded.method, subpath = excluded.subpath,
+			query = excluded.query, headers = excluded.headers, body = excluded.body, updated_at = CURRENT_TIMESTAMP`
+	if _, err := app.db.Exec(query, functionID, in.Name, in.Method, in.Subpath, in.Query, string(headers), in.Body); err != nil {
+		return nil, err
+	}
+	return scanSavedRequest(app.db.QueryRow(`SELECT `+savedRequestColumns+` FROM saved_requests WHERE function_id = ? AND name = ?`, functionID, in.Name))
+}
+
+func (app *App) updateSavedRequest(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid saved request ID"})
+		return
+	}
+	if _, err := app.getSavedRequest(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Saved request not found"})
+		return
+	}
+
+	var in savedRequestInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid saved request body"})
+		return
+	}
+	if msg := in.validate(); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	headers, _ := json.Marshal(in.Headers)
+	query := `UPDATE saved_requests SET name = ?, method = ?, subpath = ?, query = ?, headers = ?, body = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
+	if _, err := app.db.Exec(query, in.Name, in.Method, in.Subpath, in.Query, string(headers), in.Body, id); err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			c.JSON(http.StatusConflict, gin.H{"error": "A saved request named " + in.Name + " already exists"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update saved request: " + err.Error()})
+		return
+	}
+
+	saved, _ := app.getSavedRequest(id)
+	c.JSON(http.StatusOK, saved)
+}
+
+func (app *App) deleteSavedRequest(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid saved request ID"})
+		return
+	}
+
+	if _, err := app.db.Exec(`DELETE FROM saved_requests WHERE id = ?`, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete saved request"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Saved request deleted successfully"})
+}
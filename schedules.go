@@ -0,0 +1,351 @@
+package runbox
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/robfig/cron/v3"
+)
+
+const schedulerInterval = time.Second
+
+// cronParser accepts standard five-field expressions, an optional leading
+// seconds field, and descriptors such as "@hourly" or "@every 5m".
+var cronParser = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+type Schedule struct {
+	ID         int             `json:"id"`
+	FunctionID int             `json:"functionId"`
+	Cron       string          `json:"cron"`
+	Payload    json.RawMessage `json:"payload,omitempty"`
+	Enabled    bool            `json:"enabled"`
+	LastRunAt  *time.Time      `json:"lastRunAt,omitempty"`
+	NextRunAt  *time.Time      `json:"nextRunAt,omitempty"`
+	CreatedAt  time.Time       `json:"createdAt"`
+}
+
+func (app *App) initSchedulesTable() error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS schedules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		function_id INTEGER NOT NULL REFERENCES functions(id) ON DELETE CASCADE,
+		cron TEXT NOT NULL,
+		payload TEXT,
+		enabled INTEGER NOT NULL DEFAULT 1,
+		last_run_at DATETIME,
+		next_run_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_schedules_next_run ON schedules (enabled, next_run_at);`
+
+	if _, err := app.db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create schedules table: %v", err)
+	}
+	return nil
+}
+
+const scheduleColumns = `id, function_id, cron, payload, enabled, last_run_at, next_run_at, created_at`
+
+func scanSchedule(row rowScanner) (*Schedule, error) {
+	var (
+		s                    Schedule
+		payload              sql.NullString
+		lastRunAt, nextRunAt sql.NullTime
+	)
+	err := row.Scan(&s.ID, &s.FunctionID, &s.Cron, &payload, &s.Enabled, &lastRunAt, &nextRunAt, &s.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if payload.Valid && payload.String != "" {
+		s.Payload = json.RawMessage(payload.String)
+	}
+	if lastRunAt.Valid {
+		s.LastRunAt = &lastRunAt.Time
+	}
+	if nextRunAt.Valid {
+		s.NextRunAt = &nextRunAt.Time
+	}
+
+	return &s, nil
+}
+
+func (app *App) getSchedule(id int) (*Schedule, error) {
+	return scanSchedule(app.db.QueryRow(`SELECT `+scheduleColumns+` FROM schedules WHERE id = ?`, id))
+}
+
+func (app *App) getFunctionSchedules(functionID int) ([]Schedule, error) {
+	rows, err := app.db.Query(`SELECT `+scheduleColumns+` FROM schedules WHERE function_id = ? ORDER BY id`, functionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []Schedule{}
+	for rows.Next() {
+		s, err := scanSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, *s)
+	}
+
+	return schedules, nil
+}
+
+// periodicTasks remembers when each of the scheduler's periodic tasks last
+// ran. Only the scheduler goroutine uses it.
+type periodicTasks map[string]time.Time
+
+// due reports whether task last ran at least every before now, and notes
+// that it runs now when it does.
+func (p periodicTasks) due(task string, now time.Time, every time.Duration) bool {
+	if now.Sub(p[task]) < every {
+		return false
+	}
+	p[task] = now
+	return true
+}
+
+func (app *App) startScheduler() {
+	app.every(schedulerInterval, func(now time.Time) {
+		if !app.isLeader() {
+			return
+		}
+		app.runDueSchedules(now.UTC())
+		app.dispatchDueJobs(now.UTC())
+		app.runDueMaterializations(now.UTC())
+		app.pruneEventLog(now.UTC())
+		app.pruneExecutionLogs(now.UTC())
+		app.pruneCaptures(now.UTC())
+		app.evaluateAlertRules(now.UTC())
+		app.evaluateLatencySLOs(now.UTC())
+		app.evaluateTrafficAlerts(now.UTC())
+		app.evaluateCanaries(now.UTC())
+		if app.leader.clustered {
+			app.recoverOrphanedWork(now.UTC())
+			app.pruneClusterChanges(now.UTC())
+		}
+	})
+}
+
+func (app *App) runDueSchedules(now time.Time) {
+	rows, err := app.db.Query(`SELECT `+scheduleColumns+` FROM schedules WHERE enabled = 1 AND next_run_at <= ?`, now)
+	if err != nil {
+		log.Println("Failed to load due schedules:", err)
+		return
+	}
+	var due []Schedule
+	for rows.Next() {
+		if s, err := scanSchedule(rows); err == nil {
+			due = append(due, *s)
+		}
+	}
+	rows.Close()
+
+	for _, s := range due {
+		spec, err := cronParser.Parse(s.Cron)
+		if err != nil {
+			log.Printf("Schedule %d has an invalid cron expression %q: %v", s.ID, s.Cron, err)
+			continue
+		}
+
+		// Advancing next_run_at only if it still holds the value we read
+		// guarantees each tick fires once, even if a previous tick overran.
+		result, err := app.db.Exec(`UPDATE schedules SET last_run_at = ?, next_run_at = ? WHERE id = ? AND next_run_at = ?`,
+			now, spec.Next(now), s.ID, *s.NextRunAt)
+		if err != nil {
+			log.Printf("Failed to advance schedule %d: %v", s.ID, err)
+			continue
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			continue
+		}
+
+		function, err := app.getFunctionByID(s.FunctionID)
+		if err != nil {
+			continue
+		}
+
+		requestData := scheduleRequest(function, &s, *s.NextRunAt, now)
+		if _, err := app.enqueueJob(function, requestData, jobOptions{Source: JobSourceSchedule, ScheduleID: s.ID}); err != nil {
+			log.Printf("Failed to enqueue scheduled run of %s: %v", function.Path, err)
+		}
+	}
+}
+
+// scheduleRequest builds the synthetic request passed to a scheduled
+// handler. It is dispatched to a SCHEDULE function, falling back to default.
+func scheduleRequest(function *Function, s *Schedule, scheduledAt, firedAt time.Time) map[string]interface{} {
+	requestData := syntheticRequest(function, "SCHEDULE", s.Payload)
+	requestData["schedule"] = map[string]interface{}{
+		"id":          s.ID,
+		"cron":        s.Cron,
+		"scheduledAt": scheduledAt.Format(time.RFC3339),
+		"firedAt":     firedAt.Format(time.RFC3339),
+	}
+	return requestData
+}
+
+// syntheticRequest builds a request object for invocations that do not come
+// from HTTP, using payload as the body.
+func syntheticRequest(function *Function, method string, payload json.RawMessage) map[string]interface{} {
+	var body interface{} = map[string]interface{}{}
+	if len(payload) > 0 {
+		json.Unmarshal(payload, &body)
+	}
+
+	return map[string]interface{}{
+		"id":      newID(),
+		"method":  method,
+		"path":    function.Path,
+		"url":     "",
+		"query":   map[string]interface{}{},
+		"body":    body,
+		"headers": map[string]string{},
+		"rawBody": string(payload),
+		"subpath": "/",
+	}
+}
+
+type scheduleInput struct {
+	Cron    string          `json:"cron"`
+	Payload json.RawMessage `json:"payload"`
+	Enabled *bool           `json:"enabled"`
+}
+
+func (in *scheduleInput) validate() (cron.Schedule, string) {
+	if in.Cron == "" {
+		return nil, "cron is required"
+	}
+	spec, err := cronParser.Parse(in.Cron)
+	if err != nil {
+		return nil, "Invalid cron expression: " + err.Error()
+	}
+	if len(in.Payload) > 0 && !json.Valid(in.Payload) {
+		return nil, "payload must be valid JSON"
+	}
+	return spec, ""
+}
+
+func (app *App) listFunctionSchedules(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+
+	schedules, err := app.getFunctionSchedules(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list schedules"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"schedules": schedules})
+}
+
+func (app *App) createSchedule(c *gin.Context) {
+	functionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+	if _, err := app.getFunctionByID(functionID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Function not found"})
+		return
+	}
+
+	var in scheduleInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid schedule body"})
+		return
+	}
+	spec, msg := in.validate()
+	if msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	enabled := in.Enabled == nil || *in.Enabled
+	query := `INSERT INTO schedules (function_id, cron, payload, enabled, next_run_at) VALUES (?, ?, ?, ?, ?)`
+	result, err := app.db.Exec(query, functionID, in.Cron, string(in.Payload), enabled, spec.Next(time.Now().UTC()))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create schedule: " + err.Error()})
+		return
+	}
+
+	id, _ := result.LastInsertId()
+	schedule, _ := app.getSchedule(int(id))
+	c.JSON(http.StatusCreated, schedule)
+}
+
+func (app *App) updateSchedule(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid schedule ID"})
+		return
+	}
+	if _, err := app.getSchedule(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found"})
+		return
+	}
+
+	var in scheduleInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid schedule body"})
+		return
+	}
+	spec, msg := in.validate()
+	if msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	enabled := in.Enabled == nil || *in.Enabled
+	query := `UPDATE schedules SET cron = ?, payload = ?, enabled = ?, next_run_at = ? WHERE id = ?`
+	_, err = app.db.Exec(query, in.Cron, string(in.Payload), enabled, spec.Next(time.Now().UTC()), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update schedule: " + err.Error()})
+		return
+	}
+
+	schedule, _ := app.getSchedule(id)
+	c.JSON(http.StatusOK, schedule)
+}
+
+func (app *App) deleteSchedule(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid schedule ID"})
+		return
+	}
+
+	if _, err := app.db.Exec(`DELETE FROM schedules WHERE id = ?`, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete schedule"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Schedule deleted successfully"})
+}
+
+func (app *App) listScheduleRuns(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid schedule ID"})
+		return
+	}
+
+	jobs, err := app.listJobs(jobFilter{ScheduleID: id, Limit: 50})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list schedule runs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"runs": jobs})
+}
@@ -0,0 +1,25 @@
+#!/bin/sh
+# Downloads the Monaco editor into static/monaco, where the function editor
+# loads it from. Run from the repository root before building, or through
+# go generate: static/ is built into the binary. A copy of the same version
+# is kept.
+set -eu
+
+VERSION="${MONACO_VERSION:-0.52.2}"
+DEST=static/monaco
+
+if [ "$(cat "$DEST/VERSION" 2>/dev/null)" = "$VERSION" ]; then
+	echo "Monaco $VERSION is already in $DEST"
+	exit 0
+fi
+
+tmp=$(mktemp -d)
+trap 'rm -rf "$tmp"' EXIT
+
+curl -fsSL "https://registry.npmjs.org/monaco-editor/-/monaco-editor-$VERSION.tgz" | tar -xz -C "$tmp"
+rm -rf "$DEST"
+mkdir -p "$DEST"
+cp -R "$tmp/package/min/vs" "$DEST/vs"
+cp "$tmp/package/LICENSE" "$DEST/LICENSE"
+echo "$VERSION" > "$DEST/VERSION"
+echo "Monaco $VERSION installed in $DEST"
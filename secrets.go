@@ -0,0 +1,425 @@
+package runbox
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/robertkrimen/otto"
+)
+
+const (
+	maxSecretBytes  = 32 << 10
+	sealedPrefix    = "aesgcm:"
+	plaintextPrefix = "plain:"
+)
+
+// secretReferencePattern finds the secrets a function's code asks for by a
+// literal name, as in runbox.secrets.get("STRIPE_KEY"),
+// runbox.notify.slack("SLACK_ALERTS", ...) or runbox.jwt.sign(claims,
+// "JWT_KEY").
+var secretReferencePattern = regexp.MustCompile(`\b(?:(?:secrets\s*\.\s*get|notify\s*\.\s*(?:slack|discord))\s*\(|jwt\s*\.\s*(?:sign|verify)\s*\([^;]*?,)\s*['"]([A-Za-z_][A-Za-z0-9_]*)['"]\s*[,)]`)
+
+// Secret describes a stored secret. Its value is write-only: it is only
+// ever handed to functions, never returned by the API.
+type Secret struct {
+	Name       string            `json:"name"`
+	Version    int               `json:"version"`
+	CreatedAt  time.Time         `json:"createdAt"`
+	RotatedAt  time.Time         `json:"rotatedAt"`
+	References []secretReference `json:"references"`
+}
+
+// secretReference is a function whose code reads a secret.
+type secretReference struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// secretBox encrypts secret values with AES-GCM, or stores them as they are
+// when no key is configured.
+type secretBox struct {
+	aead cipher.AEAD
+}
+
+func newSecretBox(config SecretsConfig) (*secretBox, error) {
+	key := config.Key
+	if key == "" {
+		key = os.Getenv("RUNBOX_SECRETS_KEY")
+	}
+	if key == "" {
+		return &secretBox{}, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("key is not base64: %v", err)
+	}
+	if len(raw) != 32 {
+		return nil, fmt.Errorf("key is %d bytes, want 32", len(raw))
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &secretBox{aead: aead}, nil
+}
+
+func (b *secretBox) encrypted() bool { return b.aead != nil }
+
+// seal encodes a value for storage; name is bound in as associated data so
+// a sealed value can't be copied to another secret.
+func (b *secretBox) seal(name, value string) (string, error) {
+	if b.aead == nil {
+		return plaintextPrefix + value, nil
+	}
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := b.aead.Seal(nonce, nonce, []byte(value), []byte(name))
+	return sealedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (b *secretBox) open(name, stored string) (string, error) {
+	if value, ok := strings.CutPrefix(stored, plaintextPrefix); ok {
+		return value, nil
+	}
+	encoded, ok := strings.CutPrefix(stored, sealedPrefix)
+	if !ok {
+		return "", errors.New("unknown storage format")
+	}
+	if b.aead == nil {
+		return "", errors.New("the secret is encrypted and no secrets key is configured")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < b.aead.NonceSize() {
+		return "", errors.New("corrupt value")
+	}
+	n := b.aead.NonceSize()
+	value, err := b.aead.Open(nil, sealed[:n], sealed[n:], []byte(name))
+	if err != nil {
+		return "", errors.New("the secret can't be decrypted with the configured key")
+	}
+	return string(value), nil
+}
+
+func (app *App) initSecretsTable() error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS secrets (
+		name TEXT PRIMARY KEY,
+		value TEXT NOT NULL,
+		version INTEGER NOT NULL DEFAULT 1,
+		created_at DATETIME NOT NULL,
+		rotated_at DATETIME NOT NULL
+	);`
+
+	if _, err := app.db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create secrets table: %v", err)
+	}
+	if app.vault != nil {
+		log.Printf("Secrets are kept in Vault under %s/%s", app.vault.config.Mount, app.vault.config.Path)
+	} else if !app.secrets.encrypted() {
+		log.Println("Secrets are stored unencrypted; set secrets.key or RUNBOX_SECRETS_KEY to encrypt them")
+	}
+	return nil
+}
+
+// secretReferences maps each secret name that function code reads to the
+// functions reading it.
+func (app *App) secretReferences() (map[string][]secretReference, error) {
+	functions, err := app.getAllFunctions()
+	if err != nil {
+		return nil, err
+	}
+	refs := map[string][]secretReference{}
+	for _, f := range functions {
+		seen := map[string]bool{}
+		code := f.Code
+		for _, module := range f.Files {
+			code += "\n" + module.Code
+		}
+		for _, m := range secretReferencePattern.FindAllStringSubmatch(code, -1) {
+			if !seen[m[1]] {
+				seen[m[1]] = true
+				refs[m[1]] = append(refs[m[1]], secretReference{ID: f.ID, Name: f.Name, Path: f.Path})
+			}
+		}
+	}
+	return refs, nil
+}
+
+func (app *App) listSecrets() ([]Secret, error) {
+	if app.vault != nil {
+		return app.vault.list()
+	}
+	rows, err := app.db.Query(`SELECT name, version, created_at, rotated_at FROM secrets ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	secrets := []Secret{}
+	for rows.Next() {
+		var s Secret
+		if err := rows.Scan(&s.Name, &s.Version, &s.CreatedAt, &s.RotatedAt); err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, s)
+	}
+	return secrets, rows.Err()
+}
+
+// secretValue returns a secret's plaintext, or false when it isn't set.
+func (app *App) secretValue(name string) (string, bool, error) {
+	if app.vault != nil {
+		return app.vault.value(name)
+	}
+	var stored string
+	err := app.db.QueryRow(`SELECT value FROM secrets WHERE name = ?`, name).Scan(&stored)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	value, err := app.secrets.open(name, stored)
+	if err != nil {
+		return "", true, err
+	}
+	return value, true, nil
+}
+
+// jsSecrets backs runbox.secrets.
+func (app *App) jsSecrets(vm *otto.Otto) *otto.Object {
+	secrets, _ := vm.Object(`({})`)
+	secrets.Set("get", func(call otto.FunctionCall) otto.Value {
+		name := call.Argument(0).String()
+		value, ok, err := app.secretValue(name)
+		if err != nil {
+			throwError(call, "runbox.secrets.get: "+name+": "+err.Error())
+		}
+		if !ok {
+			return otto.NullValue()
+		}
+		return toValue(call, value)
+	})
+	return secrets
+}
+
+func validateSecret(name, value string) error {
+	if !envNamePattern.MatchString(name) || len(name) > maxEnvNameLength {
+		return fmt.Errorf("name must start with a letter or underscore and contain only letters, digits and underscores, up to %d characters", maxEnvNameLength)
+	}
+	if value == "" {
+		return fmt.Errorf("value is required")
+	}
+	if len(value) > maxSecretBytes {
+		return fmt.Errorf("value is larger than 32 KiB")
+	}
+	return nil
+}
+
+// listSecretsHandler serves GET /api/secrets: every secret with the
+// functions that reference it, and the names code reads that aren't set.
+func (app *App) listSecretsHandler(c *gin.Context) {
+	secrets, err := app.listSecrets()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list secrets"})
+		return
+	}
+	refs, err := app.secretReferences()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan functions"})
+		return
+	}
+	for i := range secrets {
+		secrets[i].References = refs[secrets[i].Name]
+		if secrets[i].References == nil {
+			secrets[i].References = []secretReference{}
+		}
+		delete(refs, secrets[i].Name)
+	}
+	c.JSON(http.StatusOK, gin.H{"secrets": secrets, "missing": refs, "encrypted": app.secretsEncrypted(), "backend": app.secretsBackend()})
+}
+
+// createSecret serves POST /api/secrets with {name, value}.
+func (app *App) createSecret(c *gin.Context) {
+	var in struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid secret body"})
+		return
+	}
+	if err := validateSecret(in.Name, in.Value); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if app.vault != nil {
+		s, err := app.vault.write(in.Name, in.Value, true)
+		if err == errVaultExists {
+			c.JSON(http.StatusConflict, gin.H{"error": "Secret " + in.Name + " already exists; rotate it instead"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to save secret to Vault: " + err.Error()})
+			return
+		}
+		s.References = []secretReference{}
+		c.JSON(http.StatusCreated, s)
+		return
+	}
+	if _, ok, _ := app.secretValue(in.Name); ok {
+		c.JSON(http.StatusConflict, gin.H{"error": "Secret " + in.Name + " already exists; rotate it instead"})
+		return
+	}
+
+	stored, err := app.secrets.seal(in.Name, in.Value)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encrypt secret"})
+		return
+	}
+	now := time.Now().UTC()
+	if _, err := app.db.Exec(`INSERT INTO secrets (name, value, version, created_at, rotated_at) VALUES (?, ?, 1, ?, ?)`,
+		in.Name, stored, now, now); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save secret: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, Secret{Name: in.Name, Version: 1, CreatedAt: now, RotatedAt: now, References: []secretReference{}})
+}
+
+// rotateSecret serves PUT /api/secrets/:name with {value}, replacing the
+// value; functions see the new one from their next run.
+func (app *App) rotateSecret(c *gin.Context) {
+	name := c.Param("name")
+	var in struct {
+		Value string `json:"value"`
+	}
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid secret body"})
+		return
+	}
+	if err := validateSecret(name, in.Value); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	s := Secret{References: []secretReference{}}
+	if app.vault != nil {
+		written, err := app.vault.write(name, in.Value, false)
+		if err == errVaultNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Secret not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to save secret to Vault: " + err.Error()})
+			return
+		}
+		s.Name, s.Version, s.CreatedAt, s.RotatedAt = written.Name, written.Version, written.CreatedAt, written.RotatedAt
+	} else {
+		stored, err := app.secrets.seal(name, in.Value)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encrypt secret"})
+			return
+		}
+		result, err := app.db.Exec(`UPDATE secrets SET value = ?, version = version + 1, rotated_at = ? WHERE name = ?`,
+			stored, time.Now().UTC(), name)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save secret: " + err.Error()})
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Secret not found"})
+			return
+		}
+		app.db.QueryRow(`SELECT name, version, created_at, rotated_at FROM secrets WHERE name = ?`, name).
+			Scan(&s.Name, &s.Version, &s.CreatedAt, &s.RotatedAt)
+	}
+	if refs, err := app.secretReferences(); err == nil && refs[name] != nil {
+		s.References = refs[name]
+	}
+	c.JSON(http.StatusOK, s)
+}
+
+// deleteSecret serves DELETE /api/secrets/:name. A secret functions still
+// reference is only deleted with ?force=true; without it the answer is 409
+// with the references.
+func (app *App) deleteSecret(c *gin.Context) {
+	name := c.Param("name")
+	if c.Query("force") != "true" {
+		refs, err := app.secretReferences()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan functions"})
+			return
+		}
+		if len(refs[name]) > 0 {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":      fmt.Sprintf("Secret %s is referenced by %d function(s)", name, len(refs[name])),
+				"references": refs[name],
+			})
+			return
+		}
+	}
+
+	if app.vault != nil {
+		err := app.vault.delete(name)
+		if err == errVaultNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Secret not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to delete secret from Vault: " + err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"deleted": true})
+		return
+	}
+	result, err := app.db.Exec(`DELETE FROM secrets WHERE name = ?`, name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete secret"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Secret not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deleted": true})
+}
+
+// secretsEncrypted reports whether secrets are encrypted at rest, as they
+// are in Vault.
+func (app *App) secretsEncrypted() bool {
+	return app.vault != nil || app.secrets.encrypted()
+}
+
+// secretsBackend names where secrets are kept: "vault" or "local".
+func (app *App) secretsBackend() string {
+	if app.vault != nil {
+		return "vault"
+	}
+	return "local"
+}
+
+func (app *App) secretsPage(c *gin.Context) {
+	data := gin.H{"title": "Secrets", "encrypted": app.secretsEncrypted(), "backend": app.secretsBackend()}
+	if app.vault != nil {
+		data["vaultPath"] = app.vault.config.Mount + "/" + app.vault.config.Path
+	}
+	c.HTML(http.StatusOK, "secrets.html", data)
+}
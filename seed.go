@@ -0,0 +1,49 @@
+package runbox
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strings"
+)
+
+// seedFunctions creates the functions in dir that the database doesn't
+// have, so a Docker image or demo can ship a catalog. dir is read like a
+// runbox dev directory. Functions already at a path are left as they are,
+// edited or not; one that was deleted comes back on the next start.
+func (app *App) seedFunctions(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return errors.New(dir + " is not a directory")
+	}
+	functions, err := readDevDir(dir)
+	if err != nil {
+		return err
+	}
+
+	origin := auditOrigin{actor: "seed"}
+	created := 0
+	for _, f := range functions {
+		if f.Mode == "" {
+			f.Mode = ModeStandard
+		}
+		if !strings.HasPrefix(f.Path, "/") {
+			f.Path = "/" + f.Path
+		}
+		if _, err := app.getFunctionByPath(f.Path); err == nil {
+			continue
+		}
+		if _, err := app.importBundleFunction(origin, f, importCreate); err != nil {
+			log.Printf("Seed function %s not created: %v", f.Path, err)
+			continue
+		}
+		created++
+	}
+	if created > 0 {
+		log.Printf("Seeded %d functions from %s", created, dir)
+	}
+	return nil
+}
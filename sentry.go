@@ -0,0 +1,167 @@
+package runbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/gin-gonic/gin"
+)
+
+const sentryFlushTimeout = 2 * time.Second
+
+// initSentry answers the hub errors are reported to, or nil when no DSN
+// is configured. The hub is RunBox's own rather than Sentry's global one,
+// so a program embedding RunBox keeps its own.
+func initSentry(config SentryConfig) (*sentry.Hub, error) {
+	if config.DSN == "" {
+		return nil, nil
+	}
+
+	sampleRate := config.SampleRate
+	if sampleRate <= 0 || sampleRate > 1 {
+		sampleRate = 1
+	}
+	client, err := sentry.NewClient(sentry.ClientOptions{
+		Dsn:              config.DSN,
+		Environment:      config.Environment,
+		Release:          config.Release,
+		SampleRate:       sampleRate,
+		AttachStacktrace: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sentry.NewHub(client, sentry.NewScope()), nil
+}
+
+type sentryPanicKey struct{}
+
+// sentryMiddleware reports panics raised while serving a request, then
+// lets gin's recovery answer it. Panics inside an execution are reported
+// by the execution itself, with the function attached, and not again here.
+func (app *App) sentryMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if app.sentry == nil {
+			c.Next()
+			return
+		}
+
+		hub := app.sentry.Clone()
+		hub.Scope().SetRequest(c.Request)
+		reported := &atomic.Bool{}
+		ctx := sentry.SetHubOnContext(c.Request.Context(), hub)
+		c.Request = c.Request.WithContext(context.WithValue(ctx, sentryPanicKey{}, reported))
+
+		defer func() {
+			if r := recover(); r != nil {
+				if !reported.Load() {
+					hub.RecoverWithContext(c.Request.Context(), r)
+					hub.Flush(sentryFlushTimeout)
+				}
+				panic(r)
+			}
+		}()
+		c.Next()
+	}
+}
+
+// executionHub is a hub scoped to one execution: the function, its version
+// and the request it ran for, with the headers that capture always redacts
+// and the function's own capture redaction rules applied.
+func (app *App) executionHub(exec *execution) *sentry.Hub {
+	parent := sentry.GetHubFromContext(exec.ctx)
+	if parent == nil {
+		parent = app.sentry
+	}
+	hub := parent.Clone()
+
+	scope := hub.Scope()
+	scope.SetTags(map[string]string{
+		"function":     exec.function.Name,
+		"path":         exec.function.Path,
+		"version":      strconv.Itoa(exec.function.Version),
+		"source":       exec.source,
+		"execution_id": exec.id,
+		"request_id":   exec.requestID,
+	})
+	scope.SetContext("function", sentry.Context{
+		"id":      exec.function.ID,
+		"name":    exec.function.Name,
+		"path":    exec.function.Path,
+		"mode":    exec.function.Mode,
+		"version": exec.function.Version,
+	})
+	if exec.job != nil {
+		scope.SetTag("job_id", exec.job.ID)
+	}
+
+	settings := app.captureSettingsFor(exec.function.ID)
+	if settings == nil {
+		settings = &CaptureSettings{}
+	}
+	r := newRedactor(settings)
+
+	request := &sentry.Request{Headers: map[string]string{}}
+	request.Method, _ = exec.request["method"].(string)
+	if raw, ok := exec.request["url"].(string); ok {
+		if u, err := url.Parse(r.url(raw)); err == nil {
+			request.QueryString = u.RawQuery
+			u.RawQuery = ""
+			request.URL = u.String()
+		}
+	}
+	switch headers := exec.request["headers"].(type) {
+	case map[string]string:
+		for k, v := range headers {
+			request.Headers[k] = r.header(k, v)
+		}
+	case map[string]interface{}:
+		for k, v := range headers {
+			request.Headers[k] = r.header(k, fmt.Sprint(v))
+		}
+	}
+	scope.AddEventProcessor(func(event *sentry.Event, hint *sentry.EventHint) *sentry.Event {
+		event.Request = request
+		return event
+	})
+
+	return hub
+}
+
+// reportExecutionError sends a failed execution to Sentry, grouped by the
+// same fingerprint as the function's error groups.
+func (app *App) reportExecutionError(exec *execution, err error) {
+	if app.sentry == nil || err == nil || errors.Is(err, errExecutionCancelled) {
+		return
+	}
+
+	hub := app.executionHub(exec)
+	fingerprint, location := errorFingerprint(err)
+	hub.WithScope(func(scope *sentry.Scope) {
+		scope.SetFingerprint([]string{strconv.Itoa(exec.function.ID), fingerprint})
+		if location != "" {
+			scope.SetTag("location", location)
+		}
+		hub.CaptureException(err)
+	})
+}
+
+// reportExecutionPanic sends a Go panic raised while executing a function.
+func (app *App) reportExecutionPanic(exec *execution, r interface{}) {
+	if app.sentry == nil {
+		return
+	}
+
+	hub := app.executionHub(exec)
+	hub.RecoverWithContext(exec.ctx, r)
+	hub.Flush(sentryFlushTimeout)
+	if reported, ok := exec.ctx.Value(sentryPanicKey{}).(*atomic.Bool); ok {
+		reported.Store(true)
+	}
+}
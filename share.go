@@ -0,0 +1,269 @@
+package runbox
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxShareLinkAge caps how long a share link can stay valid.
+const maxShareLinkAge = 90 * 24 * time.Hour
+
+// ShareLink is a signed, expiring link to a read-only view of a function.
+// Deleting the row revokes the link before it expires.
+type ShareLink struct {
+	ID         int       `json:"id"`
+	FunctionID int       `json:"functionId"`
+	URL        string    `json:"url,omitempty"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// loadShareKey sets the key share links are signed with: share.key or
+// RUNBOX_SHARE_KEY, or else one made on the first start and kept in the
+// database, so links survive restarts and work on every instance of a
+// cluster.
+func (app *App) loadShareKey() error {
+	key := app.config.Share.Key
+	if key == "" {
+		key = os.Getenv("RUNBOX_SHARE_KEY")
+	}
+	if key != "" {
+		app.shareKey = []byte(key)
+		return nil
+	}
+
+	random := make([]byte, 32)
+	rand.Read(random)
+	// Instances starting together race to make it; the first one wins.
+	if _, err := app.db.Exec(`INSERT OR IGNORE INTO share_key (id, key) VALUES (1, ?)`, hex.EncodeToString(random)); err != nil {
+		return fmt.Errorf("failed to store share key: %v", err)
+	}
+	if err := app.db.QueryRow(`SELECT key FROM share_key WHERE id = 1`).Scan(&key); err != nil {
+		return fmt.Errorf("failed to load share key: %v", err)
+	}
+	stored, err := hex.DecodeString(key)
+	if err != nil {
+		return fmt.Errorf("invalid stored share key: %v", err)
+	}
+	app.shareKey = stored
+	return nil
+}
+
+func (app *App) initShareLinksTable() error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS share_links (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		function_id INTEGER NOT NULL REFERENCES functions(id) ON DELETE CASCADE,
+		expires_at DATETIME NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS share_key (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		key TEXT NOT NULL
+	);`
+
+	if _, err := app.db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create share links table: %v", err)
+	}
+	return nil
+}
+
+// shareToken signs a link's ID, function and expiry; the token is all a
+// visitor needs.
+func (app *App) shareToken(link *ShareLink) string {
+	payload := fmt.Sprintf("%d.%d.%d", link.ID, link.FunctionID, link.ExpiresAt.Unix())
+	mac := hmac.New(sha256.New, app.shareKey)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyShareToken returns the link a token was signed for, as long as it
+// has not expired or been revoked.
+func (app *App) verifyShareToken(token string) (*ShareLink, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if !ok || err != nil {
+		return nil, fmt.Errorf("malformed link")
+	}
+	sum, err := base64.RawURLEncoding.DecodeString(signature)
+	mac := hmac.New(sha256.New, app.shareKey)
+	mac.Write(payload)
+	if err != nil || !hmac.Equal(sum, mac.Sum(nil)) {
+		return nil, fmt.Errorf("invalid signature")
+	}
+
+	parts := strings.Split(string(payload), ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed link")
+	}
+	id, _ := strconv.Atoi(parts[0])
+	expires, _ := strconv.ParseInt(parts[2], 10, 64)
+	if time.Now().Unix() >= expires {
+		return nil, fmt.Errorf("this link has expired")
+	}
+
+	var link ShareLink
+	err = app.db.QueryRow(`SELECT id, function_id, expires_at, created_at FROM share_links WHERE id = ?`, id).
+		Scan(&link.ID, &link.FunctionID, &link.ExpiresAt, &link.CreatedAt)
+	if err != nil || strconv.Itoa(link.FunctionID) != parts[1] {
+		return nil, fmt.Errorf("this link has been revoked")
+	}
+	return &link, nil
+}
+
+func (app *App) listShareLinks(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+
+	rows, err := app.db.Query(`SELECT id, function_id, expires_at, created_at FROM share_links
+		WHERE function_id = ? AND expires_at > ? ORDER BY id DESC`, id, time.Now().UTC())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list share links"})
+		return
+	}
+	defer rows.Close()
+
+	baseURL := app.publicBaseURL(c)
+	links := []ShareLink{}
+	for rows.Next() {
+		var link ShareLink
+		if err := rows.Scan(&link.ID, &link.FunctionID, &link.ExpiresAt, &link.CreatedAt); err != nil {
+			continue
+		}
+		link.URL = baseURL + "/share/" + app.shareToken(&link)
+		links = append(links, link)
+	}
+	c.JSON(http.StatusOK, gin.H{"links": links})
+}
+
+type shareLinkInput struct {
+	ExpiresIn string `json:"expiresIn"`
+}
+
+// createShareLink serves POST /api/functions/:id/share-links. expiresIn is
+// a duration such as "24h", up to 90 days; it defaults to a week.
+func (app *App) createShareLink(c *gin.Context) {
+	functionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+	if _, err := app.getFunctionByID(functionID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Function not found"})
+		return
+	}
+
+	var in shareLinkInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid share link body"})
+		return
+	}
+	age := 7 * 24 * time.Hour
+	if in.ExpiresIn != "" {
+		if age, err = time.ParseDuration(in.ExpiresIn); err != nil || age <= 0 || age > maxShareLinkAge {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expiresIn must be a duration such as 24h, up to 2160h"})
+			return
+		}
+	}
+
+	link := ShareLink{FunctionID: functionID, ExpiresAt: time.Now().UTC().Add(age).Truncate(time.Second)}
+	result, err := app.db.Exec(`INSERT INTO share_links (function_id, expires_at) VALUES (?, ?)`, functionID, link.ExpiresAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create share link: " + err.Error()})
+		return
+	}
+	id, _ := result.LastInsertId()
+	link.ID = int(id)
+	link.CreatedAt = time.Now().UTC()
+	link.URL = app.publicBaseURL(c) + "/share/" + app.shareToken(&link)
+	c.JSON(http.StatusCreated, link)
+}
+
+func (app *App) deleteShareLink(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid share link ID"})
+		return
+	}
+
+	if _, err := app.db.Exec(`DELETE FROM share_links WHERE id = ?`, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke share link"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Share link revoked"})
+}
+
+// sharedRequest is a saved request as the shared view shows it, with the
+// URL path it is sent to.
+type sharedRequest struct {
+	SavedRequest
+	Target string
+}
+
+// shareRedactor hides credentials from the saved requests a share link
+// shows: the headers every capture redacts, the header configured in
+// public.authHeader, and the function's own capture rules.
+func (app *App) shareRedactor(functionID int) *redactor {
+	settings := &CaptureSettings{}
+	if s, err := app.getCaptureSettings(functionID); err == nil {
+		settings = s
+	}
+	r := newRedactor(settings)
+	if header := app.config.Public.AuthHeader; header != "" {
+		r.headers[strings.ToLower(header)] = true
+	}
+	return r
+}
+
+// sharedFunctionPage serves GET /share/:token, the read-only view of a
+// function: its code, description and example requests. Saved requests are
+// shown as examples, and the generated snippets when there are none.
+func (app *App) sharedFunctionPage(c *gin.Context) {
+	link, err := app.verifyShareToken(c.Param("token"))
+	if err != nil {
+		c.HTML(http.StatusNotFound, "share.html", gin.H{"title": "Link unavailable", "error": "This share link is not valid: " + err.Error()})
+		return
+	}
+	function, err := app.getFunctionByID(link.FunctionID)
+	if err != nil {
+		c.HTML(http.StatusNotFound, "share.html", gin.H{"title": "Link unavailable", "error": "The shared function no longer exists"})
+		return
+	}
+	saved, _ := app.getFunctionSavedRequests(function.ID)
+	redact := app.shareRedactor(function.ID)
+	requests := make([]sharedRequest, len(saved))
+	for i, r := range saved {
+		redact.savedRequest(&r)
+		target := function.Path
+		if strings.HasSuffix(target, "/*") {
+			target = strings.TrimSuffix(target, "/*") + "/" + strings.TrimPrefix(r.Subpath, "/")
+		}
+		if r.Query != "" {
+			target += "?" + r.Query
+		}
+		requests[i] = sharedRequest{SavedRequest: r, Target: "/api/execute" + target}
+	}
+
+	c.HTML(http.StatusOK, "share.html", gin.H{
+		"title":     function.Name,
+		"function":  function,
+		"requests":  requests,
+		"snippets":  app.functionSnippets(function, app.publicBaseURL(c)),
+		"expiresAt": link.ExpiresAt,
+	})
+}
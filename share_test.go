@@ -0,0 +1,130 @@
+package runbox
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyShareToken(t *testing.T) {
+	s := newTestServer(t, nil)
+	app := s.app
+	function := newTestFunction(t, app, "/shared", "function GET() { return {}; }")
+	other := newTestFunction(t, app, "/other", "function GET() { return {}; }")
+
+	newLink := func(expiresAt time.Time) *ShareLink {
+		link := &ShareLink{FunctionID: function.ID, ExpiresAt: expiresAt.UTC().Truncate(time.Second)}
+		result, err := app.db.Exec(`INSERT INTO share_links (function_id, expires_at) VALUES (?, ?)`, link.FunctionID, link.ExpiresAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := result.LastInsertId()
+		link.ID = int(id)
+		return link
+	}
+	valid := newLink(time.Now().Add(time.Hour))
+	expired := newLink(time.Now().Add(-time.Minute))
+	revoked := newLink(time.Now().Add(time.Hour))
+	revokedToken := app.shareToken(revoked)
+	app.db.Exec(`DELETE FROM share_links WHERE id = ?`, revoked.ID)
+
+	// The same link signed for another function, and signed with another key.
+	moved := *valid
+	moved.FunctionID = other.ID
+	foreign := newTestServer(t, nil).app
+	payload, signature, _ := strings.Cut(app.shareToken(valid), ".")
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr string
+	}{
+		{"valid", app.shareToken(valid), ""},
+		{"expired", app.shareToken(expired), "expired"},
+		{"revoked", revokedToken, "revoked"},
+		{"other function", app.shareToken(&moved), "revoked"},
+		{"other key", foreign.shareToken(valid), "invalid signature"},
+		{"tampered signature", payload + "." + strings.ToUpper(signature), "invalid signature"},
+		{"no signature", payload, "malformed"},
+		{"not base64", "!!!.???", "malformed"},
+		{"empty", "", "malformed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			link, err := app.verifyShareToken(tt.token)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("verifyShareToken: %v", err)
+				}
+				if link.ID != valid.ID || link.FunctionID != function.ID {
+					t.Errorf("verifyShareToken = link %d of function %d, want link %d of function %d", link.ID, link.FunctionID, valid.ID, function.ID)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("verifyShareToken error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestShareKeySurvivesRestart(t *testing.T) {
+	config := DefaultConfig()
+	config.Database = filepath.Join(t.TempDir(), "runbox.db")
+	config.LogLevel = LogError
+	first, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := first.app.shareKey
+	first.Close()
+
+	second, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	if string(second.app.shareKey) != string(key) {
+		t.Error("the generated share key changed on restart")
+	}
+}
+
+func TestSharedPageRedactsCredentials(t *testing.T) {
+	s := newTestServer(t, func(c *Config) { c.Public.AuthHeader = "X-Gateway-Key" })
+	app := s.app
+	function := newTestFunction(t, app, "/shared", "function POST() { return {}; }")
+	_, err := app.db.Exec(`INSERT INTO saved_requests (function_id, name, method, headers, body) VALUES (?, ?, ?, ?, ?)`,
+		function.ID, "example", "POST",
+		`{"Authorization":"Basic dXNlcjpzZWNyZXQ=","Cookie":"session=secret-cookie","X-Gateway-Key":"secret-gateway","X-Trace":"visible-header"}`,
+		`{"order":"visible-body"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	link := &ShareLink{FunctionID: function.ID, ExpiresAt: time.Now().Add(time.Hour).UTC().Truncate(time.Second)}
+	result, err := app.db.Exec(`INSERT INTO share_links (function_id, expires_at) VALUES (?, ?)`, link.FunctionID, link.ExpiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := result.LastInsertId()
+	link.ID = int(id)
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/share/"+app.shareToken(link), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	page := w.Body.String()
+	for _, secret := range []string{"dXNlcjpzZWNyZXQ=", "secret-cookie", "secret-gateway"} {
+		if strings.Contains(page, secret) {
+			t.Errorf("the shared page shows %q", secret)
+		}
+	}
+	for _, kept := range []string{"visible-header", "visible-body", redacted} {
+		if !strings.Contains(page, kept) {
+			t.Errorf("the shared page doesn't show %q", kept)
+		}
+	}
+}
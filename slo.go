@@ -0,0 +1,294 @@
+package runbox
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	sloTargetsTTL      = 30 * time.Second
+	sloMinInvocations  = 10
+	sloBreachShare     = 0.05 // p95 is over the target once more than 5% are slower
+	defaultSLOWindow   = "15m"
+	sloEvaluationEvery = 30 * time.Second
+)
+
+// LatencySLO is a function's latency target. Executions slower than
+// TargetMs are flagged slow in the execution log and counted in metrics;
+// when the p95 over Window exceeds the target, the targets are notified
+// once until it recovers.
+type LatencySLO struct {
+	FunctionID     int        `json:"functionId"`
+	TargetMs       int64      `json:"targetMs"`
+	Window         string     `json:"window"`
+	WebhookURL     string     `json:"webhookUrl,omitempty"`
+	Secret         string     `json:"-"`
+	HasSecret      bool       `json:"hasSecret"`
+	Email          []string   `json:"email"`
+	Breaching      bool       `json:"breaching"`
+	LastBreachedAt *time.Time `json:"lastBreachedAt,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+}
+
+func (app *App) initLatencySLOTable() error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS latency_slos (
+		function_id INTEGER PRIMARY KEY REFERENCES functions(id) ON DELETE CASCADE,
+		target_ms INTEGER NOT NULL,
+		time_window TEXT NOT NULL,
+		webhook_url TEXT NOT NULL DEFAULT '',
+		secret TEXT NOT NULL DEFAULT '',
+		email TEXT NOT NULL DEFAULT '',
+		breaching INTEGER NOT NULL DEFAULT 0,
+		last_breached_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	if _, err := app.db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create latency_slos table: %v", err)
+	}
+	return nil
+}
+
+const latencySLOColumns = `function_id, target_ms, time_window, webhook_url, secret, email, breaching, last_breached_at, created_at`
+
+func scanLatencySLO(row rowScanner) (*LatencySLO, error) {
+	var (
+		s            LatencySLO
+		email        string
+		lastBreached sql.NullTime
+	)
+	err := row.Scan(&s.FunctionID, &s.TargetMs, &s.Window, &s.WebhookURL, &s.Secret, &email, &s.Breaching,
+		&lastBreached, &s.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	s.HasSecret = s.Secret != ""
+	s.Email = []string{}
+	if email != "" {
+		s.Email = strings.Split(email, ",")
+	}
+	if lastBreached.Valid {
+		s.LastBreachedAt = &lastBreached.Time
+	}
+	return &s, nil
+}
+
+func (app *App) getLatencySLO(functionID int) (*LatencySLO, error) {
+	return scanLatencySLO(app.db.QueryRow(`SELECT `+latencySLOColumns+` FROM latency_slos WHERE function_id = ?`, functionID))
+}
+
+// sloTargets caches every latency target so flagging an execution costs no
+// query; it is reloaded every sloTargetsTTL, so changes made on another
+// instance apply within that time.
+type sloTargets struct {
+	mu       sync.Mutex
+	targets  map[int]time.Duration
+	loadedAt time.Time
+}
+
+func newSLOTargets() *sloTargets {
+	return &sloTargets{targets: map[int]time.Duration{}}
+}
+
+func (t *sloTargets) invalidate() {
+	t.mu.Lock()
+	t.loadedAt = time.Time{}
+	t.mu.Unlock()
+}
+
+// slowExecution reports whether elapsed exceeds the function's target.
+func (app *App) slowExecution(functionID int, elapsed time.Duration) bool {
+	t := app.slos
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if time.Since(t.loadedAt) > sloTargetsTTL {
+		if rows, err := app.db.Query(`SELECT function_id, target_ms FROM latency_slos`); err == nil {
+			targets := map[int]time.Duration{}
+			for rows.Next() {
+				var id int
+				var ms int64
+				if rows.Scan(&id, &ms) == nil {
+					targets[id] = time.Duration(ms) * time.Millisecond
+				}
+			}
+			rows.Close()
+			t.targets = targets
+		}
+		t.loadedAt = time.Now()
+	}
+
+	target, ok := t.targets[functionID]
+	return ok && elapsed > target
+}
+
+// evaluateLatencySLOs runs on the leader. The p95 is taken to exceed the
+// target when more than 5% of the window's executions were slow, which is
+// exact where the histogram's bucket bounds would not be.
+func (app *App) evaluateLatencySLOs(now time.Time) {
+	if !app.periodic.due("latency-slos", now, sloEvaluationEvery) {
+		return
+	}
+
+	rows, err := app.db.Query(`SELECT ` + latencySLOColumns + ` FROM latency_slos`)
+	if err != nil {
+		log.Println("Failed to load latency SLOs:", err)
+		return
+	}
+	var slos []LatencySLO
+	for rows.Next() {
+		if s, err := scanLatencySLO(rows); err == nil {
+			slos = append(slos, *s)
+		}
+	}
+	rows.Close()
+
+	for i := range slos {
+		s := &slos[i]
+		window, err := parseWindow(s.Window)
+		if err != nil {
+			continue
+		}
+		stats, err := app.functionMetrics([]int{s.FunctionID}, now.Add(-window))
+		if err != nil {
+			continue
+		}
+		st := stats[s.FunctionID]
+		if st.Invocations < sloMinInvocations {
+			continue
+		}
+
+		breaching := float64(st.Slow) > sloBreachShare*float64(st.Invocations)
+		if breaching == s.Breaching {
+			continue
+		}
+		if !breaching {
+			app.db.Exec(`UPDATE latency_slos SET breaching = 0 WHERE function_id = ?`, s.FunctionID)
+			continue
+		}
+		app.db.Exec(`UPDATE latency_slos SET breaching = 1, last_breached_at = ? WHERE function_id = ?`, now, s.FunctionID)
+		app.goBackground(func() { app.notifySLOBreach(s, st) })
+	}
+}
+
+func (app *App) notifySLOBreach(s *LatencySLO, st *functionStats) {
+	function, err := app.getFunctionByID(s.FunctionID)
+	if err != nil {
+		return
+	}
+
+	data := map[string]interface{}{
+		"function":    functionSummary(function),
+		"targetMs":    s.TargetMs,
+		"window":      s.Window,
+		"invocations": st.Invocations,
+		"slow":        st.Slow,
+		"p95Ms":       st.percentile(0.95),
+	}
+	text := fmt.Sprintf("%s: %d of %d executions in the last %s took longer than the %d ms target (p95 about %d ms).\n",
+		function.Path, st.Slow, st.Invocations, s.Window, s.TargetMs, st.percentile(0.95))
+
+	app.notify(notifyTargets{WebhookURL: s.WebhookURL, Secret: s.Secret, Email: s.Email},
+		WebhookSLOBreached, data, fmt.Sprintf("[RunBox] %s: p95 latency over %d ms", function.Path, s.TargetMs), text)
+}
+
+func (app *App) getLatencySLOHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+
+	s, err := app.getLatencySLO(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No latency target is set for this function"})
+		return
+	}
+
+	c.JSON(http.StatusOK, s)
+}
+
+func (app *App) setLatencySLO(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+	if _, err := app.getFunctionByID(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Function not found"})
+		return
+	}
+
+	var in struct {
+		TargetMs   int64    `json:"targetMs"`
+		Window     string   `json:"window"`
+		WebhookURL string   `json:"webhookUrl"`
+		Secret     *string  `json:"secret"`
+		Email      []string `json:"email"`
+	}
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid latency target body"})
+		return
+	}
+	if in.TargetMs < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "targetMs must be at least 1"})
+		return
+	}
+	if in.Window == "" {
+		in.Window = defaultSLOWindow
+	}
+	if _, err := parseWindow(in.Window); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if msg := (notifyTargets{WebhookURL: in.WebhookURL, Email: in.Email}).validate(); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	current := ""
+	if existing, err := app.getLatencySLO(id); err == nil {
+		current = existing.Secret
+	}
+	secret := keptSecret(current, in.Secret)
+
+	_, err = app.db.Exec(`INSERT INTO latency_slos (function_id, target_ms, time_window, webhook_url, secret, email) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (function_id) DO UPDATE SET target_ms = excluded.target_ms, time_window = excluded.time_window,
+			webhook_url = excluded.webhook_url, secret = excluded.secret, email = excluded.email`,
+		id, in.TargetMs, in.Window, in.WebhookURL, secret, strings.Join(in.Email, ","))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save latency target: " + err.Error()})
+		return
+	}
+	app.slos.invalidate()
+	app.broadcastChange(changeSettings, id)
+
+	s, _ := app.getLatencySLO(id)
+	c.JSON(http.StatusOK, s)
+}
+
+func (app *App) deleteLatencySLO(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+
+	if _, err := app.db.Exec(`DELETE FROM latency_slos WHERE function_id = ?`, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove latency target"})
+		return
+	}
+	app.slos.invalidate()
+	app.broadcastChange(changeSettings, id)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Latency target removed"})
+}
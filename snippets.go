@@ -0,0 +1,178 @@
+package runbox
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// snippetTokenVariable is the environment variable snippets read the
+// credential from when an auth header is configured.
+const snippetTokenVariable = "RUNBOX_TOKEN"
+
+// routePathPattern finds the routes a router registers with a literal
+// pattern, as in app.get("/items/:id", ...).
+var routePathPattern = regexp.MustCompile(`\bapp\s*\.\s*(get|post|put|patch|delete|head|options|all)\s*\(\s*['"]([^'"]*)['"]`)
+
+// Snippet is a ready-to-run request to a function in several clients.
+type Snippet struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	Curl   string `json:"curl"`
+	HTTPie string `json:"httpie"`
+	Fetch  string `json:"fetch"`
+}
+
+// publicBaseURL is public.url or, without one, the scheme and host the
+// request came in on.
+func (app *App) publicBaseURL(c *gin.Context) string {
+	if app.config.Public.URL != "" {
+		return strings.TrimSuffix(app.config.Public.URL, "/")
+	}
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+// snippetTargets lists the method and path of each request worth showing:
+// every route a router registers under a wildcard function, or else each
+// method the function answers, with GET and POST for those taking any.
+func snippetTargets(f *Function) [][2]string {
+	base := strings.TrimSuffix(f.Path, "/*")
+	var targets [][2]string
+	seen := map[[2]string]bool{}
+	add := func(method, path string) {
+		t := [2]string{method, path}
+		if !seen[t] {
+			seen[t] = true
+			targets = append(targets, t)
+		}
+	}
+
+	if (f.Mode == ModeStandard || f.Mode == ModeXML) && strings.HasSuffix(f.Path, "/*") {
+		for _, m := range routePathPattern.FindAllStringSubmatch(f.Code, -1) {
+			method := strings.ToUpper(m[1])
+			if method == "ALL" {
+				method = http.MethodGet
+			}
+			add(method, base+"/"+strings.TrimPrefix(m[2], "/"))
+		}
+	}
+	for _, method := range functionMethods(f) {
+		if method == "ANY" {
+			add(http.MethodGet, base)
+			add(http.MethodPost, base)
+			continue
+		}
+		add(method, base)
+	}
+	if len(targets) == 0 {
+		add(http.MethodGet, base)
+	}
+	return targets
+}
+
+// snippetAuth is the header snippets send: public.authHeader when set, or
+// runbox's own bearer token for private functions.
+func (app *App) snippetAuth(f *Function) PublicConfig {
+	auth := app.config.Public
+	if auth.AuthHeader == "" && app.visibilityOf(f) == VisibilityPrivate {
+		auth.AuthHeader, auth.AuthScheme = "Authorization", "Bearer"
+	}
+	return auth
+}
+
+// functionSnippets builds a snippet per target, sending public.authHeader
+// with the token from the environment when one is configured.
+func (app *App) functionSnippets(f *Function, baseURL string) []Snippet {
+	auth := app.snippetAuth(f)
+	credential := "$" + snippetTokenVariable
+	jsCredential := "process.env." + snippetTokenVariable
+	if auth.AuthScheme != "" {
+		credential = auth.AuthScheme + " " + credential
+		jsCredential = strconv.Quote(auth.AuthScheme+" ") + " + " + jsCredential
+	}
+
+	snippets := []Snippet{}
+	for _, t := range snippetTargets(f) {
+		method, url := t[0], baseURL+"/api/execute"+t[1]
+		withBody := method == http.MethodPost || method == http.MethodPut || method == http.MethodPatch
+
+		curl := []string{"curl"}
+		switch method {
+		case http.MethodGet:
+		case http.MethodHead:
+			curl = append(curl, "-I")
+		default:
+			curl = append(curl, "-X", method)
+		}
+		curl = append(curl, "'"+url+"'")
+		httpie := []string{"http"}
+		if withBody {
+			httpie = append(httpie, "--json")
+		}
+		httpie = append(httpie, method, "'"+url+"'")
+		headers := []string{}
+
+		if auth.AuthHeader != "" {
+			curl = append(curl, fmt.Sprintf(`-H "%s: %s"`, auth.AuthHeader, credential))
+			httpie = append(httpie, fmt.Sprintf(`"%s:%s"`, auth.AuthHeader, credential))
+			headers = append(headers, fmt.Sprintf("    %s: %s", strconv.Quote(auth.AuthHeader), jsCredential))
+		}
+		if withBody {
+			curl = append(curl, `-H 'Content-Type: application/json'`, `-d '{"hello": "world"}'`)
+			httpie = append(httpie, "hello=world")
+			headers = append(headers, `    "Content-Type": "application/json"`)
+		}
+
+		fetch := "const response = await fetch(" + strconv.Quote(url) + ", {\n  method: " + strconv.Quote(method)
+		if len(headers) > 0 {
+			fetch += ",\n  headers: {\n" + strings.Join(headers, ",\n") + "\n  }"
+		}
+		if withBody {
+			fetch += `,
+  body: JSON.stringify({ hello: "world" })`
+		}
+		fetch += "\n});\nconsole.log(response.status, await response.text());"
+
+		snippets = append(snippets, Snippet{
+			Method: method,
+			URL:    url,
+			Curl:   strings.Join(curl, " "),
+			HTTPie: strings.Join(httpie, " "),
+			Fetch:  fetch,
+		})
+	}
+	return snippets
+}
+
+// functionSnippetsHandler serves GET /api/functions/:id/snippets: curl,
+// HTTPie and fetch calls for each handler the function defines.
+func (app *App) functionSnippetsHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+	function, err := app.getFunctionByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Function not found"})
+		return
+	}
+	baseURL := app.publicBaseURL(c)
+	c.JSON(http.StatusOK, gin.H{
+		"baseUrl":  baseURL,
+		"auth":     app.snippetAuth(function).AuthHeader != "",
+		"authVar":  snippetTokenVariable,
+		"snippets": app.functionSnippets(function, baseURL),
+	})
+}
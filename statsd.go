@@ -0,0 +1,211 @@
+package runbox
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultStatsDPrefix       = "runbox"
+	defaultStatsDFlushSeconds = 10
+	// maxStatsDTimings caps the latency samples sent per function and
+	// flush; past it a random subset goes out with its sample rate.
+	maxStatsDTimings = 500
+	// maxStatsDPacket keeps datagrams under a typical Ethernet MTU.
+	maxStatsDPacket = 1432
+)
+
+type statsdFunction struct {
+	invocations int64
+	errors      int64
+	slow        int64
+	egressBytes int64
+	timings     []int64
+	seen        int64 // timings observed, of which timings is a sample
+}
+
+// statsdExporter pushes execution metrics to a StatsD server in the plain
+// StatsD line protocol, for setups that don't read the metrics API.
+// Counters and latency samples collect in memory between flushes.
+type statsdExporter struct {
+	app    *App
+	conn   net.Conn
+	prefix string
+
+	mu        sync.Mutex
+	functions map[int]*statsdFunction
+}
+
+func newStatsDExporter(app *App, config StatsDConfig) (*statsdExporter, error) {
+	conn, err := net.Dial("udp", config.Addr)
+	if err != nil {
+		return nil, err
+	}
+	prefix := config.Prefix
+	if prefix == "" {
+		prefix = defaultStatsDPrefix
+	}
+	return &statsdExporter{
+		app:       app,
+		conn:      conn,
+		prefix:    strings.TrimSuffix(prefix, "."),
+		functions: map[int]*statsdFunction{},
+	}, nil
+}
+
+// startStatsD sends metrics every statsd.flushSeconds when statsd.addr is
+// set.
+func (app *App) startStatsD() error {
+	config := app.config.StatsD
+	if config.Addr == "" {
+		return nil
+	}
+	exporter, err := newStatsDExporter(app, config)
+	if err != nil {
+		return fmt.Errorf("failed to set up StatsD: %v", err)
+	}
+	app.statsd = exporter
+
+	interval := time.Duration(config.FlushSeconds) * time.Second
+	if interval <= 0 {
+		interval = defaultStatsDFlushSeconds * time.Second
+	}
+	app.every(interval, func(time.Time) {
+		exporter.flush()
+	})
+	return nil
+}
+
+func (s *statsdExporter) function(id int) *statsdFunction {
+	f, ok := s.functions[id]
+	if !ok {
+		f = &statsdFunction{}
+		s.functions[id] = f
+	}
+	return f
+}
+
+// observe counts an execution; it does nothing when StatsD is off.
+func (s *statsdExporter) observe(functionID int, duration time.Duration, failed, slow bool) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f := s.function(functionID)
+	f.invocations++
+	if failed {
+		f.errors++
+	}
+	if slow {
+		f.slow++
+	}
+	f.seen++
+	ms := duration.Milliseconds()
+	if len(f.timings) < maxStatsDTimings {
+		f.timings = append(f.timings, ms)
+	} else if i := rand.Int64N(f.seen); i < maxStatsDTimings {
+		f.timings[i] = ms
+	}
+}
+
+func (s *statsdExporter) addEgress(functionID int, n int64) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.function(functionID).egressBytes += n
+}
+
+// metricName turns a function path into a StatsD name segment:
+// /billing/invoice becomes billing_invoice and / becomes root.
+func metricName(path string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-':
+			return r
+		}
+		return '_'
+	}, strings.Trim(path, "/"))
+	if name == "" {
+		return "root"
+	}
+	return name
+}
+
+// flush sends what was counted since the last flush, with gauges of the
+// instance's current load, in as few datagrams as fit.
+func (s *statsdExporter) flush() {
+	s.mu.Lock()
+	functions := s.functions
+	s.functions = map[int]*statsdFunction{}
+	s.mu.Unlock()
+
+	var lines []string
+	if len(functions) > 0 {
+		names := map[int]string{}
+		if all, err := s.app.getAllFunctions(); err == nil {
+			for _, f := range all {
+				names[f.ID] = metricName(f.Path)
+			}
+		}
+		for id, f := range functions {
+			name, ok := names[id]
+			if !ok {
+				continue // deleted since
+			}
+			base := s.prefix + ".functions." + name + "."
+			lines = append(lines,
+				fmt.Sprintf("%sinvocations:%d|c", base, f.invocations),
+				fmt.Sprintf("%serrors:%d|c", base, f.errors),
+				fmt.Sprintf("%sslow:%d|c", base, f.slow),
+				fmt.Sprintf("%segress_bytes:%d|c", base, f.egressBytes))
+			rate := ""
+			if f.seen > int64(len(f.timings)) {
+				rate = "|@" + strconv.FormatFloat(float64(len(f.timings))/float64(f.seen), 'f', 4, 64)
+			}
+			for _, ms := range f.timings {
+				lines = append(lines, fmt.Sprintf("%sduration:%d|ms%s", base, ms, rate))
+			}
+		}
+	}
+
+	rs := s.app.vmStats
+	rs.mu.Lock()
+	active := rs.active
+	rs.mu.Unlock()
+	lines = append(lines,
+		fmt.Sprintf("%s.executions.active:%d|g", s.prefix, active),
+		fmt.Sprintf("%s.jobs.busy_workers:%d|g", s.prefix, rs.busyWorkers.Load()),
+		fmt.Sprintf("%s.jobs.queued:%d|g", s.prefix, len(s.app.jobs.pending)))
+
+	var packet bytes.Buffer
+	send := func() {
+		if packet.Len() == 0 {
+			return
+		}
+		if _, err := s.conn.Write(packet.Bytes()); err != nil {
+			log.Println("Failed to send StatsD metrics:", err)
+		}
+		packet.Reset()
+	}
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > maxStatsDPacket {
+			send()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	send()
+}
@@ -0,0 +1,286 @@
+package runbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/robertkrimen/otto"
+)
+
+const (
+	defaultStorageEndpoint = "https://s3.amazonaws.com"
+	defaultStoragePrefix   = "runbox/"
+
+	// maxStorageObjectBytes bounds what put and get move through the
+	// sandbox; larger files go through signed URLs instead.
+	maxStorageObjectBytes = 10 << 20
+	maxStorageListKeys    = 1000
+	defaultSignedURLTTL   = 15 * time.Minute
+	maxSignedURLTTL       = 7 * 24 * time.Hour
+)
+
+// objectStorage holds the bucket client, created on first use so an
+// instance without storage configured never dials out.
+type objectStorage struct {
+	config StorageConfig
+	mu     sync.Mutex
+	client *minio.Client
+}
+
+func (s *objectStorage) connection() (*minio.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client != nil {
+		return s.client, nil
+	}
+	if s.config.Bucket == "" {
+		return nil, errors.New("storage.bucket is not configured")
+	}
+
+	endpoint := s.config.Endpoint
+	if endpoint == "" {
+		endpoint = defaultStorageEndpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("storage.endpoint must be an http or https URL")
+	}
+	creds := credentials.NewEnvAWS()
+	if s.config.AccessKeyID != "" {
+		creds = credentials.NewStaticV4(s.config.AccessKeyID, s.config.SecretAccessKey, "")
+	}
+	lookup := minio.BucketLookupAuto
+	if s.config.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+	client, err := minio.New(u.Host, &minio.Options{
+		Creds:        creds,
+		Secure:       u.Scheme == "https",
+		Region:       s.config.Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.client = client
+	return client, nil
+}
+
+// functionPrefix is where a function's objects live in the bucket.
+func (s *objectStorage) functionPrefix(functionID int) string {
+	prefix := s.config.Prefix
+	if prefix == "" {
+		prefix = defaultStoragePrefix
+	}
+	return prefix + strconv.Itoa(functionID) + "/"
+}
+
+// jsStorage exposes runbox.storage, files private to each function in the
+// configured S3-compatible bucket. Bodies are strings; binary data goes
+// in and out as base64 with {encoding: "base64"}.
+func (app *App) jsStorage(vm *otto.Otto, exec *execution) *otto.Object {
+	storage, _ := vm.Object(`({})`)
+
+	client := func(call otto.FunctionCall, name string) *minio.Client {
+		if exec.function.ID == 0 {
+			throwError(call, "runbox.storage."+name+": save the function before using its storage")
+		}
+		c, err := app.storage.connection()
+		if err != nil {
+			throwError(call, "runbox.storage."+name+": "+err.Error())
+		}
+		return c
+	}
+	key := func(call otto.FunctionCall, name string) string {
+		k := call.Argument(0).String()
+		if !call.Argument(0).IsString() || k == "" || strings.HasPrefix(k, "/") {
+			throwError(call, "runbox.storage."+name+": key must be a non-empty string not starting with /")
+		}
+		return app.storage.functionPrefix(exec.function.ID) + k
+	}
+	options := func(arg otto.Value) map[string]interface{} {
+		if !arg.IsObject() {
+			return map[string]interface{}{}
+		}
+		exported, _ := arg.Export()
+		m, _ := exported.(map[string]interface{})
+		return m
+	}
+	bucket := app.storage.config.Bucket
+
+	// put stores body under key, answering {key, size, etag}. A body that
+	// isn't a string is stored as JSON. The content type comes from
+	// options.contentType, else the key's extension.
+	storage.Set("put", func(call otto.FunctionCall) otto.Value {
+		c := client(call, "put")
+		k := key(call, "put")
+		opts := options(call.Argument(2))
+		contentType, _ := opts["contentType"].(string)
+
+		var body []byte
+		switch arg := call.Argument(1); {
+		case arg.IsString():
+			body = []byte(arg.String())
+			if opts["encoding"] == "base64" {
+				decoded, err := base64.StdEncoding.DecodeString(arg.String())
+				if err != nil {
+					throwError(call, "runbox.storage.put: body is not valid base64")
+				}
+				body = decoded
+			}
+		case arg.IsDefined():
+			exported, _ := arg.Export()
+			encoded, err := json.Marshal(exported)
+			if err != nil {
+				throwError(call, "runbox.storage.put: body must be a string or JSON-serializable")
+			}
+			body = encoded
+			if contentType == "" {
+				contentType = "application/json"
+			}
+		default:
+			throwError(call, "runbox.storage.put: body is required")
+		}
+		if len(body) > maxStorageObjectBytes {
+			throwError(call, "runbox.storage.put: body is larger than 10 MiB; upload it to a signedUrl with method PUT")
+		}
+		if contentType == "" {
+			contentType = mime.TypeByExtension(path.Ext(k))
+		}
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		info, err := c.PutObject(exec.ctx, bucket, k, bytes.NewReader(body), int64(len(body)), minio.PutObjectOptions{ContentType: contentType})
+		if err != nil {
+			throwError(call, "runbox.storage.put: "+err.Error())
+		}
+		return toValue(call, map[string]interface{}{"key": call.Argument(0).String(), "size": info.Size, "etag": info.ETag})
+	})
+
+	// get answers {body, contentType, size, lastModified}, or null when
+	// there is no object under key.
+	storage.Set("get", func(call otto.FunctionCall) otto.Value {
+		c := client(call, "get")
+		k := key(call, "get")
+		opts := options(call.Argument(1))
+
+		object, err := c.GetObject(exec.ctx, bucket, k, minio.GetObjectOptions{})
+		if err != nil {
+			throwError(call, "runbox.storage.get: "+err.Error())
+		}
+		defer object.Close()
+		stat, err := object.Stat()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return otto.NullValue()
+		}
+		if err != nil {
+			throwError(call, "runbox.storage.get: "+err.Error())
+		}
+		if stat.Size > maxStorageObjectBytes {
+			throwError(call, "runbox.storage.get: object is larger than 10 MiB; serve it from a signedUrl")
+		}
+		body, err := io.ReadAll(object)
+		if err != nil {
+			throwError(call, "runbox.storage.get: "+err.Error())
+		}
+		encoded := string(body)
+		if opts["encoding"] == "base64" {
+			encoded = base64.StdEncoding.EncodeToString(body)
+		}
+		return toValue(call, map[string]interface{}{
+			"body":         encoded,
+			"contentType":  stat.ContentType,
+			"size":         stat.Size,
+			"lastModified": stat.LastModified.UTC().Format(time.RFC3339),
+		})
+	})
+
+	// list answers the objects whose keys start with an optional prefix,
+	// in order, up to 1000: [{key, size, lastModified}].
+	storage.Set("list", func(call otto.FunctionCall) otto.Value {
+		c := client(call, "list")
+		prefix := ""
+		if arg := call.Argument(0); arg.IsDefined() && !arg.IsNull() {
+			prefix = arg.String()
+		}
+		root := app.storage.functionPrefix(exec.function.ID)
+		objects := []map[string]interface{}{}
+		// Cancelling stops the listing when it is cut short.
+		ctx, cancel := context.WithCancel(exec.ctx)
+		defer cancel()
+		for object := range c.ListObjects(ctx, bucket, minio.ListObjectsOptions{Prefix: root + prefix, Recursive: true, MaxKeys: maxStorageListKeys}) {
+			if object.Err != nil {
+				throwError(call, "runbox.storage.list: "+object.Err.Error())
+			}
+			objects = append(objects, map[string]interface{}{
+				"key":          strings.TrimPrefix(object.Key, root),
+				"size":         object.Size,
+				"lastModified": object.LastModified.UTC().Format(time.RFC3339),
+			})
+			if len(objects) == maxStorageListKeys {
+				break
+			}
+		}
+		return toValue(call, objects)
+	})
+
+	// signedUrl answers a URL that reads key, or with {method: "PUT"}
+	// uploads to it, without credentials, for options.expiresSeconds
+	// (default 900, at most 7 days).
+	storage.Set("signedUrl", func(call otto.FunctionCall) otto.Value {
+		c := client(call, "signedUrl")
+		k := key(call, "signedUrl")
+		opts := options(call.Argument(1))
+
+		ttl := defaultSignedURLTTL
+		if arg := call.Argument(1); arg.IsObject() {
+			if seconds, _ := arg.Object().Get("expiresSeconds"); seconds.IsDefined() {
+				n, _ := seconds.ToFloat()
+				ttl = time.Duration(n) * time.Second
+				if ttl < time.Second || ttl > maxSignedURLTTL {
+					throwError(call, "runbox.storage.signedUrl: expiresSeconds must be between 1 and 604800")
+				}
+			}
+		}
+		var signed *url.URL
+		var err error
+		switch method, _ := opts["method"].(string); strings.ToUpper(method) {
+		case "", "GET":
+			signed, err = c.PresignedGetObject(exec.ctx, bucket, k, ttl, nil)
+		case "PUT":
+			signed, err = c.PresignedPutObject(exec.ctx, bucket, k, ttl)
+		default:
+			throwError(call, "runbox.storage.signedUrl: method must be GET or PUT")
+		}
+		if err != nil {
+			throwError(call, "runbox.storage.signedUrl: "+err.Error())
+		}
+		return toValue(call, signed.String())
+	})
+
+	storage.Set("delete", func(call otto.FunctionCall) otto.Value {
+		c := client(call, "delete")
+		k := key(call, "delete")
+		if err := c.RemoveObject(exec.ctx, bucket, k, minio.RemoveObjectOptions{}); err != nil {
+			throwError(call, "runbox.storage.delete: "+err.Error())
+		}
+		return otto.UndefinedValue()
+	})
+
+	return storage
+}
@@ -0,0 +1,124 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Open opens the SQLite database at database, a path or file: URI, the way
+// RunBox uses it: WAL, foreign keys, and waiting out other writers.
+func Open(database string) (*sql.DB, error) {
+	separator := "?"
+	if strings.Contains(database, "?") {
+		separator = "&"
+	}
+	db, err := sql.Open("sqlite3", database+separator+"_busy_timeout=5000&_journal_mode=WAL&_foreign_keys=on")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %v", err)
+	}
+	return db, nil
+}
+
+// Init creates the functions and function_versions tables, or brings ones
+// made by older releases up to date.
+func (s *Store) Init() error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS functions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		path TEXT NOT NULL UNIQUE,
+		code TEXT NOT NULL,
+		description TEXT
+	);`
+
+	if _, err := s.db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create table: %v", err)
+	}
+
+	if err := AddColumns(s.db, "functions", [][2]string{
+		{"version", "INTEGER NOT NULL DEFAULT 1"},
+		{"mode", "TEXT NOT NULL DEFAULT 'standard'"},
+		{"source_url", "TEXT NOT NULL DEFAULT ''"},
+		{"tags", "TEXT NOT NULL DEFAULT ''"},
+		{"files", "TEXT NOT NULL DEFAULT ''"},
+		{"visibility", "TEXT NOT NULL DEFAULT ''"},
+	}); err != nil {
+		return err
+	}
+
+	createVersionsTable := `
+	CREATE TABLE IF NOT EXISTS function_versions (
+		function_id INTEGER NOT NULL,
+		version INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		path TEXT NOT NULL,
+		code TEXT NOT NULL,
+		description TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (function_id, version)
+	);`
+
+	if _, err := s.db.Exec(createVersionsTable); err != nil {
+		return fmt.Errorf("failed to create function_versions table: %v", err)
+	}
+
+	// Versions recorded before modes were versioned have none; restoring
+	// one keeps the function's current mode.
+	if err := AddColumns(s.db, "function_versions", [][2]string{
+		{"mode", "TEXT NOT NULL DEFAULT ''"},
+		{"files", "TEXT NOT NULL DEFAULT ''"},
+	}); err != nil {
+		return err
+	}
+
+	// Functions created before versioning existed get their current code as their first version.
+	_, err := s.db.Exec(`
+	INSERT INTO function_versions (function_id, version, name, path, code, description, mode)
+	SELECT id, version, name, path, code, description, mode FROM functions
+	WHERE id NOT IN (SELECT function_id FROM function_versions)`)
+	if err != nil {
+		return fmt.Errorf("failed to backfill function versions: %v", err)
+	}
+	return nil
+}
+
+// AddColumns adds columns, each a name and a definition, to an existing
+// table unless they are already present, so databases created by older
+// releases pick up new fields on startup.
+func AddColumns(db *sql.DB, table string, columns [][2]string) error {
+	rows, err := db.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		return fmt.Errorf("failed to inspect table %s: %v", table, err)
+	}
+
+	exists := map[string]bool{}
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			columnType string
+			notNull    int
+			dflt       sql.NullString
+			pk         int
+		)
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &dflt, &pk); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to inspect table %s: %v", table, err)
+		}
+		exists[name] = true
+	}
+	rows.Close()
+
+	for _, column := range columns {
+		if exists[column[0]] {
+			continue
+		}
+		if _, err := db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column[0] + " " + column[1]); err != nil {
+			return fmt.Errorf("failed to add column %s.%s: %v", table, column[0], err)
+		}
+	}
+	return nil
+}
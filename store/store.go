@@ -0,0 +1,259 @@
+// Package store keeps RunBox functions and their versions in the database.
+// It knows nothing of HTTP or the JavaScript engine, so tools can read and
+// write functions without running a server:
+//
+//	db, err := sql.Open("sqlite3", "runbox.db")
+//	...
+//	functions, err := store.New(db).Functions()
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Function is a JavaScript handler and the path it is served on.
+type Function struct {
+	ID          int    `json:"id" db:"id"`
+	Name        string `json:"name" db:"name"`
+	Path        string `json:"path" db:"path"`
+	Code        string `json:"code" db:"code"`
+	Description string `json:"description" db:"description"`
+	Version     int    `json:"version" db:"version"`
+	Mode        string `json:"mode" db:"mode"`
+	// SourceURL is where the code was imported from, for re-syncing.
+	SourceURL string `json:"sourceUrl,omitempty" db:"source_url"`
+	// Tags group functions on the home page. They aren't versioned.
+	Tags []string `json:"tags" db:"tags"`
+	// Files are modules Code can require; they are versioned with it.
+	Files []FunctionFile `json:"files,omitempty" db:"files"`
+	// Visibility is public or private, or empty for the instance default.
+	// Like tags, it isn't versioned.
+	Visibility string `json:"visibility,omitempty" db:"visibility"`
+}
+
+// FunctionVersion is a function as it was saved at one version.
+type FunctionVersion struct {
+	FunctionID  int            `json:"functionId" db:"function_id"`
+	Version     int            `json:"version" db:"version"`
+	Name        string         `json:"name" db:"name"`
+	Path        string         `json:"path" db:"path"`
+	Code        string         `json:"code" db:"code"`
+	Description string         `json:"description" db:"description"`
+	Mode        string         `json:"mode" db:"mode"`
+	Files       []FunctionFile `json:"files,omitempty" db:"files"`
+	CreatedAt   time.Time      `json:"createdAt" db:"created_at"`
+}
+
+// FunctionFile is a module stored with a function next to its entry point
+// and loaded with require("./name").
+type FunctionFile struct {
+	Name string `json:"name"`
+	Code string `json:"code"`
+}
+
+// EncodeFiles is how files are kept in their column.
+func EncodeFiles(files []FunctionFile) string {
+	if len(files) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(files)
+	return string(data)
+}
+
+// DecodeFiles reads files back from their column.
+func DecodeFiles(data string) []FunctionFile {
+	var files []FunctionFile
+	if data != "" {
+		json.Unmarshal([]byte(data), &files)
+	}
+	return files
+}
+
+// RowScanner is a *sql.Row or *sql.Rows.
+type RowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// Store reads and writes the functions and function_versions tables.
+type Store struct {
+	db *sql.DB
+}
+
+func New(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+const functionColumns = `id, name, path, code, description, version, mode, source_url, tags, files, visibility`
+
+func scanFunction(row RowScanner) (*Function, error) {
+	var f Function
+	var description sql.NullString
+	var tags, files string
+	err := row.Scan(&f.ID, &f.Name, &f.Path, &f.Code, &description, &f.Version, &f.Mode, &f.SourceURL, &tags, &files, &f.Visibility)
+	if err != nil {
+		return nil, err
+	}
+	f.Description = description.String
+	f.Files = DecodeFiles(files)
+	f.Tags = []string{}
+	if tags != "" {
+		f.Tags = strings.Split(tags, ",")
+	}
+
+	return &f, nil
+}
+
+// Functions answers every function, by name.
+func (s *Store) Functions() ([]Function, error) {
+	query := `SELECT ` + functionColumns + ` FROM functions ORDER BY name`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var functions []Function
+	for rows.Next() {
+		f, err := scanFunction(rows)
+		if err != nil {
+			return nil, err
+		}
+		functions = append(functions, *f)
+	}
+
+	return functions, nil
+}
+
+func (s *Store) FunctionByID(id int) (*Function, error) {
+	query := `SELECT ` + functionColumns + ` FROM functions WHERE id = ?`
+	return scanFunction(s.db.QueryRow(query, id))
+}
+
+func (s *Store) FunctionByPath(path string) (*Function, error) {
+	query := `SELECT ` + functionColumns + ` FROM functions WHERE path = ?`
+	return scanFunction(s.db.QueryRow(query, path))
+}
+
+// InsertFunction stores a new function as version 1, setting its ID and
+// version.
+func (s *Store) InsertFunction(function *Function) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO functions (name, path, code, description, version, mode, source_url, tags, files, visibility) VALUES (?, ?, ?, ?, 1, ?, ?, ?, ?, ?)`
+	result, err := tx.Exec(query, function.Name, function.Path, function.Code, function.Description, function.Mode, function.SourceURL,
+		strings.Join(function.Tags, ","), EncodeFiles(function.Files), function.Visibility)
+	if err != nil {
+		return err
+	}
+
+	id, _ := result.LastInsertId()
+	function.ID = int(id)
+	function.Version = 1
+
+	if err := recordVersion(tx, function); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SaveFunction stores the versioned fields of function as its next
+// version, setting the version. It answers sql.ErrNoRows for a function
+// that doesn't exist.
+func (s *Store) SaveFunction(function *Function) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE functions SET name = ?, path = ?, code = ?, description = ?, mode = ?, files = ?, version = version + 1 WHERE id = ?`
+	result, err := tx.Exec(query, function.Name, function.Path, function.Code, function.Description, function.Mode, EncodeFiles(function.Files), function.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+
+	err = tx.QueryRow(`SELECT version FROM functions WHERE id = ?`, function.ID).Scan(&function.Version)
+	if err != nil {
+		return err
+	}
+
+	if err := recordVersion(tx, function); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteFunction removes a function with its versions.
+func (s *Store) DeleteFunction(id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM functions WHERE id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM function_versions WHERE function_id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func recordVersion(tx *sql.Tx, function *Function) error {
+	query := `INSERT INTO function_versions (function_id, version, name, path, code, description, mode, files) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := tx.Exec(query, function.ID, function.Version, function.Name, function.Path, function.Code, function.Description, function.Mode,
+		EncodeFiles(function.Files))
+	return err
+}
+
+const versionColumns = `function_id, version, name, path, code, description, mode, files, created_at`
+
+func scanVersion(row RowScanner) (*FunctionVersion, error) {
+	var v FunctionVersion
+	var description sql.NullString
+	var files string
+	err := row.Scan(&v.FunctionID, &v.Version, &v.Name, &v.Path, &v.Code, &description, &v.Mode, &files, &v.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	v.Description = description.String
+	v.Files = DecodeFiles(files)
+	return &v, nil
+}
+
+// Versions answers the versions of a function, newest first.
+func (s *Store) Versions(functionID int) ([]FunctionVersion, error) {
+	query := `SELECT ` + versionColumns + ` FROM function_versions WHERE function_id = ? ORDER BY version DESC`
+	rows, err := s.db.Query(query, functionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []FunctionVersion
+	for rows.Next() {
+		v, err := scanVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, *v)
+	}
+
+	return versions, nil
+}
+
+func (s *Store) Version(functionID, version int) (*FunctionVersion, error) {
+	query := `SELECT ` + versionColumns + ` FROM function_versions WHERE function_id = ? AND version = ?`
+	return scanVersion(s.db.QueryRow(query, functionID, version))
+}
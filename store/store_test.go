@@ -0,0 +1,57 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestFunctionVersions(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "runbox.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	s := New(db)
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	f := &Function{Name: "hello", Path: "/hello", Code: "// 1", Mode: "standard", Files: []FunctionFile{{Name: "lib.js", Code: "// lib"}}}
+	if err := s.InsertFunction(f); err != nil {
+		t.Fatal(err)
+	}
+	f.Code = "// 2"
+	if err := s.SaveFunction(f); err != nil {
+		t.Fatal(err)
+	}
+	if f.Version != 2 {
+		t.Errorf("version after a save = %d, want 2", f.Version)
+	}
+
+	got, err := s.FunctionByPath("/hello")
+	if err != nil || got.Code != "// 2" || len(got.Files) != 1 {
+		t.Fatalf("FunctionByPath = %+v, %v", got, err)
+	}
+	first, err := s.Version(f.ID, 1)
+	if err != nil || first.Code != "// 1" || first.Files[0].Name != "lib.js" {
+		t.Fatalf("Version(1) = %+v, %v", first, err)
+	}
+	if versions, _ := s.Versions(f.ID); len(versions) != 2 || versions[0].Version != 2 {
+		t.Errorf("Versions = %+v, want 2 then 1", versions)
+	}
+
+	if err := s.DeleteFunction(f.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.FunctionByID(f.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("FunctionByID after delete = %v, want sql.ErrNoRows", err)
+	}
+	if versions, _ := s.Versions(f.ID); len(versions) != 0 {
+		t.Errorf("%d versions left after delete", len(versions))
+	}
+	if err := s.SaveFunction(f); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("SaveFunction after delete = %v, want sql.ErrNoRows", err)
+	}
+}
@@ -0,0 +1,296 @@
+package runbox
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FunctionTemplate is starter code offered in the create form. Built-in
+// templates ship with runbox; custom ones are added through the API and
+// kept in the database.
+type FunctionTemplate struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Mode        string     `json:"mode"`
+	Path        string     `json:"path"`
+	Code        string     `json:"code"`
+	Builtin     bool       `json:"builtin"`
+	CreatedAt   *time.Time `json:"createdAt,omitempty"`
+}
+
+var templateIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+
+var builtinTemplates = []FunctionTemplate{
+	{
+		ID:          "json-echo",
+		Name:        "JSON echo",
+		Description: "Returns the request it was called with, for trying out clients.",
+		Mode:        ModeStandard,
+		Path:        "/echo",
+		Code: `app.all('*', function (req, res) {
+  res.json({
+    method: req.method,
+    path: req.path,
+    query: req.query,
+    headers: req.headers,
+    body: req.body
+  });
+});
+`,
+	},
+	{
+		ID:          "webhook-receiver",
+		Name:        "Webhook receiver",
+		Description: "Checks a shared token and publishes the payload as a webhook.received event.",
+		Mode:        ModeStandard,
+		Path:        "/hooks/incoming",
+		Code: `// Senders put this token in the X-Webhook-Token header.
+var TOKEN = 'change-me';
+
+app.post('/', function (req, res) {
+  if (req.headers['X-Webhook-Token'] !== TOKEN) {
+    res.status(401).json({ error: 'invalid token' });
+    return;
+  }
+  console.log('webhook received', req.rawBody.length, 'bytes');
+  var deliveries = runbox.events.publish('webhook.received', req.body);
+  res.status(202).json({ accepted: true, deliveries: deliveries });
+});
+`,
+	},
+	{
+		ID:          "kv-crud",
+		Name:        "CRUD over KV",
+		Description: "A small REST resource stored in runbox.kv; mount it on a wildcard path.",
+		Mode:        ModeStandard,
+		Path:        "/todos/*",
+		Code: `function load(id) {
+  return runbox.kv.get('todo:' + id);
+}
+
+app.get('/', function (req, res) {
+  res.json(runbox.kv.list('todo:').map(function (key) {
+    return runbox.kv.get(key);
+  }));
+});
+
+app.get('/:id', function (req, res) {
+  var todo = load(req.params.id);
+  if (!todo) {
+    res.status(404).json({ error: 'not found' });
+    return;
+  }
+  res.json(todo);
+});
+
+app.post('/', function (req, res) {
+  var id = (runbox.kv.get('next-id') || 1);
+  runbox.kv.set('next-id', id + 1);
+  var todo = { id: id, title: req.body.title || '', done: false };
+  runbox.kv.set('todo:' + id, todo);
+  res.status(201).json(todo);
+});
+
+app.put('/:id', function (req, res) {
+  var todo = load(req.params.id);
+  if (!todo) {
+    res.status(404).json({ error: 'not found' });
+    return;
+  }
+  if (req.body.title !== undefined) todo.title = req.body.title;
+  if (req.body.done !== undefined) todo.done = !!req.body.done;
+  runbox.kv.set('todo:' + todo.id, todo);
+  res.json(todo);
+});
+
+app.delete('/:id', function (req, res) {
+  if (!runbox.kv.delete('todo:' + req.params.id)) {
+    res.status(404).json({ error: 'not found' });
+    return;
+  }
+  res.status(204).send('');
+});
+`,
+	},
+	{
+		ID:          "html-page",
+		Name:        "HTML page",
+		Description: "Serves an HTML page instead of JSON.",
+		Mode:        ModeStandard,
+		Path:        "/hello",
+		Code: `function escape(s) {
+  return String(s).replace(/[&<>"']/g, function (c) {
+    return { '&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;' }[c];
+  });
+}
+
+app.get('/', function (req, res) {
+  var name = escape(req.query.name || 'world');
+  res.set('Content-Type', 'text/html; charset=utf-8').send(
+    '<!DOCTYPE html><html><head><title>Hello</title></head>' +
+    '<body><h1>Hello, ' + name + '!</h1></body></html>');
+});
+`,
+	},
+	{
+		ID:          "cron-job",
+		Name:        "Cron job",
+		Description: "A SCHEDULE handler to attach a cron schedule to; GET shows its last run.",
+		Mode:        ModeStandard,
+		Path:        "/jobs/nightly",
+		Code: `// Add a schedule on the function, e.g. {"cron": "0 3 * * *"}.
+function SCHEDULE(request) {
+  console.log('running for schedule', request.schedule.id, 'at', request.schedule.firedAt);
+  // ... the job's work goes here ...
+  runbox.kv.set('last-run', { at: request.schedule.firedAt, payload: request.body });
+  return { ok: true };
+}
+
+function GET(request) {
+  return { lastRun: runbox.kv.get('last-run') };
+}
+`,
+	},
+}
+
+func (app *App) initTemplatesTable() error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS function_templates (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		mode TEXT NOT NULL,
+		path TEXT NOT NULL DEFAULT '',
+		code TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	if _, err := app.db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create function_templates table: %v", err)
+	}
+	return nil
+}
+
+const templateColumns = `id, name, description, mode, path, code, created_at`
+
+func scanTemplate(row rowScanner) (*FunctionTemplate, error) {
+	var t FunctionTemplate
+	var createdAt time.Time
+	if err := row.Scan(&t.ID, &t.Name, &t.Description, &t.Mode, &t.Path, &t.Code, &createdAt); err != nil {
+		return nil, err
+	}
+	t.CreatedAt = &createdAt
+	return &t, nil
+}
+
+// functionTemplates lists the built-in templates followed by the custom
+// ones by name.
+func (app *App) functionTemplates() ([]FunctionTemplate, error) {
+	templates := append([]FunctionTemplate{}, builtinTemplates...)
+	for i := range templates {
+		templates[i].Builtin = true
+	}
+
+	rows, err := app.db.Query(`SELECT ` + templateColumns + ` FROM function_templates ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		t, err := scanTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, *t)
+	}
+	return templates, nil
+}
+
+func (app *App) getFunctionTemplate(id string) (*FunctionTemplate, error) {
+	for _, t := range builtinTemplates {
+		if t.ID == id {
+			t.Builtin = true
+			return &t, nil
+		}
+	}
+	return scanTemplate(app.db.QueryRow(`SELECT `+templateColumns+` FROM function_templates WHERE id = ?`, id))
+}
+
+func (app *App) listTemplatesHandler(c *gin.Context) {
+	templates, err := app.functionTemplates()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list templates"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"templates": templates})
+}
+
+func (app *App) getTemplateHandler(c *gin.Context) {
+	t, err := app.getFunctionTemplate(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+		return
+	}
+	c.JSON(http.StatusOK, t)
+}
+
+func (app *App) createTemplate(c *gin.Context) {
+	var in FunctionTemplate
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template body"})
+		return
+	}
+	if !templateIDPattern.MatchString(in.ID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id must be lowercase letters, digits, and dashes"})
+		return
+	}
+	if in.Name == "" || in.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name and code are required"})
+		return
+	}
+	if in.Mode == "" {
+		in.Mode = ModeStandard
+	}
+	if !validMode(in.Mode) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid mode " + in.Mode})
+		return
+	}
+	if diagnostics := validateFunctionCode(in.Code, in.Mode); diagnostics != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "syntax error at " + diagnostics[0].String(), "errors": diagnostics})
+		return
+	}
+	for _, t := range builtinTemplates {
+		if t.ID == in.ID {
+			c.JSON(http.StatusConflict, gin.H{"error": "A built-in template is named " + in.ID})
+			return
+		}
+	}
+
+	_, err := app.db.Exec(`INSERT INTO function_templates (id, name, description, mode, path, code) VALUES (?, ?, ?, ?, ?, ?)`,
+		in.ID, in.Name, in.Description, in.Mode, in.Path, in.Code)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Failed to create template: " + err.Error()})
+		return
+	}
+
+	t, _ := app.getFunctionTemplate(in.ID)
+	c.JSON(http.StatusCreated, t)
+}
+
+func (app *App) deleteTemplate(c *gin.Context) {
+	res, err := app.db.Exec(`DELETE FROM function_templates WHERE id = ?`, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete template"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No custom template " + c.Param("id") + "; built-in templates can't be deleted"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Template deleted"})
+}
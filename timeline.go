@@ -0,0 +1,86 @@
+package runbox
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robertkrimen/otto"
+)
+
+// ExecutionTimings breaks an execution down by phase, in milliseconds. Route
+// excludes the database lookups it made, which are DB; handler excludes
+// exporting results out of the VM, which is export. Total runs from route
+// lookup to the serialized response.
+type ExecutionTimings struct {
+	RouteMs     float64 `json:"routeMs"`
+	DBMs        float64 `json:"dbMs"`
+	SetupMs     float64 `json:"setupMs"`
+	CompileMs   float64 `json:"compileMs"`
+	HandlerMs   float64 `json:"handlerMs"`
+	ExportMs    float64 `json:"exportMs"`
+	SerializeMs float64 `json:"serializeMs"`
+	TotalMs     float64 `json:"totalMs"`
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+type timingPhase struct {
+	Name  string  `json:"name"`
+	Ms    float64 `json:"ms"`
+	Color string  `json:"color"`
+}
+
+func (t ExecutionTimings) phases() []timingPhase {
+	return []timingPhase{
+		{"route", t.RouteMs, "#6c757d"},
+		{"db", t.DBMs, "#0dcaf0"},
+		{"setup", t.SetupMs, "#adb5bd"},
+		{"compile", t.CompileMs, "#ffc107"},
+		{"handler", t.HandlerMs, "#0d6efd"},
+		{"export", t.ExportMs, "#6f42c1"},
+		{"serialize", t.SerializeMs, "#198754"},
+	}
+}
+
+// serverTiming formats the breakdown as a Server-Timing header value, which
+// browser dev tools show in the request's timing tab.
+func (t ExecutionTimings) serverTiming() string {
+	var parts []string
+	for _, p := range append(t.phases(), timingPhase{Name: "total", Ms: t.TotalMs}) {
+		parts = append(parts, fmt.Sprintf("%s;dur=%.3f", p.Name, p.Ms))
+	}
+	return strings.Join(parts, ", ")
+}
+
+type timingBar struct {
+	timingPhase
+	Percent float64 `json:"percent"`
+}
+
+// bars sizes each phase as a share of the sum of the phases, for the logs
+// page and the test console.
+func (t ExecutionTimings) bars() []timingBar {
+	var sum float64
+	phases := t.phases()
+	for _, p := range phases {
+		sum += p.Ms
+	}
+	var bars []timingBar
+	for _, p := range phases {
+		if p.Ms > 0 {
+			bars = append(bars, timingBar{timingPhase: p, Percent: 100 * p.Ms / sum})
+		}
+	}
+	return bars
+}
+
+// export converts a value out of the VM, counting the time against the
+// export phase.
+func (exec *execution) export(value otto.Value) (interface{}, error) {
+	started := time.Now()
+	defer func() { exec.timings.ExportMs += millis(time.Since(started)) }()
+	return value.Export()
+}
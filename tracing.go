@@ -0,0 +1,172 @@
+package runbox
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const tracerName = "github.com/prodemmi/runbox"
+
+// tracePropagator carries W3C trace context and baggage in and out of
+// requests, whether or not tracing is on. It is RunBox's own rather than
+// otel's global one, so a program embedding RunBox keeps its own.
+var tracePropagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// initTracing answers the tracer for the OTLP exporter described by
+// config. Endpoint and headers fall back to the standard
+// OTEL_EXPORTER_OTLP_* environment variables. With tracing off the tracer
+// is a no-op, so the spans cost next to nothing.
+func initTracing(config TracingConfig) (tracer trace.Tracer, shutdown func(), err error) {
+	if !config.Enabled {
+		return noop.NewTracerProvider().Tracer(tracerName), func() {}, nil
+	}
+
+	ctx := context.Background()
+	var exporter *otlptrace.Exporter
+	switch config.Protocol {
+	case "", "http/protobuf":
+		opts := []otlptracehttp.Option{}
+		if config.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(config.Endpoint))
+		}
+		if config.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		if len(config.Headers) > 0 {
+			opts = append(opts, otlptracehttp.WithHeaders(config.Headers))
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	case "grpc":
+		opts := []otlptracegrpc.Option{}
+		if config.Endpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpointURL(config.Endpoint))
+		}
+		if config.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		if len(config.Headers) > 0 {
+			opts = append(opts, otlptracegrpc.WithHeaders(config.Headers))
+		}
+		exporter, err = otlptracegrpc.New(ctx, opts...)
+	default:
+		return nil, nil, fmt.Errorf("unknown tracing protocol %q", config.Protocol)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serviceName := config.ServiceName
+	if serviceName == "" {
+		serviceName = "runbox"
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(serviceName)))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sampler := sdktrace.AlwaysSample()
+	if config.SampleRatio > 0 && config.SampleRatio < 1 {
+		sampler = sdktrace.TraceIDRatioBased(config.SampleRatio)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+	)
+	return provider.Tracer(tracerName), func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := provider.Shutdown(ctx); err != nil {
+			log.Println("Failed to flush traces:", err)
+		}
+	}, nil
+}
+
+// tracingMiddleware starts a server span per request, continuing the trace
+// of an incoming traceparent header.
+func (app *App) tracingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := tracePropagator.Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+
+		route := c.FullPath()
+		if route == "" {
+			route = c.Request.URL.Path
+		}
+		ctx, span := app.tracer.Start(ctx, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(c.Request.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(c.Request.URL.Path),
+			))
+		defer span.End()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
+
+// executionContext returns the context executions are traced under. Queued
+// and synthetic executions have no live request, so they continue the trace
+// recorded in their stored headers, if any.
+func executionContext(exec *execution) context.Context {
+	if trace.SpanContextFromContext(exec.ctx).IsValid() {
+		return exec.ctx
+	}
+
+	carrier := propagation.MapCarrier{}
+	switch headers := exec.request["headers"].(type) {
+	case map[string]string:
+		for k, v := range headers {
+			carrier[strings.ToLower(k)] = v
+		}
+	case map[string]interface{}:
+		for k, v := range headers {
+			if s, ok := v.(string); ok {
+				carrier[strings.ToLower(k)] = s
+			}
+		}
+	}
+	return tracePropagator.Extract(exec.ctx, carrier)
+}
+
+func functionAttributes(function *Function) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.Int("runbox.function.id", function.ID),
+		attribute.String("runbox.function.path", function.Path),
+		attribute.Int("runbox.function.version", function.Version),
+		attribute.String("runbox.function.mode", function.Mode),
+	}
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,142 @@
+package runbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBatchSize   = 100
+	maxBatchSize       = 10000
+	defaultBatchWaitMs = 1000
+)
+
+// TriggerBatch makes a trigger deliver its messages in batches: a batch is
+// handed to the function once MaxSize messages are waiting or MaxWaitMs
+// after its first message arrived, whichever comes first.
+type TriggerBatch struct {
+	MaxSize   int `json:"maxSize"`
+	MaxWaitMs int `json:"maxWaitMs"`
+}
+
+func (b *TriggerBatch) validate() error {
+	if b.MaxSize == 0 {
+		b.MaxSize = defaultBatchSize
+	}
+	if b.MaxWaitMs == 0 {
+		b.MaxWaitMs = defaultBatchWaitMs
+	}
+	if b.MaxSize < 1 || b.MaxSize > maxBatchSize {
+		return fmt.Errorf("batch.maxSize must be between 1 and %d", maxBatchSize)
+	}
+	if b.MaxWaitMs < 0 {
+		return errors.New("batch.maxWaitMs must not be negative")
+	}
+	return nil
+}
+
+type triggerBatcher struct {
+	app     *App
+	trigger *Trigger
+	size    int
+	wait    time.Duration
+
+	mu      sync.Mutex
+	pending []TriggerMessage
+	timer   *time.Timer
+}
+
+func newTriggerBatcher(app *App, t *Trigger) *triggerBatcher {
+	return &triggerBatcher{
+		app:     app,
+		trigger: t,
+		size:    t.Batch.MaxSize,
+		wait:    time.Duration(t.Batch.MaxWaitMs) * time.Millisecond,
+	}
+}
+
+// add queues a message for the next batch. Messages awaiting a reply cannot
+// share one, so they are still handled one at a time.
+func (b *triggerBatcher) add(msg TriggerMessage) {
+	if msg.Reply != nil {
+		b.app.handleTriggerMessage(b.trigger, msg)
+		return
+	}
+
+	b.mu.Lock()
+	b.pending = append(b.pending, msg)
+	if len(b.pending) < b.size {
+		if b.timer == nil {
+			b.timer = time.AfterFunc(b.wait, b.flush)
+		}
+		b.mu.Unlock()
+		return
+	}
+	batch := b.take()
+	b.mu.Unlock()
+
+	b.app.handleTriggerBatch(b.trigger, batch)
+}
+
+func (b *triggerBatcher) take() []TriggerMessage {
+	batch := b.pending
+	b.pending = nil
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	return batch
+}
+
+func (b *triggerBatcher) flush() {
+	b.mu.Lock()
+	batch := b.take()
+	b.mu.Unlock()
+
+	if len(batch) > 0 {
+		b.app.handleTriggerBatch(b.trigger, batch)
+	}
+}
+
+// handleTriggerBatch queues one job for the whole batch. request.body is the
+// array of payloads (parsed when JSON, otherwise the raw string) and
+// request.messages holds the per-message details.
+func (app *App) handleTriggerBatch(t *Trigger, batch []TriggerMessage) {
+	function, err := app.getFunctionByID(t.FunctionID)
+	if err != nil {
+		log.Printf("Trigger %d points at a missing function %d", t.ID, t.FunctionID)
+		return
+	}
+
+	payloads := make([]json.RawMessage, len(batch))
+	messages := make([]map[string]interface{}, len(batch))
+	for i, msg := range batch {
+		if json.Valid(msg.Data) {
+			payloads[i] = msg.Data
+		} else {
+			payloads[i], _ = json.Marshal(string(msg.Data))
+		}
+		messages[i] = triggerMessageInfo(t, msg)
+	}
+	payload, _ := json.Marshal(payloads)
+
+	requestData := syntheticRequest(function, "MESSAGE", payload)
+	requestData["rawBody"] = string(payload)
+	requestData["messages"] = messages
+	requestData["batch"] = map[string]interface{}{
+		"trigger":   t.Type,
+		"triggerId": t.ID,
+		"size":      len(batch),
+	}
+
+	job, err := app.enqueueJob(function, requestData, jobOptions{Source: JobSourceTrigger})
+	if err != nil {
+		log.Printf("Failed to queue batch of %d from %s trigger %d for %s: %v", len(batch), t.Type, t.ID, function.Path, err)
+		return
+	}
+	app.logEvent(JobSourceTrigger, fmt.Sprintf("%s:%d", t.Type, t.ID), function, requestData, job.ID)
+}
@@ -0,0 +1,249 @@
+package runbox
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	defaultFSDebounce = 250 * time.Millisecond
+	defaultFSMaxBytes = 1 << 20
+)
+
+var fsEventNames = map[string]fsnotify.Op{
+	"create": fsnotify.Create,
+	"write":  fsnotify.Write,
+	"remove": fsnotify.Remove,
+	"rename": fsnotify.Rename,
+	"chmod":  fsnotify.Chmod,
+}
+
+// fsTriggerConfig watches Path, a directory, for files whose base name
+// matches Pattern. Events default to create, write and remove.
+type fsTriggerConfig struct {
+	Path      string   `json:"path"`
+	Pattern   string   `json:"pattern"`
+	Events    []string `json:"events"`
+	Recursive bool     `json:"recursive"`
+	Contents  bool     `json:"contents"`
+	MaxBytes  int64    `json:"maxBytes"`
+	Debounce  int      `json:"debounce"`
+}
+
+type fsDriver struct{}
+
+func (d *fsDriver) parse(raw json.RawMessage) (fsTriggerConfig, fsnotify.Op, error) {
+	var config fsTriggerConfig
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return config, 0, err
+	}
+	if config.Path == "" {
+		return config, 0, errors.New("path is required")
+	}
+	if config.Pattern != "" {
+		if _, err := filepath.Match(config.Pattern, ""); err != nil {
+			return config, 0, errors.New("invalid pattern: " + err.Error())
+		}
+	}
+	if len(config.Events) == 0 {
+		config.Events = []string{"create", "write", "remove"}
+	}
+
+	var ops fsnotify.Op
+	for _, name := range config.Events {
+		op, ok := fsEventNames[name]
+		if !ok {
+			return config, 0, errors.New("unknown event " + name)
+		}
+		ops |= op
+	}
+
+	if config.MaxBytes <= 0 {
+		config.MaxBytes = defaultFSMaxBytes
+	}
+	return config, ops, nil
+}
+
+func (d *fsDriver) validate(raw json.RawMessage) error {
+	_, _, err := d.parse(raw)
+	return err
+}
+
+// shared is false: every instance would see the same files on a shared
+// volume.
+func (d *fsDriver) shared(json.RawMessage) bool { return false }
+
+// close has nothing to do: each trigger closes its own watcher.
+func (d *fsDriver) close() {}
+
+func (d *fsDriver) subscribe(t *Trigger, deliver func(TriggerMessage)) (func(), error) {
+	config, ops, err := d.parse(t.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(config.Path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, errors.New(config.Path + " is not a directory")
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := watchDir(watcher, config.Path, config.Recursive); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	debounce := defaultFSDebounce
+	if config.Debounce > 0 {
+		debounce = time.Duration(config.Debounce) * time.Millisecond
+	}
+
+	go func() {
+		// Editors and copies emit bursts of events per file, so events are
+		// held per path until it has been quiet for the debounce interval.
+		var mu sync.Mutex
+		pending := map[string]*time.Timer{}
+		events := map[string]fsnotify.Op{}
+
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+
+				if config.Recursive && event.Has(fsnotify.Create) {
+					if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+						watchDir(watcher, event.Name, true)
+						continue
+					}
+				}
+				if event.Op&ops == 0 {
+					continue
+				}
+				if config.Pattern != "" {
+					if ok, _ := filepath.Match(config.Pattern, filepath.Base(event.Name)); !ok {
+						continue
+					}
+				}
+
+				name := event.Name
+				mu.Lock()
+				events[name] = mergeFSOp(events[name], event.Op&ops)
+				if timer, ok := pending[name]; ok {
+					timer.Reset(debounce)
+				} else {
+					pending[name] = time.AfterFunc(debounce, func() {
+						mu.Lock()
+						op := events[name]
+						delete(events, name)
+						delete(pending, name)
+						mu.Unlock()
+						deliver(fsMessage(config, name, op))
+					})
+				}
+				mu.Unlock()
+
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("Filesystem watch on %s failed: %v", config.Path, err)
+			}
+		}
+	}()
+
+	return func() { watcher.Close() }, nil
+}
+
+func watchDir(watcher *fsnotify.Watcher, root string, recursive bool) error {
+	if !recursive {
+		return watcher.Add(root)
+	}
+	return filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return watcher.Add(path)
+		}
+		return nil
+	})
+}
+
+// mergeFSOp collapses a burst of events into the one worth reporting: a file
+// created and then written is still new, and a removal trumps everything.
+func mergeFSOp(previous, next fsnotify.Op) fsnotify.Op {
+	switch {
+	case next.Has(fsnotify.Remove), next.Has(fsnotify.Rename):
+		return next
+	case previous.Has(fsnotify.Create) && !previous.Has(fsnotify.Remove):
+		return previous
+	case next == 0:
+		return previous
+	}
+	return next
+}
+
+func fsEventName(op fsnotify.Op) string {
+	for _, name := range []string{"remove", "rename", "create", "write", "chmod"} {
+		if op.Has(fsEventNames[name]) {
+			return name
+		}
+	}
+	return ""
+}
+
+// fsMessage describes the file after the event. With Contents set the file
+// body becomes the message payload, up to MaxBytes; the metadata says
+// whether it was cut short.
+func fsMessage(config fsTriggerConfig, name string, op fsnotify.Op) TriggerMessage {
+	metadata := map[string]interface{}{
+		"event": fsEventName(op),
+		"path":  name,
+		"name":  filepath.Base(name),
+		"dir":   filepath.Dir(name),
+		"root":  config.Path,
+	}
+	if rel, err := filepath.Rel(config.Path, name); err == nil {
+		metadata["relativePath"] = rel
+	}
+
+	info, err := os.Stat(name)
+	if err != nil {
+		metadata["exists"] = false
+		return TriggerMessage{Metadata: metadata}
+	}
+	metadata["exists"] = true
+	metadata["size"] = info.Size()
+	metadata["modTime"] = info.ModTime().UTC().Format(time.RFC3339Nano)
+	metadata["mode"] = info.Mode().String()
+
+	var data []byte
+	if config.Contents && info.Mode().IsRegular() {
+		if f, err := os.Open(name); err == nil {
+			data, _ = io.ReadAll(io.LimitReader(f, config.MaxBytes))
+			f.Close()
+		}
+		metadata["truncated"] = info.Size() > int64(len(data))
+		metadata["binary"] = !utf8.Valid(data)
+	}
+
+	return TriggerMessage{Data: data, Metadata: metadata}
+}
@@ -0,0 +1,266 @@
+package runbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// mqttTriggerConfig subscribes to Topic, a topic filter. With Group the
+// subscription is the shared one $share/<group>/<topic>, and the broker
+// hands each message to one of the group's subscribers.
+type mqttTriggerConfig struct {
+	Topic string `json:"topic"`
+	QoS   byte   `json:"qos"`
+	Group string `json:"group"`
+}
+
+// filter is the topic filter subscribed to.
+func (c mqttTriggerConfig) filter() string {
+	if c.Group == "" {
+		return c.Topic
+	}
+	return "$share/" + c.Group + "/" + c.Topic
+}
+
+type mqttBinding struct {
+	config  mqttTriggerConfig
+	deliver func(TriggerMessage)
+}
+
+// mqttDriver shares one client across all MQTT triggers. The broker only
+// sees one subscription per topic filter, so bindings are grouped by topic
+// and resubscribed whenever the client (re)connects.
+type mqttDriver struct {
+	app      *App
+	mu       sync.Mutex
+	client   mqtt.Client
+	bindings map[int]*mqttBinding
+}
+
+func (d *mqttDriver) connection() (mqtt.Client, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.client != nil {
+		return d.client, nil
+	}
+
+	config := d.app.config.MQTT
+	if config.Broker == "" {
+		return nil, errors.New("mqtt.broker is not configured")
+	}
+	clientID := config.ClientID
+	if clientID == "" {
+		clientID = "runbox-" + newID()[:8]
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(config.Broker).
+		SetClientID(clientID).
+		SetUsername(config.Username).
+		SetPassword(config.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetMaxReconnectInterval(30 * time.Second).
+		// Messages are handled concurrently; QoS 1/2 acks go out once the
+		// message has been delivered.
+		SetOrderMatters(false).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Println("MQTT connection lost:", err)
+		}).
+		SetOnConnectHandler(func(c mqtt.Client) {
+			d.resubscribe(c)
+		})
+
+	client := mqtt.NewClient(opts)
+	token := client.Connect()
+	if !token.WaitTimeout(10 * time.Second) {
+		client.Disconnect(0)
+		return nil, errors.New("timed out connecting to " + config.Broker)
+	}
+	if err := token.Error(); err != nil {
+		return nil, err
+	}
+
+	d.client = client
+	return client, nil
+}
+
+// ping reports whether the shared client is connected, or, before any
+// trigger has connected it, whether the broker accepts TCP connections.
+func (d *mqttDriver) ping(ctx context.Context) error {
+	d.mu.Lock()
+	client := d.client
+	d.mu.Unlock()
+
+	if client != nil {
+		if !client.IsConnectionOpen() {
+			return errors.New("not connected to " + d.app.config.MQTT.Broker)
+		}
+		return nil
+	}
+	u, err := url.Parse(d.app.config.MQTT.Broker)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid mqtt.broker %q", d.app.config.MQTT.Broker)
+	}
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", u.Host)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (d *mqttDriver) parse(raw json.RawMessage) (mqttTriggerConfig, error) {
+	var config mqttTriggerConfig
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return config, err
+	}
+	if config.Topic == "" {
+		return config, errors.New("topic is required")
+	}
+	if config.QoS > 2 {
+		return config, errors.New("qos must be 0, 1 or 2")
+	}
+	if strings.ContainsAny(config.Group, "/+#") {
+		return config, errors.New("group must not contain /, + or #")
+	}
+	return config, nil
+}
+
+func (d *mqttDriver) validate(raw json.RawMessage) error {
+	_, err := d.parse(raw)
+	return err
+}
+
+func (d *mqttDriver) shared(raw json.RawMessage) bool {
+	config, err := d.parse(raw)
+	return err == nil && config.Group != ""
+}
+
+// topicQoS returns the highest QoS any binding asked for on the topic
+// filter, and whether the filter has bindings at all. Callers hold d.mu.
+func (d *mqttDriver) topicQoS(topic string) (byte, bool) {
+	var qos byte
+	found := false
+	for _, b := range d.bindings {
+		if b.config.filter() == topic {
+			found = true
+			if b.config.QoS > qos {
+				qos = b.config.QoS
+			}
+		}
+	}
+	return qos, found
+}
+
+func (d *mqttDriver) handler(topic string) mqtt.MessageHandler {
+	return func(_ mqtt.Client, m mqtt.Message) {
+		d.mu.Lock()
+		var targets []*mqttBinding
+		for _, b := range d.bindings {
+			if b.config.filter() == topic {
+				targets = append(targets, b)
+			}
+		}
+		d.mu.Unlock()
+
+		for _, b := range targets {
+			b.deliver(TriggerMessage{
+				Data: m.Payload(),
+				Metadata: map[string]interface{}{
+					"topic":     m.Topic(),
+					"filter":    topic,
+					"qos":       m.Qos(),
+					"retained":  m.Retained(),
+					"duplicate": m.Duplicate(),
+					"messageId": m.MessageID(),
+				},
+			})
+		}
+	}
+}
+
+func (d *mqttDriver) subscribeTopic(client mqtt.Client, topic string, qos byte) error {
+	token := client.Subscribe(topic, qos, d.handler(topic))
+	if !token.WaitTimeout(10 * time.Second) {
+		return errors.New("timed out subscribing to " + topic)
+	}
+	return token.Error()
+}
+
+func (d *mqttDriver) resubscribe(client mqtt.Client) {
+	d.mu.Lock()
+	topics := map[string]byte{}
+	for _, b := range d.bindings {
+		topics[b.config.filter()], _ = d.topicQoS(b.config.filter())
+	}
+	d.mu.Unlock()
+
+	for topic, qos := range topics {
+		if err := d.subscribeTopic(client, topic, qos); err != nil {
+			log.Printf("Failed to resubscribe MQTT topic %s: %v", topic, err)
+		}
+	}
+}
+
+func (d *mqttDriver) close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.client != nil {
+		d.client.Disconnect(250)
+		d.client = nil
+	}
+}
+
+func (d *mqttDriver) subscribe(t *Trigger, deliver func(TriggerMessage)) (func(), error) {
+	config, err := d.parse(t.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := d.connection()
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	if d.bindings == nil {
+		d.bindings = map[int]*mqttBinding{}
+	}
+	existingQoS, existing := d.topicQoS(config.filter())
+	binding := &mqttBinding{config: config, deliver: deliver}
+	d.bindings[t.ID] = binding
+	d.mu.Unlock()
+
+	if !existing || config.QoS > existingQoS {
+		if err := d.subscribeTopic(client, config.filter(), config.QoS); err != nil {
+			d.mu.Lock()
+			delete(d.bindings, t.ID)
+			d.mu.Unlock()
+			return nil, err
+		}
+	}
+
+	return func() {
+		d.mu.Lock()
+		if d.bindings[t.ID] == binding {
+			delete(d.bindings, t.ID)
+		}
+		_, remaining := d.topicQoS(config.filter())
+		d.mu.Unlock()
+
+		if !remaining {
+			client.Unsubscribe(config.filter()).WaitTimeout(5 * time.Second)
+		}
+	}, nil
+}
@@ -0,0 +1,159 @@
+package runbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+type natsTriggerConfig struct {
+	Subject string `json:"subject"`
+	Queue   string `json:"queue"`
+	Reply   bool   `json:"reply"`
+}
+
+// natsDriver shares one connection, opened on first use, across all NATS
+// triggers.
+type natsDriver struct {
+	app  *App
+	mu   sync.Mutex
+	conn *nats.Conn
+}
+
+func (d *natsDriver) connection() (*nats.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.conn != nil {
+		return d.conn, nil
+	}
+
+	config := d.app.config.NATS
+	if config.URL == "" {
+		return nil, errors.New("nats.url is not configured")
+	}
+	name := config.Name
+	if name == "" {
+		name = "runbox"
+	}
+
+	conn, err := nats.Connect(config.URL,
+		nats.Name(name),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				log.Println("NATS disconnected:", err)
+			}
+		}),
+		nats.ReconnectHandler(func(c *nats.Conn) {
+			log.Println("NATS reconnected to", c.ConnectedUrl())
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	d.conn = conn
+	return conn, nil
+}
+
+// ping checks the shared connection with a round trip to the server, or,
+// before any trigger has opened it, that the server accepts a connection.
+func (d *natsDriver) ping(ctx context.Context) error {
+	d.mu.Lock()
+	conn := d.conn
+	d.mu.Unlock()
+
+	if conn == nil {
+		probe, err := nats.Connect(d.app.config.NATS.URL, nats.Name("runbox-readiness"), nats.Timeout(dependencyCheckTimeout))
+		if err != nil {
+			return err
+		}
+		probe.Close()
+		return nil
+	}
+	if !conn.IsConnected() {
+		return fmt.Errorf("connection is %s", conn.Status())
+	}
+	return conn.FlushWithContext(ctx)
+}
+
+func (d *natsDriver) parse(raw json.RawMessage) (natsTriggerConfig, error) {
+	var config natsTriggerConfig
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return config, err
+	}
+	if config.Subject == "" {
+		return config, errors.New("subject is required")
+	}
+	return config, nil
+}
+
+func (d *natsDriver) validate(raw json.RawMessage) error {
+	_, err := d.parse(raw)
+	return err
+}
+
+func (d *natsDriver) shared(raw json.RawMessage) bool {
+	config, err := d.parse(raw)
+	return err == nil && config.Queue != ""
+}
+
+func (d *natsDriver) close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.conn != nil {
+		d.conn.Close()
+		d.conn = nil
+	}
+}
+
+func (d *natsDriver) subscribe(t *Trigger, deliver func(TriggerMessage)) (func(), error) {
+	config, err := d.parse(t.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := d.connection()
+	if err != nil {
+		return nil, err
+	}
+
+	handler := func(m *nats.Msg) {
+		headers := map[string]string{}
+		for name := range m.Header {
+			headers[name] = m.Header.Get(name)
+		}
+
+		msg := TriggerMessage{
+			Data: m.Data,
+			Metadata: map[string]interface{}{
+				"subject": m.Subject,
+				"reply":   m.Reply,
+				"queue":   config.Queue,
+				"headers": headers,
+			},
+		}
+		if config.Reply && m.Reply != "" {
+			msg.Reply = m.Respond
+		}
+		deliver(msg)
+	}
+
+	var sub *nats.Subscription
+	if config.Queue != "" {
+		sub, err = conn.QueueSubscribe(config.Subject, config.Queue, handler)
+	} else {
+		sub, err = conn.Subscribe(config.Subject, handler)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return func() { sub.Unsubscribe() }, nil
+}
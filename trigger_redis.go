@@ -0,0 +1,289 @@
+package runbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisTriggerConfig binds a trigger to exactly one of a pub/sub channel, a
+// channel pattern or a stream. Streams read through a consumer group when
+// Group is set and from new entries only otherwise.
+type redisTriggerConfig struct {
+	Channel  string `json:"channel"`
+	Pattern  string `json:"pattern"`
+	Stream   string `json:"stream"`
+	Group    string `json:"group"`
+	Consumer string `json:"consumer"`
+	Start    string `json:"start"`
+}
+
+// redisDriver shares one client, created on first use, across all Redis
+// triggers.
+type redisDriver struct {
+	app    *App
+	mu     sync.Mutex
+	client *redis.Client
+}
+
+func (d *redisDriver) connection() (*redis.Client, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.client != nil {
+		return d.client, nil
+	}
+
+	config := d.app.config.Redis
+	if config.Addr == "" {
+		return nil, errors.New("redis.addr is not configured")
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:     config.Addr,
+		Password: config.Password,
+		DB:       config.DB,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	d.client = client
+	return client, nil
+}
+
+// ping checks the shared client, or a short-lived one when no trigger has
+// created it yet.
+func (d *redisDriver) ping(ctx context.Context) error {
+	d.mu.Lock()
+	client := d.client
+	d.mu.Unlock()
+
+	if client == nil {
+		config := d.app.config.Redis
+		client = redis.NewClient(&redis.Options{Addr: config.Addr, Password: config.Password, DB: config.DB, MaxRetries: -1})
+		defer client.Close()
+	}
+	return client.Ping(ctx).Err()
+}
+
+func (d *redisDriver) parse(raw json.RawMessage) (redisTriggerConfig, error) {
+	var config redisTriggerConfig
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return config, err
+	}
+
+	sources := 0
+	for _, s := range []string{config.Channel, config.Pattern, config.Stream} {
+		if s != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return config, errors.New("exactly one of channel, pattern or stream is required")
+	}
+	if config.Stream == "" && (config.Group != "" || config.Consumer != "" || config.Start != "") {
+		return config, errors.New("group, consumer and start only apply to streams")
+	}
+
+	if config.Group != "" && config.Consumer == "" {
+		// Instances of a cluster read the group side by side, each as
+		// its own consumer.
+		config.Consumer = "runbox"
+		if d.app.leader.clustered {
+			config.Consumer = d.app.leader.instanceID
+		}
+	}
+	return config, nil
+}
+
+func (d *redisDriver) validate(raw json.RawMessage) error {
+	_, err := d.parse(raw)
+	return err
+}
+
+func (d *redisDriver) shared(raw json.RawMessage) bool {
+	config, err := d.parse(raw)
+	return err == nil && config.Group != ""
+}
+
+func (d *redisDriver) close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.client != nil {
+		d.client.Close()
+		d.client = nil
+	}
+}
+
+func (d *redisDriver) subscribe(t *Trigger, deliver func(TriggerMessage)) (func(), error) {
+	config, err := d.parse(t.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := d.connection()
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Stream != "" {
+		return d.readStream(client, config, deliver)
+	}
+	return d.subscribeChannel(client, config, deliver)
+}
+
+func (d *redisDriver) subscribeChannel(client *redis.Client, config redisTriggerConfig, deliver func(TriggerMessage)) (func(), error) {
+	ctx := context.Background()
+
+	var pubsub *redis.PubSub
+	if config.Pattern != "" {
+		pubsub = client.PSubscribe(ctx, config.Pattern)
+	} else {
+		pubsub = client.Subscribe(ctx, config.Channel)
+	}
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	go func() {
+		for m := range pubsub.Channel() {
+			deliver(TriggerMessage{
+				Data: []byte(m.Payload),
+				Metadata: map[string]interface{}{
+					"channel": m.Channel,
+					"pattern": m.Pattern,
+				},
+			})
+		}
+	}()
+
+	return func() { pubsub.Close() }, nil
+}
+
+// readStream consumes a stream until unsubscribed. With a consumer group each
+// entry is acknowledged after it has been delivered, so entries still pending
+// when the server stops are redelivered on the next start.
+func (d *redisDriver) readStream(client *redis.Client, config redisTriggerConfig, deliver func(TriggerMessage)) (func(), error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	if config.Group != "" {
+		start := config.Start
+		if start == "" {
+			start = "$"
+		}
+		err := client.XGroupCreateMkStream(ctx, config.Stream, config.Group, start).Err()
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			cancel()
+			return nil, err
+		}
+	}
+
+	go func() {
+		// Read our own pending entries first, then switch to new ones.
+		lastID := "0"
+		if config.Group == "" {
+			lastID = config.Start
+			if lastID == "" {
+				lastID = "$"
+			}
+		}
+
+		for ctx.Err() == nil {
+			var streams []redis.XStream
+			var err error
+			if config.Group != "" {
+				streams, err = client.XReadGroup(ctx, &redis.XReadGroupArgs{
+					Group:    config.Group,
+					Consumer: config.Consumer,
+					Streams:  []string{config.Stream, lastID},
+					Count:    16,
+					Block:    5 * time.Second,
+				}).Result()
+			} else {
+				streams, err = client.XRead(ctx, &redis.XReadArgs{
+					Streams: []string{config.Stream, lastID},
+					Count:   16,
+					Block:   5 * time.Second,
+				}).Result()
+			}
+			if errors.Is(err, redis.Nil) {
+				if config.Group != "" {
+					lastID = ">"
+				}
+				continue
+			}
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("Redis stream %s read failed: %v", config.Stream, err)
+					time.Sleep(time.Second)
+				}
+				continue
+			}
+
+			received := 0
+			for _, stream := range streams {
+				for _, entry := range stream.Messages {
+					if ctx.Err() != nil {
+						return
+					}
+					received++
+					deliver(streamMessage(config, entry))
+					if config.Group != "" {
+						client.XAck(context.Background(), config.Stream, config.Group, entry.ID)
+					} else {
+						lastID = entry.ID
+					}
+				}
+			}
+			if config.Group != "" && received == 0 {
+				lastID = ">"
+			}
+		}
+	}()
+
+	// A blocking read in flight finishes within its timeout; any entries it
+	// returns after this are left unacknowledged for the next reader.
+	return cancel, nil
+}
+
+// streamMessage uses the "data" field (or "payload") as the message body and
+// passes every field through in the metadata.
+func streamMessage(config redisTriggerConfig, entry redis.XMessage) TriggerMessage {
+	fields := map[string]string{}
+	for k, v := range entry.Values {
+		if s, ok := v.(string); ok {
+			fields[k] = s
+		}
+	}
+
+	var data []byte
+	if body, ok := fields["data"]; ok {
+		data = []byte(body)
+	} else if body, ok := fields["payload"]; ok {
+		data = []byte(body)
+	} else {
+		data, _ = json.Marshal(fields)
+	}
+
+	return TriggerMessage{
+		Data: data,
+		Metadata: map[string]interface{}{
+			"stream": config.Stream,
+			"group":  config.Group,
+			"id":     entry.ID,
+			"fields": fields,
+		},
+	}
+}
@@ -0,0 +1,456 @@
+package runbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Trigger binds a function to an external message source such as a NATS
+// subject. Config holds the driver-specific settings; Batch, when set,
+// delivers messages in batches instead of one by one.
+type Trigger struct {
+	ID         int             `json:"id"`
+	FunctionID int             `json:"functionId"`
+	Type       string          `json:"type"`
+	Config     json.RawMessage `json:"config"`
+	Batch      *TriggerBatch   `json:"batch,omitempty"`
+	Enabled    bool            `json:"enabled"`
+	Active     bool            `json:"active"`
+	CreatedAt  time.Time       `json:"createdAt"`
+}
+
+// TriggerMessage is one message received by a trigger driver. Reply is set
+// when the source supports responding and the binding asked for it.
+type TriggerMessage struct {
+	Data     []byte
+	Metadata map[string]interface{}
+	Reply    func(data []byte) error
+}
+
+type triggerDriver interface {
+	validate(config json.RawMessage) error
+	// shared reports whether the source hands each message to only one
+	// subscriber of a group, as NATS queue groups, Redis consumer groups
+	// and MQTT shared subscriptions do. Every instance of a cluster
+	// subscribes to those; other triggers run on the leader alone, so a
+	// message still invokes its function once.
+	shared(config json.RawMessage) bool
+	subscribe(t *Trigger, deliver func(TriggerMessage)) (unsubscribe func(), err error)
+	// close drops the driver's connection, once nothing is subscribed.
+	close()
+}
+
+type triggerManager struct {
+	mu      sync.Mutex
+	syncing sync.Mutex
+	drivers map[string]triggerDriver
+	active  map[int]func()
+}
+
+func newTriggerManager(app *App) *triggerManager {
+	return &triggerManager{
+		drivers: map[string]triggerDriver{
+			"nats":  &natsDriver{app: app},
+			"redis": &redisDriver{app: app},
+			"mqtt":  &mqttDriver{app: app},
+			"fs":    &fsDriver{},
+		},
+		active: map[int]func(){},
+	}
+}
+
+func (app *App) initTriggersTable() error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS triggers (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		function_id INTEGER NOT NULL REFERENCES functions(id) ON DELETE CASCADE,
+		type TEXT NOT NULL,
+		config TEXT NOT NULL,
+		enabled INTEGER NOT NULL DEFAULT 1,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	if _, err := app.db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create triggers table: %v", err)
+	}
+
+	return app.addColumns("triggers", [][2]string{
+		{"batch", "TEXT"},
+	})
+}
+
+func (app *App) getTriggers(functionID int) ([]Trigger, error) {
+	query := `SELECT id, function_id, type, config, batch, enabled, created_at FROM triggers`
+	var args []interface{}
+	if functionID != 0 {
+		query += ` WHERE function_id = ?`
+		args = append(args, functionID)
+	}
+	query += ` ORDER BY id`
+
+	rows, err := app.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	triggers := []Trigger{}
+	for rows.Next() {
+		var t Trigger
+		var config string
+		var batch sql.NullString
+		if err := rows.Scan(&t.ID, &t.FunctionID, &t.Type, &config, &batch, &t.Enabled, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		t.Config = json.RawMessage(config)
+		if batch.Valid {
+			json.Unmarshal([]byte(batch.String), &t.Batch)
+		}
+		triggers = append(triggers, t)
+	}
+
+	app.triggers.mu.Lock()
+	for i := range triggers {
+		_, triggers[i].Active = app.triggers.active[triggers[i].ID]
+	}
+	app.triggers.mu.Unlock()
+
+	return triggers, nil
+}
+
+// startTriggers subscribes every enabled trigger this instance runs.
+// Failures are logged rather than fatal so one unreachable broker does not
+// keep the server down.
+func (app *App) startTriggers() {
+	app.syncTriggers()
+}
+
+// runsTrigger reports whether this instance subscribes to t: every
+// instance for shared sources, and only the leader for the others.
+func (app *App) runsTrigger(t *Trigger) bool {
+	if !app.leader.clustered || app.isLeader() {
+		return true
+	}
+	driver, ok := app.triggers.drivers[t.Type]
+	return ok && driver.shared(t.Config)
+}
+
+// syncTriggers brings the active subscriptions in line with the triggers
+// table after another instance created or deleted some, and with the
+// leadership after this instance won or lost it.
+func (app *App) syncTriggers() {
+	app.triggers.syncing.Lock()
+	defer app.triggers.syncing.Unlock()
+
+	triggers, err := app.getTriggers(0)
+	if err != nil {
+		log.Println("Failed to load triggers:", err)
+		return
+	}
+
+	enabled := map[int]bool{}
+	for i := range triggers {
+		if !triggers[i].Enabled || !app.runsTrigger(&triggers[i]) {
+			continue
+		}
+		enabled[triggers[i].ID] = true
+		if triggers[i].Active {
+			continue
+		}
+		if err := app.activateTrigger(&triggers[i]); err != nil {
+			log.Printf("Failed to start %s trigger %d: %v", triggers[i].Type, triggers[i].ID, err)
+		}
+	}
+
+	app.triggers.mu.Lock()
+	var stale []int
+	for id := range app.triggers.active {
+		if !enabled[id] {
+			stale = append(stale, id)
+		}
+	}
+	app.triggers.mu.Unlock()
+	for _, id := range stale {
+		app.deactivateTrigger(id)
+	}
+}
+
+func (app *App) activateTrigger(t *Trigger) error {
+	driver, ok := app.triggers.drivers[t.Type]
+	if !ok {
+		return fmt.Errorf("unknown trigger type %q", t.Type)
+	}
+
+	trigger := *t
+	deliver := func(msg TriggerMessage) {
+		app.handleTriggerMessage(&trigger, msg)
+	}
+	var batcher *triggerBatcher
+	if trigger.Batch != nil {
+		batcher = newTriggerBatcher(app, &trigger)
+		deliver = batcher.add
+	}
+
+	unsubscribe, err := driver.subscribe(&trigger, deliver)
+	if err != nil {
+		return err
+	}
+	if batcher != nil {
+		stop := unsubscribe
+		unsubscribe = func() {
+			stop()
+			batcher.flush()
+		}
+	}
+
+	app.triggers.mu.Lock()
+	if previous, ok := app.triggers.active[t.ID]; ok {
+		previous()
+	}
+	app.triggers.active[t.ID] = unsubscribe
+	app.triggers.mu.Unlock()
+
+	return nil
+}
+
+func (app *App) deactivateTrigger(id int) {
+	app.triggers.mu.Lock()
+	unsubscribe, ok := app.triggers.active[id]
+	delete(app.triggers.active, id)
+	app.triggers.mu.Unlock()
+
+	if ok {
+		unsubscribe()
+	}
+}
+
+// stopTriggers unsubscribes every active trigger and closes the broker
+// connections, for Close.
+func (app *App) stopTriggers() {
+	app.triggers.syncing.Lock()
+	defer app.triggers.syncing.Unlock()
+
+	app.triggers.mu.Lock()
+	var ids []int
+	for id := range app.triggers.active {
+		ids = append(ids, id)
+	}
+	app.triggers.mu.Unlock()
+	for _, id := range ids {
+		app.deactivateTrigger(id)
+	}
+	for _, driver := range app.triggers.drivers {
+		driver.close()
+	}
+}
+
+func (app *App) deactivateFunctionTriggers(functionID int) {
+	triggers, err := app.getTriggers(functionID)
+	if err != nil {
+		return
+	}
+	for _, t := range triggers {
+		app.deactivateTrigger(t.ID)
+	}
+}
+
+// triggerRequest builds the request for a trigger invocation. Handlers are
+// called as MESSAGE (falling back to default) and find the source-specific
+// details in request.message.
+func triggerRequest(function *Function, t *Trigger, msg TriggerMessage) map[string]interface{} {
+	var payload json.RawMessage
+	if json.Valid(msg.Data) {
+		payload = msg.Data
+	}
+
+	requestData := syntheticRequest(function, "MESSAGE", payload)
+	requestData["rawBody"] = string(msg.Data)
+	requestData["message"] = triggerMessageInfo(t, msg)
+
+	return requestData
+}
+
+func triggerMessageInfo(t *Trigger, msg TriggerMessage) map[string]interface{} {
+	message := map[string]interface{}{
+		"trigger":   t.Type,
+		"triggerId": t.ID,
+		"data":      string(msg.Data),
+	}
+	for k, v := range msg.Metadata {
+		message[k] = v
+	}
+	return message
+}
+
+// handleTriggerMessage queues the invocation so it is retried like any other
+// job. Messages that expect a reply are executed right away instead, since
+// the sender is waiting on the result.
+func (app *App) handleTriggerMessage(t *Trigger, msg TriggerMessage) {
+	function, err := app.getFunctionByID(t.FunctionID)
+	if err != nil {
+		log.Printf("Trigger %d points at a missing function %d", t.ID, t.FunctionID)
+		return
+	}
+
+	requestData := triggerRequest(function, t, msg)
+	topic := fmt.Sprintf("%s:%d", t.Type, t.ID)
+	if msg.Reply == nil {
+		job, err := app.enqueueJob(function, requestData, jobOptions{Source: JobSourceTrigger})
+		if err != nil {
+			log.Printf("Failed to queue %s trigger %d for %s: %v", t.Type, t.ID, function.Path, err)
+			return
+		}
+		app.logEvent(JobSourceTrigger, topic, function, requestData, job.ID)
+		return
+	}
+
+	app.logEvent(JobSourceTrigger, topic, function, requestData, "")
+
+	// The sender waits for the reply, so the handler gets the job timeout
+	// like a queued message would.
+	timeout := app.live().jobTimeout()
+	ctx, cancel := context.WithTimeout(app.ctx, timeout)
+	defer cancel()
+
+	exec := newExecution(ctx, function, requestData)
+	exec.source = JobSourceTrigger
+	result, err := app.executeJavaScript(exec)
+	if errors.Is(err, errExecutionCancelled) && ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("execution timed out after %s", timeout)
+	}
+	if err != nil {
+		log.Printf("%s trigger %d for %s failed: %v", t.Type, t.ID, function.Path, err)
+		reply, _ := json.Marshal(map[string]string{"error": err.Error()})
+		msg.Reply(reply)
+		return
+	}
+
+	var reply []byte
+	if resp, ok := result.(*HTTPResponse); ok {
+		reply = []byte(resp.Body)
+	} else if reply, err = json.Marshal(result); err != nil {
+		log.Printf("Failed to serialize reply of trigger %d: %v", t.ID, err)
+		return
+	}
+	if err := msg.Reply(reply); err != nil {
+		log.Printf("Failed to send reply of trigger %d: %v", t.ID, err)
+	}
+}
+
+func (app *App) listTriggersHandler(c *gin.Context) {
+	functionID := 0
+	if id := c.Param("id"); id != "" {
+		var err error
+		if functionID, err = strconv.Atoi(id); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+			return
+		}
+	}
+
+	triggers, err := app.getTriggers(functionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list triggers"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"triggers": triggers})
+}
+
+func (app *App) createTrigger(c *gin.Context) {
+	functionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+	if _, err := app.getFunctionByID(functionID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Function not found"})
+		return
+	}
+
+	var in struct {
+		Type    string          `json:"type"`
+		Config  json.RawMessage `json:"config"`
+		Batch   *TriggerBatch   `json:"batch"`
+		Enabled *bool           `json:"enabled"`
+	}
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid trigger body"})
+		return
+	}
+
+	driver, ok := app.triggers.drivers[in.Type]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown trigger type " + strconv.Quote(in.Type)})
+		return
+	}
+	if err := driver.validate(in.Config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid trigger config: " + err.Error()})
+		return
+	}
+	var batch sql.NullString
+	if in.Batch != nil {
+		if err := in.Batch.validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid trigger batch: " + err.Error()})
+			return
+		}
+		b, _ := json.Marshal(in.Batch)
+		batch = sql.NullString{String: string(b), Valid: true}
+	}
+
+	t := Trigger{
+		FunctionID: functionID,
+		Type:       in.Type,
+		Config:     in.Config,
+		Batch:      in.Batch,
+		Enabled:    in.Enabled == nil || *in.Enabled,
+		CreatedAt:  time.Now().UTC(),
+	}
+
+	result, err := app.db.Exec(`INSERT INTO triggers (function_id, type, config, batch, enabled) VALUES (?, ?, ?, ?, ?)`,
+		t.FunctionID, t.Type, string(t.Config), batch, t.Enabled)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create trigger: " + err.Error()})
+		return
+	}
+	id, _ := result.LastInsertId()
+	t.ID = int(id)
+	app.broadcastChange(changeTriggers, functionID)
+
+	response := gin.H{"trigger": &t}
+	if t.Enabled && app.runsTrigger(&t) {
+		if err := app.activateTrigger(&t); err != nil {
+			response["warning"] = "Trigger saved but could not be started: " + err.Error()
+		} else {
+			t.Active = true
+		}
+	}
+
+	c.JSON(http.StatusCreated, response)
+}
+
+func (app *App) deleteTrigger(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid trigger ID"})
+		return
+	}
+
+	app.deactivateTrigger(id)
+	if _, err := app.db.Exec(`DELETE FROM triggers WHERE id = ?`, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete trigger"})
+		return
+	}
+	app.broadcastChange(changeTriggers, 0)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Trigger deleted successfully"})
+}
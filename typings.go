@@ -0,0 +1,528 @@
+package runbox
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// hostFunction documents one host API function for the editor. The
+// typings and the API reference panel are generated from hostFunctions, so
+// a new host function needs an entry here to get completion and docs.
+type hostFunction struct {
+	Namespace string // such as "runbox.kv"; empty for a global
+	Name      string
+	Params    string // TypeScript parameter list
+	Returns   string // TypeScript type
+	Doc       string
+	Example   string
+}
+
+var hostFunctions = []hostFunction{
+	{"", "fetch", "url: string", "RunboxFetchResult",
+		"Fetches url with GET and waits for the response. Errors are returned in `error` rather than thrown.",
+		`var res = fetch('https://api.example.com/items');
+if (res.error) throw new Error(res.error);
+var items = JSON.parse(res.body);`},
+	{"", "require", "path: string", "any",
+		"Loads one of the function's modules by relative path, such as \"./lib/util\"; a module sets module.exports.",
+		`var format = require('./lib/format');`},
+	{"runbox", "schedule", "path: string, payload?: any, runAt?: Date | string | number", "string",
+		"Runs the function at path once, as a POST with payload as the body, at runAt (a Date, an ISO-8601 string or epoch milliseconds). Returns the job ID.",
+		`runbox.schedule('/reminders', { user: 42 }, Date.now() + 60 * 60 * 1000);`},
+	{"runbox", "db", "name: string", "RunboxDatabase",
+		"Returns the external database configured as name, if it is granted to this function. Each call is limited by the database's timeout.",
+		`var users = runbox.db('analytics').query('SELECT id, email FROM users WHERE created_at > $1', [since]);`},
+	{"runbox.grpc", "call", "method: string, payload?: object, options?: RunboxGRPCCallOptions", "any",
+		"Calls the unary method, as package.Service/Method from the function's protobuf schema, on the server configured for the service. payload and the result use the proto3 JSON mapping; a failed call throws an Error with the status code and name.",
+		`var user = runbox.grpc.call('users.v1.Users/GetUser', { id: request.query.id }, { timeout: 2 });`},
+	{"runbox", "render", "name: string, data?: any", "string",
+		"Fills in the stored render template name with data. HTML templates escape data for where it lands; text templates don't.",
+		`res.set('Content-Type', 'text/html').send(runbox.render('invoice', { number: 42, lines: lines }));`},
+	{"runbox", "pdf", "html: string, options?: RunboxPDFOptions", "string",
+		"Lays out html as a PDF and returns it base64-encoded, for a Lambda-style isBase64Encoded body; in a router, res.pdf sends it. The renderer knows headings, paragraphs, lists, tables, links and bold, italic and underlined text; stylesheets and images are left out.",
+		`return { statusCode: 200, headers: { 'Content-Type': 'application/pdf' }, body: runbox.pdf(html), isBase64Encoded: true };`},
+	{"runbox", "geoip", "ip: string", "RunboxGeo | null",
+		"Looks ip up in the configured GeoIP databases, as request.geo does for the client. Returns null when they don't have it; throws without a database.",
+		`var geo = runbox.geoip(request.body.ip);`},
+	{"runbox.events", "publish", "topic: string, payload?: any", "number",
+		"Publishes an event to the functions subscribed to topic. Returns how many deliveries were queued.",
+		`runbox.events.publish('order.created', { id: order.id });`},
+	{"runbox.email", "send", "message: RunboxEmailMessage", "{ id: string }",
+		"Sends an email through the configured provider, within the function's hourly quota. Throws when it can't be sent.",
+		`runbox.email.send({ to: 'ops@example.com', subject: 'Disk full', text: 'Clean up /var' });`},
+	{"runbox.notify", "slack", "webhook: string, message: string | object", "void",
+		"Posts message to a Slack incoming webhook: webhook is the name of a secret holding its URL, or the URL. A string is sent as the text; an object as the payload, for blocks. Throws when Slack refuses it.",
+		`runbox.notify.slack('SLACK_ALERTS', 'Disk usage is at ' + usage + '%');`},
+	{"runbox.notify", "discord", "webhook: string, message: string | object", "void",
+		"Posts message to a Discord webhook: webhook is the name of a secret holding its URL, or the URL. A string is sent as the content; an object as the payload, for embeds. Throws when Discord refuses it.",
+		`runbox.notify.discord('DISCORD_OPS', 'Deploy of ' + version + ' finished');`},
+	{"runbox.ai", "complete", "request: RunboxCompletionRequest", "RunboxCompletion",
+		"Asks the configured OpenAI-compatible API for a chat completion. The tokens are counted against the function's monthly AI budget, and the call throws once it is spent.",
+		`var summary = runbox.ai.complete({ prompt: 'Summarize in one line: ' + text, maxTokens: 60 }).text;`},
+	{"runbox.parse", "csv", "text: string, options?: { header?: boolean, delimiter?: string }", "any[]",
+		"Parses CSV. With header (the default) the first row names the columns and every row becomes an object of strings; otherwise each row is an array.",
+		`var rows = runbox.parse.csv(request.rawBody, { delimiter: ';' });`},
+	{"runbox.parse", "xml", "text: string", "{ [root: string]: any }",
+		"Parses an XML document into { root: value }. An element with only text becomes the text; others become objects of attributes as \"@name\", children by name (an array when a name repeats) and text as \"#text\".",
+		`var order = runbox.parse.xml(request.rawBody).order;
+var skus = [].concat(order.item).map(function (item) { return item['@sku']; });`},
+	{"runbox.parse", "yaml", "text: string", "any",
+		"Parses the first document of YAML text. Timestamps become ISO-8601 strings.",
+		`var config = runbox.parse.yaml(runbox.storage.get('config.yaml').body);`},
+	{"runbox.stringify", "csv", "rows: any[], options?: { header?: boolean, delimiter?: string, columns?: string[] }", "string",
+		"Writes rows as CSV. Arrays are written as they are; objects under a header row of columns, by default every key in order of first appearance. Objects in cells are written as JSON.",
+		`res.set('Content-Type', 'text/csv').send(runbox.stringify.csv(orders, { columns: ['id', 'total'] }));`},
+	{"runbox.stringify", "xml", "value: { [root: string]: any }", "string",
+		"Writes { root: value }, the form runbox.parse.xml returns, as an XML document. Keys starting with @ are attributes, #text is the text and arrays repeat their element.",
+		`res.set('Content-Type', 'application/xml').send(runbox.stringify.xml({ reply: { '@status': 'ok', id: order.id } }));`},
+	{"runbox.stringify", "yaml", "value: any", "string",
+		"Writes value as YAML, keeping the order of object keys.",
+		`var text = runbox.stringify.yaml({ name: 'api', replicas: 3 });`},
+	{"runbox.kv", "get", "key: string", "any",
+		"Returns the value stored under key in this function's store, or null.",
+		`var count = runbox.kv.get('visits') || 0;`},
+	{"runbox.kv", "set", "key: string, value: any", "void",
+		"Stores a JSON-serializable value, up to 256 KiB, under key.",
+		`runbox.kv.set('visits', count + 1);`},
+	{"runbox.kv", "delete", "key: string", "boolean",
+		"Deletes key, returning whether it existed.",
+		`runbox.kv.delete('session:' + id);`},
+	{"runbox.kv", "list", "prefix?: string", "string[]",
+		"Lists the keys starting with prefix, in order.",
+		`var sessions = runbox.kv.list('session:');`},
+	{"runbox.cache", "get", "key: string", "any",
+		"Returns the value cached under key in this function's Redis cache, shared by every instance, or null.",
+		`var user = runbox.cache.get('user:' + id);`},
+	{"runbox.cache", "set", "key: string, value: any, ttlSeconds?: number", "void",
+		"Caches a JSON-serializable value, up to 256 KiB, under key, expiring after ttlSeconds if given.",
+		`runbox.cache.set('user:' + id, user, 300);`},
+	{"runbox.cache", "incr", "key: string, by?: number", "number",
+		"Adds by (1 by default) to the integer counter under key, starting from 0, and returns the new value.",
+		`var hits = runbox.cache.incr('hits:' + request.ip);
+if (hits === 1) runbox.cache.expire('hits:' + request.ip, 60);`},
+	{"runbox.cache", "expire", "key: string, seconds: number", "boolean",
+		"Makes key expire after seconds, returning whether it exists.",
+		`runbox.cache.expire('session:' + id, 3600);`},
+	{"runbox.cache", "delete", "key: string", "boolean",
+		"Deletes key from the cache, returning whether it existed.",
+		`runbox.cache.delete('user:' + id);`},
+	{"runbox.storage", "put", "key: string, body: any, options?: { contentType?: string, encoding?: \"base64\" }", "{ key: string, size: number, etag: string }",
+		"Stores body, up to 10 MiB, under key in this function's part of the storage bucket. Strings are stored as they are, or decoded with encoding base64; anything else as JSON.",
+		`runbox.storage.put('reports/' + id + '.csv', csv, { contentType: 'text/csv' });`},
+	{"runbox.storage", "get", "key: string, options?: { encoding?: \"base64\" }", "{ body: string, contentType: string, size: number, lastModified: string } | null",
+		"Reads the object under key, up to 10 MiB, or returns null when there is none. With encoding base64 the body comes back base64-encoded.",
+		`var file = runbox.storage.get('reports/' + id + '.csv');`},
+	{"runbox.storage", "list", "prefix?: string", "{ key: string, size: number, lastModified: string }[]",
+		"Lists up to 1000 of this function's objects whose keys start with prefix, in order.",
+		`var reports = runbox.storage.list('reports/');`},
+	{"runbox.storage", "signedUrl", "key: string, options?: { method?: \"GET\" | \"PUT\", expiresSeconds?: number }", "string",
+		"Returns a URL that reads key, or uploads to it with method PUT, without credentials, for expiresSeconds (default 900, at most 7 days).",
+		`var url = runbox.storage.signedUrl('uploads/' + id, { method: 'PUT', expiresSeconds: 300 });`},
+	{"runbox.storage", "delete", "key: string", "void",
+		"Deletes the object under key, if there is one.",
+		`runbox.storage.delete('reports/' + id + '.csv');`},
+	{"runbox.secrets", "get", "name: string", "string | null",
+		"Returns the value of the secret name, or null when it isn't set. Pass the name as a literal so the Secrets page can show which functions use it.",
+		`var key = runbox.secrets.get('STRIPE_KEY');`},
+	{"runbox.jwt", "sign", "claims: object, keyName: string, options?: RunboxJWTSignOptions", "string",
+		"Signs claims as a JSON Web Token with the secret keyName: an HMAC key of at least 32 bytes, or a PEM RSA, EC or Ed25519 private key. iat is set unless claims has one.",
+		`var token = runbox.jwt.sign({ sub: user.id, role: 'admin' }, 'JWT_KEY', { expiresIn: 3600 });`},
+	{"runbox.jwt", "verify", "token: string, keyName: string, options?: RunboxJWTVerifyOptions", "{ [claim: string]: any }",
+		"Checks the signature of token with the secret keyName, which may also be a PEM public key or certificate, and its exp, nbf and iat, and returns its claims. Throws when any check fails.",
+		`var claims = runbox.jwt.verify(request.headers['Authorization'].replace('Bearer ', ''), 'JWT_KEY');`},
+	{"runbox.flags", "isEnabled", "name: string, context?: { [attribute: string]: any }", "boolean",
+		"Returns whether the feature flag name is on for context, such as { userId: 42, plan: 'pro' }; key, userId or id keeps a caller in the same percentage bucket. A flag that doesn't exist is off.",
+		`if (runbox.flags.isEnabled('new-checkout', { userId: request.query.user })) {
+  return newCheckout();
+}`},
+}
+
+const commonTypings = `/** The request that invoked the function. */
+interface RunboxRequest {
+  /** The X-Request-ID of the request, or a generated one. */
+  id: string;
+  /** The client IP, taken from forwarding headers only when they come from a trusted proxy. Set for HTTP calls. */
+  ip?: string;
+  /** GET, POST, ...; SCHEDULE, EVENT, MESSAGE or FAILURE for runs that don't come from HTTP. */
+  method: string;
+  path: string;
+  url: string;
+  /** The part of the path below a wildcard mount such as /api/*. */
+  subpath: string;
+  /** The first value of each query parameter. */
+  query: { [name: string]: string };
+  /** The body parsed as JSON, a form or protobuf with the function's schema, or { raw } when it is none of them. */
+  body: any;
+  headers: { [name: string]: string };
+  rawBody: string;
+  /** Set for scheduled runs. */
+  schedule?: { id: number; cron: string; scheduledAt: string; firedAt: string };
+  /** Set for runs triggered by an event. */
+  event?: { topic: string; depth: number; [key: string]: any };
+  /** Where the client IP is, when a GeoIP database is configured and has it. */
+  geo?: RunboxGeo;
+}
+
+declare const request: RunboxRequest;
+
+/** The function's environment variables, global ones overridden by its own. */
+declare const process: { env: { [name: string]: string } };
+
+interface RunboxFetchResult {
+  status?: number;
+  body?: string;
+  error?: string;
+}
+
+interface RunboxDatabase {
+  /** Runs a statement that returns rows, one object per row keyed by column name. Postgres takes $1, $2 placeholders and MySQL ?. */
+  query(sql: string, params?: any[]): { [column: string]: any }[];
+  /** Runs a statement that returns no rows. lastInsertId is only set by MySQL. */
+  exec(sql: string, params?: any[]): { rowsAffected: number; lastInsertId?: number };
+}
+
+interface RunboxCompletionRequest {
+  /** Defaults to ai.model in the config. */
+  model?: string;
+  prompt?: string;
+  messages?: { role: "system" | "user" | "assistant"; content: string }[];
+  system?: string;
+  maxTokens?: number;
+  temperature?: number;
+}
+
+interface RunboxCompletion {
+  text: string;
+  model: string;
+  finishReason: string;
+  usage: { promptTokens: number; completionTokens: number; totalTokens: number };
+}
+
+/** What the GeoIP databases know about an address; fields they lack are left out. */
+interface RunboxGeo {
+  continent?: string;
+  /** The ISO 3166-1 country code, such as "DE". */
+  country?: string;
+  countryName?: string;
+  regionCode?: string;
+  region?: string;
+  city?: string;
+  postalCode?: string;
+  latitude?: number;
+  longitude?: number;
+  timezone?: string;
+  asn?: number;
+  asOrganization?: string;
+}
+
+interface RunboxJWTSignOptions {
+  /** Sets exp this many seconds from now. */
+  expiresIn?: number;
+  /** Defaults to HS256 for HMAC keys, RS256 for RSA, the curve's ES algorithm for EC and EdDSA for Ed25519. */
+  algorithm?: string;
+  /** Sets the kid header. */
+  keyId?: string;
+}
+
+interface RunboxJWTVerifyOptions {
+  issuer?: string;
+  audience?: string;
+  /** Seconds of clock skew allowed on exp, nbf and iat. */
+  leeway?: number;
+}
+
+interface RunboxGRPCCallOptions {
+  /** Seconds; defaults to the target's timeoutSeconds. */
+  timeout?: number;
+  /** Sent with the call after the target's metadata. */
+  metadata?: { [key: string]: string };
+}
+
+interface RunboxPDFOptions {
+  /** A4 (the default), A3, A5, Letter or Legal. */
+  pageSize?: string;
+  landscape?: boolean;
+}
+
+interface RunboxEmailMessage {
+  to: string | string[];
+  cc?: string | string[];
+  bcc?: string | string[];
+  /** Defaults to email.from from the configuration. */
+  from?: string;
+  replyTo?: string;
+  subject: string;
+  html?: string;
+  text?: string;
+}
+
+/** Console output is kept with the execution log and streamed to live tails. */
+declare const console: {
+  log(...values: any[]): void;
+  info(...values: any[]): void;
+  warn(...values: any[]): void;
+  error(...values: any[]): void;
+};
+`
+
+const standardTypings = `interface RouterRequest extends RunboxRequest {
+  /** Route parameters such as :id. */
+  params: { [name: string]: string };
+}
+
+interface RouterResponse {
+  status(code: number): RouterResponse;
+  set(name: string, value: string): RouterResponse;
+  /** Sends a string, or an object as JSON. */
+  send(body: any): RouterResponse;
+  json(body: any): RouterResponse;
+  /** Sends a string as XML, or an object serialized as runbox.stringify.xml does. */
+  xml(body: any): RouterResponse;
+  /** Sends html laid out as a PDF, as runbox.pdf does. */
+  pdf(html: string, options?: RunboxPDFOptions & { filename?: string }): RouterResponse;
+}
+
+type RouteHandler = (req: RouterRequest, res: RouterResponse) => any;
+
+/** Routes request.subpath to handlers; register routes on the global app. */
+declare class Router {
+  get(pattern: string, handler: RouteHandler): Router;
+  post(pattern: string, handler: RouteHandler): Router;
+  put(pattern: string, handler: RouteHandler): Router;
+  patch(pattern: string, handler: RouteHandler): Router;
+  delete(pattern: string, handler: RouteHandler): Router;
+  head(pattern: string, handler: RouteHandler): Router;
+  options(pattern: string, handler: RouteHandler): Router;
+  all(pattern: string, handler: RouteHandler): Router;
+}
+
+declare const app: Router;
+`
+
+const lambdaTypings = `/** An API Gateway REST (payload v1.0) proxy event. */
+interface LambdaEvent {
+  resource: string;
+  path: string;
+  httpMethod: string;
+  headers: { [name: string]: string };
+  multiValueHeaders: { [name: string]: string[] };
+  queryStringParameters: { [name: string]: string };
+  multiValueQueryStringParameters: { [name: string]: string[] };
+  pathParameters: { [name: string]: string };
+  stageVariables: { [name: string]: string };
+  requestContext: { requestId: string; resourcePath: string; path: string; httpMethod: string; stage: string; requestTimeEpoch: number };
+  body: string;
+  isBase64Encoded: boolean;
+}
+
+interface LambdaContext {
+  functionName: string;
+  functionVersion: string;
+  invokedFunctionArn: string;
+  memoryLimitInMB: string;
+  awsRequestId: string;
+  logGroupName: string;
+  logStreamName: string;
+  getRemainingTimeInMillis(): number;
+  done(error?: any, result?: LambdaResult): void;
+  succeed(result: LambdaResult): void;
+  fail(error: any): void;
+}
+
+/** Written to the HTTP response as-is. */
+interface LambdaResult {
+  statusCode: number;
+  headers?: { [name: string]: string };
+  body?: string;
+}
+
+declare const exports: { handler?: (event: LambdaEvent, context: LambdaContext, callback: (error?: any, result?: LambdaResult) => void) => any };
+declare const module: { exports: typeof exports };
+`
+
+const workersTypings = `declare class Headers {
+  constructor(init?: Headers | { [name: string]: string });
+  get(name: string): string | null;
+  set(name: string, value: string): void;
+  append(name: string, value: string): void;
+  has(name: string): boolean;
+  delete(name: string): void;
+  forEach(fn: (value: string, name: string, headers: Headers) => void, thisArg?: any): void;
+}
+
+declare class Request {
+  constructor(input: string | Request, init?: { method?: string; headers?: any; body?: string });
+  /** The X-Request-ID of the request. */
+  id: string;
+  url: string;
+  method: string;
+  headers: Headers;
+  cf: {};
+  /** Body readers are synchronous; the interpreter has no promises. */
+  text(): string;
+  json(): any;
+}
+
+declare class Response {
+  constructor(body?: string | null, init?: { status?: number; statusText?: string; headers?: any });
+  status: number;
+  statusText: string;
+  ok: boolean;
+  headers: Headers;
+  text(): string;
+  json(): any;
+  static json(data: any, init?: { status?: number; headers?: any }): Response;
+  static redirect(url: string, status?: number): Response;
+}
+
+interface FetchEvent {
+  type: "fetch";
+  request: Request;
+  respondWith(response: Response): void;
+  waitUntil(work: any): void;
+  passThroughOnException(): void;
+}
+
+interface ExecutionContext {
+  waitUntil(work: any): void;
+  passThroughOnException(): void;
+}
+
+/** The shape of export default { fetch(request, env, ctx) { ... } }. */
+interface ExportedHandler {
+  fetch(request: Request, env: { [name: string]: string }, ctx: ExecutionContext): Response;
+}
+
+declare function addEventListener(type: "fetch", listener: (event: FetchEvent) => void): void;
+`
+
+const graphqlTypings = `interface GraphQLResolveInfo {
+  fieldName: string;
+  parentType: string;
+  /** The field's type as written in typeDefs, such as [User!]!. */
+  returnType: string;
+  /** Response keys and list indexes from the root to this field. */
+  path: (string | number)[];
+  variableValues: { [name: string]: any };
+}
+
+/** The context passed to every resolver of a request. Resolvers may add to it. */
+interface GraphQLContext {
+  request: RunboxRequest;
+  [key: string]: any;
+}
+
+type GraphQLFieldResolver = (parent: any, args: { [name: string]: any }, context: GraphQLContext, info: GraphQLResolveInfo) => any;
+
+/** The schema, in GraphQL SDL. */
+declare var typeDefs: string;
+/**
+ * Field resolvers by type name. A field without one reads the parent's
+ * property of the same name. Interfaces and unions may define
+ * __resolveType(value, context, info) returning a type name.
+ */
+declare var resolvers: { [typeName: string]: { [fieldName: string]: GraphQLFieldResolver } };
+`
+
+const xmlTypings = `/** A node of the request's XML document. */
+interface RunboxXMLNode {
+  /** document, element, text, cdata, comment or attribute. */
+  type: string;
+  /** The qualified name, such as soap:Body. */
+  name: string;
+  localName: string;
+  namespace: string;
+  /**
+   * Nodes matching an XPath expression relative to this one. namespaces maps
+   * prefixes to URIs; without it prefixes match the document's own.
+   */
+  select(xpath: string, namespaces?: { [prefix: string]: string }): RunboxXMLNode[];
+  selectOne(xpath: string, namespaces?: { [prefix: string]: string }): RunboxXMLNode | null;
+  /** The node's text, or the text of the first node xpath matches. */
+  text(xpath?: string, namespaces?: { [prefix: string]: string }): string | null;
+  /** The value of an XPath expression such as count(//item) or sum(//price). */
+  evaluate(xpath: string, namespaces?: { [prefix: string]: string }): number | string | boolean | RunboxXMLNode[];
+  attr(name: string): string | null;
+  attrs(): { [name: string]: string };
+  /** Child elements. */
+  children(): RunboxXMLNode[];
+  parent(): RunboxXMLNode | null;
+  /** The node serialized as XML. */
+  xml(): string;
+}
+
+interface RunboxRequest {
+  /** The parsed body; absent when the request has none. */
+  xml?: RunboxXMLNode;
+}
+`
+
+// functionTypings is the TypeScript declaration file for code in mode.
+func functionTypings(mode string) string {
+	var b strings.Builder
+	b.WriteString("// RunBox host API for " + mode + " functions. Generated; do not edit.\n\n")
+	b.WriteString(commonTypings)
+	switch mode {
+	case ModeLambda:
+		b.WriteString("\n" + lambdaTypings)
+	case ModeWorkers:
+		b.WriteString("\n" + workersTypings)
+	case ModeGraphQL:
+		b.WriteString("\n" + graphqlTypings)
+	case ModeXML:
+		b.WriteString("\n" + standardTypings + "\n" + xmlTypings)
+	default:
+		b.WriteString("\n" + standardTypings)
+	}
+
+	// Globals first, then runbox itself. Nested namespaces are declared as
+	// objects, since members like kv.delete can't be namespace functions.
+	b.WriteString("\n")
+	for _, f := range hostFunctions {
+		if f.Namespace == "" {
+			fmt.Fprintf(&b, "/** %s */\ndeclare function %s(%s): %s;\n", f.Doc, f.Name, f.Params, f.Returns)
+		}
+	}
+	b.WriteString("\ndeclare namespace runbox {\n")
+	var current string
+	for _, f := range hostFunctions {
+		if f.Namespace == "runbox" {
+			fmt.Fprintf(&b, "  /** %s */\n  function %s(%s): %s;\n", f.Doc, f.Name, f.Params, f.Returns)
+		}
+	}
+	for _, f := range hostFunctions {
+		inner, ok := strings.CutPrefix(f.Namespace, "runbox.")
+		if !ok {
+			continue
+		}
+		if inner != current {
+			if current != "" {
+				b.WriteString("  };\n")
+			}
+			fmt.Fprintf(&b, "  const %s: {\n", inner)
+			current = inner
+		}
+		fmt.Fprintf(&b, "    /** %s */\n    %s(%s): %s;\n", f.Doc, f.Name, f.Params, f.Returns)
+	}
+	if current != "" {
+		b.WriteString("  };\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// typingsHandler serves GET /api/typings?mode=, the declarations the
+// editor loads for completion and hover docs. Point a local editor's
+// jsconfig at the same file to get them there too.
+func (app *App) typingsHandler(c *gin.Context) {
+	mode := c.DefaultQuery("mode", ModeStandard)
+	if !validMode(mode) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid mode " + mode})
+		return
+	}
+	c.Header("Content-Disposition", `inline; filename="runbox-`+mode+`.d.ts"`)
+	c.Data(http.StatusOK, "application/typescript; charset=utf-8", []byte(functionTypings(mode)))
+}
@@ -0,0 +1,443 @@
+package runbox
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	usageRetentionMonths = 13
+	quotaSettingsTTL     = 30 * time.Second
+)
+
+// Usage is what a function consumed over a period: executions, compute
+// time in milliseconds and response bytes served.
+type Usage struct {
+	Requests    int64 `json:"requests"`
+	ComputeMs   int64 `json:"computeMs"`
+	EgressBytes int64 `json:"egressBytes"`
+	AITokens    int64 `json:"aiTokens"`
+}
+
+func (u *Usage) add(o Usage) {
+	u.Requests += o.Requests
+	u.ComputeMs += o.ComputeMs
+	u.EgressBytes += o.EgressBytes
+	u.AITokens += o.AITokens
+}
+
+// UsageQuota limits a function's usage; a zero limit is unlimited. Hourly
+// and monthly periods are calendar periods in UTC. MonthlyAITokens is the
+// budget of runbox.ai, which throws once it is spent rather than the
+// function being refused.
+type UsageQuota struct {
+	FunctionID         int       `json:"functionId"`
+	HourlyRequests     int64     `json:"hourlyRequests"`
+	MonthlyRequests    int64     `json:"monthlyRequests"`
+	MonthlyComputeMs   int64     `json:"monthlyComputeMs"`
+	MonthlyEgressBytes int64     `json:"monthlyEgressBytes"`
+	MonthlyAITokens    int64     `json:"monthlyAiTokens"`
+	CreatedAt          time.Time `json:"createdAt"`
+}
+
+func (app *App) initUsageTables() error {
+	createTables := `
+	CREATE TABLE IF NOT EXISTS function_usage (
+		function_id INTEGER NOT NULL REFERENCES functions(id) ON DELETE CASCADE,
+		hour DATETIME NOT NULL,
+		requests INTEGER NOT NULL DEFAULT 0,
+		compute_ms INTEGER NOT NULL DEFAULT 0,
+		egress_bytes INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (function_id, hour)
+	);
+	CREATE TABLE IF NOT EXISTS usage_quotas (
+		function_id INTEGER PRIMARY KEY REFERENCES functions(id) ON DELETE CASCADE,
+		hourly_requests INTEGER NOT NULL DEFAULT 0,
+		monthly_requests INTEGER NOT NULL DEFAULT 0,
+		monthly_compute_ms INTEGER NOT NULL DEFAULT 0,
+		monthly_egress_bytes INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	if _, err := app.db.Exec(createTables); err != nil {
+		return fmt.Errorf("failed to create usage tables: %v", err)
+	}
+	if err := app.addColumns("function_usage", [][2]string{
+		{"ai_tokens", "INTEGER NOT NULL DEFAULT 0"},
+	}); err != nil {
+		return err
+	}
+	return app.addColumns("usage_quotas", [][2]string{
+		{"monthly_ai_tokens", "INTEGER NOT NULL DEFAULT 0"},
+	})
+}
+
+// recordUsage adds a metrics flush to the hour it happened in.
+func (app *App) recordUsage(functionID int, hour time.Time, s *functionStats) {
+	_, err := app.db.Exec(`INSERT INTO function_usage (function_id, hour, requests, compute_ms, egress_bytes) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (function_id, hour) DO UPDATE SET requests = requests + excluded.requests,
+			compute_ms = compute_ms + excluded.compute_ms, egress_bytes = egress_bytes + excluded.egress_bytes`,
+		functionID, hour, s.Invocations, s.TotalMs, s.EgressBytes)
+	if err != nil {
+		log.Printf("Failed to record usage of function %d: %v", functionID, err)
+	}
+}
+
+// recordAITokens adds tokens spent through runbox.ai to the current hour.
+// They are written at once rather than with the metrics flush, so every
+// instance sees a budget being used up.
+func (app *App) recordAITokens(functionID int, tokens int64) {
+	hour := time.Now().UTC().Truncate(time.Hour)
+	_, err := app.db.Exec(`INSERT INTO function_usage (function_id, hour, ai_tokens) VALUES (?, ?, ?)
+		ON CONFLICT (function_id, hour) DO UPDATE SET ai_tokens = ai_tokens + excluded.ai_tokens`, functionID, hour, tokens)
+	if err != nil {
+		log.Printf("Failed to record AI tokens of function %d: %v", functionID, err)
+	}
+}
+
+func (app *App) pruneUsage(now time.Time) {
+	app.db.Exec(`DELETE FROM function_usage WHERE hour < ?`, now.AddDate(0, -usageRetentionMonths, 0))
+}
+
+func monthStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// usageSince sums a function's stored usage from since, plus what this
+// instance has not flushed yet.
+func (app *App) usageSince(functionID int, since time.Time) (Usage, error) {
+	var u Usage
+	err := app.db.QueryRow(`SELECT COALESCE(SUM(requests), 0), COALESCE(SUM(compute_ms), 0), COALESCE(SUM(egress_bytes), 0), COALESCE(SUM(ai_tokens), 0)
+		FROM function_usage WHERE function_id = ? AND hour >= ?`, functionID, since).Scan(&u.Requests, &u.ComputeMs, &u.EgressBytes, &u.AITokens)
+	if err != nil {
+		return u, err
+	}
+	pending := app.metrics.snapshot(functionID)
+	u.add(Usage{Requests: pending.Invocations, ComputeMs: pending.TotalMs, EgressBytes: pending.EgressBytes})
+	return u, nil
+}
+
+// quotaCache holds every function's quota so functions without one cost no
+// query, reloaded every quotaSettingsTTL.
+type quotaCache struct {
+	mu       sync.Mutex
+	quotas   map[int]*UsageQuota
+	loadedAt time.Time
+}
+
+func newQuotaCache() *quotaCache {
+	return &quotaCache{quotas: map[int]*UsageQuota{}}
+}
+
+func (qc *quotaCache) invalidate() {
+	qc.mu.Lock()
+	qc.loadedAt = time.Time{}
+	qc.mu.Unlock()
+}
+
+func (app *App) quotaFor(functionID int) *UsageQuota {
+	qc := app.quotas
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+
+	if time.Since(qc.loadedAt) > quotaSettingsTTL {
+		if rows, err := app.db.Query(`SELECT ` + usageQuotaColumns + ` FROM usage_quotas`); err == nil {
+			quotas := map[int]*UsageQuota{}
+			for rows.Next() {
+				if q, err := scanUsageQuota(rows); err == nil {
+					quotas[q.FunctionID] = q
+				}
+			}
+			rows.Close()
+			qc.quotas = quotas
+		}
+		qc.loadedAt = time.Now()
+	}
+	return qc.quotas[functionID]
+}
+
+// quotaBreach is a quota a function has used up.
+type quotaBreach struct {
+	quota string
+	limit int64
+	reset time.Time
+}
+
+func (b *quotaBreach) Error() string {
+	return fmt.Sprintf("The %s quota of %d is used up", b.quota, b.limit)
+}
+
+// retryAfter is the Retry-After header value, in seconds.
+func (b *quotaBreach) retryAfter() string {
+	return strconv.Itoa(int(time.Until(b.reset).Seconds()) + 1)
+}
+
+// checkQuota rejects the request with 429 and returns false once the
+// function has used up one of its quotas.
+func (app *App) checkQuota(c *gin.Context, function *Function) bool {
+	breach := app.overQuota(function)
+	if breach == nil {
+		return true
+	}
+	c.Header("Retry-After", breach.retryAfter())
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":   breach.Error(),
+		"resetAt": breach.reset.Format(time.RFC3339),
+	})
+	return false
+}
+
+// overQuota answers the quota the function has used up, or nil. Usage of
+// other instances is seen after their next metrics flush, so limits may be
+// overshot by up to a minute of traffic.
+func (app *App) overQuota(function *Function) *quotaBreach {
+	q := app.quotaFor(function.ID)
+	if q == nil {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	hour, month := now.Truncate(time.Hour), monthStart(now)
+	if q.HourlyRequests > 0 {
+		u, err := app.usageSince(function.ID, hour)
+		if err == nil && u.Requests >= q.HourlyRequests {
+			return &quotaBreach{"hourly request", q.HourlyRequests, hour.Add(time.Hour)}
+		}
+	}
+	if q.MonthlyRequests > 0 || q.MonthlyComputeMs > 0 || q.MonthlyEgressBytes > 0 {
+		u, err := app.usageSince(function.ID, month)
+		if err != nil {
+			return nil
+		}
+		reset := month.AddDate(0, 1, 0)
+		switch {
+		case q.MonthlyRequests > 0 && u.Requests >= q.MonthlyRequests:
+			return &quotaBreach{"monthly request", q.MonthlyRequests, reset}
+		case q.MonthlyComputeMs > 0 && u.ComputeMs >= q.MonthlyComputeMs:
+			return &quotaBreach{"monthly compute", q.MonthlyComputeMs, reset}
+		case q.MonthlyEgressBytes > 0 && u.EgressBytes >= q.MonthlyEgressBytes:
+			return &quotaBreach{"monthly egress", q.MonthlyEgressBytes, reset}
+		}
+	}
+	return nil
+}
+
+const usageQuotaColumns = `function_id, hourly_requests, monthly_requests, monthly_compute_ms, monthly_egress_bytes, monthly_ai_tokens, created_at`
+
+func scanUsageQuota(row rowScanner) (*UsageQuota, error) {
+	var q UsageQuota
+	err := row.Scan(&q.FunctionID, &q.HourlyRequests, &q.MonthlyRequests, &q.MonthlyComputeMs, &q.MonthlyEgressBytes, &q.MonthlyAITokens, &q.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &q, nil
+}
+
+func (app *App) getUsageQuota(functionID int) (*UsageQuota, error) {
+	return scanUsageQuota(app.db.QueryRow(`SELECT `+usageQuotaColumns+` FROM usage_quotas WHERE function_id = ?`, functionID))
+}
+
+// functionUsageHandler serves GET /api/functions/:id/usage: the current
+// hour and month against the quota, and the last months (default 3, at
+// most 13).
+func (app *App) functionUsageHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+	if _, err := app.getFunctionByID(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Function not found"})
+		return
+	}
+	months := 3
+	if v := c.Query("months"); v != "" {
+		if months, err = strconv.Atoi(v); err != nil || months < 1 || months > usageRetentionMonths {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "months must be between 1 and 13"})
+			return
+		}
+	}
+
+	now := time.Now().UTC()
+	hour, month := now.Truncate(time.Hour), monthStart(now)
+	currentHour, err := app.usageSince(id, hour)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load usage"})
+		return
+	}
+	currentMonth, _ := app.usageSince(id, month)
+
+	history := []gin.H{}
+	for i := months - 1; i >= 0; i-- {
+		from := month.AddDate(0, -i, 0)
+		var u Usage
+		if i == 0 {
+			u = currentMonth
+		} else {
+			app.db.QueryRow(`SELECT COALESCE(SUM(requests), 0), COALESCE(SUM(compute_ms), 0), COALESCE(SUM(egress_bytes), 0)
+				FROM function_usage WHERE function_id = ? AND hour >= ? AND hour < ?`, id, from, from.AddDate(0, 1, 0)).
+				Scan(&u.Requests, &u.ComputeMs, &u.EgressBytes)
+		}
+		history = append(history, gin.H{"month": from.Format("2006-01"), "usage": u})
+	}
+
+	response := gin.H{
+		"functionId":   id,
+		"currentHour":  currentHour,
+		"currentMonth": currentMonth,
+		"months":       history,
+	}
+	if q, err := app.getUsageQuota(id); err == nil {
+		response["quota"] = q
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func usageMonth(c *gin.Context) (time.Time, bool) {
+	v := c.Query("month")
+	if v == "" {
+		return monthStart(time.Now().UTC()), true
+	}
+	t, err := time.Parse("2006-01", v)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "month must look like 2024-05"})
+		return t, false
+	}
+	return t, true
+}
+
+// monthlyUsage returns each function's usage of a month, including what
+// this instance has not flushed yet when it is the current month.
+func (app *App) monthlyUsage(functions []Function, month time.Time) (map[int]Usage, error) {
+	rows, err := app.db.Query(`SELECT function_id, SUM(requests), SUM(compute_ms), SUM(egress_bytes), SUM(ai_tokens) FROM function_usage
+		WHERE hour >= ? AND hour < ? GROUP BY function_id`, month, month.AddDate(0, 1, 0))
+	if err != nil {
+		return nil, err
+	}
+	usage := map[int]Usage{}
+	for rows.Next() {
+		var id int
+		var u Usage
+		if rows.Scan(&id, &u.Requests, &u.ComputeMs, &u.EgressBytes, &u.AITokens) == nil {
+			usage[id] = u
+		}
+	}
+	rows.Close()
+
+	if month.Equal(monthStart(time.Now().UTC())) {
+		for _, f := range functions {
+			u := usage[f.ID]
+			pending := app.metrics.snapshot(f.ID)
+			u.add(Usage{Requests: pending.Invocations, ComputeMs: pending.TotalMs, EgressBytes: pending.EgressBytes})
+			usage[f.ID] = u
+		}
+	}
+	return usage, nil
+}
+
+// usageReportHandler serves GET /api/usage?month=YYYY-MM, every function's
+// usage of a month (default the current one).
+func (app *App) usageReportHandler(c *gin.Context) {
+	month, ok := usageMonth(c)
+	if !ok {
+		return
+	}
+
+	functions, err := app.getAllFunctions()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list functions"})
+		return
+	}
+	usage, err := app.monthlyUsage(functions, month)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load usage"})
+		return
+	}
+
+	report := []gin.H{}
+	var total Usage
+	for _, f := range functions {
+		u := usage[f.ID]
+		total.add(u)
+		report = append(report, gin.H{"functionId": f.ID, "name": f.Name, "path": f.Path, "usage": u})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"month": month.Format("2006-01"), "functions": report, "total": total})
+}
+
+func (app *App) getUsageQuotaHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+
+	q, err := app.getUsageQuota(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No quota is set for this function"})
+		return
+	}
+
+	c.JSON(http.StatusOK, q)
+}
+
+func (app *App) setUsageQuota(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+	if _, err := app.getFunctionByID(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Function not found"})
+		return
+	}
+
+	var in UsageQuota
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid quota body"})
+		return
+	}
+	if in.HourlyRequests < 0 || in.MonthlyRequests < 0 || in.MonthlyComputeMs < 0 || in.MonthlyEgressBytes < 0 || in.MonthlyAITokens < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Quotas must not be negative"})
+		return
+	}
+
+	_, err = app.db.Exec(`INSERT INTO usage_quotas (function_id, hourly_requests, monthly_requests, monthly_compute_ms, monthly_egress_bytes, monthly_ai_tokens)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (function_id) DO UPDATE SET hourly_requests = excluded.hourly_requests, monthly_requests = excluded.monthly_requests,
+			monthly_compute_ms = excluded.monthly_compute_ms, monthly_egress_bytes = excluded.monthly_egress_bytes,
+			monthly_ai_tokens = excluded.monthly_ai_tokens`,
+		id, in.HourlyRequests, in.MonthlyRequests, in.MonthlyComputeMs, in.MonthlyEgressBytes, in.MonthlyAITokens)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save quota: " + err.Error()})
+		return
+	}
+	app.quotas.invalidate()
+	app.broadcastChange(changeSettings, id)
+
+	q, _ := app.getUsageQuota(id)
+	c.JSON(http.StatusOK, q)
+}
+
+func (app *App) deleteUsageQuota(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+
+	if _, err := app.db.Exec(`DELETE FROM usage_quotas WHERE function_id = ?`, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove quota"})
+		return
+	}
+	app.quotas.invalidate()
+	app.broadcastChange(changeSettings, id)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Quota removed"})
+}
@@ -0,0 +1,67 @@
+package runbox
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCheckQuota(t *testing.T) {
+	app := newTestServer(t, nil).app
+	now := time.Now().UTC()
+	hour := now.Truncate(time.Hour)
+	lastMonth := monthStart(now).AddDate(0, -1, 0)
+
+	tests := []struct {
+		name  string
+		quota *UsageQuota // nil for none
+		// usage this hour, and usage last month that no quota counts
+		requests, computeMs, egressBytes int64
+		lastMonthRequests                int64
+		want                             bool
+	}{
+		{"no quota", nil, 1000, 1000, 1000, 0, true},
+		{"under hourly requests", &UsageQuota{HourlyRequests: 10}, 9, 0, 0, 0, true},
+		{"at hourly requests", &UsageQuota{HourlyRequests: 10}, 10, 0, 0, 0, false},
+		{"under monthly requests", &UsageQuota{MonthlyRequests: 100}, 99, 0, 0, 0, true},
+		{"at monthly requests", &UsageQuota{MonthlyRequests: 100}, 100, 0, 0, 0, false},
+		{"last month doesn't count", &UsageQuota{MonthlyRequests: 100}, 1, 0, 0, 1000, true},
+		{"over monthly compute", &UsageQuota{MonthlyComputeMs: 500}, 1, 501, 0, 0, false},
+		{"over monthly egress", &UsageQuota{MonthlyEgressBytes: 1 << 20}, 1, 0, 1 << 21, 0, false},
+		{"other quotas unused", &UsageQuota{HourlyRequests: 10, MonthlyComputeMs: 500}, 5, 100, 1 << 30, 0, true},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			function := newTestFunction(t, app, "/quota/"+strconv.Itoa(i), "function GET() { return {}; }")
+			if tt.quota != nil {
+				_, err := app.db.Exec(`INSERT INTO usage_quotas (function_id, hourly_requests, monthly_requests, monthly_compute_ms, monthly_egress_bytes)
+					VALUES (?, ?, ?, ?, ?)`, function.ID, tt.quota.HourlyRequests, tt.quota.MonthlyRequests, tt.quota.MonthlyComputeMs, tt.quota.MonthlyEgressBytes)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			app.recordUsage(function.ID, hour, &functionStats{Invocations: tt.requests, TotalMs: tt.computeMs, EgressBytes: tt.egressBytes})
+			app.recordUsage(function.ID, lastMonth, &functionStats{Invocations: tt.lastMonthRequests})
+			app.quotas.invalidate()
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			if got := app.checkQuota(c, function); got != tt.want {
+				t.Fatalf("checkQuota = %v, want %v", got, tt.want)
+			}
+			if tt.want {
+				return
+			}
+			if w.Code != http.StatusTooManyRequests {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusTooManyRequests)
+			}
+			if retry, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || retry <= 0 {
+				t.Errorf("Retry-After = %q, want seconds until the quota resets", w.Header().Get("Retry-After"))
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package runbox
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/robertkrimen/otto/parser"
+)
+
+// CodeDiagnostic is a syntax error in function code, with a 1-based line
+// and column, as the editor marks it.
+type CodeDiagnostic struct {
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Message string `json:"message"`
+}
+
+func (d CodeDiagnostic) String() string {
+	return fmt.Sprintf("line %d, column %d: %s", d.Line, d.Column, d.Message)
+}
+
+// validateFunctionCode parses code the way it will run in mode, without
+// running it.
+func validateFunctionCode(code, mode string) []CodeDiagnostic {
+	return parseDiagnostics(functionSource(&Function{Code: code, Mode: mode}))
+}
+
+// validateModuleCode parses a module, which runs as written in every mode.
+func validateModuleCode(code string) []CodeDiagnostic {
+	return parseDiagnostics(code)
+}
+
+func parseDiagnostics(source string) []CodeDiagnostic {
+	_, err := parser.ParseFile(nil, "", source, 0)
+	if err == nil {
+		return nil
+	}
+	var list *parser.ErrorList
+	if !errors.As(err, &list) {
+		return []CodeDiagnostic{{Line: 1, Column: 1, Message: err.Error()}}
+	}
+	diagnostics := make([]CodeDiagnostic, 0, list.Len())
+	for _, e := range *list {
+		d := CodeDiagnostic{Line: e.Position.Line, Column: e.Position.Column, Message: e.Message}
+		// The parser repeats an error while it unwinds at the end of input.
+		if n := len(diagnostics); n > 0 && diagnostics[n-1] == d {
+			continue
+		}
+		diagnostics = append(diagnostics, d)
+	}
+	return diagnostics
+}
+
+// validateCodeHandler serves POST /api/functions/validate for the editor,
+// which marks the errors as the code is typed and before saving.
+func (app *App) validateCodeHandler(c *gin.Context) {
+	var in struct {
+		Code string `json:"code"`
+		Mode string `json:"mode"`
+		// Module is set when code is one of the function's modules rather
+		// than its entry point.
+		Module bool `json:"module"`
+	}
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid validation body"})
+		return
+	}
+	if in.Mode == "" {
+		in.Mode = ModeStandard
+	}
+	if !validMode(in.Mode) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid mode " + in.Mode})
+		return
+	}
+
+	diagnostics := validateFunctionCode(in.Code, in.Mode)
+	if in.Module {
+		diagnostics = validateModuleCode(in.Code)
+	}
+	if diagnostics == nil {
+		diagnostics = []CodeDiagnostic{}
+	}
+	c.JSON(http.StatusOK, gin.H{"valid": len(diagnostics) == 0, "errors": diagnostics})
+}
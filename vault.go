@@ -0,0 +1,396 @@
+package runbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultVaultCacheTTL = 60 * time.Second
+	vaultRequestTimeout  = 10 * time.Second
+	vaultRetryInterval   = 30 * time.Second
+)
+
+var (
+	errVaultNotFound = errors.New("not found in Vault")
+	errVaultExists   = errors.New("already exists in Vault")
+)
+
+// vaultError is an error status Vault answered with.
+type vaultError struct {
+	status  int
+	message string
+}
+
+func (e *vaultError) Error() string { return e.message }
+
+// vaultClient reads and writes secrets in Vault's KV version 2 engine and
+// keeps its token alive.
+type vaultClient struct {
+	config VaultConfig
+	client *http.Client
+	stop   chan struct{}
+
+	mu    sync.Mutex
+	token string
+	ttl   time.Duration
+	cache map[string]vaultCachedSecret
+}
+
+type vaultCachedSecret struct {
+	value   string
+	ok      bool
+	expires time.Time
+}
+
+// vaultAuth is the auth block Vault answers logins and token lookups and
+// renewals with.
+type vaultAuth struct {
+	ClientToken   string `json:"client_token"`
+	LeaseDuration int    `json:"lease_duration"`
+	Renewable     bool   `json:"renewable"`
+}
+
+// vaultMetadata is a KV version 2 entry's metadata.
+type vaultMetadata struct {
+	CurrentVersion int       `json:"current_version"`
+	CreatedTime    time.Time `json:"created_time"`
+	UpdatedTime    time.Time `json:"updated_time"`
+}
+
+// openVault logs in to the configured Vault and starts renewing the token,
+// or answers nil when secrets aren't kept in Vault.
+func openVault(config VaultConfig) (*vaultClient, error) {
+	if config.Address == "" {
+		return nil, nil
+	}
+	if config.Mount == "" {
+		config.Mount = "secret"
+	}
+	if config.Path == "" {
+		config.Path = "runbox"
+	}
+	if config.AppRole.Mount == "" {
+		config.AppRole.Mount = "approle"
+	}
+	if config.AppRole.SecretID == "" {
+		config.AppRole.SecretID = os.Getenv("VAULT_SECRET_ID")
+	}
+	if config.Token == "" {
+		config.Token = os.Getenv("VAULT_TOKEN")
+	}
+	config.Address = strings.TrimSuffix(config.Address, "/")
+	config.Mount = strings.Trim(config.Mount, "/")
+	config.Path = strings.Trim(config.Path, "/")
+
+	v := &vaultClient{
+		config: config,
+		client: &http.Client{Timeout: vaultRequestTimeout},
+		stop:   make(chan struct{}),
+		cache:  map[string]vaultCachedSecret{},
+	}
+	if err := v.login(); err != nil {
+		return nil, fmt.Errorf("vault: %v", err)
+	}
+	go v.renewToken()
+	return v, nil
+}
+
+func (v *vaultClient) close() { close(v.stop) }
+
+func (v *vaultClient) cacheTTL() time.Duration {
+	if v.config.CacheSeconds > 0 {
+		return time.Duration(v.config.CacheSeconds) * time.Second
+	}
+	return defaultVaultCacheTTL
+}
+
+// login gets a token with AppRole, or checks the configured token and
+// learns its TTL.
+func (v *vaultClient) login() error {
+	var auth vaultAuth
+	if v.config.AppRole.RoleID != "" {
+		body := map[string]string{"role_id": v.config.AppRole.RoleID, "secret_id": v.config.AppRole.SecretID}
+		if err := v.call(http.MethodPost, "auth/"+v.config.AppRole.Mount+"/login", "", body, &auth, "auth"); err != nil {
+			return fmt.Errorf("AppRole login failed: %v", err)
+		}
+	} else {
+		if v.config.Token == "" {
+			return errors.New("set secrets.vault.token, VAULT_TOKEN or secrets.vault.appRole.roleId")
+		}
+		var self struct {
+			TTL       int  `json:"ttl"`
+			Renewable bool `json:"renewable"`
+		}
+		if err := v.call(http.MethodGet, "auth/token/lookup-self", v.config.Token, nil, &self, "data"); err != nil {
+			return fmt.Errorf("the token was refused: %v", err)
+		}
+		auth = vaultAuth{ClientToken: v.config.Token, LeaseDuration: self.TTL, Renewable: self.Renewable}
+	}
+	v.mu.Lock()
+	v.token = auth.ClientToken
+	v.ttl = time.Duration(auth.LeaseDuration) * time.Second
+	if !auth.Renewable && v.config.AppRole.RoleID == "" {
+		// A token that can't be renewed is used until it expires.
+		v.ttl = 0
+	}
+	v.mu.Unlock()
+	return nil
+}
+
+// renewToken renews the token when two thirds of its TTL have passed, and
+// logs in again with AppRole once it can't be renewed. Tokens without a
+// TTL, such as root tokens, are left alone.
+func (v *vaultClient) renewToken() {
+	for {
+		v.mu.Lock()
+		ttl, token := v.ttl, v.token
+		v.mu.Unlock()
+		if ttl <= 0 {
+			return
+		}
+		select {
+		case <-v.stop:
+			return
+		case <-time.After(ttl * 2 / 3):
+		}
+
+		var auth vaultAuth
+		err := v.call(http.MethodPost, "auth/token/renew-self", token, map[string]string{}, &auth, "auth")
+		if err == nil {
+			renewed := time.Duration(auth.LeaseDuration) * time.Second
+			// Near its max TTL a token renews for less each time; with
+			// AppRole, a fresh login is better than letting it run out.
+			if v.config.AppRole.RoleID == "" || renewed >= ttl/2 {
+				v.mu.Lock()
+				v.ttl = renewed
+				v.mu.Unlock()
+				continue
+			}
+		}
+		if v.config.AppRole.RoleID != "" {
+			err = v.login()
+		}
+		if err != nil {
+			log.Printf("Vault token renewal failed, retrying in %s: %v", vaultRetryInterval, err)
+			v.mu.Lock()
+			v.ttl = vaultRetryInterval * 3 / 2
+			v.mu.Unlock()
+		}
+	}
+}
+
+// call sends a request to the Vault API at path with token and decodes
+// the field of the answer named in field into out. A 404 answers
+// errVaultNotFound.
+func (v *vaultClient) call(method, path, token string, body, out interface{}, field string) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), vaultRequestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, v.config.Address+"/v1/"+path, reader)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if v.config.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.config.Namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	payload, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errVaultNotFound
+	}
+	if resp.StatusCode >= 300 {
+		var failure struct {
+			Errors []string `json:"errors"`
+		}
+		json.Unmarshal(payload, &failure)
+		message := resp.Status
+		if len(failure.Errors) > 0 {
+			message += ": " + strings.Join(failure.Errors, "; ")
+		}
+		return &vaultError{status: resp.StatusCode, message: message}
+	}
+	if out == nil || len(payload) == 0 {
+		return nil
+	}
+	var envelope map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &envelope); err != nil {
+		return fmt.Errorf("invalid response: %v", err)
+	}
+	if raw, ok := envelope[field]; ok {
+		return json.Unmarshal(raw, out)
+	}
+	return nil
+}
+
+// do is call with the current token. A refused AppRole token is replaced
+// by logging in again, once.
+func (v *vaultClient) do(method, path string, body, out interface{}, field string) error {
+	v.mu.Lock()
+	token := v.token
+	v.mu.Unlock()
+	err := v.call(method, path, token, body, out, field)
+	var refused *vaultError
+	if errors.As(err, &refused) && refused.status == http.StatusForbidden && v.config.AppRole.RoleID != "" {
+		if v.login() == nil {
+			v.mu.Lock()
+			token = v.token
+			v.mu.Unlock()
+			err = v.call(method, path, token, body, out, field)
+		}
+	}
+	return err
+}
+
+func (v *vaultClient) dataPath(name string) string {
+	return v.config.Mount + "/data/" + v.config.Path + "/" + name
+}
+
+func (v *vaultClient) metadataPath(name string) string {
+	return v.config.Mount + "/metadata/" + v.config.Path + "/" + name
+}
+
+// value answers a secret's value, or false when it isn't set. Values are
+// cached; if Vault can't be reached, the last value read is used.
+func (v *vaultClient) value(name string) (string, bool, error) {
+	v.mu.Lock()
+	cached, hit := v.cache[name]
+	v.mu.Unlock()
+	if hit && time.Now().Before(cached.expires) {
+		return cached.value, cached.ok, nil
+	}
+
+	var data struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	err := v.do(http.MethodGet, v.dataPath(name), nil, &data, "data")
+	if err != nil && err != errVaultNotFound {
+		if hit {
+			log.Printf("Vault read of %s failed, using the cached value: %v", name, err)
+			return cached.value, cached.ok, nil
+		}
+		return "", false, err
+	}
+	value, ok := data.Data["value"].(string)
+	if err == nil && data.Data != nil && !ok {
+		return "", true, errors.New(`the Vault entry has no string "value" field`)
+	}
+
+	v.mu.Lock()
+	v.cache[name] = vaultCachedSecret{value: value, ok: ok, expires: time.Now().Add(v.cacheTTL())}
+	v.mu.Unlock()
+	return value, ok, nil
+}
+
+// metadata answers a secret's versions and times.
+func (v *vaultClient) metadata(name string) (vaultMetadata, error) {
+	var meta vaultMetadata
+	err := v.do(http.MethodGet, v.metadataPath(name), nil, &meta, "data")
+	return meta, err
+}
+
+func (v *vaultClient) secret(name string, meta vaultMetadata) Secret {
+	return Secret{Name: name, Version: meta.CurrentVersion, CreatedAt: meta.CreatedTime, RotatedAt: meta.UpdatedTime}
+}
+
+// list answers the secrets under the configured path.
+func (v *vaultClient) list() ([]Secret, error) {
+	var keys struct {
+		Keys []string `json:"keys"`
+	}
+	err := v.do(http.MethodGet, v.metadataPath("")+"?list=true", nil, &keys, "data")
+	if err == errVaultNotFound {
+		return []Secret{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	secrets := []Secret{}
+	for _, name := range keys.Keys {
+		if strings.HasSuffix(name, "/") || !envNamePattern.MatchString(name) {
+			continue
+		}
+		meta, err := v.metadata(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		secrets = append(secrets, v.secret(name, meta))
+	}
+	return secrets, nil
+}
+
+// write stores a new version of a secret. create fails with
+// errVaultExists when the secret is set, and a rotation fails with
+// errVaultNotFound when it isn't.
+func (v *vaultClient) write(name, value string, create bool) (Secret, error) {
+	meta, err := v.metadata(name)
+	switch {
+	case err == errVaultNotFound && !create:
+		return Secret{}, err
+	case err == nil && create:
+		return Secret{}, errVaultExists
+	case err != nil && err != errVaultNotFound:
+		return Secret{}, err
+	}
+	body := map[string]interface{}{
+		"data":    map[string]string{"value": value},
+		"options": map[string]int{"cas": meta.CurrentVersion},
+	}
+	var written struct {
+		Version     int       `json:"version"`
+		CreatedTime time.Time `json:"created_time"`
+	}
+	if err := v.do(http.MethodPost, v.dataPath(name), body, &written, "data"); err != nil {
+		return Secret{}, err
+	}
+	v.forget(name)
+	if create {
+		meta.CreatedTime = written.CreatedTime
+	}
+	meta.CurrentVersion, meta.UpdatedTime = written.Version, written.CreatedTime
+	return v.secret(name, meta), nil
+}
+
+// delete removes a secret with all its versions.
+func (v *vaultClient) delete(name string) error {
+	if _, err := v.metadata(name); err != nil {
+		return err
+	}
+	err := v.do(http.MethodDelete, v.metadataPath(name), nil, nil, "")
+	v.forget(name)
+	return err
+}
+
+func (v *vaultClient) forget(name string) {
+	v.mu.Lock()
+	delete(v.cache, name)
+	v.mu.Unlock()
+}
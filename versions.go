@@ -0,0 +1,148 @@
+package runbox
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// versionParams reads the :id and :version route parameters, answering the
+// request itself when either is invalid or the version doesn't exist.
+func (app *App) versionParams(c *gin.Context) (*Function, *FunctionVersion, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return nil, nil, false
+	}
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid version"})
+		return nil, nil, false
+	}
+	function, err := app.getFunctionByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Function not found"})
+		return nil, nil, false
+	}
+	v, err := app.getFunctionVersion(id, version)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+		return nil, nil, false
+	}
+	return function, v, true
+}
+
+func (app *App) listVersionsHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+	function, err := app.getFunctionByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Function not found"})
+		return
+	}
+
+	versions, err := app.getFunctionVersions(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list versions"})
+		return
+	}
+	if versions == nil {
+		versions = []FunctionVersion{}
+	}
+	c.JSON(http.StatusOK, gin.H{"current": function.Version, "versions": versions})
+}
+
+// versionDiffHandler serves GET /api/functions/:id/versions/:version/diff,
+// comparing the version with the one in against, the current version by
+// default.
+func (app *App) versionDiffHandler(c *gin.Context) {
+	function, from, ok := app.versionParams(c)
+	if !ok {
+		return
+	}
+	against := function.Version
+	if q := c.Query("against"); q != "" {
+		var err error
+		if against, err = strconv.Atoi(q); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid against version"})
+			return
+		}
+	}
+	to, err := app.getFunctionVersion(function.ID, against)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Version " + strconv.Itoa(against) + " not found"})
+		return
+	}
+
+	changes := map[string][2]string{}
+	for _, field := range []struct{ name, from, to string }{
+		{"name", from.Name, to.Name},
+		{"path", from.Path, to.Path},
+		{"description", from.Description, to.Description},
+		{"mode", from.Mode, to.Mode},
+	} {
+		if field.from != field.to && field.from != "" {
+			changes[field.name] = [2]string{field.from, field.to}
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":    from.Version,
+		"to":      to.Version,
+		"changes": changes,
+		"files":   moduleDiffs(from, to),
+		"rows":    sideBySide(from.Code, to.Code),
+		"diff": unifiedDiff(from.Path+"@v"+strconv.Itoa(from.Version), to.Path+"@v"+strconv.Itoa(to.Version),
+			from.Code, to.Code),
+	})
+}
+
+// restoreVersion serves POST /api/functions/:id/versions/:version/restore.
+// Restoring saves the old version's contents as a new version, so history
+// only ever grows and the restore itself can be undone.
+func (app *App) restoreVersion(c *gin.Context) {
+	before, v, ok := app.versionParams(c)
+	if !ok {
+		return
+	}
+
+	function := &Function{ID: before.ID, Name: v.Name, Path: v.Path, Code: v.Code, Description: v.Description, Mode: v.Mode, Files: v.Files}
+	if function.Mode == "" {
+		function.Mode = before.Mode
+	}
+	if err := app.saveFunction(function); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore version: " + err.Error()})
+		return
+	}
+
+	app.recordAudit(app.auditOrigin(c), AuditFunctionUpdated, before, function)
+	c.JSON(http.StatusOK, gin.H{"restored": v.Version, "function": function})
+}
+
+// moduleDiffs is a unified diff for each module added, removed or changed
+// between two versions, by module name.
+func moduleDiffs(from, to *FunctionVersion) map[string]string {
+	before, after := map[string]string{}, map[string]string{}
+	for _, f := range from.Files {
+		before[f.Name] = f.Code
+	}
+	for _, f := range to.Files {
+		after[f.Name] = f.Code
+	}
+	diffs := map[string]string{}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			after[name] = ""
+		}
+	}
+	for name, code := range after {
+		if before[name] != code {
+			diffs[name] = unifiedDiff(name+"@v"+strconv.Itoa(from.Version), name+"@v"+strconv.Itoa(to.Version), before[name], code)
+		}
+	}
+	return diffs
+}
@@ -0,0 +1,98 @@
+package runbox
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Function visibilities. Public functions can be called by anyone who can
+// reach the execute routes; private ones need one of the instance's API
+// tokens. A function with no visibility of its own has the instance's
+// default.
+const (
+	VisibilityPublic  = "public"
+	VisibilityPrivate = "private"
+)
+
+func validVisibility(v string) bool {
+	return v == "" || v == VisibilityPublic || v == VisibilityPrivate
+}
+
+// apiTokens are auth.tokens and the comma-separated RUNBOX_API_TOKENS.
+func apiTokens(config AuthConfig) []string {
+	tokens := append([]string{}, config.Tokens...)
+	for _, t := range strings.Split(os.Getenv("RUNBOX_API_TOKENS"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens
+}
+
+func (app *App) initAuth() error {
+	if !validVisibility(app.config.Auth.DefaultVisibility) {
+		return fmt.Errorf("invalid auth.defaultVisibility %q: use public or private", app.config.Auth.DefaultVisibility)
+	}
+	app.apiTokens = apiTokens(app.config.Auth)
+	if app.defaultVisibility() == VisibilityPrivate && len(app.apiTokens) == 0 {
+		log.Println("Functions are private by default but no API tokens are set; set auth.tokens or RUNBOX_API_TOKENS, or create an API key")
+	}
+	return nil
+}
+
+func (app *App) defaultVisibility() string {
+	if app.config.Auth.DefaultVisibility == "" {
+		return VisibilityPublic
+	}
+	return app.config.Auth.DefaultVisibility
+}
+
+// visibilityOf is the visibility function is served with.
+func (app *App) visibilityOf(function *Function) string {
+	if function.Visibility == "" {
+		return app.defaultVisibility()
+	}
+	return function.Visibility
+}
+
+func (app *App) setFunctionVisibility(id int, visibility string) error {
+	_, err := app.db.Exec(`UPDATE functions SET visibility = ? WHERE id = ?`, visibility, id)
+	return err
+}
+
+// checkAccess rejects calls to a private function without a valid
+// Authorization: Bearer token or API key with 401. The header is removed once checked
+// so the token doesn't reach function code or execution logs.
+func (app *App) checkAccess(c *gin.Context, function *Function) bool {
+	if app.visibilityOf(function) != VisibilityPrivate || app.takeBearerToken(c) {
+		return true
+	}
+	c.Header("WWW-Authenticate", `Bearer realm="runbox"`)
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "This function is private; send Authorization: Bearer <token>"})
+	return false
+}
+
+// authenticatedKey marks a request whose bearer token takeBearerToken has
+// accepted.
+const authenticatedKey = "runbox.authenticated"
+
+// takeBearerToken reports whether the request carries a valid token, and
+// removes the header once it has so the token doesn't reach function code
+// or execution logs. Asking again answers the first check.
+func (app *App) takeBearerToken(c *gin.Context) bool {
+	if c.GetBool(authenticatedKey) {
+		return true
+	}
+	token, ok := bearerToken(c)
+	if !ok || !app.validToken(token) {
+		return false
+	}
+	c.Request.Header.Del("Authorization")
+	c.Set(authenticatedKey, true)
+	return true
+}
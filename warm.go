@@ -0,0 +1,322 @@
+package runbox
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/robertkrimen/otto"
+)
+
+// maxCachedScripts bounds the compiled script cache; it is simply emptied
+// when full, and warm functions are recompiled on their next ping.
+const maxCachedScripts = 1024
+
+// scriptCache holds parsed programs keyed by a hash of their source, so
+// preludes and unchanged function code are parsed once rather than on every
+// execution. Compiled scripts are immutable and shared between VMs.
+type scriptCache struct {
+	mu      sync.Mutex
+	scripts map[[32]byte]*otto.Script
+	// functions keys each function's code, so the script of a changed or
+	// deleted function can be dropped rather than wait for eviction.
+	functions map[int][32]byte
+	hits      int64
+	misses    int64
+}
+
+func newScriptCache() *scriptCache {
+	return &scriptCache{scripts: map[[32]byte]*otto.Script{}, functions: map[int][32]byte{}}
+}
+
+func (sc *scriptCache) compile(source string) (*otto.Script, error) {
+	script, _, err := sc.compileCached(source)
+	return script, err
+}
+
+// compileCached is compile that also reports whether the script came from
+// the cache.
+func (sc *scriptCache) compileCached(source string) (*otto.Script, bool, error) {
+	key := sha256.Sum256([]byte(source))
+
+	sc.mu.Lock()
+	script, ok := sc.scripts[key]
+	if ok {
+		sc.hits++
+	} else {
+		sc.misses++
+	}
+	sc.mu.Unlock()
+	if ok {
+		return script, true, nil
+	}
+
+	script, err := otto.New().Compile("", source)
+	if err != nil {
+		return nil, false, err
+	}
+
+	sc.mu.Lock()
+	if len(sc.scripts) >= maxCachedScripts {
+		sc.scripts = map[[32]byte]*otto.Script{}
+		sc.functions = map[int][32]byte{}
+	}
+	sc.scripts[key] = script
+	sc.mu.Unlock()
+
+	return script, false, nil
+}
+
+// compileFunction is compileCached for the code of function id.
+func (sc *scriptCache) compileFunction(id int, source string) (*otto.Script, bool, error) {
+	script, cached, err := sc.compileCached(source)
+	if err == nil && id != 0 {
+		sc.mu.Lock()
+		sc.functions[id] = sha256.Sum256([]byte(source))
+		sc.mu.Unlock()
+	}
+	return script, cached, err
+}
+
+// forget drops the compiled code of function id, so its next execution
+// is cold.
+func (sc *scriptCache) forget(id int) {
+	sc.mu.Lock()
+	if key, ok := sc.functions[id]; ok {
+		delete(sc.scripts, key)
+		delete(sc.functions, id)
+	}
+	sc.mu.Unlock()
+}
+
+func (sc *scriptCache) stats() gin.H {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	hitRate := 0.0
+	if total := sc.hits + sc.misses; total > 0 {
+		hitRate = float64(sc.hits) / float64(total)
+	}
+	return gin.H{
+		"entries":  len(sc.scripts),
+		"capacity": maxCachedScripts,
+		"hits":     sc.hits,
+		"misses":   sc.misses,
+		"hitRate":  hitRate,
+	}
+}
+
+// runCached runs source in vm through the script cache.
+func (app *App) runCached(vm *otto.Otto, source string) (otto.Value, error) {
+	script, err := app.scripts.compile(source)
+	if err != nil {
+		return otto.UndefinedValue(), err
+	}
+	return vm.Run(script)
+}
+
+// functionSource returns the code as it is run for the function's mode.
+func functionSource(function *Function) string {
+	if function.Mode == ModeWorkers {
+		return rewriteExportDefault(function.Code)
+	}
+	return function.Code
+}
+
+// KeepWarm keeps a function ready for latency-sensitive traffic. Every
+// IntervalSeconds its code is compiled into the script cache and, when Path
+// is set, that sub-path is requested with GET as a warm-up call.
+type KeepWarm struct {
+	FunctionID      int        `json:"functionId"`
+	IntervalSeconds int        `json:"intervalSeconds"`
+	Path            string     `json:"path,omitempty"`
+	LastWarmedAt    *time.Time `json:"lastWarmedAt,omitempty"`
+	LastError       string     `json:"lastError,omitempty"`
+}
+
+func (app *App) initKeepWarmTable() error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS keep_warm (
+		function_id INTEGER PRIMARY KEY REFERENCES functions(id) ON DELETE CASCADE,
+		interval_seconds INTEGER NOT NULL,
+		path TEXT NOT NULL DEFAULT '',
+		last_warmed_at DATETIME,
+		last_error TEXT NOT NULL DEFAULT ''
+	);`
+
+	if _, err := app.db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create keep_warm table: %v", err)
+	}
+	return nil
+}
+
+func scanKeepWarm(row rowScanner) (*KeepWarm, error) {
+	var k KeepWarm
+	var lastWarmedAt sql.NullTime
+	if err := row.Scan(&k.FunctionID, &k.IntervalSeconds, &k.Path, &lastWarmedAt, &k.LastError); err != nil {
+		return nil, err
+	}
+	if lastWarmedAt.Valid {
+		k.LastWarmedAt = &lastWarmedAt.Time
+	}
+	return &k, nil
+}
+
+const keepWarmColumns = `function_id, interval_seconds, path, last_warmed_at, last_error`
+
+func (app *App) getKeepWarm(functionID int) (*KeepWarm, error) {
+	return scanKeepWarm(app.db.QueryRow(`SELECT `+keepWarmColumns+` FROM keep_warm WHERE function_id = ?`, functionID))
+}
+
+func (app *App) saveKeepWarm(functionID, intervalSeconds int, path string) error {
+	_, err := app.db.Exec(`INSERT INTO keep_warm (function_id, interval_seconds, path) VALUES (?, ?, ?)
+		ON CONFLICT (function_id) DO UPDATE SET interval_seconds = excluded.interval_seconds, path = excluded.path, last_warmed_at = NULL`,
+		functionID, intervalSeconds, path)
+	return err
+}
+
+func (app *App) removeKeepWarm(functionID int) error {
+	_, err := app.db.Exec(`DELETE FROM keep_warm WHERE function_id = ?`, functionID)
+	return err
+}
+
+// startKeepWarm runs on every instance, since each one has its own cache.
+// Warm-ups run one at a time so they never compete with real traffic for
+// more than a single worker.
+func (app *App) startKeepWarm() {
+	app.warmFunctions(time.Now().UTC(), true)
+
+	app.every(schedulerInterval, func(now time.Time) {
+		app.warmFunctions(now.UTC(), false)
+	})
+}
+
+func (app *App) warmFunctions(now time.Time, all bool) {
+	rows, err := app.db.Query(`SELECT ` + keepWarmColumns + ` FROM keep_warm`)
+	if err != nil {
+		log.Println("Failed to load keep-warm functions:", err)
+		return
+	}
+	var due []KeepWarm
+	for rows.Next() {
+		k, err := scanKeepWarm(rows)
+		if err != nil {
+			continue
+		}
+		interval := time.Duration(k.IntervalSeconds) * time.Second
+		if all || k.LastWarmedAt == nil || !now.Before(k.LastWarmedAt.Add(interval)) {
+			due = append(due, *k)
+		}
+	}
+	rows.Close()
+
+	for _, k := range due {
+		errMsg := ""
+		if err := app.warmFunction(&k); err != nil {
+			errMsg = err.Error()
+			log.Printf("Keep-warm of function %d failed: %v", k.FunctionID, err)
+		}
+		app.db.Exec(`UPDATE keep_warm SET last_warmed_at = ?, last_error = ? WHERE function_id = ?`, now, errMsg, k.FunctionID)
+	}
+}
+
+func (app *App) warmFunction(k *KeepWarm) error {
+	function, err := app.getFunctionByID(k.FunctionID)
+	if err != nil {
+		return err
+	}
+
+	if _, _, err := app.scripts.compileFunction(function.ID, functionSource(function)); err != nil {
+		return err
+	}
+	if k.Path == "" {
+		return nil
+	}
+
+	requestData := syntheticRequest(function, http.MethodGet, nil)
+	requestData["path"] = strings.TrimSuffix(function.Path, "/*") + k.Path
+	requestData["subpath"] = k.Path
+	requestData["headers"] = map[string]string{"X-Runbox-Warmup": "1"}
+
+	ctx, cancel := context.WithTimeout(app.ctx, 30*time.Second)
+	defer cancel()
+	exec := newExecution(ctx, function, requestData)
+	exec.source = "warmup"
+	_, err = app.executeJavaScript(exec)
+	return err
+}
+
+func (app *App) getKeepWarmHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+
+	k, err := app.getKeepWarm(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Keep-warm is not enabled for this function"})
+		return
+	}
+
+	c.JSON(http.StatusOK, k)
+}
+
+func (app *App) setKeepWarm(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+	if _, err := app.getFunctionByID(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Function not found"})
+		return
+	}
+
+	var in struct {
+		IntervalSeconds int    `json:"intervalSeconds"`
+		Path            string `json:"path"`
+	}
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid keep-warm body"})
+		return
+	}
+	if in.IntervalSeconds < 10 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "intervalSeconds must be at least 10"})
+		return
+	}
+	if in.Path != "" && !strings.HasPrefix(in.Path, "/") {
+		in.Path = "/" + in.Path
+	}
+
+	if err := app.saveKeepWarm(id, in.IntervalSeconds, in.Path); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save keep-warm: " + err.Error()})
+		return
+	}
+
+	k, _ := app.getKeepWarm(id)
+	c.JSON(http.StatusOK, k)
+}
+
+func (app *App) deleteKeepWarm(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid function ID"})
+		return
+	}
+
+	if err := app.removeKeepWarm(id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable keep-warm"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Keep-warm disabled"})
+}
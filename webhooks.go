@@ -0,0 +1,444 @@
+package runbox
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	WebhookFunctionCreated  = "function.created"
+	WebhookFunctionUpdated  = "function.updated"
+	WebhookFunctionDeleted  = "function.deleted"
+	WebhookExecutionFailing = "execution.failing"
+	WebhookAlertTriggered   = "alert.triggered"
+	WebhookSLOBreached      = "slo.breached"
+	WebhookTrafficAnomaly   = "traffic.anomaly"
+	WebhookCanaryRolledBack = "canary.rolled_back"
+	WebhookPing             = "ping"
+)
+
+var webhookEvents = []string{WebhookFunctionCreated, WebhookFunctionUpdated, WebhookFunctionDeleted, WebhookExecutionFailing, WebhookAlertTriggered, WebhookSLOBreached, WebhookTrafficAnomaly, WebhookCanaryRolledBack}
+
+const (
+	defaultFailureThreshold = 5
+	webhookAttempts         = 3
+	webhookTimeout          = 10 * time.Second
+)
+
+// Webhook is an outgoing HTTP callback for admin lifecycle events. The
+// secret is write-only: it signs deliveries but is never returned.
+type Webhook struct {
+	ID               int        `json:"id"`
+	URL              string     `json:"url"`
+	Secret           string     `json:"-"`
+	HasSecret        bool       `json:"hasSecret"`
+	Events           []string   `json:"events"`
+	FailureThreshold int        `json:"failureThreshold"`
+	Enabled          bool       `json:"enabled"`
+	LastDeliveryAt   *time.Time `json:"lastDeliveryAt,omitempty"`
+	LastStatus       int        `json:"lastStatus,omitempty"`
+	LastError        string     `json:"lastError,omitempty"`
+	CreatedAt        time.Time  `json:"createdAt"`
+}
+
+func (w *Webhook) wants(event string) bool {
+	if event == WebhookPing {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == "*" || e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// webhookDispatcher delivers webhooks and counts consecutive execution
+// failures per function for the execution.failing event.
+type webhookDispatcher struct {
+	client   *http.Client
+	mu       sync.Mutex
+	failures map[int]int
+}
+
+func newWebhookDispatcher() *webhookDispatcher {
+	return &webhookDispatcher{
+		client:   &http.Client{Timeout: webhookTimeout},
+		failures: map[int]int{},
+	}
+}
+
+func (app *App) initWebhooksTable() error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL DEFAULT '',
+		events TEXT NOT NULL,
+		failure_threshold INTEGER NOT NULL DEFAULT 5,
+		enabled INTEGER NOT NULL DEFAULT 1,
+		last_delivery_at DATETIME,
+		last_status INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	if _, err := app.db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create webhooks table: %v", err)
+	}
+	return nil
+}
+
+const webhookColumns = `id, url, secret, events, failure_threshold, enabled, last_delivery_at, last_status, last_error, created_at`
+
+func scanWebhook(row rowScanner) (*Webhook, error) {
+	var (
+		w              Webhook
+		events         string
+		lastDeliveryAt sql.NullTime
+	)
+	err := row.Scan(&w.ID, &w.URL, &w.Secret, &events, &w.FailureThreshold, &w.Enabled,
+		&lastDeliveryAt, &w.LastStatus, &w.LastError, &w.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	w.HasSecret = w.Secret != ""
+	w.Events = strings.Split(events, ",")
+	if lastDeliveryAt.Valid {
+		w.LastDeliveryAt = &lastDeliveryAt.Time
+	}
+
+	return &w, nil
+}
+
+func (app *App) getWebhook(id int) (*Webhook, error) {
+	return scanWebhook(app.db.QueryRow(`SELECT `+webhookColumns+` FROM webhooks WHERE id = ?`, id))
+}
+
+func (app *App) getWebhooks() ([]Webhook, error) {
+	rows, err := app.db.Query(`SELECT ` + webhookColumns + ` FROM webhooks ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, *w)
+	}
+
+	return webhooks, nil
+}
+
+func functionSummary(function *Function) map[string]interface{} {
+	return map[string]interface{}{
+		"id":      function.ID,
+		"name":    function.Name,
+		"path":    function.Path,
+		"version": function.Version,
+		"mode":    function.Mode,
+	}
+}
+
+// fireWebhook delivers event to every enabled webhook subscribed to it. It
+// returns immediately; deliveries run in the background.
+func (app *App) fireWebhook(event string, data interface{}) {
+	webhooks, err := app.getWebhooks()
+	if err != nil {
+		log.Println("Failed to load webhooks:", err)
+		return
+	}
+
+	for i := range webhooks {
+		w := &webhooks[i]
+		if w.Enabled && w.wants(event) {
+			app.goBackground(func() { app.deliverWebhook(w, event, data) })
+		}
+	}
+}
+
+// signWebhook returns the X-Runbox-Signature-256 header value for body.
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliverWebhook posts one event, retrying failed attempts with a doubling
+// backoff, and records the outcome of the last attempt on the webhook.
+func (app *App) deliverWebhook(w *Webhook, event string, data interface{}) (int, error) {
+	deliveryID := newID()
+	body, err := json.Marshal(map[string]interface{}{
+		"id":        deliveryID,
+		"event":     event,
+		"timestamp": time.Now().UTC().Format(time.RFC3339Nano),
+		"data":      data,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var status int
+	backoff := time.Second
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		status, err = app.postWebhook(w, event, deliveryID, body)
+		if err == nil {
+			break
+		}
+		if attempt < webhookAttempts {
+			if !app.sleep(backoff) {
+				break
+			}
+			backoff *= 2
+		}
+	}
+
+	lastError := ""
+	if err != nil {
+		lastError = err.Error()
+		log.Printf("Webhook %d failed to deliver %s: %v", w.ID, event, err)
+	}
+	app.db.Exec(`UPDATE webhooks SET last_delivery_at = ?, last_status = ?, last_error = ? WHERE id = ?`,
+		time.Now().UTC(), status, lastError, w.ID)
+
+	return status, err
+}
+
+func (app *App) postWebhook(w *Webhook, event, deliveryID string, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "RunBox-Webhook")
+	req.Header.Set("X-Runbox-Event", event)
+	req.Header.Set("X-Runbox-Delivery", deliveryID)
+	if w.Secret != "" {
+		req.Header.Set("X-Runbox-Signature-256", signWebhook(w.Secret, body))
+	}
+
+	resp, err := app.webhooks.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded with %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// recordExecutionOutcome tracks consecutive failures of a function and fires
+// execution.failing to each webhook once its threshold is reached. A success
+// resets the count.
+func (app *App) recordExecutionOutcome(function *Function, execErr error) {
+	if execErr == errExecutionCancelled {
+		return
+	}
+
+	app.webhooks.mu.Lock()
+	if execErr == nil {
+		delete(app.webhooks.failures, function.ID)
+		app.webhooks.mu.Unlock()
+		return
+	}
+	app.webhooks.failures[function.ID]++
+	count := app.webhooks.failures[function.ID]
+	app.webhooks.mu.Unlock()
+
+	webhooks, err := app.getWebhooks()
+	if err != nil {
+		return
+	}
+
+	data := map[string]interface{}{
+		"function":            functionSummary(function),
+		"consecutiveFailures": count,
+		"error":               execErr.Error(),
+	}
+	for i := range webhooks {
+		w := &webhooks[i]
+		if w.Enabled && w.wants(WebhookExecutionFailing) && count == w.FailureThreshold {
+			app.goBackground(func() { app.deliverWebhook(w, WebhookExecutionFailing, data) })
+		}
+	}
+}
+
+type webhookInput struct {
+	URL              string   `json:"url"`
+	Secret           *string  `json:"secret"`
+	Events           []string `json:"events"`
+	FailureThreshold int      `json:"failureThreshold"`
+	Enabled          *bool    `json:"enabled"`
+}
+
+func (in *webhookInput) validate() string {
+	if !strings.HasPrefix(in.URL, "http://") && !strings.HasPrefix(in.URL, "https://") {
+		return "url must be an http(s) URL"
+	}
+	if len(in.Events) == 0 {
+		in.Events = []string{"*"}
+	}
+	for _, e := range in.Events {
+		if e != "*" && !contains(webhookEvents, e) {
+			return "Unknown event " + strconv.Quote(e)
+		}
+	}
+	if in.FailureThreshold < 0 {
+		return "failureThreshold must not be negative"
+	}
+	if in.FailureThreshold == 0 {
+		in.FailureThreshold = defaultFailureThreshold
+	}
+	return ""
+}
+
+// keptSecret is the secret an update stores: the one sent, or current
+// when the body leaves it out.
+func keptSecret(current string, sent *string) string {
+	if sent != nil {
+		return *sent
+	}
+	return current
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (app *App) listWebhooksHandler(c *gin.Context) {
+	webhooks, err := app.getWebhooks()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list webhooks"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"webhooks": webhooks, "events": webhookEvents})
+}
+
+func (app *App) createWebhook(c *gin.Context) {
+	var in webhookInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook body"})
+		return
+	}
+	if msg := in.validate(); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	secret := ""
+	if in.Secret != nil {
+		secret = *in.Secret
+	}
+	enabled := in.Enabled == nil || *in.Enabled
+
+	result, err := app.db.Exec(`INSERT INTO webhooks (url, secret, events, failure_threshold, enabled) VALUES (?, ?, ?, ?, ?)`,
+		in.URL, secret, strings.Join(in.Events, ","), in.FailureThreshold, enabled)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook: " + err.Error()})
+		return
+	}
+
+	id, _ := result.LastInsertId()
+	webhook, _ := app.getWebhook(int(id))
+	c.JSON(http.StatusCreated, webhook)
+}
+
+func (app *App) updateWebhook(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID"})
+		return
+	}
+	existing, err := app.getWebhook(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+
+	var in webhookInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook body"})
+		return
+	}
+	if msg := in.validate(); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	secret := keptSecret(existing.Secret, in.Secret)
+	enabled := in.Enabled == nil || *in.Enabled
+
+	query := `UPDATE webhooks SET url = ?, secret = ?, events = ?, failure_threshold = ?, enabled = ? WHERE id = ?`
+	_, err = app.db.Exec(query, in.URL, secret, strings.Join(in.Events, ","), in.FailureThreshold, enabled, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update webhook: " + err.Error()})
+		return
+	}
+
+	webhook, _ := app.getWebhook(id)
+	c.JSON(http.StatusOK, webhook)
+}
+
+func (app *App) deleteWebhook(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID"})
+		return
+	}
+
+	if _, err := app.db.Exec(`DELETE FROM webhooks WHERE id = ?`, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete webhook"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted successfully"})
+}
+
+// pingWebhook sends a ping event synchronously so the caller sees whether
+// the endpoint is reachable.
+func (app *App) pingWebhook(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID"})
+		return
+	}
+	webhook, err := app.getWebhook(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+
+	status, err := app.postWebhook(webhook, WebhookPing, newID(), []byte(`{"event":"ping"}`))
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Ping failed", "details": err.Error(), "status": status})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": status})
+}
@@ -0,0 +1,162 @@
+package runbox
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/robertkrimen/otto"
+)
+
+// workersPrelude implements the subset of the Fetch API that Workers-style
+// handlers rely on. otto only speaks ES5, so body readers are synchronous.
+const workersPrelude = `
+var __runboxFetchListeners = [];
+var __runboxDefault;
+
+function addEventListener(type, listener) {
+	if (type === 'fetch') __runboxFetchListeners.push(listener);
+}
+
+function Headers(init) {
+	this._h = {};
+	if (!init) return;
+	if (init instanceof Headers) {
+		init.forEach(function (v, k) { this.set(k, v); }, this);
+		return;
+	}
+	for (var k in init) if (init.hasOwnProperty(k)) this.set(k, init[k]);
+}
+Headers.prototype.get = function (k) {
+	k = String(k).toLowerCase();
+	return this._h.hasOwnProperty(k) ? this._h[k] : null;
+};
+Headers.prototype.set = function (k, v) { this._h[String(k).toLowerCase()] = String(v); };
+Headers.prototype.append = function (k, v) {
+	k = String(k).toLowerCase();
+	this._h[k] = this._h.hasOwnProperty(k) ? this._h[k] + ', ' + v : String(v);
+};
+Headers.prototype.has = function (k) { return this._h.hasOwnProperty(String(k).toLowerCase()); };
+Headers.prototype['delete'] = function (k) { delete this._h[String(k).toLowerCase()]; };
+Headers.prototype.forEach = function (fn, thisArg) {
+	for (var k in this._h) if (this._h.hasOwnProperty(k)) fn.call(thisArg, this._h[k], k, this);
+};
+
+function Request(input, init) {
+	init = init || {};
+	var base = typeof input === 'object' && input !== null ? input : {};
+	this.url = String(base.url || input);
+	this.method = String(init.method || base.method || 'GET').toUpperCase();
+	this.headers = new Headers(init.headers || base.headers);
+	this._body = init.body != null ? String(init.body) : (base._body || '');
+	this.cf = {};
+}
+Request.prototype.text = function () { return this._body; };
+Request.prototype.json = function () { return JSON.parse(this._body); };
+
+function Response(body, init) {
+	init = init || {};
+	this.status = init.status || 200;
+	this.statusText = init.statusText || '';
+	this.ok = this.status >= 200 && this.status < 300;
+	this.headers = new Headers(init.headers);
+	this._body = body == null ? '' : String(body);
+}
+Response.prototype.text = function () { return this._body; };
+Response.prototype.json = function () { return JSON.parse(this._body); };
+Response.json = function (data, init) {
+	var r = new Response(JSON.stringify(data), init);
+	if (!r.headers.has('content-type')) r.headers.set('content-type', 'application/json');
+	return r;
+};
+Response.redirect = function (url, status) {
+	return new Response(null, { status: status || 302, headers: { location: url } });
+};
+
+function __runboxMakeRequest(method, url, headers, body) {
+	return new Request(url, { method: method, headers: headers, body: body });
+}
+
+function __runboxDispatchFetch(request) {
+	var response;
+	var event = {
+		type: 'fetch',
+		request: request,
+		respondWith: function (r) { response = r; },
+		waitUntil: function () {},
+		passThroughOnException: function () {}
+	};
+	for (var i = 0; i < __runboxFetchListeners.length && response === undefined; i++) {
+		__runboxFetchListeners[i](event);
+	}
+	return response;
+}
+
+function __runboxToResponse(r) {
+	if (!(r instanceof Response)) throw new TypeError('fetch handler must return a Response');
+	return { status: r.status, headers: r.headers._h, body: r._body };
+}
+`
+
+var exportDefaultPattern = regexp.MustCompile(`(?m)^(\s*)export\s+default\s+`)
+
+// rewriteExportDefault turns the ES module "export default { fetch }" form
+// into an assignment otto can parse.
+func rewriteExportDefault(code string) string {
+	return exportDefaultPattern.ReplaceAllString(code, "${1}__runboxDefault = ")
+}
+
+func invokeFetchHandler(vm *otto.Otto, exec *execution) (interface{}, error) {
+	requestData := exec.request
+	body, _ := requestData["rawBody"].(string)
+
+	makeRequest, _ := vm.Get("__runboxMakeRequest")
+	request, err := makeRequest.Call(otto.UndefinedValue(),
+		fmt.Sprint(requestData["method"]), fmt.Sprint(requestData["url"]), stringMap(requestData["headers"]), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build Request: %v", err)
+	}
+	request.Object().Set("id", exec.requestID)
+
+	var response otto.Value
+	if def, _ := vm.Get("__runboxDefault"); def.IsObject() {
+		fetch, _ := def.Object().Get("fetch")
+		if !fetch.IsFunction() {
+			return nil, fmt.Errorf("default export has no fetch(request, env, ctx) method")
+		}
+		env, _ := vm.ToValue(exec.env)
+		ctx, _ := vm.Object(`({ waitUntil: function () {}, passThroughOnException: function () {} })`)
+		response, err = fetch.Call(def, request, env, ctx)
+	} else {
+		dispatch, _ := vm.Get("__runboxDispatchFetch")
+		response, err = dispatch.Call(otto.UndefinedValue(), request)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error calling fetch handler: %w", err)
+	}
+	if !response.IsDefined() {
+		return nil, fmt.Errorf("no fetch handler responded; use addEventListener('fetch', ...) or export default { fetch }")
+	}
+
+	toResponse, _ := vm.Get("__runboxToResponse")
+	plain, err := toResponse.Call(otto.UndefinedValue(), response)
+	if err != nil {
+		return nil, err
+	}
+	exported, err := exec.export(plain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export response: %v", err)
+	}
+
+	obj := exported.(map[string]interface{})
+	status, err := strconv.Atoi(fmt.Sprint(obj["status"]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid response status %v", obj["status"])
+	}
+
+	return &HTTPResponse{
+		Status:  status,
+		Headers: stringMap(obj["headers"]),
+		Body:    fmt.Sprint(obj["body"]),
+	}, nil
+}
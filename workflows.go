@@ -0,0 +1,785 @@
+package runbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	StepOnErrorFail     = "fail"
+	StepOnErrorContinue = "continue"
+)
+
+const (
+	RunPending   = "pending"
+	RunRunning   = "running"
+	RunSucceeded = "succeeded"
+	RunFailed    = "failed"
+	RunSkipped   = "skipped"
+	RunCancelled = "cancelled"
+)
+
+// WorkflowStep calls the function serving Path. It runs once every step in
+// DependsOn has finished, receiving the single dependency's output as its
+// body, or an object keyed by step name when it has several.
+type WorkflowStep struct {
+	Name      string   `json:"name"`
+	Path      string   `json:"path"`
+	DependsOn []string `json:"dependsOn"`
+	Retries   int      `json:"retries"`
+	OnError   string   `json:"onError"`
+}
+
+type Workflow struct {
+	ID          int            `json:"id"`
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Steps       []WorkflowStep `json:"steps"`
+	CreatedAt   time.Time      `json:"createdAt"`
+	UpdatedAt   time.Time      `json:"updatedAt"`
+}
+
+type StepRun struct {
+	Name       string          `json:"name"`
+	Status     string          `json:"status"`
+	Attempts   int             `json:"attempts"`
+	Output     json.RawMessage `json:"output,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	Logs       []string        `json:"logs"`
+	StartedAt  *time.Time      `json:"startedAt,omitempty"`
+	FinishedAt *time.Time      `json:"finishedAt,omitempty"`
+}
+
+type WorkflowRun struct {
+	ID         string          `json:"id"`
+	WorkflowID int             `json:"workflowId"`
+	Status     string          `json:"status"`
+	Input      json.RawMessage `json:"input,omitempty"`
+	Output     json.RawMessage `json:"output,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	Steps      []StepRun       `json:"steps"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	FinishedAt *time.Time      `json:"finishedAt,omitempty"`
+}
+
+func (app *App) initWorkflowsTables() error {
+	createTables := `
+	CREATE TABLE IF NOT EXISTS workflows (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE,
+		description TEXT NOT NULL DEFAULT '',
+		steps TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS workflow_runs (
+		id TEXT PRIMARY KEY,
+		workflow_id INTEGER NOT NULL REFERENCES workflows(id) ON DELETE CASCADE,
+		status TEXT NOT NULL,
+		input TEXT,
+		output TEXT,
+		error TEXT NOT NULL DEFAULT '',
+		steps TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		finished_at DATETIME
+	);
+	CREATE INDEX IF NOT EXISTS idx_workflow_runs_workflow ON workflow_runs (workflow_id, created_at);`
+
+	if _, err := app.db.Exec(createTables); err != nil {
+		return fmt.Errorf("failed to create workflow tables: %v", err)
+	}
+
+	// Runs execute in memory on the instance that started them; see
+	// recoverOrphanedWork.
+	return app.addColumns("workflow_runs", [][2]string{
+		{"worker", "TEXT"},
+		{"cancel_requested", "INTEGER NOT NULL DEFAULT 0"},
+	})
+}
+
+// normalizeSteps validates the steps and fills in their defaults. A step
+// without dependsOn follows the step before it, so a plain list is a linear
+// pipeline; an explicit empty list makes the step a root.
+func normalizeSteps(steps []WorkflowStep) error {
+	if len(steps) == 0 {
+		return errors.New("a workflow needs at least one step")
+	}
+
+	seen := map[string]bool{}
+	for i := range steps {
+		step := &steps[i]
+		if step.Name == "" {
+			return fmt.Errorf("step %d has no name", i+1)
+		}
+		if seen[step.Name] {
+			return fmt.Errorf("duplicate step name %q", step.Name)
+		}
+		seen[step.Name] = true
+
+		if step.Path == "" {
+			return fmt.Errorf("step %q has no path", step.Name)
+		}
+		if !strings.HasPrefix(step.Path, "/") {
+			step.Path = "/" + step.Path
+		}
+		if step.Retries < 0 {
+			return fmt.Errorf("step %q has negative retries", step.Name)
+		}
+		switch step.OnError {
+		case "":
+			step.OnError = StepOnErrorFail
+		case StepOnErrorFail, StepOnErrorContinue:
+		default:
+			return fmt.Errorf("step %q: onError must be %q or %q", step.Name, StepOnErrorFail, StepOnErrorContinue)
+		}
+
+		if step.DependsOn == nil {
+			step.DependsOn = []string{}
+			if i > 0 {
+				step.DependsOn = []string{steps[i-1].Name}
+			}
+		}
+	}
+
+	for _, step := range steps {
+		for _, dep := range step.DependsOn {
+			if !seen[dep] {
+				return fmt.Errorf("step %q depends on unknown step %q", step.Name, dep)
+			}
+		}
+	}
+
+	if _, err := stepOrder(steps); err != nil {
+		return err
+	}
+	return nil
+}
+
+// stepOrder groups the steps into waves; every step in a wave only depends
+// on steps from earlier waves.
+func stepOrder(steps []WorkflowStep) ([][]int, error) {
+	done := map[string]bool{}
+	remaining := len(steps)
+	var waves [][]int
+
+	for remaining > 0 {
+		var wave []int
+		for i, step := range steps {
+			if done[step.Name] {
+				continue
+			}
+			ready := true
+			for _, dep := range step.DependsOn {
+				if !done[dep] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				wave = append(wave, i)
+			}
+		}
+		if len(wave) == 0 {
+			return nil, errors.New("steps have a dependency cycle")
+		}
+		for _, i := range wave {
+			done[steps[i].Name] = true
+		}
+		remaining -= len(wave)
+		waves = append(waves, wave)
+	}
+
+	return waves, nil
+}
+
+const workflowColumns = `id, name, description, steps, created_at, updated_at`
+
+func scanWorkflow(row rowScanner) (*Workflow, error) {
+	var w Workflow
+	var steps string
+	if err := row.Scan(&w.ID, &w.Name, &w.Description, &steps, &w.CreatedAt, &w.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(steps), &w.Steps); err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+func (app *App) getWorkflow(id int) (*Workflow, error) {
+	return scanWorkflow(app.db.QueryRow(`SELECT `+workflowColumns+` FROM workflows WHERE id = ?`, id))
+}
+
+func (app *App) getWorkflows() ([]Workflow, error) {
+	rows, err := app.db.Query(`SELECT ` + workflowColumns + ` FROM workflows ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	workflows := []Workflow{}
+	for rows.Next() {
+		w, err := scanWorkflow(rows)
+		if err != nil {
+			return nil, err
+		}
+		workflows = append(workflows, *w)
+	}
+
+	return workflows, nil
+}
+
+const workflowRunColumns = `id, workflow_id, status, input, output, error, steps, created_at, finished_at`
+
+func scanWorkflowRun(row rowScanner) (*WorkflowRun, error) {
+	var (
+		r             WorkflowRun
+		input, output sql.NullString
+		steps         string
+		finishedAt    sql.NullTime
+	)
+	err := row.Scan(&r.ID, &r.WorkflowID, &r.Status, &input, &output, &r.Error, &steps, &r.CreatedAt, &finishedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if input.Valid && input.String != "" {
+		r.Input = json.RawMessage(input.String)
+	}
+	if output.Valid && output.String != "" {
+		r.Output = json.RawMessage(output.String)
+	}
+	if err := json.Unmarshal([]byte(steps), &r.Steps); err != nil {
+		return nil, err
+	}
+	if finishedAt.Valid {
+		r.FinishedAt = &finishedAt.Time
+	}
+
+	return &r, nil
+}
+
+func (app *App) getWorkflowRun(id string) (*WorkflowRun, error) {
+	return scanWorkflowRun(app.db.QueryRow(`SELECT `+workflowRunColumns+` FROM workflow_runs WHERE id = ?`, id))
+}
+
+func (app *App) listWorkflowRuns(workflowID, limit int) ([]WorkflowRun, error) {
+	rows, err := app.db.Query(`SELECT `+workflowRunColumns+` FROM workflow_runs WHERE workflow_id = ? ORDER BY created_at DESC LIMIT ?`,
+		workflowID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []WorkflowRun{}
+	for rows.Next() {
+		r, err := scanWorkflowRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, *r)
+	}
+
+	return runs, nil
+}
+
+func (app *App) saveWorkflowRun(run *WorkflowRun) {
+	steps, _ := json.Marshal(run.Steps)
+	var output sql.NullString
+	if len(run.Output) > 0 {
+		output = sql.NullString{String: string(run.Output), Valid: true}
+	}
+
+	query := `UPDATE workflow_runs SET status = ?, output = ?, error = ?, steps = ?, finished_at = ? WHERE id = ?`
+	if _, err := app.db.Exec(query, run.Status, output, run.Error, string(steps), run.FinishedAt, run.ID); err != nil {
+		log.Printf("Failed to save workflow run %s: %v", run.ID, err)
+	}
+}
+
+// startWorkflowRun records a new run and executes it in the background.
+// The returned channel is closed once the run has finished.
+func (app *App) startWorkflowRun(w *Workflow, input json.RawMessage) (*WorkflowRun, <-chan struct{}, error) {
+	run := &WorkflowRun{
+		ID:         newID(),
+		WorkflowID: w.ID,
+		Status:     RunRunning,
+		Input:      input,
+		CreatedAt:  time.Now().UTC(),
+	}
+	for _, step := range w.Steps {
+		run.Steps = append(run.Steps, StepRun{Name: step.Name, Status: RunPending, Logs: []string{}})
+	}
+
+	steps, _ := json.Marshal(run.Steps)
+	_, err := app.db.Exec(`INSERT INTO workflow_runs (id, workflow_id, status, input, steps, worker, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		run.ID, run.WorkflowID, run.Status, string(input), string(steps), app.leader.instanceID, run.CreatedAt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithCancel(app.ctx)
+	app.runningWorkflows.Store(run.ID, cancel)
+	if app.leader.clustered {
+		app.goBackground(func() { app.watchWorkflowCancel(ctx, run.ID, cancel) })
+	}
+
+	done := make(chan struct{})
+	app.goBackground(func() {
+		defer close(done)
+		defer func() {
+			app.runningWorkflows.Delete(run.ID)
+			cancel()
+		}()
+		app.executeWorkflowRun(ctx, w, run)
+	})
+
+	return run, done, nil
+}
+
+// watchWorkflowCancel stops a run once another instance has asked for it to
+// be cancelled through the workflow_runs table.
+func (app *App) watchWorkflowCancel(ctx context.Context, id string, cancel context.CancelFunc) {
+	ticker := time.NewTicker(jobCancelPoll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		var requested bool
+		err := app.db.QueryRow(`SELECT cancel_requested FROM workflow_runs WHERE id = ?`, id).Scan(&requested)
+		if err == nil && requested {
+			cancel()
+			return
+		}
+	}
+}
+
+// cancelWorkflowRun stops a running run: the step in flight is interrupted
+// and the steps after it are skipped. A run on another instance is flagged
+// in the table for its instance to stop. It reports false when the run has
+// already finished.
+func (app *App) cancelWorkflowRun(id string) (bool, error) {
+	result, err := app.db.Exec(`UPDATE workflow_runs SET cancel_requested = 1 WHERE id = ? AND status IN (?, ?)`,
+		id, RunPending, RunRunning)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return false, nil
+	}
+
+	if cancel, ok := app.runningWorkflows.Load(id); ok {
+		cancel.(context.CancelFunc)()
+	}
+	return true, nil
+}
+
+func (app *App) executeWorkflowRun(ctx context.Context, w *Workflow, run *WorkflowRun) {
+	waves, err := stepOrder(w.Steps)
+	if err != nil {
+		app.finishWorkflowRun(run, RunFailed, err.Error())
+		return
+	}
+
+	var mu sync.Mutex
+	outputs := map[string]json.RawMessage{}
+
+	for _, wave := range waves {
+		var wg sync.WaitGroup
+		for _, i := range wave {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+
+				step := w.Steps[i]
+				mu.Lock()
+				input := stepInput(step, run.Input, outputs)
+				mu.Unlock()
+
+				result := app.executeStep(ctx, w, run, step, input)
+
+				mu.Lock()
+				run.Steps[i] = result
+				if result.Status == RunSucceeded {
+					outputs[step.Name] = result.Output
+				} else {
+					// Downstream steps of a tolerated failure see its error.
+					outputs[step.Name], _ = json.Marshal(map[string]string{"error": result.Error})
+				}
+				app.saveWorkflowRun(run)
+				mu.Unlock()
+			}(i)
+		}
+		wg.Wait()
+
+		if ctx.Err() != nil {
+			skipPendingSteps(run)
+			if app.stopping(ctx) {
+				app.finishWorkflowRun(run, RunFailed, "interrupted: its instance stopped")
+			} else {
+				app.finishWorkflowRun(run, RunCancelled, errExecutionCancelled.Error())
+			}
+			return
+		}
+		for _, i := range wave {
+			if run.Steps[i].Status == RunFailed && w.Steps[i].OnError == StepOnErrorFail {
+				skipPendingSteps(run)
+				app.finishWorkflowRun(run, RunFailed, fmt.Sprintf("step %q failed: %s", w.Steps[i].Name, run.Steps[i].Error))
+				return
+			}
+		}
+	}
+
+	// The run's output is that of the last step.
+	last := w.Steps[len(w.Steps)-1].Name
+	run.Output = outputs[last]
+	app.finishWorkflowRun(run, RunSucceeded, "")
+}
+
+func skipPendingSteps(run *WorkflowRun) {
+	for i := range run.Steps {
+		if run.Steps[i].Status == RunPending {
+			run.Steps[i].Status = RunSkipped
+		}
+	}
+}
+
+func stepInput(step WorkflowStep, runInput json.RawMessage, outputs map[string]json.RawMessage) json.RawMessage {
+	switch len(step.DependsOn) {
+	case 0:
+		return runInput
+	case 1:
+		return outputs[step.DependsOn[0]]
+	}
+
+	combined := map[string]json.RawMessage{}
+	for _, dep := range step.DependsOn {
+		combined[dep] = outputs[dep]
+	}
+	b, _ := json.Marshal(combined)
+	return b
+}
+
+// executeStep runs one step, retrying up to step.Retries times with the
+// queue's backoff. Steps are called as POST with request.workflow set, and
+// each attempt is bounded by the job timeout.
+func (app *App) executeStep(ctx context.Context, w *Workflow, run *WorkflowRun, step WorkflowStep, input json.RawMessage) StepRun {
+	started := time.Now().UTC()
+	result := StepRun{Name: step.Name, Status: RunRunning, Logs: []string{}, StartedAt: &started}
+
+	for attempt := 1; attempt <= step.Retries+1; attempt++ {
+		result.Attempts = attempt
+
+		output, logs, err := app.callStep(ctx, w, run, step, input)
+		result.Logs = logs
+		if err == nil {
+			result.Status, result.Output, result.Error = RunSucceeded, output, ""
+			break
+		}
+
+		result.Status, result.Error = RunFailed, err.Error()
+		if ctx.Err() != nil {
+			result.Status = RunCancelled
+			break
+		}
+		if attempt <= step.Retries {
+			timer := time.NewTimer(app.config.Queue.backoff(attempt))
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+			}
+		}
+	}
+
+	finished := time.Now().UTC()
+	result.FinishedAt = &finished
+	return result
+}
+
+func (app *App) callStep(ctx context.Context, w *Workflow, run *WorkflowRun, step WorkflowStep, input json.RawMessage) (json.RawMessage, []string, error) {
+	timeout := app.live().jobTimeout()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	function, subpath, err := app.resolveFunction(ctx, step.Path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("no function serves %s", step.Path)
+	}
+
+	requestData := syntheticRequest(function, http.MethodPost, input)
+	requestData["path"] = step.Path
+	requestData["subpath"] = subpath
+	requestData["workflow"] = map[string]interface{}{
+		"id":    w.ID,
+		"name":  w.Name,
+		"runId": run.ID,
+		"step":  step.Name,
+	}
+
+	exec := newExecution(ctx, function, requestData)
+	exec.source = "workflow"
+	value, err := app.executeJavaScript(exec)
+	if errors.Is(err, errExecutionCancelled) && ctx.Err() == context.DeadlineExceeded {
+		return nil, exec.logs, fmt.Errorf("step timed out after %s", timeout)
+	}
+	if err != nil {
+		return nil, exec.logs, err
+	}
+
+	// Raw responses pass their body on, parsed when it is JSON.
+	if resp, ok := value.(*HTTPResponse); ok {
+		if json.Valid([]byte(resp.Body)) {
+			return json.RawMessage(resp.Body), exec.logs, nil
+		}
+		value = resp.Body
+	}
+
+	output, err := json.Marshal(value)
+	if err != nil {
+		return nil, exec.logs, fmt.Errorf("failed to serialize step output: %v", err)
+	}
+	return output, exec.logs, nil
+}
+
+func (app *App) finishWorkflowRun(run *WorkflowRun, status, errMsg string) {
+	finished := time.Now().UTC()
+	run.Status, run.Error, run.FinishedAt = status, errMsg, &finished
+	app.saveWorkflowRun(run)
+}
+
+type workflowInput struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Steps       []WorkflowStep `json:"steps"`
+}
+
+func (in *workflowInput) validate() string {
+	if in.Name == "" {
+		return "name is required"
+	}
+	if err := normalizeSteps(in.Steps); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+func (app *App) listWorkflowsHandler(c *gin.Context) {
+	workflows, err := app.getWorkflows()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list workflows"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"workflows": workflows})
+}
+
+func (app *App) getWorkflowHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid workflow ID"})
+		return
+	}
+
+	w, err := app.getWorkflow(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workflow not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, w)
+}
+
+func (app *App) createWorkflow(c *gin.Context) {
+	var in workflowInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid workflow body"})
+		return
+	}
+	if msg := in.validate(); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	steps, _ := json.Marshal(in.Steps)
+	result, err := app.db.Exec(`INSERT INTO workflows (name, description, steps) VALUES (?, ?, ?)`,
+		in.Name, in.Description, string(steps))
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Failed to create workflow: " + err.Error()})
+		return
+	}
+
+	id, _ := result.LastInsertId()
+	w, _ := app.getWorkflow(int(id))
+	c.JSON(http.StatusCreated, w)
+}
+
+func (app *App) updateWorkflow(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid workflow ID"})
+		return
+	}
+	if _, err := app.getWorkflow(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workflow not found"})
+		return
+	}
+
+	var in workflowInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid workflow body"})
+		return
+	}
+	if msg := in.validate(); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	steps, _ := json.Marshal(in.Steps)
+	_, err = app.db.Exec(`UPDATE workflows SET name = ?, description = ?, steps = ?, updated_at = ? WHERE id = ?`,
+		in.Name, in.Description, string(steps), time.Now().UTC(), id)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Failed to update workflow: " + err.Error()})
+		return
+	}
+
+	w, _ := app.getWorkflow(id)
+	c.JSON(http.StatusOK, w)
+}
+
+func (app *App) deleteWorkflow(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid workflow ID"})
+		return
+	}
+
+	if _, err := app.db.Exec(`DELETE FROM workflows WHERE id = ?`, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete workflow"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Workflow deleted successfully"})
+}
+
+// runWorkflowHandler starts a run with the request body as its input. With
+// ?wait=true it responds once the run has finished.
+func (app *App) runWorkflowHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid workflow ID"})
+		return
+	}
+	w, err := app.getWorkflow(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workflow not found"})
+		return
+	}
+
+	input, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return
+	}
+	if len(input) > 0 && !json.Valid(input) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Input must be valid JSON"})
+		return
+	}
+
+	run, done, err := app.startWorkflowRun(w, input)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start workflow run: " + err.Error()})
+		return
+	}
+
+	if c.Query("wait") != "true" {
+		c.JSON(http.StatusAccepted, gin.H{"runId": run.ID, "status": run.Status})
+		return
+	}
+
+	select {
+	case <-done:
+	case <-c.Request.Context().Done():
+		return
+	}
+	run, _ = app.getWorkflowRun(run.ID)
+	c.JSON(http.StatusOK, run)
+}
+
+func (app *App) listWorkflowRunsHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid workflow ID"})
+		return
+	}
+
+	runs, err := app.listWorkflowRuns(id, 50)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list workflow runs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"runs": runs})
+}
+
+func (app *App) getWorkflowRunHandler(c *gin.Context) {
+	run, err := app.getWorkflowRun(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workflow run not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, run)
+}
+
+func (app *App) cancelWorkflowRunHandler(c *gin.Context) {
+	id := c.Param("id")
+	if _, err := app.getWorkflowRun(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workflow run not found"})
+		return
+	}
+
+	cancelled, err := app.cancelWorkflowRun(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel workflow run"})
+		return
+	}
+	if !cancelled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Workflow run has already finished"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Workflow run cancelled"})
+}
+
+func (app *App) workflowsPage(c *gin.Context) {
+	workflows, err := app.getWorkflows()
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": err.Error()})
+		return
+	}
+
+	runs := map[int][]WorkflowRun{}
+	for _, w := range workflows {
+		runs[w.ID], _ = app.listWorkflowRuns(w.ID, 10)
+	}
+
+	c.HTML(http.StatusOK, "workflows.html", gin.H{
+		"title":     "Workflows",
+		"workflows": workflows,
+		"runs":      runs,
+	})
+}
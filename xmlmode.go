@@ -0,0 +1,245 @@
+package runbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/antchfx/xmlquery"
+	"github.com/antchfx/xpath"
+	"github.com/robertkrimen/otto"
+)
+
+// xmlNode is a node of request.xml as JavaScript sees it: its names and
+// the methods that navigate and query it. It marshals as its scalar
+// fields, so a request that holds one can still be logged.
+type xmlNode map[string]interface{}
+
+func (n xmlNode) MarshalJSON() ([]byte, error) {
+	fields := map[string]interface{}{}
+	for key, value := range n {
+		if _, ok := value.(func(otto.FunctionCall) otto.Value); !ok {
+			fields[key] = value
+		}
+	}
+	return json.Marshal(fields)
+}
+
+var xmlNodeTypes = map[xmlquery.NodeType]string{
+	xmlquery.DocumentNode:          "document",
+	xmlquery.DeclarationNode:       "declaration",
+	xmlquery.ElementNode:           "element",
+	xmlquery.TextNode:              "text",
+	xmlquery.CharDataNode:          "cdata",
+	xmlquery.CommentNode:           "comment",
+	xmlquery.AttributeNode:         "attribute",
+	xmlquery.NotationNode:          "notation",
+	xmlquery.ProcessingInstruction: "processingInstruction",
+}
+
+// attachXML parses the body of a request to an xml function into
+// request.xml, the navigable document, and request.body, the plain object
+// runbox.parse.xml answers. A request without a body gets neither.
+func attachXML(requestData map[string]interface{}) error {
+	raw, _ := requestData["rawBody"].(string)
+	if strings.TrimSpace(raw) == "" {
+		return nil
+	}
+	if len(raw) > maxParseInputBytes {
+		return fmt.Errorf("the body is larger than %d MiB", maxParseInputBytes>>20)
+	}
+	doc, err := xmlquery.Parse(strings.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	if body, err := parseXML(raw); err == nil {
+		requestData["body"] = body
+	}
+	requestData["xml"] = newXMLNode(doc)
+	return nil
+}
+
+// compileXPath compiles expr with the namespace prefixes in the optional
+// namespaces argument, an object of prefix to URI. Without it, prefixes
+// match the ones the document uses.
+func compileXPath(call otto.FunctionCall, prefix string) *xpath.Expr {
+	source := call.Argument(0).String()
+	var namespaces map[string]string
+	if arg := call.Argument(1); arg.IsObject() {
+		exported, _ := arg.Export()
+		namespaces = stringMap(exported)
+	}
+	expr, err := xpath.CompileWithNS(source, namespaces)
+	if err != nil {
+		throwError(call, prefix+"invalid XPath "+source+": "+err.Error())
+	}
+	return expr
+}
+
+func xmlNodeList(call otto.FunctionCall, nodes []*xmlquery.Node) otto.Value {
+	list, _ := call.Otto.Object(`[]`)
+	for _, n := range nodes {
+		list.Call("push", newXMLNode(n))
+	}
+	return list.Value()
+}
+
+func xmlNodeValue(call otto.FunctionCall, n *xmlquery.Node) otto.Value {
+	if n == nil {
+		return otto.NullValue()
+	}
+	return toValue(call, newXMLNode(n))
+}
+
+func xmlQualifiedName(prefix, local string) string {
+	if prefix == "" {
+		return local
+	}
+	return prefix + ":" + local
+}
+
+// newXMLNode wraps n for JavaScript. select, selectOne, text and evaluate
+// take an XPath expression relative to the node.
+func newXMLNode(n *xmlquery.Node) xmlNode {
+	node := xmlNode{
+		"type":      xmlNodeTypes[n.Type],
+		"name":      xmlQualifiedName(n.Prefix, n.Data),
+		"localName": n.Data,
+		"namespace": n.NamespaceURI,
+	}
+	switch n.Type {
+	case xmlquery.DocumentNode:
+		node["name"], node["localName"] = "#document", "#document"
+	case xmlquery.TextNode, xmlquery.CharDataNode, xmlquery.CommentNode:
+		node["name"], node["localName"] = "#"+xmlNodeTypes[n.Type], "#"+xmlNodeTypes[n.Type]
+	}
+
+	node["select"] = func(call otto.FunctionCall) otto.Value {
+		return xmlNodeList(call, xmlquery.QuerySelectorAll(n, compileXPath(call, "select: ")))
+	}
+	node["selectOne"] = func(call otto.FunctionCall) otto.Value {
+		return xmlNodeValue(call, xmlquery.QuerySelector(n, compileXPath(call, "selectOne: ")))
+	}
+	node["text"] = func(call otto.FunctionCall) otto.Value {
+		if !call.Argument(0).IsDefined() {
+			return toValue(call, n.InnerText())
+		}
+		match := xmlquery.QuerySelector(n, compileXPath(call, "text: "))
+		if match == nil {
+			return otto.NullValue()
+		}
+		return toValue(call, match.InnerText())
+	}
+	node["evaluate"] = func(call otto.FunctionCall) otto.Value {
+		expr := compileXPath(call, "evaluate: ")
+		switch result := expr.Evaluate(xmlquery.CreateXPathNavigator(n)).(type) {
+		case *xpath.NodeIterator:
+			return xmlNodeList(call, xmlquery.QuerySelectorAll(n, expr))
+		default:
+			return toValue(call, result)
+		}
+	}
+	node["attr"] = func(call otto.FunctionCall) otto.Value {
+		name := call.Argument(0).String()
+		for _, attr := range n.Attr {
+			if attr.Name.Local == name || xmlQualifiedName(attr.Name.Space, attr.Name.Local) == name {
+				return toValue(call, attr.Value)
+			}
+		}
+		return otto.NullValue()
+	}
+	node["attrs"] = func(call otto.FunctionCall) otto.Value {
+		attrs, _ := call.Otto.Object(`({})`)
+		for _, attr := range n.Attr {
+			attrs.Set(xmlQualifiedName(attr.Name.Space, attr.Name.Local), attr.Value)
+		}
+		return attrs.Value()
+	}
+	node["children"] = func(call otto.FunctionCall) otto.Value {
+		var children []*xmlquery.Node
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type == xmlquery.ElementNode {
+				children = append(children, child)
+			}
+		}
+		return xmlNodeList(call, children)
+	}
+	node["parent"] = func(call otto.FunctionCall) otto.Value {
+		return xmlNodeValue(call, n.Parent)
+	}
+	node["xml"] = func(call otto.FunctionCall) otto.Value {
+		return toValue(call, n.OutputXML(n.Type != xmlquery.DocumentNode))
+	}
+	return node
+}
+
+// xmlResponseType is the content type xml functions answer with: the
+// request's own when it sent XML, so SOAP 1.1 clients get text/xml back,
+// and application/xml otherwise.
+func xmlResponseType(requestData map[string]interface{}) string {
+	mediaType, _, _ := mime.ParseMediaType(stringMap(requestData["headers"])["Content-Type"])
+	switch {
+	case mediaType == "text/xml", mediaType == "application/xml", strings.HasSuffix(mediaType, "+xml"):
+		return mediaType + "; charset=utf-8"
+	}
+	return "application/xml; charset=utf-8"
+}
+
+func xmlErrorResponse(status int, contentType, message string) *HTTPResponse {
+	var body bytes.Buffer
+	body.WriteString(xml.Header + "<error>")
+	xml.EscapeText(&body, []byte(message))
+	body.WriteString("</error>\n")
+	return &HTTPResponse{Status: status, Headers: map[string]string{"Content-Type": contentType}, Body: body.String()}
+}
+
+// invokeXMLHandler runs an xml function. Routers work as in standard
+// mode, with XML as the default content type. A GET, POST, ... or default
+// handler's string result is sent as the XML document, and an object is
+// serialized as runbox.stringify.xml does.
+func invokeXMLHandler(vm *otto.Otto, exec *execution) (interface{}, error) {
+	contentType := xmlResponseType(exec.request)
+	if result, handled, err := invokeRouter(vm, exec); handled {
+		if resp, ok := result.(*HTTPResponse); ok {
+			typed := false
+			for name := range resp.Headers {
+				typed = typed || strings.EqualFold(name, "Content-Type")
+			}
+			if !typed {
+				resp.Headers["Content-Type"] = contentType
+			}
+		}
+		return result, err
+	}
+
+	name := strings.ToUpper(fmt.Sprint(exec.request["method"]))
+	handler, _ := vm.Get(name)
+	if !handler.IsFunction() {
+		name = "default"
+		handler, _ = vm.Get(name)
+	}
+	if !handler.IsFunction() {
+		method := strings.ToUpper(fmt.Sprint(exec.request["method"]))
+		return xmlErrorResponse(http.StatusMethodNotAllowed, contentType,
+			fmt.Sprintf("No handler found for method %s. Please define a %s function or a default function.", method, method)), nil
+	}
+
+	result, err := handler.Call(otto.UndefinedValue(), exec.request)
+	if err != nil {
+		return nil, fmt.Errorf("error calling %s handler: %w", name, err)
+	}
+	if !result.IsDefined() || result.IsNull() {
+		return &HTTPResponse{Status: http.StatusNoContent, Headers: map[string]string{}}, nil
+	}
+	body := result.String()
+	if result.IsObject() {
+		if body, err = stringifyXML(result); err != nil {
+			return nil, fmt.Errorf("failed to serialize the result as XML: %v", err)
+		}
+	}
+	return &HTTPResponse{Status: http.StatusOK, Headers: map[string]string{"Content-Type": contentType}, Body: body}, nil
+}